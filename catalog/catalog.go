@@ -3,8 +3,9 @@ package catalog
 import (
 	"encoding/json"
 	"fmt"
-	"math/rand"
+	"maps"
 	"slices"
+	"strings"
 )
 
 // CT prefixed types correspond to cdb types and serve as the ID in CdbType. The
@@ -27,8 +28,6 @@ type CdbType struct {
 	VarPosition int
 }
 
-// TODO remove early exits to each function and blend cdb_schema as any other
-// object.
 // TODO need to look at encapsulation.
 
 // Catalog holds information about the database schema
@@ -38,22 +37,50 @@ type Catalog struct {
 	// Statements being run by the virtual machine will have their version
 	// checked with current catalog when the executing statement acquires it's
 	// file lock. If the version is out of date the statement will roll back,
-	// be recompiled, and be re-executed.
+	// be recompiled, and be re-executed. The version is made from cookie and
+	// discarded.
 	version string
+	// cookie is the schema cookie of the database file the schema was read
+	// with. Other processes change the cookie when they change the schema so
+	// the schema only needs to be read again when the cookie changes.
+	cookie int
+	// discarded is the number of schema changes discarded by RollbackTo. A
+	// discarded change may have had the cookie a later change is given, so it
+	// is part of the version to keep the versions of the two apart.
+	discarded int
+	// generations are a number for each table that changes when the table or
+	// one of its indexes changes. Statements that only depend on a few tables
+	// check these instead of version so unrelated schema changes do not force
+	// them to recompile.
+	generations map[string]int
+	// generation is the most recent generation given to a table. Generations
+	// only increase so a table that is dropped and created again never has the
+	// generation of the table it replaced.
+	generation int
+	// savepoints are the states the catalog can be rolled back to with the
+	// most recent last.
+	savepoints []savepoint
+}
+
+// savepoint is the state of the catalog when a savepoint was made.
+type savepoint struct {
+	objects     []Object
+	stats       []Stat
+	version     string
+	cookie      int
+	generations map[string]int
 }
 
 func NewCatalog() *Catalog {
 	c := &Catalog{
-		schema: &schema{},
+		schema:      &schema{objects: []Object{schemaTableObject()}},
+		generations: map[string]int{},
 	}
-	c.setNewVersion()
+	c.setVersion()
 	return c
 }
 
 func (c *Catalog) GetRootPageNumber(tableOrIndexName string) (int, error) {
-	if tableOrIndexName == "cdb_schema" {
-		return 1, nil
-	}
 	for _, o := range c.schema.objects {
 		if o.Name == tableOrIndexName {
 			return o.RootPageNumber, nil
@@ -63,9 +90,6 @@ func (c *Catalog) GetRootPageNumber(tableOrIndexName string) (int, error) {
 }
 
 func (c *Catalog) GetColumns(tableName string) ([]string, error) {
-	if tableName == "cdb_schema" {
-		return []string{"id", "type", "name", "table_name", "rootpage", "sql"}, nil
-	}
 	for _, o := range c.schema.objects {
 		if o.Name == tableName && o.TableName == tableName {
 			ts := TableSchemaFromString(o.JsonSchema)
@@ -80,9 +104,6 @@ func (c *Catalog) GetColumns(tableName string) ([]string, error) {
 }
 
 func (c *Catalog) GetPrimaryKeyColumn(tableName string) (string, error) {
-	if tableName == "cdb_schema" {
-		return "id", nil
-	}
 	for _, o := range c.schema.objects {
 		if o.Name == tableName && o.TableName == tableName {
 			ts := TableSchemaFromString(o.JsonSchema)
@@ -99,33 +120,78 @@ func (c *Catalog) GetPrimaryKeyColumn(tableName string) (string, error) {
 }
 
 func (c *Catalog) TableExists(tableName string) bool {
-	if tableName == "cdb_schema" {
-		return true
-	}
 	return slices.ContainsFunc(c.schema.objects, func(o Object) bool {
 		return o.ObjectType == "table" && o.TableName == tableName
 	})
 }
 
-func (c *Catalog) GetColumnType(tableName string, columnName string) (CdbType, error) {
-	if tableName == "cdb_schema" {
-		switch columnName {
-		case "id":
-			return CdbType{ID: CTInt}, nil
-		case "type":
-			return CdbType{ID: CTStr}, nil
-		case "name":
-			return CdbType{ID: CTStr}, nil
-		case "table_name":
-			return CdbType{ID: CTStr}, nil
-		case "rootpage":
-			return CdbType{ID: CTInt}, nil
-		case "sql":
-			return CdbType{ID: CTStr}, nil
+// IndexExists returns true when there is an index named indexName.
+func (c *Catalog) IndexExists(indexName string) bool {
+	return slices.ContainsFunc(c.schema.objects, func(o Object) bool {
+		return o.ObjectType == "index" && o.Name == indexName
+	})
+}
+
+// GetIndexes returns the indexes on tableName in the order they were created.
+func (c *Catalog) GetIndexes(tableName string) []Index {
+	indexes := []Index{}
+	for _, o := range c.schema.objects {
+		if o.ObjectType == "index" && o.TableName == tableName {
+			is := IndexSchemaFromString(o.JsonSchema)
+			indexes = append(indexes, Index{
+				Name:           o.Name,
+				TableName:      o.TableName,
+				RootPageNumber: o.RootPageNumber,
+				Columns:        is.Columns,
+				Include:        is.Include,
+				Unique:         is.Unique,
+			})
 		}
-		return CdbType{ID: CTUnknown}, fmt.Errorf("no type for table %s col %s", tableName, columnName)
 	}
+	return indexes
+}
+
+// SchemaOrder is the order GetObjects lists the objects of the schema in.
+type SchemaOrder int
 
+const (
+	// SchemaOrderCreated lists objects in the order they were created which is
+	// the order of their rows in cdb_schema.
+	SchemaOrderCreated SchemaOrder = iota
+	// SchemaOrderName lists objects by type and then by name. Two databases
+	// with the same objects list them in the same order no matter what order
+	// the objects were created in.
+	SchemaOrderName
+)
+
+// GetObjects returns every object in the schema excluding cdb_schema in order.
+func (c *Catalog) GetObjects(order SchemaOrder) []Object {
+	objects := slices.Clone(c.schema.objects[1:])
+	if order == SchemaOrderName {
+		slices.SortStableFunc(objects, func(a, b Object) int {
+			if a.ObjectType != b.ObjectType {
+				return strings.Compare(a.ObjectType, b.ObjectType)
+			}
+			return strings.Compare(a.Name, b.Name)
+		})
+	}
+	return objects
+}
+
+// GetTableNames returns the name of every table including cdb_schema sorted by
+// name.
+func (c *Catalog) GetTableNames() []string {
+	names := []string{}
+	for _, o := range c.schema.objects {
+		if o.ObjectType == "table" {
+			names = append(names, o.Name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+func (c *Catalog) GetColumnType(tableName string, columnName string) (CdbType, error) {
 	for _, o := range c.schema.objects {
 		if o.Name == tableName && o.TableName == tableName {
 			ts := TableSchemaFromString(o.JsonSchema)
@@ -146,30 +212,195 @@ func (c *Catalog) GetColumnType(tableName string, columnName string) (CdbType, e
 	return CdbType{ID: CTUnknown}, fmt.Errorf("no type for table %s col %s", tableName, columnName)
 }
 
+// IsAutoIncrement returns true when the primary key of tableName is
+// AUTOINCREMENT.
+func (c *Catalog) IsAutoIncrement(tableName string) bool {
+	for _, o := range c.schema.objects {
+		if o.Name == tableName && o.TableName == tableName {
+			ts := TableSchemaFromString(o.JsonSchema)
+			return slices.ContainsFunc(ts.Columns, func(col TableColumn) bool {
+				return col.PrimaryKey && col.AutoIncrement
+			})
+		}
+	}
+	return false
+}
+
+// GetColumnDefault returns the function computing the default value of
+// columnName such as CURRENT_TIMESTAMP. The default is empty when the column
+// has no default.
+func (c *Catalog) GetColumnDefault(tableName string, columnName string) (string, error) {
+	for _, o := range c.schema.objects {
+		if o.Name == tableName && o.TableName == tableName {
+			ts := TableSchemaFromString(o.JsonSchema)
+			for _, col := range ts.Columns {
+				if col.Name == columnName {
+					return col.Default, nil
+				}
+			}
+		}
+	}
+	return "", fmt.Errorf("no column %s for table %s", columnName, tableName)
+}
+
 // GetVersion returns a unique version identifier that is updated when the
 // catalog is updated.
 func (c *Catalog) GetVersion() string {
 	return c.version
 }
 
-func (c *Catalog) SetSchema(o []Object) {
-	c.schema.objects = o
-	c.setNewVersion()
+// GetTableStat returns the statistics gathered by ANALYZE for tableName. ok is
+// false when the table has not been analyzed.
+func (c *Catalog) GetTableStat(tableName string) (stat Stat, ok bool) {
+	for _, s := range c.schema.stats {
+		if s.TableName == tableName && s.IndexName == "" {
+			return s, true
+		}
+	}
+	return Stat{}, false
 }
 
-func (c *Catalog) setNewVersion() {
-	chars := "abcdefghijklmnopqrstuvwxyz"
-	v := make([]byte, 16)
-	for i := range v {
-		v[i] = chars[rand.Intn(len(chars))]
+// GetIndexStat returns the statistics gathered by ANALYZE for indexName. ok is
+// false when the index has not been analyzed.
+func (c *Catalog) GetIndexStat(indexName string) (stat Stat, ok bool) {
+	for _, s := range c.schema.stats {
+		if s.IndexName == indexName {
+			return s, true
+		}
 	}
-	c.version = string(v)
+	return Stat{}, false
+}
+
+// GetCookie returns the schema cookie the schema was read with.
+func (c *Catalog) GetCookie() int {
+	return c.cookie
+}
+
+// GetGeneration returns a number identifying the current definition of
+// tableName and its indexes. The generation changes when the table or one of
+// its indexes is created, changed or dropped. cdb_schema is always generation 0
+// since it cannot change.
+func (c *Catalog) GetGeneration(tableName string) int {
+	return c.generations[tableName]
+}
+
+// SetSchema replaces the objects of the schema with o which are the rows of
+// cdb_schema and the statistics with stats which are the rows of cdb_stat read
+// with the schema cookie cookie. cdb_schema itself is not a row of cdb_schema so
+// it is kept.
+func (c *Catalog) SetSchema(o []Object, stats []Stat, cookie int) {
+	previous := c.schema.objects
+	previousStats := c.schema.stats
+	c.schema.objects = append([]Object{schemaTableObject()}, o...)
+	c.schema.stats = stats
+	c.cookie = cookie
+	c.setVersion()
+	c.setNewGenerations(previous, previousStats)
+}
+
+// IsSchema returns true when o, which are rows of cdb_schema, are the objects
+// of the schema and stats, which are rows of cdb_stat, are its statistics.
+func (c *Catalog) IsSchema(o []Object, stats []Stat) bool {
+	return slices.Equal(c.schema.objects[1:], o) && slices.Equal(c.schema.stats, stats)
+}
+
+// setNewGenerations gives a new generation to every table with objects or
+// statistics that differ from the objects in previous or the statistics in
+// previousStats. A statement planned with the statistics of a table may not
+// be planned the same with new statistics.
+func (c *Catalog) setNewGenerations(previous []Object, previousStats []Stat) {
+	before := objectsByTable(previous)
+	after := objectsByTable(c.schema.objects)
+	statsBefore := statsByTable(previousStats)
+	statsAfter := statsByTable(c.schema.stats)
+	for tableName, objects := range after {
+		if !slices.Equal(objects, before[tableName]) || !slices.Equal(statsAfter[tableName], statsBefore[tableName]) {
+			c.generation += 1
+			c.generations[tableName] = c.generation
+		}
+	}
+	for tableName := range before {
+		if _, ok := after[tableName]; !ok {
+			c.generation += 1
+			c.generations[tableName] = c.generation
+		}
+	}
+}
+
+// statsByTable groups stats by the table they are for.
+func statsByTable(stats []Stat) map[string][]Stat {
+	tables := map[string][]Stat{}
+	for _, s := range stats {
+		tables[s.TableName] = append(tables[s.TableName], s)
+	}
+	return tables
+}
+
+// objectsByTable groups objects by the table they are associated with.
+func objectsByTable(objects []Object) map[string][]Object {
+	tables := map[string][]Object{}
+	for _, o := range objects {
+		tables[o.TableName] = append(tables[o.TableName], o)
+	}
+	return tables
+}
+
+// Savepoint records the schema, version and generations so they can be restored
+// with RollbackTo when the changes made after the savepoint are discarded. For
+// example a table created by a write transaction that is rolled back must not
+// remain in the catalog. Savepoints nest and are released or rolled back in
+// the reverse order they were made.
+func (c *Catalog) Savepoint() {
+	c.savepoints = append(c.savepoints, savepoint{
+		objects:     slices.Clone(c.schema.objects),
+		stats:       c.schema.stats,
+		version:     c.version,
+		cookie:      c.cookie,
+		generations: maps.Clone(c.generations),
+	})
+}
+
+// Release forgets the most recent savepoint keeping the changes made after it.
+// Release is a no-op when there are no savepoints.
+func (c *Catalog) Release() {
+	if len(c.savepoints) == 0 {
+		return
+	}
+	c.savepoints = c.savepoints[:len(c.savepoints)-1]
+}
+
+// RollbackTo restores the schema, version and generations of the most recent
+// savepoint and forgets it. Restoring the version means statements prepared before the
+// savepoint remain valid. RollbackTo is a no-op when there are no savepoints.
+func (c *Catalog) RollbackTo() {
+	if len(c.savepoints) == 0 {
+		return
+	}
+	sp := c.savepoints[len(c.savepoints)-1]
+	c.savepoints = c.savepoints[:len(c.savepoints)-1]
+	if c.version != sp.version {
+		c.discarded += 1
+	}
+	c.schema.objects = sp.objects
+	c.schema.stats = sp.stats
+	c.version = sp.version
+	c.cookie = sp.cookie
+	c.generations = sp.generations
+}
+
+// setVersion sets the version for the cookie.
+func (c *Catalog) setVersion() {
+	c.version = fmt.Sprintf("%d.%d", c.cookie, c.discarded)
 }
 
 // schema is a cached representation of the database schema
 type schema struct {
-	// objects are a in memory representation of the schema table
+	// objects are a in memory representation of the schema table. The first
+	// object is always cdb_schema so it is found like any other table.
 	objects []Object
+	// stats are a in memory representation of cdb_stat. They are empty when
+	// nothing has been analyzed.
+	stats []Stat
 }
 
 type Object struct {
@@ -183,6 +414,10 @@ type Object struct {
 	RootPageNumber int `json:"rootPageNumber"`
 	// JsonSchema is different for each object. For a table it is tableSchema
 	JsonSchema string `json:"jsonSchema"`
+	// Modified is the UTC time the object was last changed formatted as
+	// YYYY-MM-DD HH:MM:SS. Modified is empty for objects created before the
+	// time was recorded.
+	Modified string `json:"modified"`
 }
 
 type TableSchema struct {
@@ -193,6 +428,98 @@ type TableColumn struct {
 	Name       string `json:"name"`
 	ColType    string `json:"type"`
 	PrimaryKey bool   `json:"primaryKey"`
+	// Default is the function computing the value of the column when it is
+	// not given by an insert. Default is empty when there is no default.
+	Default string `json:"default,omitempty"`
+	// AutoIncrement is true for a primary key whose row ids are never reused.
+	// The largest row id the table has ever had is kept in cdb_sequence.
+	AutoIncrement bool `json:"autoIncrement,omitempty"`
+}
+
+// SchemaTableName is the name of the table holding a row for each object of the
+// schema other than itself. Its root page is always page 1.
+const SchemaTableName = "cdb_schema"
+
+// SchemaTableSchema returns the schema of cdb_schema. sql is the JsonSchema of
+// the object and modified is the time it was last changed.
+func SchemaTableSchema() *TableSchema {
+	return &TableSchema{
+		Columns: []TableColumn{
+			{Name: "id", ColType: "INTEGER", PrimaryKey: true},
+			{Name: "type", ColType: "TEXT"},
+			{Name: "name", ColType: "TEXT"},
+			{Name: "table_name", ColType: "TEXT"},
+			{Name: "rootpage", ColType: "INTEGER"},
+			{Name: "sql", ColType: "TEXT"},
+			{Name: "modified", ColType: "TEXT"},
+		},
+	}
+}
+
+// schemaTableObject returns the object describing cdb_schema.
+func schemaTableObject() Object {
+	j, _ := SchemaTableSchema().ToJSON()
+	return Object{
+		ObjectType:     "table",
+		Name:           SchemaTableName,
+		TableName:      SchemaTableName,
+		RootPageNumber: 1,
+		JsonSchema:     string(j),
+	}
+}
+
+// SequenceTableName is the name of the table holding the largest row id ever
+// used by each AUTOINCREMENT table. It is created along with the first
+// AUTOINCREMENT table.
+const SequenceTableName = "cdb_sequence"
+
+// SequenceTableSchema returns the schema of cdb_sequence. The row id of each
+// row is the root page number of the table the row is for since it never
+// changes. name is the name of the table and seq is its largest row id.
+func SequenceTableSchema() *TableSchema {
+	return &TableSchema{
+		Columns: []TableColumn{
+			{Name: "id", ColType: "INTEGER", PrimaryKey: true},
+			{Name: "name", ColType: "TEXT"},
+			{Name: "seq", ColType: "INTEGER"},
+		},
+	}
+}
+
+// StatTableName is the name of the table holding the statistics gathered by
+// ANALYZE. It is created by the first ANALYZE.
+const StatTableName = "cdb_stat"
+
+// StatTableSchema returns the schema of cdb_stat. There is a row for each
+// analyzed table where index_name is NULL and row_count is the number of rows
+// in the table. There is also a row for each index of the table where
+// row_count is the number of entries in the index and distinct_count is the
+// number of distinct values among them. NULL values are never equal so each
+// is counted as distinct.
+func StatTableSchema() *TableSchema {
+	return &TableSchema{
+		Columns: []TableColumn{
+			{Name: "id", ColType: "INTEGER", PrimaryKey: true},
+			{Name: "table_name", ColType: "TEXT"},
+			{Name: "index_name", ColType: "TEXT"},
+			{Name: "row_count", ColType: "INTEGER"},
+			{Name: "distinct_count", ColType: "INTEGER"},
+		},
+	}
+}
+
+// Stat is a row of cdb_stat. It is the statistics of a table or one of its
+// indexes. See StatTableSchema.
+type Stat struct {
+	TableName string
+	// IndexName is the name of the index the statistics are for. IndexName is
+	// empty for the statistics of the table.
+	IndexName string
+	// RowCount is the number of rows in the table or entries in the index.
+	RowCount int
+	// DistinctCount is the number of distinct values in the index. It is 0 for
+	// the statistics of the table.
+	DistinctCount int
 }
 
 func (ts *TableSchema) ToJSON() ([]byte, error) {
@@ -212,3 +539,35 @@ func TableSchemaFromString(s string) *TableSchema {
 	json.Unmarshal([]byte(s), &v)
 	return v
 }
+
+// Index describes a secondary index on a table.
+type Index struct {
+	Name           string
+	TableName      string
+	RootPageNumber int
+	// Columns are the names of the indexed columns.
+	Columns []string
+	// Include are the names of the columns whose values are stored in the
+	// entries of the index without being indexed.
+	Include []string
+	// Unique is true when no two rows may have equal values in the indexed
+	// columns.
+	Unique bool
+}
+
+// IndexSchema is the JsonSchema of an index object.
+type IndexSchema struct {
+	Columns []string `json:"columns"`
+	Include []string `json:"include,omitempty"`
+	Unique  bool     `json:"unique,omitempty"`
+}
+
+func (is *IndexSchema) ToJSON() ([]byte, error) {
+	return json.Marshal(is)
+}
+
+func IndexSchemaFromString(s string) *IndexSchema {
+	v := &IndexSchema{}
+	json.Unmarshal([]byte(s), &v)
+	return v
+}
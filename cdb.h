@@ -77,33 +77,67 @@ extern "C" {
 
 // cdb_new_db opens a database with the given filename. A filename of ":memory:"
 // will open a database that does not persist data after it is closed. A non
-// zero int is returned in case an error occurs. The database can be closed with
+// zero int is returned in case an error occurs. Statements are prepared with a
+// connection opened by cdb_open_conn. The database can be closed with
 // cdb_close_db.
 //
 extern int cdb_new_db(char* filename);
 
-// cdb_close_db closes the database with the given filename.
+// cdb_close_db closes the database with the given filename. Connections that
+// are already open remain usable until they are closed with cdb_close_conn.
 //
 extern void cdb_close_db(char* filename);
 
+// cdb_open_conn opens a connection to the database with the given filename and
+// puts its handle in connId. Each connection has its own transaction and may be
+// used by one thread at a time so every thread should open its own. A non zero
+// int is returned if the database is not open. The connection must be closed
+// with cdb_close_conn.
+//
+extern int cdb_open_conn(int* connId, char* filename);
+
+// cdb_close_conn closes the connection with the given connId. A transaction in
+// progress is rolled back.
+//
+extern void cdb_close_conn(int connId);
+
+// cdb_begin begins a transaction for the connection with the given connId.
+// Every statement prepared with the connection is part of the transaction until
+// cdb_commit or cdb_rollback. When readOnly is not 0 a statement that writes
+// has an error. A non zero int is returned if the connection is not open or
+// already has a transaction in progress.
+//
+extern int cdb_begin(int connId, int readOnly);
+
+// cdb_commit commits the transaction of the connection with the given connId.
+// A non zero int is returned if there is no transaction in progress or the
+// commit fails in which case the transaction is rolled back.
+//
+extern int cdb_commit(int connId);
+
+// cdb_rollback rolls back the transaction of the connection with the given
+// connId. A non zero int is returned if there is no transaction in progress.
+//
+extern int cdb_rollback(int connId);
+
 // cdb_prepare prepares a statement that can be bound and executed for the given
-// filename and sql. The prepareId is a handle used for further operations on
-// the prepared statement. Note the prepared statement must be cleaned up with
+// connId and sql. The prepareId is a handle used for further operations on the
+// prepared statement. Note the prepared statement must be cleaned up with
 // cdb_close_statement.
 //
 // If an error is encountered during prepare err code 2 is returned and the
 // error message is written to prepareErr.
 //
-extern int cdb_prepare(int* prepareId, char* filename, char* sql, char** prepareErr);
+extern int cdb_prepare(int* prepareId, int connId, char* sql, char** prepareErr);
 
 // cdb_close_statement cleans up a prepared statement.
 //
 extern void cdb_close_statement(int prepareId);
 
-// cdb_bind_int binds an int as the next available argument for the given
+// cdb_bind_int binds a 64 bit int as the next available argument for the given
 // prepared statement.
 //
-extern int cdb_bind_int(int prepareId, int bound);
+extern int cdb_bind_int(int prepareId, long long int bound);
 
 // cdb_bind_string binds a string as the next available argument for the given
 // prepared statement.
@@ -114,26 +148,64 @@ extern int cdb_bind_string(int prepareId, char* bound);
 //
 extern int cdb_execute(int prepareId);
 
+// cdb_execute_many evaluates the given prepared statement once for each row of
+// bound arguments within a single transaction. The bound arguments are split
+// into rows of paramsPerRow arguments in the order they were bound. If any row
+// fails none of the rows are committed. 1 is returned if the bound arguments
+// cannot be split into rows of paramsPerRow.
+//
+extern int cdb_execute_many(int prepareId, int paramsPerRow);
+
+// cdb_interrupt stops every statement currently executing on the database with
+// the given filename. An interrupted statement has the error "interrupted". A
+// non zero int is returned if the database is not open.
+//
+extern int cdb_interrupt(char* filename);
+
 // cdb_result_err puts 1 in hasError when the statement has an error. The error
 // message is put in errMessage.
 //
 extern int cdb_result_err(int prepareId, int* hasError, char** errMessage);
 
+// cdb_result_rows_affected puts the number of rows inserted, updated or deleted
+// by the statement in result.
+//
+extern int cdb_result_rows_affected(int prepareId, long long int* result);
+
 // cdb_result_row moves a cursor to the next row. If there is no row
 // cdb_result_row will put 1 into hasRow otherwise 0.
 //
 extern int cdb_result_row(int prepareId, int* hasRow);
 
-// cdb_result_col_int puts the int for the current row at the 0 based column
-// index for the result param.
+// cdb_result_col_int puts the 64 bit int for the current row at the 0 based
+// column index for the result param. 1 is returned if the column is not an
+// int.
 //
-extern int cdb_result_col_int(int prepareId, int colIdx, int* result);
+extern int cdb_result_col_int(int prepareId, int colIdx, long long int* result);
 
 // cdb_result_col_string puts the string for the current row at the 0 based
 // column index into the result param.
 //
 extern int cdb_result_col_string(int prepareId, int colIdx, char** result);
 
+// cdb_result_col_float puts the 64 bit float for the current row at the 0 based
+// column index for the result param. An int column is converted to a float. 1
+// is returned if the column is not a number.
+//
+extern int cdb_result_col_float(int prepareId, int colIdx, double* result);
+
+// cdb_result_col_blob puts the bytes for the current row at the 0 based column
+// index into the result param and the count of bytes into length. A column that
+// is not a blob is the bytes of its text. result is NULL when the column is
+// NULL.
+//
+extern int cdb_result_col_blob(int prepareId, int colIdx, void** result, int* length);
+
+// cdb_result_col_is_null puts 1 in isNull when the current row at the 0 based
+// column index is NULL otherwise 0.
+//
+extern int cdb_result_col_is_null(int prepareId, int colIdx, int* isNull);
+
 // cdb_result_col_count puts the count of result columns in result for the given
 // prepareId.
 //
@@ -159,6 +231,25 @@ extern int cdb_result_col_name(int prepareId, int colIdx, char** result);
 //
 extern int cdb_result_col_type(int prepareId, int colIdx, int* result);
 
+// cdb_result_col_database_name puts the name of the database the result column
+// is taken from in result for the given prepareId and colIdx. result is NULL
+// when the result column is not a table column.
+//
+extern int cdb_result_col_database_name(int prepareId, int colIdx, char** result);
+
+// cdb_result_col_table_name puts the name of the table the result column is
+// taken from in result for the given prepareId and colIdx. result is NULL when
+// the result column is not a table column.
+//
+extern int cdb_result_col_table_name(int prepareId, int colIdx, char** result);
+
+// cdb_result_col_origin_name puts the name of the table column the result
+// column is taken from in result for the given prepareId and colIdx. Unlike
+// cdb_result_col_name the origin name is not affected by an alias. result is
+// NULL when the result column is not a table column.
+//
+extern int cdb_result_col_origin_name(int prepareId, int colIdx, char** result);
+
 // cdb_statement_type is the type of statement e.g. SELECT CREATE INSERT
 //
 extern int cdb_statement_type(int prepareId, int* result);
@@ -2,6 +2,7 @@ package compiler
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/chirst/cdb/catalog"
 )
@@ -22,6 +23,37 @@ type SelectStmt struct {
 	From          *From
 	ResultColumns []ResultColumn
 	Where         Expr
+	// GroupBy is the expressions of the GROUP BY clause.
+	GroupBy []Expr
+	// OrderBy is the terms of the ORDER BY clause in order of precedence.
+	OrderBy []OrderingTerm
+	// With is the common table expressions of the WITH clause preceding the
+	// select. The FROM clause may name one of them instead of a table.
+	With []CommonTableExpr
+}
+
+// CommonTableExpr is a named select of a WITH clause for example foo in WITH
+// foo AS (SELECT 1) SELECT * FROM foo.
+type CommonTableExpr struct {
+	Name string
+	// Columns are the optional names of the columns for example a and b in
+	// WITH foo (a, b) AS (SELECT 1, 2). Columns is nil when the names are
+	// taken from the select.
+	Columns []string
+	// Materialized is true for AS MATERIALIZED meaning the rows of the select
+	// are computed once and reused by every reference to Name.
+	Materialized bool
+	Select       *SelectStmt
+	// SQL is the text of Select. Selects with the same text have the same rows
+	// within a transaction.
+	SQL string
+}
+
+// OrderingTerm is a term in an ORDER BY clause for example name DESC.
+type OrderingTerm struct {
+	Expr Expr
+	// Desc is true when the term is sorted in descending order.
+	Desc bool
 }
 
 // ResultColumn is the column definitions in a select statement.
@@ -37,7 +69,33 @@ type ResultColumn struct {
 }
 
 type From struct {
-	TableName string
+	// SchemaName is the optional schema qualifying the table for example main
+	// in SELECT * FROM main.foo.
+	SchemaName string
+	TableName  string
+	// Args are the arguments to a table valued function for example the
+	// string in SELECT * FROM explain('SELECT 1'). Args is nil when TableName
+	// is a table rather than a function.
+	Args []Expr
+	// Joins are the tables joined to TableName in the order they are joined
+	// for example bar in SELECT * FROM foo JOIN bar ON foo.id = bar.foo_id.
+	Joins []Join
+}
+
+// Join is a table joined to the tables preceding it in a FROM clause.
+type Join struct {
+	// Left is true for LEFT JOIN meaning a row of the preceding tables with
+	// no matching row in the table is joined to a row where every column of
+	// the table is NULL.
+	Left bool
+	// SchemaName, TableName and Args are the same as those of From.
+	SchemaName string
+	TableName  string
+	Args       []Expr
+	// On is the expression a row of the table must match to be joined. On is
+	// nil for a comma, CROSS JOIN or JOIN without ON meaning every row is
+	// joined.
+	On Expr
 }
 
 type CreateStmt struct {
@@ -46,39 +104,141 @@ type CreateStmt struct {
 	// NOT EXISTS` meaning the statement should not throw if the table already
 	// exists.
 	IfNotExists bool
-	TableName   string
-	ColDefs     []ColDef
+	// SchemaName is the optional schema qualifying the table for example main
+	// in CREATE TABLE main.foo (id INTEGER).
+	SchemaName string
+	TableName  string
+	ColDefs    []ColDef
+}
+
+// CreateIndexStmt is a statement such as CREATE INDEX idx ON foo (bar) or
+// CREATE UNIQUE INDEX idx ON foo (bar).
+type CreateIndexStmt struct {
+	*StmtBase
+	// IfNotExists is true when the statement should not throw if the index
+	// already exists.
+	IfNotExists bool
+	// SchemaName is the optional schema qualifying the index for example main
+	// in CREATE INDEX main.idx ON foo (bar). The table is in the same schema
+	// as the index.
+	SchemaName string
+	IndexName  string
+	TableName  string
+	// ColumnName is the name of the indexed column.
+	ColumnName string
+	// Include are the names of the columns following INCLUDE. Their values
+	// are stored in the entries of the index but are not indexed.
+	Include []string
+	// Unique is true for CREATE UNIQUE INDEX meaning no two rows may have
+	// equal values in the indexed column.
+	Unique bool
 }
 
 type ColDef struct {
 	ColName    string
 	ColType    string
 	PrimaryKey bool
+	// AutoIncrement is true for PRIMARY KEY AUTOINCREMENT meaning row ids
+	// are never reused even after the row with the largest id is deleted.
+	AutoIncrement bool
+	// Default is the function computing the value of the column when an
+	// insert does not specify it. Default is empty when the column has no
+	// default. It is one of FnCurrentTimestamp or FnCurrentDate.
+	Default string
 }
 
 type InsertStmt struct {
 	*StmtBase
-	TableName string
-	ColNames  []string
+	// SchemaName is the optional schema qualifying the table for example main
+	// in INSERT INTO main.foo (id) VALUES (1).
+	SchemaName string
+	TableName  string
+	// ColNames are the columns of the column list. It is empty when the
+	// statement has no column list meaning the values are for every column in
+	// the order of the table.
+	ColNames []string
 	// ColValues is a 2d list where the first dimension represents a row and the
 	// second dimension represents a column value.
 	ColValues [][]Expr
+	// Conflict is how a row with the primary key of an existing row, or the
+	// value of a unique index of an existing row, is resolved. It is one of
+	// the Conflict constants.
+	Conflict string
+	// ConflictTarget is the column of ON CONFLICT (column). It is empty when
+	// there is no target.
+	ConflictTarget string
+	// ConflictSetList is a mapping of column names to the expressions the
+	// column of the existing row is updated to when Conflict is
+	// ConflictUpdate. The expressions may reference the row that was not
+	// inserted as excluded.column.
+	ConflictSetList map[string]Expr
 }
 
+// Conflict resolutions of an InsertStmt.
+const (
+	// ConflictAbort halts the statement with an error. It is the default.
+	ConflictAbort = ""
+	// ConflictIgnore skips the row being inserted. It is OR IGNORE or ON
+	// CONFLICT DO NOTHING.
+	ConflictIgnore = kwIgnore
+	// ConflictReplace deletes the existing rows before inserting. It is OR
+	// REPLACE.
+	ConflictReplace = kwReplace
+	// ConflictUpdate updates the existing row instead of inserting. It is ON
+	// CONFLICT DO UPDATE. It only resolves a conflict of the primary key.
+	ConflictUpdate = kwUpdate
+)
+
+// ExcludedTable is the table name of a column reference to the row that was
+// not inserted in the ConflictSetList of an InsertStmt.
+const ExcludedTable = "excluded"
+
 type UpdateStmt struct {
 	*StmtBase
-	TableName string
+	// SchemaName is the optional schema qualifying the table for example main
+	// in UPDATE main.foo SET id = 1.
+	SchemaName string
+	TableName  string
 	// SetList is a mapping of column names to the expressions the column should
 	// be updated to.
 	SetList map[string]Expr
 	// Predicate is the where clause. It may be nil when there is no where.
 	Predicate Expr
+	// Limit is the most rows that are updated. It is nil when there is no
+	// limit.
+	Limit Expr
 }
 
 type DeleteStmt struct {
 	*StmtBase
+	// SchemaName is the optional schema qualifying the table for example main
+	// in DELETE FROM main.foo.
+	SchemaName string
+	TableName  string
+	Predicate  Expr
+}
+
+// PragmaStmt reports a property of the database such as PRAGMA
+// transaction_state or sets it such as PRAGMA cache_size = 100.
+type PragmaStmt struct {
+	*StmtBase
+	// Name is the name of the pragma.
+	Name string
+	// Value is the value the pragma is set to. Value is nil when the pragma is
+	// only reported.
+	Value Expr
+}
+
+// AnalyzeStmt gathers the statistics of a table and its indexes such as
+// ANALYZE foo. Every table is analyzed by ANALYZE.
+type AnalyzeStmt struct {
+	*StmtBase
+	// SchemaName is the optional schema qualifying the table for example main
+	// in ANALYZE main.foo.
+	SchemaName string
+	// TableName is the table to analyze. TableName is empty when every table is
+	// analyzed.
 	TableName string
-	Predicate Expr
 }
 
 type ExprVisitor interface {
@@ -86,9 +246,12 @@ type ExprVisitor interface {
 	VisitUnaryExpr(*UnaryExpr)
 	VisitColumnRefExpr(*ColumnRef)
 	VisitIntLit(*IntLit)
+	VisitFloatLit(*FloatLit)
 	VisitStringLit(*StringLit)
+	VisitNullLit(*NullLit)
 	VisitVariable(*Variable)
 	VisitFunctionExpr(*FunctionExpr)
+	VisitCastExpr(*CastExpr)
 }
 
 // Expr defines the interface of an expression.
@@ -123,14 +286,27 @@ type UnaryExpr struct {
 	Operand  Expr
 }
 
-func (ue *UnaryExpr) Accept(v ExprVisitor) {
+func (ue *UnaryExpr) BreadthWalk(v ExprVisitor) {
 	v.VisitUnaryExpr(ue)
 	ue.Operand.BreadthWalk(v)
 }
 
+func (ue *UnaryExpr) Print() string {
+	switch ue.Operator {
+	case OpNot:
+		return fmt.Sprintf("%s %s", ue.Operator, ue.Operand.Print())
+	case OpNeg, OpBitNot:
+		return fmt.Sprintf("%s%s", ue.Operator, ue.Operand.Print())
+	}
+	return fmt.Sprintf("%s %s", ue.Operand.Print(), ue.Operator)
+}
+
 // ColumnRef is an expression with no operands. It references a column on a
 // table.
 type ColumnRef struct {
+	// Schema is the optional schema qualifying the table for example main in
+	// main.foo.id.
+	Schema string
 	Table  string
 	Column string
 	// Type is the type of the column
@@ -167,6 +343,20 @@ func (be *IntLit) Print() string {
 	return "?"
 }
 
+// FloatLit is an expression that is a literal real number such as "1.5" or
+// "2e3".
+type FloatLit struct {
+	Value float64
+}
+
+func (fl *FloatLit) BreadthWalk(v ExprVisitor) {
+	v.VisitFloatLit(fl)
+}
+
+func (fl *FloatLit) Print() string {
+	return "?"
+}
+
 // StringLit is an expression that is a literal string such as "'asdf'".
 type StringLit struct {
 	Value string
@@ -180,6 +370,17 @@ func (vi *StringLit) Print() string {
 	return "?"
 }
 
+// NullLit is an expression that is the literal NULL.
+type NullLit struct{}
+
+func (nl *NullLit) BreadthWalk(v ExprVisitor) {
+	v.VisitNullLit(nl)
+}
+
+func (nl *NullLit) Print() string {
+	return "NULL"
+}
+
 type Variable struct {
 	// Position is a unique integer defining what order the variable appeared in
 	// the statement.
@@ -199,17 +400,105 @@ type FunctionExpr struct {
 	// FnType corresponds to the type of function. For example fnCount is for
 	// COUNT(*)
 	FnType string
-	Args   []Expr
+	// Args are the arguments of the function. Args is nil for COUNT(*).
+	Args []Expr
 }
 
 const (
 	FnCount = "COUNT"
+	FnSum   = "SUM"
+	// FnMin is the aggregate MIN(x) or the scalar MIN(x, y, ...) which is the
+	// least argument.
+	FnMin = "MIN"
+	// FnMax is the aggregate MAX(x) or the scalar MAX(x, y, ...) which is the
+	// greatest argument.
+	FnMax = "MAX"
+	// FnTypeof is typeof(expr) which is the name of the type of expr.
+	FnTypeof = "TYPEOF"
+	// FnCurrentTimestamp is CURRENT_TIMESTAMP which is the UTC date and time
+	// the statement is executed as YYYY-MM-DD HH:MM:SS.
+	FnCurrentTimestamp = "CURRENT_TIMESTAMP"
+	// FnCurrentDate is CURRENT_DATE which is the UTC date the statement is
+	// executed as YYYY-MM-DD.
+	FnCurrentDate = "CURRENT_DATE"
+	// FnUpper is UPPER(x) which is x in upper case.
+	FnUpper = "UPPER"
+	// FnLower is LOWER(x) which is x in lower case.
+	FnLower = "LOWER"
+	// FnLength is LENGTH(x) which is the number of characters in x.
+	FnLength = "LENGTH"
+	// FnSubstr is SUBSTR(x, start[, length]) which is the characters of x
+	// beginning at the 1 based position start.
+	FnSubstr = "SUBSTR"
+	// FnTrim is TRIM(x[, characters]) which is x without characters, or spaces,
+	// at its beginning and end.
+	FnTrim = "TRIM"
+	// FnAbs is ABS(x) which is the absolute value of x.
+	FnAbs = "ABS"
+	// FnRound is ROUND(x[, digits]) which is x rounded to digits decimal
+	// places.
+	FnRound = "ROUND"
+	// FnRandom is RANDOM() which is a pseudo random integer.
+	FnRandom = "RANDOM"
+	// FnCoalesce is COALESCE(x, y, ...) which is the first argument that is
+	// not NULL.
+	FnCoalesce = "COALESCE"
+	// FnIfnull is IFNULL(x, y) which is y when x is NULL and x otherwise.
+	FnIfnull = "IFNULL"
+	// FnDate is DATE(time value, modifiers...) which is the date as
+	// YYYY-MM-DD.
+	FnDate = "DATE"
+	// FnTime is TIME(time value, modifiers...) which is the time as HH:MM:SS.
+	FnTime = "TIME"
+	// FnDatetime is DATETIME(time value, modifiers...) which is the date and
+	// time as YYYY-MM-DD HH:MM:SS.
+	FnDatetime = "DATETIME"
+	// FnStrftime is STRFTIME(format, time value, modifiers...) which is the
+	// date and time formatted with format.
+	FnStrftime = "STRFTIME"
 )
 
+// IsTimeFunction returns true when fnType is a function that is written
+// without parentheses such as CURRENT_TIMESTAMP.
+func IsTimeFunction(fnType string) bool {
+	return fnType == FnCurrentTimestamp || fnType == FnCurrentDate
+}
+
 func (f *FunctionExpr) BreadthWalk(v ExprVisitor) {
 	v.VisitFunctionExpr(f)
+	for _, arg := range f.Args {
+		arg.BreadthWalk(v)
+	}
 }
 
 func (f *FunctionExpr) Print() string {
-	return f.FnType
+	if IsTimeFunction(f.FnType) {
+		return f.FnType
+	}
+	if f.Args == nil {
+		return f.FnType + "(*)"
+	}
+	args := []string{}
+	for _, arg := range f.Args {
+		args = append(args, arg.Print())
+	}
+	return f.FnType + "(" + strings.Join(args, ", ") + ")"
+}
+
+// CastExpr is an expression that converts the type of Expr for example
+// CAST('1' AS INTEGER).
+type CastExpr struct {
+	Expr Expr
+	// TypeName is the name of the type Expr is converted to for example
+	// INTEGER.
+	TypeName string
+}
+
+func (c *CastExpr) BreadthWalk(v ExprVisitor) {
+	v.VisitCastExpr(c)
+	c.Expr.BreadthWalk(v)
+}
+
+func (c *CastExpr) Print() string {
+	return fmt.Sprintf("CAST(%s AS %s)", c.Expr.Print(), c.TypeName)
 }
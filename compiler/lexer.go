@@ -46,6 +46,9 @@ const (
 	// tkComment is either a line or block comment. The value contains the
 	// comment including the leading "--" or leading "/*" and trailing "*/".
 	tkComment
+	// tkUnterminated is a quoted value missing its closing quote like 'foo.
+	// The value is the text following the opening quote.
+	tkUnterminated
 )
 
 // Keywords where kw is keyword
@@ -73,6 +76,51 @@ const (
 	kwUpdate  = "UPDATE"
 	kwSet     = "SET"
 	kwDelete  = "DELETE"
+	kwOrder   = "ORDER"
+	kwGroup   = "GROUP"
+	kwBy      = "BY"
+	kwAsc     = "ASC"
+	kwDesc    = "DESC"
+	kwIndex   = "INDEX"
+	kwOn      = "ON"
+	kwIs      = "IS"
+	kwNull    = "NULL"
+	kwPragma  = "PRAGMA"
+	kwAnd     = "AND"
+	kwOr      = "OR"
+	kwLike    = "LIKE"
+	kwCast    = "CAST"
+	kwDefault = "DEFAULT"
+	kwUnique  = "UNIQUE"
+	kwWith    = "WITH"
+	// INCLUDE lists the columns an index stores the values of without
+	// indexing them for example CREATE INDEX idx ON foo (bar) INCLUDE (baz).
+	kwInclude = "INCLUDE"
+	// MATERIALIZED is a keyword of a common table expression for example WITH
+	// foo AS MATERIALIZED (SELECT 1).
+	kwMaterialized = "MATERIALIZED"
+	// AUTOINCREMENT follows PRIMARY KEY in a column definition.
+	kwAutoincrement = "AUTOINCREMENT"
+	// CURRENT_TIMESTAMP and CURRENT_DATE are keywords rather than functions
+	// since they are not followed by parentheses.
+	kwCurrentTimestamp = "CURRENT_TIMESTAMP"
+	kwCurrentDate      = "CURRENT_DATE"
+	// IGNORE, REPLACE, CONFLICT, DO and NOTHING are part of the conflict
+	// clauses of an INSERT.
+	kwIgnore   = "IGNORE"
+	kwReplace  = "REPLACE"
+	kwConflict = "CONFLICT"
+	kwDo       = "DO"
+	kwNothing  = "NOTHING"
+	kwLimit    = "LIMIT"
+	// ANALYZE gathers the statistics of tables and their indexes.
+	kwAnalyze = "ANALYZE"
+	// JOIN, INNER, CROSS, LEFT and OUTER join tables in a FROM clause.
+	kwJoin  = "JOIN"
+	kwInner = "INNER"
+	kwCross = "CROSS"
+	kwLeft  = "LEFT"
+	kwOuter = "OUTER"
 )
 
 // keywords is a list of all keywords.
@@ -100,6 +148,45 @@ var keywords = []string{
 	kwUpdate,
 	kwSet,
 	kwDelete,
+	kwOrder,
+	kwGroup,
+	kwBy,
+	kwAsc,
+	kwDesc,
+	kwIndex,
+	kwOn,
+	kwIs,
+	kwNull,
+	kwPragma,
+	kwAnd,
+	kwOr,
+	kwLike,
+	kwCast,
+	kwDefault,
+	kwUnique,
+	kwWith,
+	kwInclude,
+	kwMaterialized,
+	kwAutoincrement,
+	kwCurrentTimestamp,
+	kwCurrentDate,
+	kwIgnore,
+	kwReplace,
+	kwConflict,
+	kwDo,
+	kwNothing,
+	kwLimit,
+	kwAnalyze,
+	kwJoin,
+	kwInner,
+	kwCross,
+	kwLeft,
+	kwOuter,
+}
+
+// Keywords returns a list of all keywords.
+func Keywords() []string {
+	return slices.Clone(keywords)
 }
 
 // Operators where op is operator.
@@ -112,6 +199,45 @@ const (
 	OpEq  = "="
 	OpLt  = "<"
 	OpGt  = ">"
+	// OpNe, OpLte and OpGte are the rest of the comparisons. opNeAlt is the
+	// same as OpNe and is parsed as OpNe.
+	OpNe    = "!="
+	opNeAlt = "<>"
+	OpLte   = "<="
+	OpGte   = ">="
+	// OpMod is the remainder of dividing the left operand by the right.
+	OpMod = "%"
+	// OpConcat is the text of the left operand followed by the text of the
+	// right.
+	OpConcat = "||"
+	// OpBitAnd, OpBitOr, OpShiftLeft and OpShiftRight are bitwise operators
+	// of the operands as 64 bit integers.
+	OpBitAnd     = "&"
+	OpBitOr      = "|"
+	OpShiftLeft  = "<<"
+	OpShiftRight = ">>"
+	// OpAnd and OpOr are keywords rather than symbols so they are not lexed
+	// as operators.
+	OpAnd = kwAnd
+	OpOr  = kwOr
+	// OpLike is a keyword the same as OpAnd and OpOr. NOT LIKE is parsed as
+	// NOT applied to LIKE.
+	OpLike = kwLike
+)
+
+// Prefix operators are the operators of a UnaryExpr preceding the operand.
+// OpNeg is lexed the same as OpSub and is only a negation when it is not
+// preceded by an operand.
+const (
+	OpNot    = kwNot
+	OpNeg    = "-"
+	OpBitNot = "~"
+)
+
+// Postfix operators are the operators of a UnaryExpr following the operand.
+const (
+	OpIsNull  = "IS NULL"
+	OpNotNull = "IS NOT NULL"
 )
 
 // operators is a list of all operators.
@@ -124,20 +250,56 @@ var operators = []string{
 	OpEq,
 	OpLt,
 	OpGt,
+	OpNe,
+	opNeAlt,
+	OpLte,
+	OpGte,
+	OpMod,
+	OpConcat,
+	OpBitAnd,
+	OpBitOr,
+	OpShiftLeft,
+	OpShiftRight,
+	OpBitNot,
 }
 
 // opPrecedence defines operator precedence. The higher the number the higher
 // the precedence.
 var opPrecedence = map[string]int{
-	OpEq:  1,
-	OpLt:  2,
-	OpGt:  2,
-	OpSub: 3,
-	OpAdd: 3,
-	OpDiv: 4,
-	OpMul: 4,
-	OpExp: 5,
-}
+	OpOr:         1,
+	OpAnd:        2,
+	OpEq:         3,
+	OpLike:       3,
+	OpNe:         3,
+	opNeAlt:      3,
+	OpLt:         4,
+	OpGt:         4,
+	OpLte:        4,
+	OpGte:        4,
+	OpBitAnd:     5,
+	OpBitOr:      5,
+	OpShiftLeft:  5,
+	OpShiftRight: 5,
+	OpSub:        6,
+	OpAdd:        6,
+	OpDiv:        7,
+	OpMul:        7,
+	OpMod:        7,
+	OpExp:        8,
+	OpConcat:     8,
+}
+
+// isPrecedence is the precedence of IS NULL and IS NOT NULL which bind the same
+// as equality.
+var isPrecedence = opPrecedence[OpEq]
+
+// notPrecedence is the precedence of the prefix NOT which binds looser than
+// comparisons so NOT a = b is NOT (a = b).
+var notPrecedence = opPrecedence[OpAnd]
+
+// negPrecedence is the precedence of the prefix - and ~ which bind tighter than
+// any binary operator.
+var negPrecedence = opPrecedence[OpExp]
 
 type lexer struct {
 	src   string
@@ -157,7 +319,7 @@ func (l *lexer) ToStatements() Statements {
 	statements := [][]token{}
 	start := 0
 	for i := range tokens {
-		if tokens[i].value == ";" {
+		if tokens[i].isTerminator() {
 			statements = append(statements, tokens[start:i+1])
 			start = i + 1
 		}
@@ -193,7 +355,7 @@ func IsTerminated(statements Statements) bool {
 		if token.tokenType == tkWhitespace {
 			continue
 		}
-		if token.value == ";" {
+		if token.isTerminator() {
 			return true
 		}
 		break
@@ -201,6 +363,43 @@ func IsTerminated(statements Statements) bool {
 	return false
 }
 
+// NormalizedSQL returns the SQL text of statement with comments and runs of
+// whitespace replaced by a single space, keywords in upper case and without the
+// terminating semi colon. Two statements with the same normalized SQL are lexed
+// to the same tokens so they have the same meaning.
+func NormalizedSQL(statement Statement) string {
+	var b strings.Builder
+	space := false
+	for _, t := range statement {
+		switch {
+		case t.tokenType == tkWhitespace || t.tokenType == tkComment:
+			space = true
+			continue
+		case t.tokenType == tkEOF || t.isTerminator():
+			continue
+		}
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		switch t.tokenType {
+		case tkLiteral:
+			b.WriteString("'" + strings.ReplaceAll(t.value, "'", "''") + "'")
+		case tkUnterminated:
+			b.WriteString("'" + t.value)
+		default:
+			b.WriteString(t.value)
+		}
+	}
+	return b.String()
+}
+
+// isTerminator returns true when t is the semi colon ending a statement. A
+// semi colon within a quoted value or comment is not a terminator.
+func (t token) isTerminator() bool {
+	return t.tokenType == tkSeparator && t.value == ";"
+}
+
 // Lex tokenizes the src string.
 func (l *lexer) Lex() []token {
 	ret := []token{}
@@ -277,11 +476,31 @@ func (l *lexer) scanWord() token {
 	return token{tokenType: tkIdentifier, value: value}
 }
 
+// scanDigit scans an integer or a real number. A real number has a fraction
+// such as 1.5, an exponent such as 2e3 or both.
 func (l *lexer) scanDigit() token {
 	l.next()
 	for l.isDigit(l.peek(l.end)) {
 		l.next()
 	}
+	if l.peek(l.end) == '.' && l.isDigit(l.peek(l.end+1)) {
+		l.next()
+		for l.isDigit(l.peek(l.end)) {
+			l.next()
+		}
+	}
+	if r := l.peek(l.end); r == 'e' || r == 'E' {
+		exponent := l.end + 1
+		if sign := l.peek(exponent); sign == '+' || sign == '-' {
+			exponent += 1
+		}
+		if l.isDigit(l.peek(exponent)) {
+			l.end = exponent
+			for l.isDigit(l.peek(l.end)) {
+				l.next()
+			}
+		}
+	}
 	return token{tokenType: tkNumeric, value: l.src[l.start:l.end]}
 }
 
@@ -301,6 +520,9 @@ func (l *lexer) scanLiteral(quote rune) token {
 		if l.peek(l.end) == quote {
 			break
 		}
+		if l.end >= len(l.src) {
+			return token{tokenType: tkUnterminated, value: l.src[l.start+1:]}
+		}
 		l.next()
 	}
 	l.next()
@@ -314,6 +536,15 @@ func (l *lexer) scanLiteral(quote rune) token {
 
 func (l *lexer) scanOperator() token {
 	l.next()
+	// An operator such as || is more than one character so the longest
+	// operator starting here is scanned.
+	for _, op := range operators {
+		if len(op) > l.end-l.start && strings.HasPrefix(l.src[l.start:], op) {
+			for l.end-l.start < len(op) {
+				l.next()
+			}
+		}
+	}
 	return token{tokenType: tkOperator, value: l.src[l.start:l.end]}
 }
 
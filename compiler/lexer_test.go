@@ -98,6 +98,22 @@ func TestLexSelect(t *testing.T) {
 				{tkNumeric, "12"},
 			},
 		},
+		{
+			sql: "SELECT 1.5,2E3,4e-2,1.e",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1.5"},
+				{tkSeparator, ","},
+				{tkNumeric, "2E3"},
+				{tkSeparator, ","},
+				{tkNumeric, "4e-2"},
+				{tkSeparator, ","},
+				{tkNumeric, "1"},
+				{tkSeparator, "."},
+				{tkIdentifier, "e"},
+			},
+		},
 		{
 			sql: "SELECT 1;",
 			expected: []token{
@@ -191,6 +207,87 @@ func TestLexSelect(t *testing.T) {
 				{tkNumeric, "9"},
 			},
 		},
+		{
+			sql: "SELECT 7 % 3 || 'a'||b",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkNumeric, "7"},
+				{tkWhitespace, " "},
+				{tkOperator, "%"},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+				{tkWhitespace, " "},
+				{tkOperator, "||"},
+				{tkWhitespace, " "},
+				{tkLiteral, "a"},
+				{tkOperator, "||"},
+				{tkIdentifier, "b"},
+			},
+		},
+		{
+			sql: "SELECT ~a & 1|2 << 3 >> 4",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkOperator, "~"},
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkOperator, "&"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkOperator, "|"},
+				{tkNumeric, "2"},
+				{tkWhitespace, " "},
+				{tkOperator, "<<"},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+				{tkWhitespace, " "},
+				{tkOperator, ">>"},
+				{tkWhitespace, " "},
+				{tkNumeric, "4"},
+			},
+		},
+		{
+			sql: "SELECT * FROM foo WHERE a != 1 AND b<>2 AND c <= 3 AND d>=4",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkOperator, "*"},
+				{tkWhitespace, " "},
+				{tkKeyword, "FROM"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkKeyword, "WHERE"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkOperator, "!="},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AND"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "b"},
+				{tkOperator, "<>"},
+				{tkNumeric, "2"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AND"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "c"},
+				{tkWhitespace, " "},
+				{tkOperator, "<="},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AND"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "d"},
+				{tkOperator, ">="},
+				{tkNumeric, "4"},
+			},
+		},
 		{
 			sql: "SELECT * FROM foo WHERE id = 1",
 			expected: []token{
@@ -318,6 +415,23 @@ func TestLexSelect(t *testing.T) {
 				{tkSeparator, ";"},
 			},
 		},
+		{
+			sql: "SELECT 'a;b';",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkLiteral, "a;b"},
+				{tkSeparator, ";"},
+			},
+		},
+		{
+			sql: "SELECT 'a;",
+			expected: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkUnterminated, "a;"},
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.sql, func(t *testing.T) {
@@ -550,6 +664,22 @@ func TestToStatements(t *testing.T) {
 			src:         "SELECT 1;  SELECT 1; ",
 			expectedLen: 2,
 		},
+		{
+			src:         "SELECT ';'",
+			expectedLen: 1,
+		},
+		{
+			src:         "INSERT INTO foo (a) VALUES ('a;b', \"c;\"); SELECT 1;",
+			expectedLen: 2,
+		},
+		{
+			src:         "SELECT 1 /* a; b */; -- c; d",
+			expectedLen: 1,
+		},
+		{
+			src:         "SELECT 'a; SELECT 1;",
+			expectedLen: 1,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.src, func(t *testing.T) {
@@ -599,6 +729,14 @@ func TestIsTerminated(t *testing.T) {
 			src:  "SELECT 1;  SELECT 1; ",
 			want: true,
 		},
+		{
+			src:  "SELECT ';'",
+			want: false,
+		},
+		{
+			src:  "SELECT 'a;",
+			want: false,
+		},
 	}
 	for _, tc := range testCases {
 		t.Run(tc.src, func(t *testing.T) {
@@ -609,3 +747,44 @@ func TestIsTerminated(t *testing.T) {
 		})
 	}
 }
+
+func TestNormalizedSQL(t *testing.T) {
+	type testCase struct {
+		src  string
+		want string
+	}
+	testCases := []testCase{
+		{
+			src:  "SELECT * FROM foo;",
+			want: "SELECT * FROM foo",
+		},
+		{
+			src:  "  select *\n\tfrom   foo ; ",
+			want: "SELECT * FROM foo",
+		},
+		{
+			src:  "SELECT /* all */ * FROM foo -- comment\n;",
+			want: "SELECT * FROM foo",
+		},
+		{
+			src:  "SELECT * FROM Foo",
+			want: "SELECT * FROM Foo",
+		},
+		{
+			src:  "SELECT 'it''s  ;'",
+			want: "SELECT 'it''s  ;'",
+		},
+		{
+			src:  "SELECT a+1 FROM foo",
+			want: "SELECT a+1 FROM foo",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.src, func(t *testing.T) {
+			statements := NewLexer(tc.src).ToStatements()
+			if got := NormalizedSQL(statements[0]); got != tc.want {
+				t.Fatalf("want %q got %q", tc.want, got)
+			}
+		})
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 )
 
 const (
@@ -30,7 +31,14 @@ func NewParser(tokens []token) *parser {
 }
 
 func (p *parser) Parse() (Stmt, error) {
-	return p.parseStmt()
+	stmt, err := p.parseStmt()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.parseEnd(); err != nil {
+		return nil, err
+	}
+	return stmt, nil
 }
 
 func (p *parser) parseStmt() (Stmt, error) {
@@ -61,7 +69,12 @@ func (p *parser) parseStmt() (Stmt, error) {
 	switch t.value {
 	case kwSelect:
 		return p.parseSelect(sb)
+	case kwWith:
+		return p.parseWith(sb)
 	case kwCreate:
+		if next := p.peekNextNonSpace().value; next == kwIndex || next == kwUnique {
+			return p.parseCreateIndex(sb)
+		}
 		return p.parseCreate(sb)
 	case kwInsert:
 		return p.parseInsert(sb)
@@ -69,6 +82,10 @@ func (p *parser) parseStmt() (Stmt, error) {
 		return p.parseUpdate(sb)
 	case kwDelete:
 		return p.parseDelete(sb)
+	case kwPragma:
+		return p.parsePragma(sb)
+	case kwAnalyze:
+		return p.parseAnalyze(sb)
 	}
 	return nil, fmt.Errorf(tokenErr, t.value)
 }
@@ -90,35 +107,257 @@ func (p *parser) parseSelect(sb *StmtBase) (*SelectStmt, error) {
 		}
 		p.nextNonSpace()
 	}
-	f := p.nextNonSpace()
-	if f.tokenType == tkEOF || f.value == ";" {
-		return stmt, nil
+	if p.peekNextNonSpace().value == kwFrom {
+		p.nextNonSpace()
+		from, err := p.parseFrom()
+		if err != nil {
+			return nil, err
+		}
+		stmt.From = from
 	}
-	w := f
-	if f.value == kwFrom {
-		t := p.nextNonSpace()
-		if t.tokenType != tkIdentifier {
-			return nil, fmt.Errorf(tokenErr, t.value)
+	if p.peekNextNonSpace().value == kwWhere {
+		p.nextNonSpace()
+		exp, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
 		}
-		stmt.From = &From{
-			TableName: t.value,
+		stmt.Where = exp
+	}
+	if p.peekNextNonSpace().value == kwGroup {
+		p.nextNonSpace()
+		if v := p.nextNonSpace().value; v != kwBy {
+			return nil, fmt.Errorf(tokenErr, v)
+		}
+		for {
+			exp, err := p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+			stmt.GroupBy = append(stmt.GroupBy, exp)
+			if p.peekNextNonSpace().value != "," {
+				break
+			}
+			p.nextNonSpace()
 		}
-		w = p.nextNonSpace()
 	}
+	if p.peekNextNonSpace().value == kwOrder {
+		p.nextNonSpace()
+		orderBy, err := p.parseOrderBy()
+		if err != nil {
+			return nil, err
+		}
+		stmt.OrderBy = orderBy
+	}
+	return stmt, nil
+}
 
-	if w.tokenType == tkEOF || w.value == ";" {
-		return stmt, nil
+// parseFrom parses the tables following FROM and the tables joined to them.
+func (p *parser) parseFrom() (*From, error) {
+	schemaName, tableName, args, err := p.parseTable()
+	if err != nil {
+		return nil, err
 	}
-	if w.value == kwWhere {
-		exp, err := p.parseExpression(0)
+	from := &From{
+		SchemaName: schemaName,
+		TableName:  tableName,
+		Args:       args,
+	}
+	for {
+		join := Join{}
+		switch p.peekNextNonSpace().value {
+		case ",":
+			p.nextNonSpace()
+		case kwJoin:
+			p.nextNonSpace()
+		case kwInner, kwCross:
+			p.nextNonSpace()
+			if v := p.nextNonSpace().value; v != kwJoin {
+				return nil, fmt.Errorf(tokenErr, v)
+			}
+		case kwLeft:
+			p.nextNonSpace()
+			v := p.nextNonSpace().value
+			if v == kwOuter {
+				v = p.nextNonSpace().value
+			}
+			if v != kwJoin {
+				return nil, fmt.Errorf(tokenErr, v)
+			}
+			join.Left = true
+		default:
+			return from, nil
+		}
+		join.SchemaName, join.TableName, join.Args, err = p.parseTable()
 		if err != nil {
 			return nil, err
 		}
-		stmt.Where = exp
+		if p.peekNextNonSpace().value == kwOn {
+			p.nextNonSpace()
+			join.On, err = p.parseExpression(0)
+			if err != nil {
+				return nil, err
+			}
+		}
+		from.Joins = append(from.Joins, join)
+	}
+}
+
+// parseTable parses a table of a FROM clause which is a table name that may be
+// qualified with a schema or a table valued function with its arguments.
+func (p *parser) parseTable() (schemaName, tableName string, args []Expr, err error) {
+	t := p.nextNonSpace()
+	isExplain := t.tokenType == tkKeyword && t.value == kwExplain
+	if t.tokenType != tkIdentifier && !isExplain {
+		return "", "", nil, fmt.Errorf(tokenErr, t.value)
 	}
+	if isExplain || p.peekNextNonSpace().value == "(" {
+		tableName = t.value
+		if isExplain {
+			tableName = strings.ToLower(t.value)
+		}
+		args, err = p.parseFunctionArgs()
+		if err != nil {
+			return "", "", nil, err
+		}
+		return "", tableName, args, nil
+	}
+	schemaName, tableName, err = p.parseQualifiedName(t)
+	return schemaName, tableName, nil, err
+}
+
+// parseEnd returns an error when tokens other than the semi colon terminating
+// the statement follow the tokens that were parsed.
+func (p *parser) parseEnd() error {
+	if end := p.nextNonSpace(); end.tokenType != tkEOF && !end.isTerminator() {
+		return fmt.Errorf(tokenErr, end.value)
+	}
+	return nil
+}
+
+// parseWith parses the common table expressions of a WITH clause and the select
+// following them.
+func (p *parser) parseWith(sb *StmtBase) (*SelectStmt, error) {
+	ctes := []CommonTableExpr{}
+	for {
+		cte, err := p.parseCommonTableExpr()
+		if err != nil {
+			return nil, err
+		}
+		ctes = append(ctes, *cte)
+		if p.peekNextNonSpace().value != "," {
+			break
+		}
+		p.nextNonSpace()
+	}
+	if v := p.nextNonSpace().value; v != kwSelect {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	stmt, err := p.parseSelect(sb)
+	if err != nil {
+		return nil, err
+	}
+	stmt.With = ctes
 	return stmt, nil
 }
 
+// parseCommonTableExpr parses a single common table expression such as foo (a)
+// AS MATERIALIZED (SELECT 1).
+func (p *parser) parseCommonTableExpr() (*CommonTableExpr, error) {
+	name := p.nextNonSpace()
+	if name.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(identErr, name.value)
+	}
+	cte := &CommonTableExpr{Name: name.value}
+	if p.peekNextNonSpace().value == "(" {
+		p.nextNonSpace()
+		for {
+			cn := p.nextNonSpace()
+			if cn.tokenType != tkIdentifier {
+				return nil, fmt.Errorf(identErr, cn.value)
+			}
+			cte.Columns = append(cte.Columns, cn.value)
+			sep := p.nextNonSpace()
+			if sep.value == ")" {
+				break
+			}
+			if sep.value != "," {
+				return nil, fmt.Errorf(tokenErr, sep.value)
+			}
+		}
+	}
+	if v := p.nextNonSpace().value; v != kwAs {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	next := p.nextNonSpace()
+	if next.value == kwNot {
+		next = p.nextNonSpace()
+		if next.value != kwMaterialized {
+			return nil, fmt.Errorf(tokenErr, next.value)
+		}
+		next = p.nextNonSpace()
+	} else if next.value == kwMaterialized {
+		cte.Materialized = true
+		next = p.nextNonSpace()
+	}
+	if next.value != "(" {
+		return nil, fmt.Errorf(tokenErr, next.value)
+	}
+	start := p.end + 1
+	if v := p.nextNonSpace().value; v != kwSelect {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	stmt, err := p.parseSelect(&StmtBase{})
+	if err != nil {
+		return nil, err
+	}
+	if v := p.nextNonSpace().value; v != ")" {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	cte.Select = stmt
+	cte.SQL = tokensText(p.tokens[start:p.end])
+	return cte, nil
+}
+
+// tokensText returns the SQL text the tokens were lexed from.
+func tokensText(tokens []token) string {
+	var b strings.Builder
+	for _, t := range tokens {
+		if t.tokenType == tkLiteral {
+			b.WriteString("'" + strings.ReplaceAll(t.value, "'", "''") + "'")
+			continue
+		}
+		b.WriteString(t.value)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// parseOrderBy parses the terms following ORDER in an ORDER BY clause.
+func (p *parser) parseOrderBy() ([]OrderingTerm, error) {
+	if v := p.nextNonSpace().value; v != kwBy {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	terms := []OrderingTerm{}
+	for {
+		exp, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		term := OrderingTerm{Expr: exp}
+		switch p.peekNextNonSpace().value {
+		case kwAsc:
+			p.nextNonSpace()
+		case kwDesc:
+			p.nextNonSpace()
+			term.Desc = true
+		}
+		terms = append(terms, term)
+		if p.peekNextNonSpace().value != "," {
+			return terms, nil
+		}
+		p.nextNonSpace()
+	}
+}
+
 // parseResultColumn parses a single result column
 func (p *parser) parseResultColumn() (*ResultColumn, error) {
 	resultColumn := &ResultColumn{}
@@ -169,7 +408,27 @@ func (p *parser) parseExpression(rbp int) (Expr, error) {
 	}
 	for {
 		nextToken := p.peekNextNonSpace()
-		if nextToken.tokenType != tkOperator {
+		if nextToken.tokenType == tkKeyword && nextToken.value == kwIs {
+			if isPrecedence <= rbp {
+				return left, nil
+			}
+			left, err = p.parseIsNull(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if nextToken.tokenType == tkKeyword && nextToken.value == kwNot {
+			if opPrecedence[OpLike] <= rbp {
+				return left, nil
+			}
+			left, err = p.parseNotLike(left)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !isBinaryOperator(nextToken) {
 			return left, nil
 		}
 		lbp := opPrecedence[nextToken.value]
@@ -181,14 +440,131 @@ func (p *parser) parseExpression(rbp int) (Expr, error) {
 		if err != nil {
 			return nil, err
 		}
+		op := nextToken.value
+		if op == opNeAlt {
+			op = OpNe
+		}
 		left = &BinaryExpr{
 			Left:     left,
-			Operator: nextToken.value,
+			Operator: op,
+			Right:    right,
+		}
+	}
+}
+
+// isBinaryOperator returns true if t is the operator of a BinaryExpr. Most
+// operators are symbols, but AND and OR are keywords.
+func isBinaryOperator(t token) bool {
+	if t.tokenType == tkKeyword {
+		return t.value == OpAnd || t.value == OpOr || t.value == OpLike
+	}
+	return t.tokenType == tkOperator && t.value != OpBitNot
+}
+
+// parseNotLike parses NOT LIKE following left as NOT applied to left LIKE the
+// pattern.
+func (p *parser) parseNotLike(left Expr) (Expr, error) {
+	p.nextNonSpace()
+	if next := p.nextNonSpace(); next.tokenType != tkKeyword || next.value != kwLike {
+		return nil, fmt.Errorf(tokenErr, next.value)
+	}
+	right, err := p.parseExpression(opPrecedence[OpLike])
+	if err != nil {
+		return nil, err
+	}
+	return &UnaryExpr{
+		Operator: OpNot,
+		Operand: &BinaryExpr{
+			Left:     left,
+			Operator: OpLike,
 			Right:    right,
+		},
+	}, nil
+}
+
+// parseIsNull parses the postfix IS NULL or IS NOT NULL following operand.
+func (p *parser) parseIsNull(operand Expr) (Expr, error) {
+	p.nextNonSpace()
+	op := OpIsNull
+	next := p.nextNonSpace()
+	if next.tokenType == tkKeyword && next.value == kwNot {
+		op = OpNotNull
+		next = p.nextNonSpace()
+	}
+	if next.tokenType != tkKeyword || next.value != kwNull {
+		return nil, fmt.Errorf(tokenErr, next.value)
+	}
+	return &UnaryExpr{Operator: op, Operand: operand}, nil
+}
+
+// parseFunctionArgs parses the parenthesized argument list of a function for
+// example ('SELECT 1') in SELECT * FROM explain('SELECT 1').
+func (p *parser) parseFunctionArgs() ([]Expr, error) {
+	if v := p.nextNonSpace().value; v != "(" {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	args := []Expr{}
+	if p.peekNextNonSpace().value == ")" {
+		p.nextNonSpace()
+		return args, nil
+	}
+	for {
+		arg, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+		sep := p.nextNonSpace()
+		if sep.value == ")" {
+			return args, nil
+		}
+		if sep.value != "," {
+			return nil, fmt.Errorf(tokenErr, sep.value)
 		}
 	}
 }
 
+// parseCast parses the parenthesized operand of CAST for example ('1' AS
+// INTEGER) in CAST('1' AS INTEGER).
+func (p *parser) parseCast() (Expr, error) {
+	if v := p.nextNonSpace().value; v != "(" {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	e, err := p.parseExpression(0)
+	if err != nil {
+		return nil, err
+	}
+	if as := p.nextNonSpace(); as.tokenType != tkKeyword || as.value != kwAs {
+		return nil, fmt.Errorf(tokenErr, as.value)
+	}
+	typeName := p.nextNonSpace()
+	if typeName.tokenType != tkKeyword && typeName.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(tokenErr, typeName.value)
+	}
+	if v := p.nextNonSpace().value; v != ")" {
+		return nil, fmt.Errorf(tokenErr, v)
+	}
+	return &CastExpr{Expr: e, TypeName: strings.ToUpper(typeName.value)}, nil
+}
+
+// isReal returns true when the numeric token value is a real number rather
+// than an integer.
+func isReal(value string) bool {
+	return strings.ContainsAny(value, ".eE")
+}
+
+// parseFloatLit parses the value of a real number token.
+func parseFloatLit(value string) (Expr, error) {
+	floatValue, err := strconv.ParseFloat(value, 64)
+	if errors.Is(err, strconv.ErrRange) {
+		return nil, fmt.Errorf("real %s overflows 64 bits", value)
+	}
+	if err != nil {
+		return nil, errors.New("failed to parse numeric token")
+	}
+	return &FloatLit{Value: floatValue}, nil
+}
+
 // getOperand is a parseExpression helper who parses token groups into atomic
 // expressions serving as operands in the expression tree. A good example of
 // this would be in the statement `SELECT foo.bar + 1;`. `foo.bar` is processed
@@ -199,35 +575,69 @@ func (p *parser) getOperand() (Expr, error) {
 		return &StringLit{Value: first.value}, nil
 	}
 	if first.tokenType == tkNumeric {
+		if isReal(first.value) {
+			return parseFloatLit(first.value)
+		}
 		intValue, err := strconv.Atoi(first.value)
+		if errors.Is(err, strconv.ErrRange) {
+			return nil, fmt.Errorf("integer %s overflows 64 bits", first.value)
+		}
 		if err != nil {
 			return nil, errors.New("failed to parse numeric token")
 		}
 		return &IntLit{Value: intValue}, nil
 	}
+	if first.tokenType == tkIdentifier && p.peekNextNonSpace().value == "(" {
+		args, err := p.parseFunctionArgs()
+		if err != nil {
+			return nil, err
+		}
+		return &FunctionExpr{FnType: strings.ToUpper(first.value), Args: args}, nil
+	}
 	if first.tokenType == tkIdentifier {
-		next := p.peekNextNonSpace()
-		if next.value == "." {
+		// A column may be qualified as table.column or schema.table.column.
+		parts := []string{first.value}
+		for len(parts) < 3 && p.peekNextNonSpace().value == "." {
 			p.nextNonSpace()
-			prop := p.peekNextNonSpace()
-			if prop.tokenType == tkIdentifier {
-				p.nextNonSpace()
-				return &ColumnRef{
-					Table:  first.value,
-					Column: prop.value,
-				}, nil
+			prop := p.nextNonSpace()
+			if prop.tokenType != tkIdentifier {
+				return nil, fmt.Errorf(tokenErr, prop.value)
 			}
+			parts = append(parts, prop.value)
+		}
+		switch len(parts) {
+		case 3:
+			return &ColumnRef{
+				Schema: parts[0],
+				Table:  parts[1],
+				Column: parts[2],
+			}, nil
+		case 2:
+			return &ColumnRef{
+				Table:  parts[0],
+				Column: parts[1],
+			}, nil
 		}
 		return &ColumnRef{
 			Column: first.value,
 		}, nil
 	}
+	if first.tokenType == tkKeyword && first.value == kwNull {
+		return &NullLit{}, nil
+	}
 	if first.tokenType == tkParam {
 		v := &Variable{Position: p.paramCount}
 		p.paramCount += 1
 		return v, nil
 	}
 	if first.tokenType == tkKeyword && first.value == kwCount {
+		if p.peekNonSpaceBy(2).value != "*" {
+			args, err := p.parseFunctionArgs()
+			if err != nil {
+				return nil, err
+			}
+			return &FunctionExpr{FnType: FnCount, Args: args}, nil
+		}
 		if v := p.nextNonSpace().value; v != "(" {
 			return nil, fmt.Errorf(tokenErr, v)
 		}
@@ -239,8 +649,59 @@ func (p *parser) getOperand() (Expr, error) {
 		}
 		return &FunctionExpr{FnType: FnCount}, nil
 	}
-	// TODO support unary prefix expression
-	// TODO support parens
+	if first.tokenType == tkKeyword && first.value == kwCast {
+		return p.parseCast()
+	}
+	if first.tokenType == tkKeyword && IsTimeFunction(first.value) {
+		return &FunctionExpr{FnType: first.value, Args: []Expr{}}, nil
+	}
+	if first.tokenType == tkSeparator && first.value == "(" {
+		e, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		if v := p.nextNonSpace().value; v != ")" {
+			return nil, fmt.Errorf(tokenErr, v)
+		}
+		return e, nil
+	}
+	if first.tokenType == tkKeyword && first.value == kwNot {
+		operand, err := p.parseExpression(notPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Operator: OpNot, Operand: operand}, nil
+	}
+	if first.tokenType == tkOperator && first.value == OpNeg {
+		if next := p.peekNextNonSpace(); next.tokenType == tkNumeric {
+			// The literal is parsed with its sign so the most negative integer
+			// does not overflow.
+			p.nextNonSpace()
+			if isReal(next.value) {
+				return parseFloatLit(OpNeg + next.value)
+			}
+			intValue, err := strconv.Atoi(OpNeg + next.value)
+			if errors.Is(err, strconv.ErrRange) {
+				return nil, fmt.Errorf("integer %s%s overflows 64 bits", OpNeg, next.value)
+			}
+			if err != nil {
+				return nil, errors.New("failed to parse numeric token")
+			}
+			return &IntLit{Value: intValue}, nil
+		}
+		operand, err := p.parseExpression(negPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Operator: OpNeg, Operand: operand}, nil
+	}
+	if first.tokenType == tkOperator && first.value == OpBitNot {
+		operand, err := p.parseExpression(negPrecedence)
+		if err != nil {
+			return nil, err
+		}
+		return &UnaryExpr{Operator: OpBitNot, Operand: operand}, nil
+	}
 	return nil, errors.New("failed to parse null denotation")
 }
 
@@ -266,23 +727,19 @@ func (p *parser) parseCreate(sb *StmtBase) (*CreateStmt, error) {
 	if t.value != kwTable {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
-	if p.peekNextNonSpace().value == kwIf {
-		p.nextNonSpace()
-		ifn := p.nextNonSpace()
-		if ifn.value != kwNot {
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
-		}
-		ifn = p.nextNonSpace()
-		if ifn.value != kwExists {
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
-		}
-		stmt.IfNotExists = true
+	ifNotExists, err := p.parseIfNotExists()
+	if err != nil {
+		return nil, err
 	}
+	stmt.IfNotExists = ifNotExists
 	tn := p.nextNonSpace()
 	if tn.tokenType != tkIdentifier {
 		return nil, fmt.Errorf(identErr, tn.value)
 	}
-	stmt.TableName = tn.value
+	stmt.SchemaName, stmt.TableName, err = p.parseQualifiedName(tn)
+	if err != nil {
+		return nil, err
+	}
 	lp := p.nextNonSpace()
 	if lp.value != "(" {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
@@ -297,21 +754,31 @@ func (p *parser) parseCreate(sb *StmtBase) (*CreateStmt, error) {
 		if colType.value != kwInteger && colType.value != kwText {
 			return nil, fmt.Errorf(columnErr, colType.value)
 		}
+		colDef := ColDef{
+			ColName: colName.value,
+			ColType: colType.value,
+		}
 		sep := p.nextNonSpace()
-		isPrimaryKey := false
-		if sep.value == kwPrimary {
-			keyKw := p.nextNonSpace()
-			if keyKw.value != kwKey {
-				return nil, fmt.Errorf(tokenErr, tn.value)
+		for {
+			if sep.value == kwPrimary && !colDef.PrimaryKey {
+				if p.nextNonSpace().value != kwKey {
+					return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+				}
+				colDef.PrimaryKey = true
+			} else if sep.value == kwAutoincrement && colDef.PrimaryKey && !colDef.AutoIncrement {
+				colDef.AutoIncrement = true
+			} else if sep.value == kwDefault && colDef.Default == "" {
+				d := p.nextNonSpace()
+				if d.tokenType != tkKeyword || !IsTimeFunction(d.value) {
+					return nil, fmt.Errorf(tokenErr, d.value)
+				}
+				colDef.Default = d.value
+			} else {
+				break
 			}
-			isPrimaryKey = true
 			sep = p.nextNonSpace()
 		}
-		stmt.ColDefs = append(stmt.ColDefs, ColDef{
-			ColName:    colName.value,
-			ColType:    colType.value,
-			PrimaryKey: isPrimaryKey,
-		})
+		stmt.ColDefs = append(stmt.ColDefs, colDef)
 		if sep.value != "," {
 			if sep.value == ")" {
 				break
@@ -322,11 +789,100 @@ func (p *parser) parseCreate(sb *StmtBase) (*CreateStmt, error) {
 	return stmt, nil
 }
 
+// parseIfNotExists parses an optional IF NOT EXISTS returning true when it is
+// present.
+func (p *parser) parseIfNotExists() (bool, error) {
+	if p.peekNextNonSpace().value != kwIf {
+		return false, nil
+	}
+	p.nextNonSpace()
+	if p.nextNonSpace().value != kwNot {
+		return false, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	if p.nextNonSpace().value != kwExists {
+		return false, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	return true, nil
+}
+
+func (p *parser) parseCreateIndex(sb *StmtBase) (*CreateIndexStmt, error) {
+	stmt := &CreateIndexStmt{StmtBase: sb}
+	if p.peekNextNonSpace().value == kwUnique {
+		p.nextNonSpace()
+		stmt.Unique = true
+	}
+	if p.nextNonSpace().value != kwIndex {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	ifNotExists, err := p.parseIfNotExists()
+	if err != nil {
+		return nil, err
+	}
+	stmt.IfNotExists = ifNotExists
+	in := p.nextNonSpace()
+	if in.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(identErr, in.value)
+	}
+	stmt.SchemaName, stmt.IndexName, err = p.parseQualifiedName(in)
+	if err != nil {
+		return nil, err
+	}
+	if p.nextNonSpace().value != kwOn {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	tn := p.nextNonSpace()
+	if tn.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(identErr, tn.value)
+	}
+	stmt.TableName = tn.value
+	if p.nextNonSpace().value != "(" {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	cn := p.nextNonSpace()
+	if cn.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(identErr, cn.value)
+	}
+	stmt.ColumnName = cn.value
+	if p.nextNonSpace().value != ")" {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	if p.peekNextNonSpace().value != kwInclude {
+		return stmt, nil
+	}
+	p.nextNonSpace()
+	if p.nextNonSpace().value != "(" {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	for {
+		in := p.nextNonSpace()
+		if in.tokenType != tkIdentifier {
+			return nil, fmt.Errorf(identErr, in.value)
+		}
+		stmt.Include = append(stmt.Include, in.value)
+		switch p.nextNonSpace().value {
+		case ",":
+		case ")":
+			return stmt, nil
+		default:
+			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+		}
+	}
+}
+
 func (p *parser) parseInsert(sb *StmtBase) (*InsertStmt, error) {
 	stmt := &InsertStmt{StmtBase: sb}
 	if p.tokens[p.end].value != kwInsert {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
+	if p.peekNextNonSpace().value == kwOr {
+		p.nextNonSpace()
+		switch resolution := p.nextNonSpace(); resolution.value {
+		case kwIgnore, kwReplace:
+			stmt.Conflict = resolution.value
+		default:
+			return nil, fmt.Errorf(tokenErr, resolution.value)
+		}
+	}
 	if p.nextNonSpace().value != kwInto {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
@@ -334,28 +890,95 @@ func (p *parser) parseInsert(sb *StmtBase) (*InsertStmt, error) {
 	if tn.tokenType != tkIdentifier {
 		return nil, fmt.Errorf(identErr, tn.value)
 	}
-	stmt.TableName = tn.value
-	if p.nextNonSpace().value != "(" {
+	schemaName, tableName, err := p.parseQualifiedName(tn)
+	if err != nil {
+		return nil, err
+	}
+	stmt.SchemaName = schemaName
+	stmt.TableName = tableName
+	// The column list is optional in which case ColNames is empty and the
+	// values are for every column in the order of the table.
+	if p.peekNextNonSpace().value != kwValues {
+		if err := p.parseInsertColumns(stmt); err != nil {
+			return nil, err
+		}
+	}
+	if p.nextNonSpace().value != kwValues {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
+	if _, err := p.parseValue(stmt, 0); err != nil {
+		return nil, err
+	}
+	if p.peekNextNonSpace().value == kwOn {
+		p.nextNonSpace()
+		if err := p.parseOnConflict(stmt); err != nil {
+			return nil, err
+		}
+	}
+	if end := p.nextNonSpace(); end.tokenType != tkEOF && !end.isTerminator() {
+		return nil, fmt.Errorf(tokenErr, end.value)
+	}
+	return stmt, nil
+}
+
+// parseInsertColumns parses the parenthesized column list of an insert.
+func (p *parser) parseInsertColumns(stmt *InsertStmt) error {
+	if p.nextNonSpace().value != "(" {
+		return fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
 	for {
 		i := p.nextNonSpace()
 		if i.tokenType != tkIdentifier {
-			return nil, fmt.Errorf(identErr, i.value)
+			return fmt.Errorf(identErr, i.value)
 		}
 		stmt.ColNames = append(stmt.ColNames, i.value)
 		sep := p.nextNonSpace()
 		if sep.value != "," {
 			if sep.value == ")" {
-				break
+				return nil
 			}
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+			return fmt.Errorf(tokenErr, p.tokens[p.end].value)
 		}
 	}
-	if p.nextNonSpace().value != kwValues {
-		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+}
+
+// parseOnConflict parses ON CONFLICT [(column)] DO NOTHING or ON CONFLICT
+// [(column)] DO UPDATE SET column = expression, ... following ON.
+func (p *parser) parseOnConflict(stmt *InsertStmt) error {
+	if stmt.Conflict != ConflictAbort {
+		return fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	if v := p.nextNonSpace().value; v != kwConflict {
+		return fmt.Errorf(tokenErr, v)
+	}
+	if p.peekNextNonSpace().value == "(" {
+		p.nextNonSpace()
+		target := p.nextNonSpace()
+		if target.tokenType != tkIdentifier {
+			return fmt.Errorf(identErr, target.value)
+		}
+		stmt.ConflictTarget = target.value
+		if v := p.nextNonSpace().value; v != ")" {
+			return fmt.Errorf(tokenErr, v)
+		}
+	}
+	if v := p.nextNonSpace().value; v != kwDo {
+		return fmt.Errorf(tokenErr, v)
+	}
+	switch action := p.nextNonSpace(); action.value {
+	case kwNothing:
+		stmt.Conflict = ConflictIgnore
+		return nil
+	case kwUpdate:
+		stmt.Conflict = ConflictUpdate
+		if v := p.nextNonSpace().value; v != kwSet {
+			return fmt.Errorf(tokenErr, v)
+		}
+		stmt.ConflictSetList = make(map[string]Expr)
+		return p.parseSetList(stmt.ConflictSetList)
+	default:
+		return fmt.Errorf(tokenErr, action.value)
 	}
-	return p.parseValue(stmt, 0)
 }
 
 func (p *parser) parseValue(stmt *InsertStmt, valueIdx int) (*InsertStmt, error) {
@@ -372,9 +995,9 @@ func (p *parser) parseValue(stmt *InsertStmt, valueIdx int) (*InsertStmt, error)
 		sep := p.nextNonSpace()
 		if sep.value != "," {
 			if sep.value == ")" {
-				sep2 := p.nextNonSpace()
-				if sep2.value == "," {
-					p.parseValue(stmt, valueIdx+1)
+				if p.peekNextNonSpace().value == "," {
+					p.nextNonSpace()
+					return p.parseValue(stmt, valueIdx+1)
 				}
 				break
 			}
@@ -393,41 +1016,62 @@ func (p *parser) parseUpdate(sb *StmtBase) (*UpdateStmt, error) {
 	if tableName.tokenType != tkIdentifier {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
-	stmt.TableName = tableName.value
-	set := p.nextNonSpace()
-	for {
-		if set.value != kwSet {
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	schemaName, name, err := p.parseQualifiedName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	stmt.SchemaName = schemaName
+	stmt.TableName = name
+	if p.nextNonSpace().value != kwSet {
+		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+	}
+	if err := p.parseSetList(stmt.SetList); err != nil {
+		return nil, err
+	}
+	next := p.nextNonSpace()
+	if next.value == kwWhere {
+		whereExp, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
 		}
+		stmt.Predicate = whereExp
+		next = p.nextNonSpace()
+	}
+	if next.value == kwLimit {
+		limitExp, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Limit = limitExp
+		next = p.nextNonSpace()
+	}
+	if next.tokenType != tkEOF && !next.isTerminator() {
+		return nil, fmt.Errorf(tokenErr, next.value)
+	}
+	return stmt, nil
+}
+
+// parseSetList parses column = expression, ... following SET into setList.
+func (p *parser) parseSetList(setList map[string]Expr) error {
+	for {
 		colName := p.nextNonSpace()
 		if colName.tokenType != tkIdentifier {
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+			return fmt.Errorf(tokenErr, p.tokens[p.end].value)
 		}
 		eqSign := p.nextNonSpace()
 		if eqSign.value != OpEq {
-			return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
+			return fmt.Errorf(tokenErr, p.tokens[p.end].value)
 		}
 		exp, err := p.parseExpression(0)
 		if err != nil {
-			return nil, err
+			return err
 		}
-		stmt.SetList[colName.value] = exp
+		setList[colName.value] = exp
 		if p.peekNextNonSpace().value != "," {
-			break
+			return nil
 		}
 		p.nextNonSpace()
 	}
-	where := p.nextNonSpace()
-	if where.value == kwWhere {
-		whereExp, err := p.parseExpression(0)
-		if err != nil {
-			return nil, err
-		}
-		stmt.Predicate = whereExp
-	} else if where.tokenType != tkEOF && where.value != ";" {
-		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
-	}
-	return stmt, nil
 }
 
 func (p *parser) parseDelete(sb *StmtBase) (*DeleteStmt, error) {
@@ -440,7 +1084,12 @@ func (p *parser) parseDelete(sb *StmtBase) (*DeleteStmt, error) {
 	if tableName.tokenType != tkIdentifier {
 		return nil, fmt.Errorf(tokenErr, p.tokens[p.end].value)
 	}
-	stmt.TableName = tableName.value
+	schemaName, name, err := p.parseQualifiedName(tableName)
+	if err != nil {
+		return nil, err
+	}
+	stmt.SchemaName = schemaName
+	stmt.TableName = name
 	possibleWhere := p.peekNextNonSpace()
 	if possibleWhere.value == kwWhere {
 		p.nextNonSpace()
@@ -453,6 +1102,51 @@ func (p *parser) parseDelete(sb *StmtBase) (*DeleteStmt, error) {
 	return stmt, nil
 }
 
+func (p *parser) parsePragma(sb *StmtBase) (*PragmaStmt, error) {
+	name := p.nextNonSpace()
+	if name.tokenType != tkIdentifier {
+		return nil, fmt.Errorf(identErr, name.value)
+	}
+	stmt := &PragmaStmt{StmtBase: sb, Name: name.value}
+	if p.peekNextNonSpace().value == OpEq {
+		p.nextNonSpace()
+		value, err := p.parseExpression(0)
+		if err != nil {
+			return nil, err
+		}
+		stmt.Value = value
+	}
+	return stmt, nil
+}
+
+func (p *parser) parseAnalyze(sb *StmtBase) (*AnalyzeStmt, error) {
+	stmt := &AnalyzeStmt{StmtBase: sb}
+	if p.peekNextNonSpace().tokenType == tkIdentifier {
+		schemaName, tableName, err := p.parseQualifiedName(p.nextNonSpace())
+		if err != nil {
+			return nil, err
+		}
+		stmt.SchemaName = schemaName
+		stmt.TableName = tableName
+	}
+	return stmt, nil
+}
+
+// parseQualifiedName parses the rest of a name that may be qualified with a
+// schema for example main.foo where first is the identifier already consumed.
+// schemaName is empty when the name is not qualified.
+func (p *parser) parseQualifiedName(first token) (schemaName, name string, err error) {
+	if p.peekNextNonSpace().value != "." {
+		return "", first.value, nil
+	}
+	p.nextNonSpace()
+	n := p.nextNonSpace()
+	if n.tokenType != tkIdentifier {
+		return "", "", fmt.Errorf(identErr, n.value)
+	}
+	return first.value, n.value, nil
+}
+
 func (p *parser) nextNonSpace() token {
 	p.end = p.end + 1
 	if p.end > len(p.tokens)-1 {
@@ -41,6 +41,69 @@ func TestParseSelect(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with schema qualified names",
+			tokens: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "main"},
+				{tkSeparator, "."},
+				{tkIdentifier, "foo"},
+				{tkSeparator, "."},
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkKeyword, "FROM"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "main"},
+				{tkSeparator, "."},
+				{tkIdentifier, "foo"},
+			},
+			expect: &SelectStmt{
+				StmtBase: &StmtBase{},
+				From: &From{
+					SchemaName: "main",
+					TableName:  "foo",
+				},
+				ResultColumns: []ResultColumn{
+					{
+						Expression: &ColumnRef{
+							Schema: "main",
+							Table:  "foo",
+							Column: "id",
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "with table function",
+			tokens: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkOperator, "*"},
+				{tkWhitespace, " "},
+				{tkKeyword, "FROM"},
+				{tkWhitespace, " "},
+				{tkKeyword, "EXPLAIN"},
+				{tkSeparator, "("},
+				{tkLiteral, "SELECT 1"},
+				{tkSeparator, ")"},
+			},
+			expect: &SelectStmt{
+				StmtBase: &StmtBase{},
+				From: &From{
+					TableName: "explain",
+					Args: []Expr{
+						&StringLit{Value: "SELECT 1"},
+					},
+				},
+				ResultColumns: []ResultColumn{
+					{
+						All: true,
+					},
+				},
+			},
+		},
 		{
 			name: "with explain query plan",
 			tokens: []token{
@@ -222,6 +285,154 @@ func TestParseSelect(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "with order by",
+			tokens: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkOperator, "*"},
+				{tkWhitespace, " "},
+				{tkKeyword, "FROM"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkKeyword, "WHERE"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkOperator, ">"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ORDER"},
+				{tkWhitespace, " "},
+				{tkKeyword, "BY"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "name"},
+				{tkWhitespace, " "},
+				{tkKeyword, "DESC"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ASC"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+			},
+			expect: &SelectStmt{
+				StmtBase: &StmtBase{},
+				ResultColumns: []ResultColumn{
+					{All: true},
+				},
+				From: &From{TableName: "foo"},
+				Where: &BinaryExpr{
+					Left:     &ColumnRef{Column: "id"},
+					Operator: OpGt,
+					Right:    &IntLit{Value: 1},
+				},
+				OrderBy: []OrderingTerm{
+					{Expr: &ColumnRef{Column: "name"}, Desc: true},
+					{Expr: &ColumnRef{Column: "id"}},
+					{Expr: &IntLit{Value: 2}},
+				},
+			},
+		},
+		{
+			name: "with group by",
+			tokens: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "name"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkKeyword, "COUNT"},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ")"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "sum"},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "FROM"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkKeyword, "GROUP"},
+				{tkWhitespace, " "},
+				{tkKeyword, "BY"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "name"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ORDER"},
+				{tkWhitespace, " "},
+				{tkKeyword, "BY"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "name"},
+			},
+			expect: &SelectStmt{
+				StmtBase: &StmtBase{},
+				ResultColumns: []ResultColumn{
+					{Expression: &ColumnRef{Column: "name"}},
+					{Expression: &FunctionExpr{
+						FnType: FnCount,
+						Args:   []Expr{&ColumnRef{Column: "id"}},
+					}},
+					{Expression: &FunctionExpr{
+						FnType: FnSum,
+						Args:   []Expr{&ColumnRef{Column: "id"}},
+					}},
+				},
+				From: &From{TableName: "foo"},
+				GroupBy: []Expr{
+					&ColumnRef{Column: "name"},
+					&IntLit{Value: 2},
+				},
+				OrderBy: []OrderingTerm{
+					{Expr: &ColumnRef{Column: "name"}},
+				},
+			},
+		},
+		{
+			name: "with parenthesized expression",
+			tokens: []token{
+				{tkKeyword, "SELECT"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkOperator, "+"},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkOperator, "*"},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+			},
+			expect: &SelectStmt{
+				StmtBase: &StmtBase{},
+				ResultColumns: []ResultColumn{
+					{
+						Expression: &BinaryExpr{
+							Left: &BinaryExpr{
+								Left:     &IntLit{Value: 1},
+								Operator: OpAdd,
+								Right:    &IntLit{Value: 2},
+							},
+							Operator: OpMul,
+							Right:    &IntLit{Value: 3},
+						},
+					},
+				},
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -330,98 +541,299 @@ func TestParseCreate(t *testing.T) {
 				},
 			},
 		},
-	}
-	for _, c := range cases {
-		t.Run(c.name, func(t *testing.T) {
-			ret, err := NewParser(c.tokens).Parse()
-			if err != nil {
-				t.Errorf("expected no err got err %s", err)
-			}
-			if !reflect.DeepEqual(ret, c.expected) {
-				t.Errorf("expected %#v got %#v", c.expected, ret)
-			}
-		})
-	}
-}
-
-type insertTestCase struct {
-	name     string
-	tokens   []token
-	expected Stmt
-}
-
-func TestParseInsert(t *testing.T) {
-	cases := []insertTestCase{
 		{
-			name: "ManyValues",
+			name: "create with default",
 			tokens: []token{
-				{tkKeyword, "INSERT"},
+				{tkKeyword, "CREATE"},
 				{tkWhitespace, " "},
-				{tkKeyword, "INTO"},
+				{tkKeyword, "TABLE"},
 				{tkWhitespace, " "},
 				{tkIdentifier, "foo"},
 				{tkWhitespace, " "},
 				{tkSeparator, "("},
-				{tkIdentifier, "id"},
-				{tkSeparator, ","},
-				{tkWhitespace, " "},
-				{tkIdentifier, "first_name"},
-				{tkSeparator, ","},
+				{tkIdentifier, "created_at"},
 				{tkWhitespace, " "},
-				{tkIdentifier, "last_name"},
-				{tkSeparator, ")"},
-				{tkWhitespace, " "},
-				{tkKeyword, "VALUES"},
+				{tkKeyword, "TEXT"},
 				{tkWhitespace, " "},
-				{tkSeparator, "("},
-				{tkNumeric, "1"},
-				{tkSeparator, ","},
+				{tkKeyword, "DEFAULT"},
 				{tkWhitespace, " "},
-				{tkLiteral, "gud"},
+				{tkKeyword, "CURRENT_TIMESTAMP"},
 				{tkSeparator, ","},
 				{tkWhitespace, " "},
-				{tkLiteral, "dude"},
-				{tkSeparator, ")"},
-				{tkSeparator, ","},
+				{tkIdentifier, "created_on"},
 				{tkWhitespace, " "},
-				{tkSeparator, "("},
-				{tkNumeric, "2"},
-				{tkSeparator, ","},
+				{tkKeyword, "TEXT"},
 				{tkWhitespace, " "},
-				{tkLiteral, "joe"},
-				{tkSeparator, ","},
+				{tkKeyword, "DEFAULT"},
 				{tkWhitespace, " "},
-				{tkLiteral, "doe"},
+				{tkKeyword, "CURRENT_DATE"},
 				{tkSeparator, ")"},
-				{tkSeparator, ","},
-				{tkWhitespace, " "},
-				{tkSeparator, "("},
-				{tkNumeric, "3"},
-				{tkSeparator, ","},
+			},
+			expected: &CreateStmt{
+				StmtBase:  &StmtBase{},
+				TableName: "foo",
+				ColDefs: []ColDef{
+					{
+						ColName: "created_at",
+						ColType: "TEXT",
+						Default: FnCurrentTimestamp,
+					},
+					{
+						ColName: "created_on",
+						ColType: "TEXT",
+						Default: FnCurrentDate,
+					},
+				},
+			},
+		},
+		{
+			name: "create with autoincrement",
+			tokens: []token{
+				{tkKeyword, "CREATE"},
 				{tkWhitespace, " "},
-				{tkLiteral, "jan"},
-				{tkSeparator, ","},
+				{tkKeyword, "TABLE"},
 				{tkWhitespace, " "},
-				{tkLiteral, "ice"},
-				{tkSeparator, ")"},
-				{tkSeparator, ","},
+				{tkIdentifier, "foo"},
 				{tkWhitespace, " "},
 				{tkSeparator, "("},
-				{tkNumeric, "4"},
-				{tkSeparator, ","},
+				{tkIdentifier, "id"},
 				{tkWhitespace, " "},
-				{tkParam, "?"},
-				{tkSeparator, ","},
+				{tkKeyword, "INTEGER"},
 				{tkWhitespace, " "},
-				{tkParam, "?"},
+				{tkKeyword, "PRIMARY"},
+				{tkWhitespace, " "},
+				{tkKeyword, "KEY"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AUTOINCREMENT"},
 				{tkSeparator, ")"},
 			},
-			expected: &InsertStmt{
-				StmtBase: &StmtBase{
-					Explain: false,
-				},
+			expected: &CreateStmt{
+				StmtBase:  &StmtBase{},
 				TableName: "foo",
-				ColNames: []string{
+				ColDefs: []ColDef{
+					{
+						ColName:       "id",
+						ColType:       "INTEGER",
+						PrimaryKey:    true,
+						AutoIncrement: true,
+					},
+				},
+			},
+		},
+		{
+			name: "create index",
+			tokens: []token{
+				{tkKeyword, "CREATE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INDEX"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "idx"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ON"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "bar"},
+				{tkSeparator, ")"},
+			},
+			expected: &CreateIndexStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				IndexName:  "idx",
+				TableName:  "foo",
+				ColumnName: "bar",
+			},
+		},
+		{
+			name: "create unique index",
+			tokens: []token{
+				{tkKeyword, "CREATE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "UNIQUE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INDEX"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "idx"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ON"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkSeparator, "("},
+				{tkIdentifier, "bar"},
+				{tkSeparator, ")"},
+			},
+			expected: &CreateIndexStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				IndexName:  "idx",
+				TableName:  "foo",
+				ColumnName: "bar",
+				Unique:     true,
+			},
+		},
+		{
+			name: "create index if not exists",
+			tokens: []token{
+				{tkKeyword, "CREATE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INDEX"},
+				{tkWhitespace, " "},
+				{tkKeyword, "IF"},
+				{tkWhitespace, " "},
+				{tkKeyword, "NOT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "EXISTS"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "idx"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ON"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkSeparator, "("},
+				{tkIdentifier, "bar"},
+				{tkSeparator, ")"},
+				{tkSeparator, ";"},
+			},
+			expected: &CreateIndexStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				IfNotExists: true,
+				IndexName:   "idx",
+				TableName:   "foo",
+				ColumnName:  "bar",
+			},
+		},
+		{
+			name: "create index include",
+			tokens: []token{
+				{tkKeyword, "CREATE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INDEX"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "idx"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ON"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkSeparator, "("},
+				{tkIdentifier, "bar"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INCLUDE"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "baz"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "qux"},
+				{tkSeparator, ")"},
+			},
+			expected: &CreateIndexStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				IndexName:  "idx",
+				TableName:  "foo",
+				ColumnName: "bar",
+				Include:    []string{"baz", "qux"},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ret, err := NewParser(c.tokens).Parse()
+			if err != nil {
+				t.Errorf("expected no err got err %s", err)
+			}
+			if !reflect.DeepEqual(ret, c.expected) {
+				t.Errorf("expected %#v got %#v", c.expected, ret)
+			}
+		})
+	}
+}
+
+type insertTestCase struct {
+	name     string
+	tokens   []token
+	expected Stmt
+}
+
+func TestParseInsert(t *testing.T) {
+	cases := []insertTestCase{
+		{
+			name: "ManyValues",
+			tokens: []token{
+				{tkKeyword, "INSERT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INTO"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "first_name"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "last_name"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "VALUES"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "gud"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "dude"},
+				{tkSeparator, ")"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "2"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "joe"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "doe"},
+				{tkSeparator, ")"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "3"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "jan"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "ice"},
+				{tkSeparator, ")"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "4"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkParam, "?"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkParam, "?"},
+				{tkSeparator, ")"},
+			},
+			expected: &InsertStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				TableName: "foo",
+				ColNames: []string{
 					"id",
 					"first_name",
 					"last_name",
@@ -520,6 +932,121 @@ func TestParseInsert(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "without column list",
+			tokens: []token{
+				{tkKeyword, "INSERT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INTO"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkKeyword, "VALUES"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkLiteral, "a"},
+				{tkSeparator, ")"},
+			},
+			expected: &InsertStmt{
+				StmtBase:  &StmtBase{},
+				TableName: "foo",
+				ColValues: [][]Expr{{&IntLit{Value: 1}, &StringLit{Value: "a"}}},
+			},
+		},
+		{
+			name: "or replace",
+			tokens: []token{
+				{tkKeyword, "INSERT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "OR"},
+				{tkWhitespace, " "},
+				{tkKeyword, "REPLACE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INTO"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "VALUES"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkSeparator, ")"},
+				{tkSeparator, ";"},
+			},
+			expected: &InsertStmt{
+				StmtBase:  &StmtBase{},
+				TableName: "foo",
+				ColNames:  []string{"id"},
+				ColValues: [][]Expr{{&IntLit{Value: 1}}},
+				Conflict:  ConflictReplace,
+			},
+		},
+		{
+			name: "on conflict do update",
+			tokens: []token{
+				{tkKeyword, "INSERT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INTO"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkIdentifier, "n"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "VALUES"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "ON"},
+				{tkWhitespace, " "},
+				{tkKeyword, "CONFLICT"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "id"},
+				{tkSeparator, ")"},
+				{tkWhitespace, " "},
+				{tkKeyword, "DO"},
+				{tkWhitespace, " "},
+				{tkKeyword, "UPDATE"},
+				{tkWhitespace, " "},
+				{tkKeyword, "SET"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "n"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkIdentifier, "excluded"},
+				{tkSeparator, "."},
+				{tkIdentifier, "n"},
+			},
+			expected: &InsertStmt{
+				StmtBase:       &StmtBase{},
+				TableName:      "foo",
+				ColNames:       []string{"id", "n"},
+				ColValues:      [][]Expr{{&IntLit{Value: 1}, &IntLit{Value: 2}}},
+				Conflict:       ConflictUpdate,
+				ConflictTarget: "id",
+				ConflictSetList: map[string]Expr{
+					"n": &ColumnRef{Table: "excluded", Column: "n"},
+				},
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.name, func(t *testing.T) {
@@ -634,6 +1161,61 @@ func TestParseUpdate(t *testing.T) {
 				},
 			},
 		},
+		{
+			caseName: "with where and limit",
+			tokens: []token{
+				{tkKeyword, "UPDATE"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "foo"},
+				{tkWhitespace, " "},
+				{tkKeyword, "SET"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "age"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkIdentifier, "age"},
+				{tkWhitespace, " "},
+				{tkOperator, "+"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "WHERE"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkOperator, ">"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "LIMIT"},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+			},
+			expected: &UpdateStmt{
+				StmtBase: &StmtBase{
+					Explain: false,
+				},
+				TableName: "foo",
+				SetList: map[string]Expr{
+					"age": &BinaryExpr{
+						Left:     &ColumnRef{Column: "age"},
+						Operator: OpAdd,
+						Right:    &IntLit{Value: 1},
+					},
+				},
+				Predicate: &BinaryExpr{
+					Left: &ColumnRef{
+						Column: "id",
+					},
+					Operator: OpGt,
+					Right: &IntLit{
+						Value: 1,
+					},
+				},
+				Limit: &IntLit{Value: 2},
+			},
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.caseName, func(t *testing.T) {
@@ -690,19 +1272,204 @@ func TestParseDelete(t *testing.T) {
 			},
 		},
 	}
-	for _, c := range cases {
-		t.Run(c.caseName, func(t *testing.T) {
-			ret, err := NewParser(c.tokens).Parse()
-			if err != nil {
-				t.Errorf("expected no err got err %s", err)
-			}
-			if !reflect.DeepEqual(ret, c.expected) {
-				t.Errorf("expected %#v got %#v", c.expected, ret)
+	for _, c := range cases {
+		t.Run(c.caseName, func(t *testing.T) {
+			ret, err := NewParser(c.tokens).Parse()
+			if err != nil {
+				t.Errorf("expected no err got err %s", err)
+			}
+			if !reflect.DeepEqual(ret, c.expected) {
+				t.Errorf("expected %#v got %#v", c.expected, ret)
+			}
+		})
+	}
+}
+
+func TestParsePragma(t *testing.T) {
+	tokens := []token{
+		{tkKeyword, "PRAGMA"},
+		{tkWhitespace, " "},
+		{tkIdentifier, "transaction_state"},
+	}
+	ret, err := NewParser(tokens).Parse()
+	if err != nil {
+		t.Fatalf("expected no err got err %s", err)
+	}
+	expected := &PragmaStmt{StmtBase: &StmtBase{}, Name: "transaction_state"}
+	if !reflect.DeepEqual(ret, expected) {
+		t.Errorf("expected %#v got %#v", expected, ret)
+	}
+
+	t.Run("value", func(t *testing.T) {
+		ret, err := NewParser(NewLexer("PRAGMA cache_size = -2000;").ToStatements()[0]).Parse()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		expected := &PragmaStmt{StmtBase: &StmtBase{}, Name: "cache_size", Value: &IntLit{Value: -2000}}
+		if !reflect.DeepEqual(ret, expected) {
+			t.Errorf("expected %#v got %#v", expected, ret)
+		}
+	})
+}
+
+func TestParseAnalyze(t *testing.T) {
+	cases := map[string]*AnalyzeStmt{
+		"ANALYZE;":     {StmtBase: &StmtBase{}},
+		"ANALYZE foo;": {StmtBase: &StmtBase{}, TableName: "foo"},
+	}
+	for sql, expected := range cases {
+		t.Run(sql, func(t *testing.T) {
+			ret, err := NewParser(NewLexer(sql).ToStatements()[0]).Parse()
+			if err != nil {
+				t.Fatalf("expected no err got err %s", err)
+			}
+			if !reflect.DeepEqual(ret, expected) {
+				t.Errorf("expected %#v got %#v", expected, ret)
+			}
+		})
+	}
+}
+
+func TestParseSchemaQualifiedTables(t *testing.T) {
+	cases := []string{
+		"CREATE TABLE main.foo (id INTEGER PRIMARY KEY);",
+		"CREATE INDEX main.foo ON bar (baz);",
+		"INSERT INTO main.foo (id) VALUES (1);",
+		"UPDATE main.foo SET id = 1;",
+		"DELETE FROM main.foo;",
+		"ANALYZE main.foo;",
+		"SELECT * FROM main.foo;",
+	}
+	for _, sql := range cases {
+		t.Run(sql, func(t *testing.T) {
+			ret, err := NewParser(NewLexer(sql).ToStatements()[0]).Parse()
+			if err != nil {
+				t.Fatalf("expected no err got err %s", err)
+			}
+			var schemaName, name string
+			switch s := ret.(type) {
+			case *CreateStmt:
+				schemaName, name = s.SchemaName, s.TableName
+			case *CreateIndexStmt:
+				schemaName, name = s.SchemaName, s.IndexName
+			case *InsertStmt:
+				schemaName, name = s.SchemaName, s.TableName
+			case *UpdateStmt:
+				schemaName, name = s.SchemaName, s.TableName
+			case *DeleteStmt:
+				schemaName, name = s.SchemaName, s.TableName
+			case *AnalyzeStmt:
+				schemaName, name = s.SchemaName, s.TableName
+			case *SelectStmt:
+				schemaName, name = s.From.SchemaName, s.From.TableName
+			}
+			if schemaName != "main" || name != "foo" {
+				t.Fatalf("expected main.foo got %s.%s", schemaName, name)
+			}
+		})
+	}
+}
+
+func TestParseJoin(t *testing.T) {
+	sql := "SELECT * FROM foo, bar JOIN baz ON baz.id = foo.id LEFT OUTER JOIN generate_series(1, 2) CROSS JOIN main.qux WHERE 1;"
+	ret, err := NewParser(NewLexer(sql).ToStatements()[0]).Parse()
+	if err != nil {
+		t.Fatalf("expected no err got err %s", err)
+	}
+	expected := &SelectStmt{
+		StmtBase: &StmtBase{},
+		From: &From{
+			TableName: "foo",
+			Joins: []Join{
+				{TableName: "bar"},
+				{
+					TableName: "baz",
+					On: &BinaryExpr{
+						Left:     &ColumnRef{Table: "baz", Column: "id"},
+						Operator: OpEq,
+						Right:    &ColumnRef{Table: "foo", Column: "id"},
+					},
+				},
+				{
+					Left:      true,
+					TableName: "generate_series",
+					Args:      []Expr{&IntLit{Value: 1}, &IntLit{Value: 2}},
+				},
+				{SchemaName: "main", TableName: "qux"},
+			},
+		},
+		ResultColumns: []ResultColumn{{All: true}},
+		Where:         &IntLit{Value: 1},
+	}
+	if !reflect.DeepEqual(ret, expected) {
+		t.Fatalf("got %#v want %#v", ret, expected)
+	}
+}
+
+func TestParseTrailingTokens(t *testing.T) {
+	cases := []string{
+		"SELECT * FROM foo bar;",
+		"SELECT * FROM foo LEFT bar;",
+		"SELECT * FROM foo JOIN bar ON 1 baz;",
+		"SELECT * FROM foo WHERE 1 2;",
+		"SELECT * FROM foo ORDER BY id GROUP BY id;",
+		"WITH t AS (SELECT 1) SELECT * FROM t t;",
+		"CREATE TABLE foo (id INTEGER PRIMARY KEY) bar;",
+		"CREATE INDEX idx ON foo (name) bar;",
+		"INSERT INTO foo (id) VALUES (1) bar;",
+		"UPDATE foo SET id = 1 WHERE id = 2 bar;",
+		"DELETE FROM foo WHERE id = 1 bar;",
+		"PRAGMA foo bar;",
+		"ANALYZE foo bar;",
+	}
+	for _, sql := range cases {
+		t.Run(sql, func(t *testing.T) {
+			_, err := NewParser(NewLexer(sql).ToStatements()[0]).Parse()
+			if err == nil {
+				t.Fatal("expected err got nil")
 			}
 		})
 	}
 }
 
+func TestParseWith(t *testing.T) {
+	sql := "WITH t (a) AS MATERIALIZED (SELECT name FROM foo WHERE name = 'x''y') SELECT a FROM t;"
+	ret, err := NewParser(NewLexer(sql).ToStatements()[0]).Parse()
+	if err != nil {
+		t.Fatalf("expected no err got err %s", err)
+	}
+	expected := &SelectStmt{
+		StmtBase: &StmtBase{},
+		From:     &From{TableName: "t"},
+		ResultColumns: []ResultColumn{
+			{Expression: &ColumnRef{Column: "a"}},
+		},
+		With: []CommonTableExpr{
+			{
+				Name:         "t",
+				Columns:      []string{"a"},
+				Materialized: true,
+				Select: &SelectStmt{
+					StmtBase: &StmtBase{},
+					From:     &From{TableName: "foo"},
+					ResultColumns: []ResultColumn{
+						{Expression: &ColumnRef{Column: "name"}},
+					},
+					Where: &BinaryExpr{
+						Left:     &ColumnRef{Column: "name"},
+						Operator: OpEq,
+						Right:    &StringLit{Value: "x'y"},
+					},
+				},
+				SQL: "SELECT name FROM foo WHERE name = 'x''y'",
+			},
+		},
+	}
+	if !reflect.DeepEqual(ret, expected) {
+		t.Errorf("expected %#v got %#v", expected, ret)
+	}
+}
+
 type resultColumnTestCase struct {
 	name   string
 	tokens []token
@@ -757,6 +1524,66 @@ func TestParseResultColumn(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "real",
+			tokens: []token{
+				{tkNumeric, "1.5"},
+				{tkSeparator, ","},
+				{tkWhitespace, " "},
+				{tkOperator, "-"},
+				{tkNumeric, "2.5e3"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &FloatLit{Value: 1.5},
+				},
+				{
+					Expression: &FloatLit{Value: -2500},
+				},
+			},
+		},
+		{
+			name: "CAST('1' AS integer)",
+			tokens: []token{
+				{tkKeyword, "CAST"},
+				{tkSeparator, "("},
+				{tkLiteral, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AS"},
+				{tkWhitespace, " "},
+				{tkKeyword, "INTEGER"},
+				{tkSeparator, ")"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &CastExpr{
+						Expr:     &StringLit{Value: "1"},
+						TypeName: "INTEGER",
+					},
+				},
+			},
+		},
+		{
+			name: "CAST(1 AS real)",
+			tokens: []token{
+				{tkKeyword, "CAST"},
+				{tkSeparator, "("},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AS"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "real"},
+				{tkSeparator, ")"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &CastExpr{
+						Expr:     &IntLit{Value: 1},
+						TypeName: "REAL",
+					},
+				},
+			},
+		},
 		{
 			name: "COUNT(*) + 1",
 			tokens: []token{
@@ -854,6 +1681,283 @@ func TestParseResultColumn(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "1 | ~2 & 3 << 4 + 5",
+			tokens: []token{
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkOperator, "|"},
+				{tkWhitespace, " "},
+				{tkOperator, "~"},
+				{tkNumeric, "2"},
+				{tkWhitespace, " "},
+				{tkOperator, "&"},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+				{tkWhitespace, " "},
+				{tkOperator, "<<"},
+				{tkWhitespace, " "},
+				{tkNumeric, "4"},
+				{tkWhitespace, " "},
+				{tkOperator, "+"},
+				{tkWhitespace, " "},
+				{tkNumeric, "5"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &BinaryExpr{
+						Left: &BinaryExpr{
+							Left: &BinaryExpr{
+								Left:     &IntLit{Value: 1},
+								Operator: OpBitOr,
+								Right: &UnaryExpr{
+									Operator: OpBitNot,
+									Operand:  &IntLit{Value: 2},
+								},
+							},
+							Operator: OpBitAnd,
+							Right:    &IntLit{Value: 3},
+						},
+						Operator: OpShiftLeft,
+						Right: &BinaryExpr{
+							Left:     &IntLit{Value: 4},
+							Operator: OpAdd,
+							Right:    &IntLit{Value: 5},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "1 <> 2 = 3 <= 4",
+			tokens: []token{
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkOperator, "<>"},
+				{tkWhitespace, " "},
+				{tkNumeric, "2"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkNumeric, "3"},
+				{tkWhitespace, " "},
+				{tkOperator, "<="},
+				{tkWhitespace, " "},
+				{tkNumeric, "4"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &BinaryExpr{
+						Left: &BinaryExpr{
+							Left:     &IntLit{Value: 1},
+							Operator: OpNe,
+							Right:    &IntLit{Value: 2},
+						},
+						Operator: OpEq,
+						Right: &BinaryExpr{
+							Left:     &IntLit{Value: 3},
+							Operator: OpLte,
+							Right:    &IntLit{Value: 4},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "NULL",
+			tokens: []token{
+				{tkKeyword, "NULL"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &NullLit{},
+				},
+			},
+		},
+		{
+			name: "id + 1 IS NULL",
+			tokens: []token{
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkOperator, "+"},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "IS"},
+				{tkWhitespace, " "},
+				{tkKeyword, "NULL"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &UnaryExpr{
+						Operator: OpIsNull,
+						Operand: &BinaryExpr{
+							Left:     &ColumnRef{Column: "id"},
+							Operator: OpAdd,
+							Right:    &IntLit{Value: 1},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "id = 1 IS NOT NULL",
+			tokens: []token{
+				{tkIdentifier, "id"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "IS"},
+				{tkWhitespace, " "},
+				{tkKeyword, "NOT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "NULL"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &UnaryExpr{
+						Operator: OpNotNull,
+						Operand: &BinaryExpr{
+							Left:     &ColumnRef{Column: "id"},
+							Operator: OpEq,
+							Right:    &IntLit{Value: 1},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "a = 1 OR b AND (c OR d)",
+			tokens: []token{
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "OR"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "b"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AND"},
+				{tkWhitespace, " "},
+				{tkSeparator, "("},
+				{tkIdentifier, "c"},
+				{tkWhitespace, " "},
+				{tkKeyword, "OR"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "d"},
+				{tkSeparator, ")"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &BinaryExpr{
+						Left: &BinaryExpr{
+							Left:     &ColumnRef{Column: "a"},
+							Operator: OpEq,
+							Right:    &IntLit{Value: 1},
+						},
+						Operator: OpOr,
+						Right: &BinaryExpr{
+							Left:     &ColumnRef{Column: "b"},
+							Operator: OpAnd,
+							Right: &BinaryExpr{
+								Left:     &ColumnRef{Column: "c"},
+								Operator: OpOr,
+								Right:    &ColumnRef{Column: "d"},
+							},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "NOT a = 1 AND -b * -2",
+			tokens: []token{
+				{tkKeyword, "NOT"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkOperator, "="},
+				{tkWhitespace, " "},
+				{tkNumeric, "1"},
+				{tkWhitespace, " "},
+				{tkKeyword, "AND"},
+				{tkWhitespace, " "},
+				{tkOperator, "-"},
+				{tkIdentifier, "b"},
+				{tkWhitespace, " "},
+				{tkOperator, "*"},
+				{tkWhitespace, " "},
+				{tkOperator, "-"},
+				{tkNumeric, "2"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &BinaryExpr{
+						Left: &UnaryExpr{
+							Operator: OpNot,
+							Operand: &BinaryExpr{
+								Left:     &ColumnRef{Column: "a"},
+								Operator: OpEq,
+								Right:    &IntLit{Value: 1},
+							},
+						},
+						Operator: OpAnd,
+						Right: &BinaryExpr{
+							Left: &UnaryExpr{
+								Operator: OpNeg,
+								Operand:  &ColumnRef{Column: "b"},
+							},
+							Operator: OpMul,
+							Right:    &IntLit{Value: -2},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "a NOT LIKE 'b%' OR a LIKE 'c'",
+			tokens: []token{
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkKeyword, "NOT"},
+				{tkWhitespace, " "},
+				{tkKeyword, "LIKE"},
+				{tkWhitespace, " "},
+				{tkLiteral, "b%"},
+				{tkWhitespace, " "},
+				{tkKeyword, "OR"},
+				{tkWhitespace, " "},
+				{tkIdentifier, "a"},
+				{tkWhitespace, " "},
+				{tkKeyword, "LIKE"},
+				{tkWhitespace, " "},
+				{tkLiteral, "c"},
+			},
+			expect: []ResultColumn{
+				{
+					Expression: &BinaryExpr{
+						Left: &UnaryExpr{
+							Operator: OpNot,
+							Operand: &BinaryExpr{
+								Left:     &ColumnRef{Column: "a"},
+								Operator: OpLike,
+								Right:    &StringLit{Value: "b%"},
+							},
+						},
+						Operator: OpOr,
+						Right: &BinaryExpr{
+							Left:     &ColumnRef{Column: "a"},
+							Operator: OpLike,
+							Right:    &StringLit{Value: "c"},
+						},
+					},
+				},
+			},
+		},
 		{
 			name: "foo.id AS bar",
 			tokens: []token{
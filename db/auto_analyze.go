@@ -0,0 +1,123 @@
+package db
+
+import (
+	"context"
+	"sync"
+
+	"github.com/chirst/cdb/vm"
+)
+
+// The statistics of an analyzed table are refreshed once the number of rows
+// inserted, updated and deleted since it was analyzed passes a threshold. The
+// threshold grows with the size of the table so a large table is not analyzed
+// again for a handful of changes.
+const (
+	// autoAnalyzeMinChanges is the least number of changes that refresh the
+	// statistics of a table.
+	autoAnalyzeMinChanges = 50
+	// autoAnalyzeFraction is the fraction of the rows counted by the last
+	// ANALYZE that also have to change before the statistics are refreshed.
+	autoAnalyzeFraction = 0.1
+)
+
+// changeCounter counts the rows inserted, updated and deleted in each table
+// since the statistics of the table were last refreshed. The counts are only
+// kept in memory and are approximate. Changes of a transaction that rolls back
+// are still counted which at worst refreshes the statistics early.
+// changeCounter is safe for concurrent use.
+type changeCounter struct {
+	mu      sync.Mutex
+	changes map[string]int
+	// refreshing is true while the statistics of drifted tables are being
+	// refreshed so only one refresh runs at a time.
+	refreshing bool
+}
+
+func newChangeCounter() *changeCounter {
+	return &changeCounter{
+		changes: map[string]int{},
+	}
+}
+
+// add counts the rows changed by executing plan.
+func (c *changeCounter) add(plan *vm.ExecutionPlan, res *vm.ExecuteResult) {
+	if plan.ChangedTable == "" || plan.Explain || res.Err != nil || res.RowsAffected == 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.changes[plan.ChangedTable] += res.RowsAffected
+}
+
+// drifted returns the tables with statistics that changed past the threshold
+// and resets their counts. Tables without statistics are forgotten since the
+// planner does not use statistics for them. When tables are returned the
+// counter is refreshing until refreshed is called and drifted returns no
+// tables in the meantime, leaving their counts for the next refresh.
+func (c *changeCounter) drifted(catalog dbCatalog) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.refreshing {
+		return nil
+	}
+	tableNames := []string{}
+	for tableName, changes := range c.changes {
+		stat, ok := catalog.GetTableStat(tableName)
+		if !ok {
+			delete(c.changes, tableName)
+			continue
+		}
+		if float64(changes) > autoAnalyzeMinChanges+autoAnalyzeFraction*float64(stat.RowCount) {
+			delete(c.changes, tableName)
+			tableNames = append(tableNames, tableName)
+		}
+	}
+	c.refreshing = len(tableNames) != 0
+	return tableNames
+}
+
+// refreshed ends the refresh begun by drifted.
+func (c *changeCounter) refreshed() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.refreshing = false
+}
+
+// refreshStats analyzes the tables that changed past the threshold since their
+// statistics were gathered. It is called once the transaction that made the
+// changes has ended and returns without waiting for the tables to be analyzed
+// so the statement that passed the threshold does not pay for the ANALYZE.
+// The tables are analyzed by a goroutine, each in a transaction of its own
+// bounded by the busy and query timeouts of the database. A table that fails
+// to be analyzed is logged and analyzed again once it changes past the
+// threshold again.
+func (db *DB) refreshStats() {
+	tableNames := db.changes.drifted(db.catalog)
+	if len(tableNames) == 0 {
+		return
+	}
+	db.refreshes.Add(1)
+	go func() {
+		defer db.refreshes.Done()
+		defer db.changes.refreshed()
+		for _, tableName := range tableNames {
+			db.analyze(tableName)
+		}
+	}()
+}
+
+// analyze refreshes the statistics of tableName.
+func (db *DB) analyze(tableName string) {
+	ctx, cancel := db.statementContext(context.Background(), 0, 0)
+	defer cancel()
+	statements := db.Tokenize("ANALYZE " + tableName)
+	res := db.record(db.execute(ctx, statements[0], false, nil, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+		return db.vm.ExecuteContext(ctx, plan, []any{})
+	}))
+	if res.Err != nil {
+		db.logger.Warn("refreshing statistics failed", "table", tableName, "err", res.Err)
+		return
+	}
+	db.metrics.StatsRefreshes.Inc()
+	db.logger.Debug("refreshed statistics", "table", tableName)
+}
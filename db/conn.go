@@ -0,0 +1,170 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// ErrInTransaction is the err of Begin when the connection already has a
+// transaction in progress.
+var ErrInTransaction = errors.New("connection already has a transaction in progress")
+
+// ErrNoTransaction is the err of Commit or Rollback when the connection has no
+// transaction in progress.
+var ErrNoTransaction = errors.New("connection has no transaction in progress")
+
+// Conn is a connection to a DB with its own prepared statements and
+// transaction. Many goroutines can each hold a Conn to the same DB. A statement
+// executed with a Conn that has no transaction in progress runs in a
+// transaction of its own, the same as a statement executed with the DB.
+//
+// While a Conn has a write transaction in progress statements that write with
+// the DB or another Conn wait for it to end. Statements that read see the
+// database as it was before the transaction. A Conn is not safe for concurrent
+// use.
+type Conn struct {
+	db *DB
+	// tx is the transaction begun by Begin. tx is nil when no transaction is
+	// in progress.
+	tx *transaction
+	// txReadOnly is true when tx rejects statements that write.
+	txReadOnly bool
+	// txCancel releases the context of tx.
+	txCancel context.CancelFunc
+}
+
+// Conn returns a new connection to the database.
+func (db *DB) Conn() *Conn {
+	return &Conn{db: db}
+}
+
+// Prepare is DB.NewPreparedStatement for a statement that executes within the
+// transaction of the connection when one is in progress.
+func (c *Conn) Prepare(sql string) (*PreparedStatement, error) {
+	ps, err := c.db.NewPreparedStatement(sql)
+	if err != nil {
+		return nil, err
+	}
+	ps.conn = c
+	return ps, nil
+}
+
+// Begin begins a transaction. Every statement executed with the connection
+// until Commit or Rollback is part of the transaction. The transaction begins
+// with the first statement and lasts until Commit or Rollback. A transaction
+// that is not readOnly holds the write lock of the database for that time.
+// When readOnly is true a statement that would write is rejected with
+// ErrReadOnly and every statement reads the same snapshot of the database.
+//
+// ctx, along with the busy and query timeouts of the database, bounds the
+// whole transaction rather than the context given to each statement.
+func (c *Conn) Begin(ctx context.Context, readOnly bool) error {
+	if c.tx != nil {
+		return ErrInTransaction
+	}
+	ctx, cancel := c.db.statementContext(ctx, 0, 0)
+	c.tx = c.db.newTransaction(ctx, !readOnly)
+	c.txReadOnly = readOnly
+	c.txCancel = cancel
+	return nil
+}
+
+// Commit commits the transaction of the connection. If the commit fails the
+// transaction is rolled back.
+func (c *Conn) Commit() error {
+	if c.tx == nil {
+		return ErrNoTransaction
+	}
+	defer c.endTransaction()
+	if err := c.tx.script.Commit(); err != nil {
+		return err
+	}
+	c.db.refreshStats()
+	return nil
+}
+
+// Rollback rolls back the transaction of the connection.
+func (c *Conn) Rollback() error {
+	if c.tx == nil {
+		return ErrNoTransaction
+	}
+	c.endTransaction()
+	return nil
+}
+
+// InTransaction returns true when the connection has a transaction in
+// progress.
+func (c *Conn) InTransaction() bool {
+	return c.tx != nil
+}
+
+// Close rolls back the transaction of the connection when one is in progress.
+func (c *Conn) Close() error {
+	if c.tx != nil {
+		return c.Rollback()
+	}
+	return nil
+}
+
+func (c *Conn) endTransaction() {
+	c.tx.end()
+	c.txCancel()
+	c.tx = nil
+	c.txReadOnly = false
+	c.txCancel = nil
+}
+
+// ExecuteContext is DB.ExecuteContext within the transaction of the connection
+// when one is in progress. When a statement within the transaction fails the
+// transaction is rolled back and ended.
+func (c *Conn) ExecuteContext(ctx context.Context, statement compiler.Statement, params []any) vm.ExecuteResult {
+	if c.tx == nil {
+		return c.db.ExecuteContext(ctx, statement, params)
+	}
+	tx := c.tx
+	res := c.db.record(c.db.execute(ctx, statement, c.txReadOnly, tx, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+		return tx.script.Execute(plan, params)
+	}))
+	if res.Err != nil {
+		c.Rollback()
+	}
+	return res
+}
+
+// Query is DB.Query within the transaction of the connection when one is in
+// progress. Within a transaction the rows are read before Query returns since
+// the transaction may execute another statement before the rows are closed.
+func (c *Conn) Query(ctx context.Context, statement compiler.Statement, params []any) (*Rows, error) {
+	if c.tx == nil {
+		return c.db.Query(ctx, statement, params)
+	}
+	res := c.ExecuteContext(ctx, statement, params)
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	return &Rows{Rows: bufferedRows(res), cancel: func() {}}, nil
+}
+
+// executeMany is PreparedStatement.ExecuteMany within the transaction of the
+// connection.
+func (c *Conn) executeMany(statement compiler.Statement, batches [][]any) vm.ExecuteResult {
+	result := vm.ExecuteResult{ResultRows: [][]vm.Value{}}
+	for _, params := range batches {
+		res := c.ExecuteContext(context.Background(), statement, params)
+		if res.Err != nil {
+			return res
+		}
+		result.ResultHeader = res.ResultHeader
+		result.ResultTypes = res.ResultTypes
+		result.ResultOrigins = res.ResultOrigins
+		result.ResultRows = append(result.ResultRows, res.ResultRows...)
+		result.RowsAffected += res.RowsAffected
+		if res.LastInsertRowID != 0 {
+			result.LastInsertRowID = res.LastInsertRowID
+		}
+	}
+	return result
+}
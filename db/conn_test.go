@@ -0,0 +1,147 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConn(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	ctx := context.Background()
+	count := func() int {
+		return mustExecute(t, db, "SELECT COUNT(*) FROM foo;").ResultRows[0][0].Int()
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		c := db.Conn()
+		if err := c.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		insert, err := c.Prepare("INSERT INTO foo (name) VALUES (?);")
+		if err != nil {
+			t.Fatal(err)
+		}
+		insert.Args = []any{"a"}
+		if res := insert.Execute(); res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res := insert.ExecuteMany([][]any{{"b"}, {"c"}}); res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		rows, err := c.Query(ctx, db.Tokenize("SELECT name FROM foo;")[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		n := 0
+		for rows.Next() {
+			n += 1
+		}
+		rows.Close()
+		if n != 3 {
+			t.Fatalf("expected transaction to see 3 rows got %d", n)
+		}
+		if err := c.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		c := db.Conn()
+		if err := c.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		c.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (name) VALUES ('d');")[0], nil)
+		if err := c.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+		if err := c.Rollback(); !errors.Is(err, ErrNoTransaction) {
+			t.Fatalf("expected ErrNoTransaction got %v", err)
+		}
+	})
+
+	t.Run("failed statement rolls back transaction", func(t *testing.T) {
+		c := db.Conn()
+		if err := c.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		c.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (name) VALUES ('e');")[0], nil)
+		res := c.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (id, name) VALUES (1, 'duplicate');")[0], nil)
+		if res.Err == nil {
+			t.Fatal("expected err for duplicate primary key")
+		}
+		if c.InTransaction() || db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+		if got := count(); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		c := db.Conn()
+		if err := c.Begin(ctx, true); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		if err := c.Begin(ctx, true); !errors.Is(err, ErrInTransaction) {
+			t.Fatalf("expected ErrInTransaction got %v", err)
+		}
+		res := c.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (name) VALUES ('f');")[0], nil)
+		if !errors.Is(res.Err, ErrReadOnly) {
+			t.Fatalf("expected ErrReadOnly got %v", res.Err)
+		}
+	})
+
+	t.Run("common table within transaction", func(t *testing.T) {
+		c := db.Conn()
+		if err := c.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Close()
+		c.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (name) VALUES ('g');")[0], nil)
+		res := c.ExecuteContext(ctx, db.Tokenize("WITH f AS (SELECT name FROM foo) SELECT * FROM f;")[0], nil)
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if got := len(res.ResultRows); got != 4 {
+			t.Fatalf("expected 4 rows got %d", got)
+		}
+	})
+
+	t.Run("other connections read snapshot during transaction", func(t *testing.T) {
+		c1 := db.Conn()
+		if err := c1.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		c1.ExecuteContext(ctx, db.Tokenize("INSERT INTO foo (name) VALUES ('h');")[0], nil)
+		c2 := db.Conn()
+		waitCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		res := c2.ExecuteContext(waitCtx, db.Tokenize("SELECT * FROM foo;")[0], nil)
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if got := len(res.ResultRows); got != 3 {
+			t.Fatalf("expected reader to see 3 rows got %d", got)
+		}
+		res = c2.ExecuteContext(waitCtx, db.Tokenize("INSERT INTO foo (name) VALUES ('i');")[0], nil)
+		if !errors.Is(res.Err, ErrBusy) {
+			t.Fatalf("expected ErrBusy got %v", res.Err)
+		}
+		if err := c1.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 4 {
+			t.Fatalf("expected 4 rows got %d", got)
+		}
+	})
+}
@@ -4,18 +4,119 @@
 package db
 
 import (
+	"context"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/logging"
+	"github.com/chirst/cdb/metrics"
+	"github.com/chirst/cdb/pager"
 	"github.com/chirst/cdb/planner"
 	"github.com/chirst/cdb/vm"
 )
 
 type executor interface {
-	Execute(*vm.ExecutionPlan, []any) *vm.ExecuteResult
+	ExecuteContext(context.Context, *vm.ExecutionPlan, []any) *vm.ExecuteResult
+	ExecuteMany(context.Context, *vm.ExecutionPlan, [][]any) *vm.ExecuteResult
+	Query(context.Context, *vm.ExecutionPlan, []any) (*vm.Rows, error)
+	NewScript(context.Context, bool) *vm.Script
+	Interrupt()
+}
+
+// ErrBusy is the err of a statement that could not begin its transaction
+// before its context was done because another transaction holds the lock.
+var ErrBusy = pager.ErrBusy
+
+// ErrCorrupt is matched by the err of a statement that read a corrupt page of
+// the database file. See IntegrityCheck.
+var ErrCorrupt = pager.ErrCorrupt
+
+// ErrEncrypted is the err of opening an encrypted database without
+// WithEncryptionKey.
+var ErrEncrypted = pager.ErrEncrypted
+
+// ErrNotEncrypted is the err of opening a database that is not encrypted with
+// WithEncryptionKey or of rekeying it.
+var ErrNotEncrypted = pager.ErrNotEncrypted
+
+// ErrWrongKey is the err of opening an encrypted database with a key other than
+// the key it is encrypted with.
+var ErrWrongKey = pager.ErrWrongKey
+
+// ErrInterrupted is the err of a statement that was stopped by Interrupt.
+var ErrInterrupted = vm.ErrInterrupted
+
+// ErrTimeout is the err of a statement that ran longer than its query timeout.
+// See SetQueryTimeout.
+var ErrTimeout = vm.ErrTimeout
+
+// ErrReadOnly is the err of a statement given to ExecuteReadOnly that would
+// write to the database.
+var ErrReadOnly = errors.New("statement is not read only")
+
+// TransactionState is the kind of transaction in progress on the database.
+type TransactionState = pager.TransactionState
+
+const (
+	// TransactionNone is when no transaction is in progress.
+	TransactionNone = pager.TransactionNone
+	// TransactionRead is when one or more read transactions are in progress.
+	TransactionRead = pager.TransactionRead
+	// TransactionWrite is when a write transaction is in progress.
+	TransactionWrite = pager.TransactionWrite
+)
+
+type transactionInspector interface {
+	TransactionState() (pager.TransactionState, int)
+}
+
+type digester interface {
+	Digest(context.Context) ([]byte, error)
+}
+
+type integrityChecker interface {
+	IntegrityCheck(context.Context) ([]string, error)
+}
+
+type rekeyer interface {
+	Rekey(context.Context, []byte) error
+}
+
+type schemaRefresher interface {
+	RefreshSchema(context.Context) error
+}
+
+// CachePolicy is the replacement policy of the page cache which chooses the
+// pages evicted when the cache is full.
+type CachePolicy = pager.CachePolicy
+
+const (
+	// CachePolicyLRU evicts the least recently used page. It is the default.
+	CachePolicyLRU = pager.CachePolicyLRU
+	// CachePolicy2Q evicts the pages read once before the pages read again so
+	// a scan of a large table does not evict the index and inner pages that
+	// are read constantly.
+	CachePolicy2Q = pager.CachePolicy2Q
+)
+
+type pageCache interface {
+	SetCacheMemory(int)
+	CacheMemory() (int, int)
+	SetCachePolicy(pager.CachePolicy)
+}
+
+type transactionHooks interface {
+	SetCommitHook(func() error)
+	SetRollbackHook(func())
 }
 
 type statementPlanner interface {
@@ -26,28 +127,385 @@ type statementPlanner interface {
 type dbCatalog interface {
 	GetColumns(string) ([]string, error)
 	GetColumnType(string, string) (catalog.CdbType, error)
+	GetColumnDefault(string, string) (string, error)
+	IsAutoIncrement(string) bool
 	GetRootPageNumber(string) (int, error)
 	TableExists(string) bool
+	IndexExists(string) bool
+	GetIndexes(string) []catalog.Index
 	GetVersion() string
+	GetGeneration(string) int
 	GetPrimaryKeyColumn(string) (string, error)
+	GetTableNames() []string
+	GetObjects(catalog.SchemaOrder) []catalog.Object
+	GetTableStat(string) (catalog.Stat, bool)
+	GetIndexStat(string) (catalog.Stat, bool)
 }
 
 type DB struct {
-	vm        executor
-	catalog   dbCatalog
+	vm           executor
+	catalog      dbCatalog
+	transactions transactionInspector
+	hooks        transactionHooks
+	digester     digester
+	checker      integrityChecker
+	rekeyer      rekeyer
+	schema       schemaRefresher
+	cache        pageCache
+	metrics      *metrics.Registry
+	logger       logging.Logger
+	plans        *planCache
+	changes      *changeCounter
+	// refreshes tracks the goroutines started by refreshStats.
+	refreshes sync.WaitGroup
 	UseMemory bool
+	// busyTimeout is the time.Duration set by SetBusyTimeout.
+	busyTimeout atomic.Int64
+	// queryTimeout is the time.Duration set by SetQueryTimeout.
+	queryTimeout atomic.Int64
 }
 
-func New(useMemory bool, filename string) (*DB, error) {
-	kv, err := kv.New(useMemory, filename)
+// Option configures optional behavior of a DB.
+type Option func(*options)
+
+type options struct {
+	logger       logging.Logger
+	storage      pager.Storage
+	busyTimeout  time.Duration
+	queryTimeout time.Duration
+	planCache    int
+	// cacheSize is the size given to SetCacheSize. 0 is the default size.
+	cacheSize   int
+	cachePolicy CachePolicy
+	// dirtyPageLimit is the limit given to WithDirtyPageLimit. 0 is no limit.
+	dirtyPageLimit int
+	mmap           bool
+	// encryptionKey is the key given to WithEncryptionKey. It is nil when the
+	// database is not encrypted.
+	encryptionKey []byte
+}
+
+// WithStorage sets the storage the database reads and writes instead of the
+// storage chosen by useMemory and filename. This is useful for testing
+// purposes such as injecting storage faults.
+func WithStorage(storage pager.Storage) Option {
+	return func(o *options) {
+		o.storage = storage
+	}
+}
+
+// WithLogger sets the logger the database reports to. By default nothing is
+// logged.
+func WithLogger(logger logging.Logger) Option {
+	return func(o *options) {
+		o.logger = logger
+	}
+}
+
+// WithBusyTimeout sets the busy timeout of the database. See SetBusyTimeout.
+func WithBusyTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.busyTimeout = d
+	}
+}
+
+// WithQueryTimeout sets the query timeout of the database. See
+// SetQueryTimeout.
+func WithQueryTimeout(d time.Duration) Option {
+	return func(o *options) {
+		o.queryTimeout = d
+	}
+}
+
+// WithPlanCacheSize sets how many compiled statements the database caches so
+// executing the same SQL again skips lexing, parsing and planning. A size of 0
+// disables the cache. The default is 128.
+func WithPlanCacheSize(size int) Option {
+	return func(o *options) {
+		o.planCache = size
+	}
+}
+
+// WithCacheSize sets the size of the page cache. See SetCacheSize. By default
+// the page cache holds a fraction of the memory available to the process.
+func WithCacheSize(size int) Option {
+	return func(o *options) {
+		o.cacheSize = size
+	}
+}
+
+// WithCachePolicy sets the replacement policy of the page cache. The default is
+// CachePolicyLRU. CachePolicy2Q suits databases where large scans would
+// otherwise evict the pages of indexes that are read constantly.
+func WithCachePolicy(policy CachePolicy) Option {
+	return func(o *options) {
+		o.cachePolicy = policy
+	}
+}
+
+// WithDirtyPageLimit sets the most pages a write transaction keeps changed in
+// memory. Past the limit the pages changed first are spilled to a temporary
+// file until the transaction ends so bulk loads use bounded memory. By default
+// there is no limit.
+func WithDirtyPageLimit(pages int) Option {
+	return func(o *options) {
+		o.dirtyPageLimit = pages
+	}
+}
+
+// WithMmap makes read transactions read the pages of the database file in
+// place from a memory mapping of the file rather than copying each page they
+// read. It has no effect when useMemory is true or WithStorage is given. It also
+// has no effect with WithEncryptionKey since encrypted pages must be decrypted
+// into a buffer.
+func WithMmap() Option {
+	return func(o *options) {
+		o.mmap = true
+	}
+}
+
+// WithEncryptionKey encrypts the pages of the database with key. A new database
+// is encrypted with key and an existing database must have been encrypted with
+// key. Pages are encrypted with AES-256 in XTS mode, including the pages copied
+// to the rollback journal, while the file header is not. ErrWrongKey is returned
+// when the key does not match and ErrNotEncrypted when the database is not
+// encrypted. The key should be at least 32 random bytes. See Rekey.
+func WithEncryptionKey(key []byte) Option {
+	return func(o *options) {
+		o.encryptionKey = key
+	}
+}
+
+func New(useMemory bool, filename string, opts ...Option) (*DB, error) {
+	o := &options{
+		logger:    logging.Nop(),
+		planCache: defaultPlanCacheSize,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var k *kv.KV
+	var err error
+	if o.storage == nil && o.encryptionKey != nil && useMemory {
+		o.storage = pager.NewMemoryStorage()
+	}
+	if o.storage == nil && o.encryptionKey != nil {
+		o.storage, err = pager.NewFileStorage(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.storage == nil && o.mmap && !useMemory {
+		o.storage, err = pager.NewMmapStorage(filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.encryptionKey != nil {
+		o.storage, err = pager.NewEncryptedStorage(o.storage, o.encryptionKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if o.storage != nil {
+		k, err = kv.NewWithStorage(o.storage)
+	} else {
+		k, err = kv.New(useMemory, filename)
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &DB{
-		vm:        vm.New(kv),
-		catalog:   kv.GetCatalog(),
-		UseMemory: useMemory,
-	}, nil
+	k.SetLogger(o.logger)
+	db := &DB{
+		vm:           vm.New(k),
+		catalog:      k.GetCatalog(),
+		transactions: k,
+		hooks:        k,
+		digester:     k,
+		checker:      k,
+		rekeyer:      k,
+		schema:       k,
+		cache:        k,
+		metrics:      k.GetMetrics(),
+		logger:       o.logger,
+		plans:        newPlanCache(o.planCache),
+		changes:      newChangeCounter(),
+		UseMemory:    useMemory,
+	}
+	db.SetBusyTimeout(o.busyTimeout)
+	db.SetQueryTimeout(o.queryTimeout)
+	if o.cachePolicy != CachePolicyLRU {
+		k.SetCachePolicy(o.cachePolicy)
+	}
+	if o.cacheSize != 0 {
+		db.SetCacheSize(o.cacheSize)
+	}
+	if o.dirtyPageLimit != 0 {
+		k.SetDirtyPageLimit(o.dirtyPageLimit)
+	}
+	return db, nil
+}
+
+// SetBusyTimeout sets how long a statement waits for another transaction to
+// release the lock of the database before failing with ErrBusy. A timeout of 0,
+// the default, waits until the context of the statement is done. The timeout
+// applies to statements that begin after SetBusyTimeout returns.
+func (db *DB) SetBusyTimeout(d time.Duration) {
+	db.busyTimeout.Store(int64(d))
+}
+
+// SetQueryTimeout sets how long a statement runs, including the time it waits
+// for the lock of the database, before it is stopped with ErrTimeout and its
+// transaction is rolled back. A timeout of 0, the default, never stops a
+// statement. The timeout applies to statements that begin after
+// SetQueryTimeout returns.
+func (db *DB) SetQueryTimeout(d time.Duration) {
+	db.queryTimeout.Store(int64(d))
+}
+
+// SetCacheSize sets how much of the database the page cache holds. A positive
+// size is a number of pages and a negative size is a number of kibibytes, the
+// same as PRAGMA cache_size. Pages beyond the size are evicted least recently
+// used first, which Metrics counts as cache evictions. SetCacheSize is safe to
+// call from any goroutine.
+func (db *DB) SetCacheSize(size int) {
+	if size < 0 {
+		db.cache.SetCacheMemory(-size * 1024)
+		return
+	}
+	db.cache.SetCacheMemory(size * pager.PageSize)
+}
+
+// CacheSize returns the number of pages the page cache holds at most and the
+// number of pages it holds now. PRAGMA cache_size reports the same.
+func (db *DB) CacheSize() (size, cached int) {
+	cachedBytes, maxBytes := db.cache.CacheMemory()
+	return maxBytes / pager.PageSize, cachedBytes / pager.PageSize
+}
+
+// statementContext returns ctx with the busy and query timeouts of a statement.
+// A timeout of 0 is the timeout of the database. The cancel function must be
+// called once the statement is done.
+func (db *DB) statementContext(ctx context.Context, busyTimeout, queryTimeout time.Duration) (context.Context, context.CancelFunc) {
+	if busyTimeout == 0 {
+		busyTimeout = time.Duration(db.busyTimeout.Load())
+	}
+	if queryTimeout == 0 {
+		queryTimeout = time.Duration(db.queryTimeout.Load())
+	}
+	ctx = vm.WithBusyTimeout(ctx, busyTimeout)
+	if queryTimeout <= 0 {
+		return ctx, func() {}
+	}
+	return vm.WithQueryTimeout(ctx, queryTimeout)
+}
+
+// Interrupt stops every statement currently executing on the database. The
+// stopped statements return ErrInterrupted and their transactions are rolled
+// back. Statements that begin after Interrupt returns run normally. Interrupt
+// is safe to call from any goroutine.
+func (db *DB) Interrupt() {
+	db.vm.Interrupt()
+}
+
+// InTransaction returns true when a statement has a transaction in progress.
+// This is safe to call from any goroutine and is useful for detecting
+// transactions that were never ended.
+func (db *DB) InTransaction() bool {
+	state, _ := db.transactions.TransactionState()
+	return state != TransactionNone
+}
+
+// TransactionState returns the kind of transaction in progress and the number
+// of dirty pages a write transaction will write when it commits. PRAGMA
+// transaction_state reports the same. This is safe to call from any goroutine.
+func (db *DB) TransactionState() (state TransactionState, dirtyPages int) {
+	return db.transactions.TransactionState()
+}
+
+// SetCommitHook sets the function called before each write transaction
+// commits. When hook returns an err the transaction is rolled back instead and
+// the statement fails with the err. This lets an embedding application veto a
+// commit or coordinate it with another system. A nil hook removes the hook.
+//
+// The hook is called while the database is locked so it must not execute
+// statements on the database. SetCommitHook must not be called while a
+// statement is executing.
+func (db *DB) SetCommitHook(hook func() error) {
+	db.hooks.SetCommitHook(hook)
+}
+
+// SetRollbackHook sets the function called after each write transaction rolls
+// back, for example to invalidate a cache filled during the transaction. The
+// hook is also called when the commit hook vetoes a commit. A nil hook removes
+// the hook. SetRollbackHook must not be called while a statement is executing.
+func (db *DB) SetRollbackHook(hook func()) {
+	db.hooks.SetRollbackHook(hook)
+}
+
+// Metrics returns the metrics registry of the database. The registry can be
+// connected to a monitoring system such as expvar or Prometheus.
+func (db *DB) Metrics() *metrics.Registry {
+	return db.metrics
+}
+
+// TableNames returns the name of every table in the schema.
+func (db *DB) TableNames() []string {
+	return db.catalog.GetTableNames()
+}
+
+// SchemaOrder is the order Schema lists objects in.
+type SchemaOrder = catalog.SchemaOrder
+
+const (
+	// SchemaOrderCreated lists objects in the order they were created.
+	SchemaOrderCreated = catalog.SchemaOrderCreated
+	// SchemaOrderName lists objects by type and then name so the schemas of
+	// two databases can be compared no matter what order their objects were
+	// created in.
+	SchemaOrderName = catalog.SchemaOrderName
+)
+
+// Schema returns every table, index and bucket in the schema in order. Each
+// object has the time it was last modified which is the same as the modified
+// column of cdb_schema.
+func (db *DB) Schema(order SchemaOrder) []catalog.Object {
+	return db.catalog.GetObjects(order)
+}
+
+// Digest returns a hex encoded hash of the schema and rows of every table. A
+// primary and a backup or replica restored from it have the same digest when
+// they hold the same rows even though their files may differ byte for byte.
+// Indexes and the modified time of objects are not part of the digest. The
+// digest is computed within a single read transaction.
+func (db *DB) Digest() (string, error) {
+	d, err := db.digester.Digest(context.Background())
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(d), nil
+}
+
+// IntegrityCheck returns a description of each problem found by walking every
+// b tree of the database. A database without problems returns none. Pages are
+// checked for corruption and trees are checked for the ordering of keys and the
+// pointers between pages. The check is done within a single read transaction.
+func (db *DB) IntegrityCheck() ([]string, error) {
+	return db.checker.IntegrityCheck(context.Background())
+}
+
+// Rekey encrypts the database with key instead of the key given to
+// WithEncryptionKey. Every page is written again within a single write
+// transaction so a crash leaves the database encrypted with one key or the
+// other. The database must be opened with key from then on. ErrNotEncrypted is
+// returned when the database was opened without an encryption key.
+func (db *DB) Rekey(key []byte) error {
+	return db.rekeyer.Rekey(context.Background(), key)
+}
+
+// ColumnNames returns the name of every column in tableName.
+func (db *DB) ColumnNames(tableName string) ([]string, error) {
+	return db.catalog.GetColumns(tableName)
 }
 
 type PreparedStatement struct {
@@ -56,6 +514,48 @@ type PreparedStatement struct {
 	DB        *DB
 	Result    *vm.ExecuteResult
 	ResultIdx int
+	// conn is the connection the statement was prepared with. When conn is not
+	// nil the statement executes within the transaction of conn.
+	conn *Conn
+	// BusyTimeout overrides the busy timeout of DB for this statement when it
+	// is not 0. See DB.SetBusyTimeout.
+	BusyTimeout time.Duration
+	// QueryTimeout overrides the query timeout of DB for this statement when it
+	// is not 0. See DB.SetQueryTimeout.
+	QueryTimeout time.Duration
+}
+
+// Execute executes the statement with Args.
+func (p *PreparedStatement) Execute() vm.ExecuteResult {
+	if p.conn != nil && p.conn.InTransaction() {
+		return p.conn.ExecuteContext(context.Background(), p.Statement, p.Args)
+	}
+	ctx, cancel := p.DB.statementContext(context.Background(), p.BusyTimeout, p.QueryTimeout)
+	defer cancel()
+	return p.DB.executeWith(ctx, p.Statement, p.Args, false)
+}
+
+// ExecuteMany executes the statement once for each set of parameters in
+// batches. The statement is compiled once and every execution shares a single
+// transaction so loading many rows is much faster than executing the statement
+// for each row. If any execution fails none of them are committed.
+func (p *PreparedStatement) ExecuteMany(batches [][]any) vm.ExecuteResult {
+	if p.conn != nil && p.conn.InTransaction() {
+		return p.conn.executeMany(p.Statement, batches)
+	}
+	ctx, cancel := p.DB.statementContext(context.Background(), p.BusyTimeout, p.QueryTimeout)
+	defer cancel()
+	return p.DB.executeMany(ctx, p.Statement, batches)
+}
+
+// LastInsertRowID returns the row id of the last row inserted by the execution
+// in Result. It is 0 when the statement has not been executed or did not insert
+// a row.
+func (p *PreparedStatement) LastInsertRowID() int {
+	if p.Result == nil {
+		return 0
+	}
+	return p.Result.LastInsertRowID
 }
 
 func (db *DB) NewPreparedStatement(sql string) (*PreparedStatement, error) {
@@ -85,50 +585,587 @@ func (db *DB) IsTerminated(statements compiler.Statements) bool {
 
 // Execute executes the given statements with the given params.
 func (db *DB) Execute(statements compiler.Statement, params []any) vm.ExecuteResult {
-	start := time.Now()
-	statement, err := compiler.NewParser(statements).Parse()
-	if err != nil {
-		return vm.ExecuteResult{Err: err}
+	return db.ExecuteContext(context.Background(), statements, params)
+}
+
+// ExecuteContext is Execute where ctx bounds how long the statement waits to
+// begin its transaction. If ctx is done first the result err is ErrBusy and
+// the statement can be retried. The busy timeout of the database also bounds
+// the wait. See SetBusyTimeout.
+func (db *DB) ExecuteContext(ctx context.Context, statements compiler.Statement, params []any) vm.ExecuteResult {
+	ctx, cancel := db.statementContext(ctx, 0, 0)
+	defer cancel()
+	return db.executeWith(ctx, statements, params, false)
+}
+
+// ExecuteReadOnly is ExecuteContext for statements that only read. A statement
+// that would begin a write transaction is rejected with ErrReadOnly when it is
+// planned so it never runs. This makes it safe to execute statements supplied
+// by users of a query endpoint without an authorizer.
+func (db *DB) ExecuteReadOnly(ctx context.Context, statements compiler.Statement, params []any) vm.ExecuteResult {
+	ctx, cancel := db.statementContext(ctx, 0, 0)
+	defer cancel()
+	return db.executeWith(ctx, statements, params, true)
+}
+
+// ExecuteMany is ExecuteContext for each set of parameters in batches. See
+// PreparedStatement.ExecuteMany.
+func (db *DB) ExecuteMany(ctx context.Context, statements compiler.Statement, batches [][]any) vm.ExecuteResult {
+	ctx, cancel := db.statementContext(ctx, 0, 0)
+	defer cancel()
+	return db.executeMany(ctx, statements, batches)
+}
+
+func (db *DB) executeMany(ctx context.Context, statements compiler.Statement, batches [][]any) vm.ExecuteResult {
+	res := db.record(db.execute(ctx, statements, false, nil, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+		return db.vm.ExecuteMany(ctx, plan, batches)
+	}))
+	db.refreshStats()
+	return res
+}
+
+// ExecuteScript executes every statement in sql within a single transaction.
+// Each statement is compiled after the statements before it executed so a
+// statement may use a table created earlier in the script. Every statement is
+// parsed before any is executed so a syntax error executes nothing.
+//
+// The results are in the order of the statements. When a statement fails the
+// transaction is rolled back, err is the err of the statement and the results
+// are of the statements that executed before it.
+func (db *DB) ExecuteScript(ctx context.Context, sql string) ([]vm.ExecuteResult, error) {
+	ctx, cancel := db.statementContext(ctx, 0, 0)
+	defer cancel()
+	statements := db.Tokenize(sql)
+	write := false
+	for _, statement := range statements {
+		stmt, err := compiler.NewParser(statement).Parse()
+		if err != nil {
+			return nil, err
+		}
+		write = write || writes(stmt)
+	}
+	tx := db.newTransaction(ctx, write)
+	defer tx.end()
+	results := []vm.ExecuteResult{}
+	for _, statement := range statements {
+		res := db.record(db.execute(ctx, statement, false, tx, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+			return tx.script.Execute(plan, []any{})
+		}))
+		if res.Err != nil {
+			return results, res.Err
+		}
+		results = append(results, res)
+	}
+	if err := tx.script.Commit(); err != nil {
+		return results, err
+	}
+	db.refreshStats()
+	return results, nil
+}
+
+// writes returns true when executing stmt writes to the database.
+func writes(stmt compiler.Stmt) bool {
+	var base *compiler.StmtBase
+	switch s := stmt.(type) {
+	case *compiler.CreateStmt:
+		base = s.StmtBase
+	case *compiler.CreateIndexStmt:
+		base = s.StmtBase
+	case *compiler.InsertStmt:
+		base = s.StmtBase
+	case *compiler.UpdateStmt:
+		base = s.StmtBase
+	case *compiler.DeleteStmt:
+		base = s.StmtBase
+	case *compiler.AnalyzeStmt:
+		base = s.StmtBase
+	default:
+		return false
 	}
+	return base == nil || !base.Explain && !base.ExplainQueryPlan
+}
 
-	planner := db.getPlannerFor(statement)
-	qp, err := planner.QueryPlan()
-	if err != nil {
-		return vm.ExecuteResult{Err: err}
+// Rows is the result of Query read one row at a time. See vm.Rows.
+type Rows struct {
+	*vm.Rows
+	cancel context.CancelFunc
+}
+
+// Close ends the statement. See vm.Rows.Close.
+func (r *Rows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// Query is ExecuteContext where the rows of the result are read one at a time
+// with the returned Rows rather than held in memory. This bounds the memory of
+// a statement with a large result. Rows must be closed to end the transaction
+// of the statement. The text of EXPLAIN QUERY PLAN is one row for each line.
+func (db *DB) Query(ctx context.Context, statements compiler.Statement, params []any) (*Rows, error) {
+	ctx, cancel := db.statementContext(ctx, 0, 0)
+	var rows *vm.Rows
+	res := db.record(db.execute(ctx, statements, false, nil, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+		var err error
+		rows, err = db.vm.Query(ctx, plan, params)
+		return &vm.ExecuteResult{Err: err}
+	}))
+	if res.Err != nil {
+		cancel()
+		return nil, res.Err
 	}
-	if qp.ExplainQueryPlan {
-		return vm.ExecuteResult{
-			Text: qp.ToString(),
+	if rows == nil {
+		rows = bufferedRows(res)
+	}
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+// bufferedRows returns Rows reading the rows of res. The text of an EXPLAIN
+// QUERY PLAN is one row for each line.
+func bufferedRows(res vm.ExecuteResult) *vm.Rows {
+	if res.Text != "" {
+		res.ResultHeader = []string{"QUERY PLAN"}
+		for _, line := range strings.Split(strings.TrimRight(res.Text, "\n"), "\n") {
+			res.ResultRows = append(res.ResultRows, []vm.Value{vm.TextValue(line)})
 		}
 	}
+	return vm.NewRows(&res)
+}
+
+func (db *DB) executeWith(ctx context.Context, statements compiler.Statement, params []any, readOnly bool) vm.ExecuteResult {
+	res := db.record(db.execute(ctx, statements, readOnly, nil, func(plan *vm.ExecutionPlan) *vm.ExecuteResult {
+		return db.vm.ExecuteContext(ctx, plan, params)
+	}))
+	db.refreshStats()
+	return res
+}
+
+// record records the metrics of an executed statement.
+func (db *DB) record(executeResult vm.ExecuteResult) vm.ExecuteResult {
+	db.metrics.StatementsExecuted.Inc()
+	if executeResult.Err != nil {
+		db.metrics.StatementErrors.Inc()
+		db.logger.Debug("statement failed", "err", executeResult.Err)
+	}
+	return executeResult
+}
 
+// execute compiles statements and runs the plan with run. The statement is
+// compiled and run again when the catalog changed since it was compiled. A
+// plan cached for the same SQL and catalog version is run without compiling.
+// When tx is not nil the statement is run within tx and so are its common
+// tables.
+func (db *DB) execute(ctx context.Context, statements compiler.Statement, readOnly bool, tx *transaction, run func(*vm.ExecutionPlan) *vm.ExecuteResult) vm.ExecuteResult {
+	start := time.Now()
+	sql := compiler.NormalizedSQL(statements)
 	var executeResult vm.ExecuteResult
+	var executionPlan *vm.ExecutionPlan
 	for {
-		executionPlan, err := planner.ExecutionPlan()
-		if err != nil {
-			return vm.ExecuteResult{Err: err}
+		key := planCacheKey{sql: sql, version: db.catalog.GetVersion()}
+		var ok bool
+		executionPlan, ok = db.plans.get(key)
+		if ok {
+			db.metrics.PlanCacheHits.Inc()
+		} else {
+			res, plan := db.compile(ctx, statements, tx)
+			if plan == nil {
+				return res
+			}
+			executionPlan = plan
+			if isCacheable(executionPlan) {
+				key.version = executionPlan.Version
+				db.plans.add(key, executionPlan)
+			}
 		}
-		executeResult = *db.vm.Execute(executionPlan, params)
+		if readOnly && !executionPlan.ReadOnly() {
+			return vm.ExecuteResult{Err: ErrReadOnly}
+		}
+		executeResult = *run(executionPlan)
 		if !errors.Is(executeResult.Err, vm.ErrVersionChanged) {
 			break
 		}
+		db.plans.remove(key)
+		db.metrics.Recompiles.Inc()
+		db.logger.Info("recompiling statement with out of date catalog")
+	}
+	db.changes.add(executionPlan, &executeResult)
+	if tx != nil {
+		tx.materialized.invalidate(executionPlan)
 	}
 	executeResult.Duration = time.Since(start)
 	return executeResult
 }
 
-func (db *DB) getPlannerFor(statement compiler.Stmt) statementPlanner {
+// compile parses and plans statements. When statements cannot be compiled or
+// are an EXPLAIN QUERY PLAN the plan is nil and the result is returned instead.
+func (db *DB) compile(ctx context.Context, statements compiler.Statement, tx *transaction) (vm.ExecuteResult, *vm.ExecutionPlan) {
+	// Another connection may have changed the schema since the catalog was
+	// parsed. The transaction of the statement would parse it again but that is
+	// too late for a statement naming an object the catalog does not have.
+	if err := db.schema.RefreshSchema(ctx); err != nil {
+		return vm.ExecuteResult{Err: err}, nil
+	}
+	for {
+		// The statement is parsed and planned again when the catalog changed
+		// since planning resolves the AST against the catalog.
+		statement, err := compiler.NewParser(statements).Parse()
+		if err != nil {
+			return vm.ExecuteResult{Err: err}, nil
+		}
+		planner := db.getPlannerFor(statement, db.newCommonTables(ctx, tx))
+		qp, err := planner.QueryPlan()
+		if errors.Is(err, vm.ErrVersionChanged) {
+			// A common table was computed with an out of date plan.
+			continue
+		}
+		if err != nil {
+			return vm.ExecuteResult{Err: err}, nil
+		}
+		if qp.ExplainQueryPlan {
+			return vm.ExecuteResult{
+				Text: qp.ToString(),
+			}, nil
+		}
+		executionPlan, err := planner.ExecutionPlan()
+		if err != nil {
+			return vm.ExecuteResult{Err: err}, nil
+		}
+		return vm.ExecuteResult{}, executionPlan
+	}
+}
+
+func (db *DB) getPlannerFor(statement compiler.Stmt, tables *commonTables) statementPlanner {
 	switch s := statement.(type) {
 	case *compiler.SelectStmt:
-		return planner.NewSelect(db.catalog, s)
+		return planner.NewSelect(db.catalog, s).
+			WithTableFunction(db.tableFunction).
+			WithMaterializer(tables.materialize)
 	case *compiler.CreateStmt:
 		return planner.NewCreate(db.catalog, s)
+	case *compiler.CreateIndexStmt:
+		return planner.NewCreateIndex(db.catalog, s)
 	case *compiler.InsertStmt:
 		return planner.NewInsert(db.catalog, s)
 	case *compiler.UpdateStmt:
 		return planner.NewUpdate(db.catalog, s)
 	case *compiler.DeleteStmt:
 		return planner.NewDelete(db.catalog, s)
+	case *compiler.PragmaStmt:
+		return planner.NewPragma(db.catalog, s, db.pragma)
+	case *compiler.AnalyzeStmt:
+		return planner.NewAnalyze(db.catalog, s)
 	}
 	panic("statement not supported")
 }
+
+// transaction is a transaction executing several statements such as the
+// statements of a script or those executed with a Conn between Begin and
+// Commit.
+type transaction struct {
+	script *vm.Script
+	// materialized are the MATERIALIZED common tables computed within the
+	// transaction.
+	materialized *materializedTables
+}
+
+func (db *DB) newTransaction(ctx context.Context, write bool) *transaction {
+	return &transaction{
+		script:       db.vm.NewScript(ctx, write),
+		materialized: newMaterializedTables(),
+	}
+}
+
+// end rolls back the transaction unless it has been committed and discards its
+// common tables.
+func (tx *transaction) end() {
+	tx.script.Rollback()
+	tx.materialized.close()
+}
+
+// commonTables computes the rows of the common table expressions of a
+// statement. The rows of a MATERIALIZED common table are kept in an ephemeral b
+// tree so a select that is named more than once, or executed for every set of
+// parameters given to ExecuteMany, is only computed once. Within a transaction
+// they are also kept for the statements after it. See materializedTables.
+type commonTables struct {
+	db  *DB
+	ctx context.Context
+	// script is the transaction the common tables are computed in. When script
+	// is nil each common table is computed in a transaction of its own.
+	script *vm.Script
+	// materialized are the MATERIALIZED common tables computed so far. Without
+	// a transaction they are only those of the statement and are discarded
+	// along with its plan.
+	materialized *materializedTables
+}
+
+func (db *DB) newCommonTables(ctx context.Context, tx *transaction) *commonTables {
+	if tx == nil {
+		return &commonTables{
+			db:           db,
+			ctx:          ctx,
+			materialized: newMaterializedTables(),
+		}
+	}
+	return &commonTables{
+		db:           db,
+		ctx:          ctx,
+		script:       tx.script,
+		materialized: tx.materialized,
+	}
+}
+
+// materialize executes the select of cte and returns its rows as a virtual
+// table. The primary key of the table is hidden from SELECT * since it is only
+// the position of the row.
+func (c *commonTables) materialize(cte compiler.CommonTableExpr) (*planner.VirtualTable, error) {
+	if cte.Materialized {
+		if t := c.materialized.get(cte); t != nil {
+			return t, nil
+		}
+	}
+	executionPlan, err := c.db.getPlannerFor(cte.Select, c).ExecutionPlan()
+	if err != nil {
+		return nil, err
+	}
+	var res *vm.ExecuteResult
+	if c.script != nil {
+		res = c.script.Execute(executionPlan, []any{})
+	} else {
+		res = c.db.vm.ExecuteContext(c.ctx, executionPlan, []any{})
+	}
+	if res.Err != nil {
+		return nil, res.Err
+	}
+	t := &planner.VirtualTable{
+		Columns:        append([]string{primaryKeyName(res.ResultHeader)}, res.ResultHeader...),
+		Types:          append([]catalog.CdbType{{ID: catalog.CTInt}}, res.ResultTypes...),
+		HidePrimaryKey: true,
+	}
+	rows := [][]any{}
+	for _, row := range res.ResultRows {
+		values := make([]any, len(row))
+		for i := range row {
+			values[i] = row[i].Any()
+		}
+		rows = append(rows, values)
+	}
+	if !cte.Materialized {
+		t.Rows = rows
+		return t, nil
+	}
+	if t.Table, err = ephemeralTable(rows); err != nil {
+		return nil, err
+	}
+	c.materialized.add(cte, executionPlan, t)
+	return t, nil
+}
+
+// ephemeralTable returns an ephemeral b tree holding rows keyed by their
+// position the same as the rows of a virtual table.
+func ephemeralTable(rows [][]any) (*kv.Ephemeral, error) {
+	e := kv.NewEphemeral()
+	c := e.NewCursor()
+	for i, row := range rows {
+		key, err := kv.EncodeKey(i)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		value, err := kv.Encode(row)
+		if err != nil {
+			e.Close()
+			return nil, err
+		}
+		if err := c.Set(key, value); err != nil {
+			e.Close()
+			return nil, err
+		}
+	}
+	return e, nil
+}
+
+// primaryKeyName returns a name for the hidden primary key of a common table
+// that is not one of columns.
+func primaryKeyName(columns []string) string {
+	name := "rowid"
+	for slices.Contains(columns, name) {
+		name = "_" + name
+	}
+	return name
+}
+
+// tableFunction resolves the table valued functions that can be used in a FROM
+// clause.
+func (db *DB) tableFunction(name string, args []any) (*planner.VirtualTable, error) {
+	if pragmaName, ok := strings.CutPrefix(name, "pragma_"); ok {
+		if len(args) != 0 {
+			return nil, fmt.Errorf("%s takes no arguments", name)
+		}
+		return db.pragma(pragmaName, nil)
+	}
+	switch name {
+	case "explain":
+		return db.explainTable(args)
+	case "generate_series":
+		return generateSeries(args)
+	}
+	return nil, fmt.Errorf("no such table function: %s", name)
+}
+
+// pragma resolves the pragma name to a virtual table reporting its value. A
+// pragma is used as PRAGMA name or as the table valued function pragma_name().
+// When value is not nil the pragma is set with PRAGMA name = value first.
+func (db *DB) pragma(name string, value any) (*planner.VirtualTable, error) {
+	name = strings.ToLower(name)
+	if value != nil && name != "cache_size" {
+		return nil, fmt.Errorf("pragma %s cannot be set", name)
+	}
+	switch name {
+	case "transaction_state":
+		return db.transactionStateTable(), nil
+	case "integrity_check":
+		return db.integrityCheckTable()
+	case "cache_size":
+		return db.cacheSizeTable(value)
+	}
+	return nil, fmt.Errorf("no such pragma: %s", name)
+}
+
+// cacheSizeTable sets the cache size to value when value is not nil and reports
+// CacheSize as a row with the columns cache_size and cached_pages.
+func (db *DB) cacheSizeTable(value any) (*planner.VirtualTable, error) {
+	if value != nil {
+		size, ok := value.(int)
+		if !ok {
+			return nil, errors.New("pragma cache_size must be set to an integer")
+		}
+		db.SetCacheSize(size)
+	}
+	size, cached := db.CacheSize()
+	return &planner.VirtualTable{
+		Columns: []string{"rowid", "cache_size", "cached_pages"},
+		Types: []catalog.CdbType{
+			{ID: catalog.CTInt}, {ID: catalog.CTInt}, {ID: catalog.CTInt},
+		},
+		Rows: [][]any{{size, cached}},
+	}, nil
+}
+
+// transactionStateTable reports TransactionState as a row with the columns
+// state which is one of none, read or write and dirty_pages.
+func (db *DB) transactionStateTable() *planner.VirtualTable {
+	state, dirtyPages := db.TransactionState()
+	return &planner.VirtualTable{
+		Columns: []string{"rowid", "state", "dirty_pages"},
+		Types: []catalog.CdbType{
+			{ID: catalog.CTInt}, {ID: catalog.CTStr}, {ID: catalog.CTInt},
+		},
+		Rows: [][]any{{state.String(), dirtyPages}},
+	}
+}
+
+// integrityCheckTable reports each problem found by IntegrityCheck as a row
+// with the column integrity_check. A single row of ok is reported when there
+// are no problems.
+func (db *DB) integrityCheckTable() (*planner.VirtualTable, error) {
+	problems, err := db.IntegrityCheck()
+	if err != nil {
+		return nil, err
+	}
+	if len(problems) == 0 {
+		problems = []string{"ok"}
+	}
+	rows := [][]any{}
+	for _, problem := range problems {
+		rows = append(rows, []any{problem})
+	}
+	return &planner.VirtualTable{
+		Columns: []string{"rowid", "integrity_check"},
+		Types: []catalog.CdbType{
+			{ID: catalog.CTInt}, {ID: catalog.CTStr},
+		},
+		Rows: rows,
+	}, nil
+}
+
+// explainTable compiles the single statement in args and returns its EXPLAIN
+// listing as a virtual table so the opcodes can be queried with SQL. The
+// statement is compiled but not executed.
+func (db *DB) explainTable(args []any) (*planner.VirtualTable, error) {
+	if len(args) != 1 {
+		return nil, errors.New("explain takes exactly one argument")
+	}
+	sql, ok := args[0].(string)
+	if !ok {
+		return nil, errors.New("explain argument must be a string")
+	}
+	statements := db.Tokenize(sql)
+	if len(statements) != 1 {
+		return nil, errors.New("explain argument must be exactly one statement")
+	}
+	statement, err := compiler.NewParser(statements[0]).Parse()
+	if err != nil {
+		return nil, err
+	}
+	executionPlan, err := db.getPlannerFor(statement, db.newCommonTables(context.Background(), nil)).ExecutionPlan()
+	if err != nil {
+		return nil, err
+	}
+	rows := [][]any{}
+	for _, row := range executionPlan.ExplainRows() {
+		// addr is the position of the row so it is left to the primary key.
+		rows = append(rows, row[1:])
+	}
+	intType := catalog.CdbType{ID: catalog.CTInt}
+	strType := catalog.CdbType{ID: catalog.CTStr}
+	return &planner.VirtualTable{
+		Columns: []string{"addr", "opcode", "P1", "P2", "P3", "P4", "P5", "comment"},
+		Types: []catalog.CdbType{
+			intType, strType, intType, intType, intType, strType, intType, strType,
+		},
+		Rows: rows,
+	}, nil
+}
+
+// maxSeriesRows is the most rows generate_series will produce. The rows of a
+// virtual table are held in memory so the series must be bounded.
+const maxSeriesRows = 1_000_000
+
+// generateSeries returns the integers from start to stop inclusive counting by
+// step for generate_series(start, stop[, step]). The step defaults to 1 and a
+// negative step counts down.
+func generateSeries(args []any) (*planner.VirtualTable, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errors.New("generate_series takes two or three arguments")
+	}
+	ints := []int{}
+	for _, arg := range args {
+		i, ok := arg.(int)
+		if !ok {
+			return nil, errors.New("generate_series arguments must be integers")
+		}
+		ints = append(ints, i)
+	}
+	start, stop, step := ints[0], ints[1], 1
+	if len(ints) == 3 {
+		step = ints[2]
+	}
+	if step == 0 {
+		return nil, errors.New("generate_series step cannot be 0")
+	}
+	rows := [][]any{}
+	for v := start; (step > 0 && v <= stop) || (step < 0 && v >= stop); v += step {
+		if len(rows) == maxSeriesRows {
+			return nil, fmt.Errorf("generate_series cannot produce more than %d rows", maxSeriesRows)
+		}
+		rows = append(rows, []any{v})
+	}
+	intType := catalog.CdbType{ID: catalog.CTInt}
+	return &planner.VirtualTable{
+		Columns:        []string{"rowid", "value"},
+		Types:          []catalog.CdbType{intType, intType},
+		Rows:           rows,
+		HidePrimaryKey: true,
+	}, nil
+}
@@ -1,10 +1,16 @@
 package db
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"os"
 	"os/exec"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/chirst/cdb/kvstore"
 )
 
 // This test is mostly to assert the platform is capable of running the code
@@ -25,6 +31,9 @@ func TestReadWriteFile(t *testing.T) {
 	if err := os.Remove("file_test.db"); err != nil {
 		t.Fatal("failed to clean up file_test.db file")
 	}
+	if err := os.Remove("file_test-lock.db"); err != nil {
+		t.Fatal("failed to clean up file_test-lock.db file")
+	}
 }
 
 // Tests the page cache flushes appropriately when two separate processes have
@@ -64,6 +73,9 @@ func TestDirtyReads(t *testing.T) {
 	if err := os.Remove("dirty_read_test.db"); err != nil {
 		t.Fatal("failed to clean up dirty_read_test.db file")
 	}
+	if err := os.Remove("dirty_read_test-lock.db"); err != nil {
+		t.Fatal("failed to clean up dirty_read_test-lock.db file")
+	}
 }
 
 func TestDirtyReadsSub(t *testing.T) {
@@ -76,3 +88,321 @@ func TestDirtyReadsSub(t *testing.T) {
 	}
 	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('gud dude 2');")
 }
+
+// Tests a statement gives up with ErrBusy when another connection holds the
+// write lock past the deadline of the statement.
+func TestExecuteContextBusy(t *testing.T) {
+	filename := t.TempDir() + "/busy_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+
+	store, err := kvstore.Open(false, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := store.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	statements := db.Tokenize("INSERT INTO foo (name) VALUES ('busy');")
+	if err := db.ExecuteContext(ctx, statements[0], []any{}).Err; !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected %v got %v", ErrBusy, err)
+	}
+
+	tx.Rollback()
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('not busy');")
+	res := mustExecute(t, db, "SELECT * FROM foo;")
+	if got := len(res.ResultRows); got != 1 {
+		t.Fatalf("expected 1 row got %d", got)
+	}
+}
+
+// Tests the busy and query timeouts of the database and of a prepared
+// statement stop a statement waiting for the write lock of another connection.
+func TestTimeouts(t *testing.T) {
+	filename := t.TempDir() + "/timeout_test"
+	db, err := New(false, filename, WithBusyTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+
+	store, err := kvstore.Open(false, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := store.Begin(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statements := db.Tokenize("INSERT INTO foo (name) VALUES ('busy');")
+	if err := db.Execute(statements[0], []any{}).Err; !errors.Is(err, ErrBusy) {
+		t.Fatalf("expected %v got %v", ErrBusy, err)
+	}
+
+	p, err := db.NewPreparedStatement("INSERT INTO foo (name) VALUES ('busy');")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.BusyTimeout = time.Hour
+	p.QueryTimeout = 20 * time.Millisecond
+	if err := p.Execute().Err; !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v got %v", ErrTimeout, err)
+	}
+
+	db.SetBusyTimeout(time.Hour)
+	db.SetQueryTimeout(20 * time.Millisecond)
+	if err := db.Execute(statements[0], []any{}).Err; !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v got %v", ErrTimeout, err)
+	}
+
+	tx.Rollback()
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('not busy');")
+	res := mustExecute(t, db, "SELECT * FROM foo;")
+	if got := len(res.ResultRows); got != 1 {
+		t.Fatalf("expected 1 row got %d", got)
+	}
+}
+
+// Tests a connection parses the schema again when another connection changes
+// it so statements are compiled with the current schema.
+func TestSchemaChangedByAnotherConnection(t *testing.T) {
+	filename := t.TempDir() + "/schema_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+
+	other, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	if !other.catalog.TableExists("foo") {
+		t.Fatal("expected foo to exist in the catalog of the other connection")
+	}
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+
+	// The insert is compiled without the index. It must be recompiled once the
+	// changed schema is parsed otherwise the index misses the row.
+	mustExecute(t, other, "INSERT INTO foo (name) VALUES ('a');")
+	res := mustExecute(t, db, "SELECT id FROM foo WHERE name = 'a';")
+	if got := len(res.ResultRows); got != 1 {
+		t.Fatalf("expected 1 row got %d", got)
+	}
+
+	// The table is not in the catalog of the other connection when the insert
+	// is planned so the catalog must be parsed again before planning.
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, other, "INSERT INTO bar (name) VALUES ('b');")
+	res = mustExecute(t, db, "SELECT name FROM bar;")
+	if got := len(res.ResultRows); got != 1 {
+		t.Fatalf("expected 1 row got %d", got)
+	}
+}
+
+// Tests the schema cookie persisted in the file header changes only when the
+// schema changes so a connection only parses the schema again and invalidates
+// its statements when another connection changed the schema.
+func TestSchemaCookie(t *testing.T) {
+	filename := t.TempDir() + "/cookie_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	other, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	version := other.catalog.GetVersion()
+
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a');")
+	mustExecute(t, other, "SELECT * FROM foo;")
+	if got := other.catalog.GetVersion(); got != version {
+		t.Fatalf("expected version %s to be kept after a write got %s", version, got)
+	}
+
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, other, "SELECT * FROM foo;")
+	if got := other.catalog.GetVersion(); got == version {
+		t.Fatal("expected version to change after a schema change")
+	}
+	if !other.catalog.TableExists("bar") {
+		t.Fatal("expected bar to exist in the catalog of the other connection")
+	}
+
+	mustExecute(t, db, "CREATE TABLE baz (id INTEGER PRIMARY KEY);")
+	mustExecute(t, other, "INSERT INTO baz (id) VALUES (1);")
+	if got := len(mustExecute(t, other, "SELECT * FROM baz;").ResultRows); got != 1 {
+		t.Fatalf("expected 1 row in a table created after the other connection opened got %d", got)
+	}
+
+	reopened, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	if got := reopened.catalog.GetVersion(); got != other.catalog.GetVersion() {
+		t.Fatalf("expected reopened version %s got %s", other.catalog.GetVersion(), got)
+	}
+}
+
+// Tests statistics gathered by ANALYZE in one connection are used by the plans
+// of another connection to the same file.
+func TestStatsCookie(t *testing.T) {
+	filename := t.TempDir() + "/stats_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, kind TEXT, name TEXT);")
+	mustExecute(t, db, "CREATE INDEX foo_kind ON foo (kind);")
+	mustExecute(t, db, "INSERT INTO foo (kind, name) VALUES ('a', 'a'), ('a', 'b'), ('a', 'c'), ('b', 'd');")
+	mustExecute(t, db, "ANALYZE;")
+	other, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	sql := "EXPLAIN QUERY PLAN SELECT * FROM foo WHERE kind = 'a';"
+	if got := mustExecute(t, other, sql).Text; !strings.Contains(got, "scan table foo (~4 rows)") {
+		t.Fatalf("expected statistics to be read when opened got\n%s", got)
+	}
+	version := other.catalog.GetVersion()
+
+	for i := range 100 {
+		mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (kind, name) VALUES ('k%d', 'n');", i))
+	}
+	mustExecute(t, db, "ANALYZE;")
+	// The catalog of other is read again by the next statement that begins a
+	// transaction.
+	mustExecute(t, other, "SELECT * FROM foo WHERE kind = 'a';")
+	if got := mustExecute(t, other, sql).Text; !strings.Contains(got, "using index foo_kind") {
+		t.Fatalf("expected new statistics to be read got\n%s", got)
+	}
+	if other.catalog.GetVersion() == version {
+		t.Fatal("expected version to change after statistics changed")
+	}
+
+	version = other.catalog.GetVersion()
+	mustExecute(t, db, "ANALYZE;")
+	mustExecute(t, other, "SELECT * FROM foo WHERE kind = 'a';")
+	if got := other.catalog.GetVersion(); got != version {
+		t.Fatalf("expected version %s to be kept when statistics are unchanged got %s", version, got)
+	}
+}
+
+// Tests a statement reading a page damaged outside of cdb fails with
+// ErrCorrupt and PRAGMA integrity_check reports the damaged page.
+func TestCorruption(t *testing.T) {
+	filename := t.TempDir() + "/corrupt_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+	for i := 0; i < 500; i += 1 {
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a somewhat long name to fill pages');")
+	}
+	mustExecute(t, db, "DELETE FROM foo WHERE id % 3 = 0;")
+	res := mustExecute(t, db, "PRAGMA integrity_check;")
+	if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "ok" {
+		t.Fatalf("expected ok got %v", res.ResultRows)
+	}
+
+	root := 0
+	for _, o := range db.Schema(SchemaOrderName) {
+		if o.Name == "foo" {
+			root = o.RootPageNumber
+		}
+	}
+	f, err := os.OpenFile(filename+".db", os.O_RDWR, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Pages follow the 100 byte file header starting with page 1.
+	if _, err := f.WriteAt([]byte("damaged"), int64(100+(root-1)*4096+2000)); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	// A new connection has none of the pages cached.
+	other, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	if err := other.Execute(other.Tokenize("SELECT * FROM foo;")[0], []any{}).Err; !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v got %v", ErrCorrupt, err)
+	}
+	if err := other.Execute(other.Tokenize("INSERT INTO foo (name) VALUES ('b');")[0], []any{}).Err; !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v got %v", ErrCorrupt, err)
+	}
+	res = mustExecute(t, other, "PRAGMA integrity_check;")
+	if len(res.ResultRows) == 0 || !strings.Contains(res.ResultRows[0][0].Text(), "checksum does not match") {
+		t.Fatalf("expected checksum problem got %v", res.ResultRows)
+	}
+}
+
+// Tests pages emptied by deleting are reused by later inserts rather than
+// growing the file and that the trees and freelist stay intact across
+// connections.
+func TestDeleteReusesPages(t *testing.T) {
+	filename := t.TempDir() + "/reuse_test"
+	db, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+	insert := func() {
+		t.Helper()
+		values := []string{}
+		for i := 0; i < 100; i += 1 {
+			values = append(values, fmt.Sprintf("('a somewhat long name %d')", i))
+		}
+		for i := 0; i < 20; i += 1 {
+			mustExecute(t, db, "INSERT INTO foo (name) VALUES "+strings.Join(values, ", ")+";")
+		}
+	}
+	insert()
+	mustExecute(t, db, "DELETE FROM foo WHERE id % 10 != 0;")
+	info, err := os.Stat(filename + ".db")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := New(false, filename)
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	res := mustExecute(t, other, "PRAGMA integrity_check;")
+	if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "ok" {
+		t.Fatalf("expected ok got %v", res.ResultRows)
+	}
+	res = mustExecute(t, other, "SELECT COUNT(*) FROM foo;")
+	if got := res.ResultRows[0][0].Int(); got != 200 {
+		t.Fatalf("expected 200 rows got %d", got)
+	}
+
+	insert()
+	after, err := os.Stat(filename + ".db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Without reusing pages the file would nearly double since as many rows
+	// are inserted again.
+	if after.Size() > info.Size()*3/2 {
+		t.Fatalf("expected file of %d bytes to grow less than half got %d", info.Size(), after.Size())
+	}
+	res = mustExecute(t, db, "PRAGMA integrity_check;")
+	if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "ok" {
+		t.Fatalf("expected ok got %v", res.ResultRows)
+	}
+}
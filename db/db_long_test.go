@@ -49,7 +49,7 @@ func TestInsertAndSelectMillions(t *testing.T) {
 		"10000000",
 	}
 	for i, se := range *selectExpects {
-		if got := *selectRes.ResultRows[i][0]; got != se {
+		if got := selectRes.ResultRows[i][0].Text(); got != se {
 			t.Fatalf("select failed got: %s want: %s", got, se)
 		}
 	}
@@ -57,7 +57,7 @@ func TestInsertAndSelectMillions(t *testing.T) {
 	selectCountRes := mustExecute(t, db, "SELECT COUNT(*) FROM test")
 	t.Log("counted millions")
 	gotCS := selectCountRes.ResultRows[0][0]
-	gotC, err := strconv.Atoi(*gotCS)
+	gotC, err := strconv.Atoi(gotCS.Text())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -68,4 +68,8 @@ func TestInsertAndSelectMillions(t *testing.T) {
 	if err != nil {
 		t.Fatal("could not cleanup millions.db database file")
 	}
+	err = os.Remove("millions-lock.db")
+	if err != nil {
+		t.Fatal("could not cleanup millions-lock.db lock file")
+	}
 }
@@ -1,10 +1,20 @@
 package db
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"slices"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
 
@@ -40,7 +50,7 @@ func TestExecute(t *testing.T) {
 		"{\"columns\":[{\"name\":\"id\",\"type\":\"INTEGER\",\"primaryKey\":true},{\"name\":\"first_name\",\"type\":\"TEXT\",\"primaryKey\":false},{\"name\":\"last_name\",\"type\":\"TEXT\",\"primaryKey\":false},{\"name\":\"age\",\"type\":\"INTEGER\",\"primaryKey\":false}]}",
 	}
 	for i, s := range schemaSelectExpectations {
-		if c := *schemaRes.ResultRows[0][i]; c != s {
+		if c := schemaRes.ResultRows[0][i].Text(); c != s {
 			t.Fatalf("expected %s got %s", s, c)
 		}
 	}
@@ -66,7 +76,7 @@ func TestExecute(t *testing.T) {
 		"50",
 	}
 	for i, s := range selectPersonExpectations {
-		if c := *selectPersonRes.ResultRows[0][i]; c != s {
+		if c := selectPersonRes.ResultRows[0][i].Text(); c != s {
 			t.Fatalf("expected %s got %s", s, c)
 		}
 	}
@@ -95,7 +105,7 @@ func TestBulkInsert(t *testing.T) {
 		t.Fatalf("expected %d got %d", expectedTotal, gotT)
 	}
 	for i, r := range selectRes.ResultRows {
-		left, err := strconv.Atoi(*r[0])
+		left, err := strconv.Atoi(r[0].Text())
 		if err != nil {
 			t.Fatal(err)
 		}
@@ -105,7 +115,7 @@ func TestBulkInsert(t *testing.T) {
 	}
 	selectCountRes := mustExecute(t, db, "SELECT COUNT(*) FROM test")
 	gotCS := selectCountRes.ResultRows[0][0]
-	gotC, err := strconv.Atoi(*gotCS)
+	gotC, err := strconv.Atoi(gotCS.Text())
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -119,6 +129,201 @@ func TestBulkInsert(t *testing.T) {
 	}
 }
 
+func TestDirtyPageLimit(t *testing.T) {
+	db, err := New(true, "", WithDirtyPageLimit(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	junk := strings.Repeat("a", 200)
+	values := []string{}
+	for i := 0; i < 2000; i += 1 {
+		values = append(values, "('"+junk+"')")
+	}
+	mustExecute(t, db, "INSERT INTO test (junk) VALUES "+strings.Join(values, ", "))
+	if got := db.Metrics().PagesSpilled.Value(); got == 0 {
+		t.Fatal("expected the insert to spill pages")
+	}
+	mustExecute(t, db, "UPDATE test SET junk = 'b'")
+	res := mustExecute(t, db, "SELECT COUNT(*), MIN(junk), MAX(junk) FROM test")
+	got := []string{res.ResultRows[0][0].Text(), res.ResultRows[0][1].Text(), res.ResultRows[0][2].Text()}
+	if want := []string{"2000", "b", "b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+}
+
+func TestMmap(t *testing.T) {
+	db, err := New(false, t.TempDir()+"/mmap", WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	mustExecute(t, db, "INSERT INTO test (junk) VALUES ('a'), ('b'), ('c')")
+	mapped := db.Metrics().PagesMapped.Value()
+	res := mustExecute(t, db, "SELECT junk FROM test")
+	got := []string{}
+	for _, row := range res.ResultRows {
+		got = append(got, row[0].Text())
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+	if db.Metrics().PagesMapped.Value() == mapped {
+		t.Fatal("expected the select to read mapped pages")
+	}
+}
+
+func TestEncryptionKey(t *testing.T) {
+	name := t.TempDir() + "/encrypted"
+	key := []byte("an encryption key of 32 bytes...")
+	db, err := New(false, name, WithEncryptionKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	mustExecute(t, db, "INSERT INTO test (junk) VALUES ('a'), ('b'), ('c')")
+	if _, err := New(false, name); !errors.Is(err, ErrEncrypted) {
+		t.Fatalf("expected %v got %v", ErrEncrypted, err)
+	}
+	newKey := []byte("another encryption key")
+	if err := db.Rekey(newKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(false, name, WithEncryptionKey(key)); !errors.Is(err, ErrWrongKey) {
+		t.Fatalf("expected %v got %v", ErrWrongKey, err)
+	}
+	reopened, err := New(false, name, WithEncryptionKey(newKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res := mustExecute(t, reopened, "SELECT junk FROM test")
+	got := []string{}
+	for _, row := range res.ResultRows {
+		got = append(got, row[0].Text())
+	}
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+	if err := mustCreateDB(t).Rekey(newKey); !errors.Is(err, ErrNotEncrypted) {
+		t.Fatalf("expected %v got %v", ErrNotEncrypted, err)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	mustExecute(t, db, "INSERT INTO test (junk) VALUES ('a')")
+	mustExecute(t, db, "INSERT INTO test (junk) VALUES ('b')")
+	mustExecute(t, db, "SELECT * FROM test")
+	statements := db.Tokenize("SELECT * FROM missing")
+	db.Execute(statements[0], []any{})
+	m := db.Metrics()
+	if got := m.StatementsExecuted.Value(); got != 5 {
+		t.Fatalf("expected 5 statements executed got %d", got)
+	}
+	if got := m.StatementErrors.Value(); got != 1 {
+		t.Fatalf("expected 1 statement error got %d", got)
+	}
+	if got := m.Commits.Value(); got != 3 {
+		t.Fatalf("expected 3 commits got %d", got)
+	}
+	if got := m.RowsWritten.Value(); got < 2 {
+		t.Fatalf("expected at least 2 rows written got %d", got)
+	}
+	if got := m.RowsRead.Value(); got < 2 {
+		t.Fatalf("expected at least 2 rows read got %d", got)
+	}
+}
+
+func TestPlanCache(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER)")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1)")
+	hits := &db.Metrics().PlanCacheHits
+
+	t.Run("same statement uses cached plan", func(t *testing.T) {
+		before := hits.Value()
+		mustExecute(t, db, "SELECT a FROM foo WHERE a = 1;")
+		mustExecute(t, db, "select   a FROM foo\nWHERE a = 1 -- again")
+		if got := hits.Value() - before; got != 1 {
+			t.Fatalf("expected 1 plan cache hit got %d", got)
+		}
+	})
+
+	t.Run("schema change compiles again", func(t *testing.T) {
+		mustExecute(t, db, "SELECT a FROM foo")
+		mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY)")
+		before := hits.Value()
+		res := mustExecute(t, db, "SELECT a FROM foo")
+		if got := hits.Value() - before; got != 0 {
+			t.Fatalf("expected no plan cache hits got %d", got)
+		}
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Int() != 1 {
+			t.Fatalf("expected one row with 1 got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("virtual table is not cached", func(t *testing.T) {
+		before := hits.Value()
+		mustExecute(t, db, "SELECT * FROM generate_series(1, 3)")
+		mustExecute(t, db, "SELECT * FROM generate_series(1, 3)")
+		if got := hits.Value() - before; got != 0 {
+			t.Fatalf("expected no plan cache hits got %d", got)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		db, err := New(true, "", WithPlanCacheSize(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustExecute(t, db, "SELECT 1")
+		mustExecute(t, db, "SELECT 1")
+		if got := db.Metrics().PlanCacheHits.Value(); got != 0 {
+			t.Fatalf("expected no plan cache hits got %d", got)
+		}
+	})
+
+	t.Run("evicts least recently used", func(t *testing.T) {
+		c := newPlanCache(2)
+		one := planCacheKey{sql: "SELECT 1", version: "v"}
+		two := planCacheKey{sql: "SELECT 2", version: "v"}
+		three := planCacheKey{sql: "SELECT 3", version: "v"}
+		c.add(one, vm.NewExecutionPlan("v", false))
+		c.add(two, vm.NewExecutionPlan("v", false))
+		c.get(one)
+		c.add(three, vm.NewExecutionPlan("v", false))
+		if _, ok := c.get(two); ok {
+			t.Fatal("expected least recently used plan to be evicted")
+		}
+		if _, ok := c.get(one); !ok {
+			t.Fatal("expected recently used plan to be cached")
+		}
+		c.remove(one)
+		if got := c.len(); got != 1 {
+			t.Fatalf("expected 1 plan got %d", got)
+		}
+	})
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	db, err := New(true, "", WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	statements := db.Tokenize("SELECT * FROM missing")
+	db.Execute(statements[0], []any{})
+	if !strings.Contains(buf.String(), "committing write transaction") {
+		t.Fatalf("expected commit to be logged got %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "statement failed") {
+		t.Fatalf("expected statement failure to be logged got %s", buf.String())
+	}
+}
+
 func TestPrimaryKeyUniqueConstraintViolation(t *testing.T) {
 	db := mustCreateDB(t)
 	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
@@ -133,7 +338,7 @@ func TestPrimaryKeyUniqueConstraintViolation(t *testing.T) {
 func TestOperators(t *testing.T) {
 	db := mustCreateDB(t)
 	res := mustExecute(t, db, "SELECT 1+2-3*4+5^7-8*9/2")
-	got := *res.ResultRows[0][0]
+	got := res.ResultRows[0][0].Text()
 	want := "78080"
 	if got != want {
 		t.Fatalf("want %s but got %s", want, got)
@@ -150,7 +355,7 @@ func TestAddColumns(t *testing.T) {
 	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
 	mustExecute(t, db, "INSERT INTO test (id, val) VALUES (78, 112)")
 	res := mustExecute(t, db, "SELECT id + val FROM test")
-	got := *res.ResultRows[0][0]
+	got := res.ResultRows[0][0].Text()
 	want := "190"
 	if got != want {
 		t.Fatalf("want %s but got %s", want, got)
@@ -184,7 +389,7 @@ func TestSelectWithWhere(t *testing.T) {
 	if rowCount := len(res.ResultRows); rowCount != 1 {
 		t.Fatalf("want 1 row but got %d", rowCount)
 	}
-	got := *res.ResultRows[0][0]
+	got := res.ResultRows[0][0].Text()
 	want := "1"
 	if got != want {
 		t.Fatalf("want %s but got %s", want, got)
@@ -199,177 +404,827 @@ func TestSelectRangeWithWhere(t *testing.T) {
 	if rowCount := len(res.ResultRows); rowCount != 1 {
 		t.Fatalf("want 1 row but got %d", rowCount)
 	}
-	got := *res.ResultRows[0][0]
+	got := res.ResultRows[0][0].Text()
 	want := "2"
 	if got != want {
 		t.Fatalf("want %s but got %s", want, got)
 	}
 }
 
-func TestResultColumnExprs(t *testing.T) {
-	type rcCase struct {
-		statement string
-		want      string
+func TestSchemaQualifiedNames(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
+	mustExecute(t, db, "INSERT INTO test (id, val) VALUES (1, 111), (2, 222)")
+	res := mustExecute(t, db, "SELECT main.test.val FROM main.test WHERE main.test.id = 2")
+	if rowCount := len(res.ResultRows); rowCount != 1 {
+		t.Fatalf("want 1 row but got %d", rowCount)
 	}
-	rcCases := []rcCase{
-		{
-			statement: "SELECT val + 2 FROM test",
-			want:      "24",
-		},
-		{
-			statement: "SELECT 3 + val + 2 FROM test",
-			want:      "27",
-		},
-		{
-			statement: "SELECT val > 2 FROM test",
-			want:      "1",
-		},
-		{
-			statement: "SELECT 3 > val > 2 FROM test",
-			want:      "0",
-		},
-		{
-			statement: "SELECT val < 2 FROM test",
-			want:      "0",
-		},
-		{
-			statement: "SELECT 3 < val < 2 FROM test",
-			want:      "1",
-		},
-		{
-			statement: "SELECT val = 2 FROM test",
-			want:      "0",
-		},
-		{
-			statement: "SELECT 3 = val = 2 FROM test",
-			want:      "0",
-		},
-		{
-			statement: "SELECT val - 2 FROM test",
-			want:      "20",
-		},
-		{
-			statement: "SELECT 3 - val - 2 FROM test",
-			want:      "-21",
-		},
-		{
-			statement: "SELECT val ^ 2 FROM test",
-			want:      "484",
-		},
-		{
-			statement: "SELECT val ^ 2 ^ 2 FROM test",
-			want:      "234256",
-		},
-		{
-			statement: "SELECT val * 2 FROM test",
-			want:      "44",
-		},
-		{
-			statement: "SELECT 2 * val * 2 FROM test",
-			want:      "88",
-		},
-		{
-			statement: "SELECT val / 2 FROM test",
-			want:      "11",
-		},
-		{
-			statement: "SELECT 44 / val / 2 FROM test",
-			want:      "1",
-		},
+	got := res.ResultRows[0][0].Text()
+	want := "222"
+	if got != want {
+		t.Fatalf("want %s but got %s", want, got)
+	}
+	statements := db.Tokenize("SELECT * FROM temp.test")
+	if err := db.Execute(statements[0], []any{}).Err; err == nil {
+		t.Fatal("want err for unknown schema but got nil")
 	}
+
+	t.Run("statements", func(t *testing.T) {
+		mustExecute(t, db, "CREATE TABLE main.other (id INTEGER PRIMARY KEY, val INTEGER)")
+		mustExecute(t, db, "CREATE INDEX main.other_val ON other (val)")
+		mustExecute(t, db, "INSERT INTO main.other (id, val) VALUES (1, 111), (2, 222), (3, 333)")
+		mustExecute(t, db, "UPDATE main.other SET val = 444 WHERE id = 3")
+		mustExecute(t, db, "DELETE FROM main.other WHERE id = 1")
+		mustExecute(t, db, "ANALYZE main.other")
+		res := mustExecute(t, db, "SELECT val FROM main.other")
+		got := []int{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Int())
+		}
+		if want := []int{222, 444}; !slices.Equal(got, want) {
+			t.Fatalf("want %v but got %v", want, got)
+		}
+	})
+
+	t.Run("join", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT main.test.val, main.other.val FROM main.test JOIN main.other ON main.test.id = main.other.id")
+		got := [][]int{}
+		for _, row := range res.ResultRows {
+			got = append(got, []int{row[0].Int(), row[1].Int()})
+		}
+		if want := [][]int{{222, 222}}; !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v but got %v", want, got)
+		}
+	})
+
+	t.Run("unknown schema", func(t *testing.T) {
+		for _, sql := range []string{
+			"CREATE TABLE temp.others (id INTEGER PRIMARY KEY)",
+			"CREATE INDEX temp.test_val ON test (val)",
+			"INSERT INTO temp.test (id, val) VALUES (3, 333)",
+			"UPDATE temp.test SET val = 1",
+			"DELETE FROM temp.test",
+			"ANALYZE temp.test",
+			"SELECT * FROM test JOIN temp.other ON test.id = other.id",
+		} {
+			err := db.Execute(db.Tokenize(sql)[0], []any{}).Err
+			if err == nil || !strings.Contains(err.Error(), "schema does not exist") {
+				t.Fatalf("want schema does not exist for %s but got %v", sql, err)
+			}
+		}
+	})
+}
+
+func TestExplainTable(t *testing.T) {
 	db := mustCreateDB(t)
 	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
-	mustExecute(t, db, "INSERT INTO test (val) VALUES (22)")
-	for _, rcc := range rcCases {
-		t.Run(rcc.statement, func(t *testing.T) {
-			res := mustExecute(t, db, rcc.statement)
-			expectedRowCount := 1
-			if rowCount := len(res.ResultRows); rowCount != expectedRowCount {
-				t.Fatalf("want %d row but got %d", expectedRowCount, rowCount)
+	explain := mustExecute(t, db, "EXPLAIN SELECT * FROM test WHERE val = 1")
+
+	t.Run("all", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT * FROM explain('SELECT * FROM test WHERE val = 1')")
+		if got, want := len(res.ResultRows), len(explain.ResultRows); got != want {
+			t.Fatalf("want %d rows but got %d", want, got)
+		}
+		if got, want := strings.Join(res.ResultHeader, ","), strings.Join(explain.ResultHeader, ","); got != want {
+			t.Fatalf("want header %s but got %s", want, got)
+		}
+		for i := range res.ResultRows {
+			for j := range res.ResultRows[i] {
+				if got, want := res.ResultRows[i][j].Text(), explain.ResultRows[i][j].Text(); got != want {
+					t.Fatalf("want %s but got %s at row %d col %d", want, got, i, j)
+				}
 			}
-			got := *res.ResultRows[0][0]
-			if got != rcc.want {
-				t.Fatalf("want %s but got %s", rcc.want, got)
+		}
+	})
+
+	t.Run("filter", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT addr, opcode FROM explain('SELECT * FROM test WHERE val = 1') WHERE opcode = 'OpenRead'")
+		if rowCount := len(res.ResultRows); rowCount != 1 {
+			t.Fatalf("want 1 row but got %d", rowCount)
+		}
+		if got := res.ResultRows[0][1].Text(); got != "OpenRead" {
+			t.Fatalf("want OpenRead but got %s", got)
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT opcode FROM explain('SELECT * FROM test') WHERE addr = 0")
+		if rowCount := len(res.ResultRows); rowCount != 1 {
+			t.Fatalf("want 1 row but got %d", rowCount)
+		}
+		if got := res.ResultRows[0][0].Text(); got != "Init" {
+			t.Fatalf("want Init but got %s", got)
+		}
+	})
+
+	t.Run("count", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT COUNT(*) FROM explain('SELECT * FROM test WHERE val = 1')")
+		if got, want := res.ResultRows[0][0].Text(), strconv.Itoa(len(explain.ResultRows)); got != want {
+			t.Fatalf("want %s but got %s", want, got)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		for _, sql := range []string{
+			"SELECT * FROM explain('SELECT * FROM does_not_exist')",
+			"SELECT * FROM explain(1)",
+			"SELECT * FROM explain()",
+			"SELECT * FROM unknown_function('')",
+		} {
+			statements := db.Tokenize(sql)
+			if err := db.Execute(statements[0], []any{}).Err; err == nil {
+				t.Fatalf("want err but got nil for %s", sql)
+			}
+		}
+	})
+}
+
+func TestGenerateSeries(t *testing.T) {
+	db := mustCreateDB(t)
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT value FROM generate_series(1, 5)", want: []string{"1", "2", "3", "4", "5"}},
+		{sql: "SELECT value FROM generate_series(0, 10, 4)", want: []string{"0", "4", "8"}},
+		{sql: "SELECT value FROM generate_series(3, 1, 0 - 1)", want: []string{"3", "2", "1"}},
+		{sql: "SELECT value FROM generate_series(2, 1)", want: []string{}},
+		{sql: "SELECT value * 2 FROM generate_series(1, 10) WHERE value > 8", want: []string{"18", "20"}},
+		{sql: "SELECT SUM(value) FROM generate_series(1, 100)", want: []string{"5050"}},
+		{sql: "SELECT * FROM generate_series(1, 2)", want: []string{"1", "2"}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
 			}
 		})
 	}
-}
 
-func TestPredicateExprs(t *testing.T) {
-	type pCase struct {
-		statement        string
-		expectedRowCount int
+	t.Run("star header", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT * FROM generate_series(1, 2)")
+		if want := []string{"value"}; !reflect.DeepEqual(res.ResultHeader, want) {
+			t.Fatalf("want header %v got %v", want, res.ResultHeader)
+		}
+	})
+
+	for _, sql := range []string{
+		"SELECT * FROM generate_series(1)",
+		"SELECT * FROM generate_series(1, 2, 0)",
+		"SELECT * FROM generate_series('a', 2)",
+		"SELECT * FROM generate_series(1, 2000000)",
+	} {
+		statements := db.Tokenize(sql)
+		if err := db.Execute(statements[0], []any{}).Err; err == nil {
+			t.Fatalf("want err but got nil for %s", sql)
+		}
 	}
-	pCases := []pCase{
-		{
-			statement:        "SELECT 1 FROM test WHERE val + 1",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE 3 + val + 1",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val / 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE 44 / val / 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val * 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE 2 * val * 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val ^ 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val ^ 2 ^ 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val - 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE 2 - val - 2",
-			expectedRowCount: 1,
-		},
-		{
-			statement:        "SELECT 1 FROM test WHERE val > 2",
-			expectedRowCount: 1,
-		},
+}
+
+func TestCommonTableExpressions(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('a', 1), ('b', 2), ('c', 3);")
+	cases := []struct {
+		sql  string
+		want [][]string
+	}{
 		{
-			statement:        "SELECT 1 FROM test WHERE 2 > val > 2",
-			expectedRowCount: 0,
+			sql:  "WITH adults AS (SELECT name FROM foo WHERE age > 1) SELECT * FROM adults;",
+			want: [][]string{{"b"}, {"c"}},
 		},
 		{
-			statement:        "SELECT 1 FROM test WHERE val < 2",
-			expectedRowCount: 0,
+			sql:  "WITH t AS MATERIALIZED (SELECT name, age * 10 AS score FROM foo) SELECT name FROM t WHERE score = 20;",
+			want: [][]string{{"b"}},
 		},
 		{
-			statement:        "SELECT 1 FROM test WHERE 2 < val < 2",
-			expectedRowCount: 1,
+			sql:  "WITH t (n, a) AS NOT MATERIALIZED (SELECT name, age FROM foo) SELECT a, n FROM t WHERE n = 'c';",
+			want: [][]string{{"3", "c"}},
 		},
 		{
-			statement:        "SELECT 1 FROM test WHERE val = 2",
-			expectedRowCount: 0,
+			sql:  "WITH a AS (SELECT age FROM foo), b AS (SELECT SUM(age) AS total FROM a) SELECT total FROM b;",
+			want: [][]string{{"6"}},
 		},
 		{
-			statement:        "SELECT 1 FROM test WHERE 3 = val = 2",
-			expectedRowCount: 0,
+			sql:  "WITH s AS (SELECT value FROM generate_series(1, 3) ORDER BY value DESC) SELECT COUNT(*) FROM s;",
+			want: [][]string{{"3"}},
 		},
 	}
-	db := mustCreateDB(t)
-	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
-	mustExecute(t, db, "INSERT INTO test (val) VALUES (22)")
-	for _, pc := range pCases {
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				values := []string{}
+				for _, v := range row {
+					values = append(values, v.Text())
+				}
+				got = append(got, values)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("materialized once per batch", func(t *testing.T) {
+		ps, err := db.NewPreparedStatement("WITH t AS MATERIALIZED (SELECT name, age FROM foo) SELECT name FROM t WHERE age = ?;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := ps.ExecuteMany([][]any{{1}, {3}})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if len(res.ResultRows) != 2 || res.ResultRows[0][0].Text() != "a" || res.ResultRows[1][0].Text() != "c" {
+			t.Fatalf("got unexpected rows %v", res.ResultRows)
+		}
+	})
+
+	t.Run("materialized once per transaction", func(t *testing.T) {
+		mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+		ctx := context.Background()
+		c := db.Conn()
+		if err := c.Begin(ctx, false); err != nil {
+			t.Fatal(err)
+		}
+		defer c.Rollback()
+		execute := func(sql string) vm.ExecuteResult {
+			t.Helper()
+			res := c.ExecuteContext(ctx, db.Tokenize(sql)[0], nil)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			return res
+		}
+		random := func() string {
+			t.Helper()
+			res := execute("WITH t AS MATERIALIZED (SELECT RANDOM() AS r FROM foo) SELECT COUNT(*), MIN(r) FROM t;")
+			return res.ResultRows[0][0].Text() + " " + res.ResultRows[0][1].Text()
+		}
+		first := random()
+		if got := random(); got != first {
+			t.Fatalf("expected the common table to be reused got %s then %s", first, got)
+		}
+		execute("INSERT INTO bar (id) VALUES (1);")
+		if got := random(); got != first {
+			t.Fatalf("expected a write to another table to keep the common table got %s then %s", first, got)
+		}
+		execute("INSERT INTO foo (name, age) VALUES ('d', 4);")
+		if got := random(); got == first || !strings.HasPrefix(got, "4 ") {
+			t.Fatalf("expected a write to foo to compute the common table again got %s then %s", first, got)
+		}
+	})
+
+	for _, sql := range []string{
+		"WITH t AS (SELECT name FROM foo WHERE age = ?) SELECT * FROM t;",
+		"WITH t (a, b) AS (SELECT name FROM foo) SELECT * FROM t;",
+		"WITH t AS (SELECT name FROM missing) SELECT * FROM t;",
+		"WITH t AS (SELECT name FROM foo SELECT * FROM t;",
+	} {
+		t.Run(sql, func(t *testing.T) {
+			if err := db.Execute(db.Tokenize(sql)[0], []any{1}).Err; err == nil {
+				t.Fatalf("want err but got nil for %s", sql)
+			}
+		})
+	}
+}
+
+func TestSchema(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	names := func(objects []catalog.Object) []string {
+		got := []string{}
+		for _, o := range objects {
+			got = append(got, o.Name)
+		}
+		return got
+	}
+	if got := names(db.Schema(SchemaOrderCreated)); !slices.Equal(got, []string{"foo", "bar", "foo_name"}) {
+		t.Fatalf("got unexpected created order %v", got)
+	}
+	if got := names(db.Schema(SchemaOrderName)); !slices.Equal(got, []string{"foo_name", "bar", "foo"}) {
+		t.Fatalf("got unexpected name order %v", got)
+	}
+	for _, o := range db.Schema(SchemaOrderName) {
+		if _, err := time.Parse(vm.TimestampLayout, o.Modified); err != nil {
+			t.Fatalf("expected modified time for %s but got %s", o.Name, err)
+		}
+	}
+	res := mustExecute(t, db, "SELECT name FROM cdb_schema WHERE modified IS NOT NULL ORDER BY type, name;")
+	if len(res.ResultRows) != 3 || res.ResultRows[0][0].Text() != "foo_name" {
+		t.Fatalf("got unexpected rows %v", res.ResultRows)
+	}
+}
+
+func TestSchemaTable(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	texts := func(res vm.ExecuteResult) []string {
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Text())
+		}
+		return got
+	}
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT name FROM cdb_schema ORDER BY name DESC;", want: []string{"foo_name", "foo", "bar"}},
+		{sql: "SELECT name FROM cdb_schema WHERE id = 2;", want: []string{"bar"}},
+		{sql: "SELECT name FROM cdb_schema WHERE rootpage > 2 AND type = 'table';", want: []string{"bar"}},
+		{sql: "SELECT type, COUNT(*) FROM cdb_schema GROUP BY type;", want: []string{"index", "table"}},
+		{sql: "SELECT typeof(rootpage) FROM cdb_schema WHERE id = 1;", want: []string{"integer"}},
+	}
+	for _, c := range cases {
+		if got := texts(mustExecute(t, db, c.sql)); !slices.Equal(got, c.want) {
+			t.Fatalf("%s: want %v got %v", c.sql, c.want, got)
+		}
+	}
+	if got := db.TableNames(); !slices.Equal(got, []string{"bar", "cdb_schema", "foo"}) {
+		t.Fatalf("got unexpected table names %v", got)
+	}
+
+	for _, sql := range []string{
+		"INSERT INTO cdb_schema (type, name) VALUES ('table', 'baz');",
+		"UPDATE cdb_schema SET name = 'baz';",
+		"DELETE FROM cdb_schema;",
+		"CREATE INDEX schema_name ON cdb_schema (name);",
+	} {
+		res := db.Execute(db.Tokenize(sql)[0], []any{})
+		if res.Err == nil || !strings.Contains(res.Err.Error(), "may not be modified") {
+			t.Fatalf("%s: expected cdb_schema to be read only got %v", sql, res.Err)
+		}
+	}
+	if got := texts(mustExecute(t, db, "SELECT COUNT(*) FROM cdb_schema;")); !slices.Equal(got, []string{"3"}) {
+		t.Fatalf("expected schema to be unchanged got %v rows", got)
+	}
+}
+
+func TestAnalyze(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	mustExecute(t, db, "CREATE UNIQUE INDEX foo_id ON foo (id);")
+	for i := range 10 {
+		mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (name) VALUES ('name%d');", i%4))
+	}
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES (NULL), (NULL);")
+	mustExecute(t, db, "INSERT INTO bar (id) VALUES (1), (2), (3);")
+	stats := func() []string {
+		res := mustExecute(t, db, "SELECT table_name, index_name, row_count, distinct_count FROM cdb_stat ORDER BY id;")
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, fmt.Sprintf("%v %v %v %v", row[0].Any(), row[1].Any(), row[2].Any(), row[3].Any()))
+		}
+		return got
+	}
+
+	mustExecute(t, db, "ANALYZE;")
+	want := []string{
+		"bar <nil> 3 <nil>",
+		"foo <nil> 12 <nil>",
+		"foo foo_name 12 6",
+		"foo foo_id 12 12",
+	}
+	if got := stats(); !slices.Equal(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+	if got := db.TableNames(); !slices.Contains(got, catalog.StatTableName) {
+		t.Fatalf("expected %s in table names %v", catalog.StatTableName, got)
+	}
+
+	t.Run("table", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO bar (id) VALUES (4);")
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('name0');")
+		mustExecute(t, db, "ANALYZE bar;")
+		want := []string{
+			"foo <nil> 12 <nil>",
+			"foo foo_name 12 6",
+			"foo foo_id 12 12",
+			"bar <nil> 4 <nil>",
+		}
+		if got := stats(); !slices.Equal(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("explain query plan", func(t *testing.T) {
+		res := mustExecute(t, db, "EXPLAIN QUERY PLAN ANALYZE;")
+		if got := res.Text; !strings.Contains(got, "analyze bar, foo") {
+			t.Fatalf("expected plan to analyze bar and foo got %s", got)
+		}
+	})
+
+	t.Run("no such table", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("ANALYZE baz;")[0], []any{})
+		if res.Err == nil || !strings.Contains(res.Err.Error(), "table does not exist") {
+			t.Fatalf("expected table does not exist got %v", res.Err)
+		}
+	})
+}
+
+func TestAutoAnalyze(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	for i := range 100 {
+		mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (name) VALUES ('name%d');", i%10))
+	}
+	mustExecute(t, db, "ANALYZE foo;")
+	rowCount := func() int {
+		db.refreshes.Wait()
+		res := mustExecute(t, db, "SELECT row_count FROM cdb_stat WHERE table_name = 'foo' AND index_name IS NULL;")
+		return res.ResultRows[0][0].Int()
+	}
+
+	t.Run("below threshold", func(t *testing.T) {
+		// The threshold of 100 rows is 50 + 10% of 100 changes.
+		for i := range 60 {
+			mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (name) VALUES ('name%d');", i))
+		}
+		if got := rowCount(); got != 100 {
+			t.Fatalf("want row count 100 got %d", got)
+		}
+	})
+
+	t.Run("past threshold", func(t *testing.T) {
+		mustExecute(t, db, "DELETE FROM foo WHERE id <= 10;")
+		if got := rowCount(); got != 150 {
+			t.Fatalf("want row count 150 got %d", got)
+		}
+		if got := db.Metrics().StatsRefreshes.Value(); got != 1 {
+			t.Fatalf("want 1 refresh got %d", got)
+		}
+	})
+
+	t.Run("script", func(t *testing.T) {
+		sql := ""
+		for i := range 80 {
+			sql += fmt.Sprintf("INSERT INTO foo (name) VALUES ('name%d');", i)
+		}
+		if _, err := db.ExecuteScript(context.Background(), sql); err != nil {
+			t.Fatal(err)
+		}
+		if got := rowCount(); got != 230 {
+			t.Fatalf("want row count 230 got %d", got)
+		}
+	})
+
+	t.Run("update", func(t *testing.T) {
+		mustExecute(t, db, "UPDATE foo SET name = 'renamed' WHERE id <= 100;")
+		if got := rowCount(); got != 230 {
+			t.Fatalf("want row count 230 got %d", got)
+		}
+		res := mustExecute(t, db, "SELECT distinct_count FROM cdb_stat WHERE index_name = 'foo_name';")
+		if got := res.ResultRows[0][0].Int(); got != 81 {
+			t.Fatalf("want distinct count 81 got %d", got)
+		}
+	})
+
+	t.Run("not analyzed", func(t *testing.T) {
+		for i := range 60 {
+			mustExecute(t, db, fmt.Sprintf("INSERT INTO bar (id) VALUES (%d);", i+1))
+		}
+		db.refreshes.Wait()
+		res := mustExecute(t, db, "SELECT * FROM cdb_stat WHERE table_name = 'bar';")
+		if len(res.ResultRows) != 0 {
+			t.Fatalf("expected bar to not be analyzed got %v", res.ResultRows)
+		}
+	})
+}
+
+func TestCostBasedPlan(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, kind TEXT, name TEXT);")
+	mustExecute(t, db, "CREATE INDEX foo_kind ON foo (kind);")
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	for i := range 100 {
+		mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (kind, name) VALUES ('k%d', 'n%d');", i%2, i))
+	}
+	plan := func(sql string) string {
+		return mustExecute(t, db, "EXPLAIN QUERY PLAN "+sql).Text
+	}
+	byKind := "SELECT * FROM foo WHERE kind = 'k0';"
+	if got := plan(byKind); !strings.Contains(got, "seek table foo using index foo_kind") {
+		t.Fatalf("expected index to be used without statistics got\n%s", got)
+	}
+	stmt := db.Tokenize(byKind)[0]
+	if res := db.Execute(stmt, []any{}); res.Err != nil || len(res.ResultRows) != 50 {
+		t.Fatalf("expected 50 rows got %d err %v", len(res.ResultRows), res.Err)
+	}
+
+	mustExecute(t, db, "ANALYZE;")
+	if got := plan(byKind); !strings.Contains(got, "scan table foo (~100 rows)") {
+		t.Fatalf("expected unselective index to be scanned instead got\n%s", got)
+	}
+	if res := db.Execute(stmt, []any{}); res.Err != nil || len(res.ResultRows) != 50 {
+		t.Fatalf("expected replanned statement to have 50 rows got %d err %v", len(res.ResultRows), res.Err)
+	}
+	byKindAndName := "SELECT name FROM foo WHERE kind = 'k0' AND name = 'n4';"
+	if got := plan(byKindAndName); !strings.Contains(got, "seek table foo using index foo_name (name = ?) (~1 rows)") {
+		t.Fatalf("expected selective index to be used got\n%s", got)
+	}
+	res := mustExecute(t, db, byKindAndName)
+	if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "n4" {
+		t.Fatalf("expected n4 got %v", res.ResultRows)
+	}
+	if got := plan("SELECT name FROM foo WHERE name = 'n4' AND id = 5;"); !strings.Contains(got, "seek table foo (id PRIMARY KEY = ?)") {
+		t.Fatalf("expected row id seek got\n%s", got)
+	}
+}
+
+func TestDigest(t *testing.T) {
+	digest := func(db *DB) string {
+		d, err := db.Digest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+	primary := mustCreateDB(t)
+	mustExecute(t, primary, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, primary, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	for i := range 500 {
+		mustExecute(t, primary, fmt.Sprintf("INSERT INTO foo (name) VALUES ('name%d');", i))
+	}
+
+	// The replica creates its tables in a different order, inserts rows in
+	// reverse and deletes rows so its pages are laid out differently.
+	replica := mustCreateDB(t)
+	mustExecute(t, replica, "CREATE TABLE bar (id INTEGER PRIMARY KEY);")
+	mustExecute(t, replica, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, replica, "CREATE INDEX foo_name ON foo (name);")
+	for i := 499; i >= 0; i -= 1 {
+		mustExecute(t, replica, fmt.Sprintf("INSERT INTO foo (id, name) VALUES (%d, 'name%d');", i+1, i))
+	}
+	mustExecute(t, replica, "INSERT INTO foo (id, name) VALUES (1000, 'extra');")
+	mustExecute(t, replica, "DELETE FROM foo WHERE id = 1000;")
+
+	if digest(primary) != digest(replica) {
+		t.Fatal("expected databases with the same rows to have the same digest")
+	}
+
+	t.Run("changed row", func(t *testing.T) {
+		mustExecute(t, replica, "UPDATE foo SET name = 'changed' WHERE id = 250;")
+		if digest(primary) == digest(replica) {
+			t.Fatal("expected changed row to change digest")
+		}
+		mustExecute(t, replica, "UPDATE foo SET name = 'name249' WHERE id = 250;")
+		if digest(primary) != digest(replica) {
+			t.Fatal("expected restored row to restore digest")
+		}
+	})
+
+	t.Run("changed schema", func(t *testing.T) {
+		mustExecute(t, replica, "CREATE TABLE baz (id INTEGER PRIMARY KEY);")
+		if digest(primary) == digest(replica) {
+			t.Fatal("expected new table to change digest")
+		}
+	})
+}
+
+func TestTableAndColumnNames(t *testing.T) {
+	db := mustCreateDB(t)
+	if got := strings.Join(db.TableNames(), ","); got != "cdb_schema" {
+		t.Fatalf("want cdb_schema but got %s", got)
+	}
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
+	if got := strings.Join(db.TableNames(), ","); got != "cdb_schema,test" {
+		t.Fatalf("want cdb_schema,test but got %s", got)
+	}
+	columns, err := db.ColumnNames("test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(columns, ","); got != "id,val" {
+		t.Fatalf("want id,val but got %s", got)
+	}
+}
+
+func TestLargeIntegers(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
+	mustExecute(t, db, "INSERT INTO test (id, val) VALUES (9223372036854775807, 9007199254740993)")
+	statements := db.Tokenize("INSERT INTO test (id, val) VALUES (?, ?)")
+	if err := db.Execute(statements[0], []any{int64(4294967296), uint32(4294967295)}).Err; err != nil {
+		t.Fatal(err)
+	}
+	res := mustExecute(t, db, "SELECT * FROM test")
+	want := [][]string{
+		{"4294967296", "4294967295"},
+		{"9223372036854775807", "9007199254740993"},
+	}
+	for i := range want {
+		for j := range want[i] {
+			if got := res.ResultRows[i][j].Text(); got != want[i][j] {
+				t.Fatalf("want %s but got %s", want[i][j], got)
+			}
+		}
+	}
+	statements = db.Tokenize("SELECT 9223372036854775808")
+	if err := db.Execute(statements[0], []any{}).Err; err == nil {
+		t.Fatal("want err for integer literal overflowing 64 bits but got nil")
+	}
+
+	t.Run("arithmetic overflow", func(t *testing.T) {
+		for _, sql := range []string{
+			"SELECT 9223372036854775807 + 1",
+			"SELECT 0 - 9223372036854775807 - 2",
+			"SELECT 4294967296 * 4294967296",
+			"SELECT id + 1 FROM test WHERE id = 9223372036854775807",
+			"SELECT -id - 2 FROM test WHERE id = 9223372036854775807",
+			"SELECT id * 2 FROM test WHERE id = 9223372036854775807",
+			"SELECT SUM(id) FROM test",
+		} {
+			err := db.Execute(db.Tokenize(sql)[0], []any{}).Err
+			if err == nil || !strings.Contains(err.Error(), "integer overflows 64 bits") {
+				t.Fatalf("want integer overflow for %s but got %v", sql, err)
+			}
+		}
+		res := mustExecute(t, db, "SELECT id - 1 + 1, 0 - 9223372036854775807 - 1 FROM test WHERE id = 9223372036854775807")
+		if got := res.ResultRows[0][0].Text() + "," + res.ResultRows[0][1].Text(); got != "9223372036854775807,-9223372036854775808" {
+			t.Fatalf("want 9223372036854775807,-9223372036854775808 got %s", got)
+		}
+	})
+}
+
+func TestResultColumnExprs(t *testing.T) {
+	type rcCase struct {
+		statement string
+		want      string
+	}
+	rcCases := []rcCase{
+		{
+			statement: "SELECT val + 2 FROM test",
+			want:      "24",
+		},
+		{
+			statement: "SELECT 3 + val + 2 FROM test",
+			want:      "27",
+		},
+		{
+			statement: "SELECT val > 2 FROM test",
+			want:      "1",
+		},
+		{
+			statement: "SELECT 3 > val > 2 FROM test",
+			want:      "0",
+		},
+		{
+			statement: "SELECT val < 2 FROM test",
+			want:      "0",
+		},
+		{
+			statement: "SELECT 3 < val < 2 FROM test",
+			want:      "1",
+		},
+		{
+			statement: "SELECT val = 2 FROM test",
+			want:      "0",
+		},
+		{
+			statement: "SELECT 3 = val = 2 FROM test",
+			want:      "0",
+		},
+		{
+			statement: "SELECT val - 2 FROM test",
+			want:      "20",
+		},
+		{
+			statement: "SELECT 3 - val - 2 FROM test",
+			want:      "-21",
+		},
+		{
+			statement: "SELECT val ^ 2 FROM test",
+			want:      "484",
+		},
+		{
+			statement: "SELECT val ^ 2 ^ 2 FROM test",
+			want:      "234256",
+		},
+		{
+			statement: "SELECT val * 2 FROM test",
+			want:      "44",
+		},
+		{
+			statement: "SELECT 2 * val * 2 FROM test",
+			want:      "88",
+		},
+		{
+			statement: "SELECT val / 2 FROM test",
+			want:      "11",
+		},
+		{
+			statement: "SELECT 44 / val / 2 FROM test",
+			want:      "1",
+		},
+	}
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
+	mustExecute(t, db, "INSERT INTO test (val) VALUES (22)")
+	for _, rcc := range rcCases {
+		t.Run(rcc.statement, func(t *testing.T) {
+			res := mustExecute(t, db, rcc.statement)
+			expectedRowCount := 1
+			if rowCount := len(res.ResultRows); rowCount != expectedRowCount {
+				t.Fatalf("want %d row but got %d", expectedRowCount, rowCount)
+			}
+			got := res.ResultRows[0][0].Text()
+			if got != rcc.want {
+				t.Fatalf("want %s but got %s", rcc.want, got)
+			}
+		})
+	}
+}
+
+func TestPredicateExprs(t *testing.T) {
+	type pCase struct {
+		statement        string
+		expectedRowCount int
+	}
+	pCases := []pCase{
+		{
+			statement:        "SELECT 1 FROM test WHERE val + 1",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 3 + val + 1",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val / 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 44 / val / 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val * 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 2 * val * 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val ^ 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val ^ 2 ^ 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val - 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 2 - val - 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val > 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 2 > val > 2",
+			expectedRowCount: 0,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val < 2",
+			expectedRowCount: 0,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 2 < val < 2",
+			expectedRowCount: 1,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE val = 2",
+			expectedRowCount: 0,
+		},
+		{
+			statement:        "SELECT 1 FROM test WHERE 3 = val = 2",
+			expectedRowCount: 0,
+		},
+	}
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, val INTEGER)")
+	mustExecute(t, db, "INSERT INTO test (val) VALUES (22)")
+	for _, pc := range pCases {
 		t.Run(pc.statement, func(t *testing.T) {
 			res := mustExecute(t, db, pc.statement)
 			if rowCount := len(res.ResultRows); rowCount != pc.expectedRowCount {
@@ -379,44 +1234,2586 @@ func TestPredicateExprs(t *testing.T) {
 	}
 }
 
-func TestUpdateStatement(t *testing.T) {
+func TestUpdateStatement(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (1,2), (3,4), (5,6);")
+	mustExecute(t, db, "UPDATE foo SET b = 1;")
+	res := mustExecute(t, db, "SELECT b FROM foo WHERE b = 1;")
+	if len(res.ResultRows) != 3 {
+		t.Fatalf("expected all 3 rows to be 1")
+	}
+}
+
+func TestUpdateLimit(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (n, name) VALUES (1, 'a'), (2, 'b'), (3, 'c'), (4, 'd');")
+	rows := func() string {
+		res := mustExecute(t, db, "SELECT n, name FROM foo;")
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Text()+row[1].Text())
+		}
+		return strings.Join(got, ",")
+	}
+	cases := []struct {
+		sql    string
+		params []any
+		want   string
+	}{
+		{"UPDATE foo SET n = n + 1, name = name || '!';", nil, "2a!,3b!,4c!,5d!"},
+		{"UPDATE foo SET n = n * 10 LIMIT 2;", nil, "20a!,30b!,4c!,5d!"},
+		{"UPDATE foo SET n = 0 LIMIT 0;", nil, "20a!,30b!,4c!,5d!"},
+		{"UPDATE foo SET n = n - 1 LIMIT -1;", nil, "19a!,29b!,3c!,4d!"},
+		{"UPDATE foo SET name = 'x' WHERE n < 10 LIMIT 1;", nil, "19a!,29b!,3x,4d!"},
+		{"UPDATE foo SET name = n LIMIT 1 + 2;", nil, "1919,2929,33,4d!"},
+		{"UPDATE foo SET n = 7 WHERE id > 1 LIMIT ?;", []any{1}, "1919,729,33,4d!"},
+	}
+	for _, c := range cases {
+		if res := db.Execute(db.Tokenize(c.sql)[0], c.params); res.Err != nil {
+			t.Fatalf("%s: %s", c.sql, res.Err)
+		}
+		if got := rows(); got != c.want {
+			t.Fatalf("%s: expected %s got %s", c.sql, c.want, got)
+		}
+	}
+	for _, sql := range []string{
+		"UPDATE foo SET n = 1 LIMIT n;",
+		"UPDATE foo SET n = 1 LIMIT 'a';",
+		"UPDATE foo SET n = 1 LIMIT 1 WHERE id = 1;",
+	} {
+		if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+			t.Fatalf("expected err for %s", sql)
+		}
+	}
+}
+
+func TestUpdatePrimaryKey(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER);")
+	mustExecute(t, db, "CREATE INDEX foo_n ON foo (n);")
+	mustExecute(t, db, "INSERT INTO foo (id, n) VALUES (1, 10), (2, 20), (3, 30);")
+	rows := func() string {
+		res := mustExecute(t, db, "SELECT id, n FROM foo;")
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Text()+":"+row[1].Text())
+		}
+		return strings.Join(got, ",")
+	}
+
+	mustExecute(t, db, "UPDATE foo SET id = id + 100, n = id;")
+	if got := rows(); got != "101:1,102:2,103:3" {
+		t.Fatalf("expected 101:1,102:2,103:3 got %s", got)
+	}
+	res := mustExecute(t, db, "SELECT id FROM foo WHERE n = 2;")
+	if len(res.ResultRows) != 1 || res.ResultRows[0][0].Int() != 102 {
+		t.Fatalf("expected index to find row 102 got %v", res.ResultRows)
+	}
+
+	mustExecute(t, db, "UPDATE foo SET id = id - 100 WHERE id > 101 LIMIT 1;")
+	if got := rows(); got != "2:2,101:1,103:3" {
+		t.Fatalf("expected 2:2,101:1,103:3 got %s", got)
+	}
+
+	sql := "UPDATE foo SET id = 103 WHERE id = 101;"
+	if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+		t.Fatalf("expected err for %s", sql)
+	}
+	if got := rows(); got != "2:2,101:1,103:3" {
+		t.Fatalf("expected failed update to change nothing got %s", got)
+	}
+
+	mustExecute(t, db, "CREATE UNIQUE INDEX foo_n_unique ON foo (n);")
+	mustExecute(t, db, "UPDATE foo SET id = n;")
+	if got := rows(); got != "1:1,2:2,3:3" {
+		t.Fatalf("expected 1:1,2:2,3:3 got %s", got)
+	}
+}
+
+func TestRowsAffected(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER);")
+	mustExecute(t, db, "CREATE INDEX foo_n ON foo (n);")
+	cases := []struct {
+		sql  string
+		want int
+	}{
+		{"INSERT INTO foo (id, n) VALUES (1, 1), (2, 2), (3, 3);", 3},
+		{"INSERT OR IGNORE INTO foo (id, n) VALUES (1, 1), (4, 4);", 1},
+		{"INSERT OR REPLACE INTO foo (id, n) VALUES (1, 10);", 1},
+		{"INSERT INTO foo (id, n) VALUES (2, 2) ON CONFLICT DO UPDATE SET n = 20;", 1},
+		{"UPDATE foo SET n = n + 1;", 4},
+		{"UPDATE foo SET n = 0 WHERE id > 2;", 2},
+		{"UPDATE foo SET n = 0 WHERE id > 10;", 0},
+		{"UPDATE foo SET id = id + 10 LIMIT 3;", 3},
+		{"SELECT * FROM foo;", 0},
+		{"DELETE FROM foo WHERE id = 11;", 1},
+		{"DELETE FROM foo;", 3},
+	}
+	for _, c := range cases {
+		res := mustExecute(t, db, c.sql)
+		if res.RowsAffected != c.want {
+			t.Fatalf("%s: expected %d rows affected got %d", c.sql, c.want, res.RowsAffected)
+		}
+	}
+
+	t.Run("execute many", func(t *testing.T) {
+		statement := db.Tokenize("INSERT INTO foo (n) VALUES (?);")[0]
+		res := db.ExecuteMany(context.Background(), statement, [][]any{{1}, {2}})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if res.RowsAffected != 2 {
+			t.Fatalf("expected 2 rows affected got %d", res.RowsAffected)
+		}
+	})
+}
+
+func TestLastInsertRowID(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER);")
+	cases := []struct {
+		sql  string
+		want int
+	}{
+		{"INSERT INTO foo (n) VALUES (1), (2);", 2},
+		{"INSERT INTO foo (id, n) VALUES (10, 3);", 10},
+		{"INSERT INTO foo (n) VALUES (4);", 11},
+		{"INSERT OR IGNORE INTO foo (id, n) VALUES (1, 5);", 0},
+		{"INSERT OR REPLACE INTO foo (id, n) VALUES (1, 5);", 1},
+		{"UPDATE foo SET id = id + 100;", 0},
+		{"SELECT * FROM foo;", 0},
+	}
+	for _, c := range cases {
+		res := mustExecute(t, db, c.sql)
+		if res.LastInsertRowID != c.want {
+			t.Fatalf("%s: expected last insert row id %d got %d", c.sql, c.want, res.LastInsertRowID)
+		}
+	}
+
+	t.Run("prepared statement", func(t *testing.T) {
+		p, err := db.NewPreparedStatement("INSERT INTO foo (n) VALUES (?);")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := p.LastInsertRowID(); got != 0 {
+			t.Fatalf("expected 0 before execute got %d", got)
+		}
+		res := p.ExecuteMany([][]any{{1}, {2}})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		p.Result = &res
+		if got := p.LastInsertRowID(); got != 113 {
+			t.Fatalf("expected 113 got %d", got)
+		}
+	})
+}
+
+func TestDeleteAll(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1), (2), (3);")
+	mustExecute(t, db, "DELETE FROM foo;")
+	res := mustExecute(t, db, "SELECT * FROM foo;")
+	if lrr := len(res.ResultRows); lrr != 0 {
+		t.Fatalf("expected no rows but got %d", lrr)
+	}
+}
+
+func TestDeleteStatementWithWhere(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (11), (12), (13);")
+	mustExecute(t, db, "DELETE FROM foo WHERE a = 12;")
+	res := mustExecute(t, db, "SELECT * FROM foo;")
+	expectedRows := 2
+	if lrr := len(res.ResultRows); lrr != expectedRows {
+		t.Fatalf("expected %d rows but got %d", expectedRows, lrr)
+	}
+	want1 := "11"
+	if got1 := res.ResultRows[0][1].Text(); got1 != want1 {
+		t.Fatalf("expected %s but got %s", want1, got1)
+	}
+	want2 := "13"
+	if got2 := res.ResultRows[1][1].Text(); got2 != want2 {
+		t.Fatalf("expected %s but got %s", want2, got2)
+	}
+}
+
+func TestCreateIndex(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('b', 1), ('a', 2), ('b', 3);")
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+	mustExecute(t, db, "CREATE INDEX idx_id ON foo (id);")
+	res := mustExecute(t, db, "SELECT type, name, table_name FROM cdb_schema WHERE type = 'index';")
+	want := [][]string{{"index", "idx_name", "foo"}, {"index", "idx_id", "foo"}}
+	if len(res.ResultRows) != len(want) {
+		t.Fatalf("expected %d indexes got %d", len(want), len(res.ResultRows))
+	}
+	for i, row := range want {
+		for j, v := range row {
+			if got := res.ResultRows[i][j].Text(); got != v {
+				t.Fatalf("expected %s got %s", v, got)
+			}
+		}
+	}
+
+	// Deleting an index entry that does not exist is an error so updating and
+	// deleting every row shows the indexes are in sync with the table.
+	mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('c', 4);")
+	mustExecute(t, db, "UPDATE foo SET name = 'z' WHERE age = 1;")
+	mustExecute(t, db, "UPDATE foo SET age = 5;")
+	mustExecute(t, db, "DELETE FROM foo WHERE name = 'z';")
+	mustExecute(t, db, "DELETE FROM foo;")
+	res = mustExecute(t, db, "SELECT * FROM foo;")
+	if lrr := len(res.ResultRows); lrr != 0 {
+		t.Fatalf("expected no rows but got %d", lrr)
+	}
+
+	t.Run("if not exists", func(t *testing.T) {
+		mustExecute(t, db, "CREATE INDEX IF NOT EXISTS idx_name ON foo (name);")
+	})
+
+	errCases := []string{
+		"CREATE INDEX idx_name ON foo (name);",
+		"CREATE INDEX foo ON foo (name);",
+		"CREATE INDEX idx_bar ON bar (name);",
+		"CREATE INDEX idx_missing ON foo (missing);",
+		"CREATE INDEX idx_include_missing ON foo (name) INCLUDE (missing);",
+		"CREATE TABLE idx_name (id INTEGER PRIMARY KEY);",
+	}
+	for _, sql := range errCases {
+		t.Run(sql, func(t *testing.T) {
+			res := db.Execute(db.Tokenize(sql)[0], []any{})
+			if res.Err == nil {
+				t.Fatalf("expected err for %s", sql)
+			}
+		})
+	}
+}
+
+func TestUniqueIndex(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('a', 1), ('b', 2), ('c', 3);")
+	mustExecute(t, db, "CREATE UNIQUE INDEX idx_name ON foo (name);")
+
+	names := func() []string {
+		res := mustExecute(t, db, "SELECT name FROM foo;")
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Text())
+		}
+		return got
+	}
+
+	t.Run("insert duplicate", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("INSERT INTO foo (name, age) VALUES ('d', 4), ('a', 5);")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected unique constraint err")
+		}
+		if got := names(); !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Fatalf("expected insert to be rolled back but got %v", got)
+		}
+	})
+
+	t.Run("update rolls back every row", func(t *testing.T) {
+		// The first row is updated before the second row violates the
+		// constraint so the whole statement must be rolled back.
+		res := db.Execute(db.Tokenize("UPDATE foo SET name = 'z' WHERE age > 1;")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected unique constraint err")
+		}
+		if got := names(); !slices.Equal(got, []string{"a", "b", "c"}) {
+			t.Fatalf("expected update to be rolled back but got %v", got)
+		}
+	})
+
+	t.Run("update to own value", func(t *testing.T) {
+		mustExecute(t, db, "UPDATE foo SET name = 'a', age = 6 WHERE name = 'a';")
+		mustExecute(t, db, "UPDATE foo SET name = 'y' WHERE name = 'a';")
+		if got := names(); !slices.Equal(got, []string{"y", "b", "c"}) {
+			t.Fatalf("expected updated names but got %v", got)
+		}
+	})
+
+	t.Run("nulls are distinct", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (name, age) VALUES (NULL, 7), (NULL, 8);")
+		mustExecute(t, db, "DELETE FROM foo WHERE name IS NULL;")
+	})
+
+	t.Run("create over duplicates", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('d', 2);")
+		res := db.Execute(db.Tokenize("CREATE UNIQUE INDEX idx_age ON foo (age);")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected unique constraint err")
+		}
+		if db.catalog.IndexExists("idx_age") {
+			t.Fatal("expected index to not be created")
+		}
+	})
+}
+
+func TestInsertConflict(t *testing.T) {
+	rows := func(t *testing.T, db *DB) [][]string {
+		res := mustExecute(t, db, "SELECT id, name, n FROM foo;")
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			got = append(got, []string{row[0].Text(), row[1].Text(), row[2].Text()})
+		}
+		return got
+	}
+	setup := func(t *testing.T) *DB {
+		db := mustCreateDB(t)
+		mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, n INTEGER);")
+		mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+		mustExecute(t, db, "INSERT INTO foo (id, name, n) VALUES (1, 'a', 1), (2, 'b', 2);")
+		return db
+	}
+	cases := []struct {
+		name string
+		sql  string
+		want [][]string
+	}{
+		{
+			name: "or ignore",
+			sql:  "INSERT OR IGNORE INTO foo (id, name, n) VALUES (1, 'x', 10), (3, 'c', 3);",
+			want: [][]string{{"1", "a", "1"}, {"2", "b", "2"}, {"3", "c", "3"}},
+		},
+		{
+			name: "do nothing",
+			sql:  "INSERT INTO foo (id, name, n) VALUES (2, 'x', 10) ON CONFLICT (id) DO NOTHING;",
+			want: [][]string{{"1", "a", "1"}, {"2", "b", "2"}},
+		},
+		{
+			name: "or replace",
+			sql:  "INSERT OR REPLACE INTO foo (id, name, n) VALUES (1, 'x', NULL);",
+			want: [][]string{{"1", "x", ""}, {"2", "b", "2"}},
+		},
+		{
+			name: "do update",
+			sql:  "INSERT INTO foo (id, name, n) VALUES (1, 'x', 10), (3, 'c', 3), (3, 'd', 4) ON CONFLICT DO UPDATE SET name = excluded.name, n = n + excluded.n;",
+			want: [][]string{{"1", "x", "11"}, {"2", "b", "2"}, {"3", "d", "7"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			db := setup(t)
+			mustExecute(t, db, c.sql)
+			if got := rows(t, db); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expected %v got %v", c.want, got)
+			}
+			// The index must have an entry for each row and no others.
+			for _, row := range c.want {
+				res := mustExecute(t, db, "SELECT id FROM foo WHERE name = '"+row[1]+"';")
+				if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != row[0] {
+					t.Fatalf("expected index entry %s for %s got %v", row[0], row[1], res.ResultRows)
+				}
+			}
+			res := mustExecute(t, db, "SELECT COUNT(*) FROM foo WHERE name = 'x' OR name = 'a';")
+			want := 0
+			for _, row := range c.want {
+				if row[1] == "x" || row[1] == "a" {
+					want += 1
+				}
+			}
+			if got := res.ResultRows[0][0].Int(); got != want {
+				t.Fatalf("expected %d rows named x or a got %d", want, got)
+			}
+		})
+	}
+
+	t.Run("unique index", func(t *testing.T) {
+		cases := []struct {
+			name string
+			sql  string
+			want [][]string
+		}{
+			{
+				name: "or ignore",
+				sql:  "INSERT OR IGNORE INTO foo (name, n) VALUES ('a', 10), ('c', 3), ('c', 4);",
+				want: [][]string{{"1", "a", "1"}, {"2", "b", "2"}, {"3", "c", "3"}},
+			},
+			{
+				name: "do nothing",
+				sql:  "INSERT INTO foo (id, name, n) VALUES (5, 'b', 10) ON CONFLICT DO NOTHING;",
+				want: [][]string{{"1", "a", "1"}, {"2", "b", "2"}},
+			},
+			{
+				name: "or replace",
+				sql:  "INSERT OR REPLACE INTO foo (id, name, n) VALUES (3, 'a', 10);",
+				want: [][]string{{"2", "b", "2"}, {"3", "a", "10"}},
+			},
+			{
+				name: "or replace primary key and unique index",
+				sql:  "INSERT OR REPLACE INTO foo (id, name, n) VALUES (1, 'b', 10);",
+				want: [][]string{{"1", "b", "10"}},
+			},
+		}
+		for _, c := range cases {
+			t.Run(c.name, func(t *testing.T) {
+				db := mustCreateDB(t)
+				mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, n INTEGER);")
+				mustExecute(t, db, "CREATE UNIQUE INDEX idx_name ON foo (name);")
+				mustExecute(t, db, "INSERT INTO foo (id, name, n) VALUES (1, 'a', 1), (2, 'b', 2);")
+				mustExecute(t, db, c.sql)
+				if got := rows(t, db); !reflect.DeepEqual(got, c.want) {
+					t.Fatalf("expected %v got %v", c.want, got)
+				}
+				for _, row := range c.want {
+					res := mustExecute(t, db, "SELECT id FROM foo WHERE name = '"+row[1]+"';")
+					if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != row[0] {
+						t.Fatalf("expected index entry %s for %s got %v", row[0], row[1], res.ResultRows)
+					}
+				}
+			})
+		}
+
+		t.Run("do update", func(t *testing.T) {
+			db := mustCreateDB(t)
+			mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, n INTEGER);")
+			mustExecute(t, db, "CREATE UNIQUE INDEX idx_name ON foo (name);")
+			mustExecute(t, db, "INSERT INTO foo (id, name, n) VALUES (1, 'a', 1);")
+			sql := "INSERT INTO foo (id, name, n) VALUES (2, 'a', 2) ON CONFLICT DO UPDATE SET n = 5;"
+			if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+				t.Fatalf("expected unique constraint err for %s", sql)
+			}
+		})
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		db := setup(t)
+		for _, sql := range []string{
+			"INSERT INTO foo (id, name, n) VALUES (1, 'x', 1);",
+			"INSERT INTO foo (id, name, n) VALUES (1, 'x', 1) ON CONFLICT (name) DO NOTHING;",
+			"INSERT INTO foo (id, name, n) VALUES (1, 'x', 1) ON CONFLICT DO UPDATE SET id = 5;",
+			"INSERT INTO foo (id, name, n) VALUES (1, 'x', 1) ON CONFLICT DO UPDATE SET foo = 5;",
+			"INSERT INTO foo (id, name, n) VALUES (1, 'x', 1) ON CONFLICT DO UPDATE SET n = excluded.foo;",
+			"INSERT OR IGNORE INTO foo (id, name, n) VALUES (1, 'x', 1) ON CONFLICT DO NOTHING;",
+		} {
+			if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+				t.Fatalf("expected err for %s", sql)
+			}
+		}
+	})
+}
+
+func TestInsertWithoutColumnList(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (name TEXT, id INTEGER PRIMARY KEY, n INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo VALUES ('a', 1, 10), ('b', 2, NULL);")
+	res := mustExecute(t, db, "SELECT id, name, n FROM foo;")
+	want := [][]string{{"1", "a", "10"}, {"2", "b", ""}}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+	sql := "INSERT INTO foo VALUES ('c', 3);"
+	if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+		t.Fatalf("expected err for %s", sql)
+	}
+}
+
+func TestAutoIncrement(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY AUTOINCREMENT, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a'), ('b'), ('c');")
+
+	ids := func() []int {
+		res := mustExecute(t, db, "SELECT id FROM foo;")
+		got := []int{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Int())
+		}
+		return got
+	}
+
+	t.Run("deleted max row id is not reused", func(t *testing.T) {
+		mustExecute(t, db, "DELETE FROM foo WHERE id = 3;")
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('d');")
+		if got := ids(); !slices.Equal(got, []int{1, 2, 4}) {
+			t.Fatalf("expected ids [1 2 4] but got %v", got)
+		}
+	})
+
+	t.Run("explicit row id raises sequence", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (id, name) VALUES (10, 'e');")
+		mustExecute(t, db, "DELETE FROM foo WHERE id = 10;")
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('f');")
+		if got := ids(); !slices.Equal(got, []int{1, 2, 4, 11}) {
+			t.Fatalf("expected ids [1 2 4 11] but got %v", got)
+		}
+	})
+
+	t.Run("sequence table", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT name, seq FROM cdb_sequence;")
+		if len(res.ResultRows) != 1 {
+			t.Fatalf("expected 1 row but got %d", len(res.ResultRows))
+		}
+		if name := res.ResultRows[0][0].Text(); name != "foo" {
+			t.Fatalf("expected name foo but got %s", name)
+		}
+		if seq := res.ResultRows[0][1].Int(); seq != 11 {
+			t.Fatalf("expected seq 11 but got %d", seq)
+		}
+	})
+
+	t.Run("rolled back insert does not advance sequence", func(t *testing.T) {
+		mustExecute(t, db, "CREATE UNIQUE INDEX idx_name ON foo (name);")
+		res := db.Execute(db.Tokenize("INSERT INTO foo (name) VALUES ('g'), ('a');")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected unique constraint err")
+		}
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('g');")
+		if got := ids(); !slices.Equal(got, []int{1, 2, 4, 11, 12}) {
+			t.Fatalf("expected ids [1 2 4 11 12] but got %v", got)
+		}
+	})
+
+	t.Run("without autoincrement", func(t *testing.T) {
+		mustExecute(t, db, "CREATE TABLE baz (id INTEGER PRIMARY KEY, name TEXT);")
+		mustExecute(t, db, "INSERT INTO baz (name) VALUES ('a'), ('b');")
+		mustExecute(t, db, "DELETE FROM baz WHERE id = 2;")
+		mustExecute(t, db, "INSERT INTO baz (name) VALUES ('c');")
+		res := mustExecute(t, db, "SELECT id FROM baz WHERE name = 'c';")
+		if id := res.ResultRows[0][0].Int(); id != 2 {
+			t.Fatalf("expected reused id 2 but got %d", id)
+		}
+	})
+}
+
+func TestPrimaryKeySeek(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a'), ('b'), ('c');")
+
+	cases := []struct {
+		sql    string
+		params []any
+		want   [][]string
+	}{
+		{sql: "SELECT * FROM foo WHERE id = 2;", want: [][]string{{"2", "b"}}},
+		{sql: "SELECT * FROM foo WHERE 3 = id;", want: [][]string{{"3", "c"}}},
+		{sql: "SELECT * FROM foo WHERE id = ?;", params: []any{1}, want: [][]string{{"1", "a"}}},
+		{sql: "SELECT * FROM foo WHERE id = ?;", params: []any{"2"}, want: [][]string{{"2", "b"}}},
+		{sql: "SELECT * FROM foo WHERE id = '02';", want: [][]string{{"2", "b"}}},
+		{sql: "SELECT * FROM foo WHERE id = 'b';", want: [][]string{}},
+		{sql: "SELECT * FROM foo WHERE id = 4;", want: [][]string{}},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %v", c.sql, c.params), func(t *testing.T) {
+			if c.params == nil {
+				c.params = []any{}
+			}
+			res := db.Execute(db.Tokenize("EXPLAIN QUERY PLAN " + c.sql)[0], c.params)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if !strings.Contains(res.Text, "seek table foo") {
+				t.Fatalf("expected plan to seek got %s", res.Text)
+			}
+			res = db.Execute(db.Tokenize(c.sql)[0], c.params)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				got = append(got, []string{row[0].Text(), row[1].Text()})
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestLike(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('apple'), ('apricot'), ('banana'), ('Apple'), (NULL), (12), ('a%b');")
+
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT id FROM foo WHERE name LIKE 'ap%';", want: []string{"1", "2"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE 'ap%t';", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE '_pple';", want: []string{"1", "4"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE '%an%';", want: []string{"3"}},
+		{sql: "SELECT id, name FROM foo WHERE name LIKE 'ban%';", want: []string{"3"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE '1%';", want: []string{"6"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE 'a%b';", want: []string{"7"}},
+		{sql: "SELECT id FROM foo WHERE name NOT LIKE 'a%';", want: []string{"3", "4", "6"}},
+		{sql: "SELECT id FROM foo WHERE name LIKE 'ap%' OR name LIKE 'b%';", want: []string{"1", "2", "3"}},
+	}
+	run := func(t *testing.T) {
+		for _, c := range cases {
+			t.Run(c.sql, func(t *testing.T) {
+				res := mustExecute(t, db, c.sql)
+				got := []string{}
+				for _, row := range res.ResultRows {
+					got = append(got, row[0].Text())
+				}
+				slices.Sort(got)
+				if !reflect.DeepEqual(got, c.want) {
+					t.Fatalf("want %v got %v", c.want, got)
+				}
+			})
+		}
+	}
+	t.Run("scan", run)
+
+	mustExecute(t, db, "CREATE INDEX foo_name ON foo (name);")
+	res := mustExecute(t, db, "EXPLAIN QUERY PLAN SELECT id FROM foo WHERE name LIKE 'ap%';")
+	if !strings.Contains(res.Text, "prefix seek table foo using covering index foo_name") {
+		t.Fatalf("expected plan to prefix seek got %s", res.Text)
+	}
+	res = mustExecute(t, db, "EXPLAIN QUERY PLAN SELECT id FROM foo WHERE name LIKE '%p';")
+	if strings.Contains(res.Text, "seek") {
+		t.Fatalf("expected plan to scan got %s", res.Text)
+	}
+	t.Run("index", run)
+
+	t.Run("results", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT name LIKE 'a%', name NOT LIKE 'a%' FROM foo WHERE id = 5;")
+		if !res.ResultRows[0][0].IsNull() || !res.ResultRows[0][1].IsNull() {
+			t.Fatal("expected NULL LIKE to be NULL")
+		}
+	})
+}
+
+func TestPrimaryKeyRangeScan(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	for i := 1; i <= 300; i += 1 {
+		mustExecute(t, db, fmt.Sprintf("INSERT INTO foo (id, name) VALUES (%d, 'a');", i))
+	}
+	insertNegative := db.Tokenize("INSERT INTO foo (id, name) VALUES (?, 'b'), (0, 'c');")[0]
+	if res := db.Execute(insertNegative, []any{-200}); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+
+	res := mustExecute(t, db, "EXPLAIN QUERY PLAN SELECT * FROM foo WHERE id > 295;")
+	if !strings.Contains(res.Text, "scan table foo after row id") {
+		t.Fatalf("expected plan to scan after the bound got %s", res.Text)
+	}
+	res = mustExecute(t, db, "EXPLAIN QUERY PLAN SELECT * FROM foo WHERE id > 1 AND id < 4;")
+	if !strings.Contains(res.Text, "scan table foo after row id ? before row id ?") {
+		t.Fatalf("expected plan to scan between the bounds got %s", res.Text)
+	}
+	cases := []struct {
+		sql    string
+		params []any
+		want   string
+	}{
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 295;", want: "5"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE 295 < id;", want: "5"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > '295';", want: "5"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 'a';", want: "0"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ?;", params: []any{-300}, want: "302"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ?;", params: []any{-1}, want: "301"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ?;", params: []any{-1.5}, want: "301"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ?;", params: []any{295.5}, want: "5"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 0;", want: "300"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND id < 4;", want: "2"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND id <= 4;", want: "3"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND 4 > id;", want: "2"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND id < '4';", want: "2"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND id < 'a';", want: "299"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > 1 AND id < NULL;", want: "0"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ? AND id < 4;", params: []any{-300}, want: "5"},
+		{sql: "SELECT COUNT(*) FROM foo WHERE id > ? AND id < 4;", params: []any{-0.5}, want: "4"},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s %v", c.sql, c.params), func(t *testing.T) {
+			if c.params == nil {
+				c.params = []any{}
+			}
+			res := db.Execute(db.Tokenize(c.sql)[0], c.params)
+			if res.Err != nil {
+				t.Fatal(res.Err)
+			}
+			if got := res.ResultRows[0][0].Text(); got != c.want {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectWithIndex(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (name, age) VALUES ('b', 1), ('a', 2), ('b', 3), ('12', 4);")
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name);")
+
+	cases := []struct {
+		sql  string
+		plan string
+		want [][]string
+	}{
+		{
+			sql:  "SELECT * FROM foo WHERE name = 'b';",
+			plan: "using index idx_name",
+			want: [][]string{{"1", "b", "1"}, {"3", "b", "3"}},
+		},
+		{
+			sql:  "SELECT id, name FROM foo WHERE 'b' = name;",
+			plan: "using covering index idx_name",
+			want: [][]string{{"1", "b"}, {"3", "b"}},
+		},
+		{
+			sql:  "SELECT id FROM foo WHERE name = 12;",
+			plan: "using covering index idx_name",
+			want: [][]string{{"4"}},
+		},
+		{
+			sql:  "SELECT age FROM foo WHERE name = 'z';",
+			plan: "using index idx_name",
+			want: [][]string{},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, "EXPLAIN QUERY PLAN "+c.sql)
+			if !strings.Contains(res.Text, c.plan) {
+				t.Fatalf("expected plan to contain %q got %s", c.plan, res.Text)
+			}
+			res = mustExecute(t, db, c.sql)
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				values := []string{}
+				for _, v := range row {
+					values = append(values, v.Text())
+				}
+				got = append(got, values)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSelectWithIncludedColumns(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, age INTEGER, email TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name, age, email) VALUES ('b', 1, 'x'), ('a', 2, 'y'), ('b', 3, 'z');")
+	mustExecute(t, db, "CREATE INDEX idx_name ON foo (name) INCLUDE (age);")
+
+	steps := []struct {
+		sql  string
+		plan string
+		want [][]string
+	}{
+		{
+			sql:  "SELECT id, name, age FROM foo WHERE name = 'b';",
+			plan: "using covering index idx_name",
+			want: [][]string{{"1", "b", "1"}, {"3", "b", "3"}},
+		},
+		{
+			sql:  "SELECT name, age FROM foo ORDER BY name;",
+			plan: "using covering index idx_name",
+			want: [][]string{{"a", "2"}, {"b", "1"}, {"b", "3"}},
+		},
+		{
+			sql:  "SELECT email FROM foo WHERE name = 'b';",
+			plan: "using index idx_name",
+			want: [][]string{{"x"}, {"z"}},
+		},
+		{sql: "UPDATE foo SET age = 10 WHERE id = 3;"},
+		{sql: "UPDATE foo SET id = 5 WHERE id = 1;"},
+		{sql: "DELETE FROM foo WHERE id = 2;"},
+		{sql: "INSERT INTO foo (name, age, email) VALUES ('b', 4, 'w');"},
+		{
+			sql:  "SELECT id, name, age FROM foo WHERE name = 'b';",
+			plan: "using covering index idx_name",
+			want: [][]string{{"3", "b", "10"}, {"5", "b", "1"}, {"6", "b", "4"}},
+		},
+	}
+	for _, step := range steps {
+		if step.plan == "" {
+			mustExecute(t, db, step.sql)
+			continue
+		}
+		res := mustExecute(t, db, "EXPLAIN QUERY PLAN "+step.sql)
+		if !strings.Contains(res.Text, step.plan) {
+			t.Fatalf("expected plan for %s to contain %q got %s", step.sql, step.plan, res.Text)
+		}
+		res = mustExecute(t, db, step.sql)
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			values := []string{}
+			for _, v := range row {
+				values = append(values, v.Text())
+			}
+			got = append(got, values)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Fatalf("%s: want %v got %v", step.sql, step.want, got)
+		}
+	}
+}
+
+func TestIndexOnNumbers(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER);")
+	mustExecute(t, db, "CREATE INDEX foo_n ON foo (n);")
+	mustExecute(t, db, "INSERT INTO foo (n) VALUES (CAST('2.5' AS REAL)), (2), (3), (-1), (CAST('-0.5' AS REAL));")
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT n FROM foo ORDER BY n;", want: []string{"-1", "-0.5", "2", "2.5", "3"}},
+		{sql: "SELECT n FROM foo ORDER BY n DESC;", want: []string{"3", "2.5", "2", "-0.5", "-1"}},
+		{sql: "SELECT id FROM foo WHERE n = 2;", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE n = '3';", want: []string{"3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, "EXPLAIN QUERY PLAN "+c.sql)
+			if !strings.Contains(res.Text, "index foo_n") {
+				t.Fatalf("expected plan to use index foo_n got %s", res.Text)
+			}
+			res = mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestTypeof(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a'), ('12');")
+	res := mustExecute(t, db, "SELECT typeof(id), typeof(name), typeof(1), typeof(name + 1) FROM foo WHERE typeof(name) = 'text';")
+	want := []string{"integer", "text", "integer", "integer"}
+	if len(res.ResultRows) != 2 {
+		t.Fatalf("expected 2 rows got %d", len(res.ResultRows))
+	}
+	for i, w := range want {
+		if got := res.ResultRows[0][i].Text(); got != w {
+			t.Fatalf("expected %s got %s", w, got)
+		}
+	}
+
+	t.Run("parameters", func(t *testing.T) {
+		statement := db.Tokenize("SELECT typeof(?), typeof(?), ?, ? + 1;")[0]
+		res := db.Execute(statement, []any{1, "a", "b", "2"})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		want := []string{"integer", "text", "b", "3"}
+		for i, w := range want {
+			if got := res.ResultRows[0][i].Text(); got != w {
+				t.Fatalf("expected %s got %s", w, got)
+			}
+		}
+		wantTypes := []catalog.CdbType{
+			{ID: catalog.CTStr},
+			{ID: catalog.CTStr},
+			{ID: catalog.CTStr, VarPosition: 2},
+			{ID: catalog.CTInt},
+		}
+		if !reflect.DeepEqual(res.ResultTypes, wantTypes) {
+			t.Fatalf("expected types %v got %v", wantTypes, res.ResultTypes)
+		}
+
+		res = db.Execute(statement, []any{1, "a", 5, "2"})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		if got := res.ResultTypes[2].ID; got != catalog.CTInt {
+			t.Fatalf("expected the type of a reused statement to follow the parameter got %d", got)
+		}
+	})
+}
+
+func TestAffinity(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, age INTEGER, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (id, age, name) VALUES ('1', '42', 7), (2, 'abc', 'a');")
+	res := mustExecute(t, db, "SELECT typeof(id), typeof(age), typeof(name) FROM foo;")
+	want := [][]string{
+		{"integer", "integer", "text"},
+		{"integer", "text", "text"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+
+	t.Run("update", func(t *testing.T) {
+		mustExecute(t, db, "UPDATE foo SET age = '7' WHERE id = 2;")
+		res := mustExecute(t, db, "SELECT typeof(age) FROM foo WHERE id = 2;")
+		if got := res.ResultRows[0][0].Text(); got != "integer" {
+			t.Fatalf("expected integer got %s", got)
+		}
+	})
+
+	t.Run("primary key", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("INSERT INTO foo (id) VALUES ('a');")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected err for primary key that is not an integer")
+		}
+	})
+}
+
+func TestCast(t *testing.T) {
+	db := mustCreateDB(t)
+	res := mustExecute(t, db, "SELECT CAST('12abc' AS INTEGER), typeof(CAST(1 AS TEXT)), CAST(CAST('1.5' AS REAL) AS TEXT), CAST(NULL AS INTEGER), typeof(CAST('3.0' AS NUMERIC));")
+	want := []string{"12", "text", "1.5", "", "integer"}
+	for i, w := range want {
+		if got := res.ResultRows[0][i].Text(); got != w {
+			t.Fatalf("col %d expected %s got %s", i, w, got)
+		}
+	}
+	if !res.ResultRows[0][3].IsNull() {
+		t.Fatalf("expected NULL got %s", res.ResultRows[0][3])
+	}
+
+	t.Run("where", func(t *testing.T) {
+		mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('1'), ('2x');")
+		res := mustExecute(t, db, "SELECT id FROM foo WHERE CAST(name AS INTEGER) = 2;")
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "2" {
+			t.Fatalf("expected row 2 got %v", res.ResultRows)
+		}
+	})
+}
+
+func TestStringFunctions(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES (' Ada Lovelace '), (NULL);")
+	res := mustExecute(t, db, "SELECT UPPER(name), lower(name), LENGTH(name), SUBSTR(name, 2, 3), TRIM(name) FROM foo;")
+	want := []string{" ADA LOVELACE ", " ada lovelace ", "14", "Ada", "Ada Lovelace"}
+	for i, w := range want {
+		if got := res.ResultRows[0][i].Text(); got != w {
+			t.Fatalf("col %d expected %q got %q", i, w, got)
+		}
+	}
+	for i, v := range res.ResultRows[1] {
+		if !v.IsNull() {
+			t.Fatalf("col %d expected NULL got %s", i, v)
+		}
+	}
+	wantTypes := []catalog.CdbType{
+		{ID: catalog.CTStr},
+		{ID: catalog.CTStr},
+		{ID: catalog.CTInt},
+		{ID: catalog.CTStr},
+		{ID: catalog.CTStr},
+	}
+	if !reflect.DeepEqual(res.ResultTypes, wantTypes) {
+		t.Fatalf("expected types %v got %v", wantTypes, res.ResultTypes)
+	}
+
+	t.Run("where", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT id FROM foo WHERE LOWER(TRIM(name)) = 'ada lovelace';")
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Int() != 1 {
+			t.Fatalf("expected row 1 got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("constant arguments are folded", func(t *testing.T) {
+		res := mustExecute(t, db, "EXPLAIN SELECT UPPER(SUBSTR('hello', 2)) FROM foo;")
+		for _, row := range res.ResultRows {
+			if op := row[1].Text(); op == "Function" {
+				t.Fatalf("expected function to be folded got %v", row)
+			}
+		}
+		res = mustExecute(t, db, "SELECT UPPER(SUBSTR('hello', 2));")
+		if got := res.ResultRows[0][0].Text(); got != "ELLO" {
+			t.Fatalf("expected ELLO got %s", got)
+		}
+	})
+
+	t.Run("wrong number of arguments", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("SELECT SUBSTR(name) FROM foo;")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected err")
+		}
+	})
+}
+
+func TestNumericFunctions(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, age INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (age) VALUES (-5), (3);")
+	res := mustExecute(t, db, "SELECT ABS(age), ROUND(age * 3 / 2), MIN(age, 0), MAX(age, 0, id) FROM foo;")
+	want := [][]string{
+		{"5", "-7", "-5", "1"},
+		{"3", "4", "0", "3"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+
+	t.Run("aggregate MIN and MAX", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT MIN(age), MAX(age) FROM foo;")
+		if len(res.ResultRows) != 1 {
+			t.Fatalf("expected 1 row got %d", len(res.ResultRows))
+		}
+		if got := res.ResultRows[0][0].Text() + "," + res.ResultRows[0][1].Text(); got != "-5,3" {
+			t.Fatalf("expected -5,3 got %s", got)
+		}
+	})
+
+	t.Run("RANDOM is not folded", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT RANDOM(), RANDOM() FROM foo;")
+		seen := map[int]bool{}
+		for _, row := range res.ResultRows {
+			for _, v := range row {
+				seen[v.Int()] = true
+			}
+		}
+		if len(seen) != 4 {
+			t.Fatalf("expected every call to be random got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("ROUND of real literals", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT ROUND(1.5), ROUND(-2.5), ROUND(1.25, 1), ROUND(2.5e-1, 1), typeof(ROUND(1.5)), 0.75, typeof(1e3);")
+		want := []string{"2", "-3", "1.3", "0.3", "real", "0.75", "real"}
+		for i, w := range want {
+			if got := res.ResultRows[0][i].Text(); got != w {
+				t.Fatalf("col %d expected %s got %s", i, w, got)
+			}
+		}
+	})
+
+	t.Run("real literals compared to rows", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT age, ROUND(2.5) FROM foo WHERE age < -4.5;")
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "-5" || res.ResultRows[0][1].Text() != "3" {
+			t.Fatalf("expected -5, 3 got %v", res.ResultRows)
+		}
+	})
+}
+
+func TestCoalesce(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (1, 10), (NULL, 20), (NULL, NULL);")
+	// b + 1 is not computed by COALESCE for the first row so the second
+	// column must compute it again.
+	res := mustExecute(t, db, "SELECT COALESCE(a, b + 1, 0), b + 1, IFNULL(a, -1) FROM foo;")
+	want := [][]string{
+		{"1", "11", "1"},
+		{"21", "21", "-1"},
+		{"0", "", "-1"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+
+	t.Run("where", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT id FROM foo WHERE COALESCE(a, b, 0) > 5;")
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Int() != 2 {
+			t.Fatalf("expected row 2 got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("aggregate", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT COALESCE(SUM(a), 0) FROM foo WHERE id > 1;")
+		if got := res.ResultRows[0][0].Text(); got != "0" {
+			t.Fatalf("expected 0 got %s", got)
+		}
+	})
+
+	t.Run("wrong number of arguments", func(t *testing.T) {
+		for _, sql := range []string{"SELECT COALESCE(a) FROM foo;", "SELECT IFNULL(a, b, 1) FROM foo;"} {
+			if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+				t.Fatalf("expected err for %s", sql)
+			}
+		}
+	})
+}
+
+func TestRemainderAndConcat(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, n INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (name, n) VALUES ('a', 7), ('b', 8), (NULL, 9);")
+	res := mustExecute(t, db, "SELECT n % 3, name || '-' || n, 1 || 2 * 3 FROM foo;")
+	want := [][]string{
+		{"1", "a-7", "36"},
+		{"2", "b-8", "36"},
+		{"0", "", "36"},
+	}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+
+	t.Run("where", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT id FROM foo WHERE n % 2 = 0 OR name || n = 'a7';")
+		if len(res.ResultRows) != 2 || res.ResultRows[0][0].Int() != 1 || res.ResultRows[1][0].Int() != 2 {
+			t.Fatalf("expected rows 1 and 2 got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("constant", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT -7 % 3, 1 || 2, 'a' || NULL;")
+		want := []string{"-1", "12", ""}
+		for i, w := range want {
+			if got := res.ResultRows[0][i].Text(); got != w {
+				t.Fatalf("col %d expected %s got %s", i, w, got)
+			}
+		}
+	})
+
+	t.Run("remainder by zero", func(t *testing.T) {
+		for _, sql := range []string{"SELECT n % 0 FROM foo;", "SELECT 1 % 0;"} {
+			if res := db.Execute(db.Tokenize(sql)[0], []any{}); res.Err == nil {
+				t.Fatalf("expected err for %s", sql)
+			}
+		}
+	})
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, flags INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (flags) VALUES (5), (6), (NULL);")
+	res := mustExecute(t, db, "SELECT flags & 3, flags | 8, flags << 2, flags >> 1, ~flags FROM foo;")
+	want := [][]string{
+		{"1", "13", "20", "2", "-6"},
+		{"2", "14", "24", "3", "-7"},
+		{"", "", "", "", ""},
+	}
+	for i, w := range want {
+		for j := range w {
+			if got := res.ResultRows[i][j].Text(); got != w[j] {
+				t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+			}
+		}
+	}
+
+	t.Run("where", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT id FROM foo WHERE flags & 4 AND flags & 1;")
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Int() != 1 {
+			t.Fatalf("expected row 1 got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("constant", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT 1 | 2 & 3, 1 << 2 + 1, ~0, -16 >> 2, 1 << 64;")
+		want := []string{"3", "8", "-1", "-4", "0"}
+		for i, w := range want {
+			if got := res.ResultRows[0][i].Text(); got != w {
+				t.Fatalf("col %d expected %s got %s", i, w, got)
+			}
+		}
+	})
+}
+
+func TestComparisonOperators(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, n INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (n) VALUES (1), (2), (3), (NULL);")
+	cases := []struct {
+		where string
+		want  []int
+	}{
+		{where: "n != 2", want: []int{1, 3}},
+		{where: "n <> 2", want: []int{1, 3}},
+		{where: "n <= 2", want: []int{1, 2}},
+		{where: "n >= 2", want: []int{2, 3}},
+		{where: "2 >= n", want: []int{1, 2}},
+		{where: "NOT n >= 2", want: []int{1}},
+		{where: "(n != 2) = 1", want: []int{1, 3}},
+		{where: "id >= 3", want: []int{3, 4}},
+		{where: "1 <> 2", want: []int{1, 2, 3, 4}},
+		{where: "1 >= 2", want: []int{}},
+	}
+	for _, c := range cases {
+		t.Run(c.where, func(t *testing.T) {
+			res := mustExecute(t, db, "SELECT id FROM foo WHERE "+c.where+";")
+			got := []int{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Int())
+			}
+			if !slices.Equal(got, c.want) {
+				t.Fatalf("expected %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("result", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT n != 2, n <= 2, n >= 2 FROM foo;")
+		want := [][]string{
+			{"1", "1", "0"},
+			{"0", "1", "1"},
+			{"1", "0", "1"},
+			{"", "", ""},
+		}
+		for i, w := range want {
+			for j := range w {
+				if got := res.ResultRows[i][j].Text(); got != w[j] {
+					t.Fatalf("row %d col %d expected %s got %s", i, j, w[j], got)
+				}
+			}
+		}
+	})
+}
+
+func TestColumnDefault(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT, created_at TEXT DEFAULT CURRENT_TIMESTAMP, created_on TEXT DEFAULT CURRENT_DATE);")
+	before := time.Now().UTC().Truncate(time.Second)
+	mustExecute(t, db, "INSERT INTO foo (name) VALUES ('a'), ('b');")
+	after := time.Now().UTC()
+	res := mustExecute(t, db, "SELECT created_at, created_on FROM foo;")
+	if len(res.ResultRows) != 2 {
+		t.Fatalf("expected 2 rows got %d", len(res.ResultRows))
+	}
+	createdAt := res.ResultRows[0][0].Text()
+	if got := res.ResultRows[1][0].Text(); got != createdAt {
+		t.Fatalf("expected every row to have time %s got %s", createdAt, got)
+	}
+	ts, err := time.Parse(vm.TimestampLayout, createdAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts.Before(before) || ts.After(after) {
+		t.Fatalf("expected time between %s and %s got %s", before, after, ts)
+	}
+	if got := res.ResultRows[0][1].Text(); got != ts.Format(vm.DateLayout) {
+		t.Fatalf("expected date %s got %s", ts.Format(vm.DateLayout), got)
+	}
+
+	t.Run("given value", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (name, created_at, created_on) VALUES ('c', 'x', 'y');")
+		res := mustExecute(t, db, "SELECT created_at, created_on FROM foo WHERE name = 'c';")
+		if got := res.ResultRows[0][0].Text() + res.ResultRows[0][1].Text(); got != "xy" {
+			t.Fatalf("expected xy got %s", got)
+		}
+	})
+
+	t.Run("select", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT typeof(CURRENT_TIMESTAMP);")
+		if got := res.ResultRows[0][0].Text(); got != "text" {
+			t.Fatalf("expected text got %s", got)
+		}
+	})
+}
+
+func TestDateTimeFunctions(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, created_at TEXT DEFAULT CURRENT_TIMESTAMP);")
+	mustExecute(t, db, "INSERT INTO foo (created_at) VALUES ('2024-01-31 10:00:00'), ('2024-03-01 09:30:00');")
+	res := mustExecute(t, db, "SELECT DATE(created_at, '+1 month'), TIME(created_at, '-30 minutes'), STRFTIME('%Y', created_at) FROM foo WHERE created_at < DATETIME('2024-02-01');")
+	want := []string{"2024-03-02", "09:30:00", "2024"}
+	if len(res.ResultRows) != 1 {
+		t.Fatalf("expected 1 row got %d", len(res.ResultRows))
+	}
+	for i, w := range want {
+		if got := res.ResultRows[0][i].Text(); got != w {
+			t.Fatalf("col %d expected %s got %s", i, w, got)
+		}
+	}
+
+	t.Run("now is the statement time", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT DATETIME('now') = CURRENT_TIMESTAMP, DATE() = CURRENT_DATE;")
+		for i, v := range res.ResultRows[0] {
+			if v.Int() != 1 {
+				t.Fatalf("col %d expected now to be the statement time", i)
+			}
+		}
+	})
+
+	t.Run("default", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (id) VALUES (3);")
+		res := mustExecute(t, db, "SELECT DATETIME(created_at) = created_at FROM foo WHERE id = 3;")
+		if res.ResultRows[0][0].Int() != 1 {
+			t.Fatal("expected default timestamp to be a valid time value")
+		}
+	})
+}
+
+func TestExecuteMany(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	ps, err := db.NewPreparedStatement("INSERT INTO foo (id, name) VALUES (?, ?);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	commits := db.Metrics().Commits.Value()
+	res := ps.ExecuteMany([][]any{{1, "a"}, {2, "b"}, {3, "c"}})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if got := db.Metrics().Commits.Value() - commits; got != 1 {
+		t.Fatalf("expected 1 commit got %d", got)
+	}
+	if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+		t.Fatalf("expected 3 rows got %d", got)
+	}
+
+	t.Run("rolls back every row when one fails", func(t *testing.T) {
+		res := ps.ExecuteMany([][]any{{4, "d"}, {1, "duplicate"}})
+		if res.Err == nil {
+			t.Fatal("expected err for duplicate primary key")
+		}
+		if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+		if db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+	})
+
+	t.Run("bad parameter executes nothing", func(t *testing.T) {
+		res := ps.ExecuteMany([][]any{{5, "e"}, {6, struct{}{}}})
+		if res.Err == nil {
+			t.Fatal("expected err for unsupported parameter")
+		}
+		if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("select appends results", func(t *testing.T) {
+		ps, err := db.NewPreparedStatement("SELECT name FROM foo WHERE id = ?;")
+		if err != nil {
+			t.Fatal(err)
+		}
+		res := ps.ExecuteMany([][]any{{3}, {1}})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+		got := []string{}
+		for _, row := range res.ResultRows {
+			got = append(got, row[0].Text())
+		}
+		if want := []string{"c", "a"}; !slices.Equal(got, want) {
+			t.Fatalf("expected %v got %v", want, got)
+		}
+	})
+}
+
+func TestExecuteScript(t *testing.T) {
+	db := mustCreateDB(t)
+
+	t.Run("statements share one transaction", func(t *testing.T) {
+		commits := db.Metrics().Commits.Value()
+		results, err := db.ExecuteScript(context.Background(), `
+			CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);
+			INSERT INTO foo (name) VALUES ('a'), ('b');
+			SELECT name FROM foo;
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(results); got != 3 {
+			t.Fatalf("expected 3 results got %d", got)
+		}
+		if got := results[1].RowsAffected; got != 2 {
+			t.Fatalf("expected 2 rows affected got %d", got)
+		}
+		if got := len(results[2].ResultRows); got != 2 {
+			t.Fatalf("expected 2 rows got %d", got)
+		}
+		if got := db.Metrics().Commits.Value() - commits; got != 1 {
+			t.Fatalf("expected 1 commit got %d", got)
+		}
+		if db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+	})
+
+	t.Run("common table after write", func(t *testing.T) {
+		results, err := db.ExecuteScript(context.Background(), `
+			INSERT INTO foo (name) VALUES ('c');
+			WITH f AS (SELECT name FROM foo) SELECT * FROM f;
+			DELETE FROM foo WHERE name = 'c';
+		`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(results[1].ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("read then write", func(t *testing.T) {
+		results, err := db.ExecuteScript(context.Background(), "SELECT * FROM foo; INSERT INTO foo (name) VALUES ('c');")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(results[0].ResultRows); got != 2 {
+			t.Fatalf("expected 2 rows got %d", got)
+		}
+		if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("failed statement rolls back every statement", func(t *testing.T) {
+		results, err := db.ExecuteScript(context.Background(), `
+			CREATE TABLE bar (id INTEGER PRIMARY KEY);
+			INSERT INTO foo (id, name) VALUES (4, 'd');
+			INSERT INTO foo (id, name) VALUES (1, 'duplicate');
+			INSERT INTO foo (id, name) VALUES (5, 'e');
+		`)
+		if err == nil {
+			t.Fatal("expected err for duplicate primary key")
+		}
+		if got := len(results); got != 2 {
+			t.Fatalf("expected 2 results got %d", got)
+		}
+		if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+		if slices.Contains(db.TableNames(), "bar") {
+			t.Fatal("expected bar to be rolled back")
+		}
+		if db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+	})
+
+	t.Run("syntax err executes nothing", func(t *testing.T) {
+		results, err := db.ExecuteScript(context.Background(), "INSERT INTO foo (name) VALUES ('f'); SELEC 1;")
+		if err == nil {
+			t.Fatal("expected syntax err")
+		}
+		if len(results) != 0 {
+			t.Fatalf("expected no results got %d", len(results))
+		}
+		if got := len(mustExecute(t, db, "SELECT * FROM foo;").ResultRows); got != 3 {
+			t.Fatalf("expected 3 rows got %d", got)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		results, err := db.ExecuteScript(context.Background(), "SELECT * FROM foo; EXPLAIN INSERT INTO foo (name) VALUES ('g');")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 2 || len(results[1].ResultRows) == 0 {
+			t.Fatalf("expected rows and an explain got %v", results)
+		}
+		if db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+	})
+}
+
+func TestQuery(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	ps, err := db.NewPreparedStatement("INSERT INTO foo (name) VALUES (?);")
+	if err != nil {
+		t.Fatal(err)
+	}
+	batch := [][]any{}
+	for i := range 1000 {
+		batch = append(batch, []any{strconv.Itoa(i)})
+	}
+	if res := ps.ExecuteMany(batch); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+
+	t.Run("streams every row", func(t *testing.T) {
+		rows, err := db.Query(context.Background(), db.Tokenize("SELECT id, name FROM foo;")[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if got, want := strings.Join(rows.ResultHeader, ","), "id,name"; got != want {
+			t.Fatalf("expected header %s got %s", want, got)
+		}
+		count := 0
+		for rows.Next() {
+			if got, want := rows.ColumnInt(0), count+1; got != want {
+				t.Fatalf("expected id %d got %d", want, got)
+			}
+			if got, want := rows.ColumnText(1), strconv.Itoa(count); got != want {
+				t.Fatalf("expected name %s got %s", want, got)
+			}
+			count += 1
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1000 {
+			t.Fatalf("expected 1000 rows got %d", count)
+		}
+	})
+
+	t.Run("params", func(t *testing.T) {
+		rows, err := db.Query(context.Background(), db.Tokenize("SELECT name FROM foo WHERE id = ?;")[0], []any{5})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatalf("expected a row got err %v", rows.Err())
+		}
+		if got := rows.Row()[0].Text(); got != "4" {
+			t.Fatalf("expected 4 got %s", got)
+		}
+		if rows.Next() {
+			t.Fatal("expected one row")
+		}
+	})
+
+	t.Run("close early ends transaction", func(t *testing.T) {
+		rows, err := db.Query(context.Background(), db.Tokenize("SELECT * FROM foo;")[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows.Next()
+		if err := rows.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if db.InTransaction() {
+			t.Fatal("expected transaction to be ended")
+		}
+		mustExecute(t, db, "INSERT INTO foo (name) VALUES ('after');")
+	})
+
+	t.Run("write while streaming", func(t *testing.T) {
+		rows, err := db.Query(context.Background(), db.Tokenize("SELECT name FROM foo;")[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatalf("expected a row got err %v", rows.Err())
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		res := db.ExecuteContext(ctx, db.Tokenize("UPDATE foo SET name = 'updated';")[0], nil)
+		if res.Err != nil {
+			t.Fatalf("expected write not to wait for rows got %s", res.Err)
+		}
+		count := 1
+		for rows.Next() {
+			if rows.ColumnText(0) == "updated" {
+				t.Fatal("expected rows to not see the update")
+			}
+			count += 1
+		}
+		if err := rows.Err(); err != nil {
+			t.Fatal(err)
+		}
+		if count != 1001 {
+			t.Fatalf("expected 1001 rows got %d", count)
+		}
+	})
+
+	t.Run("error before first row", func(t *testing.T) {
+		_, err := db.Query(context.Background(), db.Tokenize("SELECT * FROM missing;")[0], nil)
+		if err == nil {
+			t.Fatal("expected err for missing table")
+		}
+	})
+
+	t.Run("explain query plan", func(t *testing.T) {
+		rows, err := db.Query(context.Background(), db.Tokenize("EXPLAIN QUERY PLAN SELECT * FROM foo;")[0], nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if got := strings.Join(rows.ResultHeader, ","); got != "QUERY PLAN" {
+			t.Fatalf("expected QUERY PLAN header got %s", got)
+		}
+		lines := []string{}
+		for rows.Next() {
+			lines = append(lines, rows.Row()[0].Text())
+		}
+		if got := strings.Join(lines, "\n"); len(lines) < 2 || !strings.Contains(got, "foo") {
+			t.Fatalf("expected a line for each node of the plan got %s", got)
+		}
+	})
+}
+
+func TestUnknownColumn(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1);")
+	cases := []string{
+		"SELECT nonexistent FROM foo;",
+		"SELECT * FROM foo WHERE nonexistent = 1;",
+		"SELECT * FROM foo ORDER BY nonexistent;",
+		"UPDATE foo SET a = nonexistent;",
+		"DELETE FROM foo WHERE nonexistent = 1;",
+	}
+	for _, sql := range cases {
+		t.Run(sql, func(t *testing.T) {
+			res := db.Execute(db.Tokenize(sql)[0], []any{})
+			want := "no such column: nonexistent (available columns: id, a)"
+			if res.Err == nil || res.Err.Error() != want {
+				t.Fatalf("expected err %q got %v", want, res.Err)
+			}
+		})
+	}
+
+	t.Run("qualified with another table", func(t *testing.T) {
+		mustExecute(t, db, "SELECT foo.a FROM foo;")
+		res := db.Execute(db.Tokenize("SELECT bar.a FROM foo;")[0], []any{})
+		want := "no such column: bar.a (available columns: id, a)"
+		if res.Err == nil || res.Err.Error() != want {
+			t.Fatalf("expected err %q got %v", want, res.Err)
+		}
+	})
+}
+
+func TestConstantPredicates(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1), (2), (3);")
+	if res := mustExecute(t, db, "SELECT * FROM foo WHERE 1 = 0;"); len(res.ResultRows) != 0 {
+		t.Fatalf("expected no rows but got %d", len(res.ResultRows))
+	}
+	if res := mustExecute(t, db, "SELECT * FROM foo WHERE 1 = 1;"); len(res.ResultRows) != 3 {
+		t.Fatalf("expected 3 rows but got %d", len(res.ResultRows))
+	}
+	mustExecute(t, db, "UPDATE foo SET a = 9 WHERE 2 = 3;")
+	mustExecute(t, db, "DELETE FROM foo WHERE 0 = 1;")
+	if res := mustExecute(t, db, "SELECT * FROM foo WHERE a = 9;"); len(res.ResultRows) != 0 {
+		t.Fatalf("expected no updated rows but got %d", len(res.ResultRows))
+	}
+	mustExecute(t, db, "DELETE FROM foo WHERE 1 = 1;")
+	if res := mustExecute(t, db, "SELECT * FROM foo;"); len(res.ResultRows) != 0 {
+		t.Fatalf("expected no rows but got %d", len(res.ResultRows))
+	}
+}
+
+func TestNull(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1), (NULL), (3);")
+
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT id FROM foo WHERE a IS NULL;", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE a IS NOT NULL;", want: []string{"1", "3"}},
+		{sql: "SELECT id FROM foo WHERE a = NULL;", want: []string{}},
+		{sql: "SELECT id FROM foo WHERE a > 0;", want: []string{"1", "3"}},
+		{sql: "SELECT id FROM foo WHERE 4 > a;", want: []string{"1", "3"}},
+		{sql: "SELECT id FROM foo WHERE a < 2;", want: []string{"1"}},
+		{sql: "SELECT id FROM foo WHERE a + 1;", want: []string{"1", "3"}},
+		{sql: "SELECT id FROM foo WHERE (a = 1) IS NULL;", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE (a = 1) = 0;", want: []string{"3"}},
+		{sql: "SELECT id FROM foo WHERE NULL IS NULL;", want: []string{"1", "2", "3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("results", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT NULL, a + 1, a = 1, a IS NULL FROM foo WHERE id = 2;")
+		if len(res.ResultRows) != 1 {
+			t.Fatalf("expected 1 row got %d", len(res.ResultRows))
+		}
+		row := res.ResultRows[0]
+		if !row[0].IsNull() || !row[1].IsNull() || !row[2].IsNull() {
+			t.Fatalf("expected NULL results got %v", row)
+		}
+		if row[3].Text() != "1" {
+			t.Fatalf("expected a IS NULL to be 1 got %s", row[3].Text())
+		}
+	})
+}
+
+func TestLogicalOperators(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (1, 2), (0, 3), (NULL, 4), (2, NULL);")
+
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT id FROM foo WHERE a = 1 AND b = 2;", want: []string{"1"}},
+		{sql: "SELECT id FROM foo WHERE a = 1 OR b = 4;", want: []string{"1", "3"}},
+		{sql: "SELECT id FROM foo WHERE a = 1 AND (b > 2 OR b < 3);", want: []string{"1"}},
+		{sql: "SELECT id FROM foo WHERE a > 1 OR b > 2 AND b < 4;", want: []string{"2", "4"}},
+		{sql: "SELECT id FROM foo WHERE (a = 0 OR a = 2) AND (b = 3 OR b IS NULL);", want: []string{"2", "4"}},
+		{sql: "SELECT id FROM foo WHERE a > 0 OR b > 0;", want: []string{"1", "2", "3", "4"}},
+		{sql: "SELECT id FROM foo WHERE a > 0 AND b > 0;", want: []string{"1"}},
+		// The right operand is not evaluated so there is no division by 0.
+		{sql: "SELECT id FROM foo WHERE a = 0 OR 10 / a > 6;", want: []string{"1", "2"}},
+		{sql: "SELECT id FROM foo WHERE a > 0 AND 10 / a > 6;", want: []string{"1"}},
+		{sql: "SELECT id FROM foo WHERE 1 = 0 AND a = 1;", want: []string{}},
+		{sql: "SELECT id FROM foo WHERE 1 = 1 OR a = 1;", want: []string{"1", "2", "3", "4"}},
+		{sql: "SELECT id FROM foo WHERE (a = 1 OR b = 4) = 1;", want: []string{"1", "3"}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("results", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT a > 0 AND b > 0, a > 0 OR b > 0 FROM foo;")
+		want := [][]string{{"1", "1"}, {"0", "1"}, {"NULL", "1"}, {"NULL", "1"}}
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			r := []string{}
+			for _, v := range row {
+				if v.IsNull() {
+					r = append(r, "NULL")
+				} else {
+					r = append(r, v.Text())
+				}
+			}
+			got = append(got, r)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("subexpression computed within OR", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT id, a + b FROM foo WHERE a = 1 OR a + b > 2;")
+		want := [][]string{{"1", "3"}, {"2", "3"}}
+		if len(res.ResultRows) != len(want) {
+			t.Fatalf("expected %d rows got %d", len(want), len(res.ResultRows))
+		}
+		for i, row := range want {
+			if got := []string{res.ResultRows[i][0].Text(), res.ResultRows[i][1].Text()}; !reflect.DeepEqual(got, row) {
+				t.Fatalf("want %v got %v", row, got)
+			}
+		}
+	})
+}
+
+func TestNotAndNegate(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1), (0), (NULL), (-2);")
+
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT id FROM foo WHERE NOT a = 1;", want: []string{"2", "4"}},
+		{sql: "SELECT id FROM foo WHERE NOT a;", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE NOT a IS NULL;", want: []string{"1", "2", "4"}},
+		{sql: "SELECT id FROM foo WHERE NOT (a = 1 OR a = 0);", want: []string{"4"}},
+		{sql: "SELECT id FROM foo WHERE -a > 1;", want: []string{"4"}},
+		{sql: "SELECT id FROM foo WHERE a = -2;", want: []string{"4"}},
+		{sql: "SELECT id FROM foo WHERE NOT 0;", want: []string{"1", "2", "3", "4"}},
+		{sql: "SELECT id FROM foo WHERE NOT NULL;", want: []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("results", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT NOT a, -a, - -a, -(a + 1) * 2, -9223372036854775808 FROM foo;")
+		want := [][]string{
+			{"0", "-1", "1", "-4", "-9223372036854775808"},
+			{"1", "0", "0", "-2", "-9223372036854775808"},
+			{"NULL", "NULL", "NULL", "NULL", "-9223372036854775808"},
+			{"0", "2", "-2", "2", "-9223372036854775808"},
+		}
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			r := []string{}
+			for _, v := range row {
+				if v.IsNull() {
+					r = append(r, "NULL")
+				} else {
+					r = append(r, v.Text())
+				}
+			}
+			got = append(got, r)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+}
+
+func TestResultOrigins(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1);")
+	foo := func(column string) vm.ColumnOrigin {
+		return vm.ColumnOrigin{Database: "main", Table: "foo", Column: column}
+	}
+
+	cases := []struct {
+		sql  string
+		want []vm.ColumnOrigin
+	}{
+		{sql: "SELECT * FROM foo;", want: []vm.ColumnOrigin{foo("id"), foo("a")}},
+		{sql: "SELECT a AS b, a + 1, 1 FROM foo;", want: []vm.ColumnOrigin{foo("a"), {}, {}}},
+		{sql: "SELECT a, COUNT(*) FROM foo GROUP BY a;", want: []vm.ColumnOrigin{foo("a"), {}}},
+		{sql: "SELECT COUNT(*) FROM foo;", want: []vm.ColumnOrigin{{}}},
+		{sql: "SELECT value FROM generate_series(1, 2);", want: []vm.ColumnOrigin{{}}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			if !reflect.DeepEqual(res.ResultOrigins, c.want) {
+				t.Fatalf("want %v got %v", c.want, res.ResultOrigins)
+			}
+		})
+	}
+}
+
+func TestTransactionState(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	if db.InTransaction() {
+		t.Fatal("expected no transaction after the statement ended")
+	}
+	if state, dirty := db.TransactionState(); state != TransactionNone || dirty != 0 {
+		t.Fatalf("expected none with 0 dirty pages got %s with %d", state, dirty)
+	}
+	for _, sql := range []string{
+		"PRAGMA transaction_state;",
+		"SELECT state, dirty_pages FROM pragma_transaction_state();",
+	} {
+		t.Run(sql, func(t *testing.T) {
+			res := mustExecute(t, db, sql)
+			if !reflect.DeepEqual(res.ResultHeader, []string{"state", "dirty_pages"}) {
+				t.Fatalf("expected header state, dirty_pages got %v", res.ResultHeader)
+			}
+			if len(res.ResultRows) != 1 {
+				t.Fatalf("expected 1 row got %d", len(res.ResultRows))
+			}
+			if got := res.ResultRows[0][0].Text() + " " + res.ResultRows[0][1].Text(); got != "none 0" {
+				t.Fatalf("expected none 0 got %s", got)
+			}
+		})
+	}
+	t.Run("no such pragma", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("PRAGMA foo;")[0], []any{})
+		if res.Err == nil || res.Err.Error() != "no such pragma: foo" {
+			t.Fatalf("expected no such pragma err got %v", res.Err)
+		}
+	})
+}
+
+func TestCacheSize(t *testing.T) {
+	db, err := New(true, "", WithCacheSize(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size, _ := db.CacheSize(); size != 8 {
+		t.Fatalf("expected cache size 8 got %d", size)
+	}
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a TEXT);")
+	values := []string{}
+	for i := 0; i < 1000; i += 1 {
+		values = append(values, fmt.Sprintf("('%s')", strings.Repeat("a", 100)))
+	}
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES "+strings.Join(values, ", ")+";")
+	mustExecute(t, db, "SELECT COUNT(a) FROM foo;")
+	if got := db.Metrics().CacheEvictions.Value(); got == 0 {
+		t.Fatal("expected pages to be evicted from a small cache")
+	}
+	if _, cached := db.CacheSize(); cached > 8 {
+		t.Fatalf("expected at most 8 cached pages got %d", cached)
+	}
+
+	t.Run("pragma", func(t *testing.T) {
+		res := mustExecute(t, db, "PRAGMA cache_size = -64;")
+		if got := res.ResultRows[0][0].Int(); got != 16 {
+			t.Fatalf("expected 64 KiB to be 16 pages got %d", got)
+		}
+		res = mustExecute(t, db, "PRAGMA cache_size = 100;")
+		if got := res.ResultRows[0][0].Int(); got != 100 {
+			t.Fatalf("expected cache size 100 got %d", got)
+		}
+		res = mustExecute(t, db, "SELECT cache_size FROM pragma_cache_size();")
+		if got := res.ResultRows[0][0].Int(); got != 100 {
+			t.Fatalf("expected cache size 100 got %d", got)
+		}
+	})
+
+	t.Run("policy", func(t *testing.T) {
+		db, err := New(true, "", WithCacheSize(8), WithCachePolicy(CachePolicy2Q))
+		if err != nil {
+			t.Fatal(err)
+		}
+		mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a TEXT);")
+		mustExecute(t, db, "INSERT INTO foo (a) VALUES "+strings.Join(values, ", ")+";")
+		res := mustExecute(t, db, "SELECT COUNT(a) FROM foo;")
+		if got := res.ResultRows[0][0].Int(); got != 1000 {
+			t.Fatalf("expected 1000 rows got %d", got)
+		}
+		if size, _ := db.CacheSize(); size != 8 {
+			t.Fatalf("expected cache size 8 got %d", size)
+		}
+	})
+
+	t.Run("pragma that cannot be set", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("PRAGMA transaction_state = 1;")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected err setting transaction_state")
+		}
+		res = db.Execute(db.Tokenize("PRAGMA cache_size = 'big';")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("expected err setting cache_size to text")
+		}
+	})
+}
+
+func TestCommonSubexpressions(t *testing.T) {
 	db := mustCreateDB(t)
 	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b INTEGER);")
-	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (1,2), (3,4), (5,6);")
-	mustExecute(t, db, "UPDATE foo SET b = 1;")
-	res := mustExecute(t, db, "SELECT b FROM foo WHERE b = 1;")
-	if len(res.ResultRows) != 3 {
-		t.Fatalf("expected all 3 rows to be 1")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (1, 2), (3, 4), (5, 6);")
+	res := mustExecute(t, db, "SELECT a + b, (a + b) * 2 FROM foo WHERE a + b > 3;")
+	want := [][]string{{"7", "14"}, {"11", "22"}}
+	if len(res.ResultRows) != len(want) {
+		t.Fatalf("expected %d rows but got %d", len(want), len(res.ResultRows))
+	}
+	for i, row := range want {
+		for j, w := range row {
+			if got := res.ResultRows[i][j].Text(); got != w {
+				t.Fatalf("expected %s at row %d column %d but got %s", w, i, j, got)
+			}
+		}
 	}
 }
 
-func TestDeleteAll(t *testing.T) {
+func TestOrderBy(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (2, 'x'), (3, 'y'), (1, 'z'), (2, 'w');")
+	cases := []struct {
+		sql  string
+		want []string
+	}{
+		{sql: "SELECT id FROM foo ORDER BY a;", want: []string{"3", "1", "4", "2"}},
+		{sql: "SELECT id FROM foo ORDER BY a DESC;", want: []string{"2", "1", "4", "3"}},
+		{sql: "SELECT id FROM foo ORDER BY a, b;", want: []string{"3", "4", "1", "2"}},
+		{sql: "SELECT id FROM foo ORDER BY a ASC, b DESC;", want: []string{"3", "1", "4", "2"}},
+		{sql: "SELECT id, b AS c FROM foo ORDER BY c;", want: []string{"4", "1", "2", "3"}},
+		{sql: "SELECT id, b FROM foo ORDER BY 2 DESC;", want: []string{"3", "2", "1", "4"}},
+		{sql: "SELECT id FROM foo WHERE a > 1 ORDER BY b;", want: []string{"4", "1", "2"}},
+		{sql: "SELECT id FROM foo ORDER BY id * 0 - id;", want: []string{"4", "3", "2", "1"}},
+		{sql: "SELECT id FROM foo WHERE id = 2 ORDER BY a;", want: []string{"2"}},
+		{sql: "SELECT id FROM foo WHERE 1 = 0 ORDER BY a;", want: []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	statements := db.Tokenize("SELECT id FROM foo ORDER BY 2;")
+	if err := db.Execute(statements[0], []any{}).Err; err == nil {
+		t.Fatal("want err for out of range term but got nil")
+	}
+}
+
+func TestOrderByWithoutSort(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b TEXT);")
+	mustExecute(t, db, "CREATE INDEX foo_a ON foo (a);")
+	values := []string{}
+	for i := 1; i <= 300; i += 1 {
+		values = append(values, fmt.Sprintf("(%d, %d, 'b')", i, 1000-i))
+	}
+	mustExecute(t, db, "INSERT INTO foo (id, a, b) VALUES "+strings.Join(values, ", ")+";")
+	mustExecute(t, db, "INSERT INTO foo (id, a, b) VALUES (-2, NULL, 'c'), (-1, 'x', 'd'), (0, -5, 'e');")
+	want := func(ids ...int) []string {
+		s := []string{}
+		for _, id := range ids {
+			s = append(s, strconv.Itoa(id))
+		}
+		return s
+	}
+	// between returns the ids from first to last by step.
+	between := func(first, last, step int) []int {
+		ids := []int{}
+		for i := first; i != last+step; i += step {
+			ids = append(ids, i)
+		}
+		return ids
+	}
+	cases := []struct {
+		sql  string
+		plan string
+		want []string
+	}{
+		{
+			sql:  "SELECT id FROM foo ORDER BY id;",
+			plan: "scan table foo in row id order",
+			want: want(between(-2, 300, 1)...),
+		},
+		{
+			sql:  "SELECT id FROM foo ORDER BY id DESC;",
+			plan: "scan table foo in descending row id order",
+			want: want(between(300, -2, -1)...),
+		},
+		{
+			sql:  "SELECT id FROM foo WHERE b != 'b' ORDER BY id DESC;",
+			plan: "scan table foo in descending row id order",
+			want: want(0, -1, -2),
+		},
+		{
+			sql:  "SELECT id FROM foo ORDER BY a;",
+			plan: "scan table foo using covering index foo_a",
+			want: want(append(append([]int{-2, 0}, between(300, 1, -1)...), -1)...),
+		},
+		{
+			sql:  "SELECT id, b FROM foo WHERE id < 3 ORDER BY a DESC;",
+			plan: "scan table foo using index foo_a in descending order",
+			want: want(-1, 1, 2, 0, -2),
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, "EXPLAIN QUERY PLAN "+c.sql)
+			if !strings.Contains(res.Text, c.plan) || strings.Contains(res.Text, "order by") {
+				t.Fatalf("expected plan to %s without sorting got %s", c.plan, res.Text)
+			}
+			res = mustExecute(t, db, c.sql)
+			got := []string{}
+			for _, row := range res.ResultRows {
+				got = append(got, row[0].Text())
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestGroupBy(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER, b TEXT);")
+	mustExecute(t, db, "INSERT INTO foo (a, b) VALUES (2, 'x'), (3, 'y'), (1, 'x'), (5, 'x');")
+	mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY, a INTEGER);")
+	cases := []struct {
+		sql  string
+		want [][]string
+	}{
+		{
+			sql:  "SELECT b, COUNT(*) FROM foo GROUP BY b;",
+			want: [][]string{{"x", "3"}, {"y", "1"}},
+		},
+		{
+			sql:  "SELECT b, SUM(a), MIN(a), MAX(a) FROM foo GROUP BY b;",
+			want: [][]string{{"x", "8", "1", "5"}, {"y", "3", "3", "3"}},
+		},
+		{
+			sql:  "SELECT COUNT(*) FROM foo WHERE a > 1;",
+			want: [][]string{{"3"}},
+		},
+		{
+			sql:  "SELECT COUNT(a), SUM(a) + 1 FROM foo;",
+			want: [][]string{{"4", "12"}},
+		},
+		{
+			sql:  "SELECT COUNT(*), SUM(a) FROM bar;",
+			want: [][]string{{"0", "<nil>"}},
+		},
+		{
+			sql:  "SELECT a FROM bar GROUP BY a;",
+			want: [][]string{},
+		},
+		{
+			sql:  "SELECT b, COUNT(*) FROM foo GROUP BY 1 ORDER BY COUNT(*);",
+			want: [][]string{{"y", "1"}, {"x", "3"}},
+		},
+		{
+			sql:  "SELECT a * 0 AS z, COUNT(*) FROM foo GROUP BY z;",
+			want: [][]string{{"0", "4"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				values := []string{}
+				for _, v := range row {
+					if v.IsNull() {
+						values = append(values, "<nil>")
+					} else {
+						values = append(values, v.Text())
+					}
+				}
+				got = append(got, values)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	statements := db.Tokenize("SELECT a, COUNT(*) FROM foo GROUP BY b;")
+	if err := db.Execute(statements[0], []any{}).Err; err == nil {
+		t.Fatal("want err for ungrouped column but got nil")
+	}
+}
+
+func TestJoin(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE author (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO author (name) VALUES ('ann'), ('bob'), ('cy');")
+	mustExecute(t, db, "CREATE TABLE book (id INTEGER PRIMARY KEY, author_id INTEGER, title TEXT);")
+	mustExecute(t, db, "INSERT INTO book (author_id, title) VALUES (1, 'a'), (1, 'b'), (2, 'c');")
+	cases := []struct {
+		sql  string
+		want [][]string
+	}{
+		{
+			sql:  "SELECT author.name, book.title FROM author JOIN book ON author.id = book.author_id;",
+			want: [][]string{{"ann", "a"}, {"ann", "b"}, {"bob", "c"}},
+		},
+		{
+			sql:  "SELECT name, title FROM author INNER JOIN book ON author.id = author_id WHERE title > 'a';",
+			want: [][]string{{"ann", "b"}, {"bob", "c"}},
+		},
+		{
+			sql:  "SELECT name, title FROM author LEFT JOIN book ON author.id = author_id;",
+			want: [][]string{{"ann", "a"}, {"ann", "b"}, {"bob", "c"}, {"cy", "<nil>"}},
+		},
+		{
+			sql:  "SELECT name, book.id FROM author LEFT OUTER JOIN book ON author.id = author_id WHERE title IS NULL;",
+			want: [][]string{{"cy", "<nil>"}},
+		},
+		{
+			sql:  "SELECT name, title FROM author LEFT JOIN book ON author.id = author_id ORDER BY name DESC, title;",
+			want: [][]string{{"cy", "<nil>"}, {"bob", "c"}, {"ann", "a"}, {"ann", "b"}},
+		},
+		{
+			sql:  "SELECT name, COUNT(title) FROM author LEFT JOIN book ON author.id = author_id GROUP BY name;",
+			want: [][]string{{"ann", "2"}, {"bob", "1"}, {"cy", "0"}},
+		},
+		{
+			sql:  "SELECT COUNT(*) FROM author, book;",
+			want: [][]string{{"9"}},
+		},
+		{
+			sql:  "SELECT COUNT(*) FROM author JOIN book ON author.id = author_id CROSS JOIN generate_series(1, 3);",
+			want: [][]string{{"9"}},
+		},
+		{
+			sql:  "SELECT name, value FROM author JOIN generate_series(1, 2) WHERE author.id = 1;",
+			want: [][]string{{"ann", "1"}, {"ann", "2"}},
+		},
+		{
+			sql:  "SELECT * FROM author JOIN book ON author.id = book.author_id WHERE book.id = 3;",
+			want: [][]string{{"2", "bob", "3", "2", "c"}},
+		},
+		{
+			sql:  "SELECT book.* FROM author JOIN book ON author.id = author_id WHERE name = 'bob';",
+			want: [][]string{{"3", "2", "c"}},
+		},
+		{
+			sql:  "WITH t AS (SELECT name FROM author WHERE id > 1) SELECT t.name, title FROM t LEFT JOIN book ON book.id = 3;",
+			want: [][]string{{"bob", "c"}, {"cy", "c"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				values := []string{}
+				for _, v := range row {
+					if v.IsNull() {
+						values = append(values, "<nil>")
+					} else {
+						values = append(values, v.Text())
+					}
+				}
+				got = append(got, values)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+
+	t.Run("origins", func(t *testing.T) {
+		res := mustExecute(t, db, "SELECT name, title FROM author JOIN book ON author.id = author_id;")
+		want := []vm.ColumnOrigin{
+			{Database: "main", Table: "author", Column: "name"},
+			{Database: "main", Table: "book", Column: "title"},
+		}
+		if !reflect.DeepEqual(res.ResultOrigins, want) {
+			t.Fatalf("want origins %v got %v", want, res.ResultOrigins)
+		}
+	})
+
+	for _, sql := range []string{
+		"SELECT id FROM author JOIN book;",
+		"SELECT * FROM author JOIN author;",
+		"SELECT * FROM author JOIN book ON book.id = missing.id;",
+		"SELECT * FROM author LEFT book;",
+		"SELECT * FROM author JOIN book ON author.id = author_id garbage;",
+		"SELECT * FROM author WHERE id = 1 garbage;",
+		"SELECT missing.* FROM author;",
+	} {
+		t.Run(sql, func(t *testing.T) {
+			if err := db.Execute(db.Tokenize(sql)[0], []any{}).Err; err == nil {
+				t.Fatalf("want err but got nil for %s", sql)
+			}
+		})
+	}
+}
+
+func TestLeftJoinChain(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE author (id INTEGER PRIMARY KEY, name TEXT);")
+	mustExecute(t, db, "INSERT INTO author (name) VALUES ('ann'), ('bob'), ('cy');")
+	mustExecute(t, db, "CREATE TABLE book (id INTEGER PRIMARY KEY, author_id INTEGER, title TEXT);")
+	mustExecute(t, db, "INSERT INTO book (author_id, title) VALUES (1, 'a'), (2, 'b');")
+	mustExecute(t, db, "CREATE TABLE tag (id INTEGER PRIMARY KEY, book_id INTEGER, label TEXT);")
+	mustExecute(t, db, "INSERT INTO tag (book_id, label) VALUES (1, 'x'), (1, 'y');")
+	mustExecute(t, db, "CREATE TABLE empty (id INTEGER PRIMARY KEY, author_id INTEGER);")
+	cases := []struct {
+		sql  string
+		want [][]string
+	}{
+		{
+			sql: "SELECT name, title, label FROM author LEFT JOIN book ON author.id = author_id LEFT JOIN tag ON book_id = book.id;",
+			want: [][]string{
+				{"ann", "a", "x"},
+				{"ann", "a", "y"},
+				{"bob", "b", "<nil>"},
+				{"cy", "<nil>", "<nil>"},
+			},
+		},
+		{
+			sql:  "SELECT name, title, label FROM author LEFT JOIN book ON author.id = author_id JOIN tag ON book_id = book.id;",
+			want: [][]string{{"ann", "a", "x"}, {"ann", "a", "y"}},
+		},
+		{
+			sql:  "SELECT name, empty.id FROM author LEFT JOIN empty ON author.id = empty.author_id;",
+			want: [][]string{{"ann", "<nil>"}, {"bob", "<nil>"}, {"cy", "<nil>"}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := mustExecute(t, db, c.sql)
+			got := [][]string{}
+			for _, row := range res.ResultRows {
+				values := []string{}
+				for _, v := range row {
+					if v.IsNull() {
+						values = append(values, "<nil>")
+					} else {
+						values = append(values, v.Text())
+					}
+				}
+				got = append(got, values)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSemicolonInLiteral(t *testing.T) {
+	db := mustCreateDB(t)
+	statements := db.Tokenize("CREATE TABLE foo (id INTEGER PRIMARY KEY, a TEXT); INSERT INTO foo (a) VALUES ('a;b'); SELECT a FROM foo;")
+	if len(statements) != 3 {
+		t.Fatalf("want 3 statements but got %d", len(statements))
+	}
+	var res vm.ExecuteResult
+	for _, statement := range statements {
+		res = db.Execute(statement, []any{})
+		if res.Err != nil {
+			t.Fatal(res.Err)
+		}
+	}
+	if got := res.ResultRows[0][0].Text(); got != "a;b" {
+		t.Fatalf("want a;b but got %s", got)
+	}
+	unterminated := db.Tokenize("SELECT 'a;")
+	if err := db.Execute(unterminated[0], []any{}).Err; err == nil {
+		t.Fatal("want err for unterminated literal but got nil")
+	}
+}
+
+func TestPlanGenerations(t *testing.T) {
 	db := mustCreateDB(t)
 	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
-	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1), (2), (3);")
-	mustExecute(t, db, "DELETE FROM foo;")
-	res := mustExecute(t, db, "SELECT * FROM foo;")
-	if lrr := len(res.ResultRows); lrr != 0 {
-		t.Fatalf("expected no rows but got %d", lrr)
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1);")
+	prepare := func(sql string) *vm.ExecutionPlan {
+		statement, err := compiler.NewParser(db.Tokenize(sql)[0]).Parse()
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan, err := db.getPlannerFor(statement, db.newCommonTables(context.Background(), nil)).ExecutionPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return plan
 	}
+
+	t.Run("unrelated table created", func(t *testing.T) {
+		plan := prepare("SELECT a FROM foo;")
+		mustExecute(t, db, "CREATE TABLE bar (id INTEGER PRIMARY KEY, b INTEGER);")
+		res := db.vm.ExecuteContext(context.Background(), plan, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		if len(res.ResultRows) != 1 {
+			t.Fatalf("expected 1 row got %d", len(res.ResultRows))
+		}
+	})
+
+	t.Run("index created on table", func(t *testing.T) {
+		plan := prepare("INSERT INTO foo (a) VALUES (2);")
+		mustExecute(t, db, "CREATE INDEX foo_a ON foo (a);")
+		res := db.vm.ExecuteContext(context.Background(), plan, []any{})
+		if !errors.Is(res.Err, vm.ErrVersionChanged) {
+			t.Fatalf("expected version changed err got %v", res.Err)
+		}
+	})
 }
 
-func TestDeleteStatementWithWhere(t *testing.T) {
+func TestTransactionHooks(t *testing.T) {
 	db := mustCreateDB(t)
 	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
-	mustExecute(t, db, "INSERT INTO foo (a) VALUES (11), (12), (13);")
-	mustExecute(t, db, "DELETE FROM foo WHERE a = 12;")
-	res := mustExecute(t, db, "SELECT * FROM foo;")
-	expectedRows := 2
-	if lrr := len(res.ResultRows); lrr != expectedRows {
-		t.Fatalf("expected %d rows but got %d", expectedRows, lrr)
+	commits := 0
+	rollbacks := 0
+	errVeto := errors.New("veto")
+	veto := false
+	db.SetCommitHook(func() error {
+		commits += 1
+		if veto {
+			return errVeto
+		}
+		return nil
+	})
+	db.SetRollbackHook(func() {
+		rollbacks += 1
+	})
+
+	t.Run("commit", func(t *testing.T) {
+		mustExecute(t, db, "INSERT INTO foo (a) VALUES (1);")
+		if commits != 1 || rollbacks != 0 {
+			t.Fatalf("want 1 commit and 0 rollbacks got %d and %d", commits, rollbacks)
+		}
+	})
+
+	t.Run("read", func(t *testing.T) {
+		mustExecute(t, db, "SELECT * FROM foo;")
+		if commits != 1 || rollbacks != 0 {
+			t.Fatalf("want 1 commit and 0 rollbacks got %d and %d", commits, rollbacks)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		res := db.Execute(db.Tokenize("INSERT INTO foo (id, a) VALUES (1, 2);")[0], []any{})
+		if res.Err == nil {
+			t.Fatal("want err for duplicate primary key but got nil")
+		}
+		if commits != 1 || rollbacks != 1 {
+			t.Fatalf("want 1 commit and 1 rollback got %d and %d", commits, rollbacks)
+		}
+	})
+
+	t.Run("veto", func(t *testing.T) {
+		veto = true
+		res := db.Execute(db.Tokenize("INSERT INTO foo (a) VALUES (3);")[0], []any{})
+		veto = false
+		if !errors.Is(res.Err, errVeto) {
+			t.Fatalf("want err %v got %v", errVeto, res.Err)
+		}
+		if commits != 2 || rollbacks != 2 {
+			t.Fatalf("want 2 commits and 2 rollbacks got %d and %d", commits, rollbacks)
+		}
+		res = mustExecute(t, db, "SELECT COUNT(*) FROM foo;")
+		if got := res.ResultRows[0][0].Text(); got != "1" {
+			t.Fatalf("want vetoed insert discarded with count 1 got %s", got)
+		}
+		if db.InTransaction() {
+			t.Fatal("want no transaction after veto")
+		}
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		db.SetCommitHook(nil)
+		db.SetRollbackHook(nil)
+		mustExecute(t, db, "INSERT INTO foo (a) VALUES (4);")
+		if commits != 2 {
+			t.Fatalf("want removed hook not called got %d commits", commits)
+		}
+	})
+}
+
+func TestExecuteReadOnly(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, a INTEGER);")
+	mustExecute(t, db, "INSERT INTO foo (a) VALUES (1);")
+	cases := []struct {
+		sql     string
+		wantErr error
+	}{
+		{sql: "SELECT * FROM foo;"},
+		{sql: "SELECT 1;"},
+		{sql: "PRAGMA transaction_state;"},
+		{sql: "EXPLAIN INSERT INTO foo (a) VALUES (2);"},
+		{sql: "EXPLAIN QUERY PLAN DELETE FROM foo;"},
+		{sql: "INSERT INTO foo (a) VALUES (2);", wantErr: ErrReadOnly},
+		{sql: "UPDATE foo SET a = 2;", wantErr: ErrReadOnly},
+		{sql: "DELETE FROM foo;", wantErr: ErrReadOnly},
+		{sql: "CREATE TABLE bar (id INTEGER PRIMARY KEY);", wantErr: ErrReadOnly},
+		{sql: "CREATE INDEX foo_a ON foo (a);", wantErr: ErrReadOnly},
 	}
-	want1 := "11"
-	if got1 := *res.ResultRows[0][1]; got1 != want1 {
-		t.Fatalf("expected %s but got %s", want1, got1)
+	for _, c := range cases {
+		t.Run(c.sql, func(t *testing.T) {
+			res := db.ExecuteReadOnly(context.Background(), db.Tokenize(c.sql)[0], []any{})
+			if !errors.Is(res.Err, c.wantErr) {
+				t.Fatalf("want err %v got %v", c.wantErr, res.Err)
+			}
+		})
 	}
-	want2 := "13"
-	if got2 := *res.ResultRows[1][1]; got2 != want2 {
-		t.Fatalf("expected %s but got %s", want2, got2)
+	res := mustExecute(t, db, "SELECT COUNT(*) FROM foo;")
+	if got := res.ResultRows[0][0].Text(); got != "1" {
+		t.Fatalf("want rejected statements not ran with count 1 got %s", got)
+	}
+	if slices.Contains(db.TableNames(), "bar") {
+		t.Fatalf("want rejected create not ran got tables %v", db.TableNames())
+	}
+}
+
+// TestBulkInsertMany is TestBulkInsert with the rows inserted by one plan
+// executed for each set of parameters rather than a statement for each row.
+func TestBulkInsertMany(t *testing.T) {
+	db := mustCreateDB(t)
+	mustExecute(t, db, "CREATE TABLE test (id INTEGER PRIMARY KEY, junk TEXT)")
+	ps, err := db.NewPreparedStatement("INSERT INTO test (junk) VALUES (?)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedTotal := 10_000
+	batches := make([][]any, 0, expectedTotal)
+	for i := range expectedTotal {
+		batches = append(batches, []any{"junk" + strconv.Itoa(i)})
+	}
+	statements := db.Metrics().StatementsExecuted.Value()
+	commits := db.Metrics().Commits.Value()
+	if res := ps.ExecuteMany(batches); res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	if got := db.Metrics().StatementsExecuted.Value() - statements; got != 1 {
+		t.Fatalf("expected 1 statement got %d", got)
+	}
+	if got := db.Metrics().Commits.Value() - commits; got != 1 {
+		t.Fatalf("expected 1 commit got %d", got)
+	}
+	selectRes := mustExecute(t, db, "SELECT * FROM test")
+	if gotT := len(selectRes.ResultRows); expectedTotal != gotT {
+		t.Fatalf("expected %d got %d", expectedTotal, gotT)
+	}
+	for i, r := range selectRes.ResultRows {
+		if got := r[0].Int(); got != i+1 {
+			t.Fatalf("expected %d got %d", i+1, got)
+		}
+		if got, want := r[1].Text(), "junk"+strconv.Itoa(i); got != want {
+			t.Fatalf("expected %s got %s", want, got)
+		}
+	}
+}
+
+// BenchmarkInsertLoop and BenchmarkInsertMany compare inserting rows with a
+// transaction for each row to inserting them in one batch.
+func BenchmarkInsertLoop(b *testing.B) {
+	ps := mustPrepareInsert(b)
+	for b.Loop() {
+		for i := range 100 {
+			if res := ps.DB.Execute(ps.Statement, []any{"row" + strconv.Itoa(i)}); res.Err != nil {
+				b.Fatal(res.Err)
+			}
+		}
+	}
+}
+
+func BenchmarkInsertMany(b *testing.B) {
+	ps := mustPrepareInsert(b)
+	batches := [][]any{}
+	for i := range 100 {
+		batches = append(batches, []any{"row" + strconv.Itoa(i)})
+	}
+	for b.Loop() {
+		if res := ps.ExecuteMany(batches); res.Err != nil {
+			b.Fatal(res.Err)
+		}
+	}
+}
+
+func mustPrepareInsert(b *testing.B) *PreparedStatement {
+	db, err := New(false, b.TempDir()+"/bench")
+	if err != nil {
+		b.Fatal(err)
+	}
+	statements := db.Tokenize("CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT);")
+	if res := db.Execute(statements[0], []any{}); res.Err != nil {
+		b.Fatal(res.Err)
+	}
+	ps, err := db.NewPreparedStatement("INSERT INTO foo (name) VALUES (?);")
+	if err != nil {
+		b.Fatal(err)
 	}
+	return ps
 }
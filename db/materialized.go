@@ -0,0 +1,84 @@
+package db
+
+import (
+	"strings"
+
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/planner"
+	"github.com/chirst/cdb/vm"
+)
+
+// materializedTables are the MATERIALIZED common tables computed within a
+// transaction. The rows of each are held in an ephemeral b tree read by every
+// statement of the transaction naming the same common table, so the select of
+// the common table is computed once. A common table is discarded when a
+// statement writes to a table its select reads.
+type materializedTables struct {
+	tables map[string]*materializedTable
+}
+
+// materializedTable is a common table of materializedTables.
+type materializedTable struct {
+	table *planner.VirtualTable
+	// sources are the tables the select of the common table reads. sources is
+	// nil when they are not known, such as when the select reads another
+	// common table, in which case any write discards the common table.
+	sources map[string]int
+}
+
+func newMaterializedTables() *materializedTables {
+	return &materializedTables{tables: map[string]*materializedTable{}}
+}
+
+// commonTableKey returns the key of cte in materializedTables. Selects with the
+// same text have the same rows within a transaction as long as the common
+// tables they may name are the same, so the key is the text of the select along
+// with the text of the common tables before it.
+func commonTableKey(cte compiler.CommonTableExpr) string {
+	sb := &strings.Builder{}
+	for _, preceding := range cte.Select.With {
+		sb.WriteString(preceding.Name + " AS (" + preceding.SQL + "), ")
+	}
+	sb.WriteString(cte.SQL)
+	return sb.String()
+}
+
+// get returns the table of cte or nil when it has not been computed.
+func (m *materializedTables) get(cte compiler.CommonTableExpr) *planner.VirtualTable {
+	if mt, ok := m.tables[commonTableKey(cte)]; ok {
+		return mt.table
+	}
+	return nil
+}
+
+// add adds the table of cte computed by plan.
+func (m *materializedTables) add(cte compiler.CommonTableExpr, plan *vm.ExecutionPlan, table *planner.VirtualTable) {
+	m.tables[commonTableKey(cte)] = &materializedTable{
+		table:   table,
+		sources: plan.Tables,
+	}
+}
+
+// invalidate discards the common tables reading a table plan writes to. Every
+// common table is discarded when plan writes to a table other than by INSERT,
+// UPDATE or DELETE since the table it writes to is not known.
+func (m *materializedTables) invalidate(plan *vm.ExecutionPlan) {
+	if plan.ReadOnly() {
+		return
+	}
+	for key, mt := range m.tables {
+		_, reads := mt.sources[plan.ChangedTable]
+		if reads || mt.sources == nil || plan.ChangedTable == "" {
+			mt.table.Table.Close()
+			delete(m.tables, key)
+		}
+	}
+}
+
+// close discards every common table once the transaction has ended.
+func (m *materializedTables) close() {
+	for key, mt := range m.tables {
+		mt.table.Table.Close()
+		delete(m.tables, key)
+	}
+}
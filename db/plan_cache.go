@@ -0,0 +1,112 @@
+package db
+
+import (
+	"slices"
+	"sync"
+
+	"github.com/chirst/cdb/vm"
+)
+
+// defaultPlanCacheSize is the number of plans a database caches unless
+// WithPlanCacheSize is given.
+const defaultPlanCacheSize = 128
+
+// planCacheKey identifies a cached plan by the normalized SQL of the statement
+// and the catalog version the plan was compiled with. A plan compiled with an
+// older version is never found since the current version is part of the key.
+type planCacheKey struct {
+	sql     string
+	version string
+}
+
+// planCache is a LRU (least recently used) cache of execution plans so a
+// statement that is executed repeatedly is only lexed, parsed and planned once.
+// planCache is safe for concurrent use.
+type planCache struct {
+	mu    sync.Mutex
+	plans map[planCacheKey]*vm.ExecutionPlan
+	// evictList maintains an ordered list of keys currently in the cache. The
+	// list is ordered by the least recently used key at the 0th index.
+	evictList []planCacheKey
+	// maxPlans is the most plans the cache holds. When it is 0 nothing is
+	// cached.
+	maxPlans int
+}
+
+func newPlanCache(maxPlans int) *planCache {
+	return &planCache{
+		plans:     map[planCacheKey]*vm.ExecutionPlan{},
+		evictList: []planCacheKey{},
+		maxPlans:  maxPlans,
+	}
+}
+
+// get returns the plan cached for key and prioritizes it.
+func (c *planCache) get(key planCacheKey) (*vm.ExecutionPlan, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	plan, ok := c.plans[key]
+	if !ok {
+		return nil, false
+	}
+	c.prioritize(key)
+	return plan, true
+}
+
+// add caches plan for key evicting the least recently used plan when the cache
+// is full.
+func (c *planCache) add(key planCacheKey, plan *vm.ExecutionPlan) {
+	if c.maxPlans <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.plans[key]; ok {
+		c.plans[key] = plan
+		c.prioritize(key)
+		return
+	}
+	if len(c.evictList) >= c.maxPlans {
+		delete(c.plans, c.evictList[0])
+		c.evictList = c.evictList[1:]
+	}
+	c.plans[key] = plan
+	c.evictList = append(c.evictList, key)
+}
+
+// remove removes the plan cached for key.
+func (c *planCache) remove(key planCacheKey) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.plans[key]; !ok {
+		return
+	}
+	delete(c.plans, key)
+	c.evictList = slices.DeleteFunc(c.evictList, func(k planCacheKey) bool {
+		return k == key
+	})
+}
+
+// len returns the number of cached plans.
+func (c *planCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.plans)
+}
+
+// prioritize moves key to the most recently used end of evictList.
+func (c *planCache) prioritize(key planCacheKey) {
+	i := slices.Index(c.evictList, key)
+	c.evictList = append(slices.Delete(c.evictList, i, i+1), key)
+}
+
+// isCacheable returns true when plan may be executed again without being
+// compiled. A plan reading a virtual table is not cacheable since the rows of
+// the table, such as those of a common table expression or pragma, are
+// computed while planning.
+func isCacheable(plan *vm.ExecutionPlan) bool {
+	return !slices.ContainsFunc(plan.Commands, func(c vm.Command) bool {
+		_, ok := c.(*vm.OpenVirtualCmd)
+		return ok
+	})
+}
@@ -2,16 +2,20 @@
 package driver
 
 // TODO there are several context methods that are not implemented.
-// TODO transactions statements are not supported.
+// TODO transaction statements such as BEGIN are not supported. Use sql.DB.Begin
+// instead.
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
+	"fmt"
 	"io"
 
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/db"
+	"github.com/chirst/cdb/vm"
 )
 
 func init() {
@@ -29,46 +33,98 @@ type cdbDriver struct{}
 // name is :memory: the database will not use a file and will not persist
 // changes.
 func (c *cdbDriver) Open(name string) (driver.Conn, error) {
+	connector, err := c.OpenConnector(name)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(context.Background())
+}
+
+// OpenConnector implements driver.DriverContext. The database is opened once
+// for the connector so every connection in the pool of a sql.DB shares it. This
+// means the connections of a :memory: database see the same tables and rows.
+func (c *cdbDriver) OpenConnector(name string) (driver.Connector, error) {
 	isMemory := name == ":memory:"
 	d, err := db.New(isMemory, name)
 	if err != nil {
 		return nil, err
 	}
-	cn := &cdbConn{
-		cdb: d,
-	}
-	return cn, nil
+	return &cdbConnector{driver: c, cdb: d}, nil
+}
+
+type cdbConnector struct {
+	driver *cdbDriver
+	cdb    *db.DB
+}
+
+// Connect implements driver.Connector. Each connection has its own
+// transaction.
+func (c *cdbConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	return &cdbConn{conn: c.cdb.Conn()}, nil
+}
+
+// Driver implements driver.Connector.
+func (c *cdbConnector) Driver() driver.Driver {
+	return c.driver
 }
 
 type cdbConn struct {
-	cdb *db.DB
+	conn *db.Conn
 }
 
 // Begin implements driver.Conn.
 func (c *cdbConn) Begin() (driver.Tx, error) {
-	panic("Transactions not implemented")
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+// BeginTx implements driver.ConnBeginTx. A read only transaction rejects
+// statements that write. Transactions are always serializable since only one
+// write transaction can be in progress at a time.
+func (c *cdbConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	level := sql.IsolationLevel(opts.Isolation)
+	if level != sql.LevelDefault && level != sql.LevelSerializable {
+		return nil, fmt.Errorf("isolation level %s is not supported", level)
+	}
+	if err := c.conn.Begin(ctx, opts.ReadOnly); err != nil {
+		return nil, err
+	}
+	return &cdbTx{conn: c.conn}, nil
 }
 
 // Close implements driver.Conn.
 func (c *cdbConn) Close() error {
-	return nil
+	return c.conn.Close()
+}
+
+type cdbTx struct {
+	conn *db.Conn
+}
+
+// Commit implements driver.Tx.
+func (c *cdbTx) Commit() error {
+	return c.conn.Commit()
+}
+
+// Rollback implements driver.Tx.
+func (c *cdbTx) Rollback() error {
+	return c.conn.Rollback()
 }
 
 // Prepare implements driver.Conn.
 func (c *cdbConn) Prepare(query string) (driver.Stmt, error) {
-	statements := c.cdb.Tokenize(query)
-	if len(statements) != 1 {
+	ps, err := c.conn.Prepare(query)
+	if err != nil {
 		return nil, errors.New("driver supports only one statement at a time")
 	}
 	return &cdbStmt{
-		cdb:       c.cdb,
+		conn:      c.conn,
 		query:     query,
-		statement: statements[0],
+		statement: ps.Statement,
 	}, nil
 }
 
 type cdbStmt struct {
-	cdb       *db.DB
+	conn      *db.Conn
 	query     string
 	statement compiler.Statement
 }
@@ -80,11 +136,24 @@ func (c *cdbStmt) Close() error {
 
 // Exec implements driver.Stmt.
 func (c *cdbStmt) Exec(args []driver.Value) (driver.Result, error) {
-	result := c.cdb.Execute(c.statement, toAny(args))
+	return c.runExec(context.Background(), toAny(args))
+}
+
+// ExecContext implements driver.StmtExecContext. ctx bounds how long the
+// statement waits to begin its transaction.
+func (c *cdbStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return c.runExec(ctx, namedToAny(args))
+}
+
+func (c *cdbStmt) runExec(ctx context.Context, args []any) (driver.Result, error) {
+	result := c.conn.ExecuteContext(ctx, c.statement, args)
 	if result.Err != nil {
 		return nil, result.Err
 	}
-	cr := &cdbResult{}
+	cr := &cdbResult{
+		rowsAffected:    result.RowsAffected,
+		lastInsertRowID: result.LastInsertRowID,
+	}
 	return cr, nil
 }
 
@@ -99,15 +168,21 @@ func (c *cdbStmt) NumInput() int {
 
 // Query implements driver.Stmt.
 func (c *cdbStmt) Query(args []driver.Value) (driver.Rows, error) {
-	result := c.cdb.Execute(c.statement, toAny(args))
-	if result.Err != nil {
-		return nil, result.Err
-	}
-	cr := &cdbRows{
-		cols: result.ResultHeader,
-		rows: result.ResultRows,
+	return c.runQuery(context.Background(), toAny(args))
+}
+
+// QueryContext implements driver.StmtQueryContext. ctx bounds how long the
+// statement waits to begin its transaction.
+func (c *cdbStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return c.runQuery(ctx, namedToAny(args))
+}
+
+func (c *cdbStmt) runQuery(ctx context.Context, args []any) (driver.Rows, error) {
+	rows, err := c.conn.Query(ctx, c.statement, args)
+	if err != nil {
+		return nil, err
 	}
-	return cr, nil
+	return &cdbRows{rows: rows}, nil
 }
 
 func toAny(args []driver.Value) []any {
@@ -118,44 +193,65 @@ func toAny(args []driver.Value) []any {
 	return aarg
 }
 
-type cdbResult struct{}
+// namedToAny converts args to positional parameters. Names are not supported
+// so args are used in ordinal order.
+func namedToAny(args []driver.NamedValue) []any {
+	aarg := make([]any, len(args))
+	for _, arg := range args {
+		aarg[arg.Ordinal-1] = arg.Value
+	}
+	return aarg
+}
+
+type cdbResult struct {
+	rowsAffected    int
+	lastInsertRowID int
+}
 
 // LastInsertId implements driver.Result.
 func (c *cdbResult) LastInsertId() (int64, error) {
-	return 0, nil
+	return int64(c.lastInsertRowID), nil
 }
 
 // RowsAffected implements driver.Result.
 func (c *cdbResult) RowsAffected() (int64, error) {
-	return 0, nil
+	return int64(c.rowsAffected), nil
 }
 
+// cdbRows streams the rows of a query so the whole result is never held in
+// memory.
 type cdbRows struct {
-	cols   []string
-	rows   [][]*string
-	rowIdx int
+	rows *db.Rows
 }
 
 // Close implements driver.Rows.
 func (c *cdbRows) Close() error {
-	return nil
+	return c.rows.Close()
 }
 
 // Columns implements driver.Rows.
 func (c *cdbRows) Columns() []string {
-	return c.cols
+	return c.rows.ResultHeader
 }
 
 // Next implements driver.Rows.
 func (c *cdbRows) Next(dest []driver.Value) error {
-	if c.rowIdx == len(c.rows) {
+	if !c.rows.Next() {
+		if err := c.rows.Err(); err != nil {
+			return err
+		}
 		return io.EOF
 	}
-	for i, v := range c.rows[c.rowIdx] {
-		// TODO the value is a string pointer, but might be better as a typed
-		// value. It is a string pointer so it can be null.
-		dest[i] = *v
+	for i, v := range c.rows.Row() {
+		dest[i] = driverValue(v)
 	}
-	c.rowIdx += 1
 	return nil
 }
+
+// driverValue converts v to one of the types a driver.Value can be.
+func driverValue(v vm.Value) driver.Value {
+	if v.Type() == vm.IntegerType {
+		return int64(v.Int())
+	}
+	return v.Any()
+}
@@ -1,6 +1,7 @@
 package driver_test
 
 import (
+	"context"
 	"database/sql"
 	"testing"
 )
@@ -145,10 +146,16 @@ func TestInsertWithParams(t *testing.T) {
 		t.Run(c.caseName, func(t *testing.T) {
 			db := mustOpenSqlDb(t)
 			mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
-			_, err := db.Exec(c.sql, c.args...)
+			res, err := db.Exec(c.sql, c.args...)
 			if err != nil {
 				t.Fatal(err)
 			}
+			if n, err := res.RowsAffected(); err != nil || n != 1 {
+				t.Fatalf("expected 1 row affected got %d err %v", n, err)
+			}
+			if id, err := res.LastInsertId(); err != nil || id != int64(c.expectedId) {
+				t.Fatalf("expected last insert id %d got %d err %v", c.expectedId, id, err)
+			}
 			rows, err := db.Query("SELECT * FROM foo")
 			if err != nil {
 				t.Fatalf("query err %s", err)
@@ -167,3 +174,89 @@ func TestInsertWithParams(t *testing.T) {
 		})
 	}
 }
+
+func TestConnectionsShareDatabase(t *testing.T) {
+	db := mustOpenSqlDb(t)
+	ctx := context.Background()
+	c1, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c1.Close()
+	c2, err := db.Conn(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Close()
+	if _, err := c1.ExecContext(ctx, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c2.ExecContext(ctx, "INSERT INTO foo (name) VALUES ('one')"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTransactions(t *testing.T) {
+	db := mustOpenSqlDb(t)
+	mustExecute(t, db, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+	count := func() int {
+		var n int
+		if err := db.QueryRow("SELECT COUNT(*) FROM foo").Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		return n
+	}
+
+	t.Run("commit", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("INSERT INTO foo (name) VALUES ('one')"); err != nil {
+			t.Fatal(err)
+		}
+		rows, err := tx.Query("SELECT * FROM foo")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fs := toFoos(rows); len(fs) != 1 {
+			t.Fatalf("expected transaction to see 1 row got %d", len(fs))
+		}
+		if _, err := tx.Exec("INSERT INTO foo (name) VALUES ('two')"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Commit(); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 2 {
+			t.Fatalf("expected 2 rows got %d", got)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		tx, err := db.Begin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tx.Exec("INSERT INTO foo (name) VALUES ('three')"); err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Rollback(); err != nil {
+			t.Fatal(err)
+		}
+		if got := count(); got != 2 {
+			t.Fatalf("expected 2 rows got %d", got)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		tx, err := db.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec("INSERT INTO foo (name) VALUES ('four')"); err == nil {
+			t.Fatal("expected err writing in a read only transaction")
+		}
+	})
+}
@@ -5,6 +5,6 @@ go 1.24.0
 toolchain go1.24.3
 
 require (
-	golang.org/x/sys v0.39.0 // indirect
+	golang.org/x/sys v0.39.0
 	golang.org/x/term v0.38.0
 )
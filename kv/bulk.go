@@ -0,0 +1,117 @@
+package kv
+
+import (
+	"bytes"
+	"errors"
+	"runtime"
+	"slices"
+	"sync"
+
+	"github.com/chirst/cdb/pager"
+)
+
+// ErrDuplicateKey is returned when bulk loading tuples with the same key.
+var ErrDuplicateKey = errors.New("duplicate key")
+
+// minParallelSortSize is the amount of tuples below which sorting is done on a
+// single goroutine since the cost of coordinating goroutines outweighs the
+// benefit.
+const minParallelSortSize = 4096
+
+// BulkLoad inserts tuples into the empty b tree of the cursor. This is intended
+// for building a b tree from a large amount of existing data such as an index
+// on an existing table.
+//
+// The tuples are sorted by key using multiple goroutines. The sorted tuples are
+// then inserted in ascending order by a single writer. Inserting in ascending
+// order means each insert is an append which leaves pages full rather than half
+// empty. ErrDuplicateKey is returned before anything is inserted if two tuples
+// share a key.
+func (c *Cursor) BulkLoad(tuples []pager.PageTuple) error {
+	SortTuples(tuples, runtime.GOMAXPROCS(0))
+	for i := 1; i < len(tuples); i += 1 {
+		if bytes.Equal(tuples[i-1].Key, tuples[i].Key) {
+			return ErrDuplicateKey
+		}
+	}
+	for _, t := range tuples {
+		if err := c.Set(t.Key, t.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SortTuples sorts tuples by key using up to workers goroutines. The tuples are
+// divided into a run per worker. Each run is sorted on its own goroutine and
+// then neighboring runs are merged in parallel until one run remains.
+func SortTuples(tuples []pager.PageTuple, workers int) {
+	if workers < 2 || len(tuples) < minParallelSortSize {
+		slices.SortFunc(tuples, compareTuples)
+		return
+	}
+	runSize := (len(tuples) + workers - 1) / workers
+	runs := [][]pager.PageTuple{}
+	for start := 0; start < len(tuples); start += runSize {
+		runs = append(runs, tuples[start:min(start+runSize, len(tuples))])
+	}
+	var wg sync.WaitGroup
+	for _, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			slices.SortFunc(run, compareTuples)
+		}()
+	}
+	wg.Wait()
+	// Runs are contiguous in tuples so merging two neighbors produces a run
+	// covering both. The scratch buffer is swapped with tuples each round.
+	scratch := make([]pager.PageTuple, len(tuples))
+	src, dst := tuples, scratch
+	for len(runs) > 1 {
+		merged := [][]pager.PageTuple{}
+		offset := 0
+		for i := 0; i < len(runs); i += 2 {
+			left := runs[i]
+			var right []pager.PageTuple
+			if i+1 < len(runs) {
+				right = runs[i+1]
+			}
+			out := dst[offset : offset+len(left)+len(right)]
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				mergeTuples(out, left, right)
+			}()
+			merged = append(merged, out)
+			offset += len(out)
+		}
+		wg.Wait()
+		runs = merged
+		src, dst = dst, src
+	}
+	if &src[0] != &tuples[0] {
+		copy(tuples, src)
+	}
+}
+
+// mergeTuples merges the sorted left and right into out.
+func mergeTuples(out, left, right []pager.PageTuple) {
+	i, j, k := 0, 0, 0
+	for i < len(left) && j < len(right) {
+		if compareTuples(right[j], left[i]) < 0 {
+			out[k] = right[j]
+			j += 1
+		} else {
+			out[k] = left[i]
+			i += 1
+		}
+		k += 1
+	}
+	k += copy(out[k:], left[i:])
+	copy(out[k:], right[j:])
+}
+
+func compareTuples(a, b pager.PageTuple) int {
+	return bytes.Compare(a.Key, b.Key)
+}
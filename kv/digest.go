@@ -0,0 +1,56 @@
+package kv
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+
+	"github.com/chirst/cdb/catalog"
+)
+
+// Digest returns a sha256 hash of the logical content of every table and
+// bucket. Two databases with the same schema and rows have the same digest even
+// when their files differ because pages were split, freed or allocated in a
+// different order. Indexes are not part of the digest since they are derived
+// from tables and the time an object was modified is not part of the digest
+// since it depends on when a statement ran rather than what it changed.
+//
+// Every object is hashed within a single read transaction so the digest is of
+// the database at one point in time. pager.ErrBusy is returned if ctx is done
+// before the transaction can begin.
+func (kv *KV) Digest(ctx context.Context) ([]byte, error) {
+	s, err := kv.BeginReadTransaction(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer kv.EndReadTransaction(s)
+	h := sha256.New()
+	for _, o := range kv.catalog.GetObjects(catalog.SchemaOrderName) {
+		if o.ObjectType == "index" {
+			continue
+		}
+		writeDigestField(h, []byte(o.ObjectType))
+		writeDigestField(h, []byte(o.Name))
+		writeDigestField(h, []byte(o.JsonSchema))
+		c := s.NewCursor(o.RootPageNumber)
+		for exists := c.GotoFirstRecord(); exists; exists = c.GotoNext() {
+			writeDigestField(h, c.GetKey())
+			writeDigestField(h, c.GetValue())
+		}
+		// An empty field ends the tuples of the object so the tuples of one
+		// object cannot be mistaken for the name of the next.
+		writeDigestField(h, nil)
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
+
+// writeDigestField writes b to h prefixed with its length so adjacent fields
+// cannot run together.
+func writeDigestField(h hash.Hash, b []byte) {
+	h.Write(binary.AppendUvarint(nil, uint64(len(b))))
+	h.Write(b)
+}
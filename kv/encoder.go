@@ -2,10 +2,22 @@ package kv
 
 import (
 	"bytes"
+	"encoding/binary"
 	"encoding/gob"
+	"errors"
 	"fmt"
+	"math"
+	"sync"
 )
 
+// decodeReaderPool holds the readers Decode and DecodeKey read from so decoding
+// does not allocate a new reader each time.
+var decodeReaderPool = sync.Pool{
+	New: func() any {
+		return bytes.NewReader(nil)
+	},
+}
+
 func Encode(v []interface{}) ([]byte, error) {
 	var buf bytes.Buffer
 	err := gob.NewEncoder(&buf).Encode(&v)
@@ -15,10 +27,25 @@ func Encode(v []interface{}) ([]byte, error) {
 	return buf.Bytes(), nil
 }
 
+// getDecodeReader returns a pooled reader of v.
+func getDecodeReader(v []byte) *bytes.Reader {
+	r := decodeReaderPool.Get().(*bytes.Reader)
+	r.Reset(v)
+	return r
+}
+
+// putDecodeReader returns r to the pool. r is reset first so the pool does not
+// keep what it was reading alive.
+func putDecodeReader(r *bytes.Reader) {
+	r.Reset(nil)
+	decodeReaderPool.Put(r)
+}
+
 func Decode(v []byte) ([]any, error) {
-	buf := bytes.NewBuffer(v)
+	r := getDecodeReader(v)
+	defer putDecodeReader(r)
 	var s []any
-	err := gob.NewDecoder(buf).Decode(&s)
+	err := gob.NewDecoder(r).Decode(&s)
 	if err != nil {
 		return nil, fmt.Errorf("err decoding value %w", err)
 	}
@@ -35,11 +62,330 @@ func EncodeKey(v any) ([]byte, error) {
 }
 
 func DecodeKey(v []byte) (any, error) {
-	buf := bytes.NewBuffer(v)
+	r := getDecodeReader(v)
+	defer putDecodeReader(r)
 	var s any
-	err := gob.NewDecoder(buf).Decode(&s)
+	err := gob.NewDecoder(r).Decode(&s)
 	if err != nil {
 		return nil, fmt.Errorf("err decoding key %w", err)
 	}
 	return s, nil
 }
+
+// ErrNonIntegerKey is returned when a key that must be a row id is not an
+// integer, such as the key of an index entry.
+var ErrNonIntegerKey = errors.New("key is not an integer")
+
+// DecodeRowID decodes a key made by EncodeKey that must be an integer row id.
+// ErrNonIntegerKey is returned for any other key, including the keys of an
+// index which are not made by EncodeKey.
+func DecodeRowID(v []byte) (int, error) {
+	dk, err := DecodeKey(v)
+	if err != nil {
+		return 0, fmt.Errorf("%w: %w", ErrNonIntegerKey, err)
+	}
+	id, ok := dk.(int)
+	if !ok {
+		return 0, fmt.Errorf("%w: key is %T", ErrNonIntegerKey, dk)
+	}
+	return id, nil
+}
+
+// Tags prefixing each value of an index key. The tags order values of different
+// types the same as the vm orders them which is NULL < number < text < blob.
+// Integers and floats share a tag since they are ordered by value.
+const (
+	indexTagNull   byte = 0x01
+	indexTagNumber byte = 0x02
+	indexTagText   byte = 0x03
+	indexTagBlob   byte = 0x04
+)
+
+// Bytes following the float image of a number. An integer is ordered relative
+// to the float it rounds to by indexNumberBelow, indexNumberEqual and
+// indexNumberAbove. Then integers and floats equal to the same image are told
+// apart by indexNumberInteger and indexNumberFloat.
+const (
+	indexNumberBelow   byte = 0x01
+	indexNumberEqual   byte = 0x02
+	indexNumberAbove   byte = 0x03
+	indexNumberInteger byte = 0x01
+	indexNumberFloat   byte = 0x02
+)
+
+// EncodeIndexKey encodes the key of an index entry for value in the row with
+// rowId. Unlike EncodeKey the encoding preserves order when compared with
+// bytes.Compare so entries are sorted by value then row id. The encoding of
+// value is a prefix of the key so every entry for a value can be found by
+// seeking to EncodeIndexPrefix.
+func EncodeIndexKey(value any, rowId int) ([]byte, error) {
+	return EncodeIndexTuple([]any{value}, rowId)
+}
+
+// EncodeIndexTuple encodes the key of an index entry for the values of several
+// columns in the row with rowId. Keys compared with bytes.Compare are sorted by
+// the first value, then the second value and so on, then row id. Each value is
+// ordered the same as the vm orders it so integers and floats are ordered by
+// value and text is ordered before any longer text it is a prefix of.
+func EncodeIndexTuple(values []any, rowId int) ([]byte, error) {
+	k, err := EncodeIndexTuplePrefix(values)
+	if err != nil {
+		return nil, err
+	}
+	return appendIndexInt(k, int64(rowId)), nil
+}
+
+// EncodeIndexPrefix encodes value the same as it is encoded at the start of an
+// index key.
+func EncodeIndexPrefix(value any) ([]byte, error) {
+	return appendIndexValue(nil, value)
+}
+
+// EncodeIndexTuplePrefix encodes values the same as they are encoded at the
+// start of an index key. values may be fewer than the columns of the index to
+// find every entry starting with them.
+func EncodeIndexTuplePrefix(values []any) ([]byte, error) {
+	k := []byte{}
+	for _, v := range values {
+		var err error
+		if k, err = appendIndexValue(k, v); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// EncodeIndexTypePrefix encodes the tag every index key for a value of the same
+// type as value starts with. Seeking to the prefix finds the smallest value of
+// the type.
+func EncodeIndexTypePrefix(value any) ([]byte, error) {
+	k, err := EncodeIndexPrefix(value)
+	if err != nil {
+		return nil, err
+	}
+	return k[:1], nil
+}
+
+// EncodeIndexNumberPrefix encodes the start of the index key of every number
+// that may be equal to the integer or float value. Unlike EncodeIndexPrefix the
+// prefix is shared by an integer and a float of the same value such as 2 and
+// 2.0.
+func EncodeIndexNumberPrefix(value any) ([]byte, error) {
+	k, err := EncodeIndexPrefix(value)
+	if err != nil {
+		return nil, err
+	}
+	if len(k) < indexNumberPrefixSize || k[0] != indexTagNumber {
+		return nil, fmt.Errorf("err encoding index number prefix for type %T", value)
+	}
+	return k[:indexNumberPrefixSize], nil
+}
+
+// indexNumberPrefixSize is the size of the tag, float image and relation to the
+// image that start the encoding of a number.
+const indexNumberPrefixSize = 1 + 8 + 1
+
+// EncodeIndexTextPrefix encodes the start of the index key of every text value
+// that begins with prefix. Unlike EncodeIndexPrefix the encoding is not
+// terminated so longer values share it.
+func EncodeIndexTextPrefix(prefix string) []byte {
+	k := appendIndexBytes([]byte{indexTagText}, []byte(prefix))
+	return k[:len(k)-2]
+}
+
+// DecodeIndexKey decodes an index key made by EncodeIndexKey into the indexed
+// value and the row id.
+func DecodeIndexKey(key []byte) (value any, rowId int, err error) {
+	values, rowId, err := DecodeIndexTuple(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(values) != 1 {
+		return nil, 0, fmt.Errorf("err decoding index key: expected 1 value got %d", len(values))
+	}
+	return values[0], rowId, nil
+}
+
+// DecodeIndexTuple decodes an index key made by EncodeIndexTuple into the
+// indexed values and the row id.
+func DecodeIndexTuple(key []byte) (values []any, rowId int, err error) {
+	values = []any{}
+	rest := key
+	// Every value starts with a tag and the row id has none so the row id is
+	// what remains once it is all that is left.
+	for len(rest) > 8 {
+		var v any
+		if v, rest, err = readIndexValue(rest); err != nil {
+			return nil, 0, err
+		}
+		values = append(values, v)
+	}
+	id, rest, err := readIndexInt(rest)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(rest) != 0 {
+		return nil, 0, errors.New("err decoding index key: trailing bytes")
+	}
+	return values, int(id), nil
+}
+
+// appendIndexValue appends the tag and encoding of value.
+func appendIndexValue(b []byte, value any) ([]byte, error) {
+	switch v := value.(type) {
+	case nil:
+		return append(b, indexTagNull), nil
+	case int:
+		return appendIndexInteger(append(b, indexTagNumber), int64(v)), nil
+	case int64:
+		return appendIndexInteger(append(b, indexTagNumber), v), nil
+	case float64:
+		if math.IsNaN(v) {
+			return nil, errors.New("err encoding index key: NaN is not ordered")
+		}
+		b = appendIndexFloat(append(b, indexTagNumber), v)
+		return append(b, indexNumberEqual, indexNumberFloat), nil
+	case string:
+		return appendIndexBytes(append(b, indexTagText), []byte(v)), nil
+	case []byte:
+		return appendIndexBytes(append(b, indexTagBlob), v), nil
+	}
+	return nil, fmt.Errorf("err encoding index key for type %T", value)
+}
+
+// appendIndexInteger appends the float image of i followed by how i relates to
+// the image and then i exactly. Large integers round to the same float so the
+// relation and exact value order them among each other and among floats.
+func appendIndexInteger(b []byte, i int64) []byte {
+	f := float64(i)
+	b = appendIndexFloat(b, f)
+	switch {
+	// The image of the largest integers is 2^63 which no int64 reaches.
+	case f >= math.MaxInt64 || i < int64(f):
+		b = append(b, indexNumberBelow)
+	case i > int64(f):
+		b = append(b, indexNumberAbove)
+	default:
+		b = append(b, indexNumberEqual)
+	}
+	return appendIndexInt(append(b, indexNumberInteger), i)
+}
+
+// appendIndexFloat appends f big endian with the sign bit flipped when f is
+// positive and every bit flipped when f is negative so floats sort by value.
+// Negative zero is appended as zero.
+func appendIndexFloat(b []byte, f float64) []byte {
+	if f == 0 {
+		f = 0
+	}
+	bits := math.Float64bits(f)
+	if f < 0 {
+		bits = ^bits
+	} else {
+		bits ^= 1 << 63
+	}
+	return binary.BigEndian.AppendUint64(b, bits)
+}
+
+// readIndexValue reads a value appended by appendIndexValue from the start of b
+// and returns the remaining bytes.
+func readIndexValue(b []byte) (any, []byte, error) {
+	if len(b) == 0 {
+		return nil, nil, errors.New("err decoding index key: empty value")
+	}
+	rest := b[1:]
+	switch b[0] {
+	case indexTagNull:
+		return nil, rest, nil
+	case indexTagNumber:
+		return readIndexNumber(rest)
+	case indexTagText:
+		v, rest, err := readIndexBytes(rest)
+		if err != nil {
+			return nil, nil, err
+		}
+		return string(v), rest, nil
+	case indexTagBlob:
+		return readIndexBytes(rest)
+	}
+	return nil, nil, fmt.Errorf("err decoding index key: unknown tag %d", b[0])
+}
+
+// readIndexNumber reads an integer appended by appendIndexInteger or a float
+// appended by appendIndexValue from the start of b and returns the remaining
+// bytes.
+func readIndexNumber(b []byte) (any, []byte, error) {
+	// The float image is followed by the relation to the image and the kind.
+	if len(b) < 8+1+1 {
+		return nil, nil, errors.New("err decoding index key: short number")
+	}
+	bits := binary.BigEndian.Uint64(b)
+	if bits&(1<<63) != 0 {
+		bits ^= 1 << 63
+	} else {
+		bits = ^bits
+	}
+	f := math.Float64frombits(bits)
+	switch b[9] {
+	case indexNumberFloat:
+		return f, b[10:], nil
+	case indexNumberInteger:
+		i, rest, err := readIndexInt(b[10:])
+		if err != nil {
+			return nil, nil, err
+		}
+		return int(i), rest, nil
+	}
+	return nil, nil, fmt.Errorf("err decoding index key: unknown number kind %d", b[9])
+}
+
+// appendIndexInt appends i big endian with the sign bit flipped so negative
+// numbers sort before positive numbers.
+func appendIndexInt(b []byte, i int64) []byte {
+	return binary.BigEndian.AppendUint64(b, uint64(i)^(1<<63))
+}
+
+// appendIndexBytes appends v terminated by 0x00 0x01. Each 0x00 in v is escaped
+// as 0x00 0xFF so the terminator sorts before any continuation which means a
+// value sorts before every longer value it is a prefix of.
+func appendIndexBytes(b []byte, v []byte) []byte {
+	for _, c := range v {
+		if c == 0x00 {
+			b = append(b, 0x00, 0xFF)
+		} else {
+			b = append(b, c)
+		}
+	}
+	return append(b, 0x00, 0x01)
+}
+
+// readIndexInt reads an integer appended by appendIndexInt from the start of b
+// and returns the remaining bytes.
+func readIndexInt(b []byte) (int64, []byte, error) {
+	if len(b) < 8 {
+		return 0, nil, errors.New("err decoding index key: short integer")
+	}
+	return int64(binary.BigEndian.Uint64(b) ^ (1 << 63)), b[8:], nil
+}
+
+// readIndexBytes reads bytes appended by appendIndexBytes from the start of b
+// and returns the remaining bytes.
+func readIndexBytes(b []byte) ([]byte, []byte, error) {
+	v := []byte{}
+	for i := 0; i+1 < len(b); i += 1 {
+		if b[i] != 0x00 {
+			v = append(v, b[i])
+			continue
+		}
+		switch b[i+1] {
+		case 0x01:
+			return v, b[i+2:], nil
+		case 0xFF:
+			v = append(v, 0x00)
+			i += 1
+		default:
+			return nil, nil, errors.New("err decoding index key: bad escape")
+		}
+	}
+	return nil, nil, errors.New("err decoding index key: unterminated bytes")
+}
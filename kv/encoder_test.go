@@ -55,3 +55,256 @@ func TestEncoding(t *testing.T) {
 		}
 	})
 }
+
+func TestEncodeIndexKey(t *testing.T) {
+	// ordered are values in ascending order.
+	ordered := []any{
+		nil,
+		math.Inf(-1),
+		math.MinInt64,
+		-1.5,
+		-1,
+		-0.5,
+		0,
+		0.0,
+		0.5,
+		1,
+		int64(2),
+		2.5,
+		1 << 53,
+		float64(1 << 53),
+		1<<53 + 1,
+		math.MaxInt64,
+		float64(math.MaxInt64),
+		math.Inf(1),
+		"",
+		"a",
+		"a\x00",
+		"a\x00b",
+		"ab",
+		"b",
+		[]byte{},
+		[]byte{0},
+		[]byte{1},
+	}
+	keys := [][]byte{}
+	for _, v := range ordered {
+		for _, rowId := range []int{-1, 0, 1} {
+			k, err := EncodeIndexKey(v, rowId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, k)
+		}
+	}
+	for i := 1; i < len(keys); i += 1 {
+		if bytes.Compare(keys[i-1], keys[i]) != -1 {
+			t.Fatalf("expected key %d %v to be less than key %d %v", i-1, keys[i-1], i, keys[i])
+		}
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		prefix, err := EncodeIndexPrefix("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := EncodeIndexKey("a", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			t.Fatalf("expected %v to have prefix %v", k, prefix)
+		}
+		other, err := EncodeIndexKey("ab", 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(other, prefix) {
+			t.Fatalf("expected %v to not have prefix %v", other, prefix)
+		}
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		for _, v := range ordered {
+			k, err := EncodeIndexKey(v, -7)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dv, rowId, err := DecodeIndexKey(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := v
+			if i, ok := v.(int64); ok {
+				want = int(i)
+			}
+			if !reflect.DeepEqual(dv, want) || rowId != -7 {
+				t.Fatalf("expected %#v and -7 got %#v and %d", want, dv, rowId)
+			}
+		}
+	})
+
+	t.Run("type prefix", func(t *testing.T) {
+		prefix, err := EncodeIndexTypePrefix("z")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []any{"", "a\x00", "b"} {
+			k, err := EncodeIndexKey(v, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(k, prefix) {
+				t.Fatalf("expected %v to have prefix %v", k, prefix)
+			}
+		}
+		k, err := EncodeIndexKey(1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(k, prefix) {
+			t.Fatalf("expected %v to not have prefix %v", k, prefix)
+		}
+	})
+
+	t.Run("number prefix", func(t *testing.T) {
+		prefix, err := EncodeIndexNumberPrefix(2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, v := range []any{2, 2.0} {
+			k, err := EncodeIndexKey(v, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.HasPrefix(k, prefix) {
+				t.Fatalf("expected %v to have prefix %v", k, prefix)
+			}
+		}
+		for _, v := range []any{3, 2.5, "2"} {
+			k, err := EncodeIndexKey(v, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if bytes.HasPrefix(k, prefix) {
+				t.Fatalf("expected %v to not have prefix %v", k, prefix)
+			}
+		}
+	})
+
+	t.Run("negative zero", func(t *testing.T) {
+		k1, err := EncodeIndexKey(math.Copysign(0, -1), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		k2, err := EncodeIndexKey(0.0, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(k1, k2) {
+			t.Fatalf("expected %v to equal %v", k1, k2)
+		}
+	})
+
+	t.Run("unsupported type", func(t *testing.T) {
+		if _, err := EncodeIndexKey(true, 1); err == nil {
+			t.Fatal("expected err for unsupported type")
+		}
+		if _, err := EncodeIndexKey(math.NaN(), 1); err == nil {
+			t.Fatal("expected err for NaN")
+		}
+	})
+}
+
+func TestEncodeIndexTuple(t *testing.T) {
+	// ordered are tuples in ascending order.
+	ordered := [][]any{
+		{nil, nil},
+		{nil, 1},
+		{-1, "b"},
+		{1, nil},
+		{1, "a"},
+		{1.5, nil},
+		{"a", 2},
+		{"a", 2.5},
+		{"a", "a"},
+		{"a\x00", nil},
+		{"ab", nil},
+		{[]byte{}, []byte{0}},
+	}
+	keys := [][]byte{}
+	for _, values := range ordered {
+		for _, rowId := range []int{-1, 0, 1} {
+			k, err := EncodeIndexTuple(values, rowId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, k)
+		}
+	}
+	for i := 1; i < len(keys); i += 1 {
+		if bytes.Compare(keys[i-1], keys[i]) != -1 {
+			t.Fatalf("expected key %d %v to be less than key %d %v", i-1, keys[i-1], i, keys[i])
+		}
+	}
+
+	t.Run("prefix", func(t *testing.T) {
+		prefix, err := EncodeIndexTuplePrefix([]any{"a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		k, err := EncodeIndexTuple([]any{"a", 2}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.HasPrefix(k, prefix) {
+			t.Fatalf("expected %v to have prefix %v", k, prefix)
+		}
+		other, err := EncodeIndexTuple([]any{"ab", 2}, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.HasPrefix(other, prefix) {
+			t.Fatalf("expected %v to not have prefix %v", other, prefix)
+		}
+	})
+
+	t.Run("decode", func(t *testing.T) {
+		for _, values := range ordered {
+			k, err := EncodeIndexTuple(values, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dv, rowId, err := DecodeIndexTuple(k)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dv, values) || rowId != 3 {
+				t.Fatalf("expected %#v and 3 got %#v and %d", values, dv, rowId)
+			}
+		}
+	})
+
+	t.Run("decode key with many values", func(t *testing.T) {
+		k, err := EncodeIndexTuple([]any{1, 2}, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := DecodeIndexKey(k); err == nil {
+			t.Fatal("expected err decoding a key with 2 values")
+		}
+	})
+}
+
+func BenchmarkDecode(b *testing.B) {
+	v, err := Encode([]any{1, "gud", nil})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Decode(v); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package kv
+
+import "github.com/chirst/cdb/pager"
+
+// Ephemeral is a b tree held in memory apart from the database. It holds the
+// intermediate results of a statement such as rows being sorted, the distinct
+// values seen so far, the values of an IN list or a materialized subquery. An
+// ephemeral b tree is not part of any transaction so writing to it never
+// touches the database file and it is discarded by Close.
+type Ephemeral struct {
+	pager          *pager.Pager
+	rowCache       *rowCache
+	rootPageNumber int
+}
+
+// NewEphemeral creates an empty ephemeral b tree.
+func NewEphemeral() *Ephemeral {
+	p := pager.NewEphemeral()
+	return &Ephemeral{
+		pager:          p,
+		rowCache:       newRowCache(),
+		rootPageNumber: p.NewPage().GetNumber(),
+	}
+}
+
+// NewCursor creates a cursor that reads and writes the ephemeral b tree. The
+// cursor can be used the same as a cursor of the database.
+func (e *Ephemeral) NewCursor() *Cursor {
+	return &Cursor{
+		rootPageNumber: e.rootPageNumber,
+		pager:          e.pager,
+		rowCache:       e.rowCache,
+	}
+}
+
+// Close discards the ephemeral b tree. Its cursors must not be used after it is
+// closed.
+func (e *Ephemeral) Close() {
+	e.rowCache.clear()
+	e.pager.RollbackWrite()
+}
@@ -0,0 +1,212 @@
+package kv
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/pager"
+)
+
+// IntegrityCheck walks the b tree of cdb_schema and of every object in the
+// catalog and returns a description of each problem found. No problems means
+// the database is intact. Each page is checked for a valid checksum and valid
+// tuple offsets. Each tree is checked for keys in ascending order, keys within
+// the range of the pointer to their page, parent pointers that agree with the
+// pointers to each page, subtree counts that agree with the leaves, siblings
+// that link the leaves in order and leaves that are all at the same depth. The
+// freelist is checked for pages that are free and not part of any tree.
+//
+// Every tree is checked within a single read transaction. pager.ErrBusy is
+// returned if ctx is done before the transaction can begin.
+func (kv *KV) IntegrityCheck(ctx context.Context) ([]string, error) {
+	s, err := kv.BeginReadTransaction(ctx)
+	if err != nil {
+		// The schema is parsed as the transaction begins so a corrupt schema
+		// is a problem found rather than a failure to check.
+		if errors.Is(err, pager.ErrCorrupt) {
+			return []string{err.Error()}, nil
+		}
+		return nil, err
+	}
+	defer kv.EndReadTransaction(s)
+	ic := &integrityCheck{
+		snapshot: s.snapshot,
+		visited:  map[int]bool{},
+		problems: []string{},
+	}
+	ic.checkTree(catalog.SchemaTableName, 1)
+	for _, o := range kv.catalog.GetObjects(catalog.SchemaOrderName) {
+		ic.checkTree(o.Name, o.RootPageNumber)
+	}
+	ic.checkFreelist()
+	return ic.problems, nil
+}
+
+// integrityCheck is the state of IntegrityCheck.
+type integrityCheck struct {
+	snapshot *pager.Snapshot
+	// visited is every page reached by any tree so far. A page reached twice
+	// belongs to more than one parent.
+	visited  map[int]bool
+	problems []string
+	// tree is the name of the object of the tree being checked.
+	tree string
+	// leafDepth is the depth of the first leaf of the tree or -1 before the
+	// first leaf is reached.
+	leafDepth int
+	// prevLeaf is the previous leaf of the tree in key order or nil before the
+	// first leaf is reached.
+	prevLeaf *pager.Page
+}
+
+func (ic *integrityCheck) report(format string, a ...any) {
+	ic.problems = append(ic.problems, ic.tree+": "+fmt.Sprintf(format, a...))
+}
+
+// checkTree checks the tree with rootPageNumber of the object named tree.
+func (ic *integrityCheck) checkTree(tree string, rootPageNumber int) {
+	ic.tree = tree
+	ic.leafDepth = -1
+	ic.prevLeaf = nil
+	ic.checkPage(rootPageNumber, 0, 0, nil, nil)
+	if ic.prevLeaf == nil {
+		return
+	}
+	if hasRight, right := ic.prevLeaf.GetRightPageNumber(); hasRight {
+		ic.report("last leaf page %d has right sibling %d", ic.prevLeaf.GetNumber(), right)
+	}
+}
+
+// checkPage checks the page with pageNumber and its subtree and returns the
+// number of leaf entries in the subtree. parent is 0 for the root. Every key of
+// the subtree must be at least low and less than high. A nil low or high is
+// unbounded.
+func (ic *integrityCheck) checkPage(pageNumber, parent, depth int, low, high []byte) int {
+	if pageNumber < 1 || pageNumber > ic.snapshot.MaxPage() {
+		ic.report("page %d is out of range", pageNumber)
+		return 0
+	}
+	if ic.visited[pageNumber] {
+		ic.report("page %d is referenced more than once", pageNumber)
+		return 0
+	}
+	ic.visited[pageNumber] = true
+	page, err := ic.snapshot.ReadPage(pageNumber)
+	if err == nil {
+		err = page.Check()
+	}
+	if err != nil {
+		ic.report("%s", err)
+		return 0
+	}
+	hasParent, parentPageNumber := page.GetParentPageNumber()
+	if parent == 0 && hasParent {
+		ic.report("root page %d has parent %d", pageNumber, parentPageNumber)
+	}
+	if parent != 0 && (!hasParent || parentPageNumber != parent) {
+		ic.report("page %d has parent %d but is a child of %d", pageNumber, parentPageNumber, parent)
+	}
+	entries := page.GetEntries()
+	for i, e := range entries {
+		if i > 0 && bytes.Compare(entries[i-1].Key, e.Key) >= 0 {
+			ic.report("page %d has key %d out of order", pageNumber, i)
+		}
+		if low != nil && bytes.Compare(e.Key, low) < 0 {
+			ic.report("page %d has key %d less than the key of its pointer", pageNumber, i)
+		}
+		if high != nil && bytes.Compare(e.Key, high) >= 0 {
+			ic.report("page %d has key %d not less than the key of the next pointer", pageNumber, i)
+		}
+	}
+	if page.IsLeaf() {
+		ic.checkLeaf(page, depth)
+		return len(entries)
+	}
+	if len(entries) == 0 {
+		ic.report("internal page %d has no pointers", pageNumber)
+	}
+	count := 0
+	for i, e := range entries {
+		if len(e.Value) < pointerPageNumberSize {
+			ic.report("page %d has pointer %d that is too short", pageNumber, i)
+			continue
+		}
+		// The first child may hold keys lower than its pointer key since
+		// searching sends keys lower than the first key to the first child.
+		childLow := e.Key
+		if i == 0 {
+			childLow = low
+		}
+		childHigh := high
+		if i < len(entries)-1 {
+			childHigh = entries[i+1].Key
+		}
+		child := pointerPageNumber(e.Value)
+		childCount := ic.checkPage(child, pageNumber, depth+1, childLow, childHigh)
+		if len(e.Value) >= pointerPageNumberSize+pointerCountSize && pointerCount(e.Value) != childCount {
+			ic.report("page %d counts %d entries for page %d which has %d", pageNumber, pointerCount(e.Value), child, childCount)
+		}
+		count += childCount
+	}
+	return count
+}
+
+// checkLeaf checks leaf is at the same depth as the other leaves of the tree
+// and is linked to the previous leaf in key order.
+func (ic *integrityCheck) checkLeaf(leaf *pager.Page, depth int) {
+	if ic.leafDepth == -1 {
+		ic.leafDepth = depth
+	} else if depth != ic.leafDepth {
+		ic.report("leaf page %d is at depth %d but other leaves are at depth %d", leaf.GetNumber(), depth, ic.leafDepth)
+	}
+	hasLeft, left := leaf.GetLeftPageNumber()
+	if ic.prevLeaf == nil {
+		if hasLeft {
+			ic.report("first leaf page %d has left sibling %d", leaf.GetNumber(), left)
+		}
+	} else {
+		if !hasLeft || left != ic.prevLeaf.GetNumber() {
+			ic.report("leaf page %d has left sibling %d but follows %d", leaf.GetNumber(), left, ic.prevLeaf.GetNumber())
+		}
+		if hasRight, right := ic.prevLeaf.GetRightPageNumber(); !hasRight || right != leaf.GetNumber() {
+			ic.report("leaf page %d has right sibling %d but precedes %d", ic.prevLeaf.GetNumber(), right, leaf.GetNumber())
+		}
+	}
+	ic.prevLeaf = leaf
+}
+
+// checkFreelist checks every page of the freelist is a free page that is not
+// part of a tree and that the freelist has as many pages as the file header
+// says.
+func (ic *integrityCheck) checkFreelist() {
+	ic.tree = "freelist"
+	pageNumber, count := ic.snapshot.Freelist()
+	found := 0
+	for pageNumber != 0 {
+		if pageNumber > ic.snapshot.MaxPage() {
+			ic.report("page %d is out of range", pageNumber)
+			return
+		}
+		if ic.visited[pageNumber] {
+			ic.report("page %d is referenced more than once", pageNumber)
+			return
+		}
+		ic.visited[pageNumber] = true
+		page, err := ic.snapshot.ReadPage(pageNumber)
+		if err != nil {
+			ic.report("%s", err)
+			return
+		}
+		if !page.IsFree() {
+			ic.report("page %d is not a free page", pageNumber)
+		}
+		found += 1
+		_, pageNumber = page.GetRightPageNumber()
+	}
+	if found != count {
+		ic.report("has %d pages but the header counts %d", found, count)
+	}
+}
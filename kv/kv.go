@@ -6,20 +6,44 @@ package kv
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
 	"fmt"
-	"log"
 	"slices"
+	"sort"
+	"sync"
 
 	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/logging"
+	"github.com/chirst/cdb/metrics"
 	"github.com/chirst/cdb/pager"
 )
 
+// ErrTupleTooLarge is returned when a key and value cannot fit on a page.
+var ErrTupleTooLarge = errors.New("tuple is too large to fit on a page")
+
 // KV is an abstraction on the pager module that provides efficient reads and
 // writes through b tree indexes.
 type KV struct {
 	pager   *pager.Pager
 	catalog *catalog.Catalog
+	// rowCache is shared by the cursors of the write transaction to remember
+	// point lookups made during the transaction. Each Snapshot has its own.
+	rowCache *rowCache
+	// commitHook is called before a write transaction commits. See
+	// SetCommitHook.
+	commitHook func() error
+	// rollbackHook is called after a write transaction rolls back. See
+	// SetRollbackHook.
+	rollbackHook func()
+	// schemaMu prevents concurrent read transactions from parsing the schema
+	// at the same time when they find the catalog is out of date.
+	schemaMu sync.Mutex
+	// schemaCookie is the schema cookie of the database file when the catalog
+	// was last parsed. When the cookie differs after a lock is acquired
+	// another process changed the schema so the catalog is parsed again.
+	schemaCookie int
 }
 
 // New creates an instance of kv
@@ -28,80 +52,404 @@ func New(useMemory bool, filename string) (*KV, error) {
 	if err != nil {
 		return nil, err
 	}
+	return newKV(pager)
+}
+
+// NewWithStorage creates an instance of kv on top of the given storage.
+func NewWithStorage(s pager.Storage) (*KV, error) {
+	pager, err := pager.NewWithStorage(s)
+	if err != nil {
+		return nil, err
+	}
+	return newKV(pager)
+}
+
+func newKV(pager *pager.Pager) (*KV, error) {
 	catalog := catalog.NewCatalog()
 	ret := &KV{
-		pager:   pager,
-		catalog: catalog,
+		pager:    pager,
+		catalog:  catalog,
+		rowCache: newRowCache(),
+		// The cookie is never negative so the catalog is parsed by the first
+		// transaction.
+		schemaCookie: -1,
 	}
-	err = ret.ParseSchema()
-	if err != nil {
+	if err := ret.RefreshSchema(context.Background()); err != nil {
 		return nil, err
 	}
 	return ret, nil
 }
 
+// RefreshSchema parses the catalog again when another process changed the
+// schema since it was last parsed. Transactions refresh the catalog as they
+// begin but a statement is planned before its transaction begins so it must
+// refresh the catalog first to see the objects another process created.
+// pager.ErrBusy is returned if ctx is done before the schema can be read.
+func (kv *KV) RefreshSchema(ctx context.Context) error {
+	s, err := kv.BeginReadTransaction(ctx)
+	if err != nil {
+		return err
+	}
+	kv.EndReadTransaction(s)
+	return nil
+}
+
 // GetCatalog returns and instance of the system catalog.
 func (kv *KV) GetCatalog() *catalog.Catalog {
 	return kv.catalog
 }
 
+// SetLogger replaces the logger of the kv and its pager.
+func (kv *KV) SetLogger(logger logging.Logger) {
+	kv.pager.SetLogger(logger)
+}
+
+// GetMetrics returns the metrics registry of the kv and its pager.
+func (kv *KV) GetMetrics() *metrics.Registry {
+	return kv.pager.GetMetrics()
+}
+
+// SetCommitHook sets the function called before each write transaction
+// commits. When hook returns an err the commit does not happen and
+// EndWriteTransaction returns the err so the transaction can be rolled back. A
+// nil hook removes the hook. SetCommitHook must not be called while a
+// transaction is in progress.
+func (kv *KV) SetCommitHook(hook func() error) {
+	kv.commitHook = hook
+}
+
+// SetRollbackHook sets the function called after each write transaction rolls
+// back. This includes a rollback after the commit hook returns an err. A nil
+// hook removes the hook. SetRollbackHook must not be called while a
+// transaction is in progress.
+func (kv *KV) SetRollbackHook(hook func()) {
+	kv.rollbackHook = hook
+}
+
 // NewBTree creates an empty BTree and returns the new tree's root page number.
 func (kv *KV) NewBTree() int {
 	np := kv.pager.NewPage()
 	return np.GetNumber()
 }
 
-// BeginReadTransaction begins a read transaction.
-func (kv *KV) BeginReadTransaction() error {
-	return kv.pager.BeginRead()
+// TransactionState returns the kind of transaction in progress and the number
+// of dirty pages it will write. It is safe to call from any goroutine.
+func (kv *KV) TransactionState() (pager.TransactionState, int) {
+	return kv.pager.TransactionState()
+}
+
+// SetCacheMemory sets the most bytes of pages the page cache holds. It is safe
+// to call from any goroutine.
+func (kv *KV) SetCacheMemory(bytes int) {
+	kv.pager.SetCacheMemory(bytes)
+}
+
+// SetCachePolicy replaces the page cache with an empty cache using policy. It
+// is safe to call from any goroutine.
+func (kv *KV) SetCachePolicy(policy pager.CachePolicy) {
+	kv.pager.SetCachePolicy(policy)
+}
+
+// SetDirtyPageLimit sets the most pages a write transaction changes in memory
+// before spilling them to a temporary file. It is safe to call from any
+// goroutine.
+func (kv *KV) SetDirtyPageLimit(limit int) {
+	kv.pager.SetDirtyPageLimit(limit)
+}
+
+// Rekey encrypts the database with key instead of the key it is encrypted with
+// now. Every page is written again within a single write transaction so the
+// database is encrypted with either key should the process crash. ctx is the
+// context of beginning the transaction. pager.ErrNotEncrypted is returned when
+// the database is not encrypted.
+func (kv *KV) Rekey(ctx context.Context, key []byte) error {
+	if err := kv.BeginWriteTransaction(ctx); err != nil {
+		return err
+	}
+	if err := kv.pager.Rekey(key); err != nil {
+		kv.RollbackWrite()
+		return err
+	}
+	if err := kv.EndWriteTransaction(); err != nil {
+		kv.RollbackWrite()
+		return err
+	}
+	return nil
 }
 
-// EndReadTransaction ends a read transaction.
-func (kv *KV) EndReadTransaction() {
-	kv.pager.EndRead()
+// CacheMemory returns the bytes of pages held by the page cache and the most
+// bytes it can hold. It is safe to call from any goroutine.
+func (kv *KV) CacheMemory() (bytes, maxBytes int) {
+	return kv.pager.CacheMemory(), kv.pager.MaxCacheMemory()
 }
 
-// BeginWriteTransaction begins a write transaction.
-func (kv *KV) BeginWriteTransaction() error {
-	return kv.pager.BeginWrite()
+// Snapshot is a read transaction. The cursors of a snapshot read the database
+// as it was when the transaction began even while a write transaction commits
+// changes.
+type Snapshot struct {
+	kv       *KV
+	snapshot *pager.Snapshot
+	// rowCache is shared by the cursors of the snapshot.
+	rowCache *rowCache
 }
 
-// RollbackWrite rolls back and ends a write transaction.
+// BeginReadTransaction begins a read transaction. pager.ErrBusy is returned
+// if ctx is done before the transaction can begin. The catalog is parsed again
+// if the database file changed since it was last parsed.
+func (kv *KV) BeginReadTransaction(ctx context.Context) (*Snapshot, error) {
+	ps, err := kv.pager.BeginRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	s := &Snapshot{
+		kv:       kv,
+		snapshot: ps,
+		rowCache: newRowCache(),
+	}
+	if err := kv.refreshSchema(ps.SchemaCookie(), s.NewCursor); err != nil {
+		kv.pager.EndRead(ps)
+		return nil, err
+	}
+	return s, nil
+}
+
+// Err returns the first pager.CorruptionError found by the cursors of s.
+func (s *Snapshot) Err() error {
+	return s.snapshot.Err()
+}
+
+// EndReadTransaction ends the read transaction of s.
+func (kv *KV) EndReadTransaction(s *Snapshot) {
+	kv.pager.EndRead(s.snapshot)
+}
+
+// NewCursor creates a cursor reading the snapshot of the b tree with the given
+// object's rootPageNumber.
+func (s *Snapshot) NewCursor(rootPageNumber int) *Cursor {
+	c := s.kv.NewCursor(rootPageNumber)
+	c.snapshot = s.snapshot
+	c.rowCache = s.rowCache
+	return c
+}
+
+// BeginWriteTransaction begins a write transaction. pager.ErrBusy is returned
+// if ctx is done before the transaction can begin. The catalog is parsed again
+// if the database file changed since it was last parsed. A catalog savepoint
+// is made so schema changes are discarded if the transaction is rolled back.
+func (kv *KV) BeginWriteTransaction(ctx context.Context) error {
+	if err := kv.pager.BeginWrite(ctx); err != nil {
+		return err
+	}
+	kv.rowCache.clear()
+	if err := kv.refreshSchema(kv.pager.SchemaCookie(), kv.NewCursor); err != nil {
+		kv.pager.RollbackWrite()
+		return err
+	}
+	kv.catalog.Savepoint()
+	return nil
+}
+
+// WriteErr returns the first pager.CorruptionError found by the write
+// transaction in progress. A write transaction that found a corrupt page cannot
+// commit.
+func (kv *KV) WriteErr() error {
+	return kv.pager.WriteErr()
+}
+
+// RollbackWrite rolls back and ends a write transaction. The catalog is
+// restored to its state when the transaction began.
 func (kv *KV) RollbackWrite() {
+	state, _ := kv.pager.TransactionState()
 	kv.pager.RollbackWrite()
+	kv.rowCache.clear()
+	kv.catalog.RollbackTo()
+	if state == pager.TransactionWrite && kv.rollbackHook != nil {
+		kv.rollbackHook()
+	}
 }
 
-// EndWriteTransaction ends a write transaction.
+// EndWriteTransaction ends a write transaction. If ending the transaction
+// fails or the commit hook returns an err it remains open so it can be rolled
+// back with RollbackWrite.
 func (kv *KV) EndWriteTransaction() error {
-	return kv.pager.EndWrite()
+	state, _ := kv.pager.TransactionState()
+	if state == pager.TransactionWrite && kv.commitHook != nil {
+		if err := kv.commitHook(); err != nil {
+			return err
+		}
+	}
+	// The catalog was current when the transaction began and has any schema
+	// changes made by the transaction so it is current with the cookie written
+	// by the transaction.
+	cookie := kv.pager.SchemaCookie()
+	if err := kv.pager.EndWrite(); err != nil {
+		return err
+	}
+	if state == pager.TransactionWrite {
+		kv.schemaMu.Lock()
+		kv.schemaCookie = cookie
+		kv.schemaMu.Unlock()
+	}
+	kv.rowCache.clear()
+	kv.catalog.Release()
+	return nil
+}
+
+// refreshSchema parses the schema with a cursor from newCursor when cookie, the
+// schema cookie of the transaction, differs from the cookie when the catalog
+// was last parsed. This happens when another process changes the schema.
+// refreshSchema must be called within a transaction so the schema cannot change
+// while it is parsed.
+func (kv *KV) refreshSchema(cookie int, newCursor func(rootPageNumber int) *Cursor) error {
+	kv.schemaMu.Lock()
+	defer kv.schemaMu.Unlock()
+	if cookie == kv.schemaCookie {
+		return nil
+	}
+	objects, err := readSchema(newCursor(1))
+	if err != nil {
+		return err
+	}
+	stats, err := readStats(objects, newCursor)
+	if err != nil {
+		return err
+	}
+	kv.catalog.SetSchema(objects, stats, cookie)
+	kv.pinRootPages(objects)
+	kv.schemaCookie = cookie
+	return nil
 }
 
-// ParseSchema updates the system catalog by reading the schema table.
+// ParseSchema updates the system catalog by reading the schema table and the
+// statistics in cdb_stat. When the schema or statistics were changed by the
+// write transaction the schema cookie is incremented so other processes parse
+// the schema again. ParseSchema must be called within
+// a write transaction otherwise it may read the schema while another process is
+// changing it.
 func (kv *KV) ParseSchema() error {
-	c := kv.NewCursor(1)
-	exists := c.GotoFirstRecord()
-	if !exists {
+	objects, err := readSchema(kv.NewCursor(1))
+	if err != nil {
+		return err
+	}
+	stats, err := readStats(objects, kv.NewCursor)
+	if err != nil {
+		return err
+	}
+	if kv.catalog.IsSchema(objects, stats) {
 		return nil
 	}
+	kv.pager.SetSchemaCookie(kv.pager.SchemaCookie() + 1)
+	kv.catalog.SetSchema(objects, stats, kv.pager.SchemaCookie())
+	kv.pinRootPages(objects)
+	return nil
+}
+
+// readSchema reads the objects of the schema table with c.
+func readSchema(c *Cursor) ([]catalog.Object, error) {
 	var objects []catalog.Object
-	for exists {
-		v := c.GetValue()
-		dv, err := Decode(v)
+	for exists := c.GotoFirstRecord(); exists; exists = c.GotoNext() {
+		o, err := decodeSchemaObject(c.GetValue())
 		if err != nil {
-			return err
-		}
-		o := &catalog.Object{
-			ObjectType:     dv[0].(string),
-			Name:           dv[1].(string),
-			TableName:      dv[2].(string),
-			RootPageNumber: dv[3].(int),
-			JsonSchema:     dv[4].(string),
+			return nil, err
 		}
 		objects = append(objects, *o)
-		exists = c.GotoNext()
 	}
-	kv.catalog.SetSchema(objects)
-	return nil
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// readStats reads the rows of cdb_stat with a cursor from newCursor. There are
+// no stats when objects, the objects of the schema, do not include cdb_stat. A
+// row of cdb_stat may have been changed to values that are not statistics so
+// such rows are skipped rather than trusted.
+func readStats(objects []catalog.Object, newCursor func(rootPageNumber int) *Cursor) ([]catalog.Stat, error) {
+	i := slices.IndexFunc(objects, func(o catalog.Object) bool {
+		return o.ObjectType == "table" && o.Name == catalog.StatTableName
+	})
+	if i == -1 {
+		return nil, nil
+	}
+	var stats []catalog.Stat
+	c := newCursor(objects[i].RootPageNumber)
+	for exists := c.GotoFirstRecord(); exists; exists = c.GotoNext() {
+		dv, err := Decode(c.GetValue())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s row: %w", pager.ErrCorrupt, catalog.StatTableName, err)
+		}
+		if len(dv) < 4 {
+			continue
+		}
+		tableName, ok1 := dv[0].(string)
+		rowCount, ok2 := dv[2].(int)
+		if !ok1 || !ok2 {
+			continue
+		}
+		stat := catalog.Stat{TableName: tableName, RowCount: rowCount}
+		if indexName, ok := dv[1].(string); ok {
+			distinctCount, ok := dv[3].(int)
+			if !ok {
+				continue
+			}
+			stat.IndexName = indexName
+			stat.DistinctCount = distinctCount
+		}
+		stats = append(stats, stat)
+	}
+	if err := c.Err(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// decodeSchemaObject decodes a row of cdb_schema. A row that is not a schema
+// object returns an err matching pager.ErrCorrupt rather than being trusted.
+func decodeSchemaObject(v []byte) (*catalog.Object, error) {
+	dv, err := Decode(v)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cdb_schema row: %w", pager.ErrCorrupt, err)
+	}
+	if len(dv) < 5 {
+		return nil, fmt.Errorf("%w: cdb_schema row has %d values", pager.ErrCorrupt, len(dv))
+	}
+	objectType, ok1 := dv[0].(string)
+	name, ok2 := dv[1].(string)
+	tableName, ok3 := dv[2].(string)
+	rootPageNumber, ok4 := dv[3].(int)
+	jsonSchema, ok5 := dv[4].(string)
+	if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 {
+		return nil, fmt.Errorf("%w: cdb_schema row has values of the wrong type", pager.ErrCorrupt)
+	}
+	if rootPageNumber < 1 {
+		return nil, fmt.Errorf("%w: cdb_schema row %s has root page %d", pager.ErrCorrupt, name, rootPageNumber)
+	}
+	o := &catalog.Object{
+		ObjectType:     objectType,
+		Name:           name,
+		TableName:      tableName,
+		RootPageNumber: rootPageNumber,
+		JsonSchema:     jsonSchema,
+	}
+	// Objects created before the modified time was recorded have one less
+	// value.
+	if len(dv) > 5 {
+		if modified, ok := dv[5].(string); ok {
+			o.Modified = modified
+		}
+	}
+	return o, nil
+}
+
+// pinRootPages pins the root page of cdb_schema and the root page of each
+// object in the page cache. Every statement starts at a root page so they are
+// kept from being evicted by large scans.
+func (kv *KV) pinRootPages(objects []catalog.Object) {
+	pageNumbers := []int{1}
+	for _, o := range objects {
+		pageNumbers = append(pageNumbers, o.RootPageNumber)
+	}
+	kv.pager.PinPages(pageNumbers)
 }
 
 // nextBehavior is the state of GotoNext in relation to DeleteCurrent
@@ -126,11 +474,18 @@ type Cursor struct {
 	currentPage *pager.Page
 	// pager is the cursors pager
 	pager *pager.Pager
+	// snapshot is the snapshot the cursor reads. snapshot is nil when the
+	// cursor reads and writes the pages of the write transaction.
+	snapshot *pager.Snapshot
 	// nextBehavior is the state of GotoNext behavior for the cursor
 	nextBehavior nextBehavior
+	// rowCache remembers point lookups for the current transaction.
+	rowCache *rowCache
 }
 
-// NewCursor creates a cursor with the given object's rootPageNumber.
+// NewCursor creates a cursor with the given object's rootPageNumber. The cursor
+// reads and writes the pages of the write transaction in progress. A read
+// transaction must use Snapshot.NewCursor instead.
 func (kv *KV) NewCursor(rootPageNumber int) *Cursor {
 	if rootPageNumber == 0 {
 		panic("root page cannot be 0")
@@ -138,77 +493,180 @@ func (kv *KV) NewCursor(rootPageNumber int) *Cursor {
 	return &Cursor{
 		rootPageNumber: rootPageNumber,
 		pager:          kv.pager,
+		rowCache:       kv.rowCache,
+	}
+}
+
+// getPage returns the page with pageNumber from the snapshot of the cursor or,
+// when the cursor has no snapshot, the pager.
+func (c *Cursor) getPage(pageNumber int) *pager.Page {
+	if c.snapshot != nil {
+		return c.snapshot.GetPage(pageNumber)
+	}
+	return c.pager.GetPage(pageNumber)
+}
+
+// Err returns the first pager.CorruptionError found by the transaction of the
+// cursor. The cursor reads a corrupt page as an empty page.
+func (c *Cursor) Err() error {
+	if c.snapshot != nil {
+		return c.snapshot.Err()
 	}
+	return c.pager.WriteErr()
 }
 
 // getCurrentEntriesIndex gets the index of the currentKey within the pages
 // current entries. Note a special value of -1 is returned in the rare case
 // the current key doesn't exist.
 func (c *Cursor) getCurrentEntriesIndex() int {
-	return slices.IndexFunc(
-		c.currentPage.GetEntries(),
-		func(t pager.PageTuple) bool {
-			return bytes.Equal(t.Key, c.currentTupleKey)
-		},
-	)
+	i, found := c.currentPage.Search(c.currentTupleKey)
+	if !found {
+		return -1
+	}
+	return i
 }
 
 // GotoFirstRecord moves the cursor to the first tuple in ascending order. It
 // returns true if the table has values. It returns false if the table is empty.
 func (c *Cursor) GotoFirstRecord() bool {
-	candidatePage := c.pager.GetPage(c.rootPageNumber)
-	if len(candidatePage.GetEntries()) == 0 {
+	candidatePage := c.getPage(c.rootPageNumber)
+	if candidatePage.GetRecordCount() == 0 {
 		return false
 	}
 	for !candidatePage.IsLeaf() {
-		pagePointers := candidatePage.GetEntries()
-		ascendingPageNum := pagePointers[0].Value
+		ascendingPageNum := candidatePage.GetEntryView(0).Value
 		ascendingPageNum32 := binary.LittleEndian.Uint32(ascendingPageNum)
-		candidatePage = c.pager.GetPage(int(ascendingPageNum32))
+		candidatePage = c.getPage(int(ascendingPageNum32))
 	}
-	c.moveToPage(candidatePage)
+	if !c.moveToNonEmptyPage(candidatePage) {
+		return false
+	}
+	c.readAhead()
 	return true
 }
 
+// readAhead has the snapshot of the cursor load the leaves right of the current
+// page in the background while a scan moving right works on the current page.
+func (c *Cursor) readAhead() {
+	if c.snapshot == nil {
+		return
+	}
+	if hasRight, rpn := c.currentPage.GetRightPageNumber(); hasRight {
+		c.snapshot.ReadAhead(rpn)
+	}
+}
+
 // GotoLastRecord moves the cursor to the last tuple in the last page
 // (descending ordering). It returns true if the table has values otherwise
 // false.
 func (c *Cursor) GotoLastRecord() bool {
-	candidatePage := c.pager.GetPage(c.rootPageNumber)
-	if len(candidatePage.GetEntries()) == 0 {
+	candidatePage := c.getPage(c.rootPageNumber)
+	if candidatePage.GetRecordCount() == 0 {
 		return false
 	}
 	for !candidatePage.IsLeaf() {
-		pagePointers := candidatePage.GetEntries()
-		descendingPageNum := pagePointers[len(pagePointers)-1].Value
+		descendingPageNum := candidatePage.GetEntryView(candidatePage.GetRecordCount() - 1).Value
 		descendingPageNum32 := binary.LittleEndian.Uint32(descendingPageNum)
-		candidatePage = c.pager.GetPage(int(descendingPageNum32))
+		candidatePage = c.getPage(int(descendingPageNum32))
 	}
-	c.moveToPage(candidatePage)
-	entries := c.currentPage.GetEntries()
-	c.currentTupleKey = entries[len(entries)-1].Key
-	return true
+	return c.moveToNonEmptyLeftPage(candidatePage)
 }
 
+// GotoKey moves the cursor to the tuple with key. It returns true if the key
+// exists. It returns false and leaves the cursor in place if the key does not
+// exist.
 func (c *Cursor) GotoKey(key []byte) bool {
-	candidatePage := c.pager.GetPage(c.rootPageNumber)
-	for !candidatePage.IsLeaf() {
-		v, exists := candidatePage.GetValue(key)
-		if !exists {
-			return false
+	leafPage, found := c.lookup(key)
+	if !found {
+		return false
+	}
+	i, _ := leafPage.Search(key)
+	c.currentPage = leafPage
+	c.currentTupleKey = bytes.Clone(leafPage.GetEntryView(i).Key)
+	return true
+}
+
+// lookup returns the leaf page holding key and true if the key exists. The
+// outcome is remembered in the row cache so looking up the key again during the
+// transaction does not descend the tree.
+func (c *Cursor) lookup(key []byte) (*pager.Page, bool) {
+	if page, found, hit := c.rowCache.get(c.rootPageNumber, key); hit {
+		c.pager.GetMetrics().RowCacheHits.Inc()
+		return page, found
+	}
+	leafPage := c.getLeafPage(key)
+	if _, found := leafPage.Search(key); !found {
+		c.rowCache.add(c.rootPageNumber, key, nil)
+		return nil, false
+	}
+	c.rowCache.add(c.rootPageNumber, key, leafPage)
+	return leafPage, true
+}
+
+// SeekGE moves the cursor to the tuple with key or the tuple with the smallest
+// key greater than key. It returns false and leaves the cursor in place if
+// there is no such tuple.
+func (c *Cursor) SeekGE(key []byte) bool {
+	return c.seekForward(key, func(cmp int) bool { return cmp >= 0 })
+}
+
+// SeekGT moves the cursor to the tuple with the smallest key greater than key.
+// It returns false and leaves the cursor in place if there is no such tuple.
+func (c *Cursor) SeekGT(key []byte) bool {
+	return c.seekForward(key, func(cmp int) bool { return cmp > 0 })
+}
+
+// SeekLE moves the cursor to the tuple with key or the tuple with the largest
+// key less than key. It returns false and leaves the cursor in place if there
+// is no such tuple.
+func (c *Cursor) SeekLE(key []byte) bool {
+	return c.seekBackward(key, func(cmp int) bool { return cmp <= 0 })
+}
+
+// SeekLT moves the cursor to the tuple with the largest key less than key. It
+// returns false and leaves the cursor in place if there is no such tuple.
+func (c *Cursor) SeekLT(key []byte) bool {
+	return c.seekBackward(key, func(cmp int) bool { return cmp < 0 })
+}
+
+// seekForward moves the cursor to the first tuple where match is true for the
+// comparison of the tuple key with key. match must be false for every tuple
+// before the first match.
+func (c *Cursor) seekForward(key []byte, match func(cmp int) bool) bool {
+	leafPage := c.getLeafPage(key)
+	for i := 0; i < leafPage.GetRecordCount(); i += 1 {
+		k := leafPage.GetEntryView(i).Key
+		if match(bytes.Compare(k, key)) {
+			c.currentPage = leafPage
+			c.currentTupleKey = bytes.Clone(k)
+			return true
 		}
-		nextPageNumber := int(binary.LittleEndian.Uint32(v))
-		candidatePage = c.pager.GetPage(nextPageNumber)
 	}
-	c.moveToPage(candidatePage)
-	entries := c.currentPage.GetEntries()
-	for i, e := range entries {
-		if bytes.Equal(e.Key, key) {
-			c.currentTupleKey = entries[i].Key
+	hasRight, rpn := leafPage.GetRightPageNumber()
+	if !hasRight {
+		return false
+	}
+	return c.moveToNonEmptyPage(c.getPage(rpn))
+}
+
+// seekBackward moves the cursor to the last tuple where match is true for the
+// comparison of the tuple key with key. match must be false for every tuple
+// after the last match.
+func (c *Cursor) seekBackward(key []byte, match func(cmp int) bool) bool {
+	leafPage := c.getLeafPage(key)
+	for i := leafPage.GetRecordCount() - 1; i >= 0; i -= 1 {
+		k := leafPage.GetEntryView(i).Key
+		if match(bytes.Compare(k, key)) {
+			c.currentPage = leafPage
+			c.currentTupleKey = bytes.Clone(k)
 			return true
 		}
 	}
-	return false
+	hasLeft, lpn := leafPage.GetLeftPageNumber()
+	if !hasLeft {
+		return false
+	}
+	return c.moveToNonEmptyLeftPage(c.getPage(lpn))
 }
 
 // GetKey returns the key of the current tuple.
@@ -216,10 +674,16 @@ func (c *Cursor) GetKey() []byte {
 	return c.currentTupleKey
 }
 
-// GetValue returns the value of the current pointed to tuple
+// GetValue returns the value of the current pointed to tuple. The value is not
+// copied out of the page so it is only valid until the tree is next modified. A
+// caller that keeps the value past that must copy it.
 func (c *Cursor) GetValue() []byte {
-	v, _ := c.currentPage.GetValue(c.currentTupleKey)
-	return v
+	c.pager.GetMetrics().RowsRead.Inc()
+	i, found := c.currentPage.Search(c.currentTupleKey)
+	if !found {
+		return []byte{}
+	}
+	return c.currentPage.GetEntryView(i).Value
 }
 
 // DeleteCurrent deletes the current tuple the cursor is pointing to. This
@@ -227,7 +691,21 @@ func (c *Cursor) GetValue() []byte {
 // will be a no-op. If the next tuple is the end of the table GotoNext will also
 // be aware of this. This is all to facilitate execution plans which delete in a
 // loop.
-func (c *Cursor) DeleteCurrent() {
+//
+// A page left underfull by the delete is merged with a sibling or takes tuples
+// from a sibling so heavily deleted trees do not degrade. Pages emptied by
+// merging are returned to the freelist of the pager.
+//
+// DeleteCurrent returns an err matching pager.ErrCorrupt when the subtree
+// counts of the pages above the tuple cannot be updated. The tuple is not
+// deleted in that case.
+func (c *Cursor) DeleteCurrent() error {
+	c.rowCache.clear()
+	path := c.getPath(c.currentTupleKey)
+	if err := c.adjustPathCounts(path, c.currentTupleKey, -1); err != nil {
+		return err
+	}
+	c.pager.GetMetrics().RowsWritten.Inc()
 	newEntries := []pager.PageTuple{}
 	var nextKey []byte
 	foundNextKey := false
@@ -244,14 +722,13 @@ func (c *Cursor) DeleteCurrent() {
 		}
 	}
 	// Set the current page entries minus the deleted entry.
-	newPage := c.pager.GetPage(c.currentPage.GetNumber())
+	newPage := c.getPage(c.currentPage.GetNumber())
 	newPage.SetEntries(newEntries)
 	// Determine what the next key is and setup flag for GotoNext.
 	if !foundNextKey {
 		hasRight, rightPageNumber := c.currentPage.GetRightPageNumber()
-		if hasRight {
+		if hasRight && c.moveToNonEmptyPage(c.getPage(rightPageNumber)) {
 			c.nextBehavior = nextBehaviorNext
-			c.moveToPage(c.pager.GetPage(rightPageNumber))
 		} else {
 			c.nextBehavior = nextBehaviorEmpty
 		}
@@ -259,6 +736,118 @@ func (c *Cursor) DeleteCurrent() {
 		c.nextBehavior = nextBehaviorNext
 		c.currentTupleKey = nextKey
 	}
+	// Rebalancing may move the next tuple to another page.
+	if c.rebalance(path) && c.nextBehavior == nextBehaviorNext {
+		c.currentPage = c.getLeafPage(c.currentTupleKey)
+	}
+	return nil
+}
+
+// rebalance restores the fill of the last page of path after a tuple was
+// removed from it. path is the pages from the root to the page. An underfull
+// page is merged with a sibling when their tuples fit on one page. Otherwise
+// tuples are moved from the sibling so each holds about half. A merge removes a
+// pointer from the parent so the parent is rebalanced in turn. It returns true
+// when tuples moved between pages.
+func (c *Cursor) rebalance(path []*pager.Page) bool {
+	page := path[len(path)-1]
+	if len(path) == 1 {
+		return c.collapseRoot(page)
+	}
+	if !page.IsUnderfull() {
+		return false
+	}
+	parent := path[len(path)-2]
+	entries := parent.GetEntries()
+	i := slices.IndexFunc(entries, func(e pager.PageTuple) bool {
+		return pointerPageNumber(e.Value) == page.GetNumber()
+	})
+	if i == -1 || len(entries) < 2 {
+		return false
+	}
+	// The sibling is the page to the left unless page is the first child.
+	if i > 0 {
+		i -= 1
+	}
+	left := c.getPage(pointerPageNumber(entries[i].Value))
+	right := c.getPage(pointerPageNumber(entries[i+1].Value))
+	leftEntries := left.GetEntries()
+	combined := append(leftEntries, right.GetEntries()...)
+	if pager.TuplesFit(combined) {
+		c.mergePages(parent, entries, i, left, right, combined)
+		c.rebalance(path[:len(path)-1])
+		return true
+	}
+	return c.redistribute(parent, entries, i, left, right, combined, len(leftEntries))
+}
+
+// mergePages moves the combined tuples of left and right onto left and frees
+// right. left and right are the children of parent at i and i+1 of entries.
+func (c *Cursor) mergePages(parent *pager.Page, entries []pager.PageTuple, i int, left, right *pager.Page, combined []pager.PageTuple) {
+	left.SetEntries(combined)
+	if !left.IsLeaf() {
+		for _, e := range right.GetEntries() {
+			c.getPage(pointerPageNumber(e.Value)).SetParentPageNumber(left.GetNumber())
+		}
+	}
+	_, rightRightPageNumber := right.GetRightPageNumber()
+	left.SetRightPageNumber(rightRightPageNumber)
+	if rightRightPageNumber != 0 {
+		c.getPage(rightRightPageNumber).SetLeftPageNumber(left.GetNumber())
+	}
+	entries[i].Value = c.pointerTo(left)
+	parent.SetEntries(slices.Delete(entries, i+1, i+2))
+	c.pager.FreePage(right.GetNumber())
+}
+
+// redistribute divides the combined tuples of left and right evenly between
+// them. left and right are the children of parent at i and i+1 of entries and
+// left held leftCount of the tuples. The pointer key of right changes to its new
+// first key so nothing is moved when the parent cannot fit the new key. It
+// returns true when tuples moved.
+func (c *Cursor) redistribute(parent *pager.Page, entries []pager.PageTuple, i int, left, right *pager.Page, combined []pager.PageTuple, leftCount int) bool {
+	splitAt, err := splitPoint(combined, false)
+	if err != nil || splitAt == leftCount {
+		return false
+	}
+	entries[i+1].Key = combined[splitAt].Key
+	if !pager.TuplesFit(entries) {
+		return false
+	}
+	left.SetEntries(combined[:splitAt])
+	right.SetEntries(combined[splitAt:])
+	// The children of an internal page need to point to the page they were
+	// moved to.
+	if !left.IsLeaf() {
+		if splitAt > leftCount {
+			c.setChildParents(left)
+		} else {
+			c.setChildParents(right)
+		}
+	}
+	entries[i].Value = c.pointerTo(left)
+	entries[i+1].Value = c.pointerTo(right)
+	parent.SetEntries(entries)
+	return true
+}
+
+// collapseRoot moves the tuples of the only child of an internal root onto the
+// root and frees the child so the tree is no deeper than it needs to be. The
+// root keeps its page number so the catalog does not change. It returns true
+// when the root changed.
+func (c *Cursor) collapseRoot(root *pager.Page) bool {
+	collapsed := false
+	for !root.IsLeaf() && root.GetRecordCount() == 1 {
+		child := c.getPage(pointerPageNumber(root.GetEntryView(0).Value))
+		root.SetEntries(child.GetEntries())
+		root.SetType(child.GetType())
+		if !root.IsLeaf() {
+			c.setChildParents(root)
+		}
+		c.pager.FreePage(child.GetNumber())
+		collapsed = true
+	}
+	return collapsed
 }
 
 // GotoNext moves the cursor to the next tuple in ascending order. If there is
@@ -277,16 +866,15 @@ func (c *Cursor) GotoNext() bool {
 		return true
 	case nextBehaviorNormal:
 		currentIndex := c.getCurrentEntriesIndex()
-		if currentIndex+1 <= len(c.currentPage.GetEntries())-1 {
-			c.currentTupleKey = c.currentPage.GetEntries()[currentIndex+1].Key
+		if currentIndex+1 < c.currentPage.GetRecordCount() {
+			c.currentTupleKey = bytes.Clone(c.currentPage.GetEntryView(currentIndex + 1).Key)
 			return true
 		}
 		if hasRight, rpn := c.currentPage.GetRightPageNumber(); hasRight {
-			candidatePage := c.pager.GetPage(rpn)
-			if len(candidatePage.GetEntries()) == 0 {
+			if !c.moveToNonEmptyPage(c.getPage(rpn)) {
 				return false
 			}
-			c.moveToPage(candidatePage)
+			c.readAhead()
 			return true
 		}
 		return false
@@ -295,81 +883,123 @@ func (c *Cursor) GotoNext() bool {
 	}
 }
 
-// gotoNextPage advances the cursor to the next page and returns true. If there
-// is no next page it will not advance and will return false
-func (c *Cursor) gotoNextPage() bool {
-	hasRight, rightPageNumber := c.currentPage.GetRightPageNumber()
-	if !hasRight {
+// GotoPrev moves the cursor to the previous tuple in ascending order. If there
+// is no previous tuple this function will return false and leave the cursor in
+// place otherwise it will return true.
+//
+// After DeleteCurrent the previous tuple is the tuple before the deleted tuple.
+func (c *Cursor) GotoPrev() bool {
+	if c.nextBehavior != nextBehaviorNormal {
+		// The page of the cursor may have been merged away by DeleteCurrent.
+		c.nextBehavior = nextBehaviorNormal
+		c.currentPage = c.getLeafPage(c.currentTupleKey)
+	}
+	// i is the position of the first tuple not less than the current key. The
+	// current key is not on the page when it was deleted.
+	i := sort.Search(c.currentPage.GetRecordCount(), func(i int) bool {
+		return bytes.Compare(c.currentPage.GetEntryView(i).Key, c.currentTupleKey) >= 0
+	})
+	if i > 0 {
+		c.currentTupleKey = bytes.Clone(c.currentPage.GetEntryView(i - 1).Key)
+		return true
+	}
+	return c.GotoPrevPage()
+}
+
+// GotoPrevPage moves the cursor to the last tuple of the nearest non empty page
+// to the left of the current page. It returns false and leaves the cursor in
+// place if there is no such page.
+func (c *Cursor) GotoPrevPage() bool {
+	hasLeft, lpn := c.currentPage.GetLeftPageNumber()
+	if !hasLeft {
 		return false
 	}
-	np := c.pager.GetPage(rightPageNumber)
-	c.moveToPage(np)
-	return true
+	return c.moveToNonEmptyLeftPage(c.getPage(lpn))
 }
 
 func (c *Cursor) moveToPage(p *pager.Page) {
-	c.currentTupleKey = p.GetEntries()[0].Key
+	c.currentTupleKey = bytes.Clone(p.GetEntryView(0).Key)
 	c.currentPage = p
 }
 
+// moveToNonEmptyPage moves the cursor to the first tuple of p or the first
+// tuple of the nearest non empty page to the right of p. Leaves are left empty
+// when all of their tuples are deleted. It returns false and leaves the cursor
+// in place if there is no such page.
+func (c *Cursor) moveToNonEmptyPage(p *pager.Page) bool {
+	for p.GetRecordCount() == 0 {
+		hasRight, rpn := p.GetRightPageNumber()
+		if !hasRight {
+			return false
+		}
+		p = c.getPage(rpn)
+	}
+	c.moveToPage(p)
+	return true
+}
+
+// moveToNonEmptyLeftPage moves the cursor to the last tuple of p or the last
+// tuple of the nearest non empty page to the left of p. It returns false and
+// leaves the cursor in place if there is no such page.
+func (c *Cursor) moveToNonEmptyLeftPage(p *pager.Page) bool {
+	for p.GetRecordCount() == 0 {
+		hasLeft, lpn := p.GetLeftPageNumber()
+		if !hasLeft {
+			return false
+		}
+		p = c.getPage(lpn)
+	}
+	c.currentTupleKey = bytes.Clone(p.GetEntryView(p.GetRecordCount() - 1).Key)
+	c.currentPage = p
+	return true
+}
+
 // Count returns the count of the current b trees leaf node entries.
 //
-// Count does this not by scanning each individual tuple, but scanning each page
-// and summing the computed counter on the page.
+// Count does this not by scanning each individual tuple, but by summing the
+// subtree counts stored alongside each page pointer on the root page. When the
+// root page is a leaf the record count of the page is used instead.
 func (c *Cursor) Count() int {
-	hasValues := c.GotoFirstRecord()
-	sum := 0
-	if !hasValues {
-		return sum
-	}
-	sum += len(c.currentPage.GetEntries())
-	for c.gotoNextPage() {
-		sum += len(c.currentPage.GetEntries())
-	}
-	return sum
+	return c.subtreeCount(c.getPage(c.rootPageNumber))
 }
 
 // Exists will probe the specified key and return true or false if the key
 // exists or not.
 func (c *Cursor) Exists(key []byte) bool {
-	pageNumber := c.rootPageNumber
-	for {
-		page := c.pager.GetPage(pageNumber)
-		v, found := page.GetValue(key)
-		if page.IsLeaf() {
-			return found
-		}
-		if !found {
-			return false
-		}
-		pageNumber = int(binary.LittleEndian.Uint32(v))
-	}
+	_, found := c.lookup(key)
+	return found
 }
 
 // NewRowID returns the highest unused key in a table for the rootPageNumber.
-// For a integer key it is the largest integer key plus one.
-func (c *Cursor) NewRowID() int {
+// For a integer key it is the largest integer key plus one. ErrNonIntegerKey is
+// returned if the largest key is not an integer.
+func (c *Cursor) NewRowID() (int, error) {
 	// TODO could possibly cache this in the catalog or on the cursor
-	candidate := c.pager.GetPage(c.rootPageNumber)
+	candidate := c.getPage(c.rootPageNumber)
 	if len(candidate.GetEntries()) == 0 {
-		return 1
+		return 1, nil
 	}
 	for !candidate.IsLeaf() {
 		pagePointers := candidate.GetEntries()
 		descendingPageNum := pagePointers[len(pagePointers)-1].Value
 		descendingPageNum32 := binary.LittleEndian.Uint32(descendingPageNum)
-		candidate = c.pager.GetPage(int(descendingPageNum32))
+		candidate = c.getPage(int(descendingPageNum32))
 	}
-	k := candidate.GetEntries()[len(candidate.GetEntries())-1].Key
-	dk, err := DecodeKey(k)
-	if err != nil {
-		log.Fatal(err)
+	// Leaves are left empty when all of their tuples are deleted so the last
+	// tuple may be on a page to the left.
+	for candidate.GetRecordCount() == 0 {
+		hasLeft, lpn := candidate.GetLeftPageNumber()
+		if !hasLeft {
+			return 1, nil
+		}
+		candidate = c.getPage(lpn)
 	}
-	dki, ok := dk.(int)
-	if !ok {
-		log.Fatal("non integer key increment not supported")
+	k := candidate.GetEntry(candidate.GetRecordCount() - 1).Key
+	id, err := DecodeRowID(k)
+	if err != nil {
+		return 0, err
 	}
-	return dki + 1
+	return id + 1, nil
 }
 
 // Get returns a byte array corresponding to the key and a bool indicating if
@@ -377,116 +1007,216 @@ func (c *Cursor) NewRowID() int {
 // corresponding table. The system catalog uses the page number 1.
 func (c *Cursor) Get(key []byte) ([]byte, bool) {
 	// TODO improve interface to move the cursor instead of a one time point
-	pageNumber := c.rootPageNumber
-	for {
-		page := c.pager.GetPage(pageNumber)
-		v, found := page.GetValue(key)
-		if page.IsLeaf() {
-			return v, found
-		}
-		if !found {
-			return nil, false
-		}
-		pageNumber = int(binary.LittleEndian.Uint32(v))
+	leafPage, found := c.lookup(key)
+	if !found {
+		return []byte{}, false
 	}
+	v, _ := leafPage.GetValue(key)
+	c.pager.GetMetrics().RowsRead.Inc()
+	return v, true
 }
 
 // Set inserts or updates the value for the given key. The pageNumber has to do
 // with the root page of the corresponding table. The system catalog uses the
 // page number 1.
-func (c *Cursor) Set(key, value []byte) {
-	// Find leaf page with key as the search param.
-	leafPage := c.getLeafPage(c.rootPageNumber, key)
+//
+// Set returns ErrTupleTooLarge when the tuple cannot fit on a page. In case an
+// error is returned after the tree has been modified the write transaction
+// must be rolled back.
+func (c *Cursor) Set(key, value []byte) error {
+	c.rowCache.clear()
+	tuple := pager.PageTuple{Key: key, Value: value}
+	if !pager.TuplesFit([]pager.PageTuple{tuple}) {
+		return ErrTupleTooLarge
+	}
+	// Find the path of pages from the root to the leaf page with key as the
+	// search param.
+	path := c.getPath(key)
+	leafPage := path[len(path)-1]
+	_, exists := leafPage.GetValue(key)
 	// If the leaf page can hold the new tuple be done.
 	if leafPage.CanInsertTuple(key, value) {
+		if !exists {
+			if err := c.adjustPathCounts(path, key, 1); err != nil {
+				return err
+			}
+		}
 		leafPage.SetValue(key, value)
-		return
+		c.pager.GetMetrics().RowsWritten.Inc()
+		return nil
 	}
+	// The old value is removed so it is not carried into the split when the
+	// key is being updated.
+	entries := slices.DeleteFunc(leafPage.GetEntries(), func(t pager.PageTuple) bool {
+		return bytes.Equal(t.Key, key)
+	})
+	entries = insertSorted(entries, tuple)
+	// The split is checked before anything is modified so a tuple that cannot
+	// be split onto two pages leaves the tree untouched.
+	splitAt, err := splitPoint(entries, isAppend(leafPage, key))
+	if err != nil {
+		return err
+	}
+	// A new key means every subtree on the path grows by one record. Updating
+	// the counts before the split means the split only has to recompute the
+	// counts of the pages it creates.
+	if !exists {
+		if err := c.adjustPathCounts(path, key, 1); err != nil {
+			return err
+		}
+	}
+	c.pager.GetMetrics().RowsWritten.Inc()
 	// Split page when the leaf cannot hold the tuple.
-	leftPage, rightPage := c.splitPage(leafPage)
-	// Find which page out of the split can best hold the tuple.
-	c.insertIntoOne(key, value, leftPage, rightPage)
-	// Having a parent means the parent must have the new pages inserted.
-	hasParent, parentPageNumber := leafPage.GetParentPageNumber()
-	if hasParent {
-		leftPage.SetParentPageNumber(parentPageNumber)
-		rightPage.SetParentPageNumber(parentPageNumber)
-		parentPage := c.pager.GetPage(parentPageNumber)
-		c.parentInsert(parentPage, leftPage, rightPage)
-		return
+	leftPage, rightPage := c.splitPage(leafPage, entries, splitAt, len(path) == 1)
+	return c.insertSplit(path[:len(path)-1], leafPage, leftPage, rightPage)
+}
+
+// insertSplit links the left and right pages resulting from splitting page into
+// the tree. ancestors are the pages above page ordered from the root. Having a
+// parent means the parent must have the new pages inserted.
+func (c *Cursor) insertSplit(ancestors []*pager.Page, page, leftPage, rightPage *pager.Page) error {
+	if len(ancestors) != 0 {
+		parentPage := ancestors[len(ancestors)-1]
+		leftPage.SetParentPageNumber(parentPage.GetNumber())
+		rightPage.SetParentPageNumber(parentPage.GetNumber())
+		return c.parentInsert(ancestors, leftPage, rightPage)
 	}
 	// Falling through to here means there is no parent of the split so the root
 	// node has split. This is a special optimization to keep the root page
-	// number the same.
-	leafPage.SetTypeInternal()
-	leafPage.SetEntries([]pager.PageTuple{
+	// number the same so the table catalog doesn't need to be updated every
+	// time a root node splits.
+	page.SetTypeInternal()
+	page.SetEntries([]pager.PageTuple{
 		{
 			Key:   leftPage.GetEntries()[0].Key,
-			Value: leftPage.GetNumberAsBytes(),
+			Value: c.pointerTo(leftPage),
 		},
 		{
 			Key:   rightPage.GetEntries()[0].Key,
-			Value: rightPage.GetNumberAsBytes(),
+			Value: c.pointerTo(rightPage),
 		},
 	})
-	leftPage.SetParentPageNumber(leafPage.GetNumber())
-	rightPage.SetParentPageNumber(leafPage.GetNumber())
-}
-
-// insertIntoOne is a helper function to insert into a left or right page given
-// the left and right pages have space and the right page is greater than the
-// left.
-func (c *Cursor) insertIntoOne(key, value []byte, lp, rp *pager.Page) {
-	rpk := rp.GetEntries()[0].Key
-	comp := bytes.Compare(key, rpk)
-	if comp == 0 { // key == rpk
-		rp.SetEntries(append(rp.GetEntries(), pager.PageTuple{Key: key, Value: value}))
-		return
-	}
-	if comp == -1 { // key < rpk
-		lp.SetEntries(append(lp.GetEntries(), pager.PageTuple{Key: key, Value: value}))
-		return
-	}
-	// key > rpk
-	rp.SetEntries(append(rp.GetEntries(), pager.PageTuple{Key: key, Value: value}))
+	leftPage.SetParentPageNumber(page.GetNumber())
+	rightPage.SetParentPageNumber(page.GetNumber())
+	return nil
+}
+
+// insertSorted inserts tuple into the sorted entries keeping them sorted.
+func insertSorted(entries []pager.PageTuple, tuple pager.PageTuple) []pager.PageTuple {
+	i, _ := slices.BinarySearchFunc(entries, tuple.Key, func(e pager.PageTuple, k []byte) int {
+		return bytes.Compare(e.Key, k)
+	})
+	return slices.Insert(entries, i, tuple)
+}
+
+// getLeafPage returns the leaf page key belongs in. Every lookup descends the
+// tree the same way so a key is always searched for in the page it would be
+// inserted into.
+func (c *Cursor) getLeafPage(key []byte) *pager.Page {
+	path := c.getPath(key)
+	return path[len(path)-1]
 }
 
-func (c *Cursor) getLeafPage(nextPageNumber int, key []byte) *pager.Page {
-	p := c.pager.GetPage(nextPageNumber)
+// getPath returns the pages visited while searching for key starting with the
+// root page and ending with the leaf page.
+func (c *Cursor) getPath(key []byte) []*pager.Page {
+	p := c.getPage(c.rootPageNumber)
+	path := []*pager.Page{p}
 	for !p.IsLeaf() {
 		nextPage, found := p.GetValue(key)
 		if !found {
-			return nil
+			return path
+		}
+		p = c.getPage(pointerPageNumber(nextPage))
+		path = append(path, p)
+	}
+	return path
+}
+
+// isAppend returns true when key belongs after every tuple in the right most
+// page of a level. Monotonically increasing keys such as row ids always insert
+// this way.
+func isAppend(page *pager.Page, key []byte) bool {
+	if hasRight, _ := page.GetRightPageNumber(); hasRight {
+		return false
+	}
+	recordCount := page.GetRecordCount()
+	if recordCount == 0 {
+		return false
+	}
+	return bytes.Compare(key, page.GetEntry(recordCount-1).Key) == 1
+}
+
+// splitPoint returns the position to divide the sorted entries into a left and
+// right page. The entries are divided so each page holds close to the same
+// amount of bytes. Dividing by the count of entries would mean one large tuple
+// among many small tuples could leave one page overfull.
+//
+// When appending the left page keeps every entry except the appended tuple.
+// Splitting evenly would leave every left page half empty for append only
+// workloads since the left page never receives another tuple.
+//
+// ErrTupleTooLarge is returned when either side of the split would not fit on a
+// page.
+func splitPoint(entries []pager.PageTuple, appending bool) (int, error) {
+	splitAt := len(entries) - 1
+	if !appending {
+		total := 0
+		for _, e := range entries {
+			total += e.Size()
+		}
+		half := total / 2
+		leftSize := 0
+		for i, e := range entries {
+			if leftSize+e.Size() > half {
+				// Take the entry when it lands the left page closer to half.
+				splitAt = i
+				if leftSize+e.Size()-half < half-leftSize {
+					splitAt = i + 1
+				}
+				break
+			}
+			leftSize += e.Size()
 		}
-		nextPageNumber32 := binary.LittleEndian.Uint32(nextPage)
-		p = c.pager.GetPage(int(nextPageNumber32))
 	}
-	return p
+	splitAt = max(1, min(splitAt, len(entries)-1))
+	if !pager.TuplesFit(entries[:splitAt]) || !pager.TuplesFit(entries[splitAt:]) {
+		return 0, ErrTupleTooLarge
+	}
+	return splitAt, nil
 }
 
-func (c *Cursor) splitPage(page *pager.Page) (left, right *pager.Page) {
-	hasParent, _ := page.GetParentPageNumber()
+// splitPage divides entries between a left and right page at splitAt. isRoot
+// is determined by the descent path rather than the parent page number of page.
+func (c *Cursor) splitPage(page *pager.Page, entries []pager.PageTuple, splitAt int, isRoot bool) (left, right *pager.Page) {
 	_, parentLeftPageNumber := page.GetLeftPageNumber()
 	_, parentRightPageNumber := page.GetRightPageNumber()
 	parentType := page.GetType()
-	entries := page.GetEntries()
 	// If it is splitting the root page should make two new nodes so the
 	// root can keep the same page number. Otherwise will only need to split
 	// into one new node and also use the existing node.
 	leftPage := page
-	if !hasParent {
+	if isRoot {
 		leftPage = c.pager.NewPage()
 	}
-	leftEntries := entries[:len(entries)/2]
+	leftEntries := entries[:splitAt]
 	leftPage.SetEntries(leftEntries)
 	leftPage.SetType(parentType)
 	rightPage := c.pager.NewPage()
-	rightEntries := entries[len(entries)/2:]
+	rightEntries := entries[splitAt:]
 	rightPage.SetEntries(rightEntries)
 	rightPage.SetType(parentType)
+	// The children of an internal page need to point to the page they were
+	// moved to. The left page only needs this when it is a new page.
+	if !page.IsLeaf() {
+		if isRoot {
+			c.setChildParents(leftPage)
+		}
+		c.setChildParents(rightPage)
+	}
 	// Set relative left page's right page
 	if parentLeftPageNumber != 0 {
-		c.pager.GetPage(parentLeftPageNumber).SetRightPageNumber(leftPage.GetNumber())
+		c.getPage(parentLeftPageNumber).SetRightPageNumber(leftPage.GetNumber())
 	}
 	// Set split left's left and right
 	leftPage.SetLeftPageNumber(parentLeftPageNumber)
@@ -496,60 +1226,132 @@ func (c *Cursor) splitPage(page *pager.Page) (left, right *pager.Page) {
 	rightPage.SetRightPageNumber(parentRightPageNumber)
 	// Set relative right page's left page
 	if parentRightPageNumber != 0 {
-		c.pager.GetPage(parentRightPageNumber).SetLeftPageNumber(rightPage.GetNumber())
+		c.getPage(parentRightPageNumber).SetLeftPageNumber(rightPage.GetNumber())
 	}
 	return leftPage, rightPage
 }
 
+// setChildParents sets the parent page number of each child of the internal
+// page to the page.
+func (c *Cursor) setChildParents(page *pager.Page) {
+	for _, e := range page.GetEntries() {
+		c.getPage(pointerPageNumber(e.Value)).SetParentPageNumber(page.GetNumber())
+	}
+}
+
 // parentInsert is new left and right pointers needing to be inserted into the
-// parent. This means the parent may need to be split and inserted into its
-// parent and so on.
-func (c *Cursor) parentInsert(p, l, r *pager.Page) {
-	// k1/v1 and k2/v2 are the new page pointers. These will go in the parent
-	// node.
-	k1 := l.GetEntries()[0].Key
-	v1 := l.GetNumberAsBytes()
+// parent. The parent is the last of the ancestors. This means the parent may
+// need to be split and inserted into its parent and so on.
+func (c *Cursor) parentInsert(ancestors []*pager.Page, l, r *pager.Page) error {
+	p := ancestors[len(ancestors)-1]
+	// The left page already has a pointer in the parent since it is the page
+	// that was split. The pointer only needs to be refreshed.
+	entries := c.pointerEntries(p, l)
+	// k2/v2 is the new page pointer. This will go in the parent node.
 	k2 := r.GetEntries()[0].Key
-	v2 := r.GetNumberAsBytes()
-	tuples := []pager.PageTuple{{Key: k1, Value: v1}, {Key: k2, Value: v2}}
-	// If the parent is able to insert the page pointers we are done.
-	if p.CanInsertTuples(tuples) {
-		p.SetValue(k1, v1)
-		p.SetValue(k2, v2)
-		l.SetParentPageNumber(p.GetNumber())
-		r.SetParentPageNumber(p.GetNumber())
-		return
+	v2 := c.pointerTo(r)
+	entries = insertSorted(entries, pager.PageTuple{Key: k2, Value: v2})
+	// If the parent is able to hold the page pointers we are done.
+	if pager.TuplesFit(entries) {
+		p.SetEntries(entries)
+		return nil
 	}
-	// This case is the parent needing to be split. We then check if the parents
-	// parent is there or not. In case it is there we can make a recursive call.
-	// In case it is not we fall through.
-	leftPage, rightPage := c.splitPage(p)
-	c.insertIntoOne(k1, v1, leftPage, rightPage)
-	c.insertIntoOne(k2, v2, leftPage, rightPage)
-	hasParent, parentPageNumber := p.GetParentPageNumber()
-	if hasParent {
-		leftPage.SetParentPageNumber(parentPageNumber)
-		rightPage.SetParentPageNumber(parentPageNumber)
-		l.SetParentPageNumber(parentPageNumber)
-		r.SetParentPageNumber(parentPageNumber)
-		parentParent := c.pager.GetPage(parentPageNumber)
-		c.parentInsert(parentParent, leftPage, rightPage)
-		return
+	// This case is the parent needing to be split. insertSplit will check if
+	// the parents parent is there or not and make a recursive call if it is.
+	splitAt, err := splitPoint(entries, isAppend(p, k2))
+	if err != nil {
+		return err
 	}
-	// The root node needs to be split. It is wise to keep the root node the
-	// same page number so the table catalog doesn't need to be updated every
-	// time a root node splits.
-	p.SetTypeInternal()
-	p.SetEntries([]pager.PageTuple{
-		{
-			Key:   leftPage.GetEntries()[0].Key,
-			Value: leftPage.GetNumberAsBytes(),
-		},
-		{
-			Key:   rightPage.GetEntries()[0].Key,
-			Value: rightPage.GetNumberAsBytes(),
-		},
-	})
-	leftPage.SetParentPageNumber(p.GetNumber())
-	rightPage.SetParentPageNumber(p.GetNumber())
+	leftPage, rightPage := c.splitPage(p, entries, splitAt, len(ancestors) == 1)
+	return c.insertSplit(ancestors[:len(ancestors)-1], p, leftPage, rightPage)
+}
+
+// pointerEntries returns the entries of parent with the pointer to child
+// updated so the subtree count is current. If the parent does not have a
+// pointer to child one is added.
+//
+// The first child of a parent may hold keys lower than its pointer key since
+// searching sends keys lower than the first key to the first child. The pointer
+// key is lowered to the first key of child so the pointer of a page split from
+// child cannot sort before the pointer to child.
+func (c *Cursor) pointerEntries(parent, child *pager.Page) []pager.PageTuple {
+	entries := parent.GetEntries()
+	firstKey := child.GetEntries()[0].Key
+	for i, e := range entries {
+		if pointerPageNumber(e.Value) == child.GetNumber() {
+			entries[i].Value = c.pointerTo(child)
+			if bytes.Compare(firstKey, e.Key) < 0 {
+				entries[i].Key = firstKey
+			}
+			return entries
+		}
+	}
+	return insertSorted(entries, pager.PageTuple{Key: firstKey, Value: c.pointerTo(child)})
+}
+
+// adjustPathCounts adds delta to the subtree count of each pointer linking the
+// pages in path. The pointers are found by searching with key since key is the
+// key used to find the path. A pointer that cannot be found or rewritten in
+// place means the page is corrupt and a CorruptionError is returned so the
+// counts are not left stale.
+func (c *Cursor) adjustPathCounts(path []*pager.Page, key []byte, delta int) error {
+	for i := 0; i < len(path)-1; i += 1 {
+		idx, found := path[i].Search(key)
+		if !found {
+			return &pager.CorruptionError{PageNumber: path[i].GetNumber(), Reason: "has no pointers"}
+		}
+		v := path[i].GetEntry(idx).Value
+		if !path[i].SetEntryValue(idx, encodePointer(pointerPageNumber(v), pointerCount(v)+delta)) {
+			return &pager.CorruptionError{PageNumber: path[i].GetNumber(), Reason: fmt.Sprintf("has an invalid pointer for tuple %d", idx)}
+		}
+	}
+	return nil
+}
+
+// subtreeCount returns the number of leaf entries reachable from page.
+func (c *Cursor) subtreeCount(page *pager.Page) int {
+	if page.IsLeaf() {
+		return page.GetRecordCount()
+	}
+	sum := 0
+	for _, e := range page.GetEntries() {
+		sum += pointerCount(e.Value)
+	}
+	return sum
+}
+
+// pointerTo returns the value of an internal page entry pointing to page.
+func (c *Cursor) pointerTo(page *pager.Page) []byte {
+	return encodePointer(page.GetNumber(), c.subtreeCount(page))
+}
+
+// Internal page entries have a value that is a pointer to a child page. The
+// pointer is formatted as follows:
+//   - 4 bytes for the child page number.
+//   - 4 bytes for the count of leaf entries in the child page's subtree.
+const (
+	pointerPageNumberSize = 4
+	pointerCountSize      = 4
+)
+
+// encodePointer makes the value of an internal page entry.
+func encodePointer(pageNumber, count int) []byte {
+	b := make([]byte, pointerPageNumberSize+pointerCountSize)
+	binary.LittleEndian.PutUint32(b, uint32(pageNumber))
+	binary.LittleEndian.PutUint32(b[pointerPageNumberSize:], uint32(count))
+	return b
+}
+
+// pointerPageNumber returns the page number of an internal page entry value.
+func pointerPageNumber(v []byte) int {
+	return int(binary.LittleEndian.Uint32(v))
+}
+
+// pointerCount returns the subtree count of an internal page entry value.
+// Pointers without a count are counted as 0.
+func pointerCount(v []byte) int {
+	if len(v) < pointerPageNumberSize+pointerCountSize {
+		return 0
+	}
+	return int(binary.LittleEndian.Uint32(v[pointerPageNumberSize:]))
 }
@@ -2,8 +2,14 @@ package kv
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"math/rand"
 	"testing"
+
+	"github.com/chirst/cdb/pager"
 )
 
 func mustNewKv() *KV {
@@ -45,7 +51,7 @@ func TestSetPageSplit(t *testing.T) {
 	// not including the header of each page.
 	iters := 4096 / 8
 	for i := 1; i <= iters; i += 1 {
-		kv.BeginWriteTransaction()
+		kv.BeginWriteTransaction(context.Background())
 		k, err := EncodeKey(i)
 		if err != nil {
 			t.Fatal(err)
@@ -72,7 +78,7 @@ func TestBulkInsertAndGet(t *testing.T) {
 
 	// bulk insert
 	amount := 500_000
-	kv.BeginWriteTransaction()
+	kv.BeginWriteTransaction(context.Background())
 	for i := 1; i <= amount; i += 1 {
 		k, err := EncodeKey(i)
 		if err != nil {
@@ -140,7 +146,7 @@ func TestUpdateLoop(t *testing.T) {
 
 	// Seed values 1, 2, 3.
 	c := kv.NewCursor(2)
-	kv.BeginWriteTransaction()
+	kv.BeginWriteTransaction(context.Background())
 	for i := range 3 {
 		k, err := EncodeKey(i + 1)
 		if err != nil {
@@ -156,7 +162,7 @@ func TestUpdateLoop(t *testing.T) {
 
 	// Increment values by one with the cursor.
 	c = kv.NewCursor(2)
-	kv.BeginWriteTransaction()
+	kv.BeginWriteTransaction(context.Background())
 	c.GotoFirstRecord()
 	for i := range 3 {
 		k, err := EncodeKey(i + 1)
@@ -174,8 +180,11 @@ func TestUpdateLoop(t *testing.T) {
 	kv.EndWriteTransaction()
 
 	// Check values
-	c = kv.NewCursor(2)
-	kv.BeginReadTransaction()
+	snapshot, err := kv.BeginReadTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = snapshot.NewCursor(2)
 	c.GotoFirstRecord()
 	for i := range 3 {
 		v, err := Decode(c.GetValue())
@@ -191,7 +200,7 @@ func TestUpdateLoop(t *testing.T) {
 		}
 		c.GotoNext()
 	}
-	kv.EndReadTransaction()
+	kv.EndReadTransaction(snapshot)
 }
 
 func TestUpdateLoopWithIf(t *testing.T) {
@@ -199,7 +208,7 @@ func TestUpdateLoopWithIf(t *testing.T) {
 
 	// Seed values 1, 2, 3.
 	c := kv.NewCursor(2)
-	kv.BeginWriteTransaction()
+	kv.BeginWriteTransaction(context.Background())
 	for i := range 3 {
 		k, err := EncodeKey(i + 1)
 		if err != nil {
@@ -215,7 +224,7 @@ func TestUpdateLoopWithIf(t *testing.T) {
 
 	// Increment values by one except for second value with the cursor.
 	c = kv.NewCursor(2)
-	kv.BeginWriteTransaction()
+	kv.BeginWriteTransaction(context.Background())
 	c.GotoFirstRecord()
 	for i := range 3 {
 		k, err := EncodeKey(i + 1)
@@ -235,8 +244,11 @@ func TestUpdateLoopWithIf(t *testing.T) {
 	kv.EndWriteTransaction()
 
 	// Check values
-	c = kv.NewCursor(2)
-	kv.BeginReadTransaction()
+	snapshot, err := kv.BeginReadTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	c = snapshot.NewCursor(2)
 	c.GotoFirstRecord()
 	for i := range 3 {
 		v, err := Decode(c.GetValue())
@@ -258,5 +270,1052 @@ func TestUpdateLoopWithIf(t *testing.T) {
 		}
 		c.GotoNext()
 	}
-	kv.EndReadTransaction()
+	kv.EndReadTransaction(snapshot)
+}
+
+func TestCount(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	cursor := kv.NewCursor(kv.NewBTree())
+	kv.EndWriteTransaction()
+
+	t.Run("empty", func(t *testing.T) {
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		if got := snapshot.NewCursor(cursor.rootPageNumber).Count(); got != 0 {
+			t.Fatalf("want count 0 got %d", got)
+		}
+	})
+
+	amount := 50_000
+	kv.BeginWriteTransaction(context.Background())
+	for i := 1; i <= amount; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := Encode([]any{i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor.Set(k, v)
+	}
+	kv.EndWriteTransaction()
+
+	t.Run("after insert", func(t *testing.T) {
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		if got := snapshot.NewCursor(cursor.rootPageNumber).Count(); got != amount {
+			t.Fatalf("want count %d got %d", amount, got)
+		}
+	})
+
+	t.Run("after update", func(t *testing.T) {
+		kv.BeginWriteTransaction(context.Background())
+		for i := 1; i <= 100; i += 1 {
+			k, err := EncodeKey(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := Encode([]any{"updated"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			cursor.Set(k, v)
+		}
+		kv.EndWriteTransaction()
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		if got := snapshot.NewCursor(cursor.rootPageNumber).Count(); got != amount {
+			t.Fatalf("want count %d got %d", amount, got)
+		}
+	})
+
+	t.Run("after delete", func(t *testing.T) {
+		deleted := 1_000
+		kv.BeginWriteTransaction(context.Background())
+		cursor.GotoFirstRecord()
+		for range deleted {
+			cursor.DeleteCurrent()
+			cursor.GotoNext()
+		}
+		kv.EndWriteTransaction()
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		if got := snapshot.NewCursor(cursor.rootPageNumber).Count(); got != amount-deleted {
+			t.Fatalf("want count %d got %d", amount-deleted, got)
+		}
+	})
+}
+
+func TestCorruptPointerCount(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.RollbackWrite()
+	cursor := kv.NewCursor(kv.NewBTree())
+	for i := 1; i <= 1_000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root := cursor.getPage(cursor.rootPageNumber)
+	if root.IsLeaf() {
+		t.Fatal("expected root to be internal")
+	}
+	// A pointer with trailing bytes cannot be rewritten in place.
+	entries := root.GetEntries()
+	for i := range entries {
+		entries[i].Value = append(entries[i].Value, 0, 0, 0, 0)
+	}
+	root.SetEntries(entries)
+
+	k, err := EncodeKey(1_001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.Set(k, []byte("value")); !errors.Is(err, pager.ErrCorrupt) {
+		t.Fatalf("expected set to return ErrCorrupt got %v", err)
+	}
+	cursor.GotoFirstRecord()
+	if err := cursor.DeleteCurrent(); !errors.Is(err, pager.ErrCorrupt) {
+		t.Fatalf("expected delete to return ErrCorrupt got %v", err)
+	}
+}
+
+func TestAppendSplitFillsLeftPages(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	v := []byte{1, 0, 0, 0}
+	for i := 1; i <= 10_000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor.Set(k, v)
+	}
+	nk, err := EncodeKey(10_001)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cursor.GotoFirstRecord()
+	for {
+		hasRight, rpn := cursor.currentPage.GetRightPageNumber()
+		if !hasRight {
+			break
+		}
+		if cursor.currentPage.CanInsertTuple(nk, v) {
+			t.Fatalf("expected page %d to be full", cursor.currentPage.GetNumber())
+		}
+		cursor.moveToPage(kv.pager.GetPage(rpn))
+	}
+}
+
+func TestLookupBoundaryKeys(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	v := []byte{1, 0, 0, 0}
+	for i := 10; i <= 5_000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cursor.Set(k, v)
+	}
+
+	t.Run("first key of each right page", func(t *testing.T) {
+		probe := kv.NewCursor(cursor.rootPageNumber)
+		cursor.GotoFirstRecord()
+		for {
+			hasRight, rpn := cursor.currentPage.GetRightPageNumber()
+			if !hasRight {
+				break
+			}
+			cursor.moveToPage(kv.pager.GetPage(rpn))
+			k := cursor.GetKey()
+			if !probe.Exists(k) {
+				t.Fatalf("expected first key of page %d to exist", rpn)
+			}
+			if _, found := probe.Get(k); !found {
+				t.Fatalf("expected first key of page %d to be found", rpn)
+			}
+			if !probe.GotoKey(k) {
+				t.Fatalf("expected to goto first key of page %d", rpn)
+			}
+		}
+	})
+
+	t.Run("key less than every key", func(t *testing.T) {
+		k, err := EncodeKey(5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor.Exists(k) {
+			t.Fatal("expected key to not exist")
+		}
+		if _, found := cursor.Get(k); found {
+			t.Fatal("expected key to not be found")
+		}
+		cursor.Set(k, v)
+		if !cursor.Exists(k) {
+			t.Fatal("expected key to exist after set")
+		}
+		if got := cursor.Count(); got != 4_992 {
+			t.Fatalf("expected count 4992 got %d", got)
+		}
+	})
+
+	t.Run("key greater than every key", func(t *testing.T) {
+		k, err := EncodeKey(5_001)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cursor.Exists(k) {
+			t.Fatal("expected key to not exist")
+		}
+		if cursor.GotoKey(k) {
+			t.Fatal("expected goto key to be false")
+		}
+	})
+}
+
+func TestSplitBySize(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	small := []byte{1}
+	large := bytes.Repeat([]byte{1}, 3000)
+	for i := 1; i <= 100; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, small); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lk, err := EncodeKey(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.Set(lk, large); err != nil {
+		t.Fatalf("expected large tuple to split onto its own page but got %s", err)
+	}
+	got, found := cursor.Get(lk)
+	if !found {
+		t.Fatal("expected large tuple to be found")
+	}
+	if !bytes.Equal(got, large) {
+		t.Fatal("expected large tuple value to be preserved")
+	}
+	if c := cursor.Count(); c != 100 {
+		t.Fatalf("expected count 100 got %d", c)
+	}
+}
+
+func TestSplitPointBySize(t *testing.T) {
+	entries := []pager.PageTuple{{Key: []byte{0}, Value: bytes.Repeat([]byte{1}, 3000)}}
+	for i := 1; i <= 60; i += 1 {
+		entries = append(entries, pager.PageTuple{Key: []byte{byte(i)}, Value: bytes.Repeat([]byte{1}, 50)})
+	}
+	splitAt, err := splitPoint(entries, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pager.TuplesFit(entries[:splitAt]) || !pager.TuplesFit(entries[splitAt:]) {
+		t.Fatalf("expected both sides of split at %d to fit on a page", splitAt)
+	}
+	if pager.TuplesFit(entries[:len(entries)/2]) {
+		t.Fatal("expected an even split by count to overflow the left page")
+	}
+}
+
+func TestNewRowID(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+
+	t.Run("empty", func(t *testing.T) {
+		id, err := cursor.NewRowID()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if id != 1 {
+			t.Fatalf("expected 1 got %d", id)
+		}
+	})
+
+	t.Run("non integer key", func(t *testing.T) {
+		k, err := EncodeKey("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, []byte{1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cursor.NewRowID(); !errors.Is(err, ErrNonIntegerKey) {
+			t.Fatalf("expected ErrNonIntegerKey got %v", err)
+		}
+	})
+}
+
+func TestCompositeKeys(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	rowIds := rand.Perm(1_000)
+	for _, rowId := range rowIds {
+		k, err := EncodeIndexTuple([]any{fmt.Sprintf("name%d", rowId%10), rowId % 7}, rowId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("visits every key in order", func(t *testing.T) {
+		var prev []byte
+		count := 0
+		for exists := cursor.GotoFirstRecord(); exists; exists = cursor.GotoNext() {
+			if prev != nil && bytes.Compare(prev, cursor.GetKey()) != -1 {
+				t.Fatalf("expected %v to be less than %v", prev, cursor.GetKey())
+			}
+			if _, _, err := DecodeIndexTuple(cursor.GetKey()); err != nil {
+				t.Fatal(err)
+			}
+			prev = cursor.GetKey()
+			count += 1
+		}
+		if count != len(rowIds) {
+			t.Fatalf("expected %d keys got %d", len(rowIds), count)
+		}
+	})
+
+	t.Run("seeks a prefix", func(t *testing.T) {
+		prefix, err := EncodeIndexTuplePrefix([]any{"name3", 2})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := []int{}
+		for exists := cursor.SeekGE(prefix); exists && bytes.HasPrefix(cursor.GetKey(), prefix); exists = cursor.GotoNext() {
+			_, rowId, err := DecodeIndexTuple(cursor.GetKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			got = append(got, rowId)
+		}
+		want := []int{}
+		for rowId := 0; rowId < len(rowIds); rowId += 1 {
+			if rowId%10 == 3 && rowId%7 == 2 {
+				want = append(want, rowId)
+			}
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("new row id", func(t *testing.T) {
+		if _, err := cursor.NewRowID(); !errors.Is(err, ErrNonIntegerKey) {
+			t.Fatalf("expected ErrNonIntegerKey got %v", err)
+		}
+	})
+}
+
+func TestSetTupleTooLarge(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	k, err := EncodeKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.Set(k, bytes.Repeat([]byte{1}, 5000)); err != ErrTupleTooLarge {
+		t.Fatalf("expected ErrTupleTooLarge got %v", err)
+	}
+	if c := cursor.Count(); c != 0 {
+		t.Fatalf("expected count 0 got %d", c)
+	}
+}
+
+// checkTree validates the pointers and ordering of every page in the tree
+// starting at rootPageNumber. It returns the amount of leaf entries.
+func checkTree(t *testing.T, kv *KV, rootPageNumber int) int {
+	t.Helper()
+	root := kv.pager.GetPage(rootPageNumber)
+	if hasParent, pn := root.GetParentPageNumber(); hasParent {
+		t.Fatalf("expected root page %d to have no parent but got %d", rootPageNumber, pn)
+	}
+	leaves := []*pager.Page{}
+	count := checkPage(t, kv, root, nil, nil, &leaves)
+	for i, leaf := range leaves {
+		wantLeft, wantRight := 0, 0
+		if i > 0 {
+			wantLeft = leaves[i-1].GetNumber()
+		}
+		if i < len(leaves)-1 {
+			wantRight = leaves[i+1].GetNumber()
+		}
+		if _, l := leaf.GetLeftPageNumber(); l != wantLeft {
+			t.Fatalf("expected leaf %d left to be %d got %d", leaf.GetNumber(), wantLeft, l)
+		}
+		if _, r := leaf.GetRightPageNumber(); r != wantRight {
+			t.Fatalf("expected leaf %d right to be %d got %d", leaf.GetNumber(), wantRight, r)
+		}
+	}
+	return count
+}
+
+// checkPage recursively validates page. Every key within page must be at least
+// low when low is not nil and less than high when high is not nil. Leaves are
+// appended to leaves in order.
+func checkPage(t *testing.T, kv *KV, page *pager.Page, low, high []byte, leaves *[]*pager.Page) int {
+	t.Helper()
+	entries := page.GetEntries()
+	for i, e := range entries {
+		if i > 0 && bytes.Compare(entries[i-1].Key, e.Key) >= 0 {
+			t.Fatalf("expected page %d keys to be ascending at %d", page.GetNumber(), i)
+		}
+		if low != nil && bytes.Compare(e.Key, low) < 0 {
+			t.Fatalf("expected page %d key %v to be at least %v", page.GetNumber(), e.Key, low)
+		}
+		if high != nil && bytes.Compare(e.Key, high) >= 0 {
+			t.Fatalf("expected page %d key %v to be less than %v", page.GetNumber(), e.Key, high)
+		}
+	}
+	if page.IsLeaf() {
+		*leaves = append(*leaves, page)
+		return len(entries)
+	}
+	if len(entries) == 0 {
+		t.Fatalf("expected internal page %d to have entries", page.GetNumber())
+	}
+	count := 0
+	for i, e := range entries {
+		child := kv.pager.GetPage(pointerPageNumber(e.Value))
+		if _, pn := child.GetParentPageNumber(); pn != page.GetNumber() {
+			t.Fatalf("expected page %d parent to be %d got %d", child.GetNumber(), page.GetNumber(), pn)
+		}
+		// The first child may hold keys lower than its pointer key since
+		// searching sends keys lower than the first key to the first child.
+		childLow := low
+		if i > 0 {
+			childLow = e.Key
+		}
+		var childHigh []byte
+		if i < len(entries)-1 {
+			childHigh = entries[i+1].Key
+		} else {
+			childHigh = high
+		}
+		childCount := checkPage(t, kv, child, childLow, childHigh, leaves)
+		if pointerCount(e.Value) != childCount {
+			t.Fatalf("expected pointer to page %d to count %d got %d", child.GetNumber(), childCount, pointerCount(e.Value))
+		}
+		count += childCount
+	}
+	return count
+}
+
+func TestTreeInvariants(t *testing.T) {
+	workloads := map[string]func(r *rand.Rand, i int) int{
+		"ascending":  func(r *rand.Rand, i int) int { return i },
+		"descending": func(r *rand.Rand, i int) int { return 1_000_000 - i },
+		"random":     func(r *rand.Rand, i int) int { return r.Intn(20_000) },
+	}
+	for name, nextKey := range workloads {
+		t.Run(name, func(t *testing.T) {
+			r := rand.New(rand.NewSource(1))
+			kv := mustNewKv()
+			kv.BeginWriteTransaction(context.Background())
+			defer kv.EndWriteTransaction()
+			root := kv.NewBTree()
+			cursor := kv.NewCursor(root)
+			keys := map[int]bool{}
+			for i := 1; i <= 10_000; i += 1 {
+				key := nextKey(r, i)
+				k, err := EncodeKey(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				v := bytes.Repeat([]byte{1}, 1+r.Intn(200))
+				if err := cursor.Set(k, v); err != nil {
+					t.Fatal(err)
+				}
+				keys[key] = true
+				if i%2_500 == 0 {
+					if got := checkTree(t, kv, root); got != len(keys) {
+						t.Fatalf("expected %d leaf entries got %d", len(keys), got)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteRebalance(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	root := kv.NewBTree()
+	cursor := kv.NewCursor(root)
+	r := rand.New(rand.NewSource(1))
+	amount := 10_000
+	for i := 1; i <= amount; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, bytes.Repeat([]byte{1}, 1+r.Intn(200))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("random deletes", func(t *testing.T) {
+		remaining := amount
+		for _, i := range r.Perm(amount)[:amount*9/10] {
+			k, err := EncodeKey(i + 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cursor.GotoKey(k) {
+				t.Fatalf("expected key %d to exist", i+1)
+			}
+			cursor.DeleteCurrent()
+			remaining -= 1
+			if remaining%1_000 == 0 {
+				if got := checkTree(t, kv, root); got != remaining {
+					t.Fatalf("expected %d leaf entries got %d", remaining, got)
+				}
+			}
+		}
+		if kv.pager.FreelistCount() == 0 {
+			t.Fatal("expected merged pages to be freed")
+		}
+	})
+
+	t.Run("delete in a loop", func(t *testing.T) {
+		// Every other tuple is deleted so the cursor must be left on the next
+		// tuple even when deleting merged its page.
+		want := cursor.Count() / 2
+		exists := cursor.GotoFirstRecord()
+		for i := 0; exists; i += 1 {
+			if i%2 == 0 {
+				cursor.DeleteCurrent()
+			}
+			exists = cursor.GotoNext()
+		}
+		if got := checkTree(t, kv, root); got != want {
+			t.Fatalf("expected %d leaf entries got %d", want, got)
+		}
+	})
+
+	t.Run("delete all", func(t *testing.T) {
+		for cursor.GotoFirstRecord() {
+			cursor.DeleteCurrent()
+		}
+		page := kv.pager.GetPage(root)
+		if !page.IsLeaf() || page.GetRecordCount() != 0 {
+			t.Fatalf("expected root to be an empty leaf")
+		}
+	})
+
+	t.Run("freed pages are reused", func(t *testing.T) {
+		freed := kv.pager.FreelistCount()
+		for i := 1; i <= amount; i += 1 {
+			k, err := EncodeKey(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := cursor.Set(k, bytes.Repeat([]byte{1}, 100)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got := kv.pager.FreelistCount(); got >= freed {
+			t.Fatalf("expected fewer than %d free pages got %d", freed, got)
+		}
+		if got := checkTree(t, kv, root); got != amount {
+			t.Fatalf("expected %d leaf entries got %d", amount, got)
+		}
+	})
+}
+
+func TestSortTuples(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 100, 10_000, 10_001} {
+		tuples := []pager.PageTuple{}
+		for _, i := range r.Perm(n) {
+			k, err := EncodeKey(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tuples = append(tuples, pager.PageTuple{Key: k})
+		}
+		SortTuples(tuples, 3)
+		for i := 1; i < len(tuples); i += 1 {
+			if bytes.Compare(tuples[i-1].Key, tuples[i].Key) >= 0 {
+				t.Fatalf("expected %d tuples to be sorted at %d", n, i)
+			}
+		}
+	}
+}
+
+func TestBulkLoad(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+
+	t.Run("load", func(t *testing.T) {
+		root := kv.NewBTree()
+		tuples := []pager.PageTuple{}
+		for _, i := range r.Perm(20_000) {
+			k, err := EncodeKey(i)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tuples = append(tuples, pager.PageTuple{Key: k, Value: []byte{1}})
+		}
+		if err := kv.NewCursor(root).BulkLoad(tuples); err != nil {
+			t.Fatal(err)
+		}
+		if got := checkTree(t, kv, root); got != 20_000 {
+			t.Fatalf("expected 20000 entries got %d", got)
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		root := kv.NewBTree()
+		tuples := []pager.PageTuple{{Key: []byte{1}}, {Key: []byte{2}}, {Key: []byte{1}}}
+		if err := kv.NewCursor(root).BulkLoad(tuples); err != ErrDuplicateKey {
+			t.Fatalf("expected ErrDuplicateKey got %v", err)
+		}
+		if got := kv.NewCursor(root).Count(); got != 0 {
+			t.Fatalf("expected nothing to be inserted got %d", got)
+		}
+	})
+}
+
+func TestRollbackRestoresCatalog(t *testing.T) {
+	kv := mustNewKv()
+	version := kv.GetCatalog().GetVersion()
+	if err := kv.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	k, err := EncodeKey(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := Encode([]any{"table", "foo", "foo", kv.NewBTree(), "{}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.NewCursor(1).Set(k, v); err != nil {
+		t.Fatal(err)
+	}
+	if err := kv.ParseSchema(); err != nil {
+		t.Fatal(err)
+	}
+	if !kv.GetCatalog().TableExists("foo") {
+		t.Fatal("expected foo to exist within the transaction")
+	}
+	kv.RollbackWrite()
+	if kv.GetCatalog().TableExists("foo") {
+		t.Fatal("expected foo to not exist after rollback")
+	}
+	if got := kv.GetCatalog().GetVersion(); got != version {
+		t.Fatalf("expected version %s after rollback got %s", version, got)
+	}
+}
+
+func TestParseMalformedSchema(t *testing.T) {
+	rows := map[string][]any{
+		"too few values":    {"table", "foo"},
+		"wrong value type":  {"table", "foo", "foo", "2", "{}"},
+		"invalid root page": {"table", "foo", "foo", 0, "{}"},
+	}
+	for name, row := range rows {
+		t.Run(name, func(t *testing.T) {
+			kv := mustNewKv()
+			if err := kv.BeginWriteTransaction(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			defer kv.RollbackWrite()
+			k, err := EncodeKey(1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := Encode(row)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := kv.NewCursor(1).Set(k, v); err != nil {
+				t.Fatal(err)
+			}
+			if err := kv.ParseSchema(); !errors.Is(err, pager.ErrCorrupt) {
+				t.Fatalf("expected ErrCorrupt got %v", err)
+			}
+		})
+	}
+}
+
+func TestScanReadsAhead(t *testing.T) {
+	kv, cursor := mustNewCursor(1)
+	if err := kv.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for i := range 5000 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, bytes.Repeat([]byte{'a'}, 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kv.EndWriteTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	snapshot, err := kv.BeginReadTransaction(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Nothing else reads the leaves following the first before the read
+	// transaction ends and waits for reading ahead to stop.
+	if !snapshot.NewCursor(1).GotoFirstRecord() {
+		t.Fatal("expected first record")
+	}
+	kv.EndReadTransaction(snapshot)
+	if kv.GetMetrics().PagesReadAhead.Value() == 0 {
+		t.Fatal("expected the first leaf to read pages ahead")
+	}
+
+	t.Run("scan", func(t *testing.T) {
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		c := snapshot.NewCursor(1)
+		count := 0
+		for ok := c.GotoFirstRecord(); ok; ok = c.GotoNext() {
+			count += 1
+		}
+		if count != 5000 {
+			t.Fatalf("expected 5000 tuples got %d", count)
+		}
+	})
+}
+
+func TestRowCache(t *testing.T) {
+	kv, cursor := mustNewCursor(1)
+	hits := &kv.GetMetrics().RowCacheHits
+	k := []byte{1}
+	v := []byte{'a'}
+
+	if cursor.Exists(k) {
+		t.Fatal("expected key to not exist")
+	}
+	if cursor.Exists(k) {
+		t.Fatal("expected key to not exist")
+	}
+	if got := hits.Value(); got != 1 {
+		t.Fatalf("expected 1 row cache hit got %d", got)
+	}
+
+	t.Run("write invalidates", func(t *testing.T) {
+		if err := cursor.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+		before := hits.Value()
+		got, found := cursor.Get(k)
+		if !found || !bytes.Equal(got, v) {
+			t.Fatalf("expected %v got %v", v, got)
+		}
+		if hits.Value() != before {
+			t.Fatal("expected lookup after write to miss the row cache")
+		}
+		if !kv.NewCursor(1).GotoKey(k) {
+			t.Fatal("expected another cursor to find the key")
+		}
+		if hits.Value() != before+1 {
+			t.Fatal("expected another cursor to share the row cache")
+		}
+	})
+
+	t.Run("transaction invalidates", func(t *testing.T) {
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer kv.EndReadTransaction(snapshot)
+		before := hits.Value()
+		if !snapshot.NewCursor(cursor.rootPageNumber).Exists(k) {
+			t.Fatal("expected key to exist")
+		}
+		if hits.Value() != before {
+			t.Fatal("expected lookup in a new transaction to miss the row cache")
+		}
+	})
+
+	t.Run("evicts oldest", func(t *testing.T) {
+		rc := newRowCache()
+		for i := range rowCacheSize + 1 {
+			rc.add(1, []byte{byte(i)}, nil)
+		}
+		if _, _, hit := rc.get(1, []byte{0}); hit {
+			t.Fatal("expected oldest lookup to be evicted")
+		}
+		if _, _, hit := rc.get(1, []byte{rowCacheSize}); !hit {
+			t.Fatal("expected newest lookup to be cached")
+		}
+	})
+}
+
+func TestSeekBounds(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	v := []byte{1, 0, 0, 0}
+	for i := 10; i <= 5_000; i += 2 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	seeks := []struct {
+		name string
+		seek func(c *Cursor, key []byte) bool
+		// want is the key the seek should find for probe or 0 when there is no
+		// such key.
+		want func(probe int) int
+	}{
+		{
+			name: "SeekGE",
+			seek: (*Cursor).SeekGE,
+			want: func(probe int) int { return max(10, probe+probe%2) },
+		},
+		{
+			name: "SeekGT",
+			seek: (*Cursor).SeekGT,
+			want: func(probe int) int { return max(10, probe+2-probe%2) },
+		},
+		{
+			name: "SeekLE",
+			seek: (*Cursor).SeekLE,
+			want: func(probe int) int { return min(5_000, probe-probe%2) },
+		},
+		{
+			name: "SeekLT",
+			seek: (*Cursor).SeekLT,
+			want: func(probe int) int { return min(5_000, probe-2+probe%2) },
+		},
+	}
+	for _, s := range seeks {
+		t.Run(s.name, func(t *testing.T) {
+			for probe := 1; probe <= 5_010; probe += 1 {
+				want := s.want(probe)
+				if want < 10 || want > 5_000 {
+					want = 0
+				}
+				k, err := EncodeKey(probe)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c := kv.NewCursor(cursor.rootPageNumber)
+				found := s.seek(c, k)
+				if want == 0 {
+					if found {
+						t.Fatalf("expected no key for probe %d", probe)
+					}
+					continue
+				}
+				if !found {
+					t.Fatalf("expected key %d for probe %d", want, probe)
+				}
+				got, err := DecodeKey(c.GetKey())
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != want {
+					t.Fatalf("expected key %d for probe %d got %v", want, probe, got)
+				}
+			}
+		})
+	}
+}
+
+func TestGotoPrev(t *testing.T) {
+	kv := mustNewKv()
+	kv.BeginWriteTransaction(context.Background())
+	defer kv.EndWriteTransaction()
+	cursor := kv.NewCursor(kv.NewBTree())
+	v := []byte{1, 0, 0, 0}
+	for i := 1; i <= 2_000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := cursor.Set(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// reverse returns the keys from the last key to the first.
+	reverse := func(t *testing.T) []int {
+		keys := []int{}
+		c := kv.NewCursor(cursor.rootPageNumber)
+		for exists := c.GotoLastRecord(); exists; exists = c.GotoPrev() {
+			k, err := DecodeKey(c.GetKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			keys = append(keys, k.(int))
+		}
+		return keys
+	}
+
+	t.Run("visits every key in descending order", func(t *testing.T) {
+		keys := reverse(t)
+		if len(keys) != 2_000 {
+			t.Fatalf("expected 2000 keys got %d", len(keys))
+		}
+		for i, k := range keys {
+			if k != 2_000-i {
+				t.Fatalf("expected key %d at %d got %d", 2_000-i, i, k)
+			}
+		}
+	})
+
+	t.Run("moves to the previous page", func(t *testing.T) {
+		c := kv.NewCursor(cursor.rootPageNumber)
+		if !c.GotoLastRecord() {
+			t.Fatal("expected last record")
+		}
+		last := c.GetKey()
+		if !c.GotoPrevPage() {
+			t.Fatal("expected previous page")
+		}
+		if bytes.Compare(c.GetKey(), last) >= 0 {
+			t.Fatal("expected key before last key")
+		}
+		if !c.GotoFirstRecord() || c.GotoPrevPage() || c.GotoPrev() {
+			t.Fatal("expected no page or tuple before the first tuple")
+		}
+		if k, _ := DecodeKey(c.GetKey()); k != 1 {
+			t.Fatalf("expected cursor to stay on key 1 got %v", k)
+		}
+	})
+
+	t.Run("after delete", func(t *testing.T) {
+		c := kv.NewCursor(cursor.rootPageNumber)
+		for exists := c.GotoLastRecord(); exists; exists = c.GotoPrev() {
+			k, err := DecodeKey(c.GetKey())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if k.(int)%4 != 0 {
+				c.DeleteCurrent()
+			}
+		}
+		keys := reverse(t)
+		if len(keys) != 500 {
+			t.Fatalf("expected 500 keys got %d", len(keys))
+		}
+		for i, k := range keys {
+			if k != 2_000-i*4 {
+				t.Fatalf("expected key %d at %d got %d", 2_000-i*4, i, k)
+			}
+		}
+		checkTree(t, kv, cursor.rootPageNumber)
+	})
+}
+
+func TestEphemeral(t *testing.T) {
+	e := NewEphemeral()
+	defer e.Close()
+	other := NewEphemeral()
+	defer other.Close()
+	c := e.NewCursor()
+	for i := 1; i <= 1_000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(k, []byte{1, 0, 0, 0}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := e.NewCursor().Count(); got != 1_000 {
+		t.Fatalf("expected 1000 entries got %d", got)
+	}
+	if got := other.NewCursor().Count(); got != 0 {
+		t.Fatalf("expected other ephemeral b tree to be empty got %d", got)
+	}
+	rowId, err := c.NewRowID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rowId != 1_001 {
+		t.Fatalf("expected next row id 1001 got %d", rowId)
+	}
+}
+
+func BenchmarkScan(b *testing.B) {
+	kv, cursor := mustNewCursor(1)
+	if err := kv.BeginWriteTransaction(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+	for i := 1; i <= 1000; i += 1 {
+		k, err := EncodeKey(i)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := cursor.Set(k, []byte{1, 2, 3, 4}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := kv.EndWriteTransaction(); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		snapshot, err := kv.BeginReadTransaction(context.Background())
+		if err != nil {
+			b.Fatal(err)
+		}
+		c := snapshot.NewCursor(cursor.rootPageNumber)
+		for exists := c.GotoFirstRecord(); exists; exists = c.GotoNext() {
+			c.GetValue()
+		}
+		kv.EndReadTransaction(snapshot)
+	}
 }
@@ -0,0 +1,80 @@
+package kv
+
+import (
+	"sync"
+
+	"github.com/chirst/cdb/pager"
+)
+
+// rowCacheSize is the maximum number of lookups remembered by a rowCache.
+const rowCacheSize = 64
+
+// rowCache remembers the outcome of recent point lookups so looking up the same
+// key repeatedly, for example checking a foreign key for each row of a table,
+// does not descend the b tree each time.
+//
+// The cache only lives as long as a transaction. It is cleared when a
+// transaction begins or ends and whenever a cursor writes since a write can
+// move tuples between pages.
+type rowCache struct {
+	mu      sync.Mutex
+	entries map[rowCacheKey]rowCacheEntry
+	// order is the keys of entries from oldest to newest. When the cache is
+	// full the oldest entry is evicted.
+	order []rowCacheKey
+}
+
+// rowCacheKey identifies a key in the b tree with rootPageNumber.
+type rowCacheKey struct {
+	rootPageNumber int
+	key            string
+}
+
+// rowCacheEntry is the outcome of a lookup.
+type rowCacheEntry struct {
+	// page is the leaf page holding the key. page is nil when the key does not
+	// exist.
+	page *pager.Page
+}
+
+func newRowCache() *rowCache {
+	return &rowCache{
+		entries: map[rowCacheKey]rowCacheEntry{},
+	}
+}
+
+// get returns the leaf page holding key and a flag indicating if the key
+// exists. hit is false when the lookup is not cached.
+func (rc *rowCache) get(rootPageNumber int, key []byte) (page *pager.Page, exists, hit bool) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	e, ok := rc.entries[rowCacheKey{rootPageNumber, string(key)}]
+	if !ok {
+		return nil, false, false
+	}
+	return e.page, e.page != nil, true
+}
+
+// add remembers page is the leaf holding key. page is nil when the key does not
+// exist.
+func (rc *rowCache) add(rootPageNumber int, key []byte, page *pager.Page) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	k := rowCacheKey{rootPageNumber, string(key)}
+	if _, ok := rc.entries[k]; !ok {
+		if len(rc.order) == rowCacheSize {
+			delete(rc.entries, rc.order[0])
+			rc.order = rc.order[1:]
+		}
+		rc.order = append(rc.order, k)
+	}
+	rc.entries[k] = rowCacheEntry{page: page}
+}
+
+// clear forgets every lookup.
+func (rc *rowCache) clear() {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	clear(rc.entries)
+	rc.order = rc.order[:0]
+}
@@ -0,0 +1,400 @@
+// Package kvstore exposes the cdb storage engine as an embedded key value
+// store without the SQL layer. Keys and values are arbitrary byte slices kept in
+// named buckets where each bucket is a b tree sorted by key. All reads and
+// writes happen within a transaction.
+//
+// The API of this package is considered stable. A typical use looks like:
+//
+//	db, err := kvstore.Open(false, "my.db")
+//	if err != nil {
+//		return err
+//	}
+//	err = db.Update(func(tx *kvstore.Tx) error {
+//		b, err := tx.CreateBucketIfNotExists("users")
+//		if err != nil {
+//			return err
+//		}
+//		return b.Put([]byte("alice"), []byte("admin"))
+//	})
+//
+// Buckets are recorded in the same schema table SQL tables are recorded in, so
+// a bucket appears in cdb_schema with the type bucket.
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"iter"
+	"time"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/metrics"
+	"github.com/chirst/cdb/pager"
+)
+
+var (
+	// ErrBucketNotFound is returned when accessing a bucket that has not been
+	// created.
+	ErrBucketNotFound = errors.New("bucket not found")
+	// ErrBucketExists is returned when creating a bucket that already exists.
+	ErrBucketExists = errors.New("bucket already exists")
+	// ErrBucketNameRequired is returned when creating a bucket with an empty
+	// name.
+	ErrBucketNameRequired = errors.New("bucket name required")
+	// ErrKeyRequired is returned when writing an empty key.
+	ErrKeyRequired = errors.New("key required")
+	// ErrTxNotWritable is returned when writing within a read only transaction.
+	ErrTxNotWritable = errors.New("transaction not writable")
+	// ErrTxClosed is returned when using a transaction that has been committed
+	// or rolled back.
+	ErrTxClosed = errors.New("transaction closed")
+)
+
+const (
+	// schemaRootPageNumber is the root page of the schema table.
+	schemaRootPageNumber = 1
+	// bucketObjectType is the type of a bucket in the schema table.
+	bucketObjectType = "bucket"
+)
+
+// DB is a key value store backed by a single database file.
+type DB struct {
+	kv *kv.KV
+}
+
+// Open opens the database with filename. The useMemory flag means the database
+// will not create a file or persist changes to disk.
+func Open(useMemory bool, filename string) (*DB, error) {
+	kv, err := kv.New(useMemory, filename)
+	if err != nil {
+		return nil, err
+	}
+	return &DB{kv: kv}, nil
+}
+
+// Metrics returns the metrics registry of the database.
+func (db *DB) Metrics() *metrics.Registry {
+	return db.kv.GetMetrics()
+}
+
+// Begin starts a transaction. Only one writable transaction may be open at a
+// time until it is committed or rolled back. Many read only transactions may be
+// open at once, each reading the database as it was when the transaction began.
+func (db *DB) Begin(writable bool) (*Tx, error) {
+	if writable {
+		if err := db.kv.BeginWriteTransaction(context.Background()); err != nil {
+			return nil, err
+		}
+		return &Tx{db: db, writable: true}, nil
+	}
+	snapshot, err := db.kv.BeginReadTransaction(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return &Tx{db: db, snapshot: snapshot}, nil
+}
+
+// View runs fn within a read only transaction. When fn read a corrupt page
+// the error is a pager.CorruptionError.
+func (db *DB) View(fn func(*Tx) error) error {
+	tx, err := db.Begin(false)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Update runs fn within a writable transaction. If fn returns an error the
+// transaction is rolled back otherwise the transaction is committed.
+func (db *DB) Update(fn func(*Tx) error) error {
+	tx, err := db.Begin(true)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Tx is a read only or writable transaction. A Tx must not be used after it is
+// committed or rolled back.
+type Tx struct {
+	db       *DB
+	writable bool
+	closed   bool
+	// snapshot is what a read only transaction reads.
+	snapshot *kv.Snapshot
+}
+
+// Writable returns true if the transaction can write.
+func (tx *Tx) Writable() bool {
+	return tx.writable
+}
+
+// Commit writes the changes of a writable transaction to the database file
+// and closes the transaction. Committing a read only transaction closes it.
+// Either way the error is a pager.CorruptionError when the transaction read a
+// corrupt page.
+func (tx *Tx) Commit() error {
+	if tx.closed {
+		return ErrTxClosed
+	}
+	tx.closed = true
+	if !tx.writable {
+		err := tx.snapshot.Err()
+		tx.db.kv.EndReadTransaction(tx.snapshot)
+		return err
+	}
+	// The catalog is kept current so SQL statements using the same kv see
+	// new buckets. The schema is parsed before the write ends so it cannot be
+	// changed by another process while it is read.
+	if err := tx.db.kv.ParseSchema(); err != nil {
+		tx.db.kv.RollbackWrite()
+		return err
+	}
+	if err := tx.db.kv.EndWriteTransaction(); err != nil {
+		tx.db.kv.RollbackWrite()
+		return err
+	}
+	return nil
+}
+
+// Rollback discards the changes of the transaction and closes it. Rollback is a
+// no-op for a closed transaction.
+func (tx *Tx) Rollback() {
+	if tx.closed {
+		return
+	}
+	tx.closed = true
+	if tx.writable {
+		tx.db.kv.RollbackWrite()
+		return
+	}
+	tx.db.kv.EndReadTransaction(tx.snapshot)
+}
+
+// Bucket returns the bucket with name or ErrBucketNotFound.
+func (tx *Tx) Bucket(name string) (*Bucket, error) {
+	if tx.closed {
+		return nil, ErrTxClosed
+	}
+	o, err := tx.findObject(name)
+	if err != nil {
+		return nil, err
+	}
+	if o == nil || o.objectType != bucketObjectType {
+		return nil, ErrBucketNotFound
+	}
+	return &Bucket{tx: tx, rootPageNumber: o.rootPageNumber}, nil
+}
+
+// CreateBucket creates a bucket with name. ErrBucketExists is returned if the
+// bucket already exists.
+func (tx *Tx) CreateBucket(name string) (*Bucket, error) {
+	if tx.closed {
+		return nil, ErrTxClosed
+	}
+	if !tx.writable {
+		return nil, ErrTxNotWritable
+	}
+	if name == "" {
+		return nil, ErrBucketNameRequired
+	}
+	o, err := tx.findObject(name)
+	if err != nil {
+		return nil, err
+	}
+	if o != nil {
+		if o.objectType == bucketObjectType {
+			return nil, ErrBucketExists
+		}
+		return nil, fmt.Errorf("name %s is used by a %s", name, o.objectType)
+	}
+	rootPageNumber := tx.db.kv.NewBTree()
+	schema := tx.db.kv.NewCursor(schemaRootPageNumber)
+	rowID, err := schema.NewRowID()
+	if err != nil {
+		return nil, err
+	}
+	k, err := kv.EncodeKey(rowID)
+	if err != nil {
+		return nil, err
+	}
+	modified := time.Now().UTC().Format(time.DateTime)
+	v, err := kv.Encode([]any{bucketObjectType, name, name, rootPageNumber, "", modified})
+	if err != nil {
+		return nil, err
+	}
+	if err := schema.Set(k, v); err != nil {
+		return nil, err
+	}
+	return &Bucket{tx: tx, rootPageNumber: rootPageNumber}, nil
+}
+
+// CreateBucketIfNotExists returns the bucket with name creating it if it does
+// not exist.
+func (tx *Tx) CreateBucketIfNotExists(name string) (*Bucket, error) {
+	b, err := tx.Bucket(name)
+	if err == nil {
+		return b, nil
+	}
+	if !errors.Is(err, ErrBucketNotFound) {
+		return nil, err
+	}
+	return tx.CreateBucket(name)
+}
+
+// object is an entry in the schema table.
+type object struct {
+	objectType     string
+	rootPageNumber int
+}
+
+// findObject scans the schema table for an object with name. The schema table
+// is read directly rather than through the catalog so buckets created earlier
+// in the transaction are found.
+func (tx *Tx) findObject(name string) (*object, error) {
+	c := tx.newCursor(schemaRootPageNumber)
+	for exists := c.GotoFirstRecord(); exists; exists = c.GotoNext() {
+		dv, err := kv.Decode(c.GetValue())
+		if err != nil {
+			return nil, fmt.Errorf("%w: cdb_schema row: %w", pager.ErrCorrupt, err)
+		}
+		if len(dv) < 4 {
+			return nil, fmt.Errorf("%w: cdb_schema row has %d values", pager.ErrCorrupt, len(dv))
+		}
+		objectName, ok := dv[1].(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: cdb_schema row has a name of the wrong type", pager.ErrCorrupt)
+		}
+		if objectName != name {
+			continue
+		}
+		objectType, ok1 := dv[0].(string)
+		rootPageNumber, ok2 := dv[3].(int)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("%w: cdb_schema row %s has values of the wrong type", pager.ErrCorrupt, name)
+		}
+		return &object{
+			objectType:     objectType,
+			rootPageNumber: rootPageNumber,
+		}, nil
+	}
+	return nil, nil
+}
+
+// newCursor returns a cursor on the tree at root reading the snapshot of a read
+// only transaction.
+func (tx *Tx) newCursor(root int) *kv.Cursor {
+	if tx.snapshot != nil {
+		return tx.snapshot.NewCursor(root)
+	}
+	return tx.db.kv.NewCursor(root)
+}
+
+// Bucket is a collection of key value pairs sorted by key. A Bucket is only
+// valid for the life of the transaction it was retrieved from.
+type Bucket struct {
+	tx             *Tx
+	rootPageNumber int
+}
+
+// Get returns the value for key or nil if the key does not exist.
+func (b *Bucket) Get(key []byte) []byte {
+	if b.tx.closed {
+		return nil
+	}
+	v, found := b.cursor().Get(key)
+	if !found {
+		return nil
+	}
+	return v
+}
+
+// Put sets the value for key. If Put returns an error the bucket may be left
+// partially modified so the transaction must be rolled back.
+func (b *Bucket) Put(key, value []byte) error {
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	if len(key) == 0 {
+		return ErrKeyRequired
+	}
+	return b.cursor().Set(key, value)
+}
+
+// Delete removes key from the bucket. Deleting a key that does not exist is a
+// no-op.
+func (b *Bucket) Delete(key []byte) error {
+	if err := b.checkWritable(); err != nil {
+		return err
+	}
+	c := b.cursor()
+	if c.GotoKey(key) {
+		return c.DeleteCurrent()
+	}
+	return nil
+}
+
+// Count returns the number of keys in the bucket.
+func (b *Bucket) Count() int {
+	if b.tx.closed {
+		return 0
+	}
+	return b.cursor().Count()
+}
+
+// Range returns an iterator over the key value pairs with keys greater than or
+// equal to start and less than end in ascending order. A nil start begins at
+// the first key and a nil end continues to the last key. The bucket must not be
+// modified while ranging.
+func (b *Bucket) Range(start, end []byte) iter.Seq2[[]byte, []byte] {
+	return func(yield func([]byte, []byte) bool) {
+		if b.tx.closed {
+			return
+		}
+		c := b.cursor()
+		var exists bool
+		if start == nil {
+			exists = c.GotoFirstRecord()
+		} else {
+			exists = c.SeekGE(start)
+		}
+		for ; exists; exists = c.GotoNext() {
+			k := c.GetKey()
+			if end != nil && bytes.Compare(k, end) >= 0 {
+				return
+			}
+			if !yield(k, bytes.Clone(c.GetValue())) {
+				return
+			}
+		}
+	}
+}
+
+// All returns an iterator over every key value pair in ascending order.
+func (b *Bucket) All() iter.Seq2[[]byte, []byte] {
+	return b.Range(nil, nil)
+}
+
+func (b *Bucket) cursor() *kv.Cursor {
+	return b.tx.newCursor(b.rootPageNumber)
+}
+
+func (b *Bucket) checkWritable() error {
+	if b.tx.closed {
+		return ErrTxClosed
+	}
+	if !b.tx.writable {
+		return ErrTxNotWritable
+	}
+	return nil
+}
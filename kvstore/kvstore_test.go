@@ -0,0 +1,283 @@
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/kvstore"
+	"github.com/chirst/cdb/pager"
+)
+
+func mustOpen(t *testing.T) *kvstore.DB {
+	db, err := kvstore.Open(true, "")
+	if err != nil {
+		t.Fatalf("open err %s", err)
+	}
+	return db
+}
+
+func key(i int) []byte {
+	return []byte(fmt.Sprintf("key%05d", i))
+}
+
+func TestPutGetDelete(t *testing.T) {
+	db := mustOpen(t)
+	err := db.Update(func(tx *kvstore.Tx) error {
+		b, err := tx.CreateBucket("users")
+		if err != nil {
+			return err
+		}
+		if err := b.Put([]byte("alice"), []byte("admin")); err != nil {
+			return err
+		}
+		return b.Put([]byte("bob"), []byte("guest"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("get", func(t *testing.T) {
+		err := db.View(func(tx *kvstore.Tx) error {
+			b, err := tx.Bucket("users")
+			if err != nil {
+				return err
+			}
+			if got := b.Get([]byte("alice")); !bytes.Equal(got, []byte("admin")) {
+				t.Fatalf("expected admin got %s", got)
+			}
+			if got := b.Get([]byte("carl")); got != nil {
+				t.Fatalf("expected nil got %s", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		err := db.Update(func(tx *kvstore.Tx) error {
+			b, err := tx.Bucket("users")
+			if err != nil {
+				return err
+			}
+			return b.Delete([]byte("alice"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = db.View(func(tx *kvstore.Tx) error {
+			b, err := tx.Bucket("users")
+			if err != nil {
+				return err
+			}
+			if got := b.Get([]byte("alice")); got != nil {
+				t.Fatalf("expected nil got %s", got)
+			}
+			if c := b.Count(); c != 1 {
+				t.Fatalf("expected count 1 got %d", c)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("read only", func(t *testing.T) {
+		err := db.View(func(tx *kvstore.Tx) error {
+			b, err := tx.Bucket("users")
+			if err != nil {
+				return err
+			}
+			return b.Put([]byte("carl"), []byte("guest"))
+		})
+		if !errors.Is(err, kvstore.ErrTxNotWritable) {
+			t.Fatalf("expected ErrTxNotWritable got %v", err)
+		}
+	})
+}
+
+func TestBuckets(t *testing.T) {
+	db := mustOpen(t)
+
+	t.Run("not found", func(t *testing.T) {
+		err := db.View(func(tx *kvstore.Tx) error {
+			_, err := tx.Bucket("missing")
+			return err
+		})
+		if !errors.Is(err, kvstore.ErrBucketNotFound) {
+			t.Fatalf("expected ErrBucketNotFound got %v", err)
+		}
+	})
+
+	t.Run("exists", func(t *testing.T) {
+		err := db.Update(func(tx *kvstore.Tx) error {
+			if _, err := tx.CreateBucket("a"); err != nil {
+				return err
+			}
+			_, err := tx.CreateBucket("a")
+			return err
+		})
+		if !errors.Is(err, kvstore.ErrBucketExists) {
+			t.Fatalf("expected ErrBucketExists got %v", err)
+		}
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		err := db.View(func(tx *kvstore.Tx) error {
+			_, err := tx.Bucket("a")
+			return err
+		})
+		if !errors.Is(err, kvstore.ErrBucketNotFound) {
+			t.Fatalf("expected rolled back bucket to not be found got %v", err)
+		}
+	})
+
+	t.Run("separate", func(t *testing.T) {
+		err := db.Update(func(tx *kvstore.Tx) error {
+			a, err := tx.CreateBucketIfNotExists("a")
+			if err != nil {
+				return err
+			}
+			b, err := tx.CreateBucketIfNotExists("b")
+			if err != nil {
+				return err
+			}
+			if err := a.Put([]byte("k"), []byte("a")); err != nil {
+				return err
+			}
+			if err := b.Put([]byte("k"), []byte("b")); err != nil {
+				return err
+			}
+			if got := a.Get([]byte("k")); !bytes.Equal(got, []byte("a")) {
+				t.Fatalf("expected a got %s", got)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestRange(t *testing.T) {
+	db := mustOpen(t)
+	err := db.Update(func(tx *kvstore.Tx) error {
+		b, err := tx.CreateBucket("nums")
+		if err != nil {
+			return err
+		}
+		for i := 0; i < 2_000; i += 1 {
+			if err := b.Put(key(i), key(i)); err != nil {
+				return err
+			}
+		}
+		// Delete a large run of keys so the scan passes over empty pages.
+		for i := 500; i < 1_500; i += 1 {
+			if err := b.Delete(key(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name       string
+		start, end []byte
+		wantFirst  int
+		wantLast   int
+		wantCount  int
+	}{
+		{name: "all", wantFirst: 0, wantLast: 1_999, wantCount: 1_000},
+		{name: "start", start: key(100), wantFirst: 100, wantLast: 1_999, wantCount: 900},
+		{name: "end", end: key(100), wantFirst: 0, wantLast: 99, wantCount: 100},
+		{name: "deleted start", start: key(600), wantFirst: 1_500, wantLast: 1_999, wantCount: 500},
+		{name: "between", start: key(10), end: key(1_510), wantFirst: 10, wantLast: 1_509, wantCount: 500},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := db.View(func(tx *kvstore.Tx) error {
+				b, err := tx.Bucket("nums")
+				if err != nil {
+					return err
+				}
+				var keys [][]byte
+				for k, v := range b.Range(c.start, c.end) {
+					if !bytes.Equal(k, v) {
+						t.Fatalf("expected value %s to equal key %s", v, k)
+					}
+					if len(keys) > 0 && bytes.Compare(keys[len(keys)-1], k) >= 0 {
+						t.Fatalf("expected %s to be after %s", k, keys[len(keys)-1])
+					}
+					keys = append(keys, k)
+				}
+				if len(keys) != c.wantCount {
+					t.Fatalf("expected %d keys got %d", c.wantCount, len(keys))
+				}
+				if !bytes.Equal(keys[0], key(c.wantFirst)) {
+					t.Fatalf("expected first %s got %s", key(c.wantFirst), keys[0])
+				}
+				if !bytes.Equal(keys[len(keys)-1], key(c.wantLast)) {
+					t.Fatalf("expected last %s got %s", key(c.wantLast), keys[len(keys)-1])
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestCorruptSchemaRow(t *testing.T) {
+	filename := t.TempDir() + "/corrupt_schema"
+	db, err := kvstore.Open(false, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Another connection writes the row since opening a database with it is
+	// already an error.
+	k, err := kv.New(false, filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	schema := k.NewCursor(1)
+	rowID, err := schema.NewRowID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := kv.EncodeKey(rowID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The root page of the bucket is text rather than a page number.
+	value, err := kv.Encode([]any{"bucket", "bad", "bad", "2", "", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := schema.Set(key, value); err != nil {
+		t.Fatal(err)
+	}
+	if err := k.EndWriteTransaction(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.View(func(tx *kvstore.Tx) error {
+		_, err := tx.Bucket("bad")
+		return err
+	})
+	if !errors.Is(err, pager.ErrCorrupt) {
+		t.Fatalf("expected %v got %v", pager.ErrCorrupt, err)
+	}
+}
@@ -0,0 +1,25 @@
+// Package logging defines the leveled logger used throughout cdb. Library code
+// never exits the host process. Problems are returned as errors and reported to
+// the logger the embedder provides.
+package logging
+
+// Logger is a leveled logger. Args are alternating keys and values describing
+// the message. A *slog.Logger satisfies Logger.
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// Nop returns a logger that discards every message. It is the default logger.
+func Nop() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
@@ -2,12 +2,15 @@ package main
 
 import (
 	"C"
+	"context"
 	"flag"
 	"log"
-	"strconv"
+	"sync"
+	"unsafe"
 
 	"github.com/chirst/cdb/db"
 	"github.com/chirst/cdb/repl"
+	"github.com/chirst/cdb/vm"
 )
 
 const fFlagHelp = "Specify the database file name"
@@ -24,90 +27,217 @@ func main() {
 	repl.New(db).Run()
 }
 
+// _mu guards _databases, _connections and _plans since the C interface may be
+// called from many threads.
+var _mu sync.Mutex
+
 // References to _databases created by the C interface this is a mapping of
 // filename to database instance.
 var _databases = make(map[string]*db.DB)
 
+// References to _connections created by the C interface this is a mapping of
+// connId to a connection of a database. Each connection has its own
+// transaction so each thread using the database should open its own.
+var _connections = make(map[int]*db.Conn)
+
 // References to _plans created by the C interface this is a mapping of
 // prepareId to prepared statements.
 var _plans = make(map[int]*db.PreparedStatement)
 
+// getDatabase returns the database with the given filename.
+func getDatabase(filename *C.char) (*db.DB, bool) {
+	_mu.Lock()
+	defer _mu.Unlock()
+	d, ok := _databases[C.GoString(filename)]
+	return d, ok
+}
+
+// getConnection returns the connection with the given connId.
+func getConnection(connId C.int) (*db.Conn, bool) {
+	_mu.Lock()
+	defer _mu.Unlock()
+	c, ok := _connections[int(connId)]
+	return c, ok
+}
+
+// getPlan returns the prepared statement with the given prepareId.
+func getPlan(prepareId C.int) (*db.PreparedStatement, bool) {
+	_mu.Lock()
+	defer _mu.Unlock()
+	p, ok := _plans[int(prepareId)]
+	return p, ok
+}
+
+// addHandle adds v to m with the lowest id that is not in use and returns the
+// id. addHandle must be called with _mu held.
+func addHandle[T any](m map[int]T, v T) int {
+	for i := 1; ; i += 1 {
+		if _, ok := m[i]; !ok {
+			m[i] = v
+			return i
+		}
+	}
+}
+
 // cdb_new_db opens a database with the given filename. A filename of ":memory:"
 // will open a database that does not persist data after it is closed. A non
-// zero int is returned in case an error occurs. The database can be closed with
+// zero int is returned in case an error occurs. Statements are prepared with a
+// connection opened by cdb_open_conn. The database can be closed with
 // cdb_close_db.
 //
 //export cdb_new_db
 func cdb_new_db(filename *C.char) C.int {
 	fng := C.GoString(filename)
+	_mu.Lock()
+	defer _mu.Unlock()
 	if _, ok := _databases[fng]; ok {
 		return C.int(0)
 	}
 	d, err := db.New(fng == ":memory:", fng)
-	_databases[fng] = d
 	if err != nil {
 		return C.int(1)
 	}
+	_databases[fng] = d
 	return C.int(0)
 }
 
-// cdb_close_db closes the database with the given filename.
+// cdb_close_db closes the database with the given filename. Connections that
+// are already open remain usable until they are closed with cdb_close_conn.
 //
 //export cdb_close_db
 func cdb_close_db(filename *C.char) {
 	fng := C.GoString(filename)
+	_mu.Lock()
+	defer _mu.Unlock()
 	delete(_databases, fng)
 }
 
+// cdb_open_conn opens a connection to the database with the given filename and
+// puts its handle in connId. Each connection has its own transaction and may be
+// used by one thread at a time so every thread should open its own. A non zero
+// int is returned if the database is not open. The connection must be closed
+// with cdb_close_conn.
+//
+//export cdb_open_conn
+func cdb_open_conn(connId *C.int, filename *C.char) C.int {
+	_mu.Lock()
+	defer _mu.Unlock()
+	d, ok := _databases[C.GoString(filename)]
+	if !ok {
+		return C.int(1)
+	}
+	*connId = C.int(addHandle(_connections, d.Conn()))
+	return C.int(0)
+}
+
+// cdb_close_conn closes the connection with the given connId. A transaction in
+// progress is rolled back.
+//
+//export cdb_close_conn
+func cdb_close_conn(connId C.int) {
+	_mu.Lock()
+	c, ok := _connections[int(connId)]
+	delete(_connections, int(connId))
+	_mu.Unlock()
+	if ok {
+		c.Close()
+	}
+}
+
+// cdb_begin begins a transaction for the connection with the given connId.
+// Every statement prepared with the connection is part of the transaction until
+// cdb_commit or cdb_rollback. When readOnly is not 0 a statement that writes
+// has an error. A non zero int is returned if the connection is not open or
+// already has a transaction in progress.
+//
+//export cdb_begin
+func cdb_begin(connId C.int, readOnly C.int) C.int {
+	c, ok := getConnection(connId)
+	if !ok {
+		return C.int(1)
+	}
+	if err := c.Begin(context.Background(), readOnly != 0); err != nil {
+		return C.int(1)
+	}
+	return C.int(0)
+}
+
+// cdb_commit commits the transaction of the connection with the given connId.
+// A non zero int is returned if there is no transaction in progress or the
+// commit fails in which case the transaction is rolled back.
+//
+//export cdb_commit
+func cdb_commit(connId C.int) C.int {
+	c, ok := getConnection(connId)
+	if !ok {
+		return C.int(1)
+	}
+	if err := c.Commit(); err != nil {
+		return C.int(1)
+	}
+	return C.int(0)
+}
+
+// cdb_rollback rolls back the transaction of the connection with the given
+// connId. A non zero int is returned if there is no transaction in progress.
+//
+//export cdb_rollback
+func cdb_rollback(connId C.int) C.int {
+	c, ok := getConnection(connId)
+	if !ok {
+		return C.int(1)
+	}
+	if err := c.Rollback(); err != nil {
+		return C.int(1)
+	}
+	return C.int(0)
+}
+
 // cdb_prepare prepares a statement that can be bound and executed for the given
-// filename and sql. The prepareId is a handle used for further operations on
-// the prepared statement. Note the prepared statement must be cleaned up with
+// connId and sql. The prepareId is a handle used for further operations on the
+// prepared statement. Note the prepared statement must be cleaned up with
 // cdb_close_statement.
 //
 // If an error is encountered during prepare err code 2 is returned and the
 // error message is written to prepareErr.
 //
 //export cdb_prepare
-func cdb_prepare(prepareId *C.int, filename *C.char, sql *C.char, prepareErr **C.char) C.int {
-	gfn := C.GoString(filename)
+func cdb_prepare(prepareId *C.int, connId C.int, sql *C.char, prepareErr **C.char) C.int {
 	gSql := C.GoString(sql)
-	dbi, ok := _databases[gfn]
+	c, ok := getConnection(connId)
 	if !ok {
 		return C.int(1)
 	}
-	ps, err := dbi.NewPreparedStatement(gSql)
+	ps, err := c.Prepare(gSql)
 	if err != nil {
 		*prepareErr = C.CString(err.Error())
 		return C.int(2)
 	}
-	for i := 1; ; i += 1 {
-		_, ok := _plans[i]
-		if !ok {
-			_plans[i] = ps
-			*prepareId = C.int(i)
-			return C.int(0)
-		}
-	}
+	_mu.Lock()
+	defer _mu.Unlock()
+	*prepareId = C.int(addHandle(_plans, ps))
+	return C.int(0)
 }
 
 // cdb_close_statement cleans up a prepared statement.
 //
 //export cdb_close_statement
 func cdb_close_statement(prepareId C.int) {
-	p := int(prepareId)
-	delete(_plans, p)
+	_mu.Lock()
+	defer _mu.Unlock()
+	delete(_plans, int(prepareId))
 }
 
-// cdb_bind_int binds an int as the next available argument for the given
+// cdb_bind_int binds a 64 bit int as the next available argument for the given
 // prepared statement.
 //
 //export cdb_bind_int
-func cdb_bind_int(prepareId C.int, bound C.int) C.int {
-	p, ok := _plans[int(prepareId)]
+func cdb_bind_int(prepareId C.int, bound C.longlong) C.int {
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
-	p.Args = append(p.Args, int(bound))
+	p.Args = append(p.Args, int64(bound))
 	return C.int(0)
 }
 
@@ -116,7 +246,7 @@ func cdb_bind_int(prepareId C.int, bound C.int) C.int {
 //
 //export cdb_bind_string
 func cdb_bind_string(prepareId C.int, bound *C.char) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -129,22 +259,61 @@ func cdb_bind_string(prepareId C.int, bound *C.char) C.int {
 //
 //export cdb_execute
 func cdb_execute(prepareId C.int) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	result := p.Execute()
+	p.Result = &result
+	return C.int(0)
+}
+
+// cdb_execute_many evaluates the given prepared statement once for each row of
+// bound arguments within a single transaction. The bound arguments are split
+// into rows of paramsPerRow arguments in the order they were bound. If any row
+// fails none of the rows are committed. 1 is returned if the bound arguments
+// cannot be split into rows of paramsPerRow.
+//
+//export cdb_execute_many
+func cdb_execute_many(prepareId C.int, paramsPerRow C.int) C.int {
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
-	result := p.DB.Execute(p.Statement, p.Args)
+	n := int(paramsPerRow)
+	if n <= 0 || len(p.Args)%n != 0 {
+		return C.int(1)
+	}
+	batches := [][]any{}
+	for i := 0; i < len(p.Args); i += n {
+		batches = append(batches, p.Args[i:i+n])
+	}
+	result := p.ExecuteMany(batches)
 	p.Result = &result
 	return C.int(0)
 }
 
+// cdb_interrupt stops every statement currently executing on the database with
+// the given filename. An interrupted statement has the error "interrupted". A
+// non zero int is returned if the database is not open.
+//
+//export cdb_interrupt
+func cdb_interrupt(filename *C.char) C.int {
+	dbi, ok := getDatabase(filename)
+	if !ok {
+		return C.int(1)
+	}
+	dbi.Interrupt()
+	return C.int(0)
+}
+
 // cdb_result_err puts 1 in hasError when the statement has an error. The error
 // message is put in errMessage.
 //
 //export cdb_result_err
 func cdb_result_err(prepareId C.int, hasError *C.int, errMessage **C.char) C.int {
 	*hasError = C.int(0)
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -156,13 +325,26 @@ func cdb_result_err(prepareId C.int, hasError *C.int, errMessage **C.char) C.int
 	return C.int(0)
 }
 
+// cdb_result_rows_affected puts the number of rows inserted, updated or deleted
+// by the statement in result.
+//
+//export cdb_result_rows_affected
+func cdb_result_rows_affected(prepareId C.int, result *C.longlong) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	*result = C.longlong(p.Result.RowsAffected)
+	return C.int(0)
+}
+
 // cdb_result_row moves a cursor to the next row. If there is no row
 // cdb_result_row will put 1 into hasRow otherwise 0.
 //
 //export cdb_result_row
 func cdb_result_row(prepareId C.int, hasRow *C.int) C.int {
 	*hasRow = C.int(0)
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -174,21 +356,21 @@ func cdb_result_row(prepareId C.int, hasRow *C.int) C.int {
 	return C.int(0)
 }
 
-// cdb_result_col_int puts the int for the current row at the 0 based column
-// index for the result param.
+// cdb_result_col_int puts the 64 bit int for the current row at the 0 based
+// column index for the result param. 1 is returned if the column is not an
+// int.
 //
 //export cdb_result_col_int
-func cdb_result_col_int(prepareId C.int, colIdx C.int, result *C.int) C.int {
-	p, ok := _plans[int(prepareId)]
+func cdb_result_col_int(prepareId C.int, colIdx C.int, result *C.longlong) C.int {
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
 	r := p.Result.ResultRows[p.ResultIdx][int(colIdx)]
-	ri, err := strconv.Atoi(*r)
-	if err != nil {
+	if r.Type() != vm.IntegerType {
 		return C.int(1)
 	}
-	*result = C.int(ri)
+	*result = C.longlong(r.Int())
 	return C.int(0)
 }
 
@@ -197,12 +379,69 @@ func cdb_result_col_int(prepareId C.int, colIdx C.int, result *C.int) C.int {
 //
 //export cdb_result_col_string
 func cdb_result_col_string(prepareId C.int, colIdx C.int, result **C.char) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
 	r := p.Result.ResultRows[p.ResultIdx][int(colIdx)]
-	*result = C.CString(*r)
+	*result = C.CString(r.Text())
+	return C.int(0)
+}
+
+// cdb_result_col_float puts the 64 bit float for the current row at the 0 based
+// column index for the result param. An int column is converted to a float. 1
+// is returned if the column is not a number.
+//
+//export cdb_result_col_float
+func cdb_result_col_float(prepareId C.int, colIdx C.int, result *C.double) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	r := p.Result.ResultRows[p.ResultIdx][int(colIdx)]
+	if r.Type() != vm.FloatType && r.Type() != vm.IntegerType {
+		return C.int(1)
+	}
+	*result = C.double(r.Float())
+	return C.int(0)
+}
+
+// cdb_result_col_blob puts the bytes for the current row at the 0 based column
+// index into the result param and the count of bytes into length. A column that
+// is not a blob is the bytes of its text. result is NULL when the column is
+// NULL.
+//
+//export cdb_result_col_blob
+func cdb_result_col_blob(prepareId C.int, colIdx C.int, result *unsafe.Pointer, length *C.int) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	r := p.Result.ResultRows[p.ResultIdx][int(colIdx)]
+	*result = nil
+	*length = C.int(0)
+	if r.IsNull() {
+		return C.int(0)
+	}
+	b := r.Blob()
+	*result = C.CBytes(b)
+	*length = C.int(len(b))
+	return C.int(0)
+}
+
+// cdb_result_col_is_null puts 1 in isNull when the current row at the 0 based
+// column index is NULL otherwise 0.
+//
+//export cdb_result_col_is_null
+func cdb_result_col_is_null(prepareId C.int, colIdx C.int, isNull *C.int) C.int {
+	*isNull = C.int(0)
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	if p.Result.ResultRows[p.ResultIdx][int(colIdx)].IsNull() {
+		*isNull = C.int(1)
+	}
 	return C.int(0)
 }
 
@@ -211,7 +450,7 @@ func cdb_result_col_string(prepareId C.int, colIdx C.int, result **C.char) C.int
 //
 //export cdb_result_col_count
 func cdb_result_col_count(prepareId C.int, result *C.int) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -225,7 +464,7 @@ func cdb_result_col_count(prepareId C.int, result *C.int) C.int {
 //
 //export cdb_result_col_name
 func cdb_result_col_name(prepareId C.int, colIdx C.int, result **C.char) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -249,7 +488,7 @@ func cdb_result_col_name(prepareId C.int, colIdx C.int, result **C.char) C.int {
 //
 //export cdb_result_col_type
 func cdb_result_col_type(prepareId C.int, colIdx C.int, result *C.int) C.int {
-	p, ok := _plans[int(prepareId)]
+	p, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -258,11 +497,63 @@ func cdb_result_col_type(prepareId C.int, colIdx C.int, result *C.int) C.int {
 	return C.int(0)
 }
 
+// cdb_result_col_database_name puts the name of the database the result column
+// is taken from in result for the given prepareId and colIdx. result is NULL
+// when the result column is not a table column.
+//
+//export cdb_result_col_database_name
+func cdb_result_col_database_name(prepareId C.int, colIdx C.int, result **C.char) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	*result = originCString(p.Result.ResultOrigins[colIdx].Database)
+	return C.int(0)
+}
+
+// cdb_result_col_table_name puts the name of the table the result column is
+// taken from in result for the given prepareId and colIdx. result is NULL when
+// the result column is not a table column.
+//
+//export cdb_result_col_table_name
+func cdb_result_col_table_name(prepareId C.int, colIdx C.int, result **C.char) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	*result = originCString(p.Result.ResultOrigins[colIdx].Table)
+	return C.int(0)
+}
+
+// cdb_result_col_origin_name puts the name of the table column the result
+// column is taken from in result for the given prepareId and colIdx. Unlike
+// cdb_result_col_name the origin name is not affected by an alias. result is
+// NULL when the result column is not a table column.
+//
+//export cdb_result_col_origin_name
+func cdb_result_col_origin_name(prepareId C.int, colIdx C.int, result **C.char) C.int {
+	p, ok := getPlan(prepareId)
+	if !ok {
+		return C.int(1)
+	}
+	*result = originCString(p.Result.ResultOrigins[colIdx].Column)
+	return C.int(0)
+}
+
+// originCString is name as a C string or NULL when name is empty since the
+// result column has no origin.
+func originCString(name string) *C.char {
+	if name == "" {
+		return nil
+	}
+	return C.CString(name)
+}
+
 // cdb_statement_type is the type of statement e.g. SELECT CREATE INSERT
 //
 //export cdb_statement_type
 func cdb_statement_type(prepareId C.int, result *C.int) C.int {
-	_, ok := _plans[int(prepareId)]
+	_, ok := getPlan(prepareId)
 	if !ok {
 		return C.int(1)
 	}
@@ -0,0 +1,181 @@
+// Package metrics holds counters describing the work done by a database. Every
+// database has a Registry which embedders can connect to expvar, Prometheus or
+// any other monitoring system without writing their own instrumentation.
+package metrics
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Counter is a monotonically increasing value that is safe for concurrent use.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc increments the counter by 1.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add increments the counter by n.
+func (c *Counter) Add(n int64) {
+	c.v.Add(n)
+}
+
+// Value returns the current value of the counter.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// Registry is the set of counters for a database.
+type Registry struct {
+	// StatementsExecuted is the number of statements executed.
+	StatementsExecuted Counter
+	// StatementErrors is the number of statements that returned an error.
+	StatementErrors Counter
+	// Recompiles is the number of times a statement was recompiled because
+	// the catalog changed while it was prepared.
+	Recompiles Counter
+	// PlanCacheHits is the number of statements executed with a cached plan
+	// rather than being compiled.
+	PlanCacheHits Counter
+	// StatsRefreshes is the number of times the statistics of a table were
+	// gathered again because its rows changed past a threshold since it was
+	// analyzed.
+	StatsRefreshes Counter
+	// ReadTransactions is the number of read transactions started.
+	ReadTransactions Counter
+	// WriteTransactions is the number of write transactions started.
+	WriteTransactions Counter
+	// Commits is the number of write transactions committed.
+	Commits Counter
+	// Rollbacks is the number of write transactions rolled back.
+	Rollbacks Counter
+	// CacheHits is the number of pages read from the page cache.
+	CacheHits Counter
+	// CacheMisses is the number of pages read from storage.
+	CacheMisses Counter
+	// CacheEvictions is the number of pages evicted from the page cache to
+	// make room for other pages. Many evictions suggest the cache is too small.
+	CacheEvictions Counter
+	// PagesReadAhead is the number of pages loaded into the page cache in the
+	// background ahead of a scan reaching them.
+	PagesReadAhead Counter
+	// PagesMapped is the number of pages read in place from a memory mapped
+	// database file rather than from the page cache or storage.
+	PagesMapped Counter
+	// RowCacheHits is the number of point lookups answered by the per
+	// transaction row cache without descending a b tree.
+	RowCacheHits Counter
+	// PagesWritten is the number of pages written to storage.
+	PagesWritten Counter
+	// PagesSpilled is the number of dirty pages moved out of memory because a
+	// write transaction changed more pages than the dirty page limit.
+	PagesSpilled Counter
+	// RowsRead is the number of tuples visited by cursors.
+	RowsRead Counter
+	// RowsWritten is the number of tuples inserted, updated or deleted by
+	// cursors.
+	RowsWritten Counter
+}
+
+// NewRegistry creates a registry with every counter at 0.
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+// counter is a counter with a name and description for exporting.
+type counter struct {
+	name    string
+	help    string
+	counter *Counter
+}
+
+func (r *Registry) counters() []counter {
+	return []counter{
+		{"statements_executed", "Statements executed.", &r.StatementsExecuted},
+		{"statement_errors", "Statements that returned an error.", &r.StatementErrors},
+		{"recompiles", "Statements recompiled because the catalog changed.", &r.Recompiles},
+		{"plan_cache_hits", "Statements executed with a cached plan.", &r.PlanCacheHits},
+		{"stats_refreshes", "Table statistics refreshed after rows changed.", &r.StatsRefreshes},
+		{"read_transactions", "Read transactions started.", &r.ReadTransactions},
+		{"write_transactions", "Write transactions started.", &r.WriteTransactions},
+		{"commits", "Write transactions committed.", &r.Commits},
+		{"rollbacks", "Write transactions rolled back.", &r.Rollbacks},
+		{"cache_hits", "Pages read from the page cache.", &r.CacheHits},
+		{"cache_misses", "Pages read from storage.", &r.CacheMisses},
+		{"cache_evictions", "Pages evicted from the page cache.", &r.CacheEvictions},
+		{"pages_read_ahead", "Pages loaded into the page cache ahead of a scan.", &r.PagesReadAhead},
+		{"pages_mapped", "Pages read in place from the mapped database file.", &r.PagesMapped},
+		{"row_cache_hits", "Point lookups answered by the row cache.", &r.RowCacheHits},
+		{"pages_written", "Pages written to storage.", &r.PagesWritten},
+		{"pages_spilled", "Dirty pages spilled out of memory.", &r.PagesSpilled},
+		{"rows_read", "Tuples visited by cursors.", &r.RowsRead},
+		{"rows_written", "Tuples inserted, updated or deleted by cursors.", &r.RowsWritten},
+	}
+}
+
+// Each calls fn with the name, description and current value of each counter.
+// Each is intended for adapting the registry to a monitoring system such as a
+// Prometheus collector.
+func (r *Registry) Each(fn func(name, help string, value int64)) {
+	for _, c := range r.counters() {
+		fn(c.name, c.help, c.counter.Value())
+	}
+}
+
+// Snapshot returns the current value of each counter keyed by name.
+func (r *Registry) Snapshot() map[string]int64 {
+	s := map[string]int64{}
+	r.Each(func(name, _ string, value int64) {
+		s[name] = value
+	})
+	return s
+}
+
+// CacheHitRate returns the ratio of page reads served by the page cache. It
+// returns 0 when no pages have been read.
+func (r *Registry) CacheHitRate() float64 {
+	hits := r.CacheHits.Value()
+	total := hits + r.CacheMisses.Value()
+	if total == 0 {
+		return 0
+	}
+	return float64(hits) / float64(total)
+}
+
+// PublishExpvar publishes a snapshot of the registry as the expvar variable
+// with name. Like expvar.Publish it panics if name is already registered.
+func (r *Registry) PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() any {
+		return r.Snapshot()
+	}))
+}
+
+// WritePrometheus writes the counters to w in the Prometheus text exposition
+// format. Each metric name is prefixed with namespace and an underscore and,
+// following the Prometheus naming convention for counters, suffixed with
+// _total. The statements_executed counter is cdb_statements_executed_total for
+// the namespace cdb.
+func (r *Registry) WritePrometheus(w io.Writer, namespace string) error {
+	var err error
+	r.Each(func(name, help string, value int64) {
+		if err != nil {
+			return
+		}
+		fullName := namespace + "_" + name + "_total"
+		_, err = fmt.Fprintf(
+			w,
+			"# HELP %s %s\n# TYPE %s counter\n%s %d\n",
+			fullName,
+			help,
+			fullName,
+			fullName,
+			value,
+		)
+	})
+	return err
+}
@@ -0,0 +1,76 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSnapshot(t *testing.T) {
+	r := NewRegistry()
+	r.StatementsExecuted.Inc()
+	r.StatementsExecuted.Inc()
+	r.RowsWritten.Add(5)
+	s := r.Snapshot()
+	if got := s["statements_executed"]; got != 2 {
+		t.Fatalf("expected 2 statements executed got %d", got)
+	}
+	if got := s["rows_written"]; got != 5 {
+		t.Fatalf("expected 5 rows written got %d", got)
+	}
+	if got := s["commits"]; got != 0 {
+		t.Fatalf("expected 0 commits got %d", got)
+	}
+}
+
+func TestCacheHitRate(t *testing.T) {
+	r := NewRegistry()
+	if got := r.CacheHitRate(); got != 0 {
+		t.Fatalf("expected 0 got %f", got)
+	}
+	r.CacheHits.Add(3)
+	r.CacheMisses.Inc()
+	if got := r.CacheHitRate(); got != 0.75 {
+		t.Fatalf("expected 0.75 got %f", got)
+	}
+}
+
+func TestWritePrometheus(t *testing.T) {
+	r := NewRegistry()
+	r.Commits.Add(7)
+	var buf bytes.Buffer
+	if err := r.WritePrometheus(&buf, "cdb"); err != nil {
+		t.Fatal(err)
+	}
+	want := "# HELP cdb_commits_total Write transactions committed.\n# TYPE cdb_commits_total counter\ncdb_commits_total 7\n"
+	if !strings.Contains(buf.String(), want) {
+		t.Fatalf("expected output to contain %q got %q", want, buf.String())
+	}
+
+	t.Run("every counter", func(t *testing.T) {
+		lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+		samples := 0
+		for _, line := range lines {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			samples += 1
+			name, _, _ := strings.Cut(line, " ")
+			if !strings.HasPrefix(name, "cdb_") || !strings.HasSuffix(name, "_total") {
+				t.Fatalf("expected cdb_ prefix and _total suffix got %s", name)
+			}
+		}
+		if want := len(r.Snapshot()); samples != want {
+			t.Fatalf("expected %d samples got %d", want, samples)
+		}
+		for _, sample := range []string{
+			"cdb_statements_executed_total 0",
+			"cdb_rows_written_total 0",
+			"cdb_pages_spilled_total 0",
+		} {
+			if !strings.Contains(buf.String(), "\n"+sample+"\n") {
+				t.Fatalf("expected output to contain %q got %q", sample, buf.String())
+			}
+		}
+	})
+}
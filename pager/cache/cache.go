@@ -9,21 +9,32 @@ type lruPageCache struct {
 	// list is ordered by the least recently used item at the 0th index of the
 	// list.
 	evictList []int
-	maxSize   int
+	// maxBytes is the most bytes the values in the cache can add up to.
+	maxBytes int
+	// size is the bytes of the values currently in the cache.
+	size int
+	// pinned are the keys that are never evicted. A pinned key is cached even
+	// when the cache is full so pinned values may exceed maxBytes.
+	pinned map[int]bool
 	// version maintains the "version" of the cache. When the version is
 	// incremented it invalidates the cache. When the version is checked and it
 	// is the same it means the cache is still valid.
 	version int
+	// onEvict is called each time a key is evicted to make room. onEvict may
+	// be nil.
+	onEvict func()
 }
 
-// NewLRU creates a LRU (least recently used) cache. This cache takes a maxSize
-// which determines how many items can be cached. When the maximum size of the
-// cache is exceeded, the least recently used item will be evicted.
-func NewLRU(maxSize, version int) *lruPageCache {
+// NewLRU creates a LRU (least recently used) cache. This cache takes a maxBytes
+// which determines how many bytes of values can be cached. When the maximum
+// bytes of the cache are exceeded, the least recently used items will be
+// evicted until the cache fits.
+func NewLRU(maxBytes, version int) *lruPageCache {
 	return &lruPageCache{
 		cache:     map[int][]byte{},
 		evictList: []int{},
-		maxSize:   maxSize,
+		maxBytes:  maxBytes,
+		pinned:    map[int]bool{},
 		version:   version,
 	}
 }
@@ -39,25 +50,31 @@ func (c *lruPageCache) Get(key int) (value []byte, hit bool) {
 }
 
 // Add adds the key to the cache and prioritizes it. If a collision occurs, the
-// key will be prioritized and the value will be updated.
+// key will be prioritized and the value will be updated. A value that does not
+// fit in the cache is not cached unless the key is pinned.
 func (c *lruPageCache) Add(key int, value []byte) {
-	if _, ok := c.cache[key]; ok {
-		c.prioritize(key)
-		c.cache[key] = value
-		return
-	}
-	if c.maxSize == len(c.cache) {
-		c.evict()
+	c.Remove(key)
+	if !c.pinned[key] {
+		if len(value) > c.maxBytes {
+			return
+		}
+		for c.size+len(value) > c.maxBytes {
+			if !c.evict() {
+				return
+			}
+		}
 	}
 	c.cache[key] = value
+	c.size += len(value)
 	c.evictList = append(c.evictList, key)
 }
 
 // Remove removes the key from the cache. If the key is not found it will be
 // ignored.
 func (c *lruPageCache) Remove(key int) {
-	if _, ok := c.cache[key]; ok {
+	if v, ok := c.cache[key]; ok {
 		delete(c.cache, key)
+		c.size -= len(v)
 		i := slices.Index(c.evictList, key)
 		c.evictList = slices.Delete(c.evictList, i, i+1)
 	}
@@ -71,6 +88,7 @@ func (c *lruPageCache) Validate(candidateVersion int) {
 	}
 	c.cache = map[int][]byte{}
 	c.evictList = []int{}
+	c.size = 0
 }
 
 // SetVersion sets the cache version. This can be updated after a write
@@ -80,13 +98,59 @@ func (c *lruPageCache) SetVersion(newVersion int) {
 	c.version = newVersion
 }
 
+// SetMaxBytes changes the most bytes the values in the cache can add up to. The
+// least recently used items are evicted until the cache fits.
+func (c *lruPageCache) SetMaxBytes(maxBytes int) {
+	c.maxBytes = maxBytes
+	for c.size > c.maxBytes && c.evict() {
+	}
+}
+
+// SetPinned replaces the pinned keys with keys. Pinned keys are never evicted
+// so values that are used constantly are not pushed out by a large scan. Keys
+// do not need to be cached to be pinned.
+func (c *lruPageCache) SetPinned(keys []int) {
+	c.pinned = map[int]bool{}
+	for _, key := range keys {
+		c.pinned[key] = true
+	}
+	for c.size > c.maxBytes && c.evict() {
+	}
+}
+
+// Size returns the bytes of the values currently in the cache.
+func (c *lruPageCache) Size() int {
+	return c.size
+}
+
+// MaxBytes returns the most bytes the values in the cache can add up to.
+func (c *lruPageCache) MaxBytes() int {
+	return c.maxBytes
+}
+
+// OnEvict sets the function called each time a key is evicted to make room in
+// the cache. Keys that are removed or invalidated are not evicted.
+func (c *lruPageCache) OnEvict(onEvict func()) {
+	c.onEvict = onEvict
+}
+
 func (c *lruPageCache) prioritize(key int) {
 	i := slices.Index(c.evictList, key)
 	c.evictList = append(slices.Delete(c.evictList, i, i+1), key)
 }
 
-func (c *lruPageCache) evict() {
-	evictKey := c.evictList[0]
-	c.evictList = c.evictList[1:]
-	delete(c.cache, evictKey)
+// evict removes the least recently used key that is not pinned. It returns
+// false when every key is pinned.
+func (c *lruPageCache) evict() bool {
+	i := slices.IndexFunc(c.evictList, func(key int) bool {
+		return !c.pinned[key]
+	})
+	if i == -1 {
+		return false
+	}
+	c.Remove(c.evictList[i])
+	if c.onEvict != nil {
+		c.onEvict()
+	}
+	return true
 }
@@ -53,3 +53,87 @@ func TestVersion(t *testing.T) {
 		t.Fatal("expected hit to be false")
 	}
 }
+
+func TestCacheBytes(t *testing.T) {
+	c := NewLRU(10, 0)
+	c.Add(1, make([]byte, 4))
+	c.Add(2, make([]byte, 4))
+	c.Get(1)
+	c.Add(3, make([]byte, 4))
+	if _, hit := c.Get(2); hit {
+		t.Fatal("expected 2 to be evicted")
+	}
+	if size := c.Size(); size != 8 {
+		t.Fatalf("expected size 8 got %d", size)
+	}
+
+	t.Run("replace", func(t *testing.T) {
+		c.Add(1, make([]byte, 6))
+		if size := c.Size(); size != 10 {
+			t.Fatalf("expected size 10 got %d", size)
+		}
+	})
+
+	t.Run("larger than max", func(t *testing.T) {
+		c.Add(4, make([]byte, 11))
+		if _, hit := c.Get(4); hit {
+			t.Fatal("expected 4 to not be cached")
+		}
+		if size := c.Size(); size != 10 {
+			t.Fatalf("expected size 10 got %d", size)
+		}
+	})
+
+	t.Run("shrink", func(t *testing.T) {
+		evictions := 0
+		c.OnEvict(func() { evictions += 1 })
+		c.SetMaxBytes(7)
+		if evictions != 1 {
+			t.Fatalf("expected 1 eviction got %d", evictions)
+		}
+		if got := c.MaxBytes(); got != 7 {
+			t.Fatalf("expected max bytes 7 got %d", got)
+		}
+		if _, hit := c.Get(3); hit {
+			t.Fatal("expected 3 to be evicted")
+		}
+		if size := c.Size(); size != 6 {
+			t.Fatalf("expected size 6 got %d", size)
+		}
+	})
+}
+
+func TestCachePinned(t *testing.T) {
+	c := NewLRU(2, 0)
+	c.SetPinned([]int{1})
+	c.Add(1, []byte{1})
+	c.Add(2, []byte{2})
+	c.Add(3, []byte{3})
+	if _, hit := c.Get(1); !hit {
+		t.Fatal("expected pinned 1 to not be evicted")
+	}
+	if _, hit := c.Get(2); hit {
+		t.Fatal("expected 2 to be evicted")
+	}
+
+	t.Run("pinned exceeds max", func(t *testing.T) {
+		c.SetPinned([]int{1, 3, 4})
+		c.Add(4, []byte{4})
+		for _, key := range []int{1, 3, 4} {
+			if _, hit := c.Get(key); !hit {
+				t.Fatalf("expected pinned %d to be cached", key)
+			}
+		}
+		c.Add(5, []byte{5})
+		if _, hit := c.Get(5); hit {
+			t.Fatal("expected 5 to not be cached")
+		}
+	})
+
+	t.Run("unpinned", func(t *testing.T) {
+		c.SetPinned([]int{})
+		if size := c.Size(); size != 2 {
+			t.Fatalf("expected size 2 got %d", size)
+		}
+	})
+}
@@ -0,0 +1,227 @@
+package cache
+
+import "slices"
+
+// recentFraction is the fraction of the cache the recent queue of a
+// twoQueuePageCache may fill before its keys are evicted first.
+const recentFraction = 4
+
+// twoQueuePageCache implements pageCache with the 2Q replacement policy. A key
+// added for the first time enters a small queue of recent keys which are
+// evicted first in first out. Only a key added again soon after it was evicted
+// from the recent queue enters the queue of frequent keys which are evicted
+// least recently used first. A scan adds each key once so it only churns the
+// recent queue and the frequent keys stay cached.
+type twoQueuePageCache struct {
+	cache map[int][]byte
+	// recent are the cached keys added once ordered by the oldest key at the
+	// 0th index of the list.
+	recent []int
+	// recentSize is the bytes of the values of the recent keys.
+	recentSize int
+	// frequent are the cached keys added again after being evicted ordered by
+	// the least recently used key at the 0th index of the list.
+	frequent []int
+	// ghosts are the keys most recently evicted from the recent queue ordered
+	// by the oldest key at the 0th index of the list. Their values are not
+	// cached. A ghost that is added again becomes a frequent key.
+	ghosts []int
+	// ghostSizes are the bytes the value of each ghost had.
+	ghostSizes map[int]int
+	// ghostSize is the bytes the values of the ghosts had. The ghosts are
+	// trimmed so ghostSize is at most half of maxBytes.
+	ghostSize int
+	// maxBytes is the most bytes the values in the cache can add up to.
+	maxBytes int
+	// size is the bytes of the values currently in the cache.
+	size int
+	// pinned are the keys that are never evicted. A pinned key is cached even
+	// when the cache is full so pinned values may exceed maxBytes.
+	pinned map[int]bool
+	// version is the "version" of the cache. See lruPageCache.
+	version int
+	// onEvict is called each time a key is evicted to make room. onEvict may
+	// be nil.
+	onEvict func()
+}
+
+// NewTwoQueue creates a 2Q cache. Like NewLRU the cache takes a maxBytes which
+// determines how many bytes of values can be cached. Unlike an LRU cache a key
+// must be added twice before it can push out keys that were added twice, which
+// keeps a large scan from evicting the keys used constantly.
+func NewTwoQueue(maxBytes, version int) *twoQueuePageCache {
+	return &twoQueuePageCache{
+		cache:      map[int][]byte{},
+		recent:     []int{},
+		frequent:   []int{},
+		ghosts:     []int{},
+		ghostSizes: map[int]int{},
+		maxBytes:   maxBytes,
+		pinned:     map[int]bool{},
+		version:    version,
+	}
+}
+
+// Get returns a bool indicating if the key was found and the value for the key.
+// A frequent key is prioritized. A recent key is not since being read many
+// times in a row, as a scan does, does not make a key frequent.
+func (c *twoQueuePageCache) Get(key int) (value []byte, hit bool) {
+	v, ok := c.cache[key]
+	if !ok {
+		return nil, false
+	}
+	if i := slices.Index(c.frequent, key); i != -1 {
+		c.frequent = append(slices.Delete(c.frequent, i, i+1), key)
+	}
+	return v, true
+}
+
+// Add adds the key to the cache. A key that is frequent or a ghost is added as
+// a frequent key and any other key is added as a recent key. A value that does
+// not fit in the cache is not cached unless the key is pinned.
+func (c *twoQueuePageCache) Add(key int, value []byte) {
+	frequent := slices.Contains(c.frequent, key)
+	if _, ok := c.ghostSizes[key]; ok {
+		frequent = true
+	}
+	c.Remove(key)
+	if !c.pinned[key] {
+		if len(value) > c.maxBytes {
+			return
+		}
+		for c.size+len(value) > c.maxBytes {
+			if !c.evict() {
+				return
+			}
+		}
+	}
+	c.cache[key] = value
+	c.size += len(value)
+	if frequent {
+		c.frequent = append(c.frequent, key)
+	} else {
+		c.recent = append(c.recent, key)
+		c.recentSize += len(value)
+	}
+}
+
+// Remove removes the key from the cache and forgets it was ever added. If the
+// key is not found it will be ignored.
+func (c *twoQueuePageCache) Remove(key int) {
+	if size, ok := c.ghostSizes[key]; ok {
+		i := slices.Index(c.ghosts, key)
+		c.ghosts = slices.Delete(c.ghosts, i, i+1)
+		delete(c.ghostSizes, key)
+		c.ghostSize -= size
+	}
+	v, ok := c.cache[key]
+	if !ok {
+		return
+	}
+	delete(c.cache, key)
+	c.size -= len(v)
+	if i := slices.Index(c.recent, key); i != -1 {
+		c.recent = slices.Delete(c.recent, i, i+1)
+		c.recentSize -= len(v)
+		return
+	}
+	i := slices.Index(c.frequent, key)
+	c.frequent = slices.Delete(c.frequent, i, i+1)
+}
+
+// Validate clears the cache if the candidateVersion doesn't match the cache
+// version. Otherwise the cache is left intact.
+func (c *twoQueuePageCache) Validate(candidateVersion int) {
+	if candidateVersion == c.version {
+		return
+	}
+	c.cache = map[int][]byte{}
+	c.recent = []int{}
+	c.recentSize = 0
+	c.frequent = []int{}
+	c.ghosts = []int{}
+	c.ghostSizes = map[int]int{}
+	c.ghostSize = 0
+	c.size = 0
+}
+
+// SetVersion sets the cache version. See lruPageCache.SetVersion.
+func (c *twoQueuePageCache) SetVersion(newVersion int) {
+	c.version = newVersion
+}
+
+// SetMaxBytes changes the most bytes the values in the cache can add up to.
+// Keys are evicted until the cache fits.
+func (c *twoQueuePageCache) SetMaxBytes(maxBytes int) {
+	c.maxBytes = maxBytes
+	for c.size > c.maxBytes && c.evict() {
+	}
+	c.trimGhosts()
+}
+
+// MaxBytes returns the most bytes the values in the cache can add up to.
+func (c *twoQueuePageCache) MaxBytes() int {
+	return c.maxBytes
+}
+
+// SetPinned replaces the pinned keys with keys. See lruPageCache.SetPinned.
+func (c *twoQueuePageCache) SetPinned(keys []int) {
+	c.pinned = map[int]bool{}
+	for _, key := range keys {
+		c.pinned[key] = true
+	}
+	for c.size > c.maxBytes && c.evict() {
+	}
+}
+
+// Size returns the bytes of the values currently in the cache.
+func (c *twoQueuePageCache) Size() int {
+	return c.size
+}
+
+// OnEvict sets the function called each time a key is evicted to make room in
+// the cache. Keys that are removed or invalidated are not evicted.
+func (c *twoQueuePageCache) OnEvict(onEvict func()) {
+	c.onEvict = onEvict
+}
+
+// evict removes the oldest recent key when the recent keys fill more than
+// their fraction of the cache and otherwise the least recently used frequent
+// key. A recent key that is evicted becomes a ghost. It returns false when
+// every key is pinned.
+func (c *twoQueuePageCache) evict() bool {
+	unpinned := func(key int) bool {
+		return !c.pinned[key]
+	}
+	r := slices.IndexFunc(c.recent, unpinned)
+	f := slices.IndexFunc(c.frequent, unpinned)
+	switch {
+	case r != -1 && (f == -1 || c.recentSize > c.maxBytes/recentFraction):
+		key := c.recent[r]
+		size := len(c.cache[key])
+		c.Remove(key)
+		c.ghosts = append(c.ghosts, key)
+		c.ghostSizes[key] = size
+		c.ghostSize += size
+		c.trimGhosts()
+	case f != -1:
+		c.Remove(c.frequent[f])
+	default:
+		return false
+	}
+	if c.onEvict != nil {
+		c.onEvict()
+	}
+	return true
+}
+
+// trimGhosts forgets the oldest ghosts until the values they had add up to at
+// most half of maxBytes.
+func (c *twoQueuePageCache) trimGhosts() {
+	for c.ghostSize > c.maxBytes/2 && len(c.ghosts) > 0 {
+		key := c.ghosts[0]
+		c.ghosts = c.ghosts[1:]
+		c.ghostSize -= c.ghostSizes[key]
+		delete(c.ghostSizes, key)
+	}
+}
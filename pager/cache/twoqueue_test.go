@@ -0,0 +1,99 @@
+package cache
+
+import "testing"
+
+func TestTwoQueue(t *testing.T) {
+	c := NewTwoQueue(8, 0)
+	c.Add(1, []byte{1})
+	for key := 2; key <= 9; key += 1 {
+		c.Add(key, []byte{byte(key)})
+	}
+	if _, hit := c.Get(1); hit {
+		t.Fatal("expected 1 to be evicted")
+	}
+	if _, ok := c.ghostSizes[1]; !ok {
+		t.Fatal("expected 1 to be a ghost")
+	}
+	c.Add(1, []byte{1})
+	if len(c.frequent) != 1 || c.frequent[0] != 1 {
+		t.Fatalf("expected 1 to be frequent got %v", c.frequent)
+	}
+
+	t.Run("scan does not evict frequent", func(t *testing.T) {
+		for key := 100; key < 200; key += 1 {
+			if _, hit := c.Get(key); !hit {
+				c.Add(key, []byte{byte(key)})
+			}
+		}
+		if _, hit := c.Get(1); !hit {
+			t.Fatal("expected frequent 1 to be cached after a scan")
+		}
+		if size := c.Size(); size != 8 {
+			t.Fatalf("expected size 8 got %d", size)
+		}
+		if c.ghostSize > 4 {
+			t.Fatalf("expected ghosts to be at most 4 bytes got %d", c.ghostSize)
+		}
+	})
+
+	t.Run("lru evicts after a scan", func(t *testing.T) {
+		lru := NewLRU(8, 0)
+		lru.Add(1, []byte{1})
+		lru.Get(1)
+		for key := 100; key < 200; key += 1 {
+			lru.Add(key, []byte{byte(key)})
+		}
+		if _, hit := lru.Get(1); hit {
+			t.Fatal("expected lru to evict 1 during a scan")
+		}
+	})
+
+	t.Run("remove forgets ghost", func(t *testing.T) {
+		ghost := c.ghosts[len(c.ghosts)-1]
+		c.Remove(ghost)
+		c.Add(ghost, []byte{0})
+		if len(c.frequent) != 1 {
+			t.Fatalf("expected removed ghost to be recent got frequent %v", c.frequent)
+		}
+	})
+
+	t.Run("validate", func(t *testing.T) {
+		c.SetVersion(1)
+		c.Validate(0)
+		if _, hit := c.Get(1); hit {
+			t.Fatal("expected 1 to be invalidated")
+		}
+		if c.Size() != 0 || len(c.ghosts) != 0 {
+			t.Fatalf("expected empty cache got size %d and %d ghosts", c.Size(), len(c.ghosts))
+		}
+	})
+}
+
+func TestTwoQueuePinned(t *testing.T) {
+	c := NewTwoQueue(2, 0)
+	evictions := 0
+	c.OnEvict(func() { evictions += 1 })
+	c.SetPinned([]int{1})
+	c.Add(1, []byte{1})
+	c.Add(2, []byte{2})
+	c.Add(3, []byte{3})
+	if _, hit := c.Get(1); !hit {
+		t.Fatal("expected pinned 1 to not be evicted")
+	}
+	if _, hit := c.Get(2); hit {
+		t.Fatal("expected 2 to be evicted")
+	}
+	if evictions != 1 {
+		t.Fatalf("expected 1 eviction got %d", evictions)
+	}
+
+	t.Run("shrink", func(t *testing.T) {
+		c.SetMaxBytes(1)
+		if _, hit := c.Get(3); hit {
+			t.Fatal("expected 3 to be evicted")
+		}
+		if size := c.Size(); size != 1 {
+			t.Fatalf("expected size 1 got %d", size)
+		}
+	})
+}
@@ -0,0 +1,220 @@
+package pager
+
+import (
+	"bytes"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"slices"
+)
+
+// ErrEncrypted is returned when opening an encrypted database without a key.
+var ErrEncrypted = errors.New("database is encrypted")
+
+// ErrNotEncrypted is returned when opening a database that is not encrypted
+// with a key or when rekeying it.
+var ErrNotEncrypted = errors.New("database is not encrypted")
+
+// ErrWrongKey is returned when opening an encrypted database with a key other
+// than the key it was encrypted with.
+var ErrWrongKey = errors.New("encryption key is wrong")
+
+// Encryption header constants. The header itself is not encrypted.
+const (
+	// encryptionSaltOffset is the offset of the random salt the keys of an
+	// encrypted database are derived with. The salt is all zeros when the
+	// database is not encrypted.
+	encryptionSaltOffset = freelistCountOffset + freelistCountSize
+	encryptionSaltSize   = 16
+	// keyCheckOffset is the offset of a value derived from the key and salt
+	// which tells whether a database is opened with the right key.
+	keyCheckOffset = encryptionSaltOffset + encryptionSaltSize
+	keyCheckSize   = 16
+)
+
+// encryptedStorage encrypts the pages written to and decrypts the pages read
+// from the storage it wraps. The file header is not encrypted. Since the
+// journal is made from the file, it holds encrypted pages as well. Pages are
+// encrypted with an xtsCipher derived from the key and a random salt kept in
+// the header.
+type encryptedStorage struct {
+	Storage
+	// key is the key the database is encrypted with.
+	key []byte
+	// current encrypts the pages of the file.
+	current *pageKey
+	// pending encrypts the pages written by a write transaction that is
+	// changing the key. It is nil when the key is not changing.
+	pending *pageKey
+	// buf is where a page is encrypted before it is written.
+	buf []byte
+}
+
+// pageKey is the cipher derived from a key and salt.
+type pageKey struct {
+	key    []byte
+	salt   []byte
+	check  []byte
+	cipher *xtsCipher
+}
+
+// NewEncryptedStorage returns storage that encrypts the pages of the database
+// stored by s with key. A new database is encrypted with key. An existing
+// database must have been encrypted with key otherwise ErrWrongKey is returned
+// when the storage is given to a pager. ErrNotEncrypted is returned instead
+// when the existing database is not encrypted. The key may be any length but
+// should be at least 32 random bytes.
+func NewEncryptedStorage(s Storage, key []byte) (Storage, error) {
+	if len(key) == 0 {
+		return nil, errors.New("encryption key is empty")
+	}
+	return &encryptedStorage{
+		Storage: s,
+		key:     bytes.Clone(key),
+		buf:     make([]byte, pageSize),
+	}, nil
+}
+
+// newPageKey derives the page key for key and salt.
+func newPageKey(key, salt []byte) (*pageKey, error) {
+	derived, err := hkdf.Key(sha256.New, key, salt, "cdb page key", xtsKeySize)
+	if err != nil {
+		return nil, err
+	}
+	check, err := hkdf.Key(sha256.New, key, salt, "cdb key check", keyCheckSize)
+	if err != nil {
+		return nil, err
+	}
+	c, err := newXTSCipher(derived)
+	if err != nil {
+		return nil, err
+	}
+	return &pageKey{key: key, salt: salt, check: check, cipher: c}, nil
+}
+
+// newSalt returns a random salt.
+func newSalt() []byte {
+	salt := make([]byte, encryptionSaltSize)
+	rand.Read(salt)
+	return salt
+}
+
+// loadKey derives the current page key from the salt in the header. A new
+// database is given a new salt which is written by the first commit. The key is
+// loaded once a hot journal is restored since the journal may restore the salt
+// of a key that was being changed.
+func (es *encryptedStorage) loadKey() error {
+	header := make([]byte, rootPageStart)
+	if err := readFull(es.Storage, header, 0); err != nil {
+		return err
+	}
+	if bytes.Equal(header, make([]byte, rootPageStart)) {
+		k, err := newPageKey(es.key, newSalt())
+		if err != nil {
+			return err
+		}
+		es.current = k
+		return nil
+	}
+	salt := header[encryptionSaltOffset : encryptionSaltOffset+encryptionSaltSize]
+	if bytes.Equal(salt, make([]byte, encryptionSaltSize)) {
+		return ErrNotEncrypted
+	}
+	k, err := newPageKey(es.key, bytes.Clone(salt))
+	if err != nil {
+		return err
+	}
+	check := header[keyCheckOffset : keyCheckOffset+keyCheckSize]
+	if subtle.ConstantTimeCompare(check, k.check) != 1 {
+		return ErrWrongKey
+	}
+	es.current = k
+	return nil
+}
+
+// pageNumber returns the number of the page at off. Pages must be read and
+// written whole.
+func (es *encryptedStorage) pageNumber(p []byte, off int64) (int, error) {
+	start := off - rootPageStart
+	if start%pageSize != 0 || len(p) != pageSize {
+		return 0, fmt.Errorf("encrypted storage cannot access %d bytes at %d", len(p), off)
+	}
+	return int(start/pageSize) + 1, nil
+}
+
+func (es *encryptedStorage) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) <= rootPageStart {
+		return es.Storage.ReadAt(p, off)
+	}
+	pageNumber, err := es.pageNumber(p, off)
+	if err != nil {
+		return 0, err
+	}
+	n, err := es.Storage.ReadAt(p, off)
+	// A page that was allocated but never written is all zeros.
+	if !slices.ContainsFunc(p, func(b byte) bool { return b != 0 }) {
+		return n, err
+	}
+	es.current.cipher.decrypt(p, p, uint64(pageNumber))
+	return n, err
+}
+
+func (es *encryptedStorage) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) <= rootPageStart {
+		return es.Storage.WriteAt(p, off)
+	}
+	pageNumber, err := es.pageNumber(p, off)
+	if err != nil {
+		return 0, err
+	}
+	k := es.current
+	if es.pending != nil {
+		k = es.pending
+	}
+	k.cipher.encrypt(es.buf, p, uint64(pageNumber))
+	return es.Storage.WriteAt(es.buf, off)
+}
+
+// writeKeyHeader writes the salt and key check of the key pages are written
+// with to the header.
+func (es *encryptedStorage) writeKeyHeader() error {
+	k := es.current
+	if es.pending != nil {
+		k = es.pending
+	}
+	b := append(bytes.Clone(k.salt), k.check...)
+	_, err := es.Storage.WriteAt(b, encryptionSaltOffset)
+	return err
+}
+
+// setPendingKey makes pages written from now on encrypted with key and a new
+// salt. Pages are still read with the current key since the file holds pages
+// encrypted with it until the write transaction commits.
+func (es *encryptedStorage) setPendingKey(key []byte) error {
+	k, err := newPageKey(bytes.Clone(key), newSalt())
+	if err != nil {
+		return err
+	}
+	es.pending = k
+	return nil
+}
+
+// applyPendingKey makes the pending key current once the write transaction
+// changing it commits.
+func (es *encryptedStorage) applyPendingKey() {
+	if es.pending == nil {
+		return
+	}
+	es.key = es.pending.key
+	es.current = es.pending
+	es.pending = nil
+}
+
+// discardPendingKey forgets the pending key when the write transaction changing
+// it rolls back.
+func (es *encryptedStorage) discardPendingKey() {
+	es.pending = nil
+}
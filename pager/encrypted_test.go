@@ -0,0 +1,269 @@
+package pager
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"testing"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	key := []byte("an encryption key of 32 bytes...")
+	secret := []byte("a secret value")
+	open := func(t *testing.T, name string, key []byte) (*Pager, error) {
+		t.Helper()
+		s, err := NewFileStorage(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if key == nil {
+			return NewWithStorage(s)
+		}
+		es, err := NewEncryptedStorage(s, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return NewWithStorage(es)
+	}
+	write := func(t *testing.T, pager *Pager, value []byte) {
+		t.Helper()
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		pager.GetPage(1).SetValue([]byte{1}, value)
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, pager *Pager) []byte {
+		t.Helper()
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		page, err := s.ReadPage(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, _ := page.GetValue([]byte{1})
+		return v
+	}
+	newEncrypted := func(t *testing.T) string {
+		name := t.TempDir() + "/encrypted"
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, pager, secret)
+		return name
+	}
+
+	t.Run("pages are encrypted", func(t *testing.T) {
+		name := newEncrypted(t)
+		b, err := os.ReadFile(getFileName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, secret) {
+			t.Fatal("expected file to not contain plaintext")
+		}
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, pager); !bytes.Equal(got, secret) {
+			t.Fatalf("expected %s got %s", secret, got)
+		}
+	})
+
+	t.Run("journal is encrypted", func(t *testing.T) {
+		name := newEncrypted(t)
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.store.CreateJournal([]int{1}); err != nil {
+			t.Fatal(err)
+		}
+		b, err := os.ReadFile(getJournalName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(b, secret) {
+			t.Fatal("expected journal to not contain plaintext")
+		}
+		if err := pager.store.DeleteJournal(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("wrong key", func(t *testing.T) {
+		name := newEncrypted(t)
+		if _, err := open(t, name, []byte("another key")); !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("expected %v got %v", ErrWrongKey, err)
+		}
+	})
+
+	t.Run("no key", func(t *testing.T) {
+		name := newEncrypted(t)
+		if _, err := open(t, name, nil); !errors.Is(err, ErrEncrypted) {
+			t.Fatalf("expected %v got %v", ErrEncrypted, err)
+		}
+	})
+
+	t.Run("key for plaintext database", func(t *testing.T) {
+		name := t.TempDir() + "/plaintext"
+		pager, err := open(t, name, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		write(t, pager, secret)
+		if _, err := open(t, name, key); !errors.Is(err, ErrNotEncrypted) {
+			t.Fatalf("expected %v got %v", ErrNotEncrypted, err)
+		}
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer pager.RollbackWrite()
+		if err := pager.Rekey(key); !errors.Is(err, ErrNotEncrypted) {
+			t.Fatalf("expected %v got %v", ErrNotEncrypted, err)
+		}
+	})
+
+	t.Run("tampered page is corrupt", func(t *testing.T) {
+		name := newEncrypted(t)
+		f, err := os.OpenFile(getFileName(name), os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteAt([]byte{0xff}, rootPageStart+10); err != nil {
+			t.Fatal(err)
+		}
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		if _, err := s.ReadPage(1); !errors.Is(err, ErrCorrupt) {
+			t.Fatalf("expected %v got %v", ErrCorrupt, err)
+		}
+	})
+
+	t.Run("rekey", func(t *testing.T) {
+		name := newEncrypted(t)
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		newKey := []byte("a new key")
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.Rekey(newKey); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, pager); !bytes.Equal(got, secret) {
+			t.Fatalf("expected %s got %s", secret, got)
+		}
+		if _, err := open(t, name, key); !errors.Is(err, ErrWrongKey) {
+			t.Fatalf("expected old key to be %v got %v", ErrWrongKey, err)
+		}
+		reopened, err := open(t, name, newKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, reopened); !bytes.Equal(got, secret) {
+			t.Fatalf("expected %s got %s", secret, got)
+		}
+	})
+
+	t.Run("crash while rekeying", func(t *testing.T) {
+		name := newEncrypted(t)
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The commit crashes after writing page 1 and the salt of the new key.
+		if err := pager.encrypted.setPendingKey([]byte("a new key")); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.store.CreateJournal([]int{1}); err != nil {
+			t.Fatal(err)
+		}
+		content := make([]byte, pageSize)
+		if err := pager.readPage(1, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.writePage(1, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.encrypted.writeKeyHeader(); err != nil {
+			t.Fatal(err)
+		}
+		reopened, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, reopened); !bytes.Equal(got, secret) {
+			t.Fatalf("expected %s got %s", secret, got)
+		}
+	})
+
+	t.Run("rekey rolled back", func(t *testing.T) {
+		name := newEncrypted(t)
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.Rekey([]byte("a new key")); err != nil {
+			t.Fatal(err)
+		}
+		pager.RollbackWrite()
+		write(t, pager, []byte("changed"))
+		reopened, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, reopened); string(got) != "changed" {
+			t.Fatalf("expected changed got %s", got)
+		}
+	})
+
+	t.Run("spilled pages are encrypted", func(t *testing.T) {
+		name := newEncrypted(t)
+		pager, err := open(t, name, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pager.SetDirtyPageLimit(2)
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		pager.GetPage(1)
+		for range 4 {
+			pager.NewPage()
+		}
+		if pager.spill == nil || pager.spill.cipher == nil {
+			t.Fatal("expected pages to be spilled encrypted")
+		}
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+		if got := read(t, pager); !bytes.Equal(got, secret) {
+			t.Fatalf("expected %s got %s", secret, got)
+		}
+	})
+}
@@ -1,20 +1,26 @@
 package pager
 
 import (
+	"errors"
 	"fmt"
 	"runtime"
 	"sync"
 	"syscall"
 )
 
-// lock is a RWMutex. When there is no file it is implemented by the memoryLock
+// Lock is a RWMutex. When there is no file it is implemented by the memoryLock
 // When there is a file it is implemented by the implementation returned from
 // newPlatformLock.
-type lock interface {
+type Lock interface {
 	Lock() error
 	Unlock()
 	RLock() error
 	RUnlock()
+	// TryLock is Lock without blocking. False is returned if the lock is held.
+	TryLock() (bool, error)
+	// TryRLock is RLock without blocking. False is returned if the lock is
+	// held by a writer.
+	TryRLock() (bool, error)
 }
 
 // memoryLock implements lock and is used when there is no file to lock.
@@ -40,9 +46,17 @@ func (m *memoryLock) RUnlock() {
 	m.l.RUnlock()
 }
 
-// newPlatformLock returns a lock interface implementation for the detected
+func (m *memoryLock) TryLock() (bool, error) {
+	return m.l.TryLock(), nil
+}
+
+func (m *memoryLock) TryRLock() (bool, error) {
+	return m.l.TryRLock(), nil
+}
+
+// newPlatformLock returns a Lock interface implementation for the detected
 // platform.
-func newPlatformLock(fd uintptr) lock {
+func newPlatformLock(fd uintptr) Lock {
 	if !(runtime.GOOS == "linux" || runtime.GOOS == "darwin") {
 		panic(fmt.Sprintf("file lock does not support %s", runtime.GOOS))
 	}
@@ -119,3 +133,39 @@ func (l *linuxOrDarwinLock) RUnlock() {
 	}
 	l.processLock.RUnlock()
 }
+
+func (l *linuxOrDarwinLock) TryLock() (bool, error) {
+	if !l.processLock.TryLock() {
+		return false, nil
+	}
+	err := syscall.Flock(
+		l.fileDescriptor,
+		syscall.LOCK_EX|syscall.LOCK_NB,
+	)
+	if err != nil {
+		l.processLock.Unlock()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("err LOCK_EX file: %w", err)
+	}
+	return true, nil
+}
+
+func (l *linuxOrDarwinLock) TryRLock() (bool, error) {
+	if !l.processLock.TryRLock() {
+		return false, nil
+	}
+	err := syscall.Flock(
+		l.fileDescriptor,
+		syscall.LOCK_SH|syscall.LOCK_NB,
+	)
+	if err != nil {
+		l.processLock.RUnlock()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return false, nil
+		}
+		return false, fmt.Errorf("err LOCK_SH file: %w", err)
+	}
+	return true, nil
+}
@@ -0,0 +1,72 @@
+package pager
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"runtime/debug"
+	"strconv"
+	"strings"
+)
+
+const (
+	// pageCacheMemoryFraction is the fraction of available memory the page
+	// cache defaults to. For example 64 means 1/64th of available memory.
+	pageCacheMemoryFraction = 64
+	// minPageCacheMemory is the fewest bytes the page cache defaults to.
+	minPageCacheMemory = 256 * pageSize
+	// maxPageCacheMemory is the most bytes the page cache defaults to.
+	maxPageCacheMemory = 256 << 20
+	// fallbackPageCacheMemory is the bytes the page cache defaults to when the
+	// available memory cannot be determined.
+	fallbackPageCacheMemory = 1000 * pageSize
+)
+
+// defaultPageCacheMemory returns the byte budget of the page cache derived from
+// the memory available to the process.
+func defaultPageCacheMemory() int {
+	available, ok := availableMemory()
+	if !ok {
+		return fallbackPageCacheMemory
+	}
+	return min(max(available/pageCacheMemoryFraction, minPageCacheMemory), maxPageCacheMemory)
+}
+
+// availableMemory returns the bytes of memory available to the process. This is
+// the smaller of the memory the system reports as available and the Go memory
+// limit. ok is false when neither is known.
+func availableMemory() (available int, ok bool) {
+	available = math.MaxInt
+	if limit := debug.SetMemoryLimit(-1); limit != math.MaxInt64 {
+		available = int(limit)
+		ok = true
+	}
+	if system, sok := systemAvailableMemory(); sok {
+		available = min(available, system)
+		ok = true
+	}
+	return available, ok
+}
+
+// systemAvailableMemory returns MemAvailable from /proc/meminfo. ok is false
+// on systems without /proc/meminfo.
+func systemAvailableMemory() (available int, ok bool) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return 0, false
+		}
+		return kb * 1024, true
+	}
+	return 0, false
+}
@@ -0,0 +1,194 @@
+package pager
+
+import (
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// pageMapper is implemented by storage that can return the committed pages of
+// the database file in place rather than copying them into a buffer. Every
+// method must be called with the mu of the pager held.
+type pageMapper interface {
+	// mapPage returns the page with pageNumber in place. The page must not be
+	// modified. False is returned when the page cannot be mapped. version is
+	// the version of the pager.
+	mapPage(pageNumber, version int) ([]byte, bool)
+	// freeze keeps the pages with pageNumbers as they are for the snapshots
+	// that read them in place before a commit at version overwrites them.
+	freeze(pageNumbers []int, version int) error
+	// release unmaps what only snapshots before version were able to read.
+	release(version int)
+}
+
+// mmapStorage is file storage that maps the database file into memory so
+// snapshots read pages in place without copying them. Writes still go through
+// the file. The operating system keeps a shared mapping in sync with the file
+// so a snapshot holding a mapped page would see a commit overwrite it. To keep
+// snapshots consistent the pages a commit is about to overwrite are frozen in
+// every mapping a snapshot may be reading, by replacing them with a copy, and
+// the next read maps the file again. A mapping is unmapped once the snapshots
+// that were able to read it have ended.
+type mmapStorage struct {
+	*fileStorage
+	// current is the mapping new reads use. It is nil until the first read and
+	// after a commit retires it.
+	current *mapping
+	// retired are the mappings snapshots in progress may still be reading.
+	retired []*mapping
+	// frozen holds the copies of frozen pages. It is nil until the first page
+	// is frozen and is emptied once every retired mapping is unmapped.
+	frozen *os.File
+	// frozenSize is the size of frozen.
+	frozenSize int64
+}
+
+// mapping is a read only mapping of the database file.
+type mapping struct {
+	data []byte
+	// frozen are the offsets of the pages of the operating system in data
+	// that were replaced by a copy of their content.
+	frozen map[int]bool
+	// version is the version of the pager when the mapping was retired.
+	version int
+}
+
+// NewMmapStorage returns storage backed by the database file with filename like
+// NewFileStorage. Read transactions read pages directly from a memory mapping
+// of the file instead of copying each page they read into a buffer. The page
+// cache is not used for those reads since the operating system caches the
+// mapped file.
+func NewMmapStorage(filename string) (Storage, error) {
+	s, err := NewFileStorage(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &mmapStorage{fileStorage: s.(*fileStorage)}, nil
+}
+
+func (s *mmapStorage) mapPage(pageNumber, version int) ([]byte, bool) {
+	off := rootPageStart + (pageNumber-1)*pageSize
+	end := off + pageSize
+	if s.current == nil || end > len(s.current.data) {
+		if err := s.remap(version); err != nil {
+			return nil, false
+		}
+	}
+	if s.current == nil || end > len(s.current.data) {
+		return nil, false
+	}
+	return s.current.data[off:end:end], true
+}
+
+// remap maps the file again when it has grown since it was mapped. The previous
+// mapping is retired since snapshots may be reading it.
+func (s *mmapStorage) remap(version int) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return err
+	}
+	size := int(info.Size())
+	if size == 0 || (s.current != nil && size <= len(s.current.data)) {
+		return nil
+	}
+	data, err := unix.Mmap(int(s.file.Fd()), 0, size, unix.PROT_READ, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("error mapping db file %w", err)
+	}
+	s.retire(version)
+	s.current = &mapping{data: data, frozen: map[int]bool{}}
+	return nil
+}
+
+// retire retires the current mapping at version.
+func (s *mmapStorage) retire(version int) {
+	if s.current == nil {
+		return
+	}
+	s.current.version = version
+	s.retired = append(s.retired, s.current)
+	s.current = nil
+}
+
+func (s *mmapStorage) freeze(pageNumbers []int, version int) error {
+	s.retire(version)
+	for _, m := range s.retired {
+		for _, pageNumber := range pageNumbers {
+			if err := s.freezePage(m, rootPageStart+(pageNumber-1)*pageSize); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// freezePage freezes the pages of the operating system in m which hold the
+// page at off of the database file. The pages of the operating system may not
+// line up with the pages of the database but freezing the neighbouring pages
+// as well does no harm since they are frozen as they were before the commit.
+func (s *mmapStorage) freezePage(m *mapping, off int) error {
+	osPageSize := os.Getpagesize()
+	end := min(off+pageSize, len(m.data))
+	for start := off / osPageSize * osPageSize; start < end; start += osPageSize {
+		if m.frozen[start] {
+			continue
+		}
+		if err := s.freezeOSPage(m, start, osPageSize); err != nil {
+			return err
+		}
+		m.frozen[start] = true
+	}
+	return nil
+}
+
+// freezeOSPage copies the page of the operating system at start of m to the
+// frozen file and maps the copy over the page. Mapping the copy over the page
+// replaces it at once so a snapshot reading it never sees it half frozen.
+func (s *mmapStorage) freezeOSPage(m *mapping, start, osPageSize int) error {
+	if s.frozen == nil {
+		f, err := os.CreateTemp("", "cdb-frozen-*")
+		if err != nil {
+			return fmt.Errorf("error creating frozen page file %w", err)
+		}
+		os.Remove(f.Name())
+		s.frozen = f
+	}
+	page := make([]byte, osPageSize)
+	copy(page, m.data[start:])
+	if _, err := s.frozen.WriteAt(page, s.frozenSize); err != nil {
+		return fmt.Errorf("error writing frozen page %w", err)
+	}
+	_, err := unix.MmapPtr(
+		int(s.frozen.Fd()),
+		s.frozenSize,
+		unsafe.Pointer(&m.data[start]),
+		uintptr(osPageSize),
+		unix.PROT_READ,
+		unix.MAP_SHARED|unix.MAP_FIXED,
+	)
+	if err != nil {
+		return fmt.Errorf("error mapping frozen page %w", err)
+	}
+	s.frozenSize += int64(osPageSize)
+	return nil
+}
+
+func (s *mmapStorage) release(version int) {
+	retired := s.retired[:0]
+	for _, m := range s.retired {
+		if m.version >= version {
+			retired = append(retired, m)
+			continue
+		}
+		unix.Munmap(m.data)
+	}
+	clear(s.retired[len(retired):])
+	s.retired = retired
+	if len(s.retired) == 0 && s.frozen != nil {
+		s.frozen.Close()
+		s.frozen = nil
+		s.frozenSize = 0
+	}
+}
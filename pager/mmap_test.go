@@ -0,0 +1,86 @@
+package pager
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMmapStorage(t *testing.T) {
+	s, err := NewMmapStorage(t.TempDir() + "/mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager, err := NewWithStorage(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	write := func(t *testing.T, pageNumber int, value string) {
+		t.Helper()
+		if err := pager.BeginWrite(ctx); err != nil {
+			t.Fatal(err)
+		}
+		for pager.FreelistCount() == 0 && pager.currentMaxPage < pageNumber {
+			pager.NewPage()
+		}
+		pager.GetPage(pageNumber).SetValue([]byte{1}, []byte(value))
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	read := func(t *testing.T, page *Page, want string) {
+		t.Helper()
+		if v, _ := page.GetValue([]byte{1}); string(v) != want {
+			t.Fatalf("expected %s got %s", want, v)
+		}
+	}
+	write(t, 1, "a")
+	write(t, 2, "a")
+
+	old, err := pager.BeginRead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mapped := pager.GetMetrics().PagesMapped.Value()
+	oldPage := old.GetPage(1)
+	read(t, oldPage, "a")
+	if got := pager.GetMetrics().PagesMapped.Value(); got != mapped+1 {
+		t.Fatalf("expected page to be mapped got %d mapped pages", got-mapped)
+	}
+
+	t.Run("commit freezes pages held by a snapshot", func(t *testing.T) {
+		write(t, 1, "b")
+		read(t, oldPage, "a")
+		read(t, old.GetPage(1), "a")
+		current, err := pager.BeginRead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(current)
+		read(t, current.GetPage(1), "b")
+		read(t, current.GetPage(2), "a")
+	})
+
+	t.Run("file growth is mapped", func(t *testing.T) {
+		write(t, 20, "c")
+		current, err := pager.BeginRead(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(current)
+		mapped := pager.GetMetrics().PagesMapped.Value()
+		read(t, current.GetPage(20), "c")
+		if got := pager.GetMetrics().PagesMapped.Value(); got != mapped+1 {
+			t.Fatal("expected new page to be mapped")
+		}
+		read(t, oldPage, "a")
+	})
+
+	t.Run("mappings are released", func(t *testing.T) {
+		pager.EndRead(old)
+		ms := s.(*mmapStorage)
+		if len(ms.retired) != 0 || ms.frozen != nil {
+			t.Fatalf("expected retired mappings to be released got %d", len(ms.retired))
+		}
+	})
+}
@@ -5,10 +5,19 @@ package pager
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/chirst/cdb/logging"
+	"github.com/chirst/cdb/metrics"
 	"github.com/chirst/cdb/pager/cache"
 )
 
@@ -31,32 +40,112 @@ const (
 	// If the database file is called cdb.db a journal will be called
 	// cdb-journal.db
 	journalSuffix = "-journal"
+	// lockSuffix is the suffix of the filename of the file locked by write
+	// transactions. If the database file is called cdb.db the file will be
+	// called cdb-lock.db
+	lockSuffix = "-lock"
 	// DefaultDBFileName is the default name of the file the database uses. The
 	// file extension is .db.
 	DefaultDBFileName = "cdb"
-	// pageCacheSize is maximum amount of pages that can be cached in memory.
-	pageCacheSize = 1000
+	// minLockPollInterval is the first wait between attempts to acquire a
+	// lock that is held.
+	minLockPollInterval = time.Millisecond
+	// maxLockPollInterval is the longest wait between attempts to acquire a
+	// lock that is held.
+	maxLockPollInterval = 50 * time.Millisecond
 )
 
-// File header constants
+// ErrBusy is returned when a lock on the database file cannot be acquired
+// before the context of the transaction is done.
+var ErrBusy = errors.New("database is busy")
+
+// ErrCorrupt is matched by every CorruptionError with errors.Is.
+var ErrCorrupt = errors.New("database is corrupt")
+
+// CorruptionError is the err of reading a page that is not what was written to
+// it, for example because the file was damaged outside of cdb.
+type CorruptionError struct {
+	// PageNumber is the number of the corrupt page.
+	PageNumber int
+	// Reason describes how the page is corrupt.
+	Reason string
+}
+
+func (e *CorruptionError) Error() string {
+	return fmt.Sprintf("%s: page %d %s", ErrCorrupt, e.PageNumber, e.Reason)
+}
+
+func (e *CorruptionError) Unwrap() error {
+	return ErrCorrupt
+}
+
+// ErrNotDatabase is returned when opening a file that is not a cdb database.
+var ErrNotDatabase = errors.New("file is not a database")
+
+// ErrIncompatibleFormat is returned when opening a database written in a format
+// this version of cdb cannot read.
+var ErrIncompatibleFormat = errors.New("database file format is not supported")
+
+// File header constants. The header of a new database is all zeros until the
+// first write transaction commits.
 const (
-	// freePageCounterOffset is in the first position of the file header. It
-	// stores the last allocated page.
-	freePageCounterOffset = 0
+	// fileMagic is in the first position of the file header. It identifies the
+	// file as a cdb database.
+	fileMagic       = "cdb database\x00\x00\x00\x00"
+	fileMagicOffset = 0
+	fileMagicSize   = 16
+	// fileFormatVersionOffset is the offset of the version of the file format.
+	// The version is a uint32 that changes when the format of the file
+	// changes in a way older versions cannot read.
+	fileFormatVersionOffset = fileMagicOffset + fileMagicSize
+	fileFormatVersionSize   = 4
+	// fileFormatVersion is the version of the file format written by this
+	// version of cdb. Version 1 stores a page number and a subtree count in
+	// each pointer of an internal page. Version 2 added page checksums.
+	// Version 3 changed the encoding of index keys so integers and floats are
+	// ordered by value. Files written before the header had a format version
+	// store only a page number in pointers. They have no magic so they are
+	// rejected with ErrNotDatabase rather than misread.
+	fileFormatVersion = 3
+	// pageSizeOffset is the offset of the page size the file was written with.
+	// The size is a uint32.
+	pageSizeOffset = fileFormatVersionOffset + fileFormatVersionSize
+	pageSizeSize   = 4
+	// freePageCounterOffset is the offset of the free page counter. It stores
+	// the last allocated page.
+	freePageCounterOffset = pageSizeOffset + pageSizeSize
 	// freePageCounterSize is a uint32 and must match the size of the page
 	// pointer size.
 	freePageCounterSize = 4
 	// fileChangeCounterOffset is the offset of the file change counter.
-	fileChangeCounterOffset = 4
+	fileChangeCounterOffset = freePageCounterOffset + freePageCounterSize
 	// fileChangeCounterSize is a uint32 since the counter needs to be
 	// reasonably big to guarantee uniqueness.
 	fileChangeCounterSize = 4
+	// schemaCookieOffset is the offset of the schema cookie which is a uint32
+	// that increases each time a write transaction changing the schema
+	// commits.
+	schemaCookieOffset = fileChangeCounterOffset + fileChangeCounterSize
+	schemaCookieSize   = 4
+	// freelistHeadOffset is the offset of the number of the first page of the
+	// freelist which is a uint32. The freelist holds pages that were freed so
+	// they are allocated again before the file grows. It is 0 when the
+	// freelist is empty.
+	freelistHeadOffset = schemaCookieOffset + schemaCookieSize
+	freelistHeadSize   = 4
+	// freelistCountOffset is the offset of the number of pages on the
+	// freelist which is a uint32.
+	freelistCountOffset = freelistHeadOffset + freelistHeadSize
+	freelistCountSize   = 4
 	// rootPageStart marks the end of the file header. Unused space is reserved
 	// for future header additions since changing the size of the header breaks
 	// existing files.
 	rootPageStart = 100
 )
 
+// PageSize is the byte size of every page of the database file.
+const PageSize = pageSize
+
 // Page constants
 const (
 	// pageSize is the byte size of a single page. This size is used to
@@ -73,7 +162,10 @@ const (
 	// pageTypeInternal is a page representing a B tree internal node.
 	pageTypeInternal = 1
 	// pageTypeLeaf is a page representing a B tree leaf.
-	pageTypeLeaf   = 2
+	pageTypeLeaf = 2
+	// pageTypeFree is a page on the freelist. The right pointer of a free page
+	// is the next page of the freelist.
+	pageTypeFree   = 3
 	pageTypeOffset = 0
 	// pageTypeSize is a uint8
 	pageTypeSize = 1
@@ -92,8 +184,14 @@ const (
 	pageRowOffsetsOffset = pageRecordCountOffset + pageRecordCountSize
 	// pageRowOffsetSize is a uint16 that is the size of each offset.
 	pageRowOffsetSize = 2
+	// pageChecksumOffset is the offset of the checksum of a page which is the
+	// last uint32 of the page. Tuples end where the checksum begins.
+	pageChecksumOffset = pageSize - pageChecksumSize
+	pageChecksumSize   = 4
 	// emptyParentPageNumber is a reserved number to indicate no parent.
 	emptyParentPageNumber = 0
+	// readAheadPages is the most leaves Snapshot.ReadAhead loads at once.
+	readAheadPages = 8
 )
 
 // pageCache defines the page caching interface.
@@ -103,57 +201,316 @@ type pageCache interface {
 	Remove(int)
 	Validate(int)
 	SetVersion(int)
+	SetMaxBytes(int)
+	MaxBytes() int
+	SetPinned([]int)
+	Size() int
+	OnEvict(func())
+}
+
+// CachePolicy is the replacement policy of the page cache which chooses the
+// pages evicted when the cache is full.
+type CachePolicy int
+
+const (
+	// CachePolicyLRU evicts the least recently used page. It is the default.
+	CachePolicyLRU CachePolicy = iota
+	// CachePolicy2Q evicts the pages read once before the pages read again so
+	// a scan of a large table does not evict the index and inner pages that
+	// are read constantly.
+	CachePolicy2Q
+)
+
+// newPageCache returns an empty page cache using policy.
+func newPageCache(policy CachePolicy, maxBytes, version int) pageCache {
+	if policy == CachePolicy2Q {
+		return cache.NewTwoQueue(maxBytes, version)
+	}
+	return cache.NewLRU(maxBytes, version)
 }
 
 // Pager is an abstraction of the database file. Pager handles efficiently
 // accessing the file in a thread safe manner and atomically writing to the
 // file.
+//
+// There is one write transaction at a time and any number of read
+// transactions. Read transactions are snapshots so within a process readers do
+// not wait for a writer and a writer does not wait for readers. Across
+// processes a writer waits for the readers of other processes before it
+// commits.
 type Pager struct {
 	// store implements storage and is typically a file, but also can be an in
 	// memory representation for testing purposes.
-	store storage
+	store Storage
 	// currentMaxPage is a counter that holds the last allocated page number.
 	currentMaxPage int
+	// freelistHead and freelistCount are the first page and the number of
+	// pages of the freelist as changed by the write transaction.
+	freelistHead  int
+	freelistCount int
+	// schemaCookie is the schema cookie as changed by the write transaction.
+	schemaCookie int
 	// isWriting is a helper flag that is true when a writer has acquired a
 	// lock. This enables functions distributing pages to the kv layer to mark
 	// the pages as dirty so the pages can be flushed to disk before the write
-	// lock is released.
-	isWriting bool
+	// lock is released. isWriting is atomic so TransactionState can be called
+	// from any goroutine.
+	isWriting atomic.Bool
+	// readers is the number of read transactions in progress.
+	readers atomic.Int64
 	// dirtyPages is a list of pages that need to be flushed to disk in order
-	// for a write to be considered complete.
-	// TODO dirtyPages will eventually stack up. Need to have a mechanism to
-	// flush them once they reach a certain limit.
+	// for a write to be considered complete. Dirty pages are copies only seen
+	// by the write transaction.
 	dirtyPages []*Page
+	// dirtyPageLimit is the most dirty pages a write transaction keeps in
+	// memory before spilling the oldest of them to a temporary file. 0 is no
+	// limit.
+	dirtyPageLimit atomic.Int64
+	// spill holds the dirty pages spilled by the write transaction. It is nil
+	// until the first page is spilled.
+	spill *spillFile
+	// dirtyPageCount is the length of dirtyPages for TransactionState.
+	dirtyPageCount atomic.Int64
+	// writeErr is the first CorruptionError found by the write transaction or
+	// the first error spilling a dirty page.
+	writeErr error
+	// lockMu serializes acquiring and releasing the lock of the store.
+	lockMu sync.Mutex
+	// lockHolders is the number of read transactions sharing the read lock of
+	// the store. The lock is acquired by the first and released by the last so
+	// a commit can exchange it for the write lock without waiting for them.
+	lockHolders int
+	// mu guards pageCache, pinnedPages, version, snapshots and history which
+	// are shared by every transaction.
+	mu sync.Mutex
 	// pageCache caches frequently used pages to reduce expensive reads from
-	// the filesystem.
+	// the filesystem. Cached pages are never modified.
 	pageCache pageCache
+	// pinnedPages are the pages given to PinPages which are kept pinned when
+	// the page cache is replaced by SetCachePolicy.
+	pinnedPages []int
+	// version is the number of write transactions committed by the pager. A
+	// snapshot reads pages as they were at the version it began with.
+	version int
+	// snapshots is the number of snapshots in progress at each version.
+	snapshots map[int]int
+	// history holds the content pages had before they were changed by a
+	// commit. history[v] holds the pages changed by the commit that made
+	// version v+1 as they were at version v. history[v] is kept while there is
+	// a snapshot at version v or earlier.
+	history map[int]map[int][]byte
+	// mapped is the store when it can return pages in place. Snapshots read
+	// pages from it rather than the page cache. It is nil for other storage.
+	mapped pageMapper
+	// encrypted is the store when it encrypts pages. It is nil for other
+	// storage.
+	encrypted *encryptedStorage
+	// metrics counts the work done by the pager and the layers above it.
+	metrics *metrics.Registry
+	// logger reports the work done by the pager and the layers above it.
+	logger logging.Logger
 }
 
 // New creates a new pager. The useMemory flag means the database will not
 // create a file or persist changes to disk. This is useful for testing
 // purposes.
 func New(useMemory bool, filename string) (*Pager, error) {
-	var s storage
-	var err error
 	if useMemory {
-		s = newMemoryStorage()
-	} else {
-		s, err = newFileStorage(filename)
+		return NewWithStorage(NewMemoryStorage())
 	}
+	s, err := NewFileStorage(filename)
 	if err != nil {
 		return nil, err
 	}
+	return NewWithStorage(s)
+}
+
+// NewWithStorage creates a new pager on top of s. When a commit did not finish,
+// for example because the process crashed, the hot journal it left behind is
+// restored before the pager is returned.
+func NewWithStorage(s Storage) (*Pager, error) {
+	if err := rollbackHotJournal(s); err != nil {
+		return nil, err
+	}
+	if es, ok := s.(*encryptedStorage); ok {
+		if err := es.loadKey(); err != nil {
+			return nil, err
+		}
+	} else if err := validateFileHeader(s); err != nil {
+		return nil, err
+	}
 	p := &Pager{
 		store:          s,
 		currentMaxPage: allocateFreePageCounter(s),
 		dirtyPages:     []*Page{},
-		pageCache:      cache.NewLRU(pageCacheSize, readFileChangeCounter(s)),
+		pageCache:      newPageCache(CachePolicyLRU, defaultPageCacheMemory(), readFileChangeCounter(s)),
+		snapshots:      map[int]int{},
+		history:        map[int]map[int][]byte{},
+		metrics:        metrics.NewRegistry(),
+		logger:         logging.Nop(),
 	}
+	p.pageCache.OnEvict(p.metrics.CacheEvictions.Inc)
+	p.mapped, _ = s.(pageMapper)
+	p.encrypted, _ = s.(*encryptedStorage)
 	return p, nil
 }
 
+// NewEphemeral creates a pager for pages that are only held in memory and never
+// committed. The pager is always in a write transaction so every page is a
+// dirty page until RollbackWrite discards them. It has no page cache of its own
+// since no page is ever read from storage.
+func NewEphemeral() *Pager {
+	p := &Pager{
+		store:      NewMemoryStorage(),
+		dirtyPages: []*Page{},
+		pageCache:  cache.NewLRU(0, 0),
+		snapshots:  map[int]int{},
+		history:    map[int]map[int][]byte{},
+		metrics:    metrics.NewRegistry(),
+		logger:     logging.Nop(),
+	}
+	// Nothing else uses the storage so the lock is always free.
+	p.BeginWrite(context.Background())
+	return p
+}
+
+// rollbackHotJournal restores the journal of s when it is hot. A journal is
+// only hot when the reserved lock is free since otherwise it belongs to a
+// write transaction of another process that is committing.
+func rollbackHotJournal(s Storage) error {
+	ok, err := s.GetReservedLock().TryLock()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	defer s.GetReservedLock().Unlock()
+	exists, err := s.JournalExists()
+	if err != nil || !exists {
+		return err
+	}
+	// Readers of other processes are waited for so they do not read pages
+	// while they are restored.
+	if err := s.GetLock().Lock(); err != nil {
+		return err
+	}
+	defer s.GetLock().Unlock()
+	if err := s.RestoreJournal(); err != nil {
+		return fmt.Errorf("error rolling back hot journal: %w", err)
+	}
+	return nil
+}
+
+// SetCacheMemory sets the most bytes of pages the page cache holds. By default
+// the page cache holds a fraction of the memory available to the process. When
+// the cache holds more than bytes the least recently used pages are evicted.
+func (p *Pager) SetCacheMemory(bytes int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pageCache.SetMaxBytes(bytes)
+}
+
+// MaxCacheMemory returns the most bytes of pages the page cache holds.
+func (p *Pager) MaxCacheMemory() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pageCache.MaxBytes()
+}
+
+// PinPages replaces the pages that are never evicted from the page cache with
+// pageNumbers. Pinned pages stay cached even when they exceed the memory of the
+// cache set by SetCacheMemory. The file header is not a page and is always read
+// from storage.
+func (p *Pager) PinPages(pageNumbers []int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.pinnedPages = pageNumbers
+	p.pageCache.SetPinned(pageNumbers)
+}
+
+// SetCachePolicy replaces the page cache with an empty cache using policy. The
+// memory of the cache and the pinned pages are kept.
+func (p *Pager) SetCachePolicy(policy CachePolicy) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	c := newPageCache(policy, p.pageCache.MaxBytes(), readFileChangeCounter(p.store))
+	c.SetPinned(p.pinnedPages)
+	c.OnEvict(p.metrics.CacheEvictions.Inc)
+	p.pageCache = c
+}
+
+// CacheMemory returns the bytes of pages currently held by the page cache.
+func (p *Pager) CacheMemory() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.pageCache.Size()
+}
+
+// GetMetrics returns the metrics registry of the pager.
+func (p *Pager) GetMetrics() *metrics.Registry {
+	return p.metrics
+}
+
+// GetLogger returns the logger of the pager.
+func (p *Pager) GetLogger() logging.Logger {
+	return p.logger
+}
+
+// SetLogger replaces the logger of the pager.
+func (p *Pager) SetLogger(logger logging.Logger) {
+	p.logger = logger
+}
+
+// validateFileHeader returns ErrNotDatabase when the header of s does not have
+// the magic of a cdb database and ErrIncompatibleFormat when the database was
+// written with a format version or page size this version cannot read. A header
+// of all zeros is a new database.
+func validateFileHeader(s Storage) error {
+	header := make([]byte, rootPageStart)
+	if err := readFull(s, header, 0); err != nil {
+		return err
+	}
+	if bytes.Equal(header, make([]byte, rootPageStart)) {
+		return nil
+	}
+	if string(header[fileMagicOffset:fileFormatVersionOffset]) != fileMagic {
+		return ErrNotDatabase
+	}
+	version := binary.LittleEndian.Uint32(header[fileFormatVersionOffset:])
+	if version != fileFormatVersion {
+		return fmt.Errorf("%w: format version %d", ErrIncompatibleFormat, version)
+	}
+	size := binary.LittleEndian.Uint32(header[pageSizeOffset:])
+	if size != pageSize {
+		return fmt.Errorf("%w: page size %d", ErrIncompatibleFormat, size)
+	}
+	salt := header[encryptionSaltOffset : encryptionSaltOffset+encryptionSaltSize]
+	if !bytes.Equal(salt, make([]byte, encryptionSaltSize)) {
+		return ErrEncrypted
+	}
+	return nil
+}
+
+// writeFileFormat writes the magic, format version and page size to the file
+// header when the database is new.
+func (p *Pager) writeFileFormat() error {
+	b := make([]byte, freePageCounterOffset)
+	if err := readFull(p.store, b[:fileFormatVersionOffset], fileMagicOffset); err != nil {
+		return err
+	}
+	if string(b[:fileFormatVersionOffset]) == fileMagic {
+		return nil
+	}
+	copy(b[fileMagicOffset:], fileMagic)
+	binary.LittleEndian.PutUint32(b[fileFormatVersionOffset:], fileFormatVersion)
+	binary.LittleEndian.PutUint32(b[pageSizeOffset:], pageSize)
+	_, err := p.store.WriteAt(b, fileMagicOffset)
+	return err
+}
+
 // Read the free page counter from the file header.
-func allocateFreePageCounter(s storage) int {
+func allocateFreePageCounter(s Storage) int {
 	fb := make([]byte, freePageCounterSize)
 	s.ReadAt(fb, freePageCounterOffset)
 	fpc := int(binary.LittleEndian.Uint32(fb))
@@ -166,169 +523,938 @@ func allocateFreePageCounter(s storage) int {
 }
 
 // Write the free page counter to the file header.
-func (p *Pager) writeFreePageCounter() {
+func (p *Pager) writeFreePageCounter() error {
 	fb := make([]byte, freePageCounterSize)
 	binary.LittleEndian.PutUint32(fb, uint32(p.currentMaxPage))
-	p.store.WriteAt(fb, freePageCounterOffset)
+	_, err := p.store.WriteAt(fb, freePageCounterOffset)
+	return err
+}
+
+// readFreelist reads the first page and the number of pages of the freelist
+// from the file header.
+func readFreelist(s Storage) (head, count int) {
+	b := make([]byte, freelistHeadSize+freelistCountSize)
+	s.ReadAt(b, freelistHeadOffset)
+	head = int(binary.LittleEndian.Uint32(b))
+	count = int(binary.LittleEndian.Uint32(b[freelistHeadSize:]))
+	return head, count
+}
+
+// writeFreelist writes the first page and the number of pages of the freelist
+// to the file header.
+func (p *Pager) writeFreelist() error {
+	b := make([]byte, freelistHeadSize+freelistCountSize)
+	binary.LittleEndian.PutUint32(b, uint32(p.freelistHead))
+	binary.LittleEndian.PutUint32(b[freelistHeadSize:], uint32(p.freelistCount))
+	_, err := p.store.WriteAt(b, freelistHeadOffset)
+	return err
+}
+
+// readSchemaCookie reads the schema cookie from the file header.
+func readSchemaCookie(s Storage) int {
+	b := make([]byte, schemaCookieSize)
+	s.ReadAt(b, schemaCookieOffset)
+	return int(binary.LittleEndian.Uint32(b))
+}
+
+// writeSchemaCookie writes the schema cookie to the file header.
+func (p *Pager) writeSchemaCookie() error {
+	b := binary.LittleEndian.AppendUint32(nil, uint32(p.schemaCookie))
+	_, err := p.store.WriteAt(b, schemaCookieOffset)
+	return err
+}
+
+// SchemaCookie returns the schema cookie of the database file. The cookie is
+// changed by every write transaction that changes the schema so a process can
+// tell whether the schema changed without reading it. During a write
+// transaction the cookie as changed by the transaction is returned. Otherwise
+// the cookie can be changed by another process so it should only be read
+// within a transaction.
+func (p *Pager) SchemaCookie() int {
+	if p.isWriting.Load() {
+		return p.schemaCookie
+	}
+	return readSchemaCookie(p.store)
+}
+
+// SetSchemaCookie sets the schema cookie written when the write transaction in
+// progress commits.
+func (p *Pager) SetSchemaCookie(cookie int) {
+	p.schemaCookie = int(uint32(cookie))
 }
 
 // readFileChangeCounter reads the current file change version. The counter is
 // incremented by 1 each time the database file changes. This means the counter
 // can be used to invalidate the page cache to prevent dirty reads caused by
 // other processes changing the database file.
-func readFileChangeCounter(s storage) int {
+func readFileChangeCounter(s Storage) int {
 	b := make([]byte, fileChangeCounterSize)
 	s.ReadAt(b, fileChangeCounterOffset)
 	return int(binary.LittleEndian.Uint32(b))
 }
 
+// FileChangeCounter returns the file change counter of the database file. The
+// counter can be changed by another process unless a transaction is held so it
+// should only be read within a transaction.
+func (p *Pager) FileChangeCounter() int {
+	return readFileChangeCounter(p.store)
+}
+
+// NextFileChangeCounter returns what the file change counter will be after a
+// write transaction ends when the counter is currently counter.
+func NextFileChangeCounter(counter int) int {
+	return int(uint32(counter + 1))
+}
+
 // incrementFileChangeCounter increments the change counter by 1. When the
 // change counter reaches the maximum uint32 the number is truncated and starts
 // back over at 0. The possibility of getting the same number from this counter
 // is not likely to ever happen, but it is funny to think about.
-func (p *Pager) incrementFileChangeCounter() {
-	currentCount := readFileChangeCounter(p.store)
-	newCount := uint32(currentCount + 1)
+func (p *Pager) incrementFileChangeCounter() error {
+	newCount := uint32(NextFileChangeCounter(readFileChangeCounter(p.store)))
 	b := make([]byte, fileChangeCounterSize)
 	binary.LittleEndian.PutUint32(b, newCount)
-	p.store.WriteAt(b, fileChangeCounterOffset)
+	if _, err := p.store.WriteAt(b, fileChangeCounterOffset); err != nil {
+		return err
+	}
 	// Because incrementFileChangeCounter is called within the write process
 	// that invalidates dirty pages from the cache it can be assumed the cache
 	// version can be updated. This allows any cached pages surviving the write
 	// to continue to be cached.
 	p.pageCache.SetVersion(int(newCount))
+	return nil
+}
+
+// TransactionState is the kind of transaction in progress on a pager.
+type TransactionState int
+
+const (
+	// TransactionNone is when no transaction is in progress.
+	TransactionNone TransactionState = iota
+	// TransactionRead is when one or more read transactions are in progress.
+	TransactionRead
+	// TransactionWrite is when a write transaction is in progress.
+	TransactionWrite
+)
+
+func (s TransactionState) String() string {
+	switch s {
+	case TransactionRead:
+		return "read"
+	case TransactionWrite:
+		return "write"
+	}
+	return "none"
 }
 
-// BeginRead starts a read transaction. Other readers will be able to access the
-// database file.
-func (p *Pager) BeginRead() error {
-	err := p.store.GetLock().RLock()
+// TransactionState returns the kind of transaction in progress and the number
+// of dirty pages the transaction will write when it ends. A write transaction
+// takes precedence over read transactions. TransactionState is safe to call
+// from any goroutine.
+func (p *Pager) TransactionState() (state TransactionState, dirtyPages int) {
+	if p.isWriting.Load() {
+		return TransactionWrite, int(p.dirtyPageCount.Load())
+	}
+	if p.readers.Load() > 0 {
+		return TransactionRead, 0
+	}
+	return TransactionNone, 0
+}
+
+// Snapshot is a read transaction. A snapshot reads pages as they were when it
+// began even after a write transaction commits changes to them.
+type Snapshot struct {
+	pager *Pager
+	// version is the version of the pager when the snapshot began.
+	version int
+	// fileChangeCounter is the file change counter when the snapshot began.
+	fileChangeCounter int
+	// schemaCookie is the schema cookie when the snapshot began.
+	schemaCookie int
+	// maxPage is the last allocated page when the snapshot began.
+	maxPage int
+	// freelistHead and freelistCount are the first page and the number of
+	// pages of the freelist when the snapshot began.
+	freelistHead  int
+	freelistCount int
+	// err is the first CorruptionError found by GetPage.
+	err error
+	// readingAhead is true while ReadAhead loads pages in the background.
+	readingAhead atomic.Bool
+	// readAhead is done once ReadAhead has stopped loading pages so EndRead
+	// can wait for it before releasing the read lock.
+	readAhead sync.WaitGroup
+}
+
+// BeginRead starts a read transaction and returns its snapshot. Other readers
+// and the writer of the pager are able to access the database file while the
+// snapshot is in progress. The writers of other processes wait for the
+// snapshot to end before they commit. If ctx is done before the lock is
+// acquired ErrBusy is returned.
+func (p *Pager) BeginRead(ctx context.Context) (*Snapshot, error) {
+	if err := p.holdReadLock(ctx); err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	s := &Snapshot{
+		pager:             p,
+		version:           p.version,
+		fileChangeCounter: readFileChangeCounter(p.store),
+		schemaCookie:      readSchemaCookie(p.store),
+		maxPage:           allocateFreePageCounter(p.store),
+	}
+	s.freelistHead, s.freelistCount = readFreelist(p.store)
+	p.snapshots[s.version] += 1
+	p.mu.Unlock()
+	p.readers.Add(1)
+	p.metrics.ReadTransactions.Inc()
+	return s, nil
+}
+
+// EndRead ends the read transaction of s.
+func (p *Pager) EndRead(s *Snapshot) {
+	s.readAhead.Wait()
+	p.mu.Lock()
+	p.snapshots[s.version] -= 1
+	if p.snapshots[s.version] == 0 {
+		delete(p.snapshots, s.version)
+	}
+	p.pruneHistory()
+	p.mu.Unlock()
+	p.readers.Add(-1)
+	p.releaseReadLock()
+}
+
+// holdReadLock acquires the read lock of the store unless another read
+// transaction of the pager already holds it. The page cache is validated when
+// the lock is acquired since another process may have changed the file.
+func (p *Pager) holdReadLock(ctx context.Context) error {
+	err := acquireLock(
+		ctx,
+		func() error {
+			p.lockMu.Lock()
+			return nil
+		},
+		func() (bool, error) {
+			return p.lockMu.TryLock(), nil
+		},
+	)
 	if err != nil {
 		return err
 	}
-	p.pageCache.Validate(readFileChangeCounter(p.store))
+	defer p.lockMu.Unlock()
+	if p.lockHolders == 0 {
+		l := p.store.GetLock()
+		if err := acquireLock(ctx, l.RLock, l.TryRLock); err != nil {
+			return err
+		}
+		p.mu.Lock()
+		p.pageCache.Validate(readFileChangeCounter(p.store))
+		p.mu.Unlock()
+	}
+	p.lockHolders += 1
 	return nil
 }
 
-// EndRead ends a read transaction.
-func (p *Pager) EndRead() {
-	p.store.GetLock().RUnlock()
+// releaseReadLock releases the read lock of the store once the last read
+// transaction holding it has ended.
+func (p *Pager) releaseReadLock() {
+	p.lockMu.Lock()
+	defer p.lockMu.Unlock()
+	p.lockHolders -= 1
+	if p.lockHolders == 0 {
+		p.store.GetLock().RUnlock()
+	}
 }
 
-// BeginWrite starts a write transaction. If there are active readers this will
-// go into a pending state. When there is a pending writer no new readers will
-// be able to acquire a lock. Once all of the readers have finished this will
-// acquire exclusive access to the database file.
-func (p *Pager) BeginWrite() error {
-	err := p.store.GetLock().Lock()
+// pruneHistory forgets the pages in history no snapshot in progress can read.
+// pruneHistory must be called with mu held.
+func (p *Pager) pruneHistory() {
+	oldest := p.version
+	for v := range p.snapshots {
+		oldest = min(oldest, v)
+	}
+	for v := range p.history {
+		if v < oldest {
+			delete(p.history, v)
+		}
+	}
+	if p.mapped != nil {
+		if len(p.snapshots) == 0 {
+			oldest = p.version + 1
+		}
+		p.mapped.release(oldest)
+	}
+}
+
+// FileChangeCounter returns the file change counter of the database file when
+// the snapshot began.
+func (s *Snapshot) FileChangeCounter() int {
+	return s.fileChangeCounter
+}
+
+// SchemaCookie returns the schema cookie of the database file when the snapshot
+// began.
+func (s *Snapshot) SchemaCookie() int {
+	return s.schemaCookie
+}
+
+// MaxPage returns the last allocated page when the snapshot began.
+func (s *Snapshot) MaxPage() int {
+	return s.maxPage
+}
+
+// Freelist returns the first page and the number of pages of the freelist when
+// the snapshot began. The next page of the freelist is the right page of a
+// free page.
+func (s *Snapshot) Freelist() (head, count int) {
+	return s.freelistHead, s.freelistCount
+}
+
+// Err returns the first CorruptionError found by GetPage. Pages read after a
+// corrupt page may disagree with it so work done with the snapshot is not to be
+// trusted when Err is not nil.
+func (s *Snapshot) Err() error {
+	return s.err
+}
+
+// GetPage returns an allocated page as it was when the snapshot began. A page
+// changed by a commit since then is read from the history of the pager.
+// Otherwise GetPage returns the committed page which may be cached. When the
+// page is corrupt an empty page is returned and the error is kept for Err.
+func (s *Snapshot) GetPage(pageNumber int) *Page {
+	page, err := s.ReadPage(pageNumber)
+	if err != nil && s.err == nil {
+		s.err = err
+	}
+	return page
+}
+
+// ReadPage is GetPage returning the CorruptionError of a corrupt page rather
+// than keeping it for Err.
+func (s *Snapshot) ReadPage(pageNumber int) (*Page, error) {
+	p := s.pager
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for v := s.version; v < p.version; v += 1 {
+		if content, ok := p.history[v][pageNumber]; ok {
+			return p.allocatePage(pageNumber, content), nil
+		}
+	}
+	if p.mapped != nil {
+		if page, ok, err := p.getMappedPage(pageNumber); ok {
+			return page, err
+		}
+	}
+	return p.getCommittedPage(pageNumber)
+}
+
+// ReadAhead loads the leaf with pageNumber and the leaves following it through
+// their right pointers into the page cache in the background so a scan moving
+// right finds them cached rather than waiting on storage for each. At most
+// readAheadPages are loaded. Nothing is loaded while the snapshot is already
+// reading ahead or when pages are read in place from a mapped store.
+func (s *Snapshot) ReadAhead(pageNumber int) {
+	p := s.pager
+	if p.mapped != nil || !s.readingAhead.CompareAndSwap(false, true) {
+		return
+	}
+	s.readAhead.Add(1)
+	go func() {
+		defer s.readAhead.Done()
+		defer s.readingAhead.Store(false)
+		for range readAheadPages {
+			if pageNumber < 1 || pageNumber > s.maxPage {
+				return
+			}
+			pageNumber = p.readAhead(pageNumber)
+		}
+	}()
+}
+
+// readAhead loads the committed page with pageNumber into the page cache and
+// returns the page to its right. 0 is returned when the page is not a leaf or
+// is corrupt. The page is loaded while holding mu, like getCommittedPage, so a
+// commit cannot change the page between reading and caching it.
+func (p *Pager) readAhead(pageNumber int) int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	content, hit := p.pageCache.Get(pageNumber)
+	if !hit {
+		content = getPageBuffer()
+		if err := p.readPage(pageNumber, content); err != nil {
+			pageBufferPool.Put((*[pageSize]byte)(content))
+			return 0
+		}
+		p.pageCache.Add(pageNumber, content)
+		p.metrics.PagesReadAhead.Inc()
+	}
+	page := Page{content: content, number: pageNumber}
+	if !page.IsLeaf() {
+		return 0
+	}
+	_, right := page.GetRightPageNumber()
+	return right
+}
+
+// getMappedPage returns the most recently committed page in place from the
+// mapped store. False is returned when the page is not mapped or has no type,
+// since allocatePage sets the type of such a page and a mapped page cannot be
+// modified. getMappedPage must be called with mu held.
+func (p *Pager) getMappedPage(pageNumber int) (page *Page, mapped bool, err error) {
+	content, ok := p.mapped.mapPage(pageNumber, p.version)
+	if !ok || content[pageTypeOffset] == pageTypeUnknown {
+		return nil, false, nil
+	}
+	p.metrics.PagesMapped.Inc()
+	if err := verifyPage(pageNumber, content); err != nil {
+		return p.allocatePage(pageNumber, getPageBuffer()), true, err
+	}
+	return p.allocatePage(pageNumber, content), true, nil
+}
+
+// BeginWrite starts a write transaction. If another write transaction is in
+// progress, including one of another process, this waits for it to end. Read
+// transactions do not delay a write transaction from beginning. If ctx is done
+// before the lock is acquired ErrBusy is returned.
+func (p *Pager) BeginWrite(ctx context.Context) error {
+	l := p.store.GetReservedLock()
+	err := acquireLock(ctx, l.Lock, l.TryLock)
 	if err != nil {
 		return err
 	}
+	// Another process may have committed since this pager last wrote. Nothing
+	// else can commit while the reserved lock is held so the header is safe to
+	// read.
+	p.mu.Lock()
 	p.pageCache.Validate(readFileChangeCounter(p.store))
-	p.isWriting = true
+	p.mu.Unlock()
+	p.currentMaxPage = allocateFreePageCounter(p.store)
+	p.freelistHead, p.freelistCount = readFreelist(p.store)
+	p.schemaCookie = readSchemaCookie(p.store)
+	p.writeErr = nil
+	p.isWriting.Store(true)
+	p.metrics.WriteTransactions.Inc()
 	return nil
 }
 
-// EndWrite creates a copy of the database called a journal. EndWrite proceeds
-// to write pages to disk and removes the journal after all pages have been
-// written. If there is a crash while the pages are being written the journal
-// will be promoted to the main database file the next time the db is started.
-// This enables the database to write atomically.
+// WriteErr returns the first CorruptionError found by the write transaction in
+// progress. A write transaction that found a corrupt page or failed to spill a
+// dirty page cannot commit.
+func (p *Pager) WriteErr() error {
+	if p.writeErr == nil && p.spill != nil {
+		return p.spill.err
+	}
+	return p.writeErr
+}
+
+// acquireLock acquires a lock with lock when ctx can never be done. Otherwise
+// tryLock is polled with a growing interval until the lock is acquired or ctx
+// is done. Polling rather than blocking means a statement waiting on a lock
+// can give up.
+func acquireLock(ctx context.Context, lock func() error, tryLock func() (bool, error)) error {
+	if ctx.Done() == nil {
+		return lock()
+	}
+	wait := minLockPollInterval
+	for {
+		ok, err := tryLock()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return fmt.Errorf("%w: %w", ErrBusy, ctx.Err())
+		case <-t.C:
+		}
+		wait = min(wait*2, maxLockPollInterval)
+	}
+}
+
+// EndWrite copies the pages it is about to change to a file called a journal.
+// EndWrite proceeds to write pages to disk and removes the journal after all
+// pages have been written. If there is a crash while the pages are being
+// written the journal will be copied back to the database file the next time
+// the db is started. This enables the database to write atomically. Only the
+// changed pages are copied so the cost of a commit is proportional to the size
+// of the write rather than the size of the database.
+//
+// The snapshots in progress keep reading the pages as they were before the
+// commit. The readers of other processes are waited for before pages are
+// written.
+//
+// If writing fails the pages already written are restored from the journal,
+// the error is returned and the write transaction remains open so it can be
+// rolled back with RollbackWrite. The same is true when the write transaction
+// read a corrupt page.
 func (p *Pager) EndWrite() error {
-	if !p.isWriting {
+	if !p.isWriting.Load() {
 		return nil
 	}
-	if err := p.store.CreateJournal(); err != nil {
+	if err := p.WriteErr(); err != nil {
+		return err
+	}
+	if err := p.commit(); err != nil {
 		return err
 	}
+	p.isWriting.Store(false)
+	p.store.GetReservedLock().Unlock()
+	p.metrics.Commits.Inc()
+	return nil
+}
+
+// commit writes the dirty pages to storage while holding the write lock of the
+// store.
+func (p *Pager) commit() error {
+	if err := p.lockForCommit(); err != nil {
+		p.logger.Error("failed to lock database for commit", "err", err)
+		return err
+	}
+	defer p.unlockAfterCommit()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err := p.store.CreateJournal(p.dirtyPageNumbers()); err != nil {
+		p.logger.Error("failed to create journal", "err", err)
+		return err
+	}
+	p.logger.Debug("committing write transaction", "pages", len(p.dirtyPages))
+	p.keepHistory()
+	if p.mapped != nil && len(p.snapshots) > 0 {
+		if err := p.mapped.freeze(p.dirtyPageNumbers(), p.version); err != nil {
+			p.logger.Error("failed to freeze mapped pages", "err", err)
+			p.restoreJournal()
+			return err
+		}
+	}
+	p.version += 1
+	// Spilled pages are written from a single buffer rather than read back
+	// into memory all at once.
+	var spilled []byte
 	for _, fp := range p.dirtyPages {
-		p.writePage(fp)
+		content := fp.content
+		if fp.spill != nil {
+			if spilled == nil {
+				spilled = make([]byte, pageSize)
+			}
+			fp.spill.read(fp.number, spilled)
+			if err := fp.spill.err; err != nil {
+				p.logger.Error("failed to read spilled page", "page", fp.GetNumber(), "err", err)
+				p.restoreJournal()
+				return err
+			}
+			content = spilled
+		}
+		if err := p.writePage(fp.number, content); err != nil {
+			p.logger.Error("failed to write page", "page", fp.GetNumber(), "err", err)
+			p.restoreJournal()
+			return err
+		}
 		p.pageCache.Remove(fp.GetNumber())
 	}
-	p.dirtyPages = []*Page{}
-	p.writeFreePageCounter()
-	p.incrementFileChangeCounter()
+	if err := p.writeFileFormat(); err != nil {
+		p.logger.Error("failed to write file format", "err", err)
+		p.restoreJournal()
+		return err
+	}
+	if p.encrypted != nil {
+		if err := p.encrypted.writeKeyHeader(); err != nil {
+			p.logger.Error("failed to write encryption key", "err", err)
+			p.restoreJournal()
+			return err
+		}
+	}
+	if err := p.writeFreePageCounter(); err != nil {
+		p.logger.Error("failed to write free page counter", "err", err)
+		p.restoreJournal()
+		return err
+	}
+	if err := p.writeFreelist(); err != nil {
+		p.logger.Error("failed to write freelist", "err", err)
+		p.restoreJournal()
+		return err
+	}
+	if err := p.writeSchemaCookie(); err != nil {
+		p.logger.Error("failed to write schema cookie", "err", err)
+		p.restoreJournal()
+		return err
+	}
+	if err := p.incrementFileChangeCounter(); err != nil {
+		p.logger.Error("failed to write file change counter", "err", err)
+		p.restoreJournal()
+		return err
+	}
 	if err := p.store.DeleteJournal(); err != nil {
-		// TODO what can be done to gracefully handle a journal deletion failure
+		p.logger.Error("failed to delete journal", "err", err)
+		p.restoreJournal()
 		return err
 	}
-	p.isWriting = false
-	p.store.GetLock().Unlock()
+	if p.encrypted != nil {
+		p.encrypted.applyPendingKey()
+	}
+	p.metrics.PagesWritten.Add(int64(len(p.dirtyPages)))
+	releasePages(p.dirtyPages)
+	p.clearDirtyPages()
 	return nil
 }
 
+// dirtyPageNumbers returns the page numbers of dirtyPages.
+func (p *Pager) dirtyPageNumbers() []int {
+	pageNumbers := make([]int, len(p.dirtyPages))
+	for i, dp := range p.dirtyPages {
+		pageNumbers[i] = dp.number
+	}
+	return pageNumbers
+}
+
+// restoreJournal undoes the pages a failed commit has written. The pages of the
+// cache are still as they were before the commit since a written page is
+// removed from the cache. When the journal cannot be restored it is left for
+// the next pager opening the database to restore. restoreJournal must be
+// called with the write lock of the store held.
+func (p *Pager) restoreJournal() {
+	if err := p.store.RestoreJournal(); err != nil {
+		p.logger.Error("failed to restore journal", "err", err)
+	}
+}
+
+// lockForCommit exchanges the read lock shared by the read transactions of the
+// pager, if there are any, for the write lock of the store. This only waits
+// for the readers of other processes. Nothing else can change the file between
+// releasing the read lock and acquiring the write lock since the reserved lock
+// is held.
+func (p *Pager) lockForCommit() error {
+	p.lockMu.Lock()
+	l := p.store.GetLock()
+	if p.lockHolders > 0 {
+		l.RUnlock()
+	}
+	if err := l.Lock(); err != nil {
+		p.restoreReadLock()
+		p.lockMu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// unlockAfterCommit exchanges the write lock of the store for the read lock
+// when read transactions of the pager are still in progress.
+func (p *Pager) unlockAfterCommit() {
+	p.store.GetLock().Unlock()
+	p.restoreReadLock()
+	p.lockMu.Unlock()
+}
+
+// restoreReadLock acquires the read lock of the store again for the read
+// transactions of the pager. No writer can hold the lock since the reserved
+// lock is held so acquiring it only fails when the lock itself fails.
+func (p *Pager) restoreReadLock() {
+	if p.lockHolders == 0 {
+		return
+	}
+	if err := p.store.GetLock().RLock(); err != nil {
+		p.logger.Error("failed to restore read lock", "err", err)
+	}
+}
+
+// keepHistory records the dirty pages as they were before the commit so the
+// snapshots in progress keep reading them. keepHistory must be called with mu
+// held before any page is written.
+func (p *Pager) keepHistory() {
+	if len(p.snapshots) == 0 {
+		return
+	}
+	pages := make(map[int][]byte, len(p.dirtyPages))
+	for _, dp := range p.dirtyPages {
+		if content, hit := p.pageCache.Get(dp.number); hit {
+			pages[dp.number] = content
+			continue
+		}
+		// The buffer is not from the pool since a snapshot may read it for as
+		// long as it likes.
+		content := make([]byte, pageSize)
+		p.store.ReadAt(content, int64(rootPageStart+(dp.number-1)*pageSize))
+		pages[dp.number] = content
+	}
+	p.history[p.version] = pages
+}
+
 // RollbackWrite ends a write transaction without committing the changes to
 // storage.
 func (p *Pager) RollbackWrite() {
-	if !p.isWriting {
+	if !p.isWriting.Load() {
 		return
 	}
-	p.dirtyPages = []*Page{}
-	allocateFreePageCounter(p.store)
-	p.isWriting = false
-	p.store.GetLock().Unlock()
+	p.logger.Debug("rolling back write transaction", "pages", len(p.dirtyPages))
+	releasePages(p.dirtyPages)
+	p.clearDirtyPages()
+	if p.encrypted != nil {
+		p.encrypted.discardPendingKey()
+	}
+	p.currentMaxPage = allocateFreePageCounter(p.store)
+	p.freelistHead, p.freelistCount = readFreelist(p.store)
+	p.schemaCookie = readSchemaCookie(p.store)
+	p.isWriting.Store(false)
+	p.store.GetReservedLock().Unlock()
+	p.metrics.Rollbacks.Inc()
 }
 
-// GetPage returns an allocated page. GetPage will return cached pages. GetPage
-// will return dirtyPages during a write transaction.
+// Rekey changes the key an encrypted database is encrypted with to key when the
+// write transaction in progress commits. Every page is made dirty so the commit
+// writes each of them encrypted with the new key. A database larger than memory
+// should be rekeyed with a dirty page limit. ErrNotEncrypted is returned when
+// the store does not encrypt pages.
+func (p *Pager) Rekey(key []byte) error {
+	if p.encrypted == nil {
+		return ErrNotEncrypted
+	}
+	if !p.isWriting.Load() {
+		return errors.New("rekey requires a write transaction")
+	}
+	if len(key) == 0 {
+		return errors.New("encryption key is empty")
+	}
+	for pageNumber := 1; pageNumber <= p.currentMaxPage; pageNumber++ {
+		p.GetPage(pageNumber)
+	}
+	if err := p.WriteErr(); err != nil {
+		return err
+	}
+	return p.encrypted.setPendingKey(key)
+}
+
+// GetPage returns an allocated page. During a write transaction GetPage returns
+// the dirty copy of the page modified by the write transaction so GetPage must
+// only be called by the writer while one is in progress. Otherwise the most
+// recently committed page is returned. GetPage will return cached pages.
 func (p *Pager) GetPage(pageNumber int) *Page {
+	if !p.isWriting.Load() {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		page, _ := p.getCommittedPage(pageNumber)
+		return page
+	}
 	// During a write pages are collected in the dirtyPages buffer. These pages
 	// must be retrieved from the buffer as they are modified because the file
 	// is becoming outdated.
-	if p.isWriting {
-		dpn := slices.IndexFunc(p.dirtyPages, func(dp *Page) bool {
-			return dp.number == pageNumber
-		})
-		if dpn != -1 {
-			return p.dirtyPages[dpn]
-		}
+	dpn := slices.IndexFunc(p.dirtyPages, func(dp *Page) bool {
+		return dp.number == pageNumber
+	})
+	if dpn != -1 {
+		return p.dirtyPages[dpn]
+	}
+	// The committed page is copied so snapshots reading the cache do not see
+	// the changes of the write transaction.
+	content := getPageBuffer()
+	p.mu.Lock()
+	if v, hit := p.pageCache.Get(pageNumber); hit {
+		p.metrics.CacheHits.Inc()
+		copy(content, v)
 	} else {
-		if v, hit := p.pageCache.Get(pageNumber); hit {
-			return p.allocatePage(pageNumber, v)
+		p.metrics.CacheMisses.Inc()
+		if err := p.readPage(pageNumber, content); err != nil && p.writeErr == nil {
+			p.writeErr = err
 		}
 	}
-	page := make([]byte, pageSize)
-	// Page number subtracted by 1 since 0 is reserved as a pointer to nothing.
-	p.store.ReadAt(page, int64(rootPageStart+(pageNumber-1)*pageSize))
-	ap := p.allocatePage(pageNumber, page)
-	if p.isWriting {
-		p.dirtyPages = append(p.dirtyPages, ap)
+	p.mu.Unlock()
+	dp := p.allocatePage(pageNumber, content)
+	p.addDirtyPage(dp)
+	return dp
+}
+
+// getCommittedPage returns the most recently committed page from the cache or
+// storage. A corrupt page is returned empty with its CorruptionError and is not
+// cached. getCommittedPage must be called with mu held.
+func (p *Pager) getCommittedPage(pageNumber int) (*Page, error) {
+	if v, hit := p.pageCache.Get(pageNumber); hit {
+		p.metrics.CacheHits.Inc()
+		return p.allocatePage(pageNumber, v), nil
+	}
+	p.metrics.CacheMisses.Inc()
+	page := getPageBuffer()
+	if err := p.readPage(pageNumber, page); err != nil {
+		return p.allocatePage(pageNumber, page), err
 	}
 	p.pageCache.Add(pageNumber, page)
-	return ap
+	return p.allocatePage(pageNumber, page), nil
+}
+
+// readPage reads the page with pageNumber from storage into content and
+// verifies its checksum. When the checksum does not match content is cleared,
+// which is an empty page, and a CorruptionError is returned.
+func (p *Pager) readPage(pageNumber int, content []byte) error {
+	// Page number subtracted by 1 since 0 is reserved as a pointer to nothing.
+	p.store.ReadAt(content, int64(rootPageStart+(pageNumber-1)*pageSize))
+	err := verifyPage(pageNumber, content)
+	if err != nil {
+		clear(content)
+	}
+	return err
+}
+
+// verifyPage returns a CorruptionError when the checksum of content, which is
+// the page with pageNumber, does not match.
+func verifyPage(pageNumber int, content []byte) error {
+	stored := binary.LittleEndian.Uint32(content[pageChecksumOffset:])
+	if stored == pageChecksum(pageNumber, content) {
+		return nil
+	}
+	// A page that was allocated but never written is all zeros.
+	if stored == 0 && !slices.ContainsFunc(content, func(b byte) bool { return b != 0 }) {
+		return nil
+	}
+	return &CorruptionError{PageNumber: pageNumber, Reason: "checksum does not match"}
+}
+
+// pageChecksum returns the checksum of content which is the page with
+// pageNumber. The page number is part of the checksum so a page written to
+// the wrong place is found.
+func pageChecksum(pageNumber int, content []byte) uint32 {
+	sum := crc32.Update(0, checksumTable, content[:pageChecksumOffset])
+	return crc32.Update(sum, checksumTable, binary.LittleEndian.AppendUint32(nil, uint32(pageNumber)))
 }
 
-// writePage writes the page to storage.
-func (p *Pager) writePage(page *Page) error {
+// checksumTable is the CRC-32 table of page checksums.
+var checksumTable = crc32.MakeTable(crc32.Castagnoli)
+
+// writePage writes content as the page with pageNumber to storage with its
+// checksum.
+func (p *Pager) writePage(pageNumber int, content []byte) error {
+	binary.LittleEndian.PutUint32(content[pageChecksumOffset:], pageChecksum(pageNumber, content))
 	// Page number subtracted by one since 0 is reserved as a pointer to nothing
-	pn := page.GetNumber() - 1
+	pn := pageNumber - 1
 	pns := pn * pageSize
 	off := rootPageStart + pns
-	_, err := p.store.WriteAt(page.content, int64(off))
+	_, err := p.store.WriteAt(content, int64(off))
 	return err
 }
 
 // NewPage increases the free page counter, allocates a new page, and adds it to
-// the dirtyPages list. NewPage must be called during a write transaction.
+// the dirtyPages list. A page is taken from the freelist before the free page
+// counter is increased so freed pages are reused before the file grows. NewPage
+// must be called during a write transaction.
 func (p *Pager) NewPage() *Page {
-	if !p.isWriting {
+	if !p.isWriting.Load() {
 		panic("must be a write transaction to allocate a new page")
 	}
+	if p.freelistHead != 0 {
+		np := p.GetPage(p.freelistHead)
+		_, p.freelistHead = np.GetRightPageNumber()
+		p.freelistCount -= 1
+		clear(np.buf())
+		np.SetType(pageTypeLeaf)
+		return np
+	}
 	p.currentMaxPage += 1
-	np := p.allocatePage(p.currentMaxPage, make([]byte, pageSize))
-	if p.isWriting {
-		p.dirtyPages = append(p.dirtyPages, np)
+	np := p.allocatePage(p.currentMaxPage, getPageBuffer())
+	if p.isWriting.Load() {
+		p.addDirtyPage(np)
 	}
 	return np
 }
 
+// FreePage adds the page with pageNumber to the freelist. The content of the
+// page is discarded. The page must not be referenced by any b tree. FreePage
+// must be called during a write transaction.
+func (p *Pager) FreePage(pageNumber int) {
+	if !p.isWriting.Load() {
+		panic("must be a write transaction to free a page")
+	}
+	fp := p.GetPage(pageNumber)
+	clear(fp.buf())
+	fp.SetType(pageTypeFree)
+	fp.SetRightPageNumber(p.freelistHead)
+	p.freelistHead = pageNumber
+	p.freelistCount += 1
+}
+
+// FreelistCount returns the number of pages on the freelist including the
+// pages freed by the write transaction in progress.
+func (p *Pager) FreelistCount() int {
+	if p.isWriting.Load() {
+		return p.freelistCount
+	}
+	_, count := readFreelist(p.store)
+	return count
+}
+
+// addDirtyPage adds page to the pages flushed when the write ends. When more
+// dirty pages than the dirty page limit are in memory the oldest are spilled
+// until half of the limit remain so a large write does not hold every page it
+// changed in memory.
+func (p *Pager) addDirtyPage(page *Page) {
+	p.dirtyPages = append(p.dirtyPages, page)
+	p.dirtyPageCount.Store(int64(len(p.dirtyPages)))
+	limit := int(p.dirtyPageLimit.Load())
+	if limit == 0 || p.residentDirtyPages() <= limit {
+		return
+	}
+	if p.spill == nil {
+		s, err := newSpillFile(p.encrypted != nil)
+		if err != nil {
+			if p.writeErr == nil {
+				p.writeErr = err
+			}
+			return
+		}
+		p.spill = s
+	}
+	// The page being added is left in memory since the caller is about to use
+	// it.
+	for _, dp := range p.dirtyPages[:len(p.dirtyPages)-1] {
+		if p.residentDirtyPages() <= limit/2 {
+			break
+		}
+		if dp.content == nil {
+			continue
+		}
+		if err := p.spill.spill(dp); err != nil {
+			if p.writeErr == nil {
+				p.writeErr = err
+			}
+			return
+		}
+		p.metrics.PagesSpilled.Inc()
+	}
+}
+
+// residentDirtyPages returns the number of dirty pages held in memory.
+func (p *Pager) residentDirtyPages() int {
+	if p.spill == nil {
+		return len(p.dirtyPages)
+	}
+	return len(p.dirtyPages) - p.spill.spilled
+}
+
+// clearDirtyPages forgets the dirty pages once they are flushed or discarded.
+func (p *Pager) clearDirtyPages() {
+	p.dirtyPages = []*Page{}
+	p.dirtyPageCount.Store(0)
+	if p.spill != nil {
+		p.spill.close()
+		p.spill = nil
+	}
+}
+
+// SetDirtyPageLimit sets the most dirty pages a write transaction keeps in
+// memory. Once a write transaction changes more pages than limit the pages it
+// changed first are spilled to a temporary file and read back when they are
+// used again. A limit of 0, the default, keeps every dirty page in memory. It
+// is safe to call from any goroutine and applies to the next page changed.
+func (p *Pager) SetDirtyPageLimit(limit int) {
+	p.dirtyPageLimit.Store(int64(max(limit, 0)))
+}
+
 // allocatePage is a helper function that is capable of converting the
 // underlying byte slice into a page structure.
 func (p *Pager) allocatePage(pageNumber int, content []byte) *Page {
-	np := &Page{
-		content: content,
-		number:  pageNumber,
-	}
+	np := pagePool.Get().(*Page)
+	np.content = content
+	np.number = pageNumber
 	if np.GetType() == pageTypeUnknown {
 		np.SetType(pageTypeLeaf)
 	}
@@ -345,7 +1471,9 @@ func (p *Pager) allocatePage(pageNumber int, content []byte) *Page {
 //   - 4 bytes for the tuple offsets (2 bytes key 2 bytes value) multiplied by
 //     the count of tuples previously mentioned.
 //   - Variable length key and value tuples filling the remaining space. Which
-//     accumulates from the end of the Page to the start.
+//     accumulates from the checksum to the start.
+//   - 4 bytes for the checksum of the Page at the end of the Page. The checksum
+//     is written when the Page is written and verified when it is read.
 //
 // Tuple offsets are sorted and listed in order. Tuples are stored in reverse
 // order starting at the end of the Page. This is so the end of each tuple can
@@ -367,6 +1495,18 @@ func (p *Pager) allocatePage(pageNumber int, content []byte) *Page {
 type Page struct {
 	content []byte
 	number  int
+	// spill is the spill file holding the content of the page while the page
+	// is spilled. content is nil while spill is not nil.
+	spill *spillFile
+}
+
+// buf returns the content of the page reading it back from the spill file
+// when the page was spilled.
+func (p *Page) buf() []byte {
+	if p.spill != nil {
+		p.spill.unspill(p)
+	}
+	return p.content
 }
 
 // PageTuple is a variable length key value pair.
@@ -375,8 +1515,14 @@ type PageTuple struct {
 	Value []byte
 }
 
+// Size returns the amount of bytes the tuple occupies on a page including its
+// offsets.
+func (t PageTuple) Size() int {
+	return pageRowOffsetSize + pageRowOffsetSize + len(t.Key) + len(t.Value)
+}
+
 func (p *Page) GetParentPageNumber() (hasParent bool, pageNumber int) {
-	pn := binary.LittleEndian.Uint32(p.content[parentPointerOffset : parentPointerOffset+pagePointerSize])
+	pn := binary.LittleEndian.Uint32(p.buf()[parentPointerOffset : parentPointerOffset+pagePointerSize])
 	if pn == emptyParentPageNumber {
 		return false, emptyParentPageNumber
 	}
@@ -386,11 +1532,11 @@ func (p *Page) GetParentPageNumber() (hasParent bool, pageNumber int) {
 func (p *Page) SetParentPageNumber(pageNumber int) {
 	bpn := make([]byte, pagePointerSize)
 	binary.LittleEndian.PutUint32(bpn, uint32(pageNumber))
-	copy(p.content[parentPointerOffset:parentPointerOffset+pagePointerSize], bpn)
+	copy(p.buf()[parentPointerOffset:parentPointerOffset+pagePointerSize], bpn)
 }
 
 func (p *Page) GetLeftPageNumber() (hasLeft bool, pageNumber int) {
-	pn := binary.LittleEndian.Uint32(p.content[leftPointerOffset : leftPointerOffset+pagePointerSize])
+	pn := binary.LittleEndian.Uint32(p.buf()[leftPointerOffset : leftPointerOffset+pagePointerSize])
 	if pn == emptyParentPageNumber {
 		return false, emptyParentPageNumber
 	}
@@ -400,11 +1546,11 @@ func (p *Page) GetLeftPageNumber() (hasLeft bool, pageNumber int) {
 func (p *Page) SetLeftPageNumber(pageNumber int) {
 	bpn := make([]byte, pagePointerSize)
 	binary.LittleEndian.PutUint32(bpn, uint32(pageNumber))
-	copy(p.content[leftPointerOffset:leftPointerOffset+pagePointerSize], bpn)
+	copy(p.buf()[leftPointerOffset:leftPointerOffset+pagePointerSize], bpn)
 }
 
 func (p *Page) GetRightPageNumber() (hasRight bool, pageNumber int) {
-	pn := binary.LittleEndian.Uint32(p.content[rightPointerOffset : rightPointerOffset+pagePointerSize])
+	pn := binary.LittleEndian.Uint32(p.buf()[rightPointerOffset : rightPointerOffset+pagePointerSize])
 	if pn == emptyParentPageNumber {
 		return false, emptyParentPageNumber
 	}
@@ -414,7 +1560,7 @@ func (p *Page) GetRightPageNumber() (hasRight bool, pageNumber int) {
 func (p *Page) SetRightPageNumber(pageNumber int) {
 	bpn := make([]byte, pagePointerSize)
 	binary.LittleEndian.PutUint32(bpn, uint32(pageNumber))
-	copy(p.content[rightPointerOffset:rightPointerOffset+pagePointerSize], bpn)
+	copy(p.buf()[rightPointerOffset:rightPointerOffset+pagePointerSize], bpn)
 }
 
 func (p *Page) GetNumber() int {
@@ -429,17 +1575,22 @@ func (p *Page) GetNumberAsBytes() []byte {
 }
 
 func (p *Page) GetType() int {
-	return int(p.content[pageTypeOffset])
+	return int(p.buf()[pageTypeOffset])
 }
 
 func (p *Page) IsLeaf() bool {
 	return p.GetType() == pageTypeLeaf
 }
 
+// IsFree returns true when the page is on the freelist.
+func (p *Page) IsFree() bool {
+	return p.GetType() == pageTypeFree
+}
+
 func (p *Page) SetType(t int) {
 	bytePageType := make([]byte, pageTypeSize)
 	bytePageType[0] = uint8(t)
-	copy(p.content[pageTypeOffset:pageTypeOffset+pageTypeSize], bytePageType)
+	copy(p.buf()[pageTypeOffset:pageTypeOffset+pageTypeSize], bytePageType)
 }
 
 func (p *Page) SetTypeInternal() {
@@ -449,18 +1600,30 @@ func (p *Page) SetTypeInternal() {
 // GetRecordCount returns the value of the counter that tells how many tuples
 // are currently stored on the page.
 func (p *Page) GetRecordCount() int {
-	return int(binary.LittleEndian.Uint16(p.content[pageRecordCountOffset : pageRecordCountOffset+pageRecordCountSize]))
+	return int(binary.LittleEndian.Uint16(p.buf()[pageRecordCountOffset : pageRecordCountOffset+pageRecordCountSize]))
 }
 
 func (p *Page) setRecordCount(newCount int) {
 	byteRecordCount := make([]byte, pageRecordCountSize)
 	binary.LittleEndian.PutUint16(byteRecordCount, uint16(newCount))
 	copy(
-		p.content[pageRecordCountOffset:pageRecordCountOffset+pageRecordCountSize],
+		p.buf()[pageRecordCountOffset:pageRecordCountOffset+pageRecordCountSize],
 		byteRecordCount,
 	)
 }
 
+// IsUnderfull returns true when the tuples of the page use less than a quarter
+// of the space a page has for tuples. Deleting from a b tree merges an
+// underfull page with a sibling or moves tuples to it from a sibling.
+func (p *Page) IsUnderfull() bool {
+	used := 0
+	for i := 0; i < p.GetRecordCount(); i += 1 {
+		keyOffset, _, entryEnd := p.getEntryOffsets(i)
+		used += pageRowOffsetSize + pageRowOffsetSize + entryEnd - keyOffset
+	}
+	return used < (pageChecksumOffset-pageRowOffsetsOffset)/4
+}
+
 // CanInsertTuples returns true if the page can fit the new tuple otherwise it
 // returns false.
 func (p *Page) CanInsertTuple(key, value []byte) bool {
@@ -475,27 +1638,30 @@ func (p *Page) CanInsertTuple(key, value []byte) bool {
 // CanInsertTuples returns true if the page can fit the new tuples otherwise it
 // returns false.
 func (p *Page) CanInsertTuples(pageTuples []PageTuple) bool {
+	return TuplesFit(append(pageTuples, p.GetEntries()...))
+}
+
+// TuplesFit returns true if the tuples can fit on an empty page otherwise it
+// returns false.
+func TuplesFit(pageTuples []PageTuple) bool {
 	s := 0
 	s += pageTypeSize
 	s += pageRecordCountSize
 	s += pagePointerSize // parent
 	s += pagePointerSize // left
 	s += pagePointerSize // right
-	entries := append(pageTuples, p.GetEntries()...)
-	s += len(entries) * (pageRowOffsetSize + pageRowOffsetSize)
-	for _, e := range entries {
-		s += len(e.Key)
-		s += len(e.Value)
+	for _, e := range pageTuples {
+		s += e.Size()
 	}
-	return pageSize >= s
+	return pageChecksumOffset >= s
 }
 
 // SetEntries sets the page tuples in sorted order.
 func (p *Page) SetEntries(entries []PageTuple) {
-	copy(p.content[pageRowOffsetsOffset:pageSize], make([]byte, pageSize-pageRowOffsetsOffset))
+	clear(p.buf()[pageRowOffsetsOffset:pageChecksumOffset])
 	sort.Slice(entries, func(a, b int) bool { return bytes.Compare(entries[a].Key, entries[b].Key) == -1 })
 	shift := pageRowOffsetsOffset
-	entryEnd := pageSize
+	entryEnd := pageChecksumOffset
 	for _, entry := range entries {
 		startKeyOffset := shift
 		endKeyOffset := shift + pageRowOffsetSize
@@ -505,19 +1671,19 @@ func (p *Page) SetEntries(entries []PageTuple) {
 		keyOffset := entryEnd - len(entry.Key) - len(entry.Value)
 		byteKeyOffset := make([]byte, pageRowOffsetSize)
 		binary.LittleEndian.PutUint16(byteKeyOffset, uint16(keyOffset))
-		copy(p.content[startKeyOffset:endKeyOffset], byteKeyOffset)
+		copy(p.buf()[startKeyOffset:endKeyOffset], byteKeyOffset)
 
 		// set value offset
 		valueOffset := entryEnd - len(entry.Value)
 		byteValueOffset := make([]byte, pageRowOffsetSize)
 		binary.LittleEndian.PutUint16(byteValueOffset, uint16(valueOffset))
-		copy(p.content[endKeyOffset:endValueOffset], byteValueOffset)
+		copy(p.buf()[endKeyOffset:endValueOffset], byteValueOffset)
 
 		// set key
-		copy(p.content[keyOffset:valueOffset], entry.Key)
+		copy(p.buf()[keyOffset:valueOffset], entry.Key)
 
 		// set value
-		copy(p.content[valueOffset:valueOffset+len(entry.Value)], entry.Value)
+		copy(p.buf()[valueOffset:valueOffset+len(entry.Value)], entry.Value)
 
 		// update for next iteration
 		shift = endValueOffset
@@ -530,21 +1696,21 @@ func (p *Page) SetEntries(entries []PageTuple) {
 func (p *Page) GetEntries() []PageTuple {
 	entries := []PageTuple{}
 	recordCount := p.GetRecordCount()
-	entryEnd := pageSize
+	entryEnd := pageChecksumOffset
 	for i := 0; i < recordCount; i += 1 {
 		startKeyOffset := pageRowOffsetsOffset + (i * (pageRowOffsetSize + pageRowOffsetSize))
 		endKeyOffset := pageRowOffsetsOffset + (i * (pageRowOffsetSize + pageRowOffsetSize)) + pageRowOffsetSize
 		endValueOffset := pageRowOffsetsOffset + (i * (pageRowOffsetSize + pageRowOffsetSize)) + pageRowOffsetSize + pageRowOffsetSize
 
-		keyOffset := int(binary.LittleEndian.Uint16(p.content[startKeyOffset:endKeyOffset]))
-		valueOffset := int(binary.LittleEndian.Uint16(p.content[endKeyOffset:endValueOffset]))
+		keyOffset := int(binary.LittleEndian.Uint16(p.buf()[startKeyOffset:endKeyOffset]))
+		valueOffset := int(binary.LittleEndian.Uint16(p.buf()[endKeyOffset:endValueOffset]))
 
 		// These must be copied otherwise the underlying byte array is returned.
 		// This causes what seems a unique value to be treated as a reference.
 		byteKey := make([]byte, valueOffset-keyOffset)
-		copy(byteKey, p.content[keyOffset:valueOffset])
+		copy(byteKey, p.buf()[keyOffset:valueOffset])
 		byteValue := make([]byte, entryEnd-valueOffset)
-		copy(byteValue, p.content[valueOffset:entryEnd])
+		copy(byteValue, p.buf()[valueOffset:entryEnd])
 		entries = append(entries, PageTuple{
 			Key:   byteKey,
 			Value: byteValue,
@@ -575,31 +1741,112 @@ func (p *Page) SetValue(key, value []byte) {
 // GetValue searches the page and returns the value and a flag indicated if the
 // value was found. If the page is leaf an exact match must be made. If the page
 // is internal GetValue will search for the range the key falls in and return
-// the ranges value.
+// the ranges value. A key less than every key on an internal page falls in the
+// first range.
 func (p *Page) GetValue(key []byte) (value []byte, exists bool) {
-	e := p.GetEntries()
-	if p.GetType() == pageTypeLeaf {
-		for _, entry := range e {
-			c := bytes.Compare(entry.Key, key)
-			if c == 0 { // entryKey == searchKey
-				return entry.Value, true
-			}
-		}
+	i, found := p.Search(key)
+	if !found {
 		return []byte{}, false
 	}
-	var prevEntry *PageTuple = nil
-	for _, entry := range e {
-		c := bytes.Compare(entry.Key, key)
-		if c == 0 { // entryKey == searchKey
-			return entry.Value, true
+	return p.GetEntry(i).Value, true
+}
+
+// Search returns the position of the tuple GetValue would return for key and a
+// flag indicating if there is such a tuple. If the page is leaf an exact match
+// must be made. If the page is internal the position is of the range the key
+// falls in. An internal page only reports not found when it is empty.
+func (p *Page) Search(key []byte) (int, bool) {
+	recordCount := p.GetRecordCount()
+	// i is the position of the first tuple with a key greater than key.
+	i := sort.Search(recordCount, func(i int) bool {
+		return bytes.Compare(p.getKey(i), key) == 1
+	})
+	if p.GetType() == pageTypeLeaf {
+		if i == 0 || !bytes.Equal(p.getKey(i-1), key) {
+			return 0, false
 		}
-		if c == 1 { // searchKey < entryKey
-			return prevEntry.Value, true
+		return i - 1, true
+	}
+	if recordCount == 0 {
+		return 0, false
+	}
+	if i == 0 {
+		return 0, true
+	}
+	return i - 1, true
+}
+
+// GetEntry returns the tuple at position i in sorted order.
+func (p *Page) GetEntry(i int) PageTuple {
+	keyOffset, valueOffset, entryEnd := p.getEntryOffsets(i)
+	byteKey := make([]byte, valueOffset-keyOffset)
+	copy(byteKey, p.buf()[keyOffset:valueOffset])
+	byteValue := make([]byte, entryEnd-valueOffset)
+	copy(byteValue, p.buf()[valueOffset:entryEnd])
+	return PageTuple{Key: byteKey, Value: byteValue}
+}
+
+// GetEntryView returns the tuple at position i in sorted order without copying.
+// The key and value are slices of the page buffer so they are only valid until
+// the page is next modified. A caller that keeps either past that must copy it.
+func (p *Page) GetEntryView(i int) PageTuple {
+	keyOffset, valueOffset, entryEnd := p.getEntryOffsets(i)
+	return PageTuple{
+		Key:   p.buf()[keyOffset:valueOffset:valueOffset],
+		Value: p.buf()[valueOffset:entryEnd:entryEnd],
+	}
+}
+
+// SetEntryValue overwrites the value of the tuple at position i without
+// rewriting the rest of the page. The value must be the same size as the
+// existing value otherwise false is returned and the page is unchanged.
+func (p *Page) SetEntryValue(i int, value []byte) bool {
+	_, valueOffset, entryEnd := p.getEntryOffsets(i)
+	if entryEnd-valueOffset != len(value) {
+		return false
+	}
+	copy(p.buf()[valueOffset:entryEnd], value)
+	return true
+}
+
+// Check returns a CorruptionError when the type, record count or tuple offsets
+// of the page are not valid. A page that passes Check can be read with
+// GetEntries without panicking.
+func (p *Page) Check() error {
+	if t := p.GetType(); t == pageTypeFree {
+		return &CorruptionError{PageNumber: p.number, Reason: "is on the freelist"}
+	} else if t != pageTypeLeaf && t != pageTypeInternal {
+		return &CorruptionError{PageNumber: p.number, Reason: fmt.Sprintf("has unknown type %d", t)}
+	}
+	recordCount := p.GetRecordCount()
+	offsetsEnd := pageRowOffsetsOffset + recordCount*(pageRowOffsetSize+pageRowOffsetSize)
+	if offsetsEnd > pageChecksumOffset {
+		return &CorruptionError{PageNumber: p.number, Reason: fmt.Sprintf("has record count %d that does not fit", recordCount)}
+	}
+	for i := 0; i < recordCount; i += 1 {
+		keyOffset, valueOffset, entryEnd := p.getEntryOffsets(i)
+		if keyOffset < offsetsEnd || keyOffset > valueOffset || valueOffset > entryEnd || entryEnd > pageChecksumOffset {
+			return &CorruptionError{PageNumber: p.number, Reason: fmt.Sprintf("has invalid offsets for tuple %d", i)}
 		}
-		prevEntry = &entry
 	}
-	if prevEntry != nil {
-		return prevEntry.Value, true
+	return nil
+}
+
+// getKey returns the key of the tuple at position i without copying.
+func (p *Page) getKey(i int) []byte {
+	keyOffset, valueOffset, _ := p.getEntryOffsets(i)
+	return p.buf()[keyOffset:valueOffset]
+}
+
+// getEntryOffsets returns the start of the key, start of the value and end of
+// the value for the tuple at position i.
+func (p *Page) getEntryOffsets(i int) (keyOffset, valueOffset, entryEnd int) {
+	start := pageRowOffsetsOffset + (i * (pageRowOffsetSize + pageRowOffsetSize))
+	keyOffset = int(binary.LittleEndian.Uint16(p.buf()[start : start+pageRowOffsetSize]))
+	valueOffset = int(binary.LittleEndian.Uint16(p.buf()[start+pageRowOffsetSize : start+pageRowOffsetSize+pageRowOffsetSize]))
+	entryEnd = pageChecksumOffset
+	if i != 0 {
+		entryEnd = int(binary.LittleEndian.Uint16(p.buf()[start-pageRowOffsetSize-pageRowOffsetSize : start-pageRowOffsetSize]))
 	}
-	return []byte{}, false
+	return keyOffset, valueOffset, entryEnd
 }
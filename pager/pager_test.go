@@ -2,8 +2,12 @@ package pager
 
 import (
 	"bytes"
+	"context"
 	"encoding/binary"
+	"errors"
+	"os"
 	"testing"
+	"time"
 )
 
 func TestPageHelpers(t *testing.T) {
@@ -99,19 +103,19 @@ func TestPageSet(t *testing.T) {
 		p.SetValue([]byte{3}, []byte{'j', 'i', 'l', 'l', 'i', 'a', 'n'})
 
 		ExpectUint16(t, p.content, 13, 3)
-		ExpectUint16(t, p.content, 15, 4091)
-		ExpectUint16(t, p.content, 17, 4092)
-		ExpectUint16(t, p.content, 19, 4086)
-		ExpectUint16(t, p.content, 21, 4087)
-		ExpectUint16(t, p.content, 23, 4078)
-		ExpectUint16(t, p.content, 25, 4079)
+		ExpectUint16(t, p.content, 15, 4087)
+		ExpectUint16(t, p.content, 17, 4088)
+		ExpectUint16(t, p.content, 19, 4082)
+		ExpectUint16(t, p.content, 21, 4083)
+		ExpectUint16(t, p.content, 23, 4074)
+		ExpectUint16(t, p.content, 25, 4075)
 
-		ExpectByteArray(t, p.content, 4078, []byte{3})
-		ExpectByteArray(t, p.content, 4079, []byte{'j', 'i', 'l', 'l', 'i', 'a', 'n'})
-		ExpectByteArray(t, p.content, 4086, []byte{2})
-		ExpectByteArray(t, p.content, 4087, []byte{'g', 'r', 'e', 'g'})
-		ExpectByteArray(t, p.content, 4091, []byte{1})
-		ExpectByteArray(t, p.content, 4092, []byte{'c', 'a', 'r', 'l'})
+		ExpectByteArray(t, p.content, 4074, []byte{3})
+		ExpectByteArray(t, p.content, 4075, []byte{'j', 'i', 'l', 'l', 'i', 'a', 'n'})
+		ExpectByteArray(t, p.content, 4082, []byte{2})
+		ExpectByteArray(t, p.content, 4083, []byte{'g', 'r', 'e', 'g'})
+		ExpectByteArray(t, p.content, 4087, []byte{1})
+		ExpectByteArray(t, p.content, 4088, []byte{'c', 'a', 'r', 'l'})
 	})
 
 	t.Run("set update", func(t *testing.T) {
@@ -125,11 +129,11 @@ func TestPageSet(t *testing.T) {
 		p.SetValue([]byte{1}, []byte{'r', 'o', 'l', 'f'})
 
 		ExpectUint16(t, p.content, 13, 1)
-		ExpectUint16(t, p.content, 15, 4091)
-		ExpectUint16(t, p.content, 17, 4092)
+		ExpectUint16(t, p.content, 15, 4087)
+		ExpectUint16(t, p.content, 17, 4088)
 
-		ExpectByteArray(t, p.content, 4091, []byte{1})
-		ExpectByteArray(t, p.content, 4092, []byte{'r', 'o', 'l', 'f'})
+		ExpectByteArray(t, p.content, 4087, []byte{1})
+		ExpectByteArray(t, p.content, 4088, []byte{'r', 'o', 'l', 'f'})
 	})
 }
 
@@ -171,6 +175,146 @@ func TestGet(t *testing.T) {
 	})
 }
 
+func TestCacheMemory(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for pageNumber := 1; pageNumber <= 4; pageNumber++ {
+		pager.GetPage(pageNumber)
+	}
+	if got := pager.CacheMemory(); got != 4*pageSize {
+		t.Fatalf("expected %d bytes cached got %d", 4*pageSize, got)
+	}
+	pager.SetCacheMemory(2 * pageSize)
+	if got := pager.CacheMemory(); got != 2*pageSize {
+		t.Fatalf("expected %d bytes cached got %d", 2*pageSize, got)
+	}
+	pager.GetPage(5)
+	if got := pager.CacheMemory(); got != 2*pageSize {
+		t.Fatalf("expected %d bytes cached got %d", 2*pageSize, got)
+	}
+}
+
+func TestPinPages(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager.SetCacheMemory(2 * pageSize)
+	pager.PinPages([]int{1})
+	for pageNumber := 1; pageNumber <= 4; pageNumber++ {
+		pager.GetPage(pageNumber)
+	}
+	hits := pager.GetMetrics().CacheHits.Value()
+	pager.GetPage(1)
+	if got := pager.GetMetrics().CacheHits.Value(); got != hits+1 {
+		t.Fatal("expected pinned page 1 to be a cache hit")
+	}
+	if got := pager.CacheMemory(); got != 2*pageSize {
+		t.Fatalf("expected %d bytes cached got %d", 2*pageSize, got)
+	}
+}
+
+func TestSetCachePolicy(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager.SetCacheMemory(4 * pageSize)
+	pager.PinPages([]int{1})
+	pager.SetCachePolicy(CachePolicy2Q)
+	if got := pager.MaxCacheMemory(); got != 4*pageSize {
+		t.Fatalf("expected max %d bytes got %d", 4*pageSize, got)
+	}
+	// Page 2 is read twice so it is frequent once evicted and read again.
+	pager.GetPage(1)
+	for pageNumber := 2; pageNumber <= 6; pageNumber++ {
+		pager.GetPage(pageNumber)
+	}
+	pager.GetPage(2)
+	for pageNumber := 7; pageNumber <= 20; pageNumber++ {
+		pager.GetPage(pageNumber)
+	}
+	hits := pager.GetMetrics().CacheHits.Value()
+	pager.GetPage(1)
+	pager.GetPage(2)
+	if got := pager.GetMetrics().CacheHits.Value(); got != hits+2 {
+		t.Fatalf("expected pinned and frequent pages to be cache hits got %d hits", got-hits)
+	}
+	if got := pager.GetMetrics().CacheEvictions.Value(); got == 0 {
+		t.Fatal("expected evictions to be counted")
+	}
+}
+
+func TestDefaultPageCacheMemory(t *testing.T) {
+	got := defaultPageCacheMemory()
+	if got < minPageCacheMemory || got > maxPageCacheMemory {
+		t.Fatalf("expected between %d and %d got %d", minPageCacheMemory, maxPageCacheMemory, got)
+	}
+}
+
+func TestSetEntryValue(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pager.GetPage(1)
+	p.SetValue([]byte{1}, []byte{'c', 'a', 'r', 'l'})
+	p.SetValue([]byte{2}, []byte{'g', 'r', 'e', 'g'})
+
+	t.Run("same size", func(t *testing.T) {
+		i, found := p.Search([]byte{2})
+		if !found {
+			t.Fatal("expected found")
+		}
+		if ok := p.SetEntryValue(i, []byte{'j', 'a', 'n', 'e'}); !ok {
+			t.Fatal("expected value to be set")
+		}
+		want := []byte{'j', 'a', 'n', 'e'}
+		if got, _ := p.GetValue([]byte{2}); !bytes.Equal(got, want) {
+			t.Fatalf("expected %v got %v", want, got)
+		}
+	})
+
+	t.Run("different size", func(t *testing.T) {
+		i, _ := p.Search([]byte{1})
+		if ok := p.SetEntryValue(i, []byte{'c'}); ok {
+			t.Fatal("expected value to not be set")
+		}
+	})
+}
+
+func TestGetEntryView(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := pager.GetPage(1)
+	p.SetValue([]byte{1}, []byte{'c', 'a', 'r', 'l'})
+	p.SetValue([]byte{2}, []byte{'g', 'r', 'e', 'g'})
+
+	view := p.GetEntryView(1)
+	if !bytes.Equal(view.Key, []byte{2}) {
+		t.Fatalf("expected key %v got %v", []byte{2}, view.Key)
+	}
+	if !bytes.Equal(view.Value, []byte{'g', 'r', 'e', 'g'}) {
+		t.Fatalf("expected value greg got %s", view.Value)
+	}
+	copied := p.GetEntry(1)
+
+	p.SetEntryValue(1, []byte{'j', 'a', 'n', 'e'})
+	if !bytes.Equal(view.Value, []byte{'j', 'a', 'n', 'e'}) {
+		t.Fatalf("expected view to see the page change got %s", view.Value)
+	}
+	if !bytes.Equal(copied.Value, []byte{'g', 'r', 'e', 'g'}) {
+		t.Fatalf("expected copy to be unchanged got %s", copied.Value)
+	}
+	if cap(view.Key) != len(view.Key) || cap(view.Value) != len(view.Value) {
+		t.Fatal("expected view capacity to end with the slice")
+	}
+}
+
 func ExpectUint16(t *testing.T, content []byte, start int, expected uint16) {
 	e := make([]byte, 2)
 	binary.LittleEndian.PutUint16(e, expected)
@@ -185,3 +329,736 @@ func ExpectByteArray(t *testing.T, content []byte, start int, expeted []byte) {
 		t.Errorf("expected %v got %v at range start %d end %d", expeted, content[start:end], start, end)
 	}
 }
+
+func TestRollbackWrite(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pager.GetPage(1).SetValue([]byte{1}, []byte{'c', 'a', 'r', 'l'})
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pager.GetPage(1).SetValue([]byte{2}, []byte{'g', 'r', 'e', 'g'})
+	rolledBackPageNumber := pager.NewPage().GetNumber()
+	pager.RollbackWrite()
+
+	t.Run("changes are discarded", func(t *testing.T) {
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		p := s.GetPage(1)
+		if _, found := p.GetValue([]byte{2}); found {
+			t.Fatal("expected rolled back value to not be found")
+		}
+		if _, found := p.GetValue([]byte{1}); !found {
+			t.Fatal("expected committed value to be found")
+		}
+	})
+
+	t.Run("allocated pages are reused", func(t *testing.T) {
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer pager.RollbackWrite()
+		if got := pager.NewPage().GetNumber(); got != rolledBackPageNumber {
+			t.Fatalf("expected page %d got %d", rolledBackPageNumber, got)
+		}
+	})
+}
+
+func TestDirtyPageLimit(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager.SetDirtyPageLimit(4)
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pages := []*Page{}
+	for i := 0; i < 20; i++ {
+		p := pager.NewPage()
+		p.SetValue([]byte{byte(i)}, []byte{'v', byte(i)})
+		pages = append(pages, p)
+	}
+	if got := pager.residentDirtyPages(); got > 4 {
+		t.Fatalf("expected at most 4 dirty pages in memory got %d", got)
+	}
+	if got := pager.GetMetrics().PagesSpilled.Value(); got == 0 {
+		t.Fatal("expected spilled pages to be counted")
+	}
+	// The first page was spilled and is read back with the same identity.
+	if pages[0].content != nil {
+		t.Fatal("expected first page to be spilled")
+	}
+	if v, found := pages[0].GetValue([]byte{0}); !found || !bytes.Equal(v, []byte{'v', 0}) {
+		t.Fatalf("expected spilled value to be read back got %v", v)
+	}
+	if pager.GetPage(pages[0].GetNumber()) != pages[0] {
+		t.Fatal("expected spilled page to remain the dirty page")
+	}
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if pager.spill != nil {
+		t.Fatal("expected spill file to be closed after commit")
+	}
+
+	t.Run("spilled pages are committed", func(t *testing.T) {
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		for i := 0; i < 20; i++ {
+			p, err := s.ReadPage(i + 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, found := p.GetValue([]byte{byte(i)}); !found || !bytes.Equal(v, []byte{'v', byte(i)}) {
+				t.Fatalf("expected page %d to have value %d got %v", i+2, i, v)
+			}
+		}
+	})
+
+	t.Run("rollback discards spilled pages", func(t *testing.T) {
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		for pageNumber := 2; pageNumber < 22; pageNumber++ {
+			pager.GetPage(pageNumber).SetValue([]byte{100}, []byte{1})
+		}
+		pager.RollbackWrite()
+		if pager.spill != nil {
+			t.Fatal("expected spill file to be closed after rollback")
+		}
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		if _, found := s.GetPage(2).GetValue([]byte{100}); found {
+			t.Fatal("expected rolled back value to not be found")
+		}
+	})
+}
+
+func TestReadAhead(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := pager.BeginWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// Leaves 2 through 21 are linked from left to right.
+	for pageNumber := 2; pageNumber <= 21; pageNumber++ {
+		leaf := pager.NewPage()
+		leaf.SetValue([]byte{1}, []byte{byte(pageNumber)})
+		if pageNumber < 21 {
+			leaf.SetRightPageNumber(pageNumber + 1)
+		}
+	}
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := pager.BeginRead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.ReadAhead(2)
+	s.readAhead.Wait()
+	if got := pager.GetMetrics().PagesReadAhead.Value(); got != readAheadPages {
+		t.Fatalf("expected %d pages read ahead got %d", readAheadPages, got)
+	}
+	hits := pager.GetMetrics().CacheHits.Value()
+	for pageNumber := 2; pageNumber < 2+readAheadPages; pageNumber++ {
+		if v, _ := s.GetPage(pageNumber).GetValue([]byte{1}); !bytes.Equal(v, []byte{byte(pageNumber)}) {
+			t.Fatalf("expected page %d got value %v", pageNumber, v)
+		}
+	}
+	if got := pager.GetMetrics().CacheHits.Value() - hits; got != readAheadPages {
+		t.Fatalf("expected %d cache hits got %d", readAheadPages, got)
+	}
+
+	t.Run("stops at the last leaf", func(t *testing.T) {
+		before := pager.GetMetrics().PagesReadAhead.Value()
+		s.ReadAhead(18)
+		pager.EndRead(s)
+		if got := pager.GetMetrics().PagesReadAhead.Value() - before; got != 4 {
+			t.Fatalf("expected 4 pages read ahead got %d", got)
+		}
+	})
+}
+
+func TestTransactionState(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertState := func(t *testing.T, wantState TransactionState, wantDirty int) {
+		t.Helper()
+		state, dirty := pager.TransactionState()
+		if state != wantState || dirty != wantDirty {
+			t.Fatalf("want %s with %d dirty pages got %s with %d", wantState, wantDirty, state, dirty)
+		}
+	}
+	assertState(t, TransactionNone, 0)
+
+	s1, err := pager.BeginRead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s2, err := pager.BeginRead(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager.EndRead(s1)
+	assertState(t, TransactionRead, 0)
+	pager.EndRead(s2)
+	assertState(t, TransactionNone, 0)
+
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pager.GetPage(1)
+	pager.NewPage()
+	assertState(t, TransactionWrite, 2)
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+	assertState(t, TransactionNone, 0)
+
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pager.NewPage()
+	assertState(t, TransactionWrite, 1)
+	pager.RollbackWrite()
+	assertState(t, TransactionNone, 0)
+}
+
+func TestBeginBusy(t *testing.T) {
+	cases := []struct {
+		name      string
+		useMemory bool
+	}{
+		{name: "memory", useMemory: true},
+		{name: "file", useMemory: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pager, err := New(c.useMemory, t.TempDir()+"/busy")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := pager.BeginWrite(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			defer cancel()
+			if err := pager.BeginWrite(ctx); !errors.Is(err, ErrBusy) {
+				t.Fatalf("expected write %v got %v", ErrBusy, err)
+			}
+			s, err := pager.BeginRead(ctx)
+			if err != nil {
+				t.Fatalf("expected read to not wait for writer got %v", err)
+			}
+			pager.EndRead(s)
+
+			// The lock is acquired once the writer finishes within the
+			// deadline.
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				pager.RollbackWrite()
+			}()
+			ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			if err := pager.BeginWrite(ctx); err != nil {
+				t.Fatalf("expected write lock got %v", err)
+			}
+			pager.RollbackWrite()
+		})
+	}
+}
+
+func TestSnapshot(t *testing.T) {
+	cases := []struct {
+		name      string
+		useMemory bool
+	}{
+		{name: "memory", useMemory: true},
+		{name: "file", useMemory: false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			pager, err := New(c.useMemory, t.TempDir()+"/snapshot")
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx := context.Background()
+			if err := pager.BeginWrite(ctx); err != nil {
+				t.Fatal(err)
+			}
+			pager.GetPage(1).SetValue([]byte{1}, []byte{'a'})
+			if err := pager.EndWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			old, err := pager.BeginRead(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// The writer begins and commits without waiting for the reader.
+			ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+			defer cancel()
+			if err := pager.BeginWrite(ctx); err != nil {
+				t.Fatalf("expected writer to not wait for reader got %v", err)
+			}
+			pager.GetPage(1).SetValue([]byte{1}, []byte{'b'})
+			if v, _ := old.GetPage(1).GetValue([]byte{1}); string(v) != "a" {
+				t.Fatalf("expected uncommitted value to not be read got %s", v)
+			}
+			if err := pager.EndWrite(); err != nil {
+				t.Fatal(err)
+			}
+
+			current, err := pager.BeginRead(ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v, _ := old.GetPage(1).GetValue([]byte{1}); string(v) != "a" {
+				t.Fatalf("expected snapshot to read a got %s", v)
+			}
+			if v, _ := current.GetPage(1).GetValue([]byte{1}); string(v) != "b" {
+				t.Fatalf("expected snapshot to read b got %s", v)
+			}
+			pager.EndRead(old)
+			pager.EndRead(current)
+			if len(pager.history) != 0 {
+				t.Fatalf("expected history to be forgotten got %d versions", len(pager.history))
+			}
+		})
+	}
+}
+
+func TestHotJournal(t *testing.T) {
+	// newCommitted returns a pager on the file with name with a committed
+	// value in page 1.
+	newCommitted := func(t *testing.T, name string) *Pager {
+		pager, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		pager.GetPage(1).SetValue([]byte{1}, []byte{'a'})
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+		return pager
+	}
+	assertRestored := func(t *testing.T, name string) {
+		t.Helper()
+		pager, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := pager.store.JournalExists(); exists {
+			t.Fatal("expected journal to be deleted")
+		}
+		s, err := pager.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer pager.EndRead(s)
+		if v, _ := s.GetPage(1).GetValue([]byte{1}); string(v) != "a" {
+			t.Fatalf("expected committed value a got %s", v)
+		}
+	}
+
+	t.Run("crash mid write", func(t *testing.T) {
+		name := t.TempDir() + "/crash"
+		pager := newCommitted(t, name)
+		// Page 5 is new so only page 1 is journaled.
+		if err := pager.store.CreateJournal([]int{1, 5}); err != nil {
+			t.Fatal(err)
+		}
+		info, err := os.Stat(getJournalName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := int64(journalHeaderSize + journalRecordSize); info.Size() != want {
+			t.Fatalf("expected journal size %d got %d", want, info.Size())
+		}
+		// The commit crashes after overwriting page 1 and growing the file.
+		garbage := bytes.Repeat([]byte{0xff}, pageSize)
+		if _, err := pager.store.WriteAt(garbage, rootPageStart); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pager.store.WriteAt(garbage, rootPageStart+4*pageSize); err != nil {
+			t.Fatal(err)
+		}
+		assertRestored(t, name)
+		info, err = os.Stat(getFileName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() >= rootPageStart+4*pageSize {
+			t.Fatalf("expected file to be truncated got size %d", info.Size())
+		}
+	})
+
+	t.Run("crash writing journal", func(t *testing.T) {
+		name := t.TempDir() + "/crash"
+		newCommitted(t, name)
+		// A journal without a header was not completely written so the
+		// database was never changed.
+		if err := os.WriteFile(getJournalName(name), make([]byte, pageSize), 0644); err != nil {
+			t.Fatal(err)
+		}
+		assertRestored(t, name)
+	})
+
+	t.Run("journal of writer is not hot", func(t *testing.T) {
+		name := t.TempDir() + "/writer"
+		pager := newCommitted(t, name)
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer pager.RollbackWrite()
+		if err := pager.store.CreateJournal([]int{1}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(false, name); err != nil {
+			t.Fatal(err)
+		}
+		if exists, _ := pager.store.JournalExists(); !exists {
+			t.Fatal("expected journal of the writer to be kept")
+		}
+	})
+}
+
+func TestFileHeader(t *testing.T) {
+	newCommitted := func(t *testing.T, name string) {
+		pager, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		pager.GetPage(1).SetValue([]byte{1}, []byte{'a'})
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("format is written", func(t *testing.T) {
+		name := t.TempDir() + "/header"
+		newCommitted(t, name)
+		b, err := os.ReadFile(getFileName(name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(b[fileMagicOffset:fileFormatVersionOffset]); got != fileMagic {
+			t.Fatalf("expected magic %q got %q", fileMagic, got)
+		}
+		if got := binary.LittleEndian.Uint32(b[fileFormatVersionOffset:]); got != fileFormatVersion {
+			t.Fatalf("expected format version %d got %d", fileFormatVersion, got)
+		}
+		if got := binary.LittleEndian.Uint32(b[pageSizeOffset:]); got != pageSize {
+			t.Fatalf("expected page size %d got %d", pageSize, got)
+		}
+		if _, err := New(false, name); err != nil {
+			t.Fatalf("expected database to open got %s", err)
+		}
+	})
+
+	t.Run("not a database", func(t *testing.T) {
+		name := t.TempDir() + "/text"
+		if err := os.WriteFile(getFileName(name), []byte("hello world"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := New(false, name); !errors.Is(err, ErrNotDatabase) {
+			t.Fatalf("expected %v got %v", ErrNotDatabase, err)
+		}
+	})
+
+	cases := []struct {
+		name   string
+		offset int
+		value  uint32
+	}{
+		{name: "format version", offset: fileFormatVersionOffset, value: fileFormatVersion + 1},
+		{name: "page size", offset: pageSizeOffset, value: pageSize * 2},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			name := t.TempDir() + "/incompatible"
+			newCommitted(t, name)
+			f, err := os.OpenFile(getFileName(name), os.O_RDWR, 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer f.Close()
+			b := binary.LittleEndian.AppendUint32(nil, c.value)
+			if _, err := f.WriteAt(b, int64(c.offset)); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := New(false, name); !errors.Is(err, ErrIncompatibleFormat) {
+				t.Fatalf("expected %v got %v", ErrIncompatibleFormat, err)
+			}
+		})
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	name := t.TempDir() + "/checksum"
+	pager, err := New(false, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := pager.BeginWrite(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	pager.GetPage(1).SetValue([]byte{1}, []byte{'a'})
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("intact", func(t *testing.T) {
+		other, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := other.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.EndRead(s)
+		page, err := s.ReadPage(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := page.Check(); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("corrupt", func(t *testing.T) {
+		f, err := os.OpenFile(getFileName(name), os.O_RDWR, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.WriteAt([]byte{0xff}, rootPageStart+pageChecksumOffset-1); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		other, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s, err := other.BeginRead(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer other.EndRead(s)
+		page := s.GetPage(1)
+		if got := page.GetRecordCount(); got != 0 {
+			t.Fatalf("expected corrupt page to be empty got %d records", got)
+		}
+		var ce *CorruptionError
+		if !errors.As(s.Err(), &ce) || ce.PageNumber != 1 {
+			t.Fatalf("expected corruption of page 1 got %v", s.Err())
+		}
+		if !errors.Is(s.Err(), ErrCorrupt) {
+			t.Fatalf("expected %v got %v", ErrCorrupt, s.Err())
+		}
+	})
+}
+
+func TestFreelist(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := pager.BeginWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	freed := pager.NewPage().GetNumber()
+	want := pager.NewPage().GetNumber() + 1
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := pager.BeginWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pager.FreePage(freed)
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+	if got := pager.FreelistCount(); got != 1 {
+		t.Fatalf("expected 1 free page got %d", got)
+	}
+
+	t.Run("rollback keeps freelist", func(t *testing.T) {
+		if err := pager.BeginWrite(ctx); err != nil {
+			t.Fatal(err)
+		}
+		if got := pager.NewPage().GetNumber(); got != freed {
+			t.Fatalf("expected free page %d got %d", freed, got)
+		}
+		pager.RollbackWrite()
+		if got := pager.FreelistCount(); got != 1 {
+			t.Fatalf("expected 1 free page got %d", got)
+		}
+	})
+
+	t.Run("free page is allocated first", func(t *testing.T) {
+		if err := pager.BeginWrite(ctx); err != nil {
+			t.Fatal(err)
+		}
+		page := pager.NewPage()
+		if page.GetNumber() != freed || !page.IsLeaf() || page.GetRecordCount() != 0 {
+			t.Fatalf("expected empty leaf page %d got page %d", freed, page.GetNumber())
+		}
+		if got := pager.NewPage().GetNumber(); got != want {
+			t.Fatalf("expected new page %d got %d", want, got)
+		}
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+		if got := pager.FreelistCount(); got != 0 {
+			t.Fatalf("expected 0 free pages got %d", got)
+		}
+	})
+}
+
+func TestSchemaCookie(t *testing.T) {
+	name := t.TempDir() + "/cookie"
+	pager, err := New(false, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if err := pager.BeginWrite(ctx); err != nil {
+		t.Fatal(err)
+	}
+	pager.SetSchemaCookie(pager.SchemaCookie() + 1)
+	if err := pager.EndWrite(); err != nil {
+		t.Fatal(err)
+	}
+	old, err := pager.BeginRead(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pager.EndRead(old)
+
+	t.Run("rollback keeps cookie", func(t *testing.T) {
+		if err := pager.BeginWrite(ctx); err != nil {
+			t.Fatal(err)
+		}
+		pager.SetSchemaCookie(5)
+		pager.RollbackWrite()
+		if got := pager.SchemaCookie(); got != 1 {
+			t.Fatalf("expected cookie 1 got %d", got)
+		}
+	})
+
+	t.Run("cookie is persisted", func(t *testing.T) {
+		if err := pager.BeginWrite(ctx); err != nil {
+			t.Fatal(err)
+		}
+		pager.SetSchemaCookie(pager.SchemaCookie() + 1)
+		if err := pager.EndWrite(); err != nil {
+			t.Fatal(err)
+		}
+		if got := old.SchemaCookie(); got != 1 {
+			t.Fatalf("expected snapshot cookie 1 got %d", got)
+		}
+		reopened, err := New(false, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := reopened.SchemaCookie(); got != 2 {
+			t.Fatalf("expected cookie 2 got %d", got)
+		}
+	})
+}
+
+func TestPageCheck(t *testing.T) {
+	p := &Page{content: make([]byte, pageSize), number: 1}
+	p.SetType(pageTypeLeaf)
+	p.SetEntries([]PageTuple{{Key: []byte{1}, Value: []byte{'a'}}, {Key: []byte{2}, Value: []byte{'b'}}})
+	if err := p.Check(); err != nil {
+		t.Fatal(err)
+	}
+	binary.LittleEndian.PutUint16(p.content[pageRowOffsetsOffset:], pageSize)
+	if err := p.Check(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v for invalid offset got %v", ErrCorrupt, err)
+	}
+	p.SetType(7)
+	if err := p.Check(); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("expected %v for unknown type got %v", ErrCorrupt, err)
+	}
+}
+
+func TestGetPageAllocs(t *testing.T) {
+	pager, err := New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pager.GetPage(1)
+	allocs := testing.AllocsPerRun(100, func() {
+		pager.GetPage(1)
+	})
+	if allocs > 1 {
+		t.Fatalf("expected a cached page to allocate at most 1 time got %v", allocs)
+	}
+}
+
+func BenchmarkGetPage(b *testing.B) {
+	pager, err := New(true, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	pager.GetPage(1)
+	b.ReportAllocs()
+	for b.Loop() {
+		pager.GetPage(1)
+	}
+}
+
+func BenchmarkWrite(b *testing.B) {
+	pager, err := New(true, "")
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for b.Loop() {
+		if err := pager.BeginWrite(context.Background()); err != nil {
+			b.Fatal(err)
+		}
+		pager.GetPage(1).SetValue([]byte{1}, []byte{1})
+		if err := pager.EndWrite(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
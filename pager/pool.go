@@ -0,0 +1,42 @@
+package pager
+
+import "sync"
+
+// pageBufferPool holds page sized buffers so reading a page from storage does
+// not allocate a new buffer each time.
+var pageBufferPool = sync.Pool{
+	New: func() any {
+		return new([pageSize]byte)
+	},
+}
+
+// pagePool holds Page structures so handing out a page does not allocate.
+var pagePool = sync.Pool{
+	New: func() any {
+		return &Page{}
+	},
+}
+
+// getPageBuffer returns a zeroed page sized buffer.
+func getPageBuffer() []byte {
+	b := pageBufferPool.Get().(*[pageSize]byte)
+	clear(b[:])
+	return b[:]
+}
+
+// releasePages returns the buffers and structures of pages to their pools. A
+// page must not be released while anything may still read it. This includes
+// slices returned by GetEntryView and the page cache. Dirty pages satisfy this
+// once a write ends since they are copies only the write transaction reads and
+// they are never added to the cache. A spilled page has no buffer to return.
+func releasePages(pages []*Page) {
+	for _, page := range pages {
+		if page.content != nil {
+			pageBufferPool.Put((*[pageSize]byte)(page.content))
+		}
+		page.content = nil
+		page.number = 0
+		page.spill = nil
+		pagePool.Put(page)
+	}
+}
@@ -0,0 +1,107 @@
+package pager
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+)
+
+// spillFile holds the content of the dirty pages a write transaction moved out
+// of memory after it exceeded the dirty page limit. The database file cannot
+// hold them since other transactions read it before the write commits, so they
+// are written to a temporary file which only lives as long as the write
+// transaction.
+type spillFile struct {
+	file *os.File
+	// slots are the offsets in file where each spilled page is written. A page
+	// spilled again is written to the same slot.
+	slots map[int]int64
+	// spilled is the number of pages whose content is currently only in file.
+	spilled int
+	// cipher encrypts the pages in file when the database is encrypted so
+	// they are not written to disk in plaintext. It is nil otherwise.
+	cipher *xtsCipher
+	// buf is where a page is encrypted before it is written.
+	buf []byte
+	// err is the first error reading or writing file. A write transaction with
+	// an error spilling cannot commit since its pages may be lost.
+	err error
+}
+
+// newSpillFile creates an empty spill file. The file is removed as soon as it
+// is created so it does not outlive the process on systems which allow it. When
+// encrypt is true pages are encrypted with a random key which is forgotten
+// with the file.
+func newSpillFile(encrypt bool) (*spillFile, error) {
+	f, err := os.CreateTemp("", "cdb-spill-*")
+	if err != nil {
+		return nil, fmt.Errorf("err creating spill file: %w", err)
+	}
+	os.Remove(f.Name())
+	s := &spillFile{file: f, slots: map[int]int64{}}
+	if encrypt {
+		key := make([]byte, xtsKeySize)
+		rand.Read(key)
+		c, err := newXTSCipher(key)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		s.cipher = c
+		s.buf = make([]byte, pageSize)
+	}
+	return s, nil
+}
+
+// spill writes the content of page to the file and drops it from memory. The
+// buffer is not returned to the pool since slices of it given by GetEntryView
+// may still be read. The content is read back the next time the page is used.
+func (s *spillFile) spill(page *Page) error {
+	slot, ok := s.slots[page.number]
+	if !ok {
+		slot = int64(len(s.slots)) * pageSize
+		s.slots[page.number] = slot
+	}
+	content := page.content
+	if s.cipher != nil {
+		s.cipher.encrypt(s.buf, content, uint64(slot))
+		content = s.buf
+	}
+	if _, err := s.file.WriteAt(content, slot); err != nil {
+		return fmt.Errorf("err spilling page %d: %w", page.number, err)
+	}
+	page.content = nil
+	page.spill = s
+	s.spilled += 1
+	return nil
+}
+
+// read reads the spilled content of the page with pageNumber into content. An
+// error is kept in err leaving content zeroed.
+func (s *spillFile) read(pageNumber int, content []byte) {
+	slot := s.slots[pageNumber]
+	_, err := s.file.ReadAt(content, slot)
+	if err != nil {
+		if s.err == nil {
+			s.err = fmt.Errorf("err reading spilled page %d: %w", pageNumber, err)
+		}
+		return
+	}
+	if s.cipher != nil {
+		s.cipher.decrypt(content, content, uint64(slot))
+	}
+}
+
+// unspill reads the content of page back into memory.
+func (s *spillFile) unspill(page *Page) {
+	content := getPageBuffer()
+	s.read(page.number, content)
+	page.content = content
+	page.spill = nil
+	s.spilled -= 1
+}
+
+// close closes and discards the file.
+func (s *spillFile) close() {
+	s.file.Close()
+}
@@ -1,34 +1,95 @@
 package pager
 
-// Storage provides an interface for accessing the filesystem. This allows the
-// database to run on an in memory buffer if desired.
-
 import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"sync"
 )
 
-type storage interface {
+// Journal constants. The journal is formatted with a header followed by a
+// record for each page a commit changes as follows:
+// +------------------------------------------------------------+
+// | HEADER: magic, file size, record count, file header        |
+// +------------------------------------------------------------+
+// | RECORD: page number, page content before the commit        |
+// +------------------------------------------------------------+
+// | RECORD...                                                  |
+// +------------------------------------------------------------+
+const (
+	// journalMagic begins the header of a journal that was completely written.
+	journalMagic = "cdb journal\x00"
+	// journalSizeOffset is the offset of the size of the database file before
+	// the commit. The size is a uint64.
+	journalSizeOffset = len(journalMagic)
+	// journalRecordCountOffset is the offset of the number of records. The
+	// count is a uint32.
+	journalRecordCountOffset = journalSizeOffset + 8
+	// journalFileHeaderOffset is the offset of the file header of the database
+	// before the commit.
+	journalFileHeaderOffset = journalRecordCountOffset + 4
+	// journalHeaderSize is the size of the journal header.
+	journalHeaderSize = journalFileHeaderOffset + rootPageStart
+	// journalRecordSize is the size of a record which is a page pointer
+	// followed by the page.
+	journalRecordSize = pagePointerSize + pageSize
+)
+
+// Storage provides an interface for accessing the filesystem. This allows the
+// database to run on an in memory buffer if desired. Besides reading and
+// writing the database, storage keeps the journal used to undo a commit that
+// fails and the locks that coordinate readers and writers.
+type Storage interface {
 	io.ReaderAt
 	io.WriterAt
-	CreateJournal() error
+	// CreateJournal saves the file header and the pages with pageNumbers so
+	// the changes written to them after it can be undone by RestoreJournal.
+	// Pages that do not exist yet are not saved since they are removed when
+	// the journal is restored.
+	CreateJournal(pageNumbers []int) error
+	// DeleteJournal makes the changes written since CreateJournal durable then
+	// deletes the journal. Once the journal is deleted the changes are
+	// committed.
 	DeleteJournal() error
-	GetLock() lock
+	// JournalExists returns true when there is a journal. A journal that
+	// exists while no write transaction holds the reserved lock was left
+	// behind by a commit that did not finish and is called a hot journal.
+	JournalExists() (bool, error)
+	// RestoreJournal undoes the changes written since the journal was created
+	// and deletes the journal. A journal that was not completely written is
+	// deleted without changing the database since nothing was written after
+	// it.
+	RestoreJournal() error
+	// GetLock returns the lock read transactions share and a write transaction
+	// holds exclusively while it commits.
+	GetLock() Lock
+	// GetReservedLock returns the lock a write transaction holds from when it
+	// begins until it ends so there is only one writer at a time. Readers do
+	// not wait for it.
+	GetReservedLock() Lock
 }
 
 type memoryStorage struct {
-	buf  []byte
-	lock lock
+	buf          []byte
+	lock         Lock
+	reservedLock Lock
 }
 
-func newMemoryStorage() storage {
+// NewMemoryStorage returns storage backed by an in memory buffer. Changes are
+// lost when the storage is no longer referenced.
+func NewMemoryStorage() Storage {
 	return &memoryStorage{
 		buf: make([]byte, pageSize),
 		lock: &memoryLock{
 			l: &sync.RWMutex{},
 		},
+		reservedLock: &memoryLock{
+			l: &sync.RWMutex{},
+		},
 	}
 }
 
@@ -48,7 +109,7 @@ func (mf *memoryStorage) ReadAt(p []byte, off int64) (n int, err error) {
 	return 0, nil
 }
 
-func (mf *memoryStorage) CreateJournal() error {
+func (mf *memoryStorage) CreateJournal(pageNumbers []int) error {
 	// journal does not matter in memory since all data is lost on a crash
 	return nil
 }
@@ -58,53 +119,55 @@ func (mf *memoryStorage) DeleteJournal() error {
 	return nil
 }
 
-func (ms *memoryStorage) GetLock() lock {
+func (mf *memoryStorage) JournalExists() (bool, error) {
+	return false, nil
+}
+
+func (mf *memoryStorage) RestoreJournal() error {
+	return nil
+}
+
+func (ms *memoryStorage) GetLock() Lock {
 	return ms.lock
 }
 
+func (ms *memoryStorage) GetReservedLock() Lock {
+	return ms.reservedLock
+}
+
 type fileStorage struct {
 	file        *os.File
 	journalName string
 	dbFileName  string
-	lock        lock
+	lock        Lock
+	// lockFile is the file locked by reservedLock. A second file is needed
+	// since a file has a single lock which is already used by lock.
+	lockFile     *os.File
+	reservedLock Lock
 }
 
-func newFileStorage(filename string) (storage, error) {
+// NewFileStorage returns storage backed by the database file with filename. A
+// hot journal left behind for the file is not restored until the storage is
+// given to a pager.
+func NewFileStorage(filename string) (Storage, error) {
 	dName := getFileName(filename)
 	jName := getJournalName(filename)
-	jfl, err := os.OpenFile(jName, os.O_RDWR, 0644)
-	// if journal file doesn't exist open normal db file
-	if err != nil && os.IsNotExist(err) {
-		fl, err := os.OpenFile(dName, os.O_RDWR|os.O_CREATE, 0644)
-		if err != nil {
-			return nil, fmt.Errorf("error opening db file: %w", err)
-		}
-		return &fileStorage{
-			file:        fl,
-			dbFileName:  dName,
-			journalName: jName,
-			lock:        newPlatformLock(fl.Fd()),
-		}, nil
-	}
-	// if journal file has an error
+	lfl, err := os.OpenFile(getLockName(filename), os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("error opening journal: %w", err)
+		return nil, fmt.Errorf("error opening lock file: %w", err)
 	}
-	// if no error opening journal use journal as main file
 	fl, err := os.OpenFile(dName, os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
-		return nil, fmt.Errorf("error opening db file to restore journal: %w", err)
+		lfl.Close()
+		return nil, fmt.Errorf("error opening db file: %w", err)
 	}
-	_, err = io.Copy(fl, jfl)
-	if err != nil {
-		return nil, fmt.Errorf("error copying journal to db file: %w", err)
-	}
-	os.Remove(jName)
 	return &fileStorage{
-		file:        fl,
-		dbFileName:  dName,
-		journalName: jName,
-		lock:        newPlatformLock(fl.Fd()),
+		file:         fl,
+		dbFileName:   dName,
+		journalName:  jName,
+		lock:         newPlatformLock(fl.Fd()),
+		lockFile:     lfl,
+		reservedLock: newPlatformLock(lfl.Fd()),
 	}, nil
 }
 
@@ -122,6 +185,13 @@ func getJournalName(filename string) string {
 	return fmt.Sprintf("%s%s.db", filename, journalSuffix)
 }
 
+func getLockName(filename string) string {
+	if filename == "" {
+		return fmt.Sprintf("%s%s.db", DefaultDBFileName, lockSuffix)
+	}
+	return fmt.Sprintf("%s%s.db", filename, lockSuffix)
+}
+
 func (s *fileStorage) WriteAt(p []byte, off int64) (n int, err error) {
 	return s.file.WriteAt(p, off)
 }
@@ -130,18 +200,73 @@ func (s *fileStorage) ReadAt(p []byte, off int64) (n int, err error) {
 	return s.file.ReadAt(p, off)
 }
 
-func (s *fileStorage) CreateJournal() error {
-	f, err := os.OpenFile(s.journalName, os.O_RDWR|os.O_CREATE, 0644)
+// CreateJournal writes the records of the journal before the header. The
+// header is written once the records are durable so a journal with a header is
+// always complete.
+func (s *fileStorage) CreateJournal(pageNumbers []int) error {
+	info, err := s.file.Stat()
+	if err != nil {
+		return fmt.Errorf("error reading db file size %w", err)
+	}
+	size := info.Size()
+	f, err := os.OpenFile(s.journalName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
 		return fmt.Errorf("error creating journal %w", err)
 	}
-	if f.Close() != nil {
-		return fmt.Errorf("error closing journal %w", err)
+	defer f.Close()
+	w := bufio.NewWriter(io.NewOffsetWriter(f, int64(journalHeaderSize)))
+	record := make([]byte, journalRecordSize)
+	records := 0
+	for _, pageNumber := range pageNumbers {
+		off := int64(rootPageStart + (pageNumber-1)*pageSize)
+		if off >= size {
+			continue
+		}
+		binary.LittleEndian.PutUint32(record, uint32(pageNumber))
+		if err := readFull(s.file, record[pagePointerSize:], off); err != nil {
+			return fmt.Errorf("error reading page for journal %w", err)
+		}
+		if _, err := w.Write(record); err != nil {
+			return fmt.Errorf("error writing journal record %w", err)
+		}
+		records += 1
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("error writing journal record %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal %w", err)
+	}
+	header := make([]byte, journalHeaderSize)
+	copy(header, journalMagic)
+	binary.LittleEndian.PutUint64(header[journalSizeOffset:], uint64(size))
+	binary.LittleEndian.PutUint32(header[journalRecordCountOffset:], uint32(records))
+	if err := readFull(s.file, header[journalFileHeaderOffset:], 0); err != nil {
+		return fmt.Errorf("error reading file header for journal %w", err)
+	}
+	if _, err := f.WriteAt(header, 0); err != nil {
+		return fmt.Errorf("error writing journal header %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("error syncing journal %w", err)
 	}
 	return nil
 }
 
+// readFull reads len(b) bytes of r at off. Bytes past the end of r are zero.
+func readFull(r io.ReaderAt, b []byte, off int64) error {
+	n, err := r.ReadAt(b, off)
+	if errors.Is(err, io.EOF) {
+		clear(b[n:])
+		return nil
+	}
+	return err
+}
+
 func (s *fileStorage) DeleteJournal() error {
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing db file %w", err)
+	}
 	err := os.Remove(s.journalName)
 	if err != nil {
 		return fmt.Errorf("error deleting journal %w", err)
@@ -149,6 +274,75 @@ func (s *fileStorage) DeleteJournal() error {
 	return nil
 }
 
-func (s *fileStorage) GetLock() lock {
+func (s *fileStorage) JournalExists() (bool, error) {
+	_, err := os.Stat(s.journalName)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error reading journal %w", err)
+	}
+	return true, nil
+}
+
+// RestoreJournal writes the pages and file header in the journal back to the
+// database file and truncates the file to the size it had when the journal was
+// created.
+func (s *fileStorage) RestoreJournal() error {
+	f, err := os.Open(s.journalName)
+	if err != nil {
+		return fmt.Errorf("error opening journal %w", err)
+	}
+	defer f.Close()
+	header := make([]byte, journalHeaderSize)
+	_, err = f.ReadAt(header, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("error reading journal header %w", err)
+	}
+	if err == nil && bytes.Equal(header[:journalSizeOffset], []byte(journalMagic)) {
+		if err := s.restoreRecords(f, header); err != nil {
+			return err
+		}
+	}
+	if err := os.Remove(s.journalName); err != nil {
+		return fmt.Errorf("error deleting journal %w", err)
+	}
+	return nil
+}
+
+// restoreRecords writes the records of journal with header to the database
+// file.
+func (s *fileStorage) restoreRecords(journal *os.File, header []byte) error {
+	size := int64(binary.LittleEndian.Uint64(header[journalSizeOffset:]))
+	records := int(binary.LittleEndian.Uint32(header[journalRecordCountOffset:]))
+	r := bufio.NewReader(io.NewSectionReader(journal, int64(journalHeaderSize), int64(records*journalRecordSize)))
+	record := make([]byte, journalRecordSize)
+	for range records {
+		if _, err := io.ReadFull(r, record); err != nil {
+			return fmt.Errorf("error reading journal record %w", err)
+		}
+		pageNumber := int(binary.LittleEndian.Uint32(record))
+		off := int64(rootPageStart + (pageNumber-1)*pageSize)
+		if _, err := s.file.WriteAt(record[pagePointerSize:], off); err != nil {
+			return fmt.Errorf("error restoring page %d %w", pageNumber, err)
+		}
+	}
+	if _, err := s.file.WriteAt(header[journalFileHeaderOffset:], 0); err != nil {
+		return fmt.Errorf("error restoring file header %w", err)
+	}
+	if err := s.file.Truncate(size); err != nil {
+		return fmt.Errorf("error truncating db file %w", err)
+	}
+	if err := s.file.Sync(); err != nil {
+		return fmt.Errorf("error syncing db file %w", err)
+	}
+	return nil
+}
+
+func (s *fileStorage) GetLock() Lock {
 	return s.lock
 }
+
+func (s *fileStorage) GetReservedLock() Lock {
+	return s.reservedLock
+}
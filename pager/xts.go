@@ -0,0 +1,80 @@
+package pager
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/subtle"
+	"encoding/binary"
+	"fmt"
+)
+
+// xtsKeySize is the size of the key of an xtsCipher which is two AES-256 keys.
+const xtsKeySize = 64
+
+// xtsCipher encrypts pages with AES in XTS mode as specified by IEEE 1619. XTS
+// is the mode used for disk encryption. It does not change the length of what
+// it encrypts so an encrypted page is still a page, and it is tweaked with the
+// number of the page so equal pages are encrypted differently at different
+// places in the file. The checksum of a page is encrypted with it so a page
+// that is tampered with decrypts to a page with a checksum that does not match.
+type xtsCipher struct {
+	// data encrypts the blocks of a page.
+	data cipher.Block
+	// tweak encrypts the number of the page into the first tweak.
+	tweak cipher.Block
+}
+
+// newXTSCipher creates an xtsCipher with a key of xtsKeySize bytes.
+func newXTSCipher(key []byte) (*xtsCipher, error) {
+	if len(key) != xtsKeySize {
+		return nil, fmt.Errorf("xts key must be %d bytes", xtsKeySize)
+	}
+	data, err := aes.NewCipher(key[:xtsKeySize/2])
+	if err != nil {
+		return nil, err
+	}
+	tweak, err := aes.NewCipher(key[xtsKeySize/2:])
+	if err != nil {
+		return nil, err
+	}
+	return &xtsCipher{data: data, tweak: tweak}, nil
+}
+
+// encrypt encrypts src, which is a multiple of the AES block size, tweaked with
+// sector into dst. dst and src may be the same slice.
+func (x *xtsCipher) encrypt(dst, src []byte, sector uint64) {
+	x.crypt(dst, src, sector, x.data.Encrypt)
+}
+
+// decrypt decrypts what encrypt encrypted with the same sector.
+func (x *xtsCipher) decrypt(dst, src []byte, sector uint64) {
+	x.crypt(dst, src, sector, x.data.Decrypt)
+}
+
+func (x *xtsCipher) crypt(dst, src []byte, sector uint64, block func(dst, src []byte)) {
+	if len(src)%aes.BlockSize != 0 {
+		panic("xts input is not a multiple of the block size")
+	}
+	var tweak [aes.BlockSize]byte
+	binary.LittleEndian.PutUint64(tweak[:], sector)
+	x.tweak.Encrypt(tweak[:], tweak[:])
+	var b [aes.BlockSize]byte
+	for i := 0; i < len(src); i += aes.BlockSize {
+		subtle.XORBytes(b[:], src[i:i+aes.BlockSize], tweak[:])
+		block(b[:], b[:])
+		subtle.XORBytes(dst[i:i+aes.BlockSize], b[:], tweak[:])
+		mulAlpha(&tweak)
+	}
+}
+
+// mulAlpha multiplies tweak by the primitive element of GF(2^128) which moves
+// the tweak to the next block.
+func mulAlpha(tweak *[aes.BlockSize]byte) {
+	lo := binary.LittleEndian.Uint64(tweak[:8])
+	hi := binary.LittleEndian.Uint64(tweak[8:])
+	carry := hi >> 63
+	hi = hi<<1 | lo>>63
+	lo = lo<<1 ^ carry*0x87
+	binary.LittleEndian.PutUint64(tweak[:8], lo)
+	binary.LittleEndian.PutUint64(tweak[8:], hi)
+}
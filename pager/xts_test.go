@@ -0,0 +1,53 @@
+package pager
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestXTSCipher(t *testing.T) {
+	key := make([]byte, xtsKeySize)
+	for i := range key {
+		key[i] = byte(i)
+	}
+	c, err := newXTSCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext := make([]byte, 64)
+	for i := range plaintext {
+		plaintext[i] = byte(100 + i)
+	}
+
+	t.Run("matches openssl", func(t *testing.T) {
+		want, _ := hex.DecodeString(
+			"b8ff1d678592c1fa69a9022989a6c5932112558d34ad995b67cc87c03a25f9d5" +
+				"a1ea8f47e41d1ecdfd29c0eb6a043269da7ebf3c99d466f76c8868b755ec948a",
+		)
+		got := make([]byte, len(plaintext))
+		c.encrypt(got, plaintext, 5)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("expected %x got %x", want, got)
+		}
+	})
+
+	t.Run("round trip in place", func(t *testing.T) {
+		b := bytes.Clone(plaintext)
+		c.encrypt(b, b, 7)
+		c.decrypt(b, b, 7)
+		if !bytes.Equal(b, plaintext) {
+			t.Fatalf("expected %x got %x", plaintext, b)
+		}
+	})
+
+	t.Run("tweaked by sector", func(t *testing.T) {
+		a := make([]byte, len(plaintext))
+		b := make([]byte, len(plaintext))
+		c.encrypt(a, plaintext, 1)
+		c.encrypt(b, plaintext, 2)
+		if bytes.Equal(a, b) {
+			t.Fatal("expected sectors to encrypt differently")
+		}
+	})
+}
@@ -0,0 +1,135 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/chirst/cdb/compiler"
+)
+
+// groupColumn is a column of the group table made by an aggregateNode. It
+// replaces a GROUP BY expression or an aggregate function in expressions that
+// are computed after grouping. It is also a column of the rows a sorter holds
+// for a join. See orderNode.
+type groupColumn struct {
+	// expr is the GROUP BY expression or aggregate function the column holds
+	// the result of.
+	expr compiler.Expr
+	// colIdx is the position of the column in the group table or sorter.
+	colIdx int
+}
+
+// BreadthWalk does not visit anything since groupColumn only exists after
+// visitors have resolved the expressions it replaces.
+func (g *groupColumn) BreadthWalk(v compiler.ExprVisitor) {}
+
+func (g *groupColumn) Print() string {
+	return g.expr.Print()
+}
+
+// aggregateRewriter rewrites expressions that are computed after grouping so
+// they refer to the columns of the group table.
+type aggregateRewriter struct {
+	// groupBy are the GROUP BY expressions. The group table has a column for
+	// each in the same order.
+	groupBy []compiler.Expr
+	// aggregates are the distinct aggregate functions found while rewriting.
+	// The group table has a column for each following the groupBy columns.
+	aggregates []*compiler.FunctionExpr
+}
+
+// rewrite returns e with GROUP BY expressions and aggregate functions replaced
+// by group table columns. Column references outside of an aggregate function
+// must be part of the GROUP BY since the value would otherwise be ambiguous.
+func (r *aggregateRewriter) rewrite(e compiler.Expr) (compiler.Expr, error) {
+	if key, ok := exprKey(e); ok {
+		for i, g := range r.groupBy {
+			if gk, ok := exprKey(g); ok && gk == key {
+				return &groupColumn{expr: e, colIdx: i}, nil
+			}
+		}
+	}
+	switch n := e.(type) {
+	case *compiler.FunctionExpr:
+		if !isAggregate(n) {
+			args := []compiler.Expr{}
+			for _, arg := range n.Args {
+				a, err := r.rewrite(arg)
+				if err != nil {
+					return nil, err
+				}
+				args = append(args, a)
+			}
+			return &compiler.FunctionExpr{FnType: n.FnType, Args: args}, nil
+		}
+		if err := checkAggregate(n); err != nil {
+			return nil, err
+		}
+		key, _ := exprKey(n)
+		for i, a := range r.aggregates {
+			if ak, _ := exprKey(a); ak == key {
+				return &groupColumn{expr: n, colIdx: len(r.groupBy) + i}, nil
+			}
+		}
+		r.aggregates = append(r.aggregates, n)
+		return &groupColumn{expr: n, colIdx: len(r.groupBy) + len(r.aggregates) - 1}, nil
+	case *compiler.BinaryExpr:
+		left, err := r.rewrite(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := r.rewrite(n.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.BinaryExpr{Left: left, Operator: n.Operator, Right: right}, nil
+	case *compiler.CastExpr:
+		expr, err := r.rewrite(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.CastExpr{Expr: expr, TypeName: n.TypeName}, nil
+	case *compiler.ColumnRef:
+		return nil, fmt.Errorf("%w: %s", errColumnNotGrouped, n.Column)
+	}
+	return e, nil
+}
+
+// checkAggregate returns an error when f is not a known aggregate function or
+// has the wrong arguments for the function.
+func checkAggregate(f *compiler.FunctionExpr) error {
+	switch f.FnType {
+	case compiler.FnCount:
+		if f.Args != nil && len(f.Args) != 1 {
+			return fmt.Errorf("%w %s", errFunctionArgs, f.FnType)
+		}
+	case compiler.FnSum, compiler.FnMin, compiler.FnMax:
+		if len(f.Args) != 1 {
+			return fmt.Errorf("%w %s", errFunctionArgs, f.FnType)
+		}
+	default:
+		return fmt.Errorf("%w: %s", errNoFunction, f.FnType)
+	}
+	for _, arg := range f.Args {
+		if hasAggregate(arg) {
+			return errNestedAggregate
+		}
+	}
+	return nil
+}
+
+// hasAggregate returns true when e contains an aggregate function.
+func hasAggregate(e compiler.Expr) bool {
+	switch n := e.(type) {
+	case *compiler.FunctionExpr:
+		if isAggregate(n) {
+			return true
+		}
+		return slices.ContainsFunc(n.Args, hasAggregate)
+	case *compiler.BinaryExpr:
+		return hasAggregate(n.Left) || hasAggregate(n.Right)
+	case *compiler.CastExpr:
+		return hasAggregate(n.Expr)
+	}
+	return false
+}
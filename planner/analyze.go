@@ -0,0 +1,118 @@
+package planner
+
+import (
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// analyzeCatalog defines the catalog methods needed by the analyze planner.
+type analyzeCatalog interface {
+	indexCatalog
+	GetRootPageNumber(tableOrIndexName string) (int, error)
+	GetTableNames() []string
+	TableExists(tableName string) bool
+	GetVersion() string
+}
+
+// analyzePlanner is capable of generating a logical query plan and a physical
+// executionPlan for an analyze statement.
+type analyzePlanner struct {
+	// catalog contains the schema
+	catalog analyzeCatalog
+	// stmt contains the AST
+	stmt *compiler.AnalyzeStmt
+	// queryPlan contains the query plan being constructed. The root node must
+	// be analyzeNode.
+	queryPlan *analyzeNode
+	// executionPlan contains the bytecode execution plan being constructed.
+	// This is populated by calling ExecutionPlan.
+	executionPlan *vm.ExecutionPlan
+}
+
+// NewAnalyze creates a planner for the given analyze statement.
+func NewAnalyze(catalog analyzeCatalog, stmt *compiler.AnalyzeStmt) *analyzePlanner {
+	return &analyzePlanner{
+		catalog: catalog,
+		stmt:    stmt,
+		executionPlan: vm.NewExecutionPlan(
+			catalog.GetVersion(),
+			stmt.Explain,
+		),
+	}
+}
+
+// QueryPlan generates the query plan for the planner. Every table other than
+// the system tables is analyzed when the statement does not name a table.
+func (p *analyzePlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(p.stmt.SchemaName); err != nil {
+		return nil, err
+	}
+	node := &analyzeNode{
+		tableName:             p.stmt.TableName,
+		catalogRootPageNumber: 1,
+		catalogCursorId:       1,
+		statCursorId:          2,
+	}
+	tableNames := []string{p.stmt.TableName}
+	if p.stmt.TableName == "" {
+		tableNames = []string{}
+		for _, tableName := range p.catalog.GetTableNames() {
+			if !isSystemTable(tableName) {
+				tableNames = append(tableNames, tableName)
+			}
+		}
+	} else if !p.catalog.TableExists(p.stmt.TableName) {
+		return nil, errTableNotExist
+	}
+	if p.catalog.TableExists(catalog.StatTableName) {
+		rootPage, err := p.catalog.GetRootPageNumber(catalog.StatTableName)
+		if err != nil {
+			return nil, err
+		}
+		node.statRootPageNumber = rootPage
+	} else {
+		statSchema, err := catalog.StatTableSchema().ToJSON()
+		if err != nil {
+			return nil, err
+		}
+		node.statSchema = string(statSchema)
+	}
+	cursorId := node.statCursorId + 1
+	for _, tableName := range tableNames {
+		rootPage, err := p.catalog.GetRootPageNumber(tableName)
+		if err != nil {
+			return nil, err
+		}
+		indexes, err := getSecondaryIndexes(p.catalog, tableName, cursorId+1)
+		if err != nil {
+			return nil, err
+		}
+		node.tables = append(node.tables, analyzeTable{
+			name:           tableName,
+			rootPageNumber: rootPage,
+			cursorId:       cursorId,
+			indexes:        indexes,
+		})
+		cursorId += len(indexes) + 1
+	}
+	p.queryPlan = node
+	qp := newQueryPlan(node, p.stmt.ExplainQueryPlan, transactionTypeWrite)
+	node.plan = qp
+	return qp, nil
+}
+
+// ExecutionPlan returns the bytecode execution plan for the planner. Calling
+// QueryPlan is not a prerequisite to this method as it will be called by
+// ExecutionPlan if needed.
+func (p *analyzePlanner) ExecutionPlan() (*vm.ExecutionPlan, error) {
+	if p.queryPlan == nil {
+		_, err := p.QueryPlan()
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.queryPlan.plan.compile()
+	p.executionPlan.Commands = p.queryPlan.plan.commands
+	return p.executionPlan, nil
+}
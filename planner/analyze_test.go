@@ -0,0 +1,123 @@
+package planner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+type mockAnalyzeCatalog struct {
+	statExists bool
+}
+
+func (*mockAnalyzeCatalog) GetColumns(tableName string) ([]string, error) {
+	return []string{"id", "name"}, nil
+}
+
+func (*mockAnalyzeCatalog) GetPrimaryKeyColumn(tableName string) (string, error) {
+	return "id", nil
+}
+
+func (*mockAnalyzeCatalog) GetIndexes(tableName string) []catalog.Index {
+	return []catalog.Index{{Name: "foo_name", TableName: "foo", RootPageNumber: 3, Columns: []string{"name"}}}
+}
+
+func (m *mockAnalyzeCatalog) GetRootPageNumber(tableOrIndexName string) (int, error) {
+	if tableOrIndexName == catalog.StatTableName {
+		return 4, nil
+	}
+	return 2, nil
+}
+
+func (m *mockAnalyzeCatalog) GetTableNames() []string {
+	names := []string{catalog.SchemaTableName, "foo"}
+	if m.statExists {
+		names = append(names, catalog.StatTableName)
+	}
+	return names
+}
+
+func (m *mockAnalyzeCatalog) TableExists(tableName string) bool {
+	return tableName == "foo" || tableName == catalog.StatTableName && m.statExists
+}
+
+func (*mockAnalyzeCatalog) GetVersion() string {
+	return "v"
+}
+
+func TestAnalyze(t *testing.T) {
+	t.Run("table", func(t *testing.T) {
+		stmt := &compiler.AnalyzeStmt{StmtBase: &compiler.StmtBase{}, TableName: "foo"}
+		expectedCommands := []vm.Command{
+			&vm.InitCmd{P2: 35},
+			&vm.OpenWriteCmd{P1: 2, P2: 4},
+			&vm.RewindCmd{P1: 2, P2: 7},
+			&vm.ColumnCmd{P1: 2, P2: 0, P3: 1},
+			&vm.NotEqualCmd{P1: 1, P2: 6, P3: 2},
+			&vm.DeleteCmd{P1: 2},
+			&vm.NextCmd{P1: 2, P2: 3},
+			&vm.OpenReadCmd{P1: 3, P2: 2},
+			&vm.CountCmd{P1: 3, P2: 3},
+			&vm.NewRowIdCmd{P1: 2, P2: 4},
+			&vm.StringCmd{P1: 5, P4: "foo"},
+			&vm.NullCmd{P2: 6},
+			&vm.CopyCmd{P1: 3, P2: 7},
+			&vm.NullCmd{P2: 8},
+			&vm.MakeRecordCmd{P1: 5, P2: 4, P3: 9},
+			&vm.InsertCmd{P1: 2, P2: 9, P3: 4},
+			&vm.OpenIndexCmd{P1: 4, P2: 3, P3: 0, P5: 1},
+			&vm.CountCmd{P1: 4, P2: 10},
+			&vm.CopyCmd{P1: 14, P2: 11},
+			&vm.NullCmd{P2: 12},
+			&vm.RewindCmd{P1: 4, P2: 26},
+			&vm.ColumnCmd{P1: 4, P2: 0, P3: 13},
+			&vm.EqCmd{P1: 13, P2: 25, P3: 12},
+			&vm.AddCmd{P1: 11, P2: 15, P3: 11},
+			&vm.CopyCmd{P1: 13, P2: 12},
+			&vm.NextCmd{P1: 4, P2: 21},
+			&vm.NewRowIdCmd{P1: 2, P2: 16},
+			&vm.StringCmd{P1: 17, P4: "foo"},
+			&vm.StringCmd{P1: 18, P4: "foo_name"},
+			&vm.CopyCmd{P1: 10, P2: 19},
+			&vm.CopyCmd{P1: 11, P2: 20},
+			&vm.MakeRecordCmd{P1: 17, P2: 4, P3: 21},
+			&vm.InsertCmd{P1: 2, P2: 21, P3: 16},
+			&vm.ParseSchemaCmd{},
+			&vm.HaltCmd{},
+			&vm.TransactionCmd{P2: 1},
+			&vm.IntegerCmd{P1: 0, P2: 14},
+			&vm.IntegerCmd{P1: 1, P2: 15},
+			&vm.StringCmd{P1: 2, P4: "foo"},
+			&vm.GotoCmd{P2: 1},
+		}
+		plan, err := NewAnalyze(&mockAnalyzeCatalog{statExists: true}, stmt).ExecutionPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("creates stat table", func(t *testing.T) {
+		stmt := &compiler.AnalyzeStmt{StmtBase: &compiler.StmtBase{}}
+		qp, err := NewAnalyze(&mockAnalyzeCatalog{}, stmt).QueryPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "create table cdb_stat and analyze foo"
+		if got := qp.root.print(); got != expected {
+			t.Fatalf("expected %s got %s", expected, got)
+		}
+	})
+
+	t.Run("no such table", func(t *testing.T) {
+		stmt := &compiler.AnalyzeStmt{StmtBase: &compiler.StmtBase{}, TableName: "bar"}
+		if _, err := NewAnalyze(&mockAnalyzeCatalog{}, stmt).QueryPlan(); !errors.Is(err, errTableNotExist) {
+			t.Fatalf("expected %v got %v", errTableNotExist, err)
+		}
+	})
+}
@@ -1,15 +1,22 @@
 package planner
 
 import (
+	"fmt"
+	"slices"
+	"strings"
+
 	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
 )
 
 // catalogExprVisitor assigns catalog information to visited expressions.
 type catalogExprVisitor struct {
-	catalog   cevCatalog
-	tableName string
-	err       error
+	catalog cevCatalog
+	// scopes are the tables column references are resolved against.
+	scopes []*tableScope
+	// err is the first error found while visiting such as a column that is not
+	// part of the table.
+	err error
 }
 
 type cevCatalog interface {
@@ -18,26 +25,82 @@ type cevCatalog interface {
 	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
 }
 
-func (c *catalogExprVisitor) Init(catalog cevCatalog, tableName string) {
+// tableScope is the columns of a table a column reference can be resolved
+// against.
+type tableScope struct {
+	tableName string
+	columns   []string
+	pkColumn  string
+}
+
+// newTableScope returns the scope of tableName.
+func newTableScope(catalog cevCatalog, tableName string) (*tableScope, error) {
+	pkColumn, err := catalog.GetPrimaryKeyColumn(tableName)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := catalog.GetColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+	return &tableScope{
+		tableName: tableName,
+		columns:   columns,
+		pkColumn:  pkColumn,
+	}, nil
+}
+
+func (c *catalogExprVisitor) Init(catalog cevCatalog, tableNames ...string) error {
 	c.catalog = catalog
-	c.tableName = tableName
+	c.scopes = []*tableScope{}
+	for _, tableName := range tableNames {
+		if tableName == "" {
+			continue
+		}
+		scope, err := newTableScope(catalog, tableName)
+		if err != nil {
+			return err
+		}
+		c.scopes = append(c.scopes, scope)
+	}
+	return nil
+}
+
+// bindExpr assigns catalog information for tableName to the column references
+// in e. An error is returned when e references a column that does not exist.
+func bindExpr(catalog cevCatalog, tableName string, e compiler.Expr) error {
+	return bindExprTables(catalog, []string{tableName}, e)
+}
+
+// bindExprTables is bindExpr for an expression that can reference the columns
+// of each of tableNames. An unqualified column that is part of more than one
+// of the tables is ambiguous.
+func bindExprTables(catalog cevCatalog, tableNames []string, e compiler.Expr) error {
+	cev := &catalogExprVisitor{}
+	if err := cev.Init(catalog, tableNames...); err != nil {
+		return err
+	}
+	e.BreadthWalk(cev)
+	return cev.err
 }
 
 func (c *catalogExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef) {
-	pkCol, err := c.catalog.GetPrimaryKeyColumn(c.tableName)
-	if err != nil {
-		c.err = err
+	if c.err != nil {
 		return
 	}
-	cols, err := c.catalog.GetColumns(c.tableName)
+	scope, err := c.resolve(e)
 	if err != nil {
 		c.err = err
 		return
 	}
+	if len(c.scopes) > 1 {
+		// The columns of a join are read from the cursor of their table.
+		e.Table = scope.tableName
+	}
 	idx := 0
-	e.IsPrimaryKey = e.Column == pkCol
-	for _, col := range cols {
-		if col != pkCol {
+	e.IsPrimaryKey = e.Column == scope.pkColumn
+	for _, col := range scope.columns {
+		if col != scope.pkColumn {
 			if e.Column == col {
 				e.ColIdx = idx
 			}
@@ -45,7 +108,7 @@ func (c *catalogExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef) {
 		}
 	}
 
-	t, err := c.catalog.GetColumnType(c.tableName, e.Column)
+	t, err := c.catalog.GetColumnType(scope.tableName, e.Column)
 	if err != nil {
 		c.err = err
 		return
@@ -53,9 +116,51 @@ func (c *catalogExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef) {
 	e.Type = t
 }
 
+// resolve returns the scope of the table e is a column of.
+func (c *catalogExprVisitor) resolve(e *compiler.ColumnRef) (*tableScope, error) {
+	var found *tableScope
+	for _, scope := range c.scopes {
+		if e.Table != "" && e.Table != scope.tableName {
+			continue
+		}
+		if !slices.Contains(scope.columns, e.Column) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf(
+				"%w: %s (in tables: %s, %s)",
+				errAmbiguousColumn,
+				e.Column,
+				found.tableName,
+				scope.tableName,
+			)
+		}
+		found = scope
+	}
+	if found != nil {
+		return found, nil
+	}
+	name := e.Column
+	if e.Table != "" {
+		name = e.Table + "." + e.Column
+	}
+	if len(c.scopes) != 1 {
+		return nil, fmt.Errorf("%w: %s", errColumnNotExist, name)
+	}
+	return nil, fmt.Errorf(
+		"%w: %s (available columns: %s)",
+		errColumnNotExist,
+		name,
+		strings.Join(c.scopes[0].columns, ", "),
+	)
+}
+
 func (c *catalogExprVisitor) VisitBinaryExpr(e *compiler.BinaryExpr)     {}
 func (c *catalogExprVisitor) VisitUnaryExpr(e *compiler.UnaryExpr)       {}
 func (c *catalogExprVisitor) VisitIntLit(e *compiler.IntLit)             {}
+func (c *catalogExprVisitor) VisitFloatLit(e *compiler.FloatLit)         {}
 func (c *catalogExprVisitor) VisitStringLit(e *compiler.StringLit)       {}
+func (c *catalogExprVisitor) VisitNullLit(e *compiler.NullLit)           {}
 func (c *catalogExprVisitor) VisitVariable(e *compiler.Variable)         {}
+func (c *catalogExprVisitor) VisitCastExpr(e *compiler.CastExpr)         {}
 func (c *catalogExprVisitor) VisitFunctionExpr(e *compiler.FunctionExpr) {}
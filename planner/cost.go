@@ -0,0 +1,102 @@
+package planner
+
+import (
+	"math"
+
+	"github.com/chirst/cdb/catalog"
+)
+
+// The cost of reading rows is estimated in the number of rows visited by a scan
+// of the table. A table that has not been analyzed is assumed to be large and
+// its indexes selective so an index is used whenever it can be, which is how
+// plans were made before there were statistics.
+const (
+	// defaultRowCount is the number of rows assumed to be in a table that has
+	// not been analyzed.
+	defaultRowCount = 1_000_000
+	// defaultRowsPerValue is the number of rows assumed to have each value of
+	// a column with an index that has not been analyzed.
+	defaultRowsPerValue = 10
+	// prefixSelectivity is the fraction of the entries of an index assumed to
+	// start with a prefix. A prefix is a range of values with two bounds and
+	// each bound is assumed to exclude three quarters of the entries.
+	prefixSelectivity = 1.0 / 16
+	// lookupCost is the cost of finding a row by its row id after finding its
+	// entry in an index that does not cover the query. Each lookup reads the
+	// pages from the root of the table to the row so it costs several times
+	// more than visiting the next row of a scan.
+	lookupCost = 4
+)
+
+// statCatalog defines the catalog methods needed to estimate the cost of
+// reading rows.
+type statCatalog interface {
+	GetTableStat(tableName string) (catalog.Stat, bool)
+	GetIndexStat(indexName string) (catalog.Stat, bool)
+}
+
+// tableStats are the statistics of a table used to choose how its rows are
+// read.
+type tableStats struct {
+	// analyzed is true when the statistics were gathered by ANALYZE rather than
+	// assumed.
+	analyzed bool
+	// rowCount is the number of rows in the table.
+	rowCount int
+	// rowsPerValue is the average number of rows with each value of the
+	// indexed column keyed by the name of the index.
+	rowsPerValue map[string]float64
+}
+
+// getTableStats returns the statistics of tableName and indexes. The default
+// statistics are assumed for a table or index that has not been analyzed.
+func getTableStats(c statCatalog, tableName string, indexes []secondaryIndex) tableStats {
+	stats := tableStats{
+		rowCount:     defaultRowCount,
+		rowsPerValue: map[string]float64{},
+	}
+	if tableStat, ok := c.GetTableStat(tableName); ok {
+		stats.analyzed = true
+		stats.rowCount = tableStat.RowCount
+	}
+	for _, index := range indexes {
+		rowsPerValue := float64(defaultRowsPerValue)
+		if index.unique || index.isPrimaryKey {
+			rowsPerValue = 1
+		}
+		if indexStat, ok := c.GetIndexStat(index.name); ok && indexStat.DistinctCount > 0 {
+			rowsPerValue = float64(indexStat.RowCount) / float64(indexStat.DistinctCount)
+		}
+		stats.rowsPerValue[index.name] = max(rowsPerValue, 1)
+	}
+	return stats
+}
+
+// scanCost is the cost of visiting every row of the table.
+func (s tableStats) scanCost() float64 {
+	return float64(s.rowCount)
+}
+
+// sortCost is the cost of scanning the table and sorting the rows.
+func (s tableStats) sortCost() float64 {
+	n := float64(max(s.rowCount, 1))
+	return n + n*math.Log2(n)
+}
+
+// indexSeekRows is the estimated number of rows visited by a seek of index for
+// a value or a prefix when prefix is true.
+func (s tableStats) indexSeekRows(index *secondaryIndex, prefix bool) float64 {
+	if prefix {
+		return max(float64(s.rowCount)*prefixSelectivity, 1)
+	}
+	return s.rowsPerValue[index.name]
+}
+
+// indexCost is the cost of visiting rows entries of an index and the rows of
+// the table they are for. The table is not read when the index is covering.
+func indexCost(rows float64, covering bool) float64 {
+	if covering {
+		return rows
+	}
+	return rows * (1 + lookupCost)
+}
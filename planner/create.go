@@ -13,6 +13,7 @@ type createCatalog interface {
 	GetColumns(tableOrIndexName string) ([]string, error)
 	GetRootPageNumber(tableOrIndexName string) (int, error)
 	TableExists(tableName string) bool
+	IndexExists(indexName string) bool
 	GetVersion() string
 }
 
@@ -45,6 +46,9 @@ func NewCreate(catalog createCatalog, stmt *compiler.CreateStmt) *createPlanner
 
 // QueryPlan generates the query plan for the planner.
 func (p *createPlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(p.stmt.SchemaName); err != nil {
+		return nil, err
+	}
 	schemaTableRoot := 1
 	tableExists := p.catalog.TableExists(p.stmt.TableName)
 	if p.stmt.IfNotExists && tableExists {
@@ -66,6 +70,9 @@ func (p *createPlanner) QueryPlan() (*QueryPlan, error) {
 	if tableExists {
 		return nil, errTableExists
 	}
+	if p.catalog.IndexExists(p.stmt.TableName) {
+		return nil, errIndexExists
+	}
 	jSchema, err := p.getSchemaString()
 	if err != nil {
 		return nil, err
@@ -78,6 +85,13 @@ func (p *createPlanner) QueryPlan() (*QueryPlan, error) {
 		catalogRootPageNumber: schemaTableRoot,
 		catalogCursorId:       1,
 	}
+	if p.isAutoIncrement() && !p.catalog.TableExists(catalog.SequenceTableName) {
+		sequenceSchema, err := catalog.SequenceTableSchema().ToJSON()
+		if err != nil {
+			return nil, err
+		}
+		createNode.sequenceSchema = string(sequenceSchema)
+	}
 	p.queryPlan = createNode
 	qp := newQueryPlan(
 		createNode,
@@ -121,6 +135,13 @@ func (p *createPlanner) ensurePrimaryKeyInteger() error {
 	return nil
 }
 
+// isAutoIncrement returns true when the primary key is AUTOINCREMENT.
+func (p *createPlanner) isAutoIncrement() bool {
+	return slices.ContainsFunc(p.stmt.ColDefs, func(cd compiler.ColDef) bool {
+		return cd.AutoIncrement
+	})
+}
+
 // Only one primary key is supported at this time.
 func (p *createPlanner) ensurePrimaryKeyCount() error {
 	count := 0
@@ -141,9 +162,11 @@ func (p *createPlanner) schemaFrom() *catalog.TableSchema {
 	}
 	for _, cd := range p.stmt.ColDefs {
 		schema.Columns = append(schema.Columns, catalog.TableColumn{
-			Name:       cd.ColName,
-			ColType:    cd.ColType,
-			PrimaryKey: cd.PrimaryKey,
+			Name:          cd.ColName,
+			ColType:       cd.ColType,
+			PrimaryKey:    cd.PrimaryKey,
+			Default:       cd.Default,
+			AutoIncrement: cd.AutoIncrement,
 		})
 	}
 	return &schema
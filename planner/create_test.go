@@ -25,6 +25,10 @@ func (m *mockCreateCatalog) TableExists(tableName string) bool {
 	return m.tableExistsRes
 }
 
+func (*mockCreateCatalog) IndexExists(indexName string) bool {
+	return false
+}
+
 func (*mockCreateCatalog) GetVersion() string {
 	return "v"
 }
@@ -54,7 +58,7 @@ func TestCreateWithNoIDColumn(t *testing.T) {
 		t.Fatalf("failed to convert expected schema to json %s", err)
 	}
 	expectedCommands := []vm.Command{
-		&vm.InitCmd{P2: 13},
+		&vm.InitCmd{P2: 14},
 		&vm.OpenWriteCmd{P1: 1, P2: 1},
 		&vm.CreateBTreeCmd{P2: 1},
 		&vm.NewRowIdCmd{P1: 1, P2: 2},
@@ -63,8 +67,9 @@ func TestCreateWithNoIDColumn(t *testing.T) {
 		&vm.StringCmd{P1: 5, P4: "foo"},
 		&vm.CopyCmd{P1: 1, P2: 6},
 		&vm.StringCmd{P1: 7, P4: string(expectedJSONSchema)},
-		&vm.MakeRecordCmd{P1: 3, P2: 5, P3: 8},
-		&vm.InsertCmd{P1: 1, P2: 8, P3: 2},
+		&vm.CurrentTimeCmd{P1: 8, P4: vm.TimestampLayout},
+		&vm.MakeRecordCmd{P1: 3, P2: 6, P3: 9},
+		&vm.InsertCmd{P1: 1, P2: 9, P3: 2},
 		&vm.ParseSchemaCmd{},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
@@ -112,7 +117,7 @@ func TestCreateWithAlternateNamedIDColumn(t *testing.T) {
 		t.Fatalf("failed to convert expected schema to json %s", err)
 	}
 	expectedCommands := []vm.Command{
-		&vm.InitCmd{P2: 13},
+		&vm.InitCmd{P2: 14},
 		&vm.OpenWriteCmd{P1: 1, P2: 1},
 		&vm.CreateBTreeCmd{P2: 1},
 		&vm.NewRowIdCmd{P1: 1, P2: 2},
@@ -121,8 +126,9 @@ func TestCreateWithAlternateNamedIDColumn(t *testing.T) {
 		&vm.StringCmd{P1: 5, P4: "foo"},
 		&vm.CopyCmd{P1: 1, P2: 6},
 		&vm.StringCmd{P1: 7, P4: string(expectedJSONSchema)},
-		&vm.MakeRecordCmd{P1: 3, P2: 5, P3: 8},
-		&vm.InsertCmd{P1: 1, P2: 8, P3: 2},
+		&vm.CurrentTimeCmd{P1: 8, P4: vm.TimestampLayout},
+		&vm.MakeRecordCmd{P1: 3, P2: 6, P3: 9},
+		&vm.InsertCmd{P1: 1, P2: 9, P3: 2},
 		&vm.ParseSchemaCmd{},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
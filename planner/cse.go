@@ -0,0 +1,109 @@
+package planner
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// exprKey returns a key that is equal for expressions computing the same
+// result for a row. This allows common subexpressions such as a+b in
+// SELECT a+b, (a+b)*2 to be computed once per row. ok is false for
+// expressions that cannot be keyed.
+func exprKey(e compiler.Expr) (key string, ok bool) {
+	sb := &strings.Builder{}
+	if !writeExprKey(sb, e) {
+		return "", false
+	}
+	return sb.String(), true
+}
+
+func writeExprKey(sb *strings.Builder, e compiler.Expr) bool {
+	switch n := e.(type) {
+	case *compiler.BinaryExpr:
+		sb.WriteString("(")
+		if !writeExprKey(sb, n.Left) {
+			return false
+		}
+		sb.WriteString(n.Operator)
+		if !writeExprKey(sb, n.Right) {
+			return false
+		}
+		sb.WriteString(")")
+		return true
+	case *compiler.UnaryExpr:
+		sb.WriteString("(")
+		if !writeExprKey(sb, n.Operand) {
+			return false
+		}
+		sb.WriteString(" " + n.Operator + ")")
+		return true
+	case *compiler.ColumnRef:
+		if n.Table != "" {
+			sb.WriteString(n.Table + ".")
+		}
+		if n.IsPrimaryKey {
+			sb.WriteString("rowid")
+		} else {
+			fmt.Fprintf(sb, "col%d", n.ColIdx)
+		}
+		return true
+	case *compiler.IntLit:
+		fmt.Fprintf(sb, "%d", n.Value)
+		return true
+	case *compiler.FloatLit:
+		sb.WriteString(strconv.FormatFloat(n.Value, 'g', -1, 64))
+		return true
+	case *compiler.StringLit:
+		fmt.Fprintf(sb, "%q", n.Value)
+		return true
+	case *compiler.NullLit:
+		sb.WriteString("NULL")
+		return true
+	case *compiler.Variable:
+		fmt.Fprintf(sb, "?%d", n.Position)
+		return true
+	case *compiler.FunctionExpr:
+		if _, _, ok := vm.FunctionArgs(n.FnType); ok && !vm.IsDeterministic(n.FnType) {
+			// Each call of a function such as RANDOM() is a different value.
+			return false
+		}
+		sb.WriteString(n.FnType)
+		if n.Args == nil {
+			sb.WriteString("(*)")
+			return true
+		}
+		sb.WriteString("(")
+		for i, arg := range n.Args {
+			if i > 0 {
+				sb.WriteString(",")
+			}
+			if !writeExprKey(sb, arg) {
+				return false
+			}
+		}
+		sb.WriteString(")")
+		return true
+	case *compiler.CastExpr:
+		sb.WriteString("CAST(")
+		if !writeExprKey(sb, n.Expr) {
+			return false
+		}
+		sb.WriteString(" AS " + n.TypeName + ")")
+		return true
+	case *groupColumn:
+		fmt.Fprintf(sb, "group%d", n.colIdx)
+		return true
+	case *excludedColumn:
+		if n.isPrimaryKey {
+			sb.WriteString("excluded.rowid")
+		} else {
+			fmt.Fprintf(sb, "excluded.col%d", n.colIdx)
+		}
+		return true
+	}
+	return false
+}
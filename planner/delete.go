@@ -8,10 +8,12 @@ import (
 
 type deleteCatalog interface {
 	GetVersion() string
+	GetGeneration(tableName string) int
 	GetRootPageNumber(string) (int, error)
 	GetColumns(string) ([]string, error)
 	GetPrimaryKeyColumn(string) (string, error)
 	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
+	GetIndexes(tableName string) []catalog.Index
 }
 
 type deletePlanner struct {
@@ -22,25 +24,36 @@ type deletePlanner struct {
 }
 
 func NewDelete(catalog deleteCatalog, stmt *compiler.DeleteStmt) *deletePlanner {
+	executionPlan := vm.NewExecutionPlan(catalog.GetVersion(), stmt.Explain)
+	executionPlan.DependOn(stmt.TableName, catalog.GetGeneration(stmt.TableName))
+	executionPlan.ChangedTable = stmt.TableName
 	return &deletePlanner{
-		catalog: catalog,
-		stmt:    stmt,
-		executionPlan: vm.NewExecutionPlan(
-			catalog.GetVersion(),
-			stmt.Explain,
-		),
+		catalog:       catalog,
+		stmt:          stmt,
+		executionPlan: executionPlan,
 	}
 }
 
 // QueryPlan implements db.statementPlanner.
 func (d *deletePlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(d.stmt.SchemaName); err != nil {
+		return nil, err
+	}
+	if err := checkTableWritable(d.stmt.TableName); err != nil {
+		return nil, err
+	}
 	rootPageNumber, err := d.catalog.GetRootPageNumber(d.stmt.TableName)
 	if err != nil {
 		return nil, errTableNotExist
 	}
+	indexes, err := getSecondaryIndexes(d.catalog, d.stmt.TableName, 2)
+	if err != nil {
+		return nil, err
+	}
 	deleteNode := &deleteNode{
 		rootPageNumber: rootPageNumber,
 		cursorId:       1,
+		indexes:        indexes,
 	}
 	qp := newQueryPlan(deleteNode, d.stmt.ExplainQueryPlan, transactionTypeWrite)
 	deleteNode.plan = qp
@@ -52,10 +65,30 @@ func (d *deletePlanner) QueryPlan() (*QueryPlan, error) {
 		cursorId:       1,
 		isWriteCursor:  true,
 	}
+	alwaysFalse := false
 	if d.stmt.Predicate != nil {
-		cev := &catalogExprVisitor{}
-		cev.Init(d.catalog, d.stmt.TableName)
-		d.stmt.Predicate.BreadthWalk(cev)
+		if err := checkExprSchemas(d.stmt.Predicate); err != nil {
+			return nil, err
+		}
+		if err := checkExprFunctions(d.stmt.Predicate); err != nil {
+			return nil, err
+		}
+		predicate, isConst, truth, err := foldPredicate(d.stmt.Predicate)
+		if err != nil {
+			return nil, err
+		}
+		d.stmt.Predicate = predicate
+		if isConst && truth {
+			d.stmt.Predicate = nil
+		}
+		alwaysFalse = isConst && !truth
+	}
+	if alwaysFalse {
+		deleteNode.child = &emptyNode{plan: qp}
+	} else if d.stmt.Predicate != nil {
+		if err := bindExpr(d.catalog, d.stmt.TableName, d.stmt.Predicate); err != nil {
+			return nil, err
+		}
 		fn := &filterNode{
 			plan:      qp,
 			predicate: d.stmt.Predicate,
@@ -11,10 +11,18 @@ import (
 
 type mockDeleteCatalog struct{}
 
+func (*mockDeleteCatalog) GetIndexes(tableName string) []catalog.Index {
+	return []catalog.Index{}
+}
+
 func (*mockDeleteCatalog) GetVersion() string {
 	return "mock"
 }
 
+func (*mockDeleteCatalog) GetGeneration(string) int {
+	return 0
+}
+
 func (*mockDeleteCatalog) GetRootPageNumber(tableName string) (int, error) {
 	if tableName == "foo" {
 		return 2, nil
@@ -60,7 +68,7 @@ func TestDelete(t *testing.T) {
 				&vm.InitCmd{P2: 6},
 				&vm.OpenWriteCmd{P1: 1, P2: 2},
 				&vm.RewindCmd{P1: 1, P2: 5},
-				&vm.DeleteCmd{P1: 1},
+				&vm.DeleteCmd{P1: 1, P5: vm.CountChange},
 				&vm.NextCmd{P1: 1, P2: 3},
 				&vm.HaltCmd{},
 				&vm.TransactionCmd{P2: 1},
@@ -85,7 +93,7 @@ func TestDelete(t *testing.T) {
 				&vm.OpenWriteCmd{P1: 1, P2: 2},
 				&vm.CopyCmd{P1: 2, P2: 1},
 				&vm.SeekRowId{P1: 1, P2: 5, P3: 1},
-				&vm.DeleteCmd{P1: 1},
+				&vm.DeleteCmd{P1: 1, P5: vm.CountChange},
 				&vm.HaltCmd{},
 				&vm.TransactionCmd{P2: 1},
 				&vm.IntegerCmd{P1: 1, P2: 2},
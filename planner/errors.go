@@ -5,9 +5,31 @@ import "errors"
 var (
 	errInvalidPKColumnType = errors.New("primary key must be INTEGER type")
 	errTableExists         = errors.New("table exists")
+	errIndexExists         = errors.New("index exists")
 	errMoreThanOnePK       = errors.New("more than one primary key specified")
 	errTableNotExist       = errors.New("table does not exist")
+	errTableJoined         = errors.New("table is joined more than once")
+	errNoTables            = errors.New("no tables specified")
 	errValuesNotMatch      = errors.New("values list did not match columns list")
 	errMissingColumnName   = errors.New("missing column")
 	errSetColumnNotExist   = errors.New("set column not part of table")
+	errSchemaNotExist      = errors.New("schema does not exist")
+	errTableFunctionArg    = errors.New("table function arguments must be constant")
+	errNoTableFunction     = errors.New("no such table function")
+	errOrderByTermRange    = errors.New("ORDER BY term out of range")
+	errGroupByTermRange    = errors.New("GROUP BY term out of range")
+	errColumnNotGrouped    = errors.New("column must appear in GROUP BY clause or be used in an aggregate function")
+	errNoFunction          = errors.New("no such function")
+	errFunctionArgs        = errors.New("wrong number of arguments to function")
+	errNestedAggregate     = errors.New("aggregate functions cannot be nested")
+	errColumnNotExist      = errors.New("no such column")
+	errAmbiguousColumn     = errors.New("ambiguous column name")
+	errMisuseAggregate     = errors.New("aggregate functions are not allowed in WHERE or GROUP BY")
+	errCommonTableVariable = errors.New("parameters are not supported in common table expressions")
+	errCommonTableColumns  = errors.New("common table expression has the wrong number of columns")
+	errNoMaterializer      = errors.New("common table expressions are not supported")
+	errConflictTarget      = errors.New("ON CONFLICT target must be the primary key")
+	errUpdatePrimaryKey    = errors.New("updating primary key not supported")
+	errLimitNotConstant    = errors.New("LIMIT must be constant")
+	errTableReadOnly       = errors.New("table may not be modified")
 )
@@ -0,0 +1,133 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// scalarFunctionArgs is the number of arguments taken by each function that is
+// computed once per row and has its own vm command. Other scalar functions are
+// called with vm.FunctionCmd and checked with vm.FunctionArgs.
+var scalarFunctionArgs = map[string]int{
+	compiler.FnTypeof:           1,
+	compiler.FnCurrentTimestamp: 0,
+	compiler.FnCurrentDate:      0,
+}
+
+// isAggregate returns true when f is computed over a group of rows rather than
+// once per row.
+func isAggregate(f *compiler.FunctionExpr) bool {
+	switch f.FnType {
+	case compiler.FnCount, compiler.FnSum:
+		return true
+	case compiler.FnMin, compiler.FnMax:
+		// MIN and MAX with more than one argument are scalar functions.
+		return len(f.Args) == 1
+	}
+	return false
+}
+
+// functionExprVisitor checks functions are known and given the right number of
+// arguments. Aggregate functions are checked by checkAggregate.
+type functionExprVisitor struct {
+	err error
+}
+
+// checkExprFunctions returns an error for the first function in e that does not
+// exist or has the wrong number of arguments.
+func checkExprFunctions(e compiler.Expr) error {
+	if e == nil {
+		return nil
+	}
+	v := &functionExprVisitor{}
+	e.BreadthWalk(v)
+	return v.err
+}
+
+func (f *functionExprVisitor) VisitFunctionExpr(e *compiler.FunctionExpr) {
+	if f.err != nil || isAggregate(e) {
+		return
+	}
+	minArgs, maxArgs, ok := functionArgs(e.FnType)
+	if !ok {
+		f.err = fmt.Errorf("%w: %s", errNoFunction, e.FnType)
+		return
+	}
+	if len(e.Args) < minArgs || len(e.Args) > maxArgs {
+		f.err = fmt.Errorf("%w %s", errFunctionArgs, e.FnType)
+	}
+}
+
+// functionArgs returns the least and most arguments taken by the scalar
+// function fnType. ok is false when there is no such function.
+func functionArgs(fnType string) (minArgs, maxArgs int, ok bool) {
+	if argCount, ok := scalarFunctionArgs[fnType]; ok {
+		return argCount, argCount, true
+	}
+	return vm.FunctionArgs(fnType)
+}
+
+// foldFunction computes a function of constants before the query is executed.
+// The function is returned as is when an argument is not a constant, the
+// function is not deterministic or the result cannot be written as a literal.
+func foldFunction(fe *compiler.FunctionExpr) (compiler.Expr, error) {
+	args := make([]vm.Value, len(fe.Args))
+	for i := range fe.Args {
+		var err error
+		fe.Args[i], err = foldExpr(fe.Args[i])
+		if err != nil {
+			return nil, err
+		}
+		v, ok := literalValue(fe.Args[i])
+		if !ok {
+			return fe, nil
+		}
+		args[i] = v
+	}
+	if !vm.IsDeterministic(fe.FnType) || isAggregate(fe) {
+		return fe, nil
+	}
+	v, err := vm.CallFunction(fe.FnType, args)
+	if err != nil {
+		// The function is left for checkExprFunctions or the vm to report.
+		return fe, nil
+	}
+	switch v.Type() {
+	case vm.NullType:
+		return &compiler.NullLit{}, nil
+	case vm.IntegerType:
+		return &compiler.IntLit{Value: v.Int()}, nil
+	case vm.FloatType:
+		return &compiler.FloatLit{Value: v.Float()}, nil
+	case vm.TextType:
+		return &compiler.StringLit{Value: v.Text()}, nil
+	}
+	return fe, nil
+}
+
+// literalValue returns the value of e when e is a literal.
+func literalValue(e compiler.Expr) (vm.Value, bool) {
+	switch l := e.(type) {
+	case *compiler.IntLit:
+		return vm.IntValue(l.Value), true
+	case *compiler.FloatLit:
+		return vm.FloatValue(l.Value), true
+	case *compiler.StringLit:
+		return vm.TextValue(l.Value), true
+	case *compiler.NullLit:
+		return vm.NullValue(), true
+	}
+	return vm.Value{}, false
+}
+
+func (f *functionExprVisitor) VisitBinaryExpr(e *compiler.BinaryExpr)   {}
+func (f *functionExprVisitor) VisitUnaryExpr(e *compiler.UnaryExpr)     {}
+func (f *functionExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef) {}
+func (f *functionExprVisitor) VisitIntLit(e *compiler.IntLit)           {}
+func (f *functionExprVisitor) VisitFloatLit(e *compiler.FloatLit)       {}
+func (f *functionExprVisitor) VisitStringLit(e *compiler.StringLit)     {}
+func (f *functionExprVisitor) VisitNullLit(e *compiler.NullLit)         {}
+func (f *functionExprVisitor) VisitVariable(e *compiler.Variable)       {}
+func (f *functionExprVisitor) VisitCastExpr(e *compiler.CastExpr)       {}
@@ -1,11 +1,36 @@
 package planner
 
 import (
+	"strings"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
 
 func (u *updateNode) produce() {
+	openIndexes(u.plan, u.indexes)
+	if u.pkExpr != nil {
+		u.plan.commands = append(u.plan.commands, &vm.SorterOpenCmd{
+			P1: u.sorterCursorId,
+			P2: 1,
+			P4: "ASC",
+		})
+	}
+	if u.limit != nil {
+		u.limitRegister = u.plan.freeRegister
+		u.plan.freeRegister += 1
+		generateExpressionTo(u.plan, u.limit, u.limitRegister, u.cursorId)
+		u.plan.commands = append(u.plan.commands, &vm.MustBeIntCmd{P1: u.limitRegister})
+		ifNot := &vm.IfNotCmd{P1: u.limitRegister}
+		u.plan.commands = append(u.plan.commands, ifNot)
+		u.limitJumps = append(u.limitJumps, ifNot)
+	}
 	u.child.produce()
+	u.limitJumps.SetJumpAddress(len(u.plan.commands))
+	if u.pkExpr != nil {
+		u.moveRows()
+	}
 }
 
 func (u *updateNode) consume() {
@@ -16,34 +41,181 @@ func (u *updateNode) consume() {
 	})
 	rowIdRegister := u.plan.freeRegister
 	u.plan.freeRegister += 1
+	if u.pkExpr != nil {
+		// The row is kept in the sorter ordered by its row id and updated by
+		// moveRows once the scan is done.
+		recordRegister := u.plan.freeRegister
+		u.plan.freeRegister += 1
+		u.plan.commands = append(u.plan.commands, &vm.MakeRecordCmd{
+			P1: rowIdRegister,
+			P2: 1,
+			P3: recordRegister,
+		})
+		u.plan.commands = append(u.plan.commands, &vm.SorterInsertCmd{
+			P1: u.sorterCursorId,
+			P2: recordRegister,
+			P3: u.cursorId,
+		})
+	} else {
+		updateRow(u.plan, u.cursorId, rowIdRegister, u.updateExprs, u.affinities, u.indexes)
+	}
+	if u.limit != nil {
+		djz := &vm.DecrJumpZeroCmd{P1: u.limitRegister}
+		u.plan.commands = append(u.plan.commands, djz)
+		u.limitJumps = append(u.limitJumps, djz)
+	}
+}
 
-	// Reserve a contiguous block of free registers for the columns. This block
-	// will be used in makeRecord.
+// moveRows appends a loop over the rows in the sorter that replaces each row
+// with a row having the primary key pkExpr. The expressions are computed from
+// the copy of the row in the sorter so they see the values from before the
+// update.
+func (u *updateNode) moveRows() {
+	sortCmd := &vm.SorterSortCmd{P1: u.sorterCursorId}
+	u.plan.commands = append(u.plan.commands, sortCmd)
+	loopBeginAddress := len(u.plan.commands)
+	u.plan.resetExprRegisters()
+
+	oldRowIdRegister := u.plan.freeRegister
+	newRowIdRegister := u.plan.freeRegister + 1
+	u.plan.freeRegister += 2
+	u.plan.commands = append(u.plan.commands, &vm.RowIdCmd{
+		P1: u.sorterCursorId,
+		P2: oldRowIdRegister,
+	})
+	generateExpressionTo(u.plan, u.pkExpr, newRowIdRegister, u.sorterCursorId)
+	u.plan.commands = append(u.plan.commands, &vm.MustBeIntCmd{P1: newRowIdRegister})
 	startRecordRegister := u.plan.freeRegister
 	u.plan.freeRegister += len(u.updateExprs)
-	recordRegisterCount := len(u.updateExprs)
 	for i, e := range u.updateExprs {
-		generateExpressionTo(u.plan, e, startRecordRegister+i, u.cursorId)
+		generateExpressionTo(u.plan, e, startRecordRegister+i, u.sorterCursorId)
+	}
+	recordRegister := u.plan.freeRegister
+	u.plan.freeRegister += 1
+	u.plan.commands = append(u.plan.commands, &vm.MakeRecordCmd{
+		P1: startRecordRegister,
+		P2: len(u.updateExprs),
+		P3: recordRegister,
+		P4: u.affinities,
+	})
+
+	// Remove the old row and its index entries.
+	seek := &vm.SeekRowId{P1: u.cursorId, P3: oldRowIdRegister}
+	u.plan.commands = append(u.plan.commands, seek)
+	for _, index := range u.indexes {
+		valueRegister := indexValueRegister(u.plan, index, u.cursorId, oldRowIdRegister)
+		u.plan.commands = append(u.plan.commands, &vm.IdxDeleteCmd{
+			P1: index.cursorId,
+			P2: valueRegister,
+			P3: oldRowIdRegister,
+		})
+	}
+	u.plan.commands = append(u.plan.commands, &vm.DeleteCmd{P1: u.cursorId})
+
+	// Insert the new row which must not take the key of another row.
+	nec := &vm.NotExistsCmd{P1: u.cursorId, P3: newRowIdRegister}
+	u.plan.commands = append(u.plan.commands, nec)
+	u.plan.commands = append(u.plan.commands, &vm.HaltCmd{
+		P1: 1,
+		P4: pkConstraint,
+	})
+	nec.P2 = len(u.plan.commands)
+	u.plan.commands = append(u.plan.commands, &vm.InsertCmd{
+		P1: u.cursorId,
+		P2: recordRegister,
+		P3: newRowIdRegister,
+		P5: vm.CountChange,
+	})
+	for _, index := range u.indexes {
+		valueRegister := newRowIdRegister
+		if !index.isPrimaryKey {
+			valueRegister = startRecordRegister + index.colIdx
+		}
+		generateUniqueCheck(u.plan, index, valueRegister)
+		u.plan.commands = append(u.plan.commands, &vm.IdxInsertCmd{
+			P1: index.cursorId,
+			P2: valueRegister,
+			P3: newRowIdRegister,
+			P4: index.includeRegisters(startRecordRegister),
+		})
+	}
+	seek.P2 = len(u.plan.commands)
+
+	u.plan.resetExprRegisters()
+	u.plan.commands = append(u.plan.commands, &vm.SorterNextCmd{
+		P1: u.sorterCursorId,
+		P2: loopBeginAddress,
+	})
+	sortCmd.P2 = len(u.plan.commands)
+}
+
+// updateRow appends commands replacing the row cursorId points to with a record
+// of updateExprs. rowIdRegister holds the row id of the row which does not
+// change. The entries of indexes are updated to the new values of the row.
+func updateRow(plan *QueryPlan, cursorId, rowIdRegister int, updateExprs []compiler.Expr, affinities string, indexes []secondaryIndex) {
+	// Reserve a contiguous block of free registers for the columns. This block
+	// will be used in makeRecord.
+	startRecordRegister := plan.freeRegister
+	plan.freeRegister += len(updateExprs)
+	recordRegisterCount := len(updateExprs)
+	for i, e := range updateExprs {
+		generateExpressionTo(plan, e, startRecordRegister+i, cursorId)
 	}
 
 	// Make the record for inserting
-	u.plan.commands = append(u.plan.commands, &vm.MakeRecordCmd{
+	plan.commands = append(plan.commands, &vm.MakeRecordCmd{
 		P1: startRecordRegister,
 		P2: recordRegisterCount,
-		P3: u.plan.freeRegister,
+		P3: plan.freeRegister,
+		P4: affinities,
 	})
-	recordRegister := u.plan.freeRegister
-	u.plan.freeRegister += 1
+	recordRegister := plan.freeRegister
+	plan.freeRegister += 1
+
+	// Remove the index entries for the old values. The primary key cannot be
+	// updated so an index on it only changes when its entries include values.
+	for _, index := range indexes {
+		if index.isPrimaryKey && len(index.include) == 0 {
+			continue
+		}
+		valueRegister := indexValueRegister(plan, index, cursorId, rowIdRegister)
+		plan.commands = append(plan.commands, &vm.IdxDeleteCmd{
+			P1: index.cursorId,
+			P2: valueRegister,
+			P3: rowIdRegister,
+		})
+	}
 
 	// Update by deleting then inserting
-	u.plan.commands = append(u.plan.commands, &vm.DeleteCmd{
-		P1: u.cursorId,
+	plan.commands = append(plan.commands, &vm.DeleteCmd{
+		P1: cursorId,
 	})
-	u.plan.commands = append(u.plan.commands, &vm.InsertCmd{
-		P1: u.cursorId,
+	plan.commands = append(plan.commands, &vm.InsertCmd{
+		P1: cursorId,
 		P2: recordRegister,
 		P3: rowIdRegister,
+		P5: vm.CountChange,
 	})
+	for _, index := range indexes {
+		if index.isPrimaryKey {
+			if len(index.include) != 0 {
+				plan.commands = append(plan.commands, &vm.IdxInsertCmd{
+					P1: index.cursorId,
+					P2: rowIdRegister,
+					P3: rowIdRegister,
+					P4: index.includeRegisters(startRecordRegister),
+				})
+			}
+			continue
+		}
+		generateUniqueCheck(plan, index, startRecordRegister+index.colIdx)
+		plan.commands = append(plan.commands, &vm.IdxInsertCmd{
+			P1: index.cursorId,
+			P2: startRecordRegister + index.colIdx,
+			P3: rowIdRegister,
+			P4: index.includeRegisters(startRecordRegister),
+		})
+	}
 }
 
 func (f *filterNode) produce() {
@@ -69,18 +241,118 @@ func (s *scanNode) consume() {
 	} else {
 		s.plan.commands = append(
 			s.plan.commands,
-			&vm.OpenReadCmd{P1: s.cursorId, P2: s.rootPageNumber},
+			openReadCmd(s.cursorId, s.rootPageNumber, s.tableName, s.virtualTable),
 		)
 	}
-	rewindCmd := &vm.RewindCmd{P1: s.cursorId}
-	s.plan.commands = append(s.plan.commands, rewindCmd)
+	if s.order != scanUnordered {
+		s.consumeInRowIdOrder()
+		return
+	}
+	var startCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	if s.lowerBound != nil {
+		boundRegister := s.plan.freeRegister
+		s.plan.freeRegister += 1
+		generateExpressionTo(s.plan, s.lowerBound, boundRegister, s.cursorId)
+		startCmd = &vm.SeekGTCmd{P1: s.cursorId, P3: boundRegister}
+	} else {
+		startCmd = &vm.RewindCmd{P1: s.cursorId}
+	}
+	s.plan.commands = append(s.plan.commands, startCmd)
 	loopBeginAddress := len(s.plan.commands)
+	s.plan.resetExprRegisters()
+	var pastUpperBound vm.JumpCommand
+	if s.upperBound != nil {
+		pastUpperBound = generatePredicate(s.plan, s.upperBound, s.cursorId)
+	}
 	s.parent.consume()
+	s.plan.resetExprRegisters()
+	nextAddress := len(s.plan.commands)
 	s.plan.commands = append(s.plan.commands, &vm.NextCmd{
 		P1: s.cursorId,
 		P2: loopBeginAddress,
 	})
-	rewindCmd.P2 = len(s.plan.commands)
+	if pastUpperBound != nil {
+		s.consumeUpperBound(pastUpperBound, nextAddress)
+	}
+	startCmd.SetJumpAddress(len(s.plan.commands))
+}
+
+// consumeUpperBound generates the code pastUpperBound jumps to for a row past
+// the upper bound. Keys are in row id order for non negative row ids but a
+// negative row id is ordered among them by its magnitude so a row with a
+// negative row id can follow the upper bound. The scan stops when the lower
+// bound is not negative since no such row can be after the lower bound.
+// Otherwise the scan continues at nextAddress.
+func (s *scanNode) consumeUpperBound(pastUpperBound vm.JumpCommand, nextAddress int) {
+	endCmd := &vm.GotoCmd{}
+	s.plan.commands = append(s.plan.commands, endCmd)
+	pastUpperBound.SetJumpAddress(len(s.plan.commands))
+	s.plan.resetExprRegisters()
+	nonNegative := &compiler.BinaryExpr{
+		Left:     &compiler.CastExpr{Expr: s.lowerBound, TypeName: "INTEGER"},
+		Operator: compiler.OpGte,
+		Right:    &compiler.IntLit{Value: 0},
+	}
+	generatePredicate(s.plan, nonNegative, s.cursorId).SetJumpAddress(nextAddress)
+	s.plan.resetExprRegisters()
+	endCmd.P2 = len(s.plan.commands)
+}
+
+// consumeInRowIdOrder visits the rows in the order of their row ids. Keys are
+// in row id order for non negative row ids, but a negative row id is ordered
+// among them by its magnitude so the rows are visited in two passes. Ascending
+// order visits the negative row ids from the last key to the first and then the
+// non negative row ids from the first key to the last. Descending order visits
+// the non negative row ids from the last key to the first and then the negative
+// row ids from the first key to the last.
+func (s *scanNode) consumeInRowIdOrder() {
+	desc := s.order == scanDescending
+	s.consumePass(true, !desc)
+	s.consumePass(false, desc)
+}
+
+// consumePass appends a loop visiting the rows with negative row ids when
+// negative is true or otherwise the rows with non negative row ids. The rows
+// are visited in descending key order when reverse is true.
+func (s *scanNode) consumePass(reverse, negative bool) {
+	var startCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	if reverse {
+		startCmd = &vm.LastCmd{P1: s.cursorId}
+	} else {
+		startCmd = &vm.RewindCmd{P1: s.cursorId}
+	}
+	s.plan.commands = append(s.plan.commands, startCmd)
+	loopBeginAddress := len(s.plan.commands)
+	s.plan.resetExprRegisters()
+	rowIdRegister := s.plan.freeRegister
+	s.plan.freeRegister += 1
+	s.plan.commands = append(s.plan.commands, &vm.RowIdCmd{P1: s.cursorId, P2: rowIdRegister})
+	var skipCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	zeroRegister := s.plan.declareConstInt(0)
+	if negative {
+		skipCmd = &vm.GteCmd{P1: rowIdRegister, P3: zeroRegister}
+	} else {
+		skipCmd = &vm.LtCmd{P1: rowIdRegister, P3: zeroRegister}
+	}
+	s.plan.commands = append(s.plan.commands, skipCmd)
+	s.parent.consume()
+	s.plan.resetExprRegisters()
+	skipCmd.SetJumpAddress(len(s.plan.commands))
+	if reverse {
+		s.plan.commands = append(s.plan.commands, &vm.PrevCmd{P1: s.cursorId, P2: loopBeginAddress})
+	} else {
+		s.plan.commands = append(s.plan.commands, &vm.NextCmd{P1: s.cursorId, P2: loopBeginAddress})
+	}
+	startCmd.SetJumpAddress(len(s.plan.commands))
 }
 
 func (p *projectNode) produce() {
@@ -100,6 +372,137 @@ func (p *projectNode) consume() {
 	})
 }
 
+func (o *orderNode) produce() {
+	orders := []string{}
+	for _, term := range o.terms {
+		if term.desc {
+			orders = append(orders, "DESC")
+		} else {
+			orders = append(orders, "ASC")
+		}
+	}
+	o.plan.commands = append(o.plan.commands, &vm.SorterOpenCmd{
+		P1: o.cursorId,
+		P2: len(o.terms),
+		P4: strings.Join(orders, ","),
+	})
+	o.child.produce()
+	sortCmd := &vm.SorterSortCmd{P1: o.cursorId}
+	o.plan.commands = append(o.plan.commands, sortCmd)
+	loopBeginAddress := len(o.plan.commands)
+	o.plan.resetExprRegisters()
+	o.parent.consume()
+	o.plan.resetExprRegisters()
+	o.plan.commands = append(o.plan.commands, &vm.SorterNextCmd{
+		P1: o.cursorId,
+		P2: loopBeginAddress,
+	})
+	sortCmd.P2 = len(o.plan.commands)
+}
+
+func (o *orderNode) consume() {
+	startRegister := o.plan.freeRegister
+	o.plan.freeRegister += len(o.terms)
+	for i, term := range o.terms {
+		generateExpressionTo(o.plan, term.expr, startRegister+i, o.sourceCursorId)
+	}
+	recordRegister := o.plan.freeRegister
+	o.plan.freeRegister += 1
+	o.plan.commands = append(o.plan.commands, &vm.MakeRecordCmd{
+		P1: startRegister,
+		P2: len(o.terms),
+		P3: recordRegister,
+	})
+	insertCmd := &vm.SorterInsertCmd{
+		P1: o.cursorId,
+		P2: recordRegister,
+		P3: o.sourceCursorId,
+	}
+	if len(o.values) != 0 {
+		valueRegister := o.plan.freeRegister
+		o.plan.freeRegister += len(o.values)
+		for i, e := range o.values {
+			generateExpressionTo(o.plan, e, valueRegister+i, o.sourceCursorId)
+		}
+		rowRegister := o.plan.freeRegister
+		o.plan.freeRegister += 1
+		o.plan.commands = append(o.plan.commands, &vm.MakeRecordCmd{
+			P1: valueRegister,
+			P2: len(o.values),
+			P3: rowRegister,
+		})
+		insertCmd.P3 = 0
+		insertCmd.P5 = rowRegister
+	}
+	o.plan.commands = append(o.plan.commands, insertCmd)
+}
+
+func (a *aggregateNode) produce() {
+	fns := []string{}
+	for _, f := range a.aggregates {
+		fns = append(fns, f.FnType)
+	}
+	a.plan.commands = append(a.plan.commands, &vm.AggOpenCmd{
+		P1: a.cursorId,
+		P2: len(a.aggregates),
+		P4: strings.Join(fns, ","),
+	})
+	a.child.produce()
+	alwaysOneGroup := 0
+	if len(a.groupBy) == 0 {
+		alwaysOneGroup = 1
+	}
+	a.plan.commands = append(a.plan.commands, &vm.AggFinalCmd{
+		P1: a.cursorId,
+		P2: alwaysOneGroup,
+	})
+	rewindCmd := &vm.RewindCmd{P1: a.cursorId}
+	a.plan.commands = append(a.plan.commands, rewindCmd)
+	loopBeginAddress := len(a.plan.commands)
+	a.plan.resetExprRegisters()
+	a.parent.consume()
+	a.plan.resetExprRegisters()
+	a.plan.commands = append(a.plan.commands, &vm.NextCmd{
+		P1: a.cursorId,
+		P2: loopBeginAddress,
+	})
+	rewindCmd.P2 = len(a.plan.commands)
+}
+
+func (a *aggregateNode) consume() {
+	startRegister := a.plan.freeRegister
+	a.plan.freeRegister += len(a.groupBy)
+	for i, e := range a.groupBy {
+		generateExpressionTo(a.plan, e, startRegister+i, a.sourceCursorId)
+	}
+	recordRegister := a.plan.freeRegister
+	a.plan.freeRegister += 1
+	a.plan.commands = append(a.plan.commands, &vm.MakeRecordCmd{
+		P1: startRegister,
+		P2: len(a.groupBy),
+		P3: recordRegister,
+	})
+	for i, f := range a.aggregates {
+		var argRegister int
+		if f.Args == nil {
+			// COUNT(*) counts every row so it is given a value that is never
+			// NULL.
+			argRegister = a.plan.declareConstInt(1)
+		} else {
+			argRegister = a.plan.freeRegister
+			a.plan.freeRegister += 1
+			generateExpressionTo(a.plan, f.Args[0], argRegister, a.sourceCursorId)
+		}
+		a.plan.commands = append(a.plan.commands, &vm.AggStepCmd{
+			P1: a.cursorId,
+			P2: recordRegister,
+			P3: argRegister,
+			P4: f.FnType,
+			P5: i,
+		})
+	}
+}
+
 func (c *constantNode) produce() {
 	c.consume()
 }
@@ -108,6 +511,10 @@ func (c *constantNode) consume() {
 	c.parent.consume()
 }
 
+func (e *emptyNode) produce() {}
+
+func (e *emptyNode) consume() {}
+
 func (c *countNode) produce() {
 	c.consume()
 }
@@ -115,7 +522,7 @@ func (c *countNode) produce() {
 func (c *countNode) consume() {
 	c.plan.commands = append(
 		c.plan.commands,
-		&vm.OpenReadCmd{P1: c.cursorId, P2: c.rootPageNumber},
+		openReadCmd(c.cursorId, c.rootPageNumber, c.tableName, c.virtualTable),
 	)
 	c.plan.commands = append(c.plan.commands, &vm.CountCmd{
 		P1: c.cursorId,
@@ -142,18 +549,258 @@ func (c *createNode) consume() {
 		c.plan.commands,
 		&vm.OpenWriteCmd{P1: c.catalogCursorId, P2: c.catalogRootPageNumber},
 	)
-	c.plan.commands = append(c.plan.commands, &vm.CreateBTreeCmd{P2: 1})
-	c.plan.commands = append(c.plan.commands, &vm.NewRowIdCmd{P1: c.catalogCursorId, P2: 2})
-	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: 3, P4: c.objectType})
-	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: 4, P4: c.objectName})
-	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: 5, P4: c.tableName})
-	c.plan.commands = append(c.plan.commands, &vm.CopyCmd{P1: 1, P2: 6})
-	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: 7, P4: string(c.schema)})
-	c.plan.commands = append(c.plan.commands, &vm.MakeRecordCmd{P1: 3, P2: 5, P3: 8})
-	c.plan.commands = append(c.plan.commands, &vm.InsertCmd{P1: c.catalogCursorId, P2: 8, P3: 2})
+	createObject(c.plan, c.catalogCursorId, 0, c.objectType, c.objectName, c.tableName, c.schema)
+	if c.sequenceSchema != "" {
+		createObject(c.plan, c.catalogCursorId, 9, "table", catalog.SequenceTableName, catalog.SequenceTableName, c.sequenceSchema)
+	}
 	c.plan.commands = append(c.plan.commands, &vm.ParseSchemaCmd{})
 }
 
+// createObject creates a b tree and inserts an object for it into the system
+// catalog open with catalogCursorId using the registers following
+// firstRegister. The root page number of the b tree is left in register
+// firstRegister + 1.
+func createObject(plan *QueryPlan, catalogCursorId, firstRegister int, objectType, objectName, tableName, schema string) {
+	r := firstRegister
+	plan.commands = append(plan.commands, &vm.CreateBTreeCmd{P2: r + 1})
+	plan.commands = append(plan.commands, &vm.NewRowIdCmd{P1: catalogCursorId, P2: r + 2})
+	plan.commands = append(plan.commands, &vm.StringCmd{P1: r + 3, P4: objectType})
+	plan.commands = append(plan.commands, &vm.StringCmd{P1: r + 4, P4: objectName})
+	plan.commands = append(plan.commands, &vm.StringCmd{P1: r + 5, P4: tableName})
+	plan.commands = append(plan.commands, &vm.CopyCmd{P1: r + 1, P2: r + 6})
+	plan.commands = append(plan.commands, &vm.StringCmd{P1: r + 7, P4: schema})
+	plan.commands = append(plan.commands, &vm.CurrentTimeCmd{P1: r + 8, P4: vm.TimestampLayout})
+	plan.commands = append(plan.commands, &vm.MakeRecordCmd{P1: r + 3, P2: 6, P3: r + 9})
+	plan.commands = append(plan.commands, &vm.InsertCmd{P1: catalogCursorId, P2: r + 9, P3: r + 2})
+}
+
+func (a *analyzeNode) produce() {
+	a.consume()
+}
+
+func (a *analyzeNode) consume() {
+	if a.statSchema != "" {
+		a.plan.commands = append(
+			a.plan.commands,
+			&vm.OpenWriteCmd{P1: a.catalogCursorId, P2: a.catalogRootPageNumber},
+		)
+		r := a.plan.freeRegister
+		a.plan.freeRegister += 10
+		createObject(a.plan, a.catalogCursorId, r, "table", catalog.StatTableName, catalog.StatTableName, a.statSchema)
+		a.plan.commands = append(a.plan.commands, &vm.OpenWriteCmd{P1: a.statCursorId, P2: r + 1, P5: 1})
+	} else {
+		a.plan.commands = append(
+			a.plan.commands,
+			&vm.OpenWriteCmd{P1: a.statCursorId, P2: a.statRootPageNumber},
+		)
+		a.clearStats()
+	}
+	for _, table := range a.tables {
+		a.analyzeTable(table)
+	}
+	a.plan.commands = append(a.plan.commands, &vm.ParseSchemaCmd{})
+}
+
+// clearStats deletes the rows of cdb_stat for the analyzed table or every row
+// when every table is analyzed.
+func (a *analyzeNode) clearStats() {
+	rewindCmd := &vm.RewindCmd{P1: a.statCursorId}
+	a.plan.commands = append(a.plan.commands, rewindCmd)
+	loopBeginAddress := len(a.plan.commands)
+	var notEqualCmd *vm.NotEqualCmd
+	if a.tableName != "" {
+		tableNameRegister := a.plan.freeRegister
+		a.plan.freeRegister += 1
+		a.plan.commands = append(a.plan.commands, &vm.ColumnCmd{P1: a.statCursorId, P2: 0, P3: tableNameRegister})
+		notEqualCmd = &vm.NotEqualCmd{P1: tableNameRegister, P3: a.plan.declareConstString(a.tableName)}
+		a.plan.commands = append(a.plan.commands, notEqualCmd)
+	}
+	a.plan.commands = append(a.plan.commands, &vm.DeleteCmd{P1: a.statCursorId})
+	if notEqualCmd != nil {
+		notEqualCmd.P2 = len(a.plan.commands)
+	}
+	a.plan.commands = append(a.plan.commands, &vm.NextCmd{P1: a.statCursorId, P2: loopBeginAddress})
+	rewindCmd.P2 = len(a.plan.commands)
+}
+
+// analyzeTable inserts a row into cdb_stat with the number of rows of table and
+// a row for each of its indexes with the number of entries and distinct values.
+// The entries of an index are ordered by value so a value is distinct when it
+// differs from the value of the previous entry.
+func (a *analyzeNode) analyzeTable(table analyzeTable) {
+	a.plan.commands = append(a.plan.commands, &vm.OpenReadCmd{P1: table.cursorId, P2: table.rootPageNumber})
+	countRegister := a.plan.freeRegister
+	a.plan.freeRegister += 1
+	a.plan.commands = append(a.plan.commands, &vm.CountCmd{P1: table.cursorId, P2: countRegister})
+	a.insertStat(table.name, "", countRegister, 0)
+	for _, index := range table.indexes {
+		colIdx := 0
+		if index.isPrimaryKey {
+			colIdx = -1
+		}
+		a.plan.commands = append(a.plan.commands, &vm.OpenIndexCmd{
+			P1: index.cursorId,
+			P2: index.rootPageNumber,
+			P3: colIdx,
+			P5: 1,
+		})
+		entriesRegister := a.plan.freeRegister
+		distinctRegister := a.plan.freeRegister + 1
+		previousRegister := a.plan.freeRegister + 2
+		valueRegister := a.plan.freeRegister + 3
+		a.plan.freeRegister += 4
+		a.plan.commands = append(a.plan.commands, &vm.CountCmd{P1: index.cursorId, P2: entriesRegister})
+		a.plan.commands = append(a.plan.commands, &vm.CopyCmd{P1: a.plan.declareConstInt(0), P2: distinctRegister})
+		a.plan.commands = append(a.plan.commands, &vm.NullCmd{P2: previousRegister})
+		rewindCmd := &vm.RewindCmd{P1: index.cursorId}
+		a.plan.commands = append(a.plan.commands, rewindCmd)
+		loopBeginAddress := len(a.plan.commands)
+		if index.isPrimaryKey {
+			a.plan.commands = append(a.plan.commands, &vm.RowIdCmd{P1: index.cursorId, P2: valueRegister})
+		} else {
+			a.plan.commands = append(a.plan.commands, &vm.ColumnCmd{P1: index.cursorId, P2: 0, P3: valueRegister})
+		}
+		eqCmd := &vm.EqCmd{P1: valueRegister, P3: previousRegister}
+		a.plan.commands = append(a.plan.commands, eqCmd)
+		a.plan.commands = append(a.plan.commands, &vm.AddCmd{
+			P1: distinctRegister,
+			P2: a.plan.declareConstInt(1),
+			P3: distinctRegister,
+		})
+		a.plan.commands = append(a.plan.commands, &vm.CopyCmd{P1: valueRegister, P2: previousRegister})
+		eqCmd.P2 = len(a.plan.commands)
+		a.plan.commands = append(a.plan.commands, &vm.NextCmd{P1: index.cursorId, P2: loopBeginAddress})
+		rewindCmd.P2 = len(a.plan.commands)
+		a.insertStat(table.name, index.name, entriesRegister, distinctRegister)
+	}
+}
+
+// insertStat inserts a row into cdb_stat. indexName is empty and
+// distinctRegister is 0 for the row of a table which makes them NULL.
+func (a *analyzeNode) insertStat(tableName, indexName string, countRegister, distinctRegister int) {
+	rowIdRegister := a.plan.freeRegister
+	a.plan.freeRegister += 1
+	a.plan.commands = append(a.plan.commands, &vm.NewRowIdCmd{P1: a.statCursorId, P2: rowIdRegister})
+	startRegister := a.plan.freeRegister
+	a.plan.freeRegister += 4
+	a.plan.commands = append(a.plan.commands, &vm.StringCmd{P1: startRegister, P4: tableName})
+	if indexName == "" {
+		a.plan.commands = append(a.plan.commands, &vm.NullCmd{P2: startRegister + 1})
+	} else {
+		a.plan.commands = append(a.plan.commands, &vm.StringCmd{P1: startRegister + 1, P4: indexName})
+	}
+	a.plan.commands = append(a.plan.commands, &vm.CopyCmd{P1: countRegister, P2: startRegister + 2})
+	if distinctRegister == 0 {
+		a.plan.commands = append(a.plan.commands, &vm.NullCmd{P2: startRegister + 3})
+	} else {
+		a.plan.commands = append(a.plan.commands, &vm.CopyCmd{P1: distinctRegister, P2: startRegister + 3})
+	}
+	recordRegister := a.plan.freeRegister
+	a.plan.freeRegister += 1
+	a.plan.commands = append(a.plan.commands, &vm.MakeRecordCmd{P1: startRegister, P2: 4, P3: recordRegister})
+	a.plan.commands = append(a.plan.commands, &vm.InsertCmd{P1: a.statCursorId, P2: recordRegister, P3: rowIdRegister})
+}
+
+func (c *createIndexNode) produce() {
+	c.consume()
+}
+
+func (c *createIndexNode) consume() {
+	if c.noop {
+		return
+	}
+	rootRegister := c.plan.freeRegister
+	c.plan.freeRegister += 1
+	c.plan.commands = append(
+		c.plan.commands,
+		&vm.OpenWriteCmd{P1: c.catalogCursorId, P2: c.catalogRootPageNumber},
+	)
+	c.plan.commands = append(c.plan.commands, &vm.CreateBTreeCmd{P2: rootRegister})
+
+	// Insert the index into the system catalog
+	rowIdRegister := c.plan.freeRegister
+	c.plan.freeRegister += 1
+	c.plan.commands = append(c.plan.commands, &vm.NewRowIdCmd{P1: c.catalogCursorId, P2: rowIdRegister})
+	startRegister := c.plan.freeRegister
+	c.plan.freeRegister += 6
+	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: startRegister, P4: "index"})
+	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: startRegister + 1, P4: c.indexName})
+	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: startRegister + 2, P4: c.tableName})
+	c.plan.commands = append(c.plan.commands, &vm.CopyCmd{P1: rootRegister, P2: startRegister + 3})
+	c.plan.commands = append(c.plan.commands, &vm.StringCmd{P1: startRegister + 4, P4: c.schema})
+	c.plan.commands = append(c.plan.commands, &vm.CurrentTimeCmd{P1: startRegister + 5, P4: vm.TimestampLayout})
+	recordRegister := c.plan.freeRegister
+	c.plan.freeRegister += 1
+	c.plan.commands = append(c.plan.commands, &vm.MakeRecordCmd{P1: startRegister, P2: 6, P3: recordRegister})
+	c.plan.commands = append(c.plan.commands, &vm.InsertCmd{P1: c.catalogCursorId, P2: recordRegister, P3: rowIdRegister})
+
+	// Hold an entry for each row of the table then load the entries into the
+	// index all at once.
+	c.plan.commands = append(c.plan.commands, &vm.OpenReadCmd{P1: c.tableCursorId, P2: c.tableRootPageNumber})
+	c.plan.commands = append(c.plan.commands, &vm.OpenWriteCmd{P1: c.indexCursorId, P2: rootRegister, P5: 1})
+	rewindCmd := &vm.RewindCmd{P1: c.tableCursorId}
+	c.plan.commands = append(c.plan.commands, rewindCmd)
+	loopBeginAddress := len(c.plan.commands)
+	rowRowIdRegister := c.plan.freeRegister
+	c.plan.freeRegister += 1
+	valueRegister := rowRowIdRegister
+	c.plan.commands = append(c.plan.commands, &vm.RowIdCmd{P1: c.tableCursorId, P2: rowRowIdRegister})
+	if !c.isPrimaryKey {
+		valueRegister = c.plan.freeRegister
+		c.plan.freeRegister += 1
+		c.plan.commands = append(c.plan.commands, &vm.ColumnCmd{P1: c.tableCursorId, P2: c.colIdx, P3: valueRegister})
+	}
+	includeRegisters := make([]int, len(c.include))
+	for i, colIdx := range c.include {
+		includeRegisters[i] = c.plan.freeRegister
+		c.plan.freeRegister += 1
+		c.plan.commands = append(c.plan.commands, &vm.ColumnCmd{P1: c.tableCursorId, P2: colIdx, P3: includeRegisters[i]})
+	}
+	c.plan.commands = append(c.plan.commands, &vm.IdxInsertCmd{
+		P1: c.indexCursorId,
+		P2: valueRegister,
+		P3: rowRowIdRegister,
+		P4: vm.IntList(includeRegisters),
+		P5: 1,
+	})
+	c.plan.commands = append(c.plan.commands, &vm.NextCmd{P1: c.tableCursorId, P2: loopBeginAddress})
+	rewindCmd.P2 = len(c.plan.commands)
+	bulkLoadCmd := &vm.IdxBulkLoadCmd{P1: c.indexCursorId}
+	if c.unique {
+		bulkLoadCmd.P2 = 1
+		bulkLoadCmd.P4 = uniqueConstraint(secondaryIndex{name: c.indexName})
+	}
+	c.plan.commands = append(c.plan.commands, bulkLoadCmd)
+	c.plan.commands = append(c.plan.commands, &vm.ParseSchemaCmd{})
+}
+
+// openIndexes opens a write cursor on each of indexes.
+func openIndexes(plan *QueryPlan, indexes []secondaryIndex) {
+	for _, index := range indexes {
+		plan.commands = append(
+			plan.commands,
+			&vm.OpenWriteCmd{P1: index.cursorId, P2: index.rootPageNumber},
+		)
+	}
+}
+
+// indexValueRegister returns the register holding the value of the column of
+// index in the row of tableCursorId. The value is the row id in rowIdRegister
+// when the column is the primary key otherwise it is read into a new register.
+func indexValueRegister(plan *QueryPlan, index secondaryIndex, tableCursorId, rowIdRegister int) int {
+	if index.isPrimaryKey {
+		return rowIdRegister
+	}
+	valueRegister := plan.freeRegister
+	plan.freeRegister += 1
+	plan.commands = append(plan.commands, &vm.ColumnCmd{
+		P1: tableCursorId,
+		P2: index.colIdx,
+		P3: valueRegister,
+	})
+	return valueRegister
+}
+
 func (n *insertNode) produce() {
 	n.consume()
 }
@@ -163,6 +810,11 @@ func (n *insertNode) consume() {
 		n.plan.commands,
 		&vm.OpenWriteCmd{P1: n.cursorId, P2: n.rootPageNumber},
 	)
+	openIndexes(n.plan, n.indexes)
+	sequenceRegister := 0
+	if n.sequenceRootPageNumber != 0 {
+		sequenceRegister = n.readSequence()
+	}
 	for valuesIdx := range len(n.colValues) {
 		// Setup rowid and it's uniqueness/type checks
 		pkRegister := n.plan.freeRegister
@@ -171,20 +823,23 @@ func (n *insertNode) consume() {
 			n.plan.commands = append(n.plan.commands, &vm.NewRowIdCmd{
 				P1: n.cursorId,
 				P2: pkRegister,
+				P3: sequenceRegister,
 			})
 		} else {
 			generateExpressionTo(n.plan, n.pkValues[valuesIdx], pkRegister, n.cursorId)
 			n.plan.commands = append(n.plan.commands, &vm.MustBeIntCmd{P1: pkRegister})
-			nec := &vm.NotExistsCmd{
-				P1: n.cursorId,
-				P3: pkRegister,
+			if n.conflict == compiler.ConflictAbort {
+				nec := &vm.NotExistsCmd{
+					P1: n.cursorId,
+					P3: pkRegister,
+				}
+				n.plan.commands = append(n.plan.commands, nec)
+				n.plan.commands = append(n.plan.commands, &vm.HaltCmd{
+					P1: 1,
+					P4: pkConstraint,
+				})
+				nec.P2 = len(n.plan.commands)
 			}
-			n.plan.commands = append(n.plan.commands, nec)
-			n.plan.commands = append(n.plan.commands, &vm.HaltCmd{
-				P1: 1,
-				P4: pkConstraint,
-			})
-			nec.P2 = len(n.plan.commands)
 		}
 
 		// Reserve registers and make values segment for MakeRecord
@@ -199,12 +854,17 @@ func (n *insertNode) consume() {
 				n.cursorId,
 			)
 		}
+		var skipRow jumpCommands
+		if n.conflict != compiler.ConflictAbort {
+			skipRow = n.resolveConflict(pkRegister, startRegister)
+		}
 
 		// Insert
 		n.plan.commands = append(n.plan.commands, &vm.MakeRecordCmd{
 			P1: startRegister,
 			P2: reservedRegisters,
 			P3: n.plan.freeRegister,
+			P4: n.affinities,
 		})
 		recordRegister := n.plan.freeRegister
 		n.plan.freeRegister += 1
@@ -212,21 +872,386 @@ func (n *insertNode) consume() {
 			P1: n.cursorId,
 			P2: recordRegister,
 			P3: pkRegister,
+			P5: vm.CountChange | vm.SetLastInsertRowID,
 		})
+		for _, index := range n.indexes {
+			valueRegister := pkRegister
+			if !index.isPrimaryKey {
+				valueRegister = startRegister + index.colIdx
+			}
+			generateUniqueCheck(n.plan, index, valueRegister)
+			n.plan.commands = append(n.plan.commands, &vm.IdxInsertCmd{
+				P1: index.cursorId,
+				P2: valueRegister,
+				P3: pkRegister,
+				P4: index.includeRegisters(startRegister),
+			})
+		}
+		if sequenceRegister != 0 {
+			lte := &vm.LteCmd{P1: pkRegister, P3: sequenceRegister}
+			n.plan.commands = append(n.plan.commands, lte)
+			n.plan.commands = append(n.plan.commands, &vm.CopyCmd{P1: pkRegister, P2: sequenceRegister})
+			lte.P2 = len(n.plan.commands)
+		}
+		skipRow.SetJumpAddress(len(n.plan.commands))
+	}
+	if sequenceRegister != 0 {
+		n.writeSequence(sequenceRegister)
+	}
+}
+
+// resolveConflict appends commands resolving the conflict of the row about to
+// be inserted with an existing row having the row id in pkRegister or a value
+// of a unique index. The values of the row about to be inserted start at
+// startRegister. When the row must not be inserted the returned jumps must be
+// set to skip the insert. There are no jumps when the row is always inserted.
+func (n *insertNode) resolveConflict(pkRegister, startRegister int) jumpCommands {
+	switch n.conflict {
+	case compiler.ConflictIgnore:
+		return n.ignoreConflict(pkRegister, startRegister)
+	case compiler.ConflictReplace:
+		n.replaceConflict(pkRegister, startRegister)
+		return nil
+	}
+	if n.autoPk {
+		return nil
+	}
+	seek := &vm.SeekRowId{P1: n.cursorId, P3: pkRegister}
+	n.plan.commands = append(n.plan.commands, seek)
+	// The existing row is read by the commands that follow so expressions
+	// computed before or after them are not for the same row.
+	n.plan.resetExprRegisters()
+	defer n.plan.resetExprRegisters()
+	n.plan.setExprRegister(&excludedColumn{indexColumn: indexColumn{isPrimaryKey: true}}, pkRegister)
+	for i := range n.conflictExprs {
+		n.plan.setExprRegister(&excludedColumn{indexColumn: indexColumn{colIdx: i}}, startRegister+i)
+	}
+	updateRow(n.plan, n.cursorId, pkRegister, n.conflictExprs, n.affinities, n.indexes)
+	skipRow := &vm.GotoCmd{}
+	n.plan.commands = append(n.plan.commands, skipRow)
+	seek.P2 = len(n.plan.commands)
+	return jumpCommands{skipRow}
+}
+
+// ignoreConflict appends commands jumping past the insert when a row has the
+// row id in pkRegister or a unique index has an entry equal to the value of the
+// row about to be inserted.
+func (n *insertNode) ignoreConflict(pkRegister, startRegister int) jumpCommands {
+	skipRow := jumpCommands{}
+	if !n.autoPk {
+		nec := &vm.NotExistsCmd{P1: n.cursorId, P3: pkRegister}
+		n.plan.commands = append(n.plan.commands, nec)
+		skipCmd := &vm.GotoCmd{}
+		n.plan.commands = append(n.plan.commands, skipCmd)
+		skipRow = append(skipRow, skipCmd)
+		nec.P2 = len(n.plan.commands)
+	}
+	for _, index := range n.uniqueIndexes() {
+		notExistsCmd := &vm.IdxNotExistsCmd{P1: index.cursorId, P3: startRegister + index.colIdx}
+		n.plan.commands = append(n.plan.commands, notExistsCmd)
+		skipCmd := &vm.GotoCmd{}
+		n.plan.commands = append(n.plan.commands, skipCmd)
+		skipRow = append(skipRow, skipCmd)
+		notExistsCmd.P2 = len(n.plan.commands)
+	}
+	return skipRow
+}
+
+// replaceConflict appends commands deleting the row having the row id in
+// pkRegister and every row with a value of a unique index equal to the value of
+// the row about to be inserted.
+func (n *insertNode) replaceConflict(pkRegister, startRegister int) {
+	if !n.autoPk {
+		n.deleteRow(pkRegister)
 	}
+	for _, index := range n.uniqueIndexes() {
+		rowIdRegister := n.plan.freeRegister
+		n.plan.freeRegister += 1
+		notExistsCmd := &vm.IdxNotExistsCmd{
+			P1: index.cursorId,
+			P3: startRegister + index.colIdx,
+			P5: rowIdRegister,
+		}
+		n.plan.commands = append(n.plan.commands, notExistsCmd)
+		n.deleteRow(rowIdRegister)
+		notExistsCmd.P2 = len(n.plan.commands)
+	}
+}
+
+// deleteRow appends commands deleting the row with the row id in rowIdRegister
+// and its index entries when there is such a row.
+func (n *insertNode) deleteRow(rowIdRegister int) {
+	seek := &vm.SeekRowId{P1: n.cursorId, P3: rowIdRegister}
+	n.plan.commands = append(n.plan.commands, seek)
+	// The existing row is read by the commands that follow so expressions
+	// computed before or after them are not for the same row.
+	n.plan.resetExprRegisters()
+	defer n.plan.resetExprRegisters()
+	for _, index := range n.indexes {
+		valueRegister := indexValueRegister(n.plan, index, n.cursorId, rowIdRegister)
+		n.plan.commands = append(n.plan.commands, &vm.IdxDeleteCmd{
+			P1: index.cursorId,
+			P2: valueRegister,
+			P3: rowIdRegister,
+		})
+	}
+	n.plan.commands = append(n.plan.commands, &vm.DeleteCmd{P1: n.cursorId})
+	seek.P2 = len(n.plan.commands)
+}
+
+// uniqueIndexes are the unique indexes of the table other than an index on the
+// primary key whose conflicts are those of the primary key.
+func (n *insertNode) uniqueIndexes() []secondaryIndex {
+	indexes := []secondaryIndex{}
+	for _, index := range n.indexes {
+		if index.unique && !index.isPrimaryKey {
+			indexes = append(indexes, index)
+		}
+	}
+	return indexes
+}
+
+// readSequence opens cdb_sequence and reads the largest row id the table has
+// ever had into the returned register. The register is 0 when the table has no
+// entry in cdb_sequence. The register before the returned register holds the
+// key of the entry.
+func (n *insertNode) readSequence() int {
+	keyRegister := n.plan.freeRegister
+	sequenceRegister := n.plan.freeRegister + 1
+	n.plan.freeRegister += 2
+	n.plan.commands = append(
+		n.plan.commands,
+		&vm.OpenWriteCmd{P1: n.sequenceCursorId, P2: n.sequenceRootPageNumber},
+	)
+	n.plan.commands = append(n.plan.commands, &vm.IntegerCmd{P1: n.rootPageNumber, P2: keyRegister})
+	n.plan.commands = append(n.plan.commands, &vm.IntegerCmd{P1: 0, P2: sequenceRegister})
+	seek := &vm.SeekRowId{P1: n.sequenceCursorId, P3: keyRegister}
+	n.plan.commands = append(n.plan.commands, seek)
+	n.plan.commands = append(n.plan.commands, &vm.ColumnCmd{
+		P1: n.sequenceCursorId,
+		P2: 1,
+		P3: sequenceRegister,
+	})
+	seek.P2 = len(n.plan.commands)
+	return sequenceRegister
+}
+
+// writeSequence sets the entry for the table in cdb_sequence to the largest
+// row id in sequenceRegister.
+func (n *insertNode) writeSequence(sequenceRegister int) {
+	keyRegister := sequenceRegister - 1
+	startRegister := n.plan.freeRegister
+	recordRegister := n.plan.freeRegister + 2
+	n.plan.freeRegister += 3
+	n.plan.commands = append(n.plan.commands, &vm.StringCmd{P1: startRegister, P4: n.tableName})
+	n.plan.commands = append(n.plan.commands, &vm.CopyCmd{P1: sequenceRegister, P2: startRegister + 1})
+	n.plan.commands = append(n.plan.commands, &vm.MakeRecordCmd{
+		P1: startRegister,
+		P2: 2,
+		P3: recordRegister,
+		P4: string([]byte{byte(vm.TextAffinity), byte(vm.IntegerAffinity)}),
+	})
+	n.plan.commands = append(n.plan.commands, &vm.InsertCmd{
+		P1: n.sequenceCursorId,
+		P2: recordRegister,
+		P3: keyRegister,
+	})
 }
 
 func (d *deleteNode) consume() {
-	d.plan.commands = append(d.plan.commands, &vm.DeleteCmd{P1: d.cursorId})
+	if len(d.indexes) > 0 {
+		rowIdRegister := d.plan.freeRegister
+		d.plan.freeRegister += 1
+		d.plan.commands = append(d.plan.commands, &vm.RowIdCmd{
+			P1: d.cursorId,
+			P2: rowIdRegister,
+		})
+		for _, index := range d.indexes {
+			valueRegister := indexValueRegister(d.plan, index, d.cursorId, rowIdRegister)
+			d.plan.commands = append(d.plan.commands, &vm.IdxDeleteCmd{
+				P1: index.cursorId,
+				P2: valueRegister,
+				P3: rowIdRegister,
+			})
+		}
+	}
+	d.plan.commands = append(d.plan.commands, &vm.DeleteCmd{P1: d.cursorId, P5: vm.CountChange})
 }
 
 func (d *deleteNode) produce() {
+	openIndexes(d.plan, d.indexes)
 	d.child.produce()
 }
 
-func (n *joinNode) produce() {}
+func (n *joinNode) produce() {
+	right := n.right.(*scanNode)
+	n.plan.commands = append(
+		n.plan.commands,
+		openReadCmd(right.cursorId, right.rootPageNumber, right.tableName, right.virtualTable),
+	)
+	n.left.produce()
+}
+
+// consume visits the rows of right for the current row of left. A left join
+// tracks whether a row of right matched in noMatchRegister. When none did the
+// cursor of right is moved to a row of NULLs and the parent consumes it once.
+func (n *joinNode) consume() {
+	right := n.right.(*scanNode)
+	noMatchRegister := 0
+	if n.isLeft {
+		noMatchRegister = n.plan.freeRegister
+		n.plan.freeRegister += 1
+		n.plan.commands = append(n.plan.commands, &vm.IntegerCmd{P1: 1, P2: noMatchRegister})
+	}
+	rewindCmd := &vm.RewindCmd{P1: right.cursorId}
+	n.plan.commands = append(n.plan.commands, rewindCmd)
+	loopBeginAddress := len(n.plan.commands)
+	n.plan.resetExprRegisters()
+	var onCmd vm.JumpCommand
+	if n.on != nil {
+		onCmd = generatePredicate(n.plan, n.on, right.cursorId)
+	}
+	if n.isLeft {
+		n.plan.commands = append(n.plan.commands, &vm.IntegerCmd{P1: 0, P2: noMatchRegister})
+	}
+	n.parent.consume()
+	if onCmd != nil {
+		onCmd.SetJumpAddress(len(n.plan.commands))
+	}
+	n.plan.resetExprRegisters()
+	n.plan.commands = append(n.plan.commands, &vm.NextCmd{
+		P1: right.cursorId,
+		P2: loopBeginAddress,
+	})
+	rewindCmd.P2 = len(n.plan.commands)
+	if !n.isLeft {
+		return
+	}
+	matchedCmd := &vm.IfNotCmd{P1: noMatchRegister}
+	n.plan.commands = append(n.plan.commands, matchedCmd)
+	n.plan.commands = append(n.plan.commands, &vm.NullRowCmd{P1: right.cursorId})
+	n.parent.consume()
+	n.plan.resetExprRegisters()
+	matchedCmd.P2 = len(n.plan.commands)
+}
+
+func (s *indexSeekNode) produce() {
+	s.consume()
+}
+
+func (s *indexSeekNode) consume() {
+	if !s.covering {
+		s.plan.commands = append(
+			s.plan.commands,
+			&vm.OpenReadCmd{P1: s.cursorId, P2: s.rootPageNumber},
+		)
+	}
+	colIdx := s.index.colIdx
+	if s.index.isPrimaryKey {
+		colIdx = -1
+	}
+	s.plan.commands = append(s.plan.commands, &vm.OpenIndexCmd{
+		P1: s.index.cursorId,
+		P2: s.index.rootPageNumber,
+		P3: colIdx,
+		P4: vm.IntList(s.index.include),
+		P5: s.columnCount,
+	})
+	valueRegister := s.plan.freeRegister
+	s.plan.freeRegister += 1
+	generateExpressionTo(s.plan, s.predicate, valueRegister, s.cursorId)
+	var seekCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	if s.prefix {
+		seekCmd = &vm.IdxPrefixSeekCmd{P1: s.index.cursorId, P3: valueRegister}
+	} else {
+		seekCmd = &vm.IdxSeekCmd{P1: s.index.cursorId, P3: valueRegister}
+	}
+	s.plan.commands = append(s.plan.commands, seekCmd)
+	loopBeginAddress := len(s.plan.commands)
+	s.plan.resetExprRegisters()
+	var seekRowIdCmd *vm.SeekRowId
+	if !s.covering {
+		rowIdRegister := s.plan.freeRegister
+		s.plan.freeRegister += 1
+		s.plan.commands = append(s.plan.commands, &vm.RowIdCmd{
+			P1: s.index.cursorId,
+			P2: rowIdRegister,
+		})
+		seekRowIdCmd = &vm.SeekRowId{P1: s.cursorId, P3: rowIdRegister}
+		s.plan.commands = append(s.plan.commands, seekRowIdCmd)
+	}
+	s.parent.consume()
+	s.plan.resetExprRegisters()
+	if seekRowIdCmd != nil {
+		seekRowIdCmd.P2 = len(s.plan.commands)
+	}
+	s.plan.commands = append(s.plan.commands, &vm.IdxNextCmd{
+		P1: s.index.cursorId,
+		P2: loopBeginAddress,
+	})
+	seekCmd.SetJumpAddress(len(s.plan.commands))
+}
+
+func (s *indexScanNode) produce() {
+	s.consume()
+}
 
-func (n *joinNode) consume() {}
+func (s *indexScanNode) consume() {
+	if !s.covering {
+		s.plan.commands = append(
+			s.plan.commands,
+			&vm.OpenReadCmd{P1: s.cursorId, P2: s.rootPageNumber},
+		)
+	}
+	colIdx := s.index.colIdx
+	if s.index.isPrimaryKey {
+		colIdx = -1
+	}
+	s.plan.commands = append(s.plan.commands, &vm.OpenIndexCmd{
+		P1: s.index.cursorId,
+		P2: s.index.rootPageNumber,
+		P3: colIdx,
+		P4: vm.IntList(s.index.include),
+		P5: s.columnCount,
+	})
+	var startCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	if s.desc {
+		startCmd = &vm.LastCmd{P1: s.index.cursorId}
+	} else {
+		startCmd = &vm.RewindCmd{P1: s.index.cursorId}
+	}
+	s.plan.commands = append(s.plan.commands, startCmd)
+	loopBeginAddress := len(s.plan.commands)
+	s.plan.resetExprRegisters()
+	var seekRowIdCmd *vm.SeekRowId
+	if !s.covering {
+		rowIdRegister := s.plan.freeRegister
+		s.plan.freeRegister += 1
+		s.plan.commands = append(s.plan.commands, &vm.RowIdCmd{
+			P1: s.index.cursorId,
+			P2: rowIdRegister,
+		})
+		seekRowIdCmd = &vm.SeekRowId{P1: s.cursorId, P3: rowIdRegister}
+		s.plan.commands = append(s.plan.commands, seekRowIdCmd)
+	}
+	s.parent.consume()
+	s.plan.resetExprRegisters()
+	if seekRowIdCmd != nil {
+		seekRowIdCmd.P2 = len(s.plan.commands)
+	}
+	if s.desc {
+		s.plan.commands = append(s.plan.commands, &vm.PrevCmd{P1: s.index.cursorId, P2: loopBeginAddress})
+	} else {
+		s.plan.commands = append(s.plan.commands, &vm.NextCmd{P1: s.index.cursorId, P2: loopBeginAddress})
+	}
+	startCmd.SetJumpAddress(len(s.plan.commands))
+}
 
 func (s *seekNode) produce() {
 	s.consume()
@@ -241,7 +1266,7 @@ func (s *seekNode) consume() {
 	} else {
 		s.plan.commands = append(
 			s.plan.commands,
-			&vm.OpenReadCmd{P1: s.cursorId, P2: s.rootPageNumber},
+			openReadCmd(s.cursorId, s.rootPageNumber, s.tableName, s.virtualTable),
 		)
 	}
 	rowIdRegister := s.plan.freeRegister
@@ -255,3 +1280,18 @@ func (s *seekNode) consume() {
 	s.parent.consume()
 	seekCmd.P2 = len(s.plan.commands)
 }
+
+// openReadCmd returns the command opening a read cursor with cursorId. The
+// cursor is over the rows of virtualTable when it is not nil otherwise it is
+// over the b tree at rootPageNumber.
+func openReadCmd(cursorId, rootPageNumber int, tableName string, virtualTable *VirtualTable) vm.Command {
+	if virtualTable != nil {
+		return &vm.OpenVirtualCmd{
+			P1:    cursorId,
+			P4:    tableName,
+			Rows:  virtualTable.Rows,
+			Table: virtualTable.Table,
+		}
+	}
+	return &vm.OpenReadCmd{P1: cursorId, P2: rootPageNumber}
+}
@@ -0,0 +1,279 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// createIndexCatalog defines the catalog methods needed by the create index
+// planner.
+type createIndexCatalog interface {
+	GetColumns(tableOrIndexName string) ([]string, error)
+	GetRootPageNumber(tableOrIndexName string) (int, error)
+	GetPrimaryKeyColumn(tableName string) (string, error)
+	TableExists(tableName string) bool
+	IndexExists(indexName string) bool
+	GetVersion() string
+}
+
+// createIndexPlanner is capable of generating a logical query plan and a
+// physical executionPlan for a create index statement.
+type createIndexPlanner struct {
+	// catalog contains the schema
+	catalog createIndexCatalog
+	// stmt contains the AST
+	stmt *compiler.CreateIndexStmt
+	// queryPlan contains the query plan being constructed. The root node must
+	// be createIndexNode.
+	queryPlan *createIndexNode
+	// executionPlan contains the bytecode execution plan being constructed.
+	// This is populated by calling ExecutionPlan.
+	executionPlan *vm.ExecutionPlan
+}
+
+// NewCreateIndex creates a planner for the given create index statement.
+func NewCreateIndex(catalog createIndexCatalog, stmt *compiler.CreateIndexStmt) *createIndexPlanner {
+	return &createIndexPlanner{
+		catalog: catalog,
+		stmt:    stmt,
+		executionPlan: vm.NewExecutionPlan(
+			catalog.GetVersion(),
+			stmt.Explain,
+		),
+	}
+}
+
+// QueryPlan generates the query plan for the planner.
+func (p *createIndexPlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(p.stmt.SchemaName); err != nil {
+		return nil, err
+	}
+	node := &createIndexNode{
+		indexName:             p.stmt.IndexName,
+		tableName:             p.stmt.TableName,
+		columnName:            p.stmt.ColumnName,
+		unique:                p.stmt.Unique,
+		catalogRootPageNumber: 1,
+		catalogCursorId:       1,
+		tableCursorId:         2,
+		indexCursorId:         3,
+	}
+	indexExists := p.catalog.IndexExists(p.stmt.IndexName)
+	if p.stmt.IfNotExists && indexExists {
+		node.noop = true
+		return p.newQueryPlan(node), nil
+	}
+	if indexExists {
+		return nil, errIndexExists
+	}
+	if p.catalog.TableExists(p.stmt.IndexName) {
+		return nil, errTableExists
+	}
+	if !p.catalog.TableExists(p.stmt.TableName) {
+		return nil, errTableNotExist
+	}
+	if err := checkTableWritable(p.stmt.TableName); err != nil {
+		return nil, err
+	}
+	rootPage, err := p.catalog.GetRootPageNumber(p.stmt.TableName)
+	if err != nil {
+		return nil, err
+	}
+	node.tableRootPageNumber = rootPage
+	column, err := indexColumnFor(p.catalog, p.stmt.TableName, p.stmt.ColumnName)
+	if err != nil {
+		return nil, err
+	}
+	node.colIdx = column.colIdx
+	node.isPrimaryKey = column.isPrimaryKey
+	node.include, err = includeColumnsFor(p.catalog, p.stmt.TableName, column, p.stmt.Include)
+	if err != nil {
+		return nil, err
+	}
+	schema := catalog.IndexSchema{
+		Columns: []string{p.stmt.ColumnName},
+		Include: p.stmt.Include,
+		Unique:  p.stmt.Unique,
+	}
+	jSchema, err := schema.ToJSON()
+	if err != nil {
+		return nil, err
+	}
+	node.schema = string(jSchema)
+	return p.newQueryPlan(node), nil
+}
+
+func (p *createIndexPlanner) newQueryPlan(node *createIndexNode) *QueryPlan {
+	p.queryPlan = node
+	qp := newQueryPlan(node, p.stmt.ExplainQueryPlan, transactionTypeWrite)
+	node.plan = qp
+	return qp
+}
+
+// ExecutionPlan returns the bytecode execution plan for the planner. Calling
+// QueryPlan is not a prerequisite to this method as it will be called by
+// ExecutionPlan if needed.
+func (p *createIndexPlanner) ExecutionPlan() (*vm.ExecutionPlan, error) {
+	if p.queryPlan == nil {
+		_, err := p.QueryPlan()
+		if err != nil {
+			return nil, err
+		}
+	}
+	p.queryPlan.plan.compile()
+	p.executionPlan.Commands = p.queryPlan.plan.commands
+	return p.executionPlan, nil
+}
+
+// columnCatalog defines the catalog methods needed to locate a column in a row.
+type columnCatalog interface {
+	GetColumns(tableOrIndexName string) ([]string, error)
+	GetPrimaryKeyColumn(tableName string) (string, error)
+}
+
+// indexCatalog defines the catalog methods needed to maintain the secondary
+// indexes of a table.
+type indexCatalog interface {
+	columnCatalog
+	GetIndexes(tableName string) []catalog.Index
+}
+
+// secondaryIndex is an index that must be kept in sync with the rows of its
+// table.
+type secondaryIndex struct {
+	name           string
+	rootPageNumber int
+	// cursorId is the id of the write cursor opened on the index.
+	cursorId int
+	// unique is true when an entry cannot be added for a value the index
+	// already has.
+	unique bool
+	indexColumn
+	// include are the nth non primary key values of the row the entries of the
+	// index include. The primary key and the indexed column are never included
+	// since every entry already has their values.
+	include []int
+}
+
+// includeRegisters returns the registers of the values the entries of index
+// include when the values of a row are in the registers starting at
+// startRegister. See vm.IdxInsertCmd.
+func (index secondaryIndex) includeRegisters(startRegister int) string {
+	registers := make([]int, len(index.include))
+	for i, colIdx := range index.include {
+		registers[i] = startRegister + colIdx
+	}
+	return vm.IntList(registers)
+}
+
+// includesColumn returns true when the entries of index have the value of the
+// nth non primary key column colIdx.
+func (index secondaryIndex) includesColumn(colIdx int) bool {
+	return !index.isPrimaryKey && index.colIdx == colIdx || slices.Contains(index.include, colIdx)
+}
+
+// indexColumn locates the value of an indexed column in a row.
+type indexColumn struct {
+	// isPrimaryKey is true when the indexed column is the row id.
+	isPrimaryKey bool
+	// colIdx is the nth non primary key value of the row when isPrimaryKey is
+	// false.
+	colIdx int
+}
+
+// indexColumnFor returns where columnName is in the rows of tableName or an
+// error when the table has no such column.
+func indexColumnFor(c columnCatalog, tableName, columnName string) (indexColumn, error) {
+	pkColumn, err := c.GetPrimaryKeyColumn(tableName)
+	if err != nil {
+		return indexColumn{}, err
+	}
+	if columnName == pkColumn {
+		return indexColumn{isPrimaryKey: true}, nil
+	}
+	columns, err := c.GetColumns(tableName)
+	if err != nil {
+		return indexColumn{}, err
+	}
+	nonPkColumns := slices.DeleteFunc(columns, func(col string) bool {
+		return col == pkColumn
+	})
+	colIdx := slices.Index(nonPkColumns, columnName)
+	if colIdx == -1 {
+		return indexColumn{}, fmt.Errorf("%w: %s", errColumnNotExist, columnName)
+	}
+	return indexColumn{colIdx: colIdx}, nil
+}
+
+// includeColumnsFor returns the nth non primary key values of the columns
+// named by include that are stored in the entries of an index on column.
+// Columns the entries already have are left out. See secondaryIndex.include.
+func includeColumnsFor(c columnCatalog, tableName string, column indexColumn, include []string) ([]int, error) {
+	colIdxs := []int{}
+	for _, columnName := range include {
+		included, err := indexColumnFor(c, tableName, columnName)
+		if err != nil {
+			return nil, err
+		}
+		if included.isPrimaryKey || !column.isPrimaryKey && included.colIdx == column.colIdx {
+			continue
+		}
+		if !slices.Contains(colIdxs, included.colIdx) {
+			colIdxs = append(colIdxs, included.colIdx)
+		}
+	}
+	return colIdxs, nil
+}
+
+// getSecondaryIndexes returns the indexes of tableName. The indexes are
+// assigned cursor ids counting up from firstCursorId.
+func getSecondaryIndexes(c indexCatalog, tableName string, firstCursorId int) ([]secondaryIndex, error) {
+	indexes := []secondaryIndex{}
+	for i, index := range c.GetIndexes(tableName) {
+		column, err := indexColumnFor(c, tableName, index.Columns[0])
+		if err != nil {
+			return nil, err
+		}
+		include, err := includeColumnsFor(c, tableName, column, index.Include)
+		if err != nil {
+			return nil, err
+		}
+		indexes = append(indexes, secondaryIndex{
+			name:           index.Name,
+			rootPageNumber: index.RootPageNumber,
+			cursorId:       firstCursorId + i,
+			unique:         index.Unique,
+			indexColumn:    column,
+			include:        include,
+		})
+	}
+	return indexes, nil
+}
+
+// uniqueConstraint returns the error message of a statement that would give
+// two rows of a unique index equal values.
+func uniqueConstraint(index secondaryIndex) string {
+	return fmt.Sprintf("unique constraint violated: %s", index.name)
+}
+
+// generateUniqueCheck appends commands that halt the statement with an error
+// when index is unique and already has an entry equal to the value in
+// valueRegister. The check must come before the entry for the value is
+// inserted. Halting rolls back every row the statement already changed so a
+// statement is either applied to every row or to none of them.
+func generateUniqueCheck(plan *QueryPlan, index secondaryIndex, valueRegister int) {
+	if !index.unique {
+		return
+	}
+	notExistsCmd := &vm.IdxNotExistsCmd{P1: index.cursorId, P3: valueRegister}
+	plan.commands = append(plan.commands, notExistsCmd)
+	plan.commands = append(plan.commands, &vm.HaltCmd{
+		P1: 1,
+		P4: uniqueConstraint(index),
+	})
+	notExistsCmd.P2 = len(plan.commands)
+}
@@ -0,0 +1,150 @@
+package planner
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+type mockCreateIndexCatalog struct {
+	indexExistsRes bool
+}
+
+func (*mockCreateIndexCatalog) GetColumns(tableOrIndexName string) ([]string, error) {
+	return []string{"id", "name"}, nil
+}
+
+func (*mockCreateIndexCatalog) GetRootPageNumber(tableOrIndexName string) (int, error) {
+	return 2, nil
+}
+
+func (*mockCreateIndexCatalog) GetPrimaryKeyColumn(tableName string) (string, error) {
+	return "id", nil
+}
+
+func (*mockCreateIndexCatalog) TableExists(tableName string) bool {
+	return tableName == "foo"
+}
+
+func (m *mockCreateIndexCatalog) IndexExists(indexName string) bool {
+	return m.indexExistsRes
+}
+
+func (*mockCreateIndexCatalog) GetVersion() string {
+	return "v"
+}
+
+func TestCreateIndex(t *testing.T) {
+	t.Run("create", func(t *testing.T) {
+		stmt := &compiler.CreateIndexStmt{
+			StmtBase:   &compiler.StmtBase{},
+			IndexName:  "idx",
+			TableName:  "foo",
+			ColumnName: "name",
+		}
+		schema := &catalog.IndexSchema{Columns: []string{"name"}}
+		jSchema, err := schema.ToJSON()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectedCommands := []vm.Command{
+			&vm.InitCmd{P2: 22},
+			&vm.OpenWriteCmd{P1: 1, P2: 1},
+			&vm.CreateBTreeCmd{P2: 1},
+			&vm.NewRowIdCmd{P1: 1, P2: 2},
+			&vm.StringCmd{P1: 3, P4: "index"},
+			&vm.StringCmd{P1: 4, P4: "idx"},
+			&vm.StringCmd{P1: 5, P4: "foo"},
+			&vm.CopyCmd{P1: 1, P2: 6},
+			&vm.StringCmd{P1: 7, P4: string(jSchema)},
+			&vm.CurrentTimeCmd{P1: 8, P4: vm.TimestampLayout},
+			&vm.MakeRecordCmd{P1: 3, P2: 6, P3: 9},
+			&vm.InsertCmd{P1: 1, P2: 9, P3: 2},
+			&vm.OpenReadCmd{P1: 2, P2: 2},
+			&vm.OpenWriteCmd{P1: 3, P2: 1, P5: 1},
+			&vm.RewindCmd{P1: 2, P2: 19},
+			&vm.RowIdCmd{P1: 2, P2: 10},
+			&vm.ColumnCmd{P1: 2, P2: 0, P3: 11},
+			&vm.IdxInsertCmd{P1: 3, P2: 11, P3: 10, P5: 1},
+			&vm.NextCmd{P1: 2, P2: 15},
+			&vm.IdxBulkLoadCmd{P1: 3},
+			&vm.ParseSchemaCmd{},
+			&vm.HaltCmd{},
+			&vm.TransactionCmd{P2: 1},
+			&vm.GotoCmd{P2: 1},
+		}
+		plan, err := NewCreateIndex(&mockCreateIndexCatalog{}, stmt).ExecutionPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("if not exists", func(t *testing.T) {
+		stmt := &compiler.CreateIndexStmt{
+			StmtBase:    &compiler.StmtBase{},
+			IfNotExists: true,
+			IndexName:   "idx",
+			TableName:   "foo",
+			ColumnName:  "name",
+		}
+		expectedCommands := []vm.Command{
+			&vm.InitCmd{P2: 2},
+			&vm.HaltCmd{},
+			&vm.TransactionCmd{P2: 1},
+			&vm.GotoCmd{P2: 1},
+		}
+		mc := &mockCreateIndexCatalog{indexExistsRes: true}
+		plan, err := NewCreateIndex(mc, stmt).ExecutionPlan()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+			t.Error(err)
+		}
+	})
+
+	errCases := []struct {
+		name        string
+		stmt        *compiler.CreateIndexStmt
+		indexExists bool
+		err         error
+	}{
+		{
+			name:        "index exists",
+			stmt:        &compiler.CreateIndexStmt{IndexName: "idx", TableName: "foo", ColumnName: "name"},
+			indexExists: true,
+			err:         errIndexExists,
+		},
+		{
+			name: "name used by table",
+			stmt: &compiler.CreateIndexStmt{IndexName: "foo", TableName: "foo", ColumnName: "name"},
+			err:  errTableExists,
+		},
+		{
+			name: "table does not exist",
+			stmt: &compiler.CreateIndexStmt{IndexName: "idx", TableName: "bar", ColumnName: "name"},
+			err:  errTableNotExist,
+		},
+		{
+			name: "column does not exist",
+			stmt: &compiler.CreateIndexStmt{IndexName: "idx", TableName: "foo", ColumnName: "age"},
+			err:  errColumnNotExist,
+		},
+	}
+	for _, c := range errCases {
+		t.Run(c.name, func(t *testing.T) {
+			c.stmt.StmtBase = &compiler.StmtBase{}
+			mc := &mockCreateIndexCatalog{indexExistsRes: c.indexExists}
+			_, err := NewCreateIndex(mc, c.stmt).QueryPlan()
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected %s got %v", c.err, err)
+			}
+		})
+	}
+}
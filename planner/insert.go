@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"slices"
 
+	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
@@ -17,7 +18,12 @@ type insertCatalog interface {
 	GetColumns(tableOrIndexName string) ([]string, error)
 	GetRootPageNumber(tableOrIndexName string) (int, error)
 	GetVersion() string
+	GetGeneration(tableName string) int
 	GetPrimaryKeyColumn(tableName string) (string, error)
+	GetIndexes(tableName string) []catalog.Index
+	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
+	GetColumnDefault(tableName string, columnName string) (string, error)
+	IsAutoIncrement(tableName string) bool
 }
 
 // insertPlanner consists of planners capable of generating a logical query plan
@@ -37,31 +43,56 @@ type insertPlanner struct {
 
 // NewInsert returns an instance of an insert planner for the given AST.
 func NewInsert(catalog insertCatalog, stmt *compiler.InsertStmt) *insertPlanner {
+	executionPlan := vm.NewExecutionPlan(catalog.GetVersion(), stmt.Explain)
+	executionPlan.DependOn(stmt.TableName, catalog.GetGeneration(stmt.TableName))
+	executionPlan.ChangedTable = stmt.TableName
 	return &insertPlanner{
-		catalog: catalog,
-		stmt:    stmt,
-		executionPlan: vm.NewExecutionPlan(
-			catalog.GetVersion(),
-			stmt.Explain,
-		),
+		catalog:       catalog,
+		stmt:          stmt,
+		executionPlan: executionPlan,
 	}
 }
 
 // QueryPlan generates the query plan tree for the planner.
 func (p *insertPlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(p.stmt.SchemaName); err != nil {
+		return nil, err
+	}
+	if err := checkTableWritable(p.stmt.TableName); err != nil {
+		return nil, err
+	}
 	rootPage, err := p.catalog.GetRootPageNumber(p.stmt.TableName)
 	if err != nil {
 		return nil, errTableNotExist
 	}
+	if len(p.stmt.ColNames) == 0 {
+		columns, err := p.catalog.GetColumns(p.stmt.TableName)
+		if err != nil {
+			return nil, err
+		}
+		p.stmt.ColNames = columns
+	}
 	if err := p.checkValuesMatchColumns(p.stmt); err != nil {
 		return nil, err
 	}
+	for _, values := range p.stmt.ColValues {
+		for _, value := range values {
+			if err := checkExprFunctions(value); err != nil {
+				return nil, err
+			}
+		}
+	}
 	colValues, err := p.getNonPkValues()
 	if err != nil {
 		return nil, err
 	}
+	affinities, err := recordAffinities(p.catalog, p.stmt.TableName)
+	if err != nil {
+		return nil, err
+	}
 	insertNode := &insertNode{
 		colValues:      colValues,
+		affinities:     affinities,
 		rootPageNumber: rootPage,
 		tableName:      p.stmt.TableName,
 		cursorId:       1,
@@ -69,6 +100,22 @@ func (p *insertPlanner) QueryPlan() (*QueryPlan, error) {
 	if err := p.setPkValues(insertNode); err != nil {
 		return nil, err
 	}
+	if err := p.setConflict(insertNode); err != nil {
+		return nil, err
+	}
+	indexes, err := getSecondaryIndexes(p.catalog, p.stmt.TableName, 2)
+	if err != nil {
+		return nil, err
+	}
+	insertNode.indexes = indexes
+	if p.catalog.IsAutoIncrement(p.stmt.TableName) {
+		sequenceRoot, err := p.catalog.GetRootPageNumber(catalog.SequenceTableName)
+		if err != nil {
+			return nil, err
+		}
+		insertNode.sequenceRootPageNumber = sequenceRoot
+		insertNode.sequenceCursorId = 2 + len(indexes)
+	}
 	p.queryPlan = insertNode
 	qp := newQueryPlan(
 		insertNode,
@@ -102,6 +149,127 @@ func (p *insertPlanner) setPkValues(n *insertNode) error {
 	return nil
 }
 
+// setConflict sets how the insert node resolves a row with the primary key of
+// an existing row. For ON CONFLICT DO UPDATE this is an expression for each
+// column of the existing row. A column not in the set list keeps its value.
+func (p *insertPlanner) setConflict(n *insertNode) error {
+	n.conflict = p.stmt.Conflict
+	pkColumnName, err := p.catalog.GetPrimaryKeyColumn(p.stmt.TableName)
+	if err != nil {
+		return err
+	}
+	if p.stmt.ConflictTarget != "" && p.stmt.ConflictTarget != pkColumnName {
+		return fmt.Errorf("%w: %s", errConflictTarget, p.stmt.ConflictTarget)
+	}
+	if n.conflict != compiler.ConflictUpdate {
+		return nil
+	}
+	columns, err := p.catalog.GetColumns(p.stmt.TableName)
+	if err != nil {
+		return err
+	}
+	if _, ok := p.stmt.ConflictSetList[pkColumnName]; ok {
+		return errUpdatePrimaryKey
+	}
+	for colName := range p.stmt.ConflictSetList {
+		if !slices.Contains(columns, colName) {
+			return errSetColumnNotExist
+		}
+	}
+	for _, column := range columns {
+		if column == pkColumnName {
+			continue
+		}
+		e, ok := p.stmt.ConflictSetList[column]
+		if !ok {
+			e = &compiler.ColumnRef{Table: p.stmt.TableName, Column: column}
+		}
+		if err := checkExprSchemas(e); err != nil {
+			return err
+		}
+		if err := checkExprFunctions(e); err != nil {
+			return err
+		}
+		e, err = p.rewriteExcluded(e)
+		if err != nil {
+			return err
+		}
+		if err := bindExpr(p.catalog, p.stmt.TableName, e); err != nil {
+			return err
+		}
+		n.conflictExprs = append(n.conflictExprs, e)
+	}
+	return nil
+}
+
+// excludedColumn is a column of the row that was not inserted because of a
+// conflict. It replaces excluded.column in ON CONFLICT DO UPDATE.
+type excludedColumn struct {
+	// expr is the column reference the excluded column replaces.
+	expr *compiler.ColumnRef
+	indexColumn
+}
+
+// BreadthWalk does not visit anything since excludedColumn is resolved when it
+// replaces a column reference.
+func (e *excludedColumn) BreadthWalk(v compiler.ExprVisitor) {}
+
+func (e *excludedColumn) Print() string {
+	return e.expr.Print()
+}
+
+// rewriteExcluded returns e with column references to the excluded table
+// replaced by the excluded column they refer to.
+func (p *insertPlanner) rewriteExcluded(e compiler.Expr) (compiler.Expr, error) {
+	switch n := e.(type) {
+	case *compiler.ColumnRef:
+		if n.Table != compiler.ExcludedTable {
+			return n, nil
+		}
+		column, err := indexColumnFor(p.catalog, p.stmt.TableName, n.Column)
+		if err != nil {
+			return nil, err
+		}
+		return &excludedColumn{expr: n, indexColumn: column}, nil
+	case *compiler.BinaryExpr:
+		left, err := p.rewriteExcluded(n.Left)
+		if err != nil {
+			return nil, err
+		}
+		right, err := p.rewriteExcluded(n.Right)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.BinaryExpr{Left: left, Operator: n.Operator, Right: right}, nil
+	case *compiler.UnaryExpr:
+		operand, err := p.rewriteExcluded(n.Operand)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.UnaryExpr{Operator: n.Operator, Operand: operand}, nil
+	case *compiler.CastExpr:
+		expr, err := p.rewriteExcluded(n.Expr)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.CastExpr{Expr: expr, TypeName: n.TypeName}, nil
+	case *compiler.FunctionExpr:
+		if n.Args == nil {
+			return n, nil
+		}
+		args := []compiler.Expr{}
+		for _, arg := range n.Args {
+			a, err := p.rewriteExcluded(arg)
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+		}
+		return &compiler.FunctionExpr{FnType: n.FnType, Args: args}, nil
+	}
+	return e, nil
+}
+
 func (p *insertPlanner) getNonPkValues() ([][]compiler.Expr, error) {
 	pkColumnName, err := p.catalog.GetPrimaryKeyColumn(p.stmt.TableName)
 	if err != nil {
@@ -122,7 +290,12 @@ func (p *insertPlanner) getNonPkValues() ([][]compiler.Expr, error) {
 				return stmtColName == cn
 			})
 			if stmtColIdx == -1 {
-				return nil, fmt.Errorf("%w %s", errMissingColumnName, cn)
+				d, err := p.getDefault(cn)
+				if err != nil {
+					return nil, err
+				}
+				resultValue = append(resultValue, d)
+				continue
 			}
 			resultValue = append(resultValue, colValue[stmtColIdx])
 		}
@@ -131,6 +304,19 @@ func (p *insertPlanner) getNonPkValues() ([][]compiler.Expr, error) {
 	return resultValues, nil
 }
 
+// getDefault returns the expression for the default value of columnName. An
+// error is returned when the column has no default.
+func (p *insertPlanner) getDefault(columnName string) (compiler.Expr, error) {
+	d, err := p.catalog.GetColumnDefault(p.stmt.TableName, columnName)
+	if err != nil {
+		return nil, err
+	}
+	if d == "" {
+		return nil, fmt.Errorf("%w %s", errMissingColumnName, columnName)
+	}
+	return &compiler.FunctionExpr{FnType: d, Args: []compiler.Expr{}}, nil
+}
+
 // ExecutionPlan returns the bytecode routine for the planner. Calling QueryPlan
 // is not prerequisite to calling ExecutionPlan as ExecutionPlan will be called
 // as needed.
@@ -155,3 +341,44 @@ func (p *insertPlanner) checkValuesMatchColumns(s *compiler.InsertStmt) error {
 	}
 	return nil
 }
+
+// affinityCatalog defines the catalog methods needed to determine the affinity
+// of the columns in a record.
+type affinityCatalog interface {
+	GetColumns(tableOrIndexName string) ([]string, error)
+	GetPrimaryKeyColumn(tableName string) (string, error)
+	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
+}
+
+// recordAffinities returns the affinity of each column stored in a record of
+// tableName for MakeRecordCmd. The primary key is not part of the record so it
+// has no affinity.
+func recordAffinities(c affinityCatalog, tableName string) (string, error) {
+	columns, err := c.GetColumns(tableName)
+	if err != nil {
+		return "", err
+	}
+	pkColumnName, err := c.GetPrimaryKeyColumn(tableName)
+	if err != nil {
+		return "", err
+	}
+	affinities := []byte{}
+	for _, column := range columns {
+		if column == pkColumnName {
+			continue
+		}
+		t, err := c.GetColumnType(tableName, column)
+		if err != nil {
+			return "", err
+		}
+		affinity := vm.BlobAffinity
+		switch t.ID {
+		case catalog.CTInt:
+			affinity = vm.IntegerAffinity
+		case catalog.CTStr:
+			affinity = vm.TextAffinity
+		}
+		affinities = append(affinities, byte(affinity))
+	}
+	return string(affinities), nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"testing"
 
+	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
@@ -11,6 +12,8 @@ import (
 type mockInsertCatalog struct {
 	columnsReturn []string
 	pkColumnName  string
+	indexes       []catalog.Index
+	defaults      map[string]string
 }
 
 func (c *mockInsertCatalog) GetColumns(s string) ([]string, error) {
@@ -31,10 +34,30 @@ func (*mockInsertCatalog) GetVersion() string {
 	return "v"
 }
 
+func (*mockInsertCatalog) GetGeneration(string) int {
+	return 0
+}
+
 func (m *mockInsertCatalog) GetPrimaryKeyColumn(tableName string) (string, error) {
 	return m.pkColumnName, nil
 }
 
+func (m *mockInsertCatalog) GetIndexes(tableName string) []catalog.Index {
+	return m.indexes
+}
+
+func (*mockInsertCatalog) GetColumnType(tableName string, columnName string) (catalog.CdbType, error) {
+	return catalog.CdbType{ID: catalog.CTStr}, nil
+}
+
+func (m *mockInsertCatalog) GetColumnDefault(tableName string, columnName string) (string, error) {
+	return m.defaults[columnName], nil
+}
+
+func (m *mockInsertCatalog) IsAutoIncrement(tableName string) bool {
+	return false
+}
+
 func TestInsertWithoutPrimaryKey(t *testing.T) {
 	expectedCommands := []vm.Command{
 		&vm.InitCmd{P2: 18},
@@ -42,18 +65,18 @@ func TestInsertWithoutPrimaryKey(t *testing.T) {
 		&vm.NewRowIdCmd{P1: 1, P2: 1},
 		&vm.CopyCmd{P1: 4, P2: 2},
 		&vm.CopyCmd{P1: 5, P2: 3},
-		&vm.MakeRecordCmd{P1: 2, P2: 2, P3: 6},
-		&vm.InsertCmd{P1: 1, P2: 6, P3: 1},
+		&vm.MakeRecordCmd{P1: 2, P2: 2, P3: 6, P4: "TT"},
+		&vm.InsertCmd{P1: 1, P2: 6, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.NewRowIdCmd{P1: 1, P2: 7},
 		&vm.CopyCmd{P1: 10, P2: 8},
 		&vm.CopyCmd{P1: 11, P2: 9},
-		&vm.MakeRecordCmd{P1: 8, P2: 2, P3: 12},
-		&vm.InsertCmd{P1: 1, P2: 12, P3: 7},
+		&vm.MakeRecordCmd{P1: 8, P2: 2, P3: 12, P4: "TT"},
+		&vm.InsertCmd{P1: 1, P2: 12, P3: 7, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.NewRowIdCmd{P1: 1, P2: 13},
 		&vm.CopyCmd{P1: 16, P2: 14},
 		&vm.CopyCmd{P1: 17, P2: 15},
-		&vm.MakeRecordCmd{P1: 14, P2: 2, P3: 18},
-		&vm.InsertCmd{P1: 1, P2: 18, P3: 13},
+		&vm.MakeRecordCmd{P1: 14, P2: 2, P3: 18, P4: "TT"},
+		&vm.InsertCmd{P1: 1, P2: 18, P3: 13, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.StringCmd{P1: 4, P4: "gud"},
@@ -107,8 +130,8 @@ func TestInsertWithPrimaryKey(t *testing.T) {
 		&vm.NotExistsCmd{P1: 1, P2: 6, P3: 1},
 		&vm.HaltCmd{P1: 1, P4: "pk unique constraint violated"},
 		&vm.CopyCmd{P1: 4, P2: 3},
-		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5},
-		&vm.InsertCmd{P1: 1, P2: 5, P3: 1},
+		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5, P4: "T"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.IntegerCmd{P1: 22, P2: 2},
@@ -142,6 +165,58 @@ func TestInsertWithPrimaryKey(t *testing.T) {
 	}
 }
 
+func TestInsertWithIndexes(t *testing.T) {
+	expectedCommands := []vm.Command{
+		&vm.InitCmd{P2: 14},
+		&vm.OpenWriteCmd{P1: 1, P2: 2},
+		&vm.OpenWriteCmd{P1: 2, P2: 3},
+		&vm.OpenWriteCmd{P1: 3, P2: 4},
+		&vm.CopyCmd{P1: 2, P2: 1},
+		&vm.MustBeIntCmd{P1: 1},
+		&vm.NotExistsCmd{P1: 1, P2: 8, P3: 1},
+		&vm.HaltCmd{P1: 1, P4: "pk unique constraint violated"},
+		&vm.CopyCmd{P1: 4, P2: 3},
+		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5, P4: "T"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
+		&vm.IdxInsertCmd{P1: 2, P2: 3, P3: 1},
+		&vm.IdxInsertCmd{P1: 3, P2: 1, P3: 1},
+		&vm.HaltCmd{},
+		&vm.TransactionCmd{P2: 1},
+		&vm.IntegerCmd{P1: 22, P2: 2},
+		&vm.StringCmd{P1: 4, P4: "gud"},
+		&vm.GotoCmd{P2: 1},
+	}
+	ast := &compiler.InsertStmt{
+		StmtBase:  &compiler.StmtBase{},
+		TableName: "foo",
+		ColNames: []string{
+			"id",
+			"first",
+		},
+		ColValues: [][]compiler.Expr{
+			{
+				&compiler.IntLit{Value: 22},
+				&compiler.StringLit{Value: "gud"},
+			},
+		},
+	}
+	mockCatalog := &mockInsertCatalog{
+		columnsReturn: []string{"id", "first"},
+		pkColumnName:  "id",
+		indexes: []catalog.Index{
+			{Name: "idx_first", TableName: "foo", RootPageNumber: 3, Columns: []string{"first"}},
+			{Name: "idx_id", TableName: "foo", RootPageNumber: 4, Columns: []string{"id"}},
+		},
+	}
+	plan, err := NewInsert(mockCatalog, ast).ExecutionPlan()
+	if err != nil {
+		t.Errorf("expected no err got err %s", err)
+	}
+	if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestInsertWithPrimaryKeyMiddleOrder(t *testing.T) {
 	expectedCommands := []vm.Command{
 		&vm.InitCmd{P2: 10},
@@ -151,8 +226,8 @@ func TestInsertWithPrimaryKeyMiddleOrder(t *testing.T) {
 		&vm.NotExistsCmd{P1: 1, P2: 6, P3: 1},
 		&vm.HaltCmd{P1: 1, P4: "pk unique constraint violated"},
 		&vm.CopyCmd{P1: 4, P2: 3},
-		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5},
-		&vm.InsertCmd{P1: 1, P2: 5, P3: 1},
+		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5, P4: "T"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.IntegerCmd{P1: 12, P2: 2},
@@ -195,8 +270,8 @@ func TestInsertWithPrimaryKeyParameter(t *testing.T) {
 		&vm.NotExistsCmd{P1: 1, P2: 6, P3: 1},
 		&vm.HaltCmd{P1: 1, P4: "pk unique constraint violated"},
 		&vm.CopyCmd{P1: 4, P2: 3},
-		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5},
-		&vm.InsertCmd{P1: 1, P2: 5, P3: 1},
+		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5, P4: "T"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.StringCmd{P1: 4, P4: "feller"},
@@ -239,8 +314,8 @@ func TestInsertWithParameter(t *testing.T) {
 		&vm.NotExistsCmd{P1: 1, P2: 6, P3: 1},
 		&vm.HaltCmd{P1: 1, P4: "pk unique constraint violated"},
 		&vm.CopyCmd{P1: 4, P2: 3},
-		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5},
-		&vm.InsertCmd{P1: 1, P2: 5, P3: 1},
+		&vm.MakeRecordCmd{P1: 3, P2: 1, P3: 5, P4: "T"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.VariableCmd{P1: 0, P2: 2},
@@ -340,6 +415,41 @@ func TestInsertValuesNotMatchingColumnsGreater(t *testing.T) {
 	}
 }
 
+func TestInsertColumnDefault(t *testing.T) {
+	expectedCommands := []vm.Command{
+		&vm.InitCmd{P2: 8},
+		&vm.OpenWriteCmd{P1: 1, P2: 2},
+		&vm.NewRowIdCmd{P1: 1, P2: 1},
+		&vm.CopyCmd{P1: 4, P2: 2},
+		&vm.CurrentTimeCmd{P1: 3, P4: vm.TimestampLayout},
+		&vm.MakeRecordCmd{P1: 2, P2: 2, P3: 5, P4: "TT"},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange | vm.SetLastInsertRowID},
+		&vm.HaltCmd{},
+		&vm.TransactionCmd{P2: 1},
+		&vm.StringCmd{P1: 4, P4: "gud"},
+		&vm.GotoCmd{P2: 1},
+	}
+	ast := &compiler.InsertStmt{
+		StmtBase:  &compiler.StmtBase{},
+		TableName: "foo",
+		ColNames:  []string{"first"},
+		ColValues: [][]compiler.Expr{
+			{&compiler.StringLit{Value: "gud"}},
+		},
+	}
+	mockCatalog := &mockInsertCatalog{
+		columnsReturn: []string{"first", "created"},
+		defaults:      map[string]string{"created": compiler.FnCurrentTimestamp},
+	}
+	plan, err := NewInsert(mockCatalog, ast).ExecutionPlan()
+	if err != nil {
+		t.Fatalf("expected no err got err %s", err)
+	}
+	if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestInsertIntoNonExistingColumn(t *testing.T) {
 	ast := &compiler.InsertStmt{
 		StmtBase:  &compiler.StmtBase{},
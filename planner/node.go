@@ -2,7 +2,9 @@ package planner
 
 import (
 	"fmt"
+	"strings"
 
+	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
 )
 
@@ -26,19 +28,33 @@ type logicalNode interface {
 	setChildren(n ...logicalNode)
 }
 
-// TODO joinNode is unused, but remains as a prototype binary operation node.
+// joinNode joins each row of left to the rows of the table scanned by right
+// with a nested loop. Every row of right is visited for each row of left.
 type joinNode struct {
-	// left is the left subtree of the join.
+	parent logicalNode
+	plan   *QueryPlan
+	// left is the left subtree of the join which is a scan of the first table
+	// of the join or another join.
 	left logicalNode
-	// right is the right subtree of the join.
+	// right is the scan of the table joined to the rows of left.
 	right logicalNode
-	// TODO operation is the type of join to be performed. Possibly left, right
-	// or inner join. Could also have a field for join algorithm i.e. loop.
-	operation string
+	// isLeft is true for a left join meaning a row of left with no matching
+	// row in right is joined to a row where every column of right is NULL.
+	isLeft bool
+	// on is the predicate a row of right must match to be joined to a row of
+	// left. on is nil when every row is joined.
+	on compiler.Expr
 }
 
 func (j *joinNode) print() string {
-	return fmt.Sprint(j.operation)
+	operation := "join"
+	if j.isLeft {
+		operation = "left join"
+	}
+	if j.on == nil {
+		return operation
+	}
+	return operation + " on (" + j.on.Print() + ")"
 }
 
 func (j *joinNode) children() []logicalNode {
@@ -76,12 +92,19 @@ type createNode struct {
 	// catalogCursorId is the id of the cursor associated with the system
 	// catalog table being updated.
 	catalogCursorId int
+	// sequenceSchema is the json serialized schema of cdb_sequence when it
+	// must be created along with the table. It is empty when cdb_sequence
+	// exists or the table is not AUTOINCREMENT.
+	sequenceSchema string
 }
 
 func (c *createNode) print() string {
 	if c.noop {
 		return fmt.Sprintf("assert table %s does not exist", c.tableName)
 	}
+	if c.sequenceSchema != "" {
+		return fmt.Sprintf("create table %s and %s", c.tableName, catalog.SequenceTableName)
+	}
 	return fmt.Sprintf("create table %s", c.tableName)
 }
 
@@ -91,6 +114,109 @@ func (c *createNode) children() []logicalNode {
 
 func (c *createNode) setChildren(n ...logicalNode) {}
 
+// createIndexNode represents a operation to create an index in the system
+// catalog and fill the index with an entry for each row of its table.
+type createIndexNode struct {
+	plan       *QueryPlan
+	indexName  string
+	tableName  string
+	columnName string
+	// schema is the json serialized schema definition for the index.
+	schema string
+	// noop is true when the index exists and the statement has IF NOT EXISTS.
+	// See createNode.noop.
+	noop bool
+	// catalogRootPageNumber is the page number of the system catalog.
+	catalogRootPageNumber int
+	// catalogCursorId is the id of the cursor on the system catalog.
+	catalogCursorId int
+	// tableRootPageNumber is the page number of the indexed table.
+	tableRootPageNumber int
+	// tableCursorId is the id of the cursor reading the indexed table.
+	tableCursorId int
+	// indexCursorId is the id of the cursor writing the new index.
+	indexCursorId int
+	// isPrimaryKey is true when the indexed column is the row id.
+	isPrimaryKey bool
+	// colIdx is the nth non primary key value of the indexed column.
+	colIdx int
+	// include are the nth non primary key values the entries include. See
+	// secondaryIndex.include.
+	include []int
+	// unique is true when the index rejects rows with equal values.
+	unique bool
+}
+
+func (c *createIndexNode) print() string {
+	if c.noop {
+		return fmt.Sprintf("assert index %s does not exist", c.indexName)
+	}
+	if c.unique {
+		return fmt.Sprintf("create unique index %s on %s (%s)", c.indexName, c.tableName, c.columnName)
+	}
+	return fmt.Sprintf("create index %s on %s (%s)", c.indexName, c.tableName, c.columnName)
+}
+
+func (c *createIndexNode) children() []logicalNode {
+	return []logicalNode{}
+}
+
+func (c *createIndexNode) setChildren(n ...logicalNode) {}
+
+// analyzeNode represents an operation to gather the statistics of tables and
+// their indexes into cdb_stat.
+type analyzeNode struct {
+	plan *QueryPlan
+	// tableName is the table being analyzed. It is empty when every table is
+	// analyzed.
+	tableName string
+	// tables are the tables being analyzed.
+	tables []analyzeTable
+	// statSchema is the json serialized schema of cdb_stat when it must be
+	// created. It is empty when cdb_stat exists.
+	statSchema string
+	// statRootPageNumber is the root page of cdb_stat when it exists.
+	statRootPageNumber int
+	// statCursorId is the id of the cursor writing cdb_stat.
+	statCursorId int
+	// catalogRootPageNumber is the page number of the system catalog.
+	catalogRootPageNumber int
+	// catalogCursorId is the id of the cursor on the system catalog.
+	catalogCursorId int
+}
+
+// analyzeTable is a table being analyzed by analyzeNode.
+type analyzeTable struct {
+	name           string
+	rootPageNumber int
+	// cursorId is the id of the cursor reading the table.
+	cursorId int
+	// indexes are the indexes of the table which are each read with their own
+	// cursor.
+	indexes []secondaryIndex
+}
+
+func (a *analyzeNode) print() string {
+	names := []string{}
+	for _, table := range a.tables {
+		names = append(names, table.name)
+	}
+	analyzed := "analyze " + strings.Join(names, ", ")
+	if len(names) == 0 {
+		analyzed = "analyze no tables"
+	}
+	if a.statSchema != "" {
+		return fmt.Sprintf("create table %s and %s", catalog.StatTableName, analyzed)
+	}
+	return analyzed
+}
+
+func (a *analyzeNode) children() []logicalNode {
+	return []logicalNode{}
+}
+
+func (a *analyzeNode) setChildren(n ...logicalNode) {}
+
 // insertNode represents an insert operation.
 type insertNode struct {
 	plan *QueryPlan
@@ -107,6 +233,8 @@ type insertNode struct {
 	// autoPk indicates the generator should use a NewRowIdCmd for pk
 	// generation.
 	autoPk bool
+	// affinities has the affinity of each value in colValues.
+	affinities string
 	// tableName is the name of the table being inserted to.
 	tableName string
 	// rootPageNumber is the page number of the table being inserted to.
@@ -114,6 +242,22 @@ type insertNode struct {
 	// cursorId is the id of the cursor associated with the table being inserted
 	// to.
 	cursorId int
+	// indexes are the secondary indexes that get an entry for each inserted
+	// row.
+	indexes []secondaryIndex
+	// sequenceRootPageNumber is the page number of cdb_sequence when the table
+	// is AUTOINCREMENT. It is 0 otherwise.
+	sequenceRootPageNumber int
+	// sequenceCursorId is the id of the cursor associated with cdb_sequence.
+	sequenceCursorId int
+	// conflict is how a row with the primary key of an existing row, or the
+	// value of a unique index of an existing row, is resolved. It is one of the
+	// compiler.Conflict constants.
+	conflict string
+	// conflictExprs are the expressions for each non primary key column of the
+	// existing row when conflict is compiler.ConflictUpdate. They are in the
+	// same order as colValues.
+	conflictExprs []compiler.Expr
 }
 
 func (i *insertNode) print() string {
@@ -133,6 +277,8 @@ type countNode struct {
 	tableName string
 	// rootPageNumber is the page number of the table being scanned.
 	rootPageNumber int
+	// virtualTable is the table being scanned when it is not a b tree.
+	virtualTable *VirtualTable
 	// cursorId is the id of the cursor associated with the table being scanned.
 	cursorId int
 }
@@ -162,6 +308,22 @@ func (c *constantNode) children() []logicalNode {
 
 func (c *constantNode) setChildren(n ...logicalNode) {}
 
+// emptyNode is a data source that produces no rows. It replaces a scan when
+// the predicate filtering the scan is always false.
+type emptyNode struct {
+	plan *QueryPlan
+}
+
+func (e *emptyNode) print() string {
+	return "empty data source"
+}
+
+func (e *emptyNode) children() []logicalNode {
+	return []logicalNode{}
+}
+
+func (e *emptyNode) setChildren(n ...logicalNode) {}
+
 type projection struct {
 	expr compiler.Expr
 	// alias is the alias of the projection or no alias for the zero value.
@@ -190,6 +352,91 @@ func (p *projectNode) setChildren(n ...logicalNode) {
 	p.child = n[0]
 }
 
+// orderingTerm is a term of an ORDER BY clause.
+type orderingTerm struct {
+	expr compiler.Expr
+	// desc is true when the term is sorted in descending order.
+	desc bool
+}
+
+// orderNode sorts the rows of its child. Each row is inserted into a sorter
+// which then becomes the data source of the parent.
+type orderNode struct {
+	child  logicalNode
+	parent logicalNode
+	plan   *QueryPlan
+	terms  []orderingTerm
+	// cursorId is the id of the sorter.
+	cursorId int
+	// sourceCursorId is the id of the cursor associated with the rows being
+	// sorted.
+	sourceCursorId int
+	// values are stored as the row of each sort key instead of the row of
+	// sourceCursorId. This is how the rows of a join are sorted since they
+	// are read from more than one cursor. The parent reads them as the
+	// groupColumns of cursorId.
+	values []compiler.Expr
+}
+
+func (o *orderNode) print() string {
+	terms := []string{}
+	for _, term := range o.terms {
+		if term.desc {
+			terms = append(terms, term.expr.Print()+" DESC")
+		} else {
+			terms = append(terms, term.expr.Print())
+		}
+	}
+	return "order by " + strings.Join(terms, ", ")
+}
+
+func (o *orderNode) children() []logicalNode {
+	return []logicalNode{o.child}
+}
+
+func (o *orderNode) setChildren(n ...logicalNode) {
+	o.child = n[0]
+}
+
+// aggregateNode groups the rows of its child and computes aggregate functions
+// for each group. The groups are stored in a group table which then becomes
+// the data source of the parent. Each row of the group table is the group keys
+// followed by the result of each aggregate function.
+type aggregateNode struct {
+	child  logicalNode
+	parent logicalNode
+	plan   *QueryPlan
+	// groupBy are the expressions rows are grouped by. When there are none
+	// every row is in a single group.
+	groupBy []compiler.Expr
+	// aggregates are the aggregate functions computed for each group.
+	aggregates []*compiler.FunctionExpr
+	// cursorId is the id of the group table.
+	cursorId int
+	// sourceCursorId is the id of the cursor associated with the rows being
+	// grouped.
+	sourceCursorId int
+}
+
+func (a *aggregateNode) print() string {
+	if len(a.groupBy) == 0 {
+		return "aggregate"
+	}
+	exprs := []string{}
+	for _, e := range a.groupBy {
+		exprs = append(exprs, e.Print())
+	}
+	return "group by " + strings.Join(exprs, ", ")
+}
+
+func (a *aggregateNode) children() []logicalNode {
+	return []logicalNode{a.child}
+}
+
+func (a *aggregateNode) setChildren(n ...logicalNode) {
+	a.child = n[0]
+}
+
 type scanNode struct {
 	parent logicalNode
 	plan   *QueryPlan
@@ -197,14 +444,68 @@ type scanNode struct {
 	tableName string
 	// rootPageNumber is the page number of the table being scanned.
 	rootPageNumber int
+	// virtualTable is the table being scanned when it is not a b tree.
+	virtualTable *VirtualTable
 	// cursorId is the id of the cursor associated with the table being scanned.
 	cursorId int
 	// isWriteCursor is true when the cursor should be a write cursor.
 	isWriteCursor bool
-}
+	// lowerBound is an expression the primary key is greater than. When
+	// lowerBound is not nil the scan seeks past it instead of starting at the
+	// first row.
+	lowerBound compiler.Expr
+	// upperBound is the primary key less than or less than or equal to a
+	// constant. When upperBound is not nil the scan stops at the first row
+	// past the bound that no later row can be before. It is only set along
+	// with lowerBound.
+	upperBound *compiler.BinaryExpr
+	// order is the order of the row ids the scan visits rows in. A scan in row
+	// id order stands in for sorting by the primary key.
+	order scanOrder
+	// estimatedRows is the number of rows the node is estimated to visit. It
+	// is 0 when there is no estimate since the table has not been analyzed.
+	estimatedRows int
+}
+
+// scanOrder is the order a scan visits rows in.
+type scanOrder int
+
+const (
+	// scanUnordered visits rows in key order which is the fastest order.
+	scanUnordered scanOrder = 0
+	// scanAscending visits rows from the least row id to the greatest.
+	scanAscending scanOrder = 1
+	// scanDescending visits rows from the greatest row id to the least.
+	scanDescending scanOrder = 2
+)
 
 func (s *scanNode) print() string {
-	return fmt.Sprintf("scan table %s", s.tableName)
+	if s.lowerBound != nil && s.upperBound != nil {
+		until := "before"
+		if s.upperBound.Operator == compiler.OpLte {
+			until = "through"
+		}
+		return fmt.Sprintf("scan table %s after row id %s %s row id %s%s", s.tableName, s.lowerBound.Print(), until, s.upperBound.Right.Print(), printEstimate(s.estimatedRows))
+	}
+	if s.lowerBound != nil {
+		return fmt.Sprintf("scan table %s after row id %s%s", s.tableName, s.lowerBound.Print(), printEstimate(s.estimatedRows))
+	}
+	switch s.order {
+	case scanAscending:
+		return fmt.Sprintf("scan table %s in row id order%s", s.tableName, printEstimate(s.estimatedRows))
+	case scanDescending:
+		return fmt.Sprintf("scan table %s in descending row id order%s", s.tableName, printEstimate(s.estimatedRows))
+	}
+	return fmt.Sprintf("scan table %s%s", s.tableName, printEstimate(s.estimatedRows))
+}
+
+// printEstimate returns the estimated number of rows a node visits formatted to
+// follow the description of the node. It is empty when there is no estimate.
+func printEstimate(estimatedRows int) string {
+	if estimatedRows == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (~%d rows)", estimatedRows)
 }
 
 func (s *scanNode) children() []logicalNode {
@@ -220,6 +521,8 @@ type seekNode struct {
 	tableName string
 	// rootPageNumber is the root page number of the table being searched.
 	rootPageNumber int
+	// virtualTable is the table being searched when it is not a b tree.
+	virtualTable *VirtualTable
 	// cursorId is the id of the cursor associated with the search.
 	cursorId int
 	// isWriteCursor determines whether or not the cursor is for read or write.
@@ -240,6 +543,109 @@ func (s *seekNode) children() []logicalNode {
 
 func (s *seekNode) setChildren(n ...logicalNode) {}
 
+// indexSeekNode visits the rows with a column equal to a value by seeking the
+// value in an index on the column.
+type indexSeekNode struct {
+	parent logicalNode
+	plan   *QueryPlan
+	// tableName is the name of the table being searched.
+	tableName string
+	// rootPageNumber is the root page number of the table being searched.
+	rootPageNumber int
+	// cursorId is the id of the cursor the parent reads rows from.
+	cursorId int
+	// index is the index being searched.
+	index secondaryIndex
+	// columnCount is the number of non primary key columns in the table.
+	columnCount int
+	// covering is true when the index has every column the query uses. A
+	// covering index is read in place of the table so cursorId is the cursor
+	// of the index.
+	covering bool
+	// fullPredicate is the entire expression this node matches.
+	fullPredicate compiler.Expr
+	// predicate is the value the indexed column must be equal to or the text
+	// the indexed column must start with when prefix is true.
+	predicate compiler.Expr
+	// prefix is true when the seek visits the entries that may start with
+	// predicate. These are a superset of the matching entries so the parent is
+	// a filter.
+	prefix bool
+	// estimatedRows is the number of rows the node is estimated to visit. It
+	// is 0 when there is no estimate since the table has not been analyzed.
+	estimatedRows int
+}
+
+func (s *indexSeekNode) print() string {
+	indexType := "index"
+	if s.covering {
+		indexType = "covering index"
+	}
+	seekType := "seek"
+	if s.prefix {
+		seekType = "prefix seek"
+	}
+	return fmt.Sprintf(
+		"%s table %s using %s %s (%s)%s",
+		seekType,
+		s.tableName,
+		indexType,
+		s.index.name,
+		s.fullPredicate.Print(),
+		printEstimate(s.estimatedRows),
+	)
+}
+
+func (s *indexSeekNode) children() []logicalNode {
+	return []logicalNode{}
+}
+
+func (s *indexSeekNode) setChildren(n ...logicalNode) {}
+
+// indexScanNode visits every row of a table in the order of an indexed column
+// by scanning the index. This stands in for sorting by the column.
+type indexScanNode struct {
+	parent logicalNode
+	plan   *QueryPlan
+	// tableName is the name of the table being scanned.
+	tableName string
+	// rootPageNumber is the root page number of the table being scanned.
+	rootPageNumber int
+	// cursorId is the id of the cursor the parent reads rows from.
+	cursorId int
+	// index is the index being scanned.
+	index secondaryIndex
+	// columnCount is the number of non primary key columns in the table.
+	columnCount int
+	// covering is true when the index has every column the query uses. See
+	// indexSeekNode.
+	covering bool
+	// desc is true when the index is scanned from the greatest value to the
+	// least.
+	desc bool
+	// estimatedRows is the number of rows the node is estimated to visit. It
+	// is 0 when there is no estimate since the table has not been analyzed.
+	estimatedRows int
+}
+
+func (s *indexScanNode) print() string {
+	indexType := "index"
+	if s.covering {
+		indexType = "covering index"
+	}
+	order := ""
+	if s.desc {
+		order = " in descending order"
+	}
+	return fmt.Sprintf("scan table %s using %s %s%s%s", s.tableName, indexType, s.index.name, order, printEstimate(s.estimatedRows))
+}
+
+func (s *indexScanNode) children() []logicalNode {
+	return []logicalNode{}
+}
+
+func (s *indexScanNode) setChildren(n ...logicalNode) {}
+
 type filterNode struct {
 	child     logicalNode
 	parent    logicalNode
@@ -271,18 +677,37 @@ type updateNode struct {
 	// columnRef or the complex expression from the right hand side of the SET
 	// keyword. Note it is important to provide the expressions in their correct
 	// ordinal position as the generator will not try to order them correctly.
-	//
-	// The row id is not allowed to be updated at the moment because it could
-	// cause infinite loops due to it changing the physical location of the
-	// record. The query plan will have to use a temporary storage to update
-	// primary keys.
 	updateExprs []compiler.Expr
+	// pkExpr is the new value of the primary key. It is nil when the primary
+	// key is not set.
+	//
+	// Updating the primary key changes the physical location of the record so
+	// the scan could visit an updated row again. When pkExpr is set the rows
+	// are first copied into the sorter sorterCursorId and then updated in a
+	// second pass over the sorter.
+	pkExpr compiler.Expr
+	// sorterCursorId is the id of the sorter holding the rows to update when
+	// the primary key is set.
+	sorterCursorId int
+	// affinities has the affinity of each expression in updateExprs.
+	affinities string
 	// tableName is the name of the table being updated.
 	tableName string
 	// rootPageNumber is the page number of the table being updated.
 	rootPageNumber int
 	// cursorId is the id of the cursor associated with the table being updated.
 	cursorId int
+	// indexes are the secondary indexes that have the entry of each updated
+	// row replaced.
+	indexes []secondaryIndex
+	// limit is the most rows that are updated. It is computed once before any
+	// row is updated and there is no limit when it is nil or negative.
+	limit compiler.Expr
+	// limitRegister counts down the rows left to update when there is a limit.
+	limitRegister int
+	// limitJumps are the commands jumping out of the scan once limit rows are
+	// updated.
+	limitJumps jumpCommands
 }
 
 func (u *updateNode) print() string {
@@ -302,6 +727,9 @@ type deleteNode struct {
 	plan           *QueryPlan
 	rootPageNumber int
 	cursorId       int
+	// indexes are the secondary indexes that have the entry of each deleted
+	// row removed.
+	indexes []secondaryIndex
 }
 
 func (d *deleteNode) print() string {
@@ -1,40 +1,451 @@
 package planner
 
-import "github.com/chirst/cdb/compiler"
+import (
+	"math"
+	"strings"
 
-type optimizer struct{}
+	"github.com/chirst/cdb/compiler"
+)
+
+type optimizer struct {
+	// indexes are the secondary indexes of the table being read. The indexes
+	// are considered when the primary key cannot be used to find rows.
+	indexes []secondaryIndex
+	// columnCount is the number of non primary key columns in the table being
+	// read.
+	columnCount int
+	// columns are the column references of the query. An index covers the
+	// query when every column in columns is part of the index.
+	columns []*compiler.ColumnRef
+	// stats are the statistics of the table being read. They are used to
+	// choose between the ways of reading the rows.
+	stats tableStats
+}
 
 func (o *optimizer) optimizePlan(plan *QueryPlan) {
 	if len(plan.root.children()) == 0 {
 		return
 	}
-	filterNode, ok := plan.root.children()[0].(*filterNode)
-	if !ok {
+	child := plan.root.children()[0]
+	on, isOrdered := child.(*orderNode)
+	if isOrdered {
+		child = on.child
+	}
+	if an, ok := child.(*aggregateNode); ok {
+		child = an.child
+	}
+	if filterNode, ok := child.(*filterNode); ok {
+		o.optimizeFilter(filterNode)
+	}
+	if isOrdered {
+		o.optimizeOrder(on)
+	}
+	o.estimateRows(plan.root)
+}
+
+// estimateRows sets the estimated number of rows read by each node below n
+// that reads a table so the estimate is part of the plan explained by EXPLAIN
+// QUERY PLAN. Nothing is estimated when the table has not been analyzed.
+func (o *optimizer) estimateRows(n logicalNode) {
+	if !o.stats.analyzed {
 		return
 	}
+	switch n := n.(type) {
+	case *scanNode:
+		n.estimatedRows = o.stats.rowCount
+	case *indexScanNode:
+		n.estimatedRows = o.stats.rowCount
+	case *indexSeekNode:
+		n.estimatedRows = int(math.Round(o.stats.indexSeekRows(&n.index, n.prefix)))
+	}
+	for _, child := range n.children() {
+		o.estimateRows(child)
+	}
+}
+
+// optimizeFilter replaces the scan below filterNode with a seek when a term of
+// the predicate of the filter can find the rows without visiting every row. The
+// terms of a predicate are the expressions joined by AND. The filter is kept
+// when the seek does not match every term.
+//
+// A seek of the primary key is always chosen since it visits at most one row.
+// Otherwise the cheapest seek of an index is chosen when it is cheaper than
+// visiting every row. See tableStats.
+func (o *optimizer) optimizeFilter(filterNode *filterNode) {
 	sn, ok := filterNode.child.(*scanNode)
 	if !ok {
 		return
 	}
-	rowExpr := o.canOpt(filterNode.predicate)
-	if rowExpr == nil {
-		return
+	terms := conjuncts(filterNode.predicate)
+	for _, term := range terms {
+		if rowExpr := o.canOpt(term); rowExpr != nil {
+			o.optimizeSeek(filterNode, sn, term, rowExpr, len(terms) == 1)
+			return
+		}
 	}
-	// If the filter can be moved to a seek then remove the filter and push the
-	// predicate into a seek.
+	if !o.optimizeIndexSeek(filterNode, sn, terms) {
+		o.optimizeRangeScan(sn, terms)
+	}
+}
+
+// conjuncts returns the terms of predicate which are the expressions joined by
+// AND.
+func conjuncts(predicate compiler.Expr) []compiler.Expr {
+	be, ok := predicate.(*compiler.BinaryExpr)
+	if !ok || be.Operator != compiler.OpAnd {
+		return []compiler.Expr{predicate}
+	}
+	return append(conjuncts(be.Left), conjuncts(be.Right)...)
+}
+
+// optimizeSeek replaces the scan with a seek of the row id in rowExpr which is
+// the value of the primary key in term. The filter is removed when term is the
+// whole predicate.
+func (o *optimizer) optimizeSeek(filterNode *filterNode, sn *scanNode, term, rowExpr compiler.Expr, isPredicate bool) {
 	seekN := &seekNode{
-		parent:         filterNode.parent,
+		parent:         filterNode,
 		plan:           sn.plan,
 		tableName:      sn.tableName,
 		rootPageNumber: sn.rootPageNumber,
+		virtualTable:   sn.virtualTable,
 		cursorId:       sn.cursorId,
 		isWriteCursor:  sn.isWriteCursor,
-		fullPredicate:  filterNode.predicate,
+		fullPredicate:  term,
 		predicate:      rowExpr,
 	}
+	if isPredicate {
+		seekN.parent = filterNode.parent
+	}
 	seekN.parent.setChildren(seekN)
 }
 
+// optimizeIndexSeek replaces the scan with the cheapest seek of an index for a
+// term of the predicate when it is cheaper than the scan. A term can be sought
+// when it is an indexed column equal to a constant or an indexed column LIKE a
+// pattern that starts with text. The filter is removed when the term is an
+// equality and the whole predicate. Otherwise it is kept to check the other
+// terms or the pattern since a prefix seek visits entries that may not match
+// it. It returns true when the plan is changed.
+func (o *optimizer) optimizeIndexSeek(filterNode *filterNode, sn *scanNode, terms []compiler.Expr) bool {
+	if sn.isWriteCursor || sn.virtualTable != nil {
+		return false
+	}
+	var best *indexSeekNode
+	bestCost := o.stats.scanCost()
+	for _, term := range terms {
+		index, valueExpr := o.canIndexOpt(term)
+		prefix := false
+		if index == nil {
+			index, valueExpr = o.canIndexPrefixOpt(term)
+			prefix = true
+		}
+		if index == nil {
+			continue
+		}
+		covering := o.isCovering(index)
+		cost := indexCost(o.stats.indexSeekRows(index, prefix), covering)
+		if cost >= bestCost {
+			continue
+		}
+		bestCost = cost
+		best = &indexSeekNode{
+			parent:         filterNode,
+			plan:           sn.plan,
+			tableName:      sn.tableName,
+			rootPageNumber: sn.rootPageNumber,
+			cursorId:       sn.cursorId,
+			index:          *index,
+			columnCount:    o.columnCount,
+			covering:       covering,
+			fullPredicate:  term,
+			predicate:      valueExpr,
+			prefix:         prefix,
+		}
+	}
+	if best == nil {
+		return false
+	}
+	if best.covering {
+		best.index.cursorId = sn.cursorId
+	}
+	if !best.prefix && len(terms) == 1 {
+		best.parent = filterNode.parent
+	}
+	best.parent.setChildren(best)
+	return true
+}
+
+// canIndexPrefixOpt returns the index and the prefix to seek when predicate is
+// an indexed column LIKE a pattern that starts with text.
+func (o *optimizer) canIndexPrefixOpt(predicate compiler.Expr) (*secondaryIndex, compiler.Expr) {
+	be, ok := predicate.(*compiler.BinaryExpr)
+	if !ok || be.Operator != compiler.OpLike {
+		return nil, nil
+	}
+	cr, ok := be.Left.(*compiler.ColumnRef)
+	if !ok {
+		return nil, nil
+	}
+	pattern, ok := be.Right.(*compiler.StringLit)
+	if !ok {
+		return nil, nil
+	}
+	prefix := likePrefix(pattern.Value)
+	if prefix == "" {
+		return nil, nil
+	}
+	if index := o.indexOn(cr); index != nil {
+		return index, &compiler.StringLit{Value: prefix}
+	}
+	return nil, nil
+}
+
+// likePrefix returns the text every value matching the LIKE pattern starts
+// with. This is the pattern up to the first wildcard.
+func likePrefix(pattern string) string {
+	if i := strings.IndexAny(pattern, "%_"); i != -1 {
+		return pattern[:i]
+	}
+	return pattern
+}
+
+// optimizeRangeScan starts the scan after the lower bound of the primary key
+// when a term of the filter is the primary key greater than a constant. When
+// another term is the primary key less than a constant the scan also stops
+// once it passes that upper bound. The filter is kept since the scan only skips
+// the rows outside the bounds.
+func (o *optimizer) optimizeRangeScan(sn *scanNode, terms []compiler.Expr) {
+	if sn.virtualTable != nil {
+		return
+	}
+	for _, term := range terms {
+		if boundExpr := lowerBoundOf(term); boundExpr != nil {
+			sn.lowerBound = boundExpr
+			break
+		}
+	}
+	if sn.lowerBound == nil {
+		return
+	}
+	for _, term := range terms {
+		if upperBound := upperBoundOf(term); upperBound != nil {
+			sn.upperBound = upperBound
+			return
+		}
+	}
+}
+
+// lowerBoundOf returns the constant the primary key is greater than when term
+// is the primary key greater than a constant.
+func lowerBoundOf(term compiler.Expr) compiler.Expr {
+	be, ok := term.(*compiler.BinaryExpr)
+	if !ok {
+		return nil
+	}
+	var cr, boundExpr compiler.Expr
+	switch be.Operator {
+	case compiler.OpGt:
+		cr, boundExpr = be.Left, be.Right
+	case compiler.OpLt:
+		cr, boundExpr = be.Right, be.Left
+	default:
+		return nil
+	}
+	if pk, ok := cr.(*compiler.ColumnRef); !ok || !pk.IsPrimaryKey {
+		return nil
+	}
+	if !isConstant(boundExpr) {
+		return nil
+	}
+	return boundExpr
+}
+
+// upperBoundOf returns term as the primary key less than or less than or equal
+// to a constant when term is such a comparison.
+func upperBoundOf(term compiler.Expr) *compiler.BinaryExpr {
+	be, ok := term.(*compiler.BinaryExpr)
+	if !ok {
+		return nil
+	}
+	var cr, boundExpr compiler.Expr
+	var operator string
+	switch be.Operator {
+	case compiler.OpLt, compiler.OpLte:
+		cr, boundExpr, operator = be.Left, be.Right, be.Operator
+	case compiler.OpGt:
+		cr, boundExpr, operator = be.Right, be.Left, compiler.OpLt
+	case compiler.OpGte:
+		cr, boundExpr, operator = be.Right, be.Left, compiler.OpLte
+	default:
+		return nil
+	}
+	if pk, ok := cr.(*compiler.ColumnRef); !ok || !pk.IsPrimaryKey {
+		return nil
+	}
+	if !isConstant(boundExpr) {
+		return nil
+	}
+	return &compiler.BinaryExpr{Left: cr, Operator: operator, Right: boundExpr}
+}
+
+// optimizeOrder removes the sort of on when the rows can be read in the order of
+// its single term. Rows are read in the order of the primary key by scanning
+// the table in row id order and in the order of an indexed column by scanning
+// the index when that is cheaper than sorting the rows.
+func (o *optimizer) optimizeOrder(on *orderNode) {
+	if len(on.terms) != 1 {
+		return
+	}
+	cr, ok := on.terms[0].expr.(*compiler.ColumnRef)
+	if !ok {
+		return
+	}
+	pn, ok := on.parent.(*projectNode)
+	if !ok {
+		return
+	}
+	fn, _ := on.child.(*filterNode)
+	var sn *scanNode
+	if fn != nil {
+		sn, _ = fn.child.(*scanNode)
+	} else {
+		sn, _ = on.child.(*scanNode)
+	}
+	if sn == nil || sn.isWriteCursor || sn.virtualTable != nil || sn.lowerBound != nil {
+		return
+	}
+	var source logicalNode
+	if cr.IsPrimaryKey {
+		sn.order = scanAscending
+		if on.terms[0].desc {
+			sn.order = scanDescending
+		}
+		source = sn
+	} else {
+		index := o.indexOn(cr)
+		if index == nil {
+			return
+		}
+		if indexCost(float64(o.stats.rowCount), o.isCovering(index)) >= o.stats.sortCost() {
+			return
+		}
+		isn := &indexScanNode{
+			plan:           sn.plan,
+			tableName:      sn.tableName,
+			rootPageNumber: sn.rootPageNumber,
+			cursorId:       sn.cursorId,
+			index:          *index,
+			columnCount:    o.columnCount,
+			covering:       o.isCovering(index),
+			desc:           on.terms[0].desc,
+		}
+		if isn.covering {
+			isn.index.cursorId = sn.cursorId
+		}
+		source = isn
+	}
+	pn.cursorId = on.sourceCursorId
+	var parent logicalNode = pn
+	if fn != nil {
+		fn.parent = pn
+		pn.setChildren(fn)
+		parent = fn
+	}
+	parent.setChildren(source)
+	switch s := source.(type) {
+	case *scanNode:
+		s.parent = parent
+	case *indexScanNode:
+		s.parent = parent
+	}
+}
+
+// canIndexOpt returns the index and value expression when predicate is an
+// indexed column equal to a constant.
+func (o *optimizer) canIndexOpt(predicate compiler.Expr) (*secondaryIndex, compiler.Expr) {
+	be, ok := predicate.(*compiler.BinaryExpr)
+	if !ok || be.Operator != compiler.OpEq {
+		return nil, nil
+	}
+	cr, ok := be.Left.(*compiler.ColumnRef)
+	valueExpr := be.Right
+	if !ok {
+		cr, ok = be.Right.(*compiler.ColumnRef)
+		valueExpr = be.Left
+	}
+	if !ok || !isConstant(valueExpr) {
+		return nil, nil
+	}
+	if index := o.indexOn(cr); index != nil {
+		return index, valueExpr
+	}
+	return nil, nil
+}
+
+// indexOn returns the secondary index on the column cr or nil when the column
+// is not indexed.
+func (o *optimizer) indexOn(cr *compiler.ColumnRef) *secondaryIndex {
+	for i := range o.indexes {
+		index := &o.indexes[i]
+		if !index.isPrimaryKey && !cr.IsPrimaryKey && index.colIdx == cr.ColIdx {
+			return index
+		}
+	}
+	return nil
+}
+
+// isCovering returns true when every column of the query is the primary key,
+// the indexed column of index or a column its entries include.
+func (o *optimizer) isCovering(index *secondaryIndex) bool {
+	for _, cr := range o.columns {
+		if cr.IsPrimaryKey {
+			continue
+		}
+		if !index.includesColumn(cr.ColIdx) {
+			return false
+		}
+	}
+	return true
+}
+
+// isConstant returns true when e has the same value for every row.
+func isConstant(e compiler.Expr) bool {
+	switch e.(type) {
+	case *compiler.IntLit, *compiler.FloatLit, *compiler.StringLit, *compiler.Variable:
+		return true
+	}
+	return false
+}
+
+// columnRefCollector collects the column references of the visited
+// expressions.
+type columnRefCollector struct {
+	columns []*compiler.ColumnRef
+}
+
+// collect adds the column references of each of exprs.
+func (c *columnRefCollector) collect(exprs ...compiler.Expr) {
+	for _, e := range exprs {
+		if e != nil {
+			e.BreadthWalk(c)
+		}
+	}
+}
+
+func (c *columnRefCollector) VisitColumnRefExpr(e *compiler.ColumnRef) {
+	c.columns = append(c.columns, e)
+}
+
+func (c *columnRefCollector) VisitBinaryExpr(e *compiler.BinaryExpr)     {}
+func (c *columnRefCollector) VisitUnaryExpr(e *compiler.UnaryExpr)       {}
+func (c *columnRefCollector) VisitIntLit(e *compiler.IntLit)             {}
+func (c *columnRefCollector) VisitFloatLit(e *compiler.FloatLit)         {}
+func (c *columnRefCollector) VisitStringLit(e *compiler.StringLit)       {}
+func (c *columnRefCollector) VisitNullLit(e *compiler.NullLit)           {}
+func (c *columnRefCollector) VisitVariable(e *compiler.Variable)         {}
+func (c *columnRefCollector) VisitCastExpr(e *compiler.CastExpr)         {}
+func (c *columnRefCollector) VisitFunctionExpr(e *compiler.FunctionExpr) {}
+
 func (*optimizer) canOpt(predicate compiler.Expr) compiler.Expr {
 	// The most basic optimization. Is the filter a primary key column ref equal
 	// to a constant of some sort.
@@ -3,9 +3,10 @@ package planner
 import (
 	"fmt"
 	"slices"
+	"strconv"
 	"strings"
-	"unicode/utf8"
 
+	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
 
@@ -31,11 +32,25 @@ type QueryPlan struct {
 	// constStrings is a mapping of constant string values to the registers that
 	// contain the value.
 	constStrings map[string]int
+	// constFloats is a mapping of constant real values to the registers that
+	// contain the value.
+	constFloats map[float64]int
 	// constVars is a mapping of a variable's position to the registers that
 	// holds the variable's value.
 	constVars map[int]int
+	// constNull is the register that holds NULL. It is 0 when no expression
+	// in the plan is NULL.
+	constNull int
+	// exprRegisters is a mapping of an expression key to the register holding
+	// the result of the expression for the current row. See exprKey.
+	exprRegisters map[string]int
 	// freeRegister is a counter containing the next free register in the plan.
 	freeRegister int
+	// tableCursors is a mapping of the tables of a join to the ids of their
+	// cursors. A column of a table in tableCursors is read from the cursor of
+	// the table rather than the cursor given to the generator. tableCursors is
+	// nil when the plan does not join tables.
+	tableCursors map[string]int
 	// transactionType defines what kind of transaction the plan will need.
 	transactionType transactionType
 }
@@ -51,7 +66,9 @@ func newQueryPlan(
 		commands:         []vm.Command{},
 		constInts:        make(map[int]int),
 		constStrings:     make(map[string]int),
+		constFloats:      make(map[float64]int),
 		constVars:        make(map[int]int),
+		exprRegisters:    make(map[string]int),
 		freeRegister:     1,
 		transactionType:  transactionType,
 	}
@@ -90,6 +107,18 @@ func (p *QueryPlan) declareConstString(s string) int {
 	return p.constStrings[s]
 }
 
+// declareConstFloat gets or sets a register with the const value and returns
+// the register. It is guaranteed the value will be in the register for the
+// duration of the plan.
+func (p *QueryPlan) declareConstFloat(f float64) int {
+	_, ok := p.constFloats[f]
+	if !ok {
+		p.constFloats[f] = p.freeRegister
+		p.freeRegister += 1
+	}
+	return p.constFloats[f]
+}
+
 // declareConstVar gets or sets a register with the const value and returns
 // the register. It is guaranteed the value will be in the register for the
 // duration of the plan.
@@ -102,6 +131,52 @@ func (p *QueryPlan) declareConstVar(position int) int {
 	return p.constVars[position]
 }
 
+// declareConstNull gets or sets a register with NULL and returns the register.
+// It is guaranteed the value will be in the register for the duration of the
+// plan.
+func (p *QueryPlan) declareConstNull() int {
+	if p.constNull == 0 {
+		p.constNull = p.freeRegister
+		p.freeRegister += 1
+	}
+	return p.constNull
+}
+
+// columnCursor returns the id of the cursor cr is read from given cursorId is
+// the cursor of the generator reading it.
+func (p *QueryPlan) columnCursor(cr *compiler.ColumnRef, cursorId int) int {
+	if c, ok := p.tableCursors[cr.Table]; ok {
+		return c
+	}
+	return cursorId
+}
+
+// exprRegister returns the register holding the result of an equivalent
+// expression that was already computed for the current row.
+func (p *QueryPlan) exprRegister(e compiler.Expr) (int, bool) {
+	key, ok := exprKey(e)
+	if !ok {
+		return 0, false
+	}
+	r, ok := p.exprRegisters[key]
+	return r, ok
+}
+
+// setExprRegister records r as holding the result of e for the current row so
+// repeated occurrences of e can reuse r instead of being computed again.
+func (p *QueryPlan) setExprRegister(e compiler.Expr, r int) {
+	if key, ok := exprKey(e); ok {
+		p.exprRegisters[key] = r
+	}
+}
+
+// resetExprRegisters forgets computed expressions. This must be called
+// whenever the row a cursor points to changes since the results would be
+// stale.
+func (p *QueryPlan) resetExprRegisters() {
+	clear(p.exprRegisters)
+}
+
 // compile sets byte code for the root node and it's children on commands.
 func (p *QueryPlan) compile() {
 	initCmd := &vm.InitCmd{}
@@ -114,7 +189,9 @@ func (p *QueryPlan) compile() {
 	// difficult to assert that a sequence of instructions appears.
 	p.pushConstantInts()
 	p.pushConstantStrings()
+	p.pushConstantFloats()
 	p.pushConstantVars()
+	p.pushConstantNull()
 	p.commands = append(p.commands, &vm.GotoCmd{P2: 1})
 }
 
@@ -163,6 +240,19 @@ func (p *QueryPlan) pushConstantStrings() {
 	}
 }
 
+func (p *QueryPlan) pushConstantFloats() {
+	temp := []*vm.FloatCmd{}
+	for f := range p.constFloats {
+		temp = append(temp, &vm.FloatCmd{P1: p.constFloats[f], P4: strconv.FormatFloat(f, 'g', -1, 64)})
+	}
+	slices.SortFunc(temp, func(a, b *vm.FloatCmd) int {
+		return a.P1 - b.P1
+	})
+	for i := range temp {
+		p.commands = append(p.commands, temp[i])
+	}
+}
+
 func (p *QueryPlan) pushConstantVars() {
 	temp := []*vm.VariableCmd{}
 	for v := range p.constVars {
@@ -176,6 +266,13 @@ func (p *QueryPlan) pushConstantVars() {
 	}
 }
 
+func (p *QueryPlan) pushConstantNull() {
+	if p.constNull == 0 {
+		return
+	}
+	p.commands = append(p.commands, &vm.NullCmd{P2: p.constNull})
+}
+
 // ToString evaluates and returns the query plan as a string representation.
 func (p *QueryPlan) ToString() string {
 	qp := &QueryPlan{}
@@ -222,32 +319,37 @@ func (p *QueryPlan) trimLeft() {
 // initial recursive walk is completed. connectSiblings goes over the string
 // representation in reverse row order and forwards column order. When a '└'
 // character is found connectSiblings moves upwards on the current column making
-// replacements until the top is reached. Once reached the column and row search
-// continue.
+// replacements until the parent of the node is reached. Once reached the column
+// and row search continue. Columns are counted in runes since the connecting
+// characters are more than one byte.
 func (p *QueryPlan) connectSiblings() string {
-	planMatrix := strings.Split(p.plan, "\n")
+	planMatrix := [][]rune{}
+	for _, row := range strings.Split(p.plan, "\n") {
+		planMatrix = append(planMatrix, []rune(row))
+	}
 	for rowIdx := len(planMatrix) - 1; 0 < rowIdx; rowIdx -= 1 {
-		row := planMatrix[rowIdx]
-		for charIdx, char := range row {
-			if char == '└' {
-				for backwardsRowIdx := rowIdx - 1; 0 < backwardsRowIdx; backwardsRowIdx -= 1 {
-					if len(planMatrix[backwardsRowIdx]) < charIdx {
-						continue
-					}
-					char, _ := utf8.DecodeRuneInString(planMatrix[backwardsRowIdx][charIdx:])
-					if char == ' ' {
-						out := []rune(planMatrix[backwardsRowIdx])
-						out[charIdx] = '|'
-						planMatrix[backwardsRowIdx] = string(out)
-					}
-					if char == '└' {
-						out := []rune(planMatrix[backwardsRowIdx])
-						out[charIdx] = '├'
-						planMatrix[backwardsRowIdx] = string(out)
-					}
+		for charIdx, char := range planMatrix[rowIdx] {
+			if char != '└' {
+				continue
+			}
+			for backwardsRowIdx := rowIdx - 1; 0 < backwardsRowIdx; backwardsRowIdx -= 1 {
+				row := planMatrix[backwardsRowIdx]
+				if len(row) <= charIdx {
+					break
+				}
+				if row[charIdx] == ' ' {
+					row[charIdx] = '|'
+				} else if row[charIdx] == '└' {
+					row[charIdx] = '├'
+				} else {
+					break
 				}
 			}
 		}
 	}
-	return strings.Join(planMatrix, "\n")
+	rows := []string{}
+	for _, row := range planMatrix {
+		rows = append(rows, string(row))
+	}
+	return strings.Join(rows, "\n")
 }
@@ -5,14 +5,11 @@ import "testing"
 func TestExplainQueryPlan(t *testing.T) {
 	root := &projectNode{
 		child: &joinNode{
-			operation: "join",
 			left: &joinNode{
-				operation: "join",
 				left: &scanNode{
 					tableName: "foo",
 				},
 				right: &joinNode{
-					operation: "join",
 					left: &scanNode{
 						tableName: "bar",
 					},
@@ -41,3 +38,37 @@ func TestExplainQueryPlan(t *testing.T) {
 		t.Fatalf("got\n%s\nwant\n%s", formattedResult, expectedResult)
 	}
 }
+
+func TestExplainQueryPlanSiblings(t *testing.T) {
+	root := &projectNode{
+		child: &joinNode{
+			left: &joinNode{
+				left: &scanNode{tableName: "foo"},
+				right: &joinNode{
+					left:  &scanNode{tableName: "bar"},
+					right: &scanNode{tableName: "baz"},
+				},
+			},
+			right: &joinNode{
+				left:  &scanNode{tableName: "qux"},
+				right: &scanNode{tableName: "quux"},
+			},
+		},
+	}
+	qp := newQueryPlan(root, true, transactionTypeRead)
+	formattedResult := qp.ToString()
+	expectedResult := "" +
+		" ── project\n" +
+		"     └─ join\n" +
+		"         ├─ join\n" +
+		"         |   ├─ scan table foo\n" +
+		"         |   └─ join\n" +
+		"         |       ├─ scan table bar\n" +
+		"         |       └─ scan table baz\n" +
+		"         └─ join\n" +
+		"             ├─ scan table qux\n" +
+		"             └─ scan table quux\n"
+	if formattedResult != expectedResult {
+		t.Fatalf("got\n%s\nwant\n%s", formattedResult, expectedResult)
+	}
+}
@@ -0,0 +1,91 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/vm"
+)
+
+// Pragma resolves the name of a pragma to a VirtualTable reporting its value.
+// When value is not nil the pragma is first set to value.
+type Pragma func(name string, value any) (*VirtualTable, error)
+
+// pragmaPlanner plans a pragma as a select of every column except the primary
+// key of the virtual table reported by the pragma. This means PRAGMA foo is the
+// same as SELECT the columns of pragma_foo().
+type pragmaPlanner struct {
+	catalog selectCatalog
+	stmt    *compiler.PragmaStmt
+	pragma  Pragma
+	// selectPlanner plans the select of the pragma once the pragma has been
+	// resolved.
+	selectPlanner *selectPlanner
+}
+
+// NewPragma returns an instance of a pragma planner for the given AST. pragma
+// resolves the value of the pragma.
+func NewPragma(catalog selectCatalog, stmt *compiler.PragmaStmt, pragma Pragma) *pragmaPlanner {
+	return &pragmaPlanner{
+		catalog: catalog,
+		stmt:    stmt,
+		pragma:  pragma,
+	}
+}
+
+// QueryPlan generates the query plan tree for the planner.
+func (p *pragmaPlanner) QueryPlan() (*QueryPlan, error) {
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.selectPlanner.QueryPlan()
+}
+
+// ExecutionPlan returns the bytecode execution plan for the planner.
+func (p *pragmaPlanner) ExecutionPlan() (*vm.ExecutionPlan, error) {
+	if err := p.resolve(); err != nil {
+		return nil, err
+	}
+	return p.selectPlanner.ExecutionPlan()
+}
+
+// resolve resolves the pragma and makes the select of its columns. The pragma
+// is only resolved once so the plan reports the value of the pragma at the
+// time it was planned.
+func (p *pragmaPlanner) resolve() error {
+	if p.selectPlanner != nil {
+		return nil
+	}
+	var value any
+	if p.stmt.Value != nil {
+		v, ok := literalValue(p.stmt.Value)
+		if !ok {
+			return fmt.Errorf("pragma %s must be set to a literal", p.stmt.Name)
+		}
+		value = v.Any()
+	}
+	table, err := p.pragma(p.stmt.Name, value)
+	if err != nil {
+		return err
+	}
+	resultColumns := []compiler.ResultColumn{}
+	for _, column := range table.Columns[1:] {
+		resultColumns = append(resultColumns, compiler.ResultColumn{
+			Expression: &compiler.ColumnRef{Column: column},
+		})
+	}
+	stmt := &compiler.SelectStmt{
+		StmtBase: p.stmt.StmtBase,
+		From: &compiler.From{
+			TableName: "pragma_" + p.stmt.Name,
+			Args:      []compiler.Expr{},
+		},
+		ResultColumns: resultColumns,
+	}
+	p.selectPlanner = NewSelect(p.catalog, stmt).WithTableFunction(
+		func(string, []any) (*VirtualTable, error) {
+			return table, nil
+		},
+	)
+	return nil
+}
@@ -1,19 +1,30 @@
 package planner
 
 import (
+	"maps"
+
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
 
 // generatePredicate generates code to make a boolean jump for the given
-// expression within the plan context. The function returns the jump command to
-// lazily set the jump address.
+// expression within the plan context. The jump is taken when the expression is
+// not true. The function returns the jump command to lazily set the jump
+// address.
 func generatePredicate(plan *QueryPlan, expression compiler.Expr, cursorId int) vm.JumpCommand {
 	pg := &predicateGenerator{}
 	pg.plan = plan
 	pg.cursorId = cursorId
-	pg.build(expression, 0)
-	return pg.jumpCommand
+	return pg.condition(expression)
+}
+
+// jumpCommands is a set of jumps to the same address.
+type jumpCommands []vm.JumpCommand
+
+func (j jumpCommands) SetJumpAddress(address int) {
+	for _, jc := range j {
+		jc.SetJumpAddress(address)
+	}
 }
 
 // predicateGenerator builds commands to calculate the boolean result of an
@@ -29,9 +40,42 @@ type predicateGenerator struct {
 	cursorId int
 }
 
+// condition generates the jumps taken when e is not true. AND and OR are short
+// circuited so the right operand is only evaluated when the left operand does
+// not decide the condition.
+func (p *predicateGenerator) condition(e compiler.Expr) jumpCommands {
+	be, ok := e.(*compiler.BinaryExpr)
+	if !ok || (be.Operator != compiler.OpAnd && be.Operator != compiler.OpOr) {
+		p.build(e, 0)
+		return jumpCommands{p.jumpCommand}
+	}
+	if be.Operator == compiler.OpAnd {
+		return append(p.condition(be.Left), p.condition(be.Right)...)
+	}
+	// Expressions computed within an OR are not computed when it short
+	// circuits so they are forgotten once the OR is generated.
+	exprRegisters := maps.Clone(p.plan.exprRegisters)
+	defer func() { p.plan.exprRegisters = exprRegisters }()
+	leftFalse := p.condition(be.Left)
+	leftTrue := &vm.GotoCmd{}
+	p.plan.commands = append(p.plan.commands, leftTrue)
+	leftFalse.SetJumpAddress(len(p.plan.commands))
+	rightFalse := p.condition(be.Right)
+	leftTrue.P2 = len(p.plan.commands)
+	return rightFalse
+}
+
 func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 	switch ce := e.(type) {
 	case *compiler.BinaryExpr:
+		if cr, ok := p.plan.exprRegister(ce); ok {
+			if level == 0 {
+				jc := &vm.IfNotCmd{P1: cr}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+			}
+			return cr, nil
+		}
 		ol, err := p.build(ce.Left, level+1)
 		if err != nil {
 			return 0, err
@@ -41,6 +85,10 @@ func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 			return 0, err
 		}
 		r := p.getNextRegister()
+		if level != 0 {
+			// At the top level r may not be filled since comparisons only jump.
+			p.plan.setExprRegister(ce, r)
+		}
 		switch ce.Operator {
 		case compiler.OpAdd:
 			p.plan.commands = append(
@@ -97,57 +145,141 @@ func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 				p.plan.commands = append(p.plan.commands, jc)
 			}
 			return r, nil
+		case compiler.OpLike:
+			p.plan.commands = append(
+				p.plan.commands,
+				&vm.LikeCmd{P1: ol, P2: or, P3: r},
+			)
+			if level == 0 {
+				jc := &vm.IfNotCmd{P1: r}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+			}
+			return r, nil
+		case compiler.OpMod, compiler.OpConcat, compiler.OpBitAnd, compiler.OpBitOr,
+			compiler.OpShiftLeft, compiler.OpShiftRight:
+			generateOperator(p.plan, ce.Operator, ol, or, r)
+			if level == 0 {
+				jc := &vm.IfNotCmd{P1: r}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+			}
+			return r, nil
+		case compiler.OpAnd, compiler.OpOr:
+			generateLogical(p.plan, ce.Operator, ol, or, r)
+			if level == 0 {
+				jc := &vm.IfNotCmd{P1: r}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+			}
+			return r, nil
 		case compiler.OpEq:
 			if level == 0 {
-				jc := &vm.NotEqualCmd{P1: ol, P3: or}
+				jc := &vm.NotEqualCmd{P1: ol, P3: or, P5: vm.JumpIfNull}
 				p.jumpCommand = jc
 				p.plan.commands = append(p.plan.commands, jc)
 				return 0, nil
 			}
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(p.plan.commands) + jumpOverCount
-			p.plan.commands = append(
-				p.plan.commands,
-				&vm.NotEqualCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
+			generateComparison(p.plan, ce.Operator, ol, or, r)
 			return r, nil
 		case compiler.OpLt:
 			if level == 0 {
-				jc := &vm.LteCmd{P1: or, P3: ol}
+				jc := &vm.LteCmd{P1: or, P3: ol, P5: vm.JumpIfNull}
 				p.jumpCommand = jc
 				p.plan.commands = append(p.plan.commands, jc)
 				return 0, nil
 			}
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(p.plan.commands) + jumpOverCount
-			p.plan.commands = append(
-				p.plan.commands,
-				&vm.GteCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
+			generateComparison(p.plan, ce.Operator, ol, or, r)
 			return r, nil
 		case compiler.OpGt:
 			if level == 0 {
-				jc := &vm.GteCmd{P1: or, P3: ol}
+				jc := &vm.GteCmd{P1: or, P3: ol, P5: vm.JumpIfNull}
 				p.jumpCommand = jc
 				p.plan.commands = append(p.plan.commands, jc)
 				return 0, nil
 			}
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(p.plan.commands) + jumpOverCount
-			p.plan.commands = append(
-				p.plan.commands,
-				&vm.LteCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			p.plan.commands = append(p.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
+			generateComparison(p.plan, ce.Operator, ol, or, r)
+			return r, nil
+		case compiler.OpNe:
+			if level == 0 {
+				jc := &vm.EqCmd{P1: ol, P3: or, P5: vm.JumpIfNull}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+				return 0, nil
+			}
+			generateComparison(p.plan, ce.Operator, ol, or, r)
+			return r, nil
+		case compiler.OpLte:
+			if level == 0 {
+				jc := &vm.LtCmd{P1: or, P3: ol, P5: vm.JumpIfNull}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+				return 0, nil
+			}
+			generateComparison(p.plan, ce.Operator, ol, or, r)
+			return r, nil
+		case compiler.OpGte:
+			if level == 0 {
+				jc := &vm.GtCmd{P1: or, P3: ol, P5: vm.JumpIfNull}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+				return 0, nil
+			}
+			generateComparison(p.plan, ce.Operator, ol, or, r)
 			return r, nil
 		default:
 			panic("no vm command for operator")
 		}
+	case *compiler.UnaryExpr:
+		if cr, ok := p.plan.exprRegister(ce); ok {
+			if level == 0 {
+				jc := &vm.IfNotCmd{P1: cr}
+				p.jumpCommand = jc
+				p.plan.commands = append(p.plan.commands, jc)
+			}
+			return cr, nil
+		}
+		o, err := p.build(ce.Operand, level+1)
+		if err != nil {
+			return 0, err
+		}
+		if level == 0 && (ce.Operator == compiler.OpIsNull || ce.Operator == compiler.OpNotNull) {
+			// The predicate fails when the null test is false so the jump is
+			// the opposite test.
+			var jc interface {
+				vm.Command
+				vm.JumpCommand
+			}
+			switch ce.Operator {
+			case compiler.OpIsNull:
+				jc = &vm.NotNullCmd{P1: o}
+			case compiler.OpNotNull:
+				jc = &vm.IsNullCmd{P1: o}
+			default:
+				panic("no vm command for operator")
+			}
+			p.jumpCommand = jc
+			p.plan.commands = append(p.plan.commands, jc)
+			return 0, nil
+		}
+		r := p.getNextRegister()
+		p.plan.setExprRegister(ce, r)
+		generateUnary(p.plan, ce.Operator, o, r)
+		if level == 0 {
+			jc := &vm.IfNotCmd{P1: r}
+			p.jumpCommand = jc
+			p.plan.commands = append(p.plan.commands, jc)
+		}
+		return r, nil
+	case *compiler.FunctionExpr, *compiler.CastExpr:
+		r := p.getNextRegister()
+		generateExpressionTo(p.plan, ce, r, p.cursorId)
+		if level == 0 {
+			jc := &vm.IfNotCmd{P1: r}
+			p.jumpCommand = jc
+			p.plan.commands = append(p.plan.commands, jc)
+		}
+		return r, nil
 	case *compiler.ColumnRef:
 		colRefReg := p.valueRegisterFor(ce)
 		if level == 0 {
@@ -164,6 +296,14 @@ func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 			p.plan.commands = append(p.plan.commands, jc)
 		}
 		return cir, nil
+	case *compiler.FloatLit:
+		cfr := p.plan.declareConstFloat(ce.Value)
+		if level == 0 {
+			jc := &vm.IfNotCmd{P1: cfr}
+			p.jumpCommand = jc
+			p.plan.commands = append(p.plan.commands, jc)
+		}
+		return cfr, nil
 	case *compiler.StringLit:
 		csr := p.plan.declareConstString(ce.Value)
 		if level == 0 {
@@ -172,6 +312,14 @@ func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 			p.plan.commands = append(p.plan.commands, jc)
 		}
 		return csr, nil
+	case *compiler.NullLit:
+		cnr := p.plan.declareConstNull()
+		if level == 0 {
+			jc := &vm.IfNotCmd{P1: cnr}
+			p.jumpCommand = jc
+			p.plan.commands = append(p.plan.commands, jc)
+		}
+		return cnr, nil
 	case *compiler.Variable:
 		cvr := p.plan.declareConstVar(ce.Position)
 		if level == 0 {
@@ -185,17 +333,18 @@ func (p *predicateGenerator) build(e compiler.Expr, level int) (int, error) {
 }
 
 func (p *predicateGenerator) valueRegisterFor(ce *compiler.ColumnRef) int {
+	cursorId := p.plan.columnCursor(ce, p.cursorId)
 	if ce.IsPrimaryKey {
 		r := p.getNextRegister()
 		p.plan.commands = append(p.plan.commands, &vm.RowIdCmd{
-			P1: p.cursorId,
+			P1: cursorId,
 			P2: r,
 		})
 		return r
 	}
 	r := p.getNextRegister()
 	p.plan.commands = append(p.plan.commands, &vm.ColumnCmd{
-		P1: p.cursorId,
+		P1: cursorId,
 		P2: ce.ColIdx, P3: r,
 	})
 	return r
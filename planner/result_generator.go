@@ -1,6 +1,8 @@
 package planner
 
 import (
+	"maps"
+
 	"github.com/chirst/cdb/compiler"
 	"github.com/chirst/cdb/vm"
 )
@@ -29,9 +31,20 @@ type resultExprGenerator struct {
 func (e *resultExprGenerator) build(root compiler.Expr, level int) int {
 	switch n := root.(type) {
 	case *compiler.BinaryExpr:
+		if cr, ok := e.plan.exprRegister(n); ok {
+			if level == 0 {
+				e.plan.commands = append(
+					e.plan.commands,
+					&vm.CopyCmd{P1: cr, P2: e.outputRegister},
+				)
+				return e.outputRegister
+			}
+			return cr
+		}
 		ol := e.build(n.Left, level+1)
 		or := e.build(n.Right, level+1)
 		r := e.getNextRegister(level)
+		e.plan.setExprRegister(n, r)
 		switch n.Operator {
 		case compiler.OpAdd:
 			e.plan.commands = append(e.plan.commands, &vm.AddCmd{P1: ol, P2: or, P3: r})
@@ -43,48 +56,131 @@ func (e *resultExprGenerator) build(root compiler.Expr, level int) int {
 			e.plan.commands = append(e.plan.commands, &vm.ExponentCmd{P1: ol, P2: or, P3: r})
 		case compiler.OpSub:
 			e.plan.commands = append(e.plan.commands, &vm.SubtractCmd{P1: ol, P2: or, P3: r})
-		case compiler.OpEq:
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(e.plan.commands) + jumpOverCount
-			e.plan.commands = append(
-				e.plan.commands,
-				&vm.NotEqualCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
-		case compiler.OpLt:
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(e.plan.commands) + jumpOverCount
-			e.plan.commands = append(
-				e.plan.commands,
-				&vm.GteCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
-		case compiler.OpGt:
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 0, P2: r})
-			jumpOverCount := 2
-			jumpAddress := len(e.plan.commands) + jumpOverCount
-			e.plan.commands = append(
-				e.plan.commands,
-				&vm.LteCmd{P1: ol, P2: jumpAddress, P3: or},
-			)
-			e.plan.commands = append(e.plan.commands, &vm.IntegerCmd{P1: 1, P2: r})
+		case compiler.OpEq, compiler.OpNe, compiler.OpLt, compiler.OpGt, compiler.OpLte,
+			compiler.OpGte:
+			generateComparison(e.plan, n.Operator, ol, or, r)
+		case compiler.OpAnd, compiler.OpOr:
+			generateLogical(e.plan, n.Operator, ol, or, r)
+		case compiler.OpLike:
+			e.plan.commands = append(e.plan.commands, &vm.LikeCmd{P1: ol, P2: or, P3: r})
+		case compiler.OpMod, compiler.OpConcat, compiler.OpBitAnd, compiler.OpBitOr,
+			compiler.OpShiftLeft, compiler.OpShiftRight:
+			generateOperator(e.plan, n.Operator, ol, or, r)
 		default:
 			panic("no vm command for operator")
 		}
 		return r
+	case *compiler.UnaryExpr:
+		if cr, ok := e.plan.exprRegister(n); ok {
+			if level == 0 {
+				e.plan.commands = append(
+					e.plan.commands,
+					&vm.CopyCmd{P1: cr, P2: e.outputRegister},
+				)
+				return e.outputRegister
+			}
+			return cr
+		}
+		o := e.build(n.Operand, level+1)
+		r := e.getNextRegister(level)
+		e.plan.setExprRegister(n, r)
+		generateUnary(e.plan, n.Operator, o, r)
+		return r
+	case *compiler.FunctionExpr:
+		if cr, ok := e.plan.exprRegister(n); ok {
+			if level == 0 {
+				e.plan.commands = append(
+					e.plan.commands,
+					&vm.CopyCmd{P1: cr, P2: e.outputRegister},
+				)
+				return e.outputRegister
+			}
+			return cr
+		}
+		if n.FnType == compiler.FnCoalesce || n.FnType == compiler.FnIfnull {
+			return e.buildCoalesce(n, level)
+		}
+		args := []int{}
+		argStart := 0
+		if _, ok := scalarFunctionArgs[n.FnType]; ok {
+			for _, arg := range n.Args {
+				args = append(args, e.build(arg, level+1))
+			}
+		} else {
+			// FunctionCmd takes its arguments from consecutive registers.
+			argStart = e.plan.freeRegister
+			e.plan.freeRegister += len(n.Args)
+			for i, arg := range n.Args {
+				generateExpressionTo(e.plan, arg, argStart+i, e.cursorId)
+			}
+		}
+		r := e.getNextRegister(level)
+		e.plan.setExprRegister(n, r)
+		switch n.FnType {
+		case compiler.FnTypeof:
+			e.plan.commands = append(e.plan.commands, &vm.TypeofCmd{P1: args[0], P2: r})
+		case compiler.FnCurrentTimestamp:
+			e.plan.commands = append(e.plan.commands, &vm.CurrentTimeCmd{P1: r, P4: vm.TimestampLayout})
+		case compiler.FnCurrentDate:
+			e.plan.commands = append(e.plan.commands, &vm.CurrentTimeCmd{P1: r, P4: vm.DateLayout})
+		default:
+			e.plan.commands = append(e.plan.commands, &vm.FunctionCmd{
+				P1: argStart,
+				P2: len(n.Args),
+				P3: r,
+				P4: n.FnType,
+			})
+		}
+		return r
+	case *compiler.CastExpr:
+		if cr, ok := e.plan.exprRegister(n); ok {
+			if level == 0 {
+				e.plan.commands = append(
+					e.plan.commands,
+					&vm.CopyCmd{P1: cr, P2: e.outputRegister},
+				)
+				return e.outputRegister
+			}
+			return cr
+		}
+		o := e.build(n.Expr, level+1)
+		r := e.getNextRegister(level)
+		e.plan.setExprRegister(n, r)
+		e.plan.commands = append(e.plan.commands, &vm.CastCmd{P1: o, P2: r, P4: n.TypeName})
+		return r
 	case *compiler.ColumnRef:
 		r := e.getNextRegister(level)
+		cursorId := e.plan.columnCursor(n, e.cursorId)
 		if n.IsPrimaryKey {
-			e.plan.commands = append(e.plan.commands, &vm.RowIdCmd{P1: e.cursorId, P2: r})
+			e.plan.commands = append(e.plan.commands, &vm.RowIdCmd{P1: cursorId, P2: r})
 		} else {
 			e.plan.commands = append(
 				e.plan.commands,
-				&vm.ColumnCmd{P1: e.cursorId, P2: n.ColIdx, P3: r},
+				&vm.ColumnCmd{P1: cursorId, P2: n.ColIdx, P3: r},
 			)
 		}
 		return r
+	case *excludedColumn:
+		// The register of each excluded column is set before the expressions
+		// referencing them are generated.
+		xr, ok := e.plan.exprRegister(n)
+		if !ok {
+			panic("no register for excluded column")
+		}
+		if level == 0 {
+			e.plan.commands = append(
+				e.plan.commands,
+				&vm.CopyCmd{P1: xr, P2: e.outputRegister},
+			)
+		}
+		return xr
+	case *groupColumn:
+		r := e.getNextRegister(level)
+		e.plan.commands = append(
+			e.plan.commands,
+			&vm.ColumnCmd{P1: e.cursorId, P2: n.colIdx, P3: r},
+		)
+		return r
 	case *compiler.IntLit:
 		cir := e.plan.declareConstInt(n.Value)
 		if level == 0 {
@@ -94,6 +190,15 @@ func (e *resultExprGenerator) build(root compiler.Expr, level int) int {
 			)
 		}
 		return cir
+	case *compiler.FloatLit:
+		cfr := e.plan.declareConstFloat(n.Value)
+		if level == 0 {
+			e.plan.commands = append(
+				e.plan.commands,
+				&vm.CopyCmd{P1: cfr, P2: e.outputRegister},
+			)
+		}
+		return cfr
 	case *compiler.StringLit:
 		csr := e.plan.declareConstString(n.Value)
 		if level == 0 {
@@ -103,6 +208,15 @@ func (e *resultExprGenerator) build(root compiler.Expr, level int) int {
 			)
 		}
 		return csr
+	case *compiler.NullLit:
+		cnr := e.plan.declareConstNull()
+		if level == 0 {
+			e.plan.commands = append(
+				e.plan.commands,
+				&vm.CopyCmd{P1: cnr, P2: e.outputRegister},
+			)
+		}
+		return cnr
 	case *compiler.Variable:
 		cvr := e.plan.declareConstVar(n.Position)
 		if level == 0 {
@@ -116,6 +230,31 @@ func (e *resultExprGenerator) build(root compiler.Expr, level int) int {
 	panic("unhandled expression in expr command builder")
 }
 
+// buildCoalesce computes the arguments of COALESCE or IFNULL in order into the
+// result register and stops at the first argument that is not NULL.
+func (e *resultExprGenerator) buildCoalesce(n *compiler.FunctionExpr, level int) int {
+	r := e.getNextRegister(level)
+	// Arguments are not computed once an earlier argument is not NULL and
+	// each argument overwrites the result register so expressions computed
+	// within the function are forgotten once it is generated.
+	exprRegisters := maps.Clone(e.plan.exprRegisters)
+	notNulls := []*vm.NotNullCmd{}
+	for i, arg := range n.Args {
+		generateExpressionTo(e.plan, arg, r, e.cursorId)
+		if i < len(n.Args)-1 {
+			notNull := &vm.NotNullCmd{P1: r}
+			e.plan.commands = append(e.plan.commands, notNull)
+			notNulls = append(notNulls, notNull)
+		}
+	}
+	for _, notNull := range notNulls {
+		notNull.P2 = len(e.plan.commands)
+	}
+	e.plan.exprRegisters = exprRegisters
+	e.plan.setExprRegister(n, r)
+	return r
+}
+
 func (e *resultExprGenerator) getNextRegister(level int) int {
 	if level == 0 {
 		return e.outputRegister
@@ -124,3 +263,120 @@ func (e *resultExprGenerator) getNextRegister(level int) int {
 	e.plan.freeRegister += 1
 	return r
 }
+
+// generateComparison appends commands storing the result of comparing register
+// ol to register or with operator in register r. The result is 1 when the
+// comparison is true and 0 when it is false. Comparing NULL to any value is
+// unknown so the result is NULL when either operand is NULL.
+func generateComparison(plan *QueryPlan, operator string, ol, or, r int) {
+	// falseCmd jumps when the comparison is false.
+	var falseCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	switch operator {
+	case compiler.OpEq:
+		falseCmd = &vm.NotEqualCmd{P1: ol, P3: or}
+	case compiler.OpLt:
+		falseCmd = &vm.GteCmd{P1: ol, P3: or}
+	case compiler.OpGt:
+		falseCmd = &vm.LteCmd{P1: ol, P3: or}
+	case compiler.OpNe:
+		falseCmd = &vm.EqCmd{P1: ol, P3: or}
+	case compiler.OpLte:
+		falseCmd = &vm.GtCmd{P1: ol, P3: or}
+	case compiler.OpGte:
+		falseCmd = &vm.LtCmd{P1: ol, P3: or}
+	default:
+		panic("no vm command for comparison")
+	}
+	leftNull := &vm.IsNullCmd{P1: ol}
+	rightNull := &vm.IsNullCmd{P1: or}
+	plan.commands = append(
+		plan.commands,
+		&vm.NullCmd{P2: r},
+		leftNull,
+		rightNull,
+		&vm.IntegerCmd{P1: 0, P2: r},
+		falseCmd,
+		&vm.IntegerCmd{P1: 1, P2: r},
+	)
+	endAddress := len(plan.commands)
+	leftNull.SetJumpAddress(endAddress)
+	rightNull.SetJumpAddress(endAddress)
+	falseCmd.SetJumpAddress(endAddress)
+}
+
+// generateLogical appends the command storing the three valued result of the
+// AND or OR operator applied to registers ol and or in register r.
+func generateLogical(plan *QueryPlan, operator string, ol, or, r int) {
+	switch operator {
+	case compiler.OpAnd:
+		plan.commands = append(plan.commands, &vm.AndCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpOr:
+		plan.commands = append(plan.commands, &vm.OrCmd{P1: ol, P2: or, P3: r})
+	default:
+		panic("no vm command for operator")
+	}
+}
+
+// generateOperator appends the command storing the result of applying operator
+// to register ol and register or in register r.
+func generateOperator(plan *QueryPlan, operator string, ol, or, r int) {
+	switch operator {
+	case compiler.OpMod:
+		plan.commands = append(plan.commands, &vm.RemainderCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpConcat:
+		plan.commands = append(plan.commands, &vm.ConcatCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpBitAnd:
+		plan.commands = append(plan.commands, &vm.BitAndCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpBitOr:
+		plan.commands = append(plan.commands, &vm.BitOrCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpShiftLeft:
+		plan.commands = append(plan.commands, &vm.ShiftLeftCmd{P1: ol, P2: or, P3: r})
+	case compiler.OpShiftRight:
+		plan.commands = append(plan.commands, &vm.ShiftRightCmd{P1: ol, P2: or, P3: r})
+	default:
+		panic("no vm command for operator")
+	}
+}
+
+// generateUnary appends commands storing the result of the unary operator
+// applied to register o in register r.
+func generateUnary(plan *QueryPlan, operator string, o, r int) {
+	switch operator {
+	case compiler.OpNot:
+		plan.commands = append(plan.commands, &vm.NotCmd{P1: o, P2: r})
+	case compiler.OpNeg:
+		plan.commands = append(plan.commands, &vm.NegCmd{P1: o, P2: r})
+	case compiler.OpBitNot:
+		plan.commands = append(plan.commands, &vm.BitNotCmd{P1: o, P2: r})
+	default:
+		generateNullTest(plan, operator, o, r)
+	}
+}
+
+// generateNullTest appends commands storing the result of the IS NULL or IS NOT
+// NULL operator applied to register o in register r. Unlike a comparison the
+// result is never NULL.
+func generateNullTest(plan *QueryPlan, operator string, o, r int) {
+	var trueCmd interface {
+		vm.Command
+		vm.JumpCommand
+	}
+	switch operator {
+	case compiler.OpIsNull:
+		trueCmd = &vm.IsNullCmd{P1: o}
+	case compiler.OpNotNull:
+		trueCmd = &vm.NotNullCmd{P1: o}
+	default:
+		panic("no vm command for operator")
+	}
+	plan.commands = append(
+		plan.commands,
+		&vm.IntegerCmd{P1: 1, P2: r},
+		trueCmd,
+		&vm.IntegerCmd{P1: 0, P2: r},
+	)
+	trueCmd.SetJumpAddress(len(plan.commands))
+}
@@ -0,0 +1,74 @@
+package planner
+
+import (
+	"fmt"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+)
+
+// mainSchemaName is the name of the only schema. Names may be qualified with it
+// for example main.foo.id.
+const mainSchemaName = "main"
+
+// checkSchemaName returns errSchemaNotExist when name is not a known schema. An
+// empty name is unqualified and always valid.
+func checkSchemaName(name string) error {
+	if name == "" || name == mainSchemaName {
+		return nil
+	}
+	return fmt.Errorf("%w: %s", errSchemaNotExist, name)
+}
+
+// checkTableWritable returns errTableReadOnly when tableName is cdb_schema. Its
+// rows are only changed by statements such as CREATE TABLE since a row changed
+// any other way would no longer describe the object it is for.
+func checkTableWritable(tableName string) error {
+	if tableName == catalog.SchemaTableName {
+		return fmt.Errorf("%w: %s", errTableReadOnly, tableName)
+	}
+	return nil
+}
+
+// isSystemTable returns true when tableName is one of the tables cdb keeps for
+// itself rather than a table created by CREATE TABLE.
+func isSystemTable(tableName string) bool {
+	switch tableName {
+	case catalog.SchemaTableName, catalog.SequenceTableName, catalog.StatTableName:
+		return true
+	}
+	return false
+}
+
+// schemaExprVisitor checks column references are only qualified with known
+// schemas.
+type schemaExprVisitor struct {
+	err error
+}
+
+// checkExprSchemas returns an error for the first column reference in e that is
+// qualified with an unknown schema.
+func checkExprSchemas(e compiler.Expr) error {
+	if e == nil {
+		return nil
+	}
+	v := &schemaExprVisitor{}
+	e.BreadthWalk(v)
+	return v.err
+}
+
+func (s *schemaExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef) {
+	if s.err == nil {
+		s.err = checkSchemaName(e.Schema)
+	}
+}
+
+func (s *schemaExprVisitor) VisitBinaryExpr(e *compiler.BinaryExpr)     {}
+func (s *schemaExprVisitor) VisitUnaryExpr(e *compiler.UnaryExpr)       {}
+func (s *schemaExprVisitor) VisitIntLit(e *compiler.IntLit)             {}
+func (s *schemaExprVisitor) VisitFloatLit(e *compiler.FloatLit)         {}
+func (s *schemaExprVisitor) VisitStringLit(e *compiler.StringLit)       {}
+func (s *schemaExprVisitor) VisitNullLit(e *compiler.NullLit)           {}
+func (s *schemaExprVisitor) VisitVariable(e *compiler.Variable)         {}
+func (s *schemaExprVisitor) VisitCastExpr(e *compiler.CastExpr)         {}
+func (s *schemaExprVisitor) VisitFunctionExpr(e *compiler.FunctionExpr) {}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"math"
+	"slices"
 
 	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/compiler"
@@ -16,7 +17,10 @@ type selectCatalog interface {
 	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
 	GetRootPageNumber(tableOrIndexName string) (int, error)
 	GetVersion() string
+	GetGeneration(tableName string) int
 	GetPrimaryKeyColumn(tableName string) (string, error)
+	GetIndexes(tableName string) []catalog.Index
+	statCatalog
 }
 
 // selectPlanner is capable of generating a logical query plan and a physical
@@ -31,18 +35,95 @@ type selectPlanner struct {
 	// executionPlan contains the execution plan for the vm. This is built by
 	// calling ExecutionPlan.
 	executionPlan *vm.ExecutionPlan
+	// tableFunction resolves table valued functions in the FROM clause. Table
+	// valued functions are an error when tableFunction is nil.
+	tableFunction TableFunction
+	// materializer computes the rows of the common table expressions named in
+	// the FROM clause. They are an error when materializer is nil.
+	materializer Materializer
+	// tables are the tables of the FROM clause in the order they are joined.
+	// They are set by resolveTables.
+	tables []*fromTable
+}
+
+// fromTable is a table of the FROM clause of a select.
+type fromTable struct {
+	// tableName is the name of the table, table valued function or common
+	// table expression.
+	tableName string
+	// args are the arguments of a table valued function. args is nil when
+	// tableName is not a function.
+	args []compiler.Expr
+	// rootPageNumber is the page number of the table when it is a b tree.
+	rootPageNumber int
+	// virtualTable is the table when it is not a b tree.
+	virtualTable *VirtualTable
+	// cursorId is the id of the cursor reading the table.
+	cursorId int
+	// isLeft and on are the join of the table to the tables preceding it. See
+	// compiler.Join.
+	isLeft bool
+	on     compiler.Expr
 }
 
 // NewSelect returns an instance of a select planner for the given AST.
 func NewSelect(catalog selectCatalog, stmt *compiler.SelectStmt) *selectPlanner {
+	executionPlan := vm.NewExecutionPlan(catalog.GetVersion(), stmt.Explain)
+	// Table valued functions and common table expressions are resolved while
+	// planning so their plans are left to depend on the version of the whole
+	// catalog.
+	joins := fromJoins(stmt)
+	isStored := !slices.ContainsFunc(joins, func(join compiler.Join) bool {
+		return join.Args != nil || isCommonTable(stmt, join)
+	})
+	if isStored {
+		for _, join := range joins {
+			executionPlan.DependOn(join.TableName, catalog.GetGeneration(join.TableName))
+		}
+	}
 	return &selectPlanner{
-		catalog: catalog,
-		stmt:    stmt,
-		executionPlan: vm.NewExecutionPlan(
-			catalog.GetVersion(),
-			stmt.Explain,
-		),
+		catalog:       catalog,
+		stmt:          stmt,
+		executionPlan: executionPlan,
+	}
+}
+
+// WithTableFunction sets the resolver for table valued functions such as
+// explain('SELECT 1') used in the FROM clause.
+func (p *selectPlanner) WithTableFunction(f TableFunction) *selectPlanner {
+	p.tableFunction = f
+	return p
+}
+
+// WithMaterializer sets the function computing the rows of common table
+// expressions such as foo in WITH foo AS (SELECT 1) SELECT * FROM foo.
+func (p *selectPlanner) WithMaterializer(f Materializer) *selectPlanner {
+	p.materializer = f
+	return p
+}
+
+// fromJoins returns the tables of the FROM clause of stmt in the order they are
+// joined. The first table is a join to nothing.
+func fromJoins(stmt *compiler.SelectStmt) []compiler.Join {
+	if stmt.From == nil {
+		return nil
+	}
+	first := compiler.Join{
+		SchemaName: stmt.From.SchemaName,
+		TableName:  stmt.From.TableName,
+		Args:       stmt.From.Args,
 	}
+	return append([]compiler.Join{first}, stmt.From.Joins...)
+}
+
+// isCommonTable returns true when join names one of the common table
+// expressions of stmt rather than a table.
+func isCommonTable(stmt *compiler.SelectStmt, join compiler.Join) bool {
+	if join.Args != nil || join.SchemaName != "" {
+		return false
+	}
+	_, ok := commonTable(stmt, join.TableName)
+	return ok
 }
 
 // QueryPlan generates the query plan tree for the planner.
@@ -52,16 +133,19 @@ func (p *selectPlanner) QueryPlan() (*QueryPlan, error) {
 		return nil, err
 	}
 
+	if err := p.resolveTables(); err != nil {
+		return nil, err
+	}
 	var tableName string
 	var rootPageNumber int
-	if p.stmt.From != nil {
-		tableName = p.stmt.From.TableName
+	var virtualTable *VirtualTable
+	if len(p.tables) != 0 {
+		tableName = p.tables[0].tableName
+		rootPageNumber = p.tables[0].rootPageNumber
+		virtualTable = p.tables[0].virtualTable
 	}
-	if tableName != "" {
-		rootPageNumber, err = p.catalog.GetRootPageNumber(tableName)
-		if err != nil {
-			return nil, errTableNotExist
-		}
+	if err := p.bindJoins(); err != nil {
+		return nil, err
 	}
 
 	projections, err := p.getProjections()
@@ -69,28 +153,22 @@ func (p *selectPlanner) QueryPlan() (*QueryPlan, error) {
 		return nil, err
 	}
 	for i := range projections {
-		cev := &catalogExprVisitor{}
-		cev.Init(p.catalog, tableName)
-		projections[i].expr.BreadthWalk(cev)
-	}
-
-	hasFunc := false
-	for i := range projections {
-		_, ok := projections[i].expr.(*compiler.FunctionExpr)
-		if ok {
-			hasFunc = true
+		if err := checkExprSchemas(projections[i].expr); err != nil {
+			return nil, err
 		}
-	}
-	if hasFunc {
-		if len(projections) != 1 {
-			return nil, errors.New("only one projection allowed for COUNT")
+		if err := checkExprFunctions(projections[i].expr); err != nil {
+			return nil, err
 		}
-		if tableName == "" {
-			return nil, errors.New("must have from for COUNT")
+		if err := p.bindExpr(projections[i].expr); err != nil {
+			return nil, err
 		}
+	}
+
+	if p.isCountTable(projections, tableName) {
 		cn := &countNode{
 			projection:     projections[0],
 			rootPageNumber: rootPageNumber,
+			virtualTable:   virtualTable,
 			tableName:      tableName,
 			cursorId:       1,
 		}
@@ -104,8 +182,65 @@ func (p *selectPlanner) QueryPlan() (*QueryPlan, error) {
 		return plan, nil
 	}
 
+	groupBy, err := p.getGroupBy(projections)
+	if err != nil {
+		return nil, err
+	}
+	orderingTerms, err := p.getOrderingTerms(projections)
+	if err != nil {
+		return nil, err
+	}
+	// referenced holds the columns of the query before aggregates are
+	// rewritten to read from the group table.
+	referenced := &columnRefCollector{}
+	for i := range projections {
+		referenced.collect(projections[i].expr)
+	}
+	for i := range orderingTerms {
+		referenced.collect(orderingTerms[i].expr)
+	}
+	referenced.collect(groupBy...)
+	var rewriter *aggregateRewriter
+	if len(groupBy) != 0 || hasAggregateProjection(projections, orderingTerms) {
+		rewriter = &aggregateRewriter{groupBy: groupBy}
+		for i := range projections {
+			projections[i].expr, err = rewriter.rewrite(projections[i].expr)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for i := range orderingTerms {
+			orderingTerms[i].expr, err = rewriter.rewrite(orderingTerms[i].expr)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	alwaysFalse := false
+	if p.stmt.Where != nil {
+		if err := checkExprSchemas(p.stmt.Where); err != nil {
+			return nil, err
+		}
+		if err := checkExprFunctions(p.stmt.Where); err != nil {
+			return nil, err
+		}
+		if hasAggregate(p.stmt.Where) {
+			return nil, errMisuseAggregate
+		}
+		where, isConst, truth, err := foldPredicate(p.stmt.Where)
+		if err != nil {
+			return nil, err
+		}
+		p.stmt.Where = where
+		if isConst && truth {
+			p.stmt.Where = nil
+		}
+		alwaysFalse = isConst && !truth
+	}
+
 	tt := transactionTypeRead
-	if tableName == "" {
+	if tableName == "" || alwaysFalse {
 		tt = transactionTypeNone
 	}
 	projectNode := &projectNode{
@@ -114,17 +249,64 @@ func (p *selectPlanner) QueryPlan() (*QueryPlan, error) {
 	}
 	plan := newQueryPlan(projectNode, p.stmt.ExplainQueryPlan, tt)
 	projectNode.plan = plan
-	if p.stmt.Where != nil {
-		cev := &catalogExprVisitor{}
-		cev.Init(p.catalog, tableName)
-		p.stmt.Where.BreadthWalk(cev)
+	if len(p.tables) > 1 {
+		plan.tableCursors = map[string]int{}
+		for _, table := range p.tables {
+			plan.tableCursors[table.tableName] = table.cursorId
+		}
+	}
+	// parent is the node the data source feeds.
+	var parent logicalNode = projectNode
+	// sourceCursorId is the cursor of the rows parent reads.
+	sourceCursorId := 1
+	var groupNode *aggregateNode
+	if rewriter != nil {
+		groupNode = &aggregateNode{
+			plan:           plan,
+			groupBy:        groupBy,
+			aggregates:     rewriter.aggregates,
+			cursorId:       3,
+			sourceCursorId: 1,
+		}
+		sourceCursorId = groupNode.cursorId
+	}
+	if len(orderingTerms) != 0 {
+		orderNode := &orderNode{
+			parent:         parent,
+			plan:           plan,
+			terms:          orderingTerms,
+			cursorId:       2,
+			sourceCursorId: sourceCursorId,
+		}
+		if rewriter == nil && len(p.tables) > 1 {
+			for i := range projections {
+				orderNode.values = append(orderNode.values, projections[i].expr)
+				projections[i].expr = &groupColumn{expr: projections[i].expr, colIdx: i}
+			}
+		}
+		parent.setChildren(orderNode)
+		sourceCursorId = orderNode.cursorId
+		parent = orderNode
+	}
+	projectNode.cursorId = sourceCursorId
+	if groupNode != nil {
+		groupNode.parent = parent
+		parent.setChildren(groupNode)
+		parent = groupNode
+	}
+	if alwaysFalse {
+		parent.setChildren(&emptyNode{plan: plan})
+	} else if p.stmt.Where != nil {
+		if err := p.bindExpr(p.stmt.Where); err != nil {
+			return nil, err
+		}
 		filterNode := &filterNode{
-			parent:    projectNode,
+			parent:    parent,
 			plan:      plan,
 			predicate: p.stmt.Where,
 			cursorId:  1,
 		}
-		projectNode.child = filterNode
+		parent.setChildren(filterNode)
 		if tableName == "" {
 			constNode := &constantNode{
 				plan: plan,
@@ -132,39 +314,236 @@ func (p *selectPlanner) QueryPlan() (*QueryPlan, error) {
 			filterNode.child = constNode
 			constNode.parent = filterNode
 		} else {
-			scanNode := &scanNode{
-				plan:           plan,
-				tableName:      tableName,
-				rootPageNumber: rootPageNumber,
-				cursorId:       1,
-			}
-			filterNode.child = scanNode
-			scanNode.parent = filterNode
+			filterNode.child = p.sourceNode(plan, filterNode)
 		}
 	} else {
 		if tableName == "" {
 			constNode := &constantNode{
 				plan: plan,
 			}
-			projectNode.child = constNode
-			constNode.parent = projectNode
+			parent.setChildren(constNode)
+			constNode.parent = parent
 		} else {
-			scanNode := &scanNode{
-				plan:           plan,
-				tableName:      tableName,
-				rootPageNumber: rootPageNumber,
-				cursorId:       1,
-			}
-			projectNode.child = scanNode
-			scanNode.parent = projectNode
+			parent.setChildren(p.sourceNode(plan, parent))
 		}
 	}
 	p.queryPlan = plan
 	plan.root = projectNode
-	(&optimizer{}).optimizePlan(plan)
+	referenced.collect(p.stmt.Where)
+	o, err := p.newOptimizer(tableName, virtualTable, referenced.columns)
+	if err != nil {
+		return nil, err
+	}
+	o.optimizePlan(plan)
 	return plan, nil
 }
 
+// sourceNode returns the node reading the rows of the FROM clause for parent.
+// It is a scan of the table or the joins of the tables in the order they are
+// joined.
+func (p *selectPlanner) sourceNode(plan *QueryPlan, parent logicalNode) logicalNode {
+	first := p.tables[0]
+	var source logicalNode = &scanNode{
+		parent:         parent,
+		plan:           plan,
+		tableName:      first.tableName,
+		rootPageNumber: first.rootPageNumber,
+		virtualTable:   first.virtualTable,
+		cursorId:       first.cursorId,
+	}
+	for _, table := range p.tables[1:] {
+		join := &joinNode{
+			parent: parent,
+			plan:   plan,
+			left:   source,
+			isLeft: table.isLeft,
+			on:     table.on,
+		}
+		join.right = &scanNode{
+			parent:         join,
+			plan:           plan,
+			tableName:      table.tableName,
+			rootPageNumber: table.rootPageNumber,
+			virtualTable:   table.virtualTable,
+			cursorId:       table.cursorId,
+		}
+		switch left := source.(type) {
+		case *scanNode:
+			left.parent = join
+		case *joinNode:
+			left.parent = join
+		}
+		source = join
+	}
+	return source
+}
+
+// newOptimizer returns an optimizer that can use the indexes of tableName.
+// Joins are not optimized.
+func (p *selectPlanner) newOptimizer(
+	tableName string,
+	virtualTable *VirtualTable,
+	columns []*compiler.ColumnRef,
+) (*optimizer, error) {
+	if tableName == "" || virtualTable != nil || len(p.tables) > 1 {
+		return &optimizer{}, nil
+	}
+	indexes, err := getSecondaryIndexes(p.catalog, tableName, 4)
+	if err != nil {
+		return nil, err
+	}
+	tableColumns, err := p.catalog.GetColumns(tableName)
+	if err != nil {
+		return nil, err
+	}
+	pkColumn, err := p.catalog.GetPrimaryKeyColumn(tableName)
+	if err != nil {
+		return nil, err
+	}
+	columnCount := len(tableColumns)
+	if pkColumn != "" {
+		columnCount--
+	}
+	return &optimizer{
+		indexes:     indexes,
+		columnCount: columnCount,
+		columns:     columns,
+		stats:       getTableStats(p.catalog, tableName, indexes),
+	}, nil
+}
+
+// resolveTables sets the tables of the FROM clause. Table valued functions and
+// common table expressions are computed and answered for by the catalog as
+// virtual tables. The first table is read with cursor 1 and the tables joined
+// to it with the cursors following the cursors of the sorter, the group table
+// and the indexes of the first table.
+func (p *selectPlanner) resolveTables() error {
+	for i, join := range fromJoins(p.stmt) {
+		if err := checkSchemaName(join.SchemaName); err != nil {
+			return err
+		}
+		isJoined := slices.ContainsFunc(p.tables, func(t *fromTable) bool {
+			return t.tableName == join.TableName
+		})
+		if isJoined {
+			return fmt.Errorf("%w: %s", errTableJoined, join.TableName)
+		}
+		table := &fromTable{
+			tableName: join.TableName,
+			args:      join.Args,
+			cursorId:  1,
+			isLeft:    join.Left,
+			on:        join.On,
+		}
+		if i != 0 {
+			table.cursorId = 3 + i
+		}
+		var err error
+		if join.Args != nil {
+			table.virtualTable, err = p.resolveTableFunction(join.TableName, join.Args)
+		} else if isCommonTable(p.stmt, join) {
+			table.virtualTable, err = p.materializeCommonTable(join.TableName)
+		}
+		if err != nil {
+			return err
+		}
+		if table.virtualTable != nil {
+			p.catalog = &virtualTableCatalog{
+				selectCatalog: p.catalog,
+				tableName:     table.tableName,
+				table:         table.virtualTable,
+			}
+		} else {
+			table.rootPageNumber, err = p.catalog.GetRootPageNumber(table.tableName)
+			if err != nil {
+				return errTableNotExist
+			}
+		}
+		p.tables = append(p.tables, table)
+	}
+	return nil
+}
+
+// tableNames returns the names of the tables of the FROM clause in the order
+// they are joined.
+func (p *selectPlanner) tableNames() []string {
+	tableNames := []string{}
+	for _, table := range p.tables {
+		tableNames = append(tableNames, table.tableName)
+	}
+	return tableNames
+}
+
+// bindExpr assigns catalog information for the tables of the FROM clause to the
+// column references in e.
+func (p *selectPlanner) bindExpr(e compiler.Expr) error {
+	return bindExprTables(p.catalog, p.tableNames(), e)
+}
+
+// bindJoins checks and binds the ON expression of each join. An ON expression
+// can reference the table it joins and the tables preceding it.
+func (p *selectPlanner) bindJoins() error {
+	tableNames := p.tableNames()
+	for i, table := range p.tables {
+		if table.on == nil {
+			continue
+		}
+		if err := checkExprSchemas(table.on); err != nil {
+			return err
+		}
+		if err := checkExprFunctions(table.on); err != nil {
+			return err
+		}
+		if hasAggregate(table.on) {
+			return errMisuseAggregate
+		}
+		if err := bindExprTables(p.catalog, tableNames[:i+1], table.on); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveTableFunction returns the virtual table produced by the table valued
+// function name called with args.
+func (p *selectPlanner) resolveTableFunction(name string, args []compiler.Expr) (*VirtualTable, error) {
+	if p.tableFunction == nil {
+		return nil, fmt.Errorf("%w: %s", errNoTableFunction, name)
+	}
+	values, err := tableFunctionArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return p.tableFunction(name, values)
+}
+
+// materializeCommonTable returns the rows of the common table expression name.
+// Parameters are not allowed since the rows are computed while planning and a
+// materialized result is reused by executions with other parameters.
+func (p *selectPlanner) materializeCommonTable(name string) (*VirtualTable, error) {
+	if p.materializer == nil {
+		return nil, errNoMaterializer
+	}
+	cte, _ := commonTable(p.stmt, name)
+	if selectHasVariable(cte.Select) {
+		return nil, errCommonTableVariable
+	}
+	cte.Select = withPreceding(p.stmt, cte)
+	table, err := p.materializer(cte)
+	if err != nil {
+		return nil, err
+	}
+	if cte.Columns == nil {
+		return table, nil
+	}
+	if len(cte.Columns) != len(table.Columns)-1 {
+		return nil, fmt.Errorf("%w: %s", errCommonTableColumns, cte.Name)
+	}
+	renamed := *table
+	renamed.Columns = append([]string{table.Columns[0]}, cte.Columns...)
+	return &renamed, nil
+}
+
 // ExecutionPlan returns the bytecode execution plan for the planner. Calling
 // QueryPlan is not a prerequisite to this method as it will be called by
 // ExecutionPlan if needed.
@@ -196,12 +575,31 @@ func (p *selectPlanner) optimizeResultColumns() error {
 	return nil
 }
 
+// foldPredicate folds predicate. When the folded predicate is a constant isConst
+// is true and truth is whether the predicate always passes. A filter with an
+// always true predicate can be removed and a filter with an always false
+// predicate will never produce rows.
+func foldPredicate(predicate compiler.Expr) (folded compiler.Expr, isConst, truth bool, err error) {
+	folded, err = foldExpr(predicate)
+	if err != nil {
+		return nil, false, false, err
+	}
+	isConst, truth, _ = constantTruth(folded)
+	return folded, isConst, truth, nil
+}
+
 // foldExpr folds expressions that can be computed before the query is executed.
 // This optimization cuts down on instructions.
 func foldExpr(e compiler.Expr) (compiler.Expr, error) {
-	// Currently this only focuses on squashing binary expressions, but it could
-	// do unary expressions or certain string manipulations. Anything involving
-	// two constants.
+	// Currently this only focuses on squashing binary, unary and function
+	// expressions, but it could do certain string manipulations. Anything
+	// involving constants.
+	if ue, ok := e.(*compiler.UnaryExpr); ok {
+		return foldUnary(ue)
+	}
+	if fe, ok := e.(*compiler.FunctionExpr); ok {
+		return foldFunction(fe)
+	}
 	be, bok := e.(*compiler.BinaryExpr)
 	if !bok {
 		return e, nil
@@ -215,6 +613,20 @@ func foldExpr(e compiler.Expr) (compiler.Expr, error) {
 	if err != nil {
 		return nil, err
 	}
+	if be.Operator == compiler.OpAnd || be.Operator == compiler.OpOr {
+		return foldLogical(be), nil
+	}
+	_, lnull := be.Left.(*compiler.NullLit)
+	_, rnull := be.Right.(*compiler.NullLit)
+	if lnull || rnull {
+		return &compiler.NullLit{}, nil
+	}
+	if be.Operator == compiler.OpLike {
+		return be, nil
+	}
+	if be.Operator == compiler.OpConcat {
+		return foldConcat(be), nil
+	}
 	// TODO need to support strings as well. Should probably share logic with vm
 	// somehow.
 	// TODO need to consider commutative operators such as + i.e. 4 + age + 5 vs
@@ -227,7 +639,11 @@ func foldExpr(e compiler.Expr) (compiler.Expr, error) {
 	}
 	switch be.Operator {
 	case compiler.OpAdd:
-		return &compiler.IntLit{Value: le.Value + re.Value}, nil
+		v, err := vm.Add(le.Value, re.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.IntLit{Value: v}, nil
 	case compiler.OpDiv:
 		if re.Value == 0 {
 			return nil, errors.New("cannot divide by 0")
@@ -236,9 +652,30 @@ func foldExpr(e compiler.Expr) (compiler.Expr, error) {
 	case compiler.OpExp:
 		return &compiler.IntLit{Value: int(math.Pow(float64(le.Value), float64(re.Value)))}, nil
 	case compiler.OpMul:
-		return &compiler.IntLit{Value: le.Value * re.Value}, nil
+		v, err := vm.Multiply(le.Value, re.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.IntLit{Value: v}, nil
+	case compiler.OpMod:
+		if re.Value == 0 {
+			return nil, errors.New("cannot divide by 0")
+		}
+		return &compiler.IntLit{Value: le.Value % re.Value}, nil
+	case compiler.OpBitAnd:
+		return &compiler.IntLit{Value: le.Value & re.Value}, nil
+	case compiler.OpBitOr:
+		return &compiler.IntLit{Value: le.Value | re.Value}, nil
+	case compiler.OpShiftLeft:
+		return &compiler.IntLit{Value: vm.ShiftLeft(le.Value, re.Value)}, nil
+	case compiler.OpShiftRight:
+		return &compiler.IntLit{Value: vm.ShiftRight(le.Value, re.Value)}, nil
 	case compiler.OpSub:
-		return &compiler.IntLit{Value: le.Value - re.Value}, nil
+		v, err := vm.Subtract(le.Value, re.Value)
+		if err != nil {
+			return nil, err
+		}
+		return &compiler.IntLit{Value: v}, nil
 	case compiler.OpEq:
 		if le.Value == re.Value {
 			return &compiler.IntLit{Value: 1}, nil
@@ -254,40 +691,165 @@ func foldExpr(e compiler.Expr) (compiler.Expr, error) {
 			return &compiler.IntLit{Value: 1}, nil
 		}
 		return &compiler.IntLit{Value: 0}, nil
+	case compiler.OpNe:
+		return boolLit(le.Value != re.Value), nil
+	case compiler.OpLte:
+		return boolLit(le.Value <= re.Value), nil
+	case compiler.OpGte:
+		return boolLit(le.Value >= re.Value), nil
 	default:
 		return nil, fmt.Errorf("folding not implemented for %s", be.Operator)
 	}
 }
 
+// foldConcat folds || when both operands are integer or string literals.
+func foldConcat(be *compiler.BinaryExpr) compiler.Expr {
+	l, lok := literalValue(be.Left)
+	r, rok := literalValue(be.Right)
+	if !lok || !rok {
+		return be
+	}
+	return &compiler.StringLit{Value: l.Text() + r.Text()}
+}
+
+// foldLogical folds AND and OR when the operands are constant or when one
+// constant operand decides the result. For example 0 AND a is always 0.
+func foldLogical(be *compiler.BinaryExpr) compiler.Expr {
+	// decisive is the truth of an operand that decides the result on its own.
+	decisive := be.Operator == compiler.OpOr
+	lconst, ltruth, lknown := constantTruth(be.Left)
+	rconst, rtruth, rknown := constantTruth(be.Right)
+	if (lknown && ltruth == decisive) || (rknown && rtruth == decisive) {
+		return boolLit(decisive)
+	}
+	if !lconst || !rconst {
+		return be
+	}
+	if lknown && rknown {
+		return boolLit(!decisive)
+	}
+	return &compiler.NullLit{}
+}
+
+// constantTruth returns the truth of e when e is a constant. known is false
+// when e is NULL since the truth of NULL is unknown.
+func constantTruth(e compiler.Expr) (isConst, truth, known bool) {
+	switch c := e.(type) {
+	case *compiler.IntLit:
+		return true, c.Value != 0, true
+	case *compiler.FloatLit:
+		return true, c.Value != 0, true
+	case *compiler.NullLit:
+		return true, false, false
+	}
+	return false, false, false
+}
+
+// boolLit returns the integer literal for b.
+func boolLit(b bool) *compiler.IntLit {
+	if b {
+		return &compiler.IntLit{Value: 1}
+	}
+	return &compiler.IntLit{Value: 0}
+}
+
+// foldUnary folds NOT, negation and bitwise complement when the operand folds
+// to an integer or NULL. Other unary expressions are folded by foldNullTest.
+func foldUnary(ue *compiler.UnaryExpr) (compiler.Expr, error) {
+	var err error
+	ue.Operand, err = foldExpr(ue.Operand)
+	if err != nil {
+		return nil, err
+	}
+	if ue.Operator != compiler.OpNot && ue.Operator != compiler.OpNeg && ue.Operator != compiler.OpBitNot {
+		return foldNullTest(ue), nil
+	}
+	isConst, truth, known := constantTruth(ue.Operand)
+	if !isConst {
+		return ue, nil
+	}
+	if !known {
+		return &compiler.NullLit{}, nil
+	}
+	if ue.Operator == compiler.OpNot {
+		return boolLit(!truth), nil
+	}
+	if fl, ok := ue.Operand.(*compiler.FloatLit); ok {
+		if ue.Operator == compiler.OpBitNot {
+			return ue, nil
+		}
+		return &compiler.FloatLit{Value: -fl.Value}, nil
+	}
+	if ue.Operator == compiler.OpBitNot {
+		return &compiler.IntLit{Value: ^ue.Operand.(*compiler.IntLit).Value}, nil
+	}
+	return &compiler.IntLit{Value: -ue.Operand.(*compiler.IntLit).Value}, nil
+}
+
+// foldNullTest folds IS NULL and IS NOT NULL when the operand is a constant.
+func foldNullTest(ue *compiler.UnaryExpr) compiler.Expr {
+	var isNull bool
+	switch ue.Operand.(type) {
+	case *compiler.NullLit:
+		isNull = true
+	case *compiler.IntLit, *compiler.FloatLit, *compiler.StringLit:
+		isNull = false
+	default:
+		return ue
+	}
+	if isNull == (ue.Operator == compiler.OpIsNull) {
+		return &compiler.IntLit{Value: 1}
+	}
+	return &compiler.IntLit{Value: 0}
+}
+
+// allColumns returns the projections of the columns of table selected by *.
+func (p *selectPlanner) allColumns(table *fromTable) ([]projection, error) {
+	cols, err := p.catalog.GetColumns(table.tableName)
+	if err != nil {
+		return nil, err
+	}
+	if table.virtualTable != nil && table.virtualTable.HidePrimaryKey {
+		cols = cols[1:]
+	}
+	projections := []projection{}
+	for _, c := range cols {
+		projections = append(projections, projection{
+			expr: &compiler.ColumnRef{
+				Table:  table.tableName,
+				Column: c,
+			},
+		})
+	}
+	return projections, nil
+}
+
 func (p *selectPlanner) getProjections() ([]projection, error) {
 	var projections []projection
 	for _, resultColumn := range p.stmt.ResultColumns {
 		if resultColumn.All {
-			cols, err := p.catalog.GetColumns(p.stmt.From.TableName)
-			if err != nil {
-				return nil, err
+			if len(p.tables) == 0 {
+				return nil, errNoTables
 			}
-			for _, c := range cols {
-				projections = append(projections, projection{
-					expr: &compiler.ColumnRef{
-						Table:  p.stmt.From.TableName,
-						Column: c,
-					},
-				})
+			for _, table := range p.tables {
+				cols, err := p.allColumns(table)
+				if err != nil {
+					return nil, err
+				}
+				projections = append(projections, cols...)
 			}
 		} else if resultColumn.AllTable != "" {
-			cols, err := p.catalog.GetColumns(p.stmt.From.TableName)
+			i := slices.IndexFunc(p.tables, func(t *fromTable) bool {
+				return t.tableName == resultColumn.AllTable
+			})
+			if i == -1 {
+				return nil, fmt.Errorf("%w: %s", errTableNotExist, resultColumn.AllTable)
+			}
+			cols, err := p.allColumns(p.tables[i])
 			if err != nil {
 				return nil, err
 			}
-			for _, c := range cols {
-				projections = append(projections, projection{
-					expr: &compiler.ColumnRef{
-						Table:  p.stmt.From.TableName,
-						Column: c,
-					},
-				})
-			}
+			projections = append(projections, cols...)
 		} else if resultColumn.Expression != nil {
 			projections = append(projections, projection{
 				expr:  resultColumn.Expression,
@@ -298,15 +860,124 @@ func (p *selectPlanner) getProjections() ([]projection, error) {
 	return projections, nil
 }
 
+// isCountTable is true when the statement only counts the rows of a table. This
+// is done without visiting each row.
+func (p *selectPlanner) isCountTable(projections []projection, tableName string) bool {
+	if len(projections) != 1 || tableName == "" || len(p.tables) > 1 {
+		return false
+	}
+	if p.stmt.Where != nil || len(p.stmt.GroupBy) != 0 || len(p.stmt.OrderBy) != 0 {
+		return false
+	}
+	f, ok := projections[0].expr.(*compiler.FunctionExpr)
+	return ok && f.FnType == compiler.FnCount && f.Args == nil
+}
+
+// hasAggregateProjection is true when a projection or ordering term contains an
+// aggregate function.
+func hasAggregateProjection(projections []projection, terms []orderingTerm) bool {
+	for _, projection := range projections {
+		if hasAggregate(projection.expr) {
+			return true
+		}
+	}
+	for _, term := range terms {
+		if hasAggregate(term.expr) {
+			return true
+		}
+	}
+	return false
+}
+
+// getGroupBy resolves the expressions of the GROUP BY clause the same way as
+// the terms of the ORDER BY clause.
+func (p *selectPlanner) getGroupBy(projections []projection) ([]compiler.Expr, error) {
+	exprs := []compiler.Expr{}
+	for _, e := range p.stmt.GroupBy {
+		expr, err := p.resolveTerm(e, projections, errGroupByTermRange)
+		if err != nil {
+			return nil, err
+		}
+		if hasAggregate(expr) {
+			return nil, errMisuseAggregate
+		}
+		exprs = append(exprs, expr)
+	}
+	return exprs, nil
+}
+
+// getOrderingTerms resolves the terms of the ORDER BY clause.
+func (p *selectPlanner) getOrderingTerms(projections []projection) ([]orderingTerm, error) {
+	terms := []orderingTerm{}
+	for _, term := range p.stmt.OrderBy {
+		expr, err := p.resolveTerm(term.Expr, projections, errOrderByTermRange)
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, orderingTerm{expr: expr, desc: term.Desc})
+	}
+	return terms, nil
+}
+
+// resolveTerm resolves a term of an ORDER BY or GROUP BY clause. A term that is
+// an integer refers to the projection in that position and a term that is the
+// alias of a projection refers to that projection. rangeErr is returned when
+// the position is not a projection.
+func (p *selectPlanner) resolveTerm(
+	term compiler.Expr,
+	projections []projection,
+	rangeErr error,
+) (compiler.Expr, error) {
+	expr, err := foldExpr(term)
+	if err != nil {
+		return nil, err
+	}
+	switch e := expr.(type) {
+	case *compiler.IntLit:
+		if e.Value < 1 || e.Value > len(projections) {
+			return nil, fmt.Errorf(
+				"%w should be between 1 and %d",
+				rangeErr,
+				len(projections),
+			)
+		}
+		expr = projections[e.Value-1].expr
+	case *compiler.ColumnRef:
+		if e.Table == "" {
+			for _, projection := range projections {
+				if projection.alias != "" && projection.alias == e.Column {
+					expr = projection.expr
+					break
+				}
+			}
+		}
+	}
+	if err := checkExprSchemas(expr); err != nil {
+		return nil, err
+	}
+	if err := checkExprFunctions(expr); err != nil {
+		return nil, err
+	}
+	if err := p.bindExpr(expr); err != nil {
+		return nil, err
+	}
+	return expr, nil
+}
+
 func (p *selectPlanner) setResultHeader() {
 	resultHeader := []string{}
+	resultOrigins := []vm.ColumnOrigin{}
 	switch t := p.queryPlan.root.(type) {
 	case *projectNode:
 		projectExprs := []compiler.Expr{}
 		for _, projection := range t.projections {
 			header := ""
 			if projection.alias == "" {
-				if cr, ok := projection.expr.(*compiler.ColumnRef); ok {
+				expr := projection.expr
+				if gc, ok := expr.(*groupColumn); ok {
+					expr = gc.expr
+				}
+				if cr, ok := expr.(*compiler.ColumnRef); ok {
 					header = cr.Column
 				}
 			} else {
@@ -314,15 +985,49 @@ func (p *selectPlanner) setResultHeader() {
 			}
 			resultHeader = append(resultHeader, header)
 			projectExprs = append(projectExprs, projection.expr)
+			resultOrigins = append(resultOrigins, p.resultOrigin(projection.expr))
 		}
 		p.setResultTypes(projectExprs)
 	case *countNode:
 		resultHeader = append(resultHeader, t.projection.alias)
+		resultOrigins = append(resultOrigins, vm.ColumnOrigin{})
 		p.setResultTypes([]compiler.Expr{t.projection.expr})
 	default:
 		panic("unhandled node for result header")
 	}
 	p.executionPlan.ResultHeader = resultHeader
+	p.executionPlan.ResultOrigins = resultOrigins
+}
+
+// resultOrigin returns the table column expr is taken from. The origin is empty
+// when expr is not a column of a table. Columns of table valued functions have
+// no origin since they are not stored in a table.
+func (p *selectPlanner) resultOrigin(expr compiler.Expr) vm.ColumnOrigin {
+	if gc, ok := expr.(*groupColumn); ok {
+		expr = gc.expr
+	}
+	cr, ok := expr.(*compiler.ColumnRef)
+	if !ok || len(p.tables) == 0 {
+		return vm.ColumnOrigin{}
+	}
+	table := p.tables[0]
+	if cr.Table != "" {
+		i := slices.IndexFunc(p.tables, func(t *fromTable) bool {
+			return t.tableName == cr.Table
+		})
+		if i == -1 {
+			return vm.ColumnOrigin{}
+		}
+		table = p.tables[i]
+	}
+	if table.args != nil {
+		return vm.ColumnOrigin{}
+	}
+	return vm.ColumnOrigin{
+		Database: mainSchemaName,
+		Table:    table.tableName,
+		Column:   cr.Column,
+	}
 }
 
 // setResultTypes attempts to precompute the type for each result column expr.
@@ -343,29 +1048,67 @@ func (p *selectPlanner) setResultTypes(exprs []compiler.Expr) error {
 // variable it will need to be resolved later on.
 func getExprType(expr compiler.Expr) (catalog.CdbType, error) {
 	switch c := expr.(type) {
-	case *compiler.IntLit:
+	case *compiler.IntLit, *compiler.FloatLit:
 		return catalog.CdbType{ID: catalog.CTInt}, nil
 	case *compiler.StringLit:
 		return catalog.CdbType{ID: catalog.CTStr}, nil
 	case *compiler.Variable:
 		return catalog.CdbType{ID: catalog.CTVar, VarPosition: c.Position}, nil
+	case *compiler.NullLit:
+		// NULL has no type of its own. It is an integer the same as NULL in an
+		// integer column.
+		return catalog.CdbType{ID: catalog.CTInt}, nil
+	case *compiler.UnaryExpr:
+		if _, err := getExprType(c.Operand); err != nil {
+			return catalog.CdbType{ID: catalog.CTUnknown}, err
+		}
+		return catalog.CdbType{ID: catalog.CTInt}, nil
 	case *compiler.FunctionExpr:
+		switch c.FnType {
+		case compiler.FnMin, compiler.FnMax:
+			return getExprType(c.Args[0])
+		case compiler.FnCoalesce, compiler.FnIfnull:
+			// The type is the type of the first argument that can be something
+			// other than NULL.
+			for _, arg := range c.Args {
+				if _, ok := arg.(*compiler.NullLit); !ok {
+					return getExprType(arg)
+				}
+			}
+		case compiler.FnTypeof, compiler.FnCurrentTimestamp, compiler.FnCurrentDate,
+			compiler.FnUpper, compiler.FnLower, compiler.FnSubstr, compiler.FnTrim,
+			compiler.FnDate, compiler.FnTime, compiler.FnDatetime, compiler.FnStrftime:
+			return catalog.CdbType{ID: catalog.CTStr}, nil
+		}
+		return catalog.CdbType{ID: catalog.CTInt}, nil
+	case *compiler.CastExpr:
+		if _, err := getExprType(c.Expr); err != nil {
+			return catalog.CdbType{ID: catalog.CTUnknown}, err
+		}
+		switch vm.AffinityOf(c.TypeName) {
+		case vm.TextAffinity, vm.BlobAffinity:
+			return catalog.CdbType{ID: catalog.CTStr}, nil
+		}
 		return catalog.CdbType{ID: catalog.CTInt}, nil
+	case *groupColumn:
+		return getExprType(c.expr)
 	case *compiler.ColumnRef:
 		return c.Type, nil
 	case *compiler.BinaryExpr:
-		left, err := getExprType(c.Left)
-		if err != nil {
+		// Arithmetic and comparisons convert their operands to integers so the
+		// result is an integer no matter the type of the operands. This means
+		// an operand that is a variable has no effect on the type. The result
+		// of || is always text.
+		if _, err := getExprType(c.Left); err != nil {
 			return catalog.CdbType{ID: catalog.CTUnknown}, err
 		}
-		right, err := getExprType(c.Right)
-		if err != nil {
+		if _, err := getExprType(c.Right); err != nil {
 			return catalog.CdbType{ID: catalog.CTUnknown}, err
 		}
-		if left.ID > right.ID {
-			return left, nil
+		if c.Operator == compiler.OpConcat {
+			return catalog.CdbType{ID: catalog.CTStr}, nil
 		}
-		return right, nil
+		return catalog.CdbType{ID: catalog.CTInt}, nil
 	default:
 		return catalog.CdbType{ID: catalog.CTUnknown}, fmt.Errorf("no handler for expr type %v", expr)
 	}
@@ -2,6 +2,8 @@ package planner
 
 import (
 	"errors"
+	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/chirst/cdb/catalog"
@@ -13,6 +15,8 @@ type mockSelectCatalog struct {
 	columns              []string
 	columnTypes          []catalog.CdbType
 	primaryKeyColumnName string
+	indexes              []catalog.Index
+	stats                []catalog.Stat
 }
 
 func (m *mockSelectCatalog) GetColumns(s string) ([]string, error) {
@@ -33,6 +37,10 @@ func (*mockSelectCatalog) GetVersion() string {
 	return "v"
 }
 
+func (*mockSelectCatalog) GetGeneration(string) int {
+	return 0
+}
+
 func (m *mockSelectCatalog) GetPrimaryKeyColumn(tableName string) (string, error) {
 	return m.primaryKeyColumnName, nil
 }
@@ -47,6 +55,28 @@ func (m *mockSelectCatalog) GetColumnType(tableName string, columnName string) (
 	return catalog.CdbType{ID: catalog.CTUnknown}, nil
 }
 
+func (m *mockSelectCatalog) GetIndexes(tableName string) []catalog.Index {
+	return m.indexes
+}
+
+func (m *mockSelectCatalog) GetTableStat(tableName string) (catalog.Stat, bool) {
+	for _, stat := range m.stats {
+		if stat.TableName == tableName && stat.IndexName == "" {
+			return stat, true
+		}
+	}
+	return catalog.Stat{}, false
+}
+
+func (m *mockSelectCatalog) GetIndexStat(indexName string) (catalog.Stat, bool) {
+	for _, stat := range m.stats {
+		if stat.IndexName == indexName {
+			return stat, true
+		}
+	}
+	return catalog.Stat{}, false
+}
+
 func TestSelectPlan(t *testing.T) {
 	type selectCase struct {
 		description      string
@@ -486,6 +516,55 @@ func TestSelectTableDoesNotExist(t *testing.T) {
 	}
 }
 
+func TestSelectSchemaDoesNotExist(t *testing.T) {
+	cases := []struct {
+		description string
+		ast         *compiler.SelectStmt
+	}{
+		{
+			description: "From",
+			ast: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				From: &compiler.From{
+					SchemaName: "temp",
+					TableName:  "foo",
+				},
+				ResultColumns: []compiler.ResultColumn{
+					{
+						All: true,
+					},
+				},
+			},
+		},
+		{
+			description: "ColumnRef",
+			ast: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				From: &compiler.From{
+					TableName: "foo",
+				},
+				ResultColumns: []compiler.ResultColumn{
+					{
+						Expression: &compiler.ColumnRef{
+							Schema: "temp",
+							Table:  "foo",
+							Column: "id",
+						},
+					},
+				},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			_, err := NewSelect(&mockSelectCatalog{}, c.ast).ExecutionPlan()
+			if expectErr := errSchemaNotExist; !errors.Is(err, expectErr) {
+				t.Fatalf("expected err: %s but got: %s", expectErr, err)
+			}
+		})
+	}
+}
+
 func TestUsePrimaryKeyIndex(t *testing.T) {
 	ast := &compiler.SelectStmt{
 		StmtBase: &compiler.StmtBase{},
@@ -559,3 +638,947 @@ func TestUsePrimaryKeyIndexFlippedOperands(t *testing.T) {
 		t.Errorf("expected project node but got %#v", qp.root)
 	}
 }
+
+func TestPrimaryKeyRangeScan(t *testing.T) {
+	cases := []struct {
+		description string
+		where       *compiler.BinaryExpr
+		wantBound   bool
+	}{
+		{
+			description: "GreaterThan",
+			where: &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "id"},
+				Right:    &compiler.IntLit{Value: 1},
+				Operator: compiler.OpGt,
+			},
+			wantBound: true,
+		},
+		{
+			description: "FlippedLessThan",
+			where: &compiler.BinaryExpr{
+				Left:     &compiler.Variable{Position: 0},
+				Right:    &compiler.ColumnRef{Column: "id"},
+				Operator: compiler.OpLt,
+			},
+			wantBound: true,
+		},
+		{
+			description: "LessThan",
+			where: &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "id"},
+				Right:    &compiler.IntLit{Value: 1},
+				Operator: compiler.OpLt,
+			},
+		},
+		{
+			description: "NotPrimaryKey",
+			where: &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "name"},
+				Right:    &compiler.IntLit{Value: 1},
+				Operator: compiler.OpGt,
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ast := &compiler.SelectStmt{
+				StmtBase:      &compiler.StmtBase{},
+				From:          &compiler.From{TableName: "foo"},
+				ResultColumns: []compiler.ResultColumn{{All: true}},
+				Where:         c.where,
+			}
+			mockCatalog := &mockSelectCatalog{primaryKeyColumnName: "id"}
+			qp, err := NewSelect(mockCatalog, ast).QueryPlan()
+			if err != nil {
+				t.Fatalf("expected no err got err %s", err)
+			}
+			pn := qp.root.(*projectNode)
+			fn, ok := pn.child.(*filterNode)
+			if !ok {
+				t.Fatalf("expected the filter to be kept but got %#v", pn.child)
+			}
+			sn, ok := fn.child.(*scanNode)
+			if !ok {
+				t.Fatalf("expected scan node but got %#v", fn.child)
+			}
+			if gotBound := sn.lowerBound != nil; gotBound != c.wantBound {
+				t.Fatalf("expected lower bound %t got %t", c.wantBound, gotBound)
+			}
+		})
+	}
+}
+
+func TestPrimaryKeyRangeScanUpperBound(t *testing.T) {
+	stmt := &compiler.SelectStmt{
+		StmtBase: &compiler.StmtBase{},
+		From:     &compiler.From{TableName: "foo"},
+		ResultColumns: []compiler.ResultColumn{
+			{Expression: &compiler.ColumnRef{Column: "name"}},
+		},
+		Where: &compiler.BinaryExpr{
+			Left: &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "id"},
+				Operator: compiler.OpGt,
+				Right:    &compiler.IntLit{Value: 1},
+			},
+			Operator: compiler.OpAnd,
+			Right: &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "id"},
+				Operator: compiler.OpLt,
+				Right:    &compiler.IntLit{Value: 4},
+			},
+		},
+	}
+	mockCatalog := &mockSelectCatalog{primaryKeyColumnName: "id"}
+	expectedCommands := []vm.Command{
+		&vm.InitCmd{P2: 17},
+		&vm.OpenReadCmd{P1: 1, P2: 2},
+		&vm.CopyCmd{P1: 2, P2: 1},
+		&vm.SeekGTCmd{P1: 1, P2: 16, P3: 1},
+		&vm.RowIdCmd{P1: 1, P2: 3},
+		// A row id that is not less than 4 is past the upper bound.
+		&vm.LteCmd{P1: 4, P2: 14, P3: 3, P5: 1},
+		&vm.RowIdCmd{P1: 1, P2: 6},
+		&vm.GteCmd{P1: 2, P2: 12, P3: 6, P5: 1},
+		&vm.RowIdCmd{P1: 1, P2: 8},
+		&vm.LteCmd{P1: 4, P2: 12, P3: 8, P5: 1},
+		&vm.ColumnCmd{P1: 1, P2: 0, P3: 10},
+		&vm.ResultRowCmd{P1: 10, P2: 1},
+		&vm.NextCmd{P1: 1, P2: 4},
+		&vm.GotoCmd{P2: 16},
+		// The scan halts past the upper bound unless the lower bound is
+		// negative.
+		&vm.CastCmd{P1: 2, P2: 11, P4: "INTEGER"},
+		&vm.GtCmd{P1: 12, P2: 12, P3: 11, P5: 1},
+		&vm.HaltCmd{},
+		&vm.TransactionCmd{P2: 0},
+		&vm.IntegerCmd{P1: 1, P2: 2},
+		&vm.IntegerCmd{P1: 4, P2: 4},
+		&vm.IntegerCmd{P1: 0, P2: 12},
+		&vm.GotoCmd{P2: 1},
+	}
+	plan, err := NewSelect(mockCatalog, stmt).ExecutionPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestUseSecondaryIndex(t *testing.T) {
+	cases := []struct {
+		description   string
+		resultColumns []compiler.ResultColumn
+		include       []string
+		covering      bool
+		cursorId      int
+	}{
+		{
+			description: "Covering",
+			resultColumns: []compiler.ResultColumn{
+				{Expression: &compiler.ColumnRef{Column: "id"}},
+				{Expression: &compiler.ColumnRef{Column: "name"}},
+			},
+			covering: true,
+			cursorId: 1,
+		},
+		{
+			description:   "NotCovering",
+			resultColumns: []compiler.ResultColumn{{All: true}},
+			covering:      false,
+			cursorId:      4,
+		},
+		{
+			description: "CoveringInclude",
+			resultColumns: []compiler.ResultColumn{
+				{Expression: &compiler.ColumnRef{Column: "name"}},
+				{Expression: &compiler.ColumnRef{Column: "age"}},
+			},
+			include:  []string{"age"},
+			covering: true,
+			cursorId: 1,
+		},
+		{
+			description:   "NotCoveringInclude",
+			resultColumns: []compiler.ResultColumn{{All: true}},
+			include:       []string{"age"},
+			covering:      false,
+			cursorId:      4,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ast := &compiler.SelectStmt{
+				StmtBase:      &compiler.StmtBase{},
+				From:          &compiler.From{TableName: "foo"},
+				ResultColumns: c.resultColumns,
+				Where: &compiler.BinaryExpr{
+					Left:     &compiler.StringLit{Value: "a"},
+					Right:    &compiler.ColumnRef{Column: "name"},
+					Operator: compiler.OpEq,
+				},
+			}
+			mockCatalog := &mockSelectCatalog{
+				columns:              []string{"id", "age", "name", "email"},
+				primaryKeyColumnName: "id",
+				indexes: []catalog.Index{
+					{Name: "idx_name", TableName: "foo", RootPageNumber: 3, Columns: []string{"name"}, Include: c.include},
+				},
+			}
+			qp, err := NewSelect(mockCatalog, ast).QueryPlan()
+			if err != nil {
+				t.Fatalf("expected no err got err %s", err)
+			}
+			pn, ok := qp.root.(*projectNode)
+			if !ok {
+				t.Fatalf("expected project node but got %#v", qp.root)
+			}
+			seekN, ok := pn.child.(*indexSeekNode)
+			if !ok {
+				t.Fatalf("expected index seek node but got %#v", pn.child)
+			}
+			if seekN.parent != pn {
+				t.Fatal("expected parent to be pn")
+			}
+			if seekN.index.colIdx != 1 {
+				t.Fatalf("expected index on column 1 got %d", seekN.index.colIdx)
+			}
+			if seekN.covering != c.covering {
+				t.Fatalf("expected covering %t got %t", c.covering, seekN.covering)
+			}
+			if seekN.index.cursorId != c.cursorId {
+				t.Fatalf("expected index cursor %d got %d", c.cursorId, seekN.index.cursorId)
+			}
+		})
+	}
+}
+
+func TestCostBasedAccess(t *testing.T) {
+	eq := func(column string, value compiler.Expr) compiler.Expr {
+		return &compiler.BinaryExpr{Left: &compiler.ColumnRef{Column: column}, Right: value, Operator: compiler.OpEq}
+	}
+	and := func(left, right compiler.Expr) compiler.Expr {
+		return &compiler.BinaryExpr{Left: left, Right: right, Operator: compiler.OpAnd}
+	}
+	analyzed := func(rows, nameDistinct, ageDistinct int) []catalog.Stat {
+		return []catalog.Stat{
+			{TableName: "foo", RowCount: rows},
+			{TableName: "foo", IndexName: "idx_name", RowCount: rows, DistinctCount: nameDistinct},
+			{TableName: "foo", IndexName: "idx_age", RowCount: rows, DistinctCount: ageDistinct},
+		}
+	}
+	cases := []struct {
+		description string
+		where       compiler.Expr
+		orderBy     string
+		stats       []catalog.Stat
+		expected    string
+	}{
+		{
+			description: "IndexWithoutStats",
+			where:       eq("name", &compiler.StringLit{Value: "a"}),
+			expected:    " ── project\n     └─ seek table foo using index idx_name (name = ?)\n",
+		},
+		{
+			description: "SelectiveIndex",
+			where:       eq("name", &compiler.StringLit{Value: "a"}),
+			stats:       analyzed(1000, 500, 2),
+			expected:    " ── project\n     └─ seek table foo using index idx_name (name = ?) (~2 rows)\n",
+		},
+		{
+			description: "UnselectiveIndex",
+			where:       eq("age", &compiler.IntLit{Value: 1}),
+			stats:       analyzed(1000, 500, 2),
+			expected:    " ── project\n     └─ filter (age = ?)\n         └─ scan table foo (~1000 rows)\n",
+		},
+		{
+			description: "CheapestIndex",
+			where:       and(eq("age", &compiler.IntLit{Value: 1}), eq("name", &compiler.Variable{Position: 0})),
+			stats:       analyzed(1000, 500, 250),
+			expected:    " ── project\n     └─ filter (age = ? AND name = ?)\n         └─ seek table foo using index idx_name (name = ?) (~2 rows)\n",
+		},
+		{
+			description: "RowIdSeek",
+			where:       and(eq("name", &compiler.StringLit{Value: "a"}), eq("id", &compiler.IntLit{Value: 1})),
+			stats:       analyzed(1000, 1000, 2),
+			expected:    " ── project\n     └─ filter (name = ? AND id PRIMARY KEY = ?)\n         └─ seek table foo (id PRIMARY KEY = ?)\n",
+		},
+		{
+			description: "IndexScan",
+			orderBy:     "age",
+			expected:    " ── project\n     └─ scan table foo using index idx_age\n",
+		},
+		{
+			description: "SortSmallTable",
+			orderBy:     "age",
+			stats:       analyzed(10, 5, 5),
+			expected:    " ── project\n     └─ order by age\n         └─ scan table foo (~10 rows)\n",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			ast := &compiler.SelectStmt{
+				StmtBase:      &compiler.StmtBase{ExplainQueryPlan: true},
+				From:          &compiler.From{TableName: "foo"},
+				ResultColumns: []compiler.ResultColumn{{All: true}},
+				Where:         c.where,
+			}
+			if c.orderBy != "" {
+				ast.OrderBy = []compiler.OrderingTerm{{Expr: &compiler.ColumnRef{Column: c.orderBy}}}
+			}
+			mockCatalog := &mockSelectCatalog{
+				columns:              []string{"id", "age", "name"},
+				primaryKeyColumnName: "id",
+				indexes: []catalog.Index{
+					{Name: "idx_name", TableName: "foo", RootPageNumber: 3, Columns: []string{"name"}},
+					{Name: "idx_age", TableName: "foo", RootPageNumber: 4, Columns: []string{"age"}},
+				},
+				stats: c.stats,
+			}
+			qp, err := NewSelect(mockCatalog, ast).QueryPlan()
+			if err != nil {
+				t.Fatalf("expected no err got err %s", err)
+			}
+			if got := qp.ToString(); got != c.expected {
+				t.Fatalf("expected\n%s\ngot\n%s", c.expected, got)
+			}
+		})
+	}
+}
+
+func TestConstantPredicate(t *testing.T) {
+	newAst := func(left, right int) *compiler.SelectStmt {
+		return &compiler.SelectStmt{
+			StmtBase: &compiler.StmtBase{},
+			From: &compiler.From{
+				TableName: "foo",
+			},
+			ResultColumns: []compiler.ResultColumn{
+				{
+					All: true,
+				},
+			},
+			Where: &compiler.BinaryExpr{
+				Left:     &compiler.IntLit{Value: left},
+				Right:    &compiler.IntLit{Value: right},
+				Operator: compiler.OpEq,
+			},
+		}
+	}
+
+	t.Run("AlwaysFalse", func(t *testing.T) {
+		qp, err := NewSelect(&mockSelectCatalog{}, newAst(1, 0)).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*emptyNode); !ok {
+			t.Fatalf("expected empty node but got %#v", pn.child)
+		}
+		if qp.transactionType != transactionTypeNone {
+			t.Fatalf("expected no transaction but got %d", qp.transactionType)
+		}
+	})
+
+	t.Run("AlwaysTrue", func(t *testing.T) {
+		qp, err := NewSelect(&mockSelectCatalog{}, newAst(1, 1)).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*scanNode); !ok {
+			t.Fatalf("expected scan node but got %#v", pn.child)
+		}
+	})
+
+	t.Run("AlwaysNull", func(t *testing.T) {
+		ast := newAst(1, 1)
+		ast.Where.(*compiler.BinaryExpr).Right = &compiler.NullLit{}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*emptyNode); !ok {
+			t.Fatalf("expected empty node but got %#v", pn.child)
+		}
+	})
+
+	t.Run("AlwaysFalseAnd", func(t *testing.T) {
+		ast := newAst(1, 1)
+		ast.Where = &compiler.BinaryExpr{
+			Left:     &compiler.NullLit{},
+			Operator: compiler.OpAnd,
+			Right:    &compiler.IntLit{Value: 0},
+		}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*emptyNode); !ok {
+			t.Fatalf("expected empty node but got %#v", pn.child)
+		}
+	})
+
+	t.Run("AlwaysTrueOr", func(t *testing.T) {
+		ast := newAst(1, 0)
+		ast.Where = &compiler.BinaryExpr{
+			Left:     ast.Where,
+			Operator: compiler.OpOr,
+			Right:    &compiler.IntLit{Value: 2},
+		}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*scanNode); !ok {
+			t.Fatalf("expected scan node but got %#v", pn.child)
+		}
+	})
+
+	t.Run("AlwaysFalseNot", func(t *testing.T) {
+		ast := newAst(1, 1)
+		ast.Where = &compiler.UnaryExpr{
+			Operator: compiler.OpNot,
+			Operand: &compiler.UnaryExpr{
+				Operator: compiler.OpNeg,
+				Operand:  &compiler.IntLit{Value: 1},
+			},
+		}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*emptyNode); !ok {
+			t.Fatalf("expected empty node but got %#v", pn.child)
+		}
+	})
+
+	t.Run("AlwaysIsNull", func(t *testing.T) {
+		ast := newAst(1, 1)
+		ast.Where = &compiler.UnaryExpr{
+			Operator: compiler.OpIsNull,
+			Operand:  &compiler.NullLit{},
+		}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		if _, ok := pn.child.(*scanNode); !ok {
+			t.Fatalf("expected scan node but got %#v", pn.child)
+		}
+	})
+}
+
+func TestCommonSubexpression(t *testing.T) {
+	add := func() compiler.Expr {
+		return &compiler.BinaryExpr{
+			Left:     &compiler.ColumnRef{Column: "id"},
+			Right:    &compiler.IntLit{Value: 1},
+			Operator: compiler.OpAdd,
+		}
+	}
+	ast := &compiler.SelectStmt{
+		StmtBase: &compiler.StmtBase{},
+		From: &compiler.From{
+			TableName: "foo",
+		},
+		ResultColumns: []compiler.ResultColumn{
+			{
+				Expression: add(),
+			},
+			{
+				Expression: &compiler.BinaryExpr{
+					Left:     add(),
+					Right:    &compiler.IntLit{Value: 2},
+					Operator: compiler.OpMul,
+				},
+			},
+		},
+		Where: &compiler.BinaryExpr{
+			Left:     add(),
+			Right:    &compiler.IntLit{Value: 3},
+			Operator: compiler.OpGt,
+		},
+	}
+	plan, err := NewSelect(&mockSelectCatalog{}, ast).ExecutionPlan()
+	if err != nil {
+		t.Fatalf("expected no err got err %s", err)
+	}
+	addCount := 0
+	for _, c := range plan.Commands {
+		if _, ok := c.(*vm.AddCmd); ok {
+			addCount += 1
+		}
+	}
+	if addCount != 1 {
+		t.Fatalf("expected id + 1 to be computed once but got %d", addCount)
+	}
+}
+
+func TestOrderBy(t *testing.T) {
+	newAst := func(term compiler.Expr) *compiler.SelectStmt {
+		return &compiler.SelectStmt{
+			StmtBase: &compiler.StmtBase{},
+			From: &compiler.From{
+				TableName: "foo",
+			},
+			ResultColumns: []compiler.ResultColumn{
+				{
+					All: true,
+				},
+			},
+			OrderBy: []compiler.OrderingTerm{
+				{Expr: term, Desc: true},
+			},
+		}
+	}
+
+	t.Run("SortsScan", func(t *testing.T) {
+		ast := newAst(&compiler.ColumnRef{Column: "name"})
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		on, ok := pn.child.(*orderNode)
+		if !ok {
+			t.Fatalf("expected order node but got %#v", pn.child)
+		}
+		if pn.cursorId != on.cursorId {
+			t.Fatalf("expected project to read sorter %d but got %d", on.cursorId, pn.cursorId)
+		}
+		if sn, ok := on.child.(*scanNode); !ok || sn.parent != on {
+			t.Fatalf("expected scan node with order parent but got %#v", on.child)
+		}
+		if len(on.terms) != 1 || !on.terms[0].desc {
+			t.Fatalf("expected one descending term but got %#v", on.terms)
+		}
+	})
+
+	t.Run("ScansInRowIdOrder", func(t *testing.T) {
+		ast := newAst(&compiler.ColumnRef{Column: "id"})
+		mockCatalog := &mockSelectCatalog{primaryKeyColumnName: "id"}
+		qp, err := NewSelect(mockCatalog, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		sn, ok := pn.child.(*scanNode)
+		if !ok || sn.parent != pn {
+			t.Fatalf("expected scan node with project parent but got %#v", pn.child)
+		}
+		if sn.order != scanDescending {
+			t.Fatalf("expected descending scan got %d", sn.order)
+		}
+		if pn.cursorId != sn.cursorId {
+			t.Fatalf("expected project to read scan %d but got %d", sn.cursorId, pn.cursorId)
+		}
+	})
+
+	t.Run("ScansIndex", func(t *testing.T) {
+		ast := newAst(&compiler.ColumnRef{Column: "name"})
+		ast.Where = &compiler.BinaryExpr{
+			Left:     &compiler.ColumnRef{Column: "id"},
+			Right:    &compiler.IntLit{Value: 1},
+			Operator: compiler.OpNe,
+		}
+		mockCatalog := &mockSelectCatalog{
+			primaryKeyColumnName: "id",
+			indexes: []catalog.Index{
+				{Name: "idx_name", TableName: "foo", RootPageNumber: 3, Columns: []string{"name"}},
+			},
+		}
+		qp, err := NewSelect(mockCatalog, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		fn, ok := pn.child.(*filterNode)
+		if !ok || fn.parent != pn {
+			t.Fatalf("expected filter node with project parent but got %#v", pn.child)
+		}
+		isn, ok := fn.child.(*indexScanNode)
+		if !ok || isn.parent != fn {
+			t.Fatalf("expected index scan node with filter parent but got %#v", fn.child)
+		}
+		if !isn.desc || !isn.covering {
+			t.Fatalf("expected descending covering index scan got %#v", isn)
+		}
+		if pn.cursorId != isn.cursorId {
+			t.Fatalf("expected project to read index %d but got %d", isn.cursorId, pn.cursorId)
+		}
+	})
+
+	t.Run("TermOutOfRange", func(t *testing.T) {
+		ast := newAst(&compiler.IntLit{Value: 3})
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errOrderByTermRange) {
+			t.Fatalf("expected err %s but got %v", errOrderByTermRange, err)
+		}
+	})
+}
+
+func TestGroupBy(t *testing.T) {
+	newAst := func(groupBy []compiler.Expr, resultColumns ...compiler.Expr) *compiler.SelectStmt {
+		stmt := &compiler.SelectStmt{
+			StmtBase: &compiler.StmtBase{},
+			From: &compiler.From{
+				TableName: "foo",
+			},
+			GroupBy: groupBy,
+		}
+		for _, e := range resultColumns {
+			stmt.ResultColumns = append(stmt.ResultColumns, compiler.ResultColumn{Expression: e})
+		}
+		return stmt
+	}
+
+	t.Run("GroupsScan", func(t *testing.T) {
+		ast := newAst(
+			[]compiler.Expr{&compiler.ColumnRef{Column: "name"}},
+			&compiler.ColumnRef{Column: "name"},
+			&compiler.FunctionExpr{FnType: compiler.FnCount},
+		)
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		an, ok := pn.child.(*aggregateNode)
+		if !ok {
+			t.Fatalf("expected aggregate node but got %#v", pn.child)
+		}
+		if pn.cursorId != an.cursorId {
+			t.Fatalf("expected project to read group table %d but got %d", an.cursorId, pn.cursorId)
+		}
+		if sn, ok := an.child.(*scanNode); !ok || sn.parent != an {
+			t.Fatalf("expected scan node with aggregate parent but got %#v", an.child)
+		}
+		if len(an.aggregates) != 1 {
+			t.Fatalf("expected one aggregate but got %d", len(an.aggregates))
+		}
+		for i, p := range pn.projections {
+			gc, ok := p.expr.(*groupColumn)
+			if !ok || gc.colIdx != i {
+				t.Fatalf("expected projection %d to be group column %d but got %#v", i, i, p.expr)
+			}
+		}
+	})
+
+	t.Run("CountWithWhere", func(t *testing.T) {
+		ast := newAst(nil, &compiler.FunctionExpr{FnType: compiler.FnCount})
+		ast.Where = &compiler.BinaryExpr{
+			Left:     &compiler.ColumnRef{Column: "name"},
+			Operator: compiler.OpEq,
+			Right:    &compiler.StringLit{Value: "a"},
+		}
+		qp, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		pn := qp.root.(*projectNode)
+		an, ok := pn.child.(*aggregateNode)
+		if !ok {
+			t.Fatalf("expected aggregate node but got %#v", pn.child)
+		}
+		if _, ok := an.child.(*filterNode); !ok {
+			t.Fatalf("expected filter node but got %#v", an.child)
+		}
+	})
+
+	t.Run("ColumnNotGrouped", func(t *testing.T) {
+		ast := newAst(
+			nil,
+			&compiler.ColumnRef{Column: "name"},
+			&compiler.FunctionExpr{FnType: compiler.FnCount},
+		)
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errColumnNotGrouped) {
+			t.Fatalf("expected err %s but got %v", errColumnNotGrouped, err)
+		}
+	})
+
+	t.Run("NoSuchFunction", func(t *testing.T) {
+		ast := newAst(nil, &compiler.FunctionExpr{
+			FnType: "AVERAGE",
+			Args:   []compiler.Expr{&compiler.ColumnRef{Column: "id"}},
+		})
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errNoFunction) {
+			t.Fatalf("expected err %s but got %v", errNoFunction, err)
+		}
+	})
+
+	t.Run("TermOutOfRange", func(t *testing.T) {
+		ast := newAst([]compiler.Expr{&compiler.IntLit{Value: 2}}, &compiler.ColumnRef{Column: "name"})
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errGroupByTermRange) {
+			t.Fatalf("expected err %s but got %v", errGroupByTermRange, err)
+		}
+	})
+}
+
+func TestScalarFunctions(t *testing.T) {
+	newAst := func(resultColumns ...compiler.Expr) *compiler.SelectStmt {
+		stmt := &compiler.SelectStmt{StmtBase: &compiler.StmtBase{}}
+		for _, e := range resultColumns {
+			stmt.ResultColumns = append(stmt.ResultColumns, compiler.ResultColumn{Expression: e})
+		}
+		return stmt
+	}
+
+	t.Run("Typeof", func(t *testing.T) {
+		ast := newAst(&compiler.FunctionExpr{
+			FnType: compiler.FnTypeof,
+			Args:   []compiler.Expr{&compiler.Variable{Position: 0}},
+		})
+		plan, err := NewSelect(&mockSelectCatalog{}, ast).ExecutionPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		expectedCommands := []vm.Command{
+			&vm.InitCmd{P2: 4},
+			&vm.TypeofCmd{P1: 2, P2: 1},
+			&vm.ResultRowCmd{P1: 1, P2: 1},
+			&vm.HaltCmd{},
+			&vm.VariableCmd{P1: 0, P2: 2},
+			&vm.GotoCmd{P2: 1},
+		}
+		if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+			t.Error(err)
+		}
+		want := []catalog.CdbType{{ID: catalog.CTStr}}
+		if !reflect.DeepEqual(plan.ResultTypes, want) {
+			t.Fatalf("expected result types %v got %v", want, plan.ResultTypes)
+		}
+	})
+
+	t.Run("OperatorWithVariableIsInteger", func(t *testing.T) {
+		ast := newAst(&compiler.BinaryExpr{
+			Left:     &compiler.Variable{Position: 0},
+			Operator: compiler.OpAdd,
+			Right:    &compiler.IntLit{Value: 1},
+		})
+		plan, err := NewSelect(&mockSelectCatalog{}, ast).ExecutionPlan()
+		if err != nil {
+			t.Fatalf("expected no err got err %s", err)
+		}
+		want := []catalog.CdbType{{ID: catalog.CTInt}}
+		if !reflect.DeepEqual(plan.ResultTypes, want) {
+			t.Fatalf("expected result types %v got %v", want, plan.ResultTypes)
+		}
+	})
+
+	t.Run("NoSuchFunction", func(t *testing.T) {
+		ast := newAst(&compiler.FunctionExpr{
+			FnType: "NOPE",
+			Args:   []compiler.Expr{&compiler.IntLit{Value: 1}},
+		})
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errNoFunction) {
+			t.Fatalf("expected err %s but got %v", errNoFunction, err)
+		}
+	})
+
+	t.Run("WrongArgumentCount", func(t *testing.T) {
+		ast := newAst(&compiler.FunctionExpr{FnType: compiler.FnTypeof, Args: []compiler.Expr{}})
+		_, err := NewSelect(&mockSelectCatalog{}, ast).QueryPlan()
+		if !errors.Is(err, errFunctionArgs) {
+			t.Fatalf("expected err %s but got %v", errFunctionArgs, err)
+		}
+	})
+}
+
+func TestUnknownColumn(t *testing.T) {
+	cases := []struct {
+		name string
+		stmt *compiler.SelectStmt
+	}{
+		{
+			name: "ResultColumn",
+			stmt: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				From:     &compiler.From{TableName: "foo"},
+				ResultColumns: []compiler.ResultColumn{
+					{Expression: &compiler.ColumnRef{Column: "nonexistent"}},
+				},
+			},
+		},
+		{
+			name: "Where",
+			stmt: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				From:     &compiler.From{TableName: "foo"},
+				ResultColumns: []compiler.ResultColumn{
+					{All: true},
+				},
+				Where: &compiler.BinaryExpr{
+					Left:     &compiler.ColumnRef{Column: "nonexistent"},
+					Operator: compiler.OpEq,
+					Right:    &compiler.IntLit{Value: 1},
+				},
+			},
+		},
+		{
+			name: "OrderBy",
+			stmt: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				From:     &compiler.From{TableName: "foo"},
+				ResultColumns: []compiler.ResultColumn{
+					{All: true},
+				},
+				OrderBy: []compiler.OrderingTerm{
+					{Expr: &compiler.ColumnRef{Column: "nonexistent"}},
+				},
+			},
+		},
+		{
+			name: "NoTable",
+			stmt: &compiler.SelectStmt{
+				StmtBase: &compiler.StmtBase{},
+				ResultColumns: []compiler.ResultColumn{
+					{Expression: &compiler.ColumnRef{Column: "nonexistent"}},
+				},
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			_, err := NewSelect(&mockSelectCatalog{}, c.stmt).QueryPlan()
+			if !errors.Is(err, errColumnNotExist) {
+				t.Fatalf("expected err %s but got %v", errColumnNotExist, err)
+			}
+			if !strings.Contains(err.Error(), "nonexistent") {
+				t.Fatalf("expected err to name the column but got %s", err)
+			}
+		})
+	}
+
+	t.Run("Suggestions", func(t *testing.T) {
+		_, err := NewSelect(&mockSelectCatalog{}, cases[0].stmt).QueryPlan()
+		want := "no such column: nonexistent (available columns: id, name)"
+		if err == nil || err.Error() != want {
+			t.Fatalf("expected err %q but got %v", want, err)
+		}
+	})
+}
+
+func TestAmbiguousColumn(t *testing.T) {
+	cases := []struct {
+		name    string
+		ref     *compiler.ColumnRef
+		err     error
+		wantErr string
+	}{
+		{
+			name:    "Unqualified",
+			ref:     &compiler.ColumnRef{Column: "name"},
+			err:     errAmbiguousColumn,
+			wantErr: "ambiguous column name: name (in tables: foo, bar)",
+		},
+		{
+			name: "Qualified",
+			ref:  &compiler.ColumnRef{Table: "bar", Column: "name"},
+		},
+		{
+			name:    "QualifiedUnknownTable",
+			ref:     &compiler.ColumnRef{Table: "baz", Column: "name"},
+			err:     errColumnNotExist,
+			wantErr: "no such column: baz.name",
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := bindExprTables(&mockSelectCatalog{}, []string{"foo", "bar"}, c.ref)
+			if c.err == nil {
+				if err != nil {
+					t.Fatalf("expected no err got %s", err)
+				}
+				return
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expected err %s but got %v", c.err, err)
+			}
+			if err.Error() != c.wantErr {
+				t.Fatalf("expected err %q but got %q", c.wantErr, err)
+			}
+		})
+	}
+}
+
+// joinCatalog is a mockSelectCatalog with the table bar besides foo.
+type joinCatalog struct {
+	mockSelectCatalog
+}
+
+func (m *joinCatalog) GetRootPageNumber(s string) (int, error) {
+	if s == "bar" {
+		return 3, nil
+	}
+	return m.mockSelectCatalog.GetRootPageNumber(s)
+}
+
+func TestLeftJoin(t *testing.T) {
+	stmt := &compiler.SelectStmt{
+		StmtBase: &compiler.StmtBase{},
+		From: &compiler.From{
+			TableName: "foo",
+			Joins: []compiler.Join{
+				{
+					Left:      true,
+					TableName: "bar",
+					On: &compiler.BinaryExpr{
+						Left:     &compiler.ColumnRef{Table: "foo", Column: "id"},
+						Operator: compiler.OpEq,
+						Right:    &compiler.ColumnRef{Table: "bar", Column: "id"},
+					},
+				},
+			},
+		},
+		ResultColumns: []compiler.ResultColumn{
+			{Expression: &compiler.ColumnRef{Table: "foo", Column: "name"}},
+			{Expression: &compiler.ColumnRef{Table: "bar", Column: "name"}},
+		},
+	}
+	mockCatalog := &joinCatalog{}
+	mockCatalog.primaryKeyColumnName = "id"
+	expectedCommands := []vm.Command{
+		&vm.InitCmd{P2: 21},
+		&vm.OpenReadCmd{P1: 4, P2: 3},
+		&vm.OpenReadCmd{P1: 1, P2: 2},
+		&vm.RewindCmd{P1: 1, P2: 20},
+		&vm.IntegerCmd{P1: 1, P2: 1},
+		&vm.RewindCmd{P1: 4, P2: 14},
+		&vm.RowIdCmd{P1: 1, P2: 2},
+		&vm.RowIdCmd{P1: 4, P2: 3},
+		&vm.NotEqualCmd{P1: 2, P2: 13, P3: 3, P5: 1},
+		&vm.IntegerCmd{P1: 0, P2: 1},
+		&vm.ColumnCmd{P1: 1, P2: 0, P3: 5},
+		&vm.ColumnCmd{P1: 4, P2: 0, P3: 6},
+		&vm.ResultRowCmd{P1: 5, P2: 2},
+		&vm.NextCmd{P1: 4, P2: 6},
+		&vm.IfNotCmd{P1: 1, P2: 19},
+		&vm.NullRowCmd{P1: 4},
+		&vm.ColumnCmd{P1: 1, P2: 0, P3: 7},
+		&vm.ColumnCmd{P1: 4, P2: 0, P3: 8},
+		&vm.ResultRowCmd{P1: 7, P2: 2},
+		&vm.NextCmd{P1: 1, P2: 4},
+		&vm.HaltCmd{},
+		&vm.TransactionCmd{P2: 0},
+		&vm.GotoCmd{P2: 1},
+	}
+	plan, err := NewSelect(mockCatalog, stmt).ExecutionPlan()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+		t.Error(err)
+	}
+}
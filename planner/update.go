@@ -1,7 +1,6 @@
 package planner
 
 import (
-	"errors"
 	"slices"
 
 	"github.com/chirst/cdb/catalog"
@@ -12,10 +11,12 @@ import (
 // updateCatalog is the required catalog methods for the update planner.
 type updateCatalog interface {
 	GetVersion() string
+	GetGeneration(tableName string) int
 	GetRootPageNumber(string) (int, error)
 	GetColumns(string) ([]string, error)
 	GetPrimaryKeyColumn(string) (string, error)
 	GetColumnType(tableName string, columnName string) (catalog.CdbType, error)
+	GetIndexes(tableName string) []catalog.Index
 }
 
 // updatePanner houses the query planner and execution planner for a update
@@ -29,24 +30,35 @@ type updatePlanner struct {
 
 // NewUpdate create a update planner.
 func NewUpdate(catalog updateCatalog, stmt *compiler.UpdateStmt) *updatePlanner {
+	executionPlan := vm.NewExecutionPlan(catalog.GetVersion(), stmt.Explain)
+	executionPlan.DependOn(stmt.TableName, catalog.GetGeneration(stmt.TableName))
+	executionPlan.ChangedTable = stmt.TableName
 	return &updatePlanner{
-		catalog: catalog,
-		stmt:    stmt,
-		executionPlan: vm.NewExecutionPlan(
-			catalog.GetVersion(),
-			stmt.Explain,
-		),
+		catalog:       catalog,
+		stmt:          stmt,
+		executionPlan: executionPlan,
 	}
 }
 
 // QueryPlan sets up a high level plan to be passed to ExecutionPlan.
 func (p *updatePlanner) QueryPlan() (*QueryPlan, error) {
+	if err := checkSchemaName(p.stmt.SchemaName); err != nil {
+		return nil, err
+	}
+	if err := checkTableWritable(p.stmt.TableName); err != nil {
+		return nil, err
+	}
 	rootPage, err := p.catalog.GetRootPageNumber(p.stmt.TableName)
 	if err != nil {
 		return nil, errTableNotExist
 	}
+	affinities, err := recordAffinities(p.catalog, p.stmt.TableName)
+	if err != nil {
+		return nil, err
+	}
 	updateNode := &updateNode{
 		updateExprs:    []compiler.Expr{},
+		affinities:     affinities,
 		tableName:      p.stmt.TableName,
 		rootPageNumber: rootPage,
 		cursorId:       1,
@@ -60,17 +72,20 @@ func (p *updatePlanner) QueryPlan() (*QueryPlan, error) {
 	p.queryPlan = updateNode
 	logicalPlan.root = updateNode
 
-	if err := p.errIfPrimaryKeySet(); err != nil {
+	if err := p.errIfSetNotOnDestinationTable(); err != nil {
 		return nil, err
 	}
 
-	if err := p.errIfSetNotOnDestinationTable(); err != nil {
+	if err := p.setQueryPlanRecordExpressions(); err != nil {
 		return nil, err
 	}
 
-	if err := p.setQueryPlanRecordExpressions(); err != nil {
+	indexes, err := getSecondaryIndexes(p.catalog, p.stmt.TableName, 2)
+	if err != nil {
 		return nil, err
 	}
+	updateNode.indexes = indexes
+	updateNode.sorterCursorId = 2 + len(indexes)
 
 	scanNode := &scanNode{
 		plan:           logicalPlan,
@@ -79,10 +94,40 @@ func (p *updatePlanner) QueryPlan() (*QueryPlan, error) {
 		cursorId:       1,
 		isWriteCursor:  true,
 	}
+	alwaysFalse := false
 	if p.stmt.Predicate != nil {
-		cev := &catalogExprVisitor{}
-		cev.Init(p.catalog, p.stmt.TableName)
-		p.stmt.Predicate.BreadthWalk(cev)
+		if err := checkExprSchemas(p.stmt.Predicate); err != nil {
+			return nil, err
+		}
+		if err := checkExprFunctions(p.stmt.Predicate); err != nil {
+			return nil, err
+		}
+		predicate, isConst, truth, err := foldPredicate(p.stmt.Predicate)
+		if err != nil {
+			return nil, err
+		}
+		p.stmt.Predicate = predicate
+		if isConst && truth {
+			p.stmt.Predicate = nil
+		}
+		alwaysFalse = isConst && !truth
+	}
+	if p.stmt.Limit != nil {
+		limit, err := p.foldLimit()
+		if err != nil {
+			return nil, err
+		}
+		if il, ok := limit.(*compiler.IntLit); ok && il.Value == 0 {
+			alwaysFalse = true
+		}
+		updateNode.limit = limit
+	}
+	if alwaysFalse {
+		updateNode.child = &emptyNode{plan: logicalPlan}
+	} else if p.stmt.Predicate != nil {
+		if err := bindExpr(p.catalog, p.stmt.TableName, p.stmt.Predicate); err != nil {
+			return nil, err
+		}
 		filterNode := &filterNode{
 			plan:      logicalPlan,
 			predicate: p.stmt.Predicate,
@@ -100,17 +145,20 @@ func (p *updatePlanner) QueryPlan() (*QueryPlan, error) {
 	return logicalPlan, nil
 }
 
-// errIfPrimaryKeySet checks the primary key isn't being updated because it
-// could cause an infinite loop if not handled properly.
-func (p *updatePlanner) errIfPrimaryKeySet() error {
-	pkColumnName, err := p.catalog.GetPrimaryKeyColumn(p.stmt.TableName)
+// foldLimit returns the limit folded to a literal or variable. The limit is
+// computed once before any row is updated so it cannot refer to a column.
+func (p *updatePlanner) foldLimit() (compiler.Expr, error) {
+	if err := checkExprFunctions(p.stmt.Limit); err != nil {
+		return nil, err
+	}
+	limit, err := foldExpr(p.stmt.Limit)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if _, ok := p.stmt.SetList[pkColumnName]; ok {
-		return errors.New("updating primary key not supported")
+	if !isConstant(limit) {
+		return nil, errLimitNotConstant
 	}
-	return nil
+	return limit, nil
 }
 
 // errIfSetNotOnDestinationTable checks the set list has column names that are
@@ -164,10 +212,20 @@ func (p *updatePlanner) setQueryPlanRecordExpressions() error {
 			idx += 1
 		}
 	}
-	for i := range p.queryPlan.updateExprs {
-		cev := &catalogExprVisitor{}
-		cev.Init(p.catalog, p.stmt.TableName)
-		p.queryPlan.updateExprs[i].BreadthWalk(cev)
+	p.queryPlan.pkExpr = p.stmt.SetList[pkColName]
+	for _, e := range append([]compiler.Expr{p.queryPlan.pkExpr}, p.queryPlan.updateExprs...) {
+		if e == nil {
+			continue
+		}
+		if err := checkExprSchemas(e); err != nil {
+			return err
+		}
+		if err := checkExprFunctions(e); err != nil {
+			return err
+		}
+		if err := bindExpr(p.catalog, p.stmt.TableName, e); err != nil {
+			return err
+		}
 	}
 	return nil
 }
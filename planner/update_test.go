@@ -11,10 +11,18 @@ import (
 
 type mockUpdateCatalog struct{}
 
+func (*mockUpdateCatalog) GetIndexes(tableName string) []catalog.Index {
+	return []catalog.Index{}
+}
+
 func (*mockUpdateCatalog) GetVersion() string {
 	return "mock"
 }
 
+func (*mockUpdateCatalog) GetGeneration(string) int {
+	return 0
+}
+
 func (*mockUpdateCatalog) GetRootPageNumber(tableName string) (int, error) {
 	if tableName == "foo" {
 		return 2, nil
@@ -61,9 +69,9 @@ func TestUpdate(t *testing.T) {
 		&vm.RowIdCmd{P1: 1, P2: 1},
 		&vm.ColumnCmd{P1: 1, P2: 0, P3: 2},
 		&vm.CopyCmd{P1: 4, P2: 3},
-		&vm.MakeRecordCmd{P1: 2, P2: 2, P3: 5},
+		&vm.MakeRecordCmd{P1: 2, P2: 2, P3: 5, P4: "II"},
 		&vm.DeleteCmd{P1: 1},
-		&vm.InsertCmd{P1: 1, P2: 5, P3: 1},
+		&vm.InsertCmd{P1: 1, P2: 5, P3: 1, P5: vm.CountChange},
 		&vm.NextCmd{P1: 1, P2: 3},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
@@ -108,9 +116,9 @@ func TestUpdateWithWhere(t *testing.T) {
 		&vm.RowIdCmd{P1: 1, P2: 3},
 		&vm.ColumnCmd{P1: 1, P2: 0, P3: 4},
 		&vm.CopyCmd{P1: 2, P2: 5},
-		&vm.MakeRecordCmd{P1: 4, P2: 2, P3: 6},
+		&vm.MakeRecordCmd{P1: 4, P2: 2, P3: 6, P4: "II"},
 		&vm.DeleteCmd{P1: 1},
-		&vm.InsertCmd{P1: 1, P2: 6, P3: 3},
+		&vm.InsertCmd{P1: 1, P2: 6, P3: 3, P5: vm.CountChange},
 		&vm.HaltCmd{},
 		&vm.TransactionCmd{P2: 1},
 		&vm.IntegerCmd{P1: 1, P2: 2},
@@ -125,3 +133,53 @@ func TestUpdateWithWhere(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestUpdatePrimaryKey(t *testing.T) {
+	ast := &compiler.UpdateStmt{
+		StmtBase:  &compiler.StmtBase{},
+		TableName: "foo",
+		SetList: map[string]compiler.Expr{
+			"id": &compiler.BinaryExpr{
+				Left:     &compiler.ColumnRef{Column: "id"},
+				Operator: compiler.OpAdd,
+				Right:    &compiler.IntLit{Value: 1},
+			},
+		},
+	}
+	expectedCommands := []vm.Command{
+		&vm.InitCmd{P2: 23},
+		&vm.SorterOpenCmd{P1: 2, P2: 1, P4: "ASC"},
+		&vm.OpenWriteCmd{P1: 1, P2: 2},
+		&vm.RewindCmd{P1: 1, P2: 8},
+		&vm.RowIdCmd{P1: 1, P2: 1},
+		&vm.MakeRecordCmd{P1: 1, P2: 1, P3: 2},
+		&vm.SorterInsertCmd{P1: 2, P2: 2, P3: 1},
+		&vm.NextCmd{P1: 1, P2: 4},
+		&vm.SorterSortCmd{P1: 2, P2: 22},
+		&vm.RowIdCmd{P1: 2, P2: 3},
+		&vm.RowIdCmd{P1: 2, P2: 5},
+		&vm.AddCmd{P1: 5, P2: 6, P3: 4},
+		&vm.MustBeIntCmd{P1: 4},
+		&vm.ColumnCmd{P1: 2, P2: 0, P3: 7},
+		&vm.ColumnCmd{P1: 2, P2: 1, P3: 8},
+		&vm.MakeRecordCmd{P1: 7, P2: 2, P3: 9, P4: "II"},
+		&vm.SeekRowId{P1: 1, P2: 21, P3: 3},
+		&vm.DeleteCmd{P1: 1},
+		&vm.NotExistsCmd{P1: 1, P2: 20, P3: 4},
+		&vm.HaltCmd{P1: 1, P4: pkConstraint},
+		&vm.InsertCmd{P1: 1, P2: 9, P3: 4, P5: vm.CountChange},
+		&vm.SorterNextCmd{P1: 2, P2: 9},
+		&vm.HaltCmd{},
+		&vm.TransactionCmd{P2: 1},
+		&vm.IntegerCmd{P1: 1, P2: 6},
+		&vm.GotoCmd{P2: 1},
+	}
+	mockCatalog := &mockUpdateCatalog{}
+	plan, err := NewUpdate(mockCatalog, ast).ExecutionPlan()
+	if err != nil {
+		t.Errorf("expected no err got err %s", err)
+	}
+	if err := assertCommandsMatch(plan.Commands, expectedCommands); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,167 @@
+package planner
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/chirst/cdb/catalog"
+	"github.com/chirst/cdb/compiler"
+	"github.com/chirst/cdb/kv"
+)
+
+// VirtualTable is a read only table with rows computed while planning rather
+// than stored in a b tree. Table valued functions such as explain produce a
+// VirtualTable.
+type VirtualTable struct {
+	// Columns are the names of the columns. The first column is an INTEGER
+	// PRIMARY KEY holding the position of the row.
+	Columns []string
+	// Types are the types of Columns.
+	Types []catalog.CdbType
+	// Rows are the values of each row excluding the primary key.
+	Rows [][]any
+	// Table holds the rows instead of Rows when it is not nil. The key of each
+	// row is its position and the value is the encoded row. Table is owned by
+	// whoever created it, which closes it once no plan reads it.
+	Table *kv.Ephemeral
+	// HidePrimaryKey is true when the primary key is left out of SELECT *
+	// since it is not one of the columns the table was defined with.
+	HidePrimaryKey bool
+}
+
+// TableFunction resolves the table valued function name called with args to a
+// VirtualTable.
+type TableFunction func(name string, args []any) (*VirtualTable, error)
+
+// Materializer computes the rows of a common table expression as a
+// VirtualTable.
+type Materializer func(cte compiler.CommonTableExpr) (*VirtualTable, error)
+
+// commonTable returns the common table expression of stmt named name.
+func commonTable(stmt *compiler.SelectStmt, name string) (compiler.CommonTableExpr, bool) {
+	i := slices.IndexFunc(stmt.With, func(cte compiler.CommonTableExpr) bool {
+		return cte.Name == name
+	})
+	if i == -1 {
+		return compiler.CommonTableExpr{}, false
+	}
+	return stmt.With[i], true
+}
+
+// withPreceding returns the select of cte given the common table expressions
+// preceding it in stmt so the select may name them in its FROM clause.
+func withPreceding(stmt *compiler.SelectStmt, cte compiler.CommonTableExpr) *compiler.SelectStmt {
+	i := slices.IndexFunc(stmt.With, func(c compiler.CommonTableExpr) bool {
+		return c.Name == cte.Name
+	})
+	s := *cte.Select
+	s.With = slices.Concat(stmt.With[:i], s.With)
+	return &s
+}
+
+// virtualTableCatalog answers catalog questions for a virtual table and defers
+// to the underlying catalog for every other table.
+type virtualTableCatalog struct {
+	selectCatalog
+	tableName string
+	table     *VirtualTable
+}
+
+func (v *virtualTableCatalog) GetColumns(tableName string) ([]string, error) {
+	if tableName != v.tableName {
+		return v.selectCatalog.GetColumns(tableName)
+	}
+	return v.table.Columns, nil
+}
+
+func (v *virtualTableCatalog) GetColumnType(tableName string, columnName string) (catalog.CdbType, error) {
+	if tableName != v.tableName {
+		return v.selectCatalog.GetColumnType(tableName, columnName)
+	}
+	i := slices.Index(v.table.Columns, columnName)
+	if i == -1 {
+		return catalog.CdbType{ID: catalog.CTUnknown}, fmt.Errorf("no type for table %s col %s", tableName, columnName)
+	}
+	return v.table.Types[i], nil
+}
+
+func (v *virtualTableCatalog) GetPrimaryKeyColumn(tableName string) (string, error) {
+	if tableName != v.tableName {
+		return v.selectCatalog.GetPrimaryKeyColumn(tableName)
+	}
+	return v.table.Columns[0], nil
+}
+
+// GetIndexes returns no indexes for the virtual table since it is not a b tree.
+func (v *virtualTableCatalog) GetIndexes(tableName string) []catalog.Index {
+	if tableName != v.tableName {
+		return v.selectCatalog.GetIndexes(tableName)
+	}
+	return []catalog.Index{}
+}
+
+// tableFunctionArgs converts the arguments of a table valued function to
+// values. Arguments must be constant since they are evaluated while planning.
+func tableFunctionArgs(exprs []compiler.Expr) ([]any, error) {
+	args := []any{}
+	for _, e := range exprs {
+		e, err := foldExpr(e)
+		if err != nil {
+			return nil, err
+		}
+		switch t := e.(type) {
+		case *compiler.StringLit:
+			args = append(args, t.Value)
+		case *compiler.IntLit:
+			args = append(args, t.Value)
+		default:
+			return nil, errTableFunctionArg
+		}
+	}
+	return args, nil
+}
+
+// variableExprVisitor finds variables in an expression.
+type variableExprVisitor struct {
+	found bool
+}
+
+// selectHasVariable returns true when stmt or one of its common table
+// expressions has a variable.
+func selectHasVariable(stmt *compiler.SelectStmt) bool {
+	v := &variableExprVisitor{}
+	exprs := []compiler.Expr{stmt.Where}
+	for _, rc := range stmt.ResultColumns {
+		exprs = append(exprs, rc.Expression)
+	}
+	exprs = append(exprs, stmt.GroupBy...)
+	for _, term := range stmt.OrderBy {
+		exprs = append(exprs, term.Expr)
+	}
+	for _, join := range fromJoins(stmt) {
+		exprs = append(exprs, join.Args...)
+		exprs = append(exprs, join.On)
+	}
+	for _, e := range exprs {
+		if e != nil {
+			e.BreadthWalk(v)
+		}
+	}
+	return v.found || slices.ContainsFunc(stmt.With, func(cte compiler.CommonTableExpr) bool {
+		return selectHasVariable(cte.Select)
+	})
+}
+
+func (v *variableExprVisitor) VisitVariable(e *compiler.Variable) {
+	v.found = true
+}
+
+func (v *variableExprVisitor) VisitBinaryExpr(e *compiler.BinaryExpr)     {}
+func (v *variableExprVisitor) VisitUnaryExpr(e *compiler.UnaryExpr)       {}
+func (v *variableExprVisitor) VisitColumnRefExpr(e *compiler.ColumnRef)   {}
+func (v *variableExprVisitor) VisitIntLit(e *compiler.IntLit)             {}
+func (v *variableExprVisitor) VisitFloatLit(e *compiler.FloatLit)         {}
+func (v *variableExprVisitor) VisitStringLit(e *compiler.StringLit)       {}
+func (v *variableExprVisitor) VisitNullLit(e *compiler.NullLit)           {}
+func (v *variableExprVisitor) VisitFunctionExpr(e *compiler.FunctionExpr) {}
+func (v *variableExprVisitor) VisitCastExpr(e *compiler.CastExpr)         {}
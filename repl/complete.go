@@ -0,0 +1,95 @@
+package repl
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/chirst/cdb/compiler"
+)
+
+// autoComplete is the terminal AutoCompleteCallback. Pressing tab completes the
+// word before the cursor with a keyword, table name, or column name. Names are
+// read from the catalog on every completion so they are current after DDL.
+func (r *repl) autoComplete(line string, pos int, key rune) (string, int, bool) {
+	if key != '\t' {
+		return "", 0, false
+	}
+	return complete(line, pos, compiler.Keywords(), r.schemaNames())
+}
+
+// schemaNames returns every table and column name in the catalog.
+func (r *repl) schemaNames() []string {
+	names := []string{}
+	for _, table := range r.db.TableNames() {
+		names = append(names, table)
+		columns, err := r.db.ColumnNames(table)
+		if err != nil {
+			continue
+		}
+		names = append(names, columns...)
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// complete extends the word ending at pos in line to the longest prefix shared
+// by every matching keyword or name. Words match case insensitively. Keywords
+// are completed in lower case when the word is typed in lower case while names
+// are completed as they are defined. ok is false when there is nothing to
+// complete.
+func complete(line string, pos int, keywords, names []string) (newLine string, newPos int, ok bool) {
+	start := pos
+	for start > 0 && isWordRune(rune(line[start-1])) {
+		start -= 1
+	}
+	word := line[start:pos]
+	if word == "" {
+		return "", 0, false
+	}
+	lowerKeywords := strings.ToLower(word) == word
+	matches := []string{}
+	for _, k := range keywords {
+		if lowerKeywords {
+			k = strings.ToLower(k)
+		}
+		if hasPrefixFold(k, word) {
+			matches = append(matches, k)
+		}
+	}
+	for _, n := range names {
+		if hasPrefixFold(n, word) {
+			matches = append(matches, n)
+		}
+	}
+	if len(matches) == 0 {
+		return "", 0, false
+	}
+	completion := matches[0]
+	for _, m := range matches[1:] {
+		completion = commonPrefixFold(completion, m)
+	}
+	if len(completion) <= len(word) {
+		return "", 0, false
+	}
+	newLine = line[:start] + completion + line[pos:]
+	return newLine, start + len(completion), true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+func hasPrefixFold(s, prefix string) bool {
+	return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+}
+
+// commonPrefixFold returns the case insensitive common prefix of a and b using
+// the case of a.
+func commonPrefixFold(a, b string) string {
+	i := 0
+	for i < len(a) && i < len(b) && strings.EqualFold(a[i:i+1], b[i:i+1]) {
+		i += 1
+	}
+	return a[:i]
+}
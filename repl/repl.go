@@ -8,10 +8,12 @@ import (
 	"os"
 	"os/signal"
 	"slices"
+	"strconv"
 	"strings"
 	"syscall"
 
 	"github.com/chirst/cdb/db"
+	"github.com/chirst/cdb/vm"
 	"golang.org/x/term"
 )
 
@@ -25,22 +27,42 @@ const (
 	// promptContinued is the prompt when it is pending termination for example
 	// by a semi colon.
 	promptContinued = "...> "
+	// promptMore is the prompt when a result is paused between pages.
+	promptMore = "--More-- (enter for more, q to quit) "
+	// pageReservedLines is the number of terminal lines that are not rows when
+	// a page is displayed. These are the two header lines and promptMore.
+	pageReservedLines = 3
 )
 
 type repl struct {
 	db       *db.DB
 	terminal *term.Terminal
+	// maxRows is the number of rows displayed before pausing for the user to
+	// ask for more. 0 means results are never paused.
+	maxRows int
 }
 
 func New(db *db.DB) *repl {
 	r := &repl{
 		db:       db,
 		terminal: term.NewTerminal(os.Stdin, prompt),
+		maxRows:  defaultMaxRows(),
 	}
+	r.terminal.AutoCompleteCallback = r.autoComplete
 	r.loadHistory()
 	return r
 }
 
+// defaultMaxRows fits a page to the height of the terminal. Results are not
+// paged when the height is unknown.
+func defaultMaxRows() int {
+	_, height, err := term.GetSize(int(os.Stdin.Fd()))
+	if err != nil {
+		return 0
+	}
+	return max(height-pageReservedLines, 1)
+}
+
 func (r *repl) Run() {
 	r.writeLn("Welcome to cdb. Type .exit to exit")
 	if r.db.UseMemory {
@@ -70,10 +92,7 @@ func (r *repl) Run() {
 			continue
 		}
 		if input[0] == '.' {
-			if input == ".exit" {
-				r.exitGracefully()
-			}
-			r.writeLn("Command not supported")
+			r.runCommand(input)
 			continue
 		}
 
@@ -94,13 +113,53 @@ func (r *repl) Run() {
 				r.writeLn(result.Text)
 			}
 			if len(result.ResultRows) != 0 {
-				r.writeLn(r.printRows(result.ResultHeader, result.ResultRows))
+				r.pageRows(result.ResultHeader, result.ResultRows, r.writeLn, r.more)
+			}
+			if result.RowsAffected != 0 {
+				r.writeLn("Rows affected: " + strconv.Itoa(result.RowsAffected))
 			}
 			r.writeLn("Time: " + result.Duration.String())
 		}
 	}
 }
 
+// runCommand runs a dot command such as .exit.
+func (r *repl) runCommand(input string) {
+	fields := strings.Fields(input)
+	switch fields[0] {
+	case ".exit":
+		r.exitGracefully()
+	case ".maxrows":
+		if len(fields) == 1 {
+			r.writeLn(strconv.Itoa(r.maxRows))
+			return
+		}
+		n, err := strconv.Atoi(fields[1])
+		if err != nil || n < 0 || len(fields) > 2 {
+			r.writeLn("Usage: .maxrows [n] where 0 disables paging")
+			return
+		}
+		r.maxRows = n
+	default:
+		r.writeLn("Command not supported")
+	}
+}
+
+// more asks the user whether to display the next page of a result. The answer
+// is not echoed or saved to history.
+func (r *repl) more() bool {
+	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
+	if err != nil {
+		panic(err)
+	}
+	defer term.Restore(int(os.Stdin.Fd()), oldState)
+	answer, err := r.terminal.ReadPassword(promptMore)
+	if err != nil {
+		return false
+	}
+	return !strings.EqualFold(strings.TrimSpace(answer), "q")
+}
+
 func (r *repl) readLine(previousInput string) string {
 	oldState, err := term.MakeRaw(int(os.Stdin.Fd()))
 	if err != nil {
@@ -133,7 +192,7 @@ func (r *repl) writeWarning(text string) {
 	r.terminal.Write(r.terminal.Escape.Reset)
 }
 
-func (r *repl) printRows(resultHeader []string, resultRows [][]*string) string {
+func (r *repl) printRows(resultHeader []string, resultRows [][]vm.Value) string {
 	ret := ""
 	widths := r.getWidths(resultHeader, resultRows)
 	ret += r.printHeader(resultHeader, widths)
@@ -148,7 +207,28 @@ func (r *repl) printRows(resultHeader []string, resultRows [][]*string) string {
 	return ret
 }
 
-func (*repl) getWidths(header []string, rows [][]*string) []int {
+// pageRows writes the rows maxRows at a time with write. After each full page
+// more is called and the remaining rows are skipped if it returns false.
+// Columns are sized to every row so pages line up.
+func (r *repl) pageRows(resultHeader []string, resultRows [][]vm.Value, write func(string), more func() bool) {
+	if r.maxRows == 0 || len(resultRows) <= r.maxRows {
+		write(r.printRows(resultHeader, resultRows))
+		return
+	}
+	widths := r.getWidths(resultHeader, resultRows)
+	write(r.printHeader(resultHeader, widths))
+	for i, row := range resultRows {
+		write(r.printRow(row, widths))
+		shown := i + 1
+		if shown%r.maxRows == 0 && shown < len(resultRows) && !more() {
+			write(fmt.Sprintf("(%d of %d rows)", shown, len(resultRows)))
+			break
+		}
+	}
+	write("")
+}
+
+func (*repl) getWidths(header []string, rows [][]vm.Value) []int {
 	widths := make([]int, len(rows[0]))
 	for i := range widths {
 		widths[i] = 0
@@ -165,8 +245,8 @@ func (*repl) getWidths(header []string, rows [][]*string) []int {
 	for _, row := range rows {
 		for i, column := range row {
 			size := len(emptyRowValue)
-			if column != nil {
-				size = len(*column)
+			if !column.IsNull() {
+				size = len(column.Text())
 			}
 			if widths[i] < size {
 				widths[i] = size
@@ -198,12 +278,12 @@ func (*repl) printHeader(row []string, widths []int) string {
 	return ret
 }
 
-func (*repl) printRow(row []*string, widths []int) string {
+func (*repl) printRow(row []vm.Value, widths []int) string {
 	ret := ""
 	for i, column := range row {
 		v := emptyRowValue
-		if column != nil {
-			v = *column
+		if !column.IsNull() {
+			v = column.Text()
 		}
 		ret = ret + fmt.Sprintf(" %-*s ", widths[i], v)
 		if i != len(row)-1 {
@@ -1,9 +1,13 @@
 package repl
 
-import "testing"
+import (
+	"testing"
 
-func makeStr(s string) *string {
-	return &s
+	"github.com/chirst/cdb/vm"
+)
+
+func makeText(s string) vm.Value {
+	return vm.TextValue(s)
 }
 
 func TestPrint(t *testing.T) {
@@ -12,22 +16,22 @@ func TestPrint(t *testing.T) {
 		"id",
 		"name",
 	}
-	resultRows := [][]*string{
+	resultRows := [][]vm.Value{
 		{
-			makeStr("1"),
-			makeStr("gud name"),
+			makeText("1"),
+			makeText("gud name"),
 		},
 		{
-			makeStr("2"),
-			makeStr("gudder name"),
+			makeText("2"),
+			makeText("gudder name"),
 		},
 		{
-			makeStr("3"),
-			makeStr("guddest name"),
+			makeText("3"),
+			makeText("guddest name"),
 		},
 		{
-			makeStr("4"),
-			nil,
+			makeText("4"),
+			vm.NullValue(),
 		},
 	}
 	result := repl.printRows(resultHeader, resultRows)
@@ -46,9 +50,9 @@ func TestPrint(t *testing.T) {
 func TestPrintCount(t *testing.T) {
 	repl := New(nil)
 	resultHeader := []string{""}
-	resultRows := [][]*string{
+	resultRows := [][]vm.Value{
 		{
-			makeStr("1"),
+			makeText("1"),
 		},
 	}
 	result := repl.printRows(resultHeader, resultRows)
@@ -60,3 +64,95 @@ func TestPrintCount(t *testing.T) {
 		t.Errorf("\nwant\n%s\ngot\n%s\n", e, result)
 	}
 }
+
+func TestPageRows(t *testing.T) {
+	resultHeader := []string{"id"}
+	resultRows := [][]vm.Value{
+		{makeText("1")},
+		{makeText("2")},
+		{makeText("3")},
+		{makeText("4")},
+		{makeText("5")},
+	}
+	cases := []struct {
+		name      string
+		maxRows   int
+		answers   []bool
+		want      string
+		wantPages int
+	}{
+		{
+			name:    "disabled",
+			maxRows: 0,
+			want:    " id \n----\n 1  \n 2  \n 3  \n 4  \n 5  \n\n",
+		},
+		{
+			name:      "all pages",
+			maxRows:   2,
+			answers:   []bool{true, true},
+			want:      " id \n----\n 1  \n 2  \n 3  \n 4  \n 5  \n\n",
+			wantPages: 2,
+		},
+		{
+			name:      "quit",
+			maxRows:   2,
+			answers:   []bool{true, false},
+			want:      " id \n----\n 1  \n 2  \n 3  \n 4  \n(4 of 5 rows)\n\n",
+			wantPages: 2,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			repl := New(nil)
+			repl.maxRows = c.maxRows
+			got := ""
+			write := func(s string) {
+				got += s + "\n"
+			}
+			pages := 0
+			more := func() bool {
+				answer := c.answers[pages]
+				pages += 1
+				return answer
+			}
+			repl.pageRows(resultHeader, resultRows, write, more)
+			if got != c.want {
+				t.Errorf("\nwant\n%s\ngot\n%s\n", c.want, got)
+			}
+			if pages != c.wantPages {
+				t.Errorf("want %d pages got %d", c.wantPages, pages)
+			}
+		})
+	}
+}
+
+func TestComplete(t *testing.T) {
+	keywords := []string{"SELECT", "SET", "FROM"}
+	names := []string{"foo", "foobar", "id"}
+	cases := []struct {
+		line     string
+		pos      int
+		wantLine string
+		wantPos  int
+		wantOk   bool
+	}{
+		{line: "SEL", pos: 3, wantLine: "SELECT", wantPos: 6, wantOk: true},
+		{line: "sel", pos: 3, wantLine: "select", wantPos: 6, wantOk: true},
+		{line: "SELECT * FROM fo", pos: 16, wantLine: "SELECT * FROM foo", wantPos: 17, wantOk: true},
+		{line: "SELECT i FROM foo", pos: 8, wantLine: "SELECT id FROM foo", wantPos: 9, wantOk: true},
+		{line: "SE", pos: 2, wantOk: false},
+		{line: "SELECT ", pos: 7, wantOk: false},
+		{line: "zzz", pos: 3, wantOk: false},
+	}
+	for _, c := range cases {
+		t.Run(c.line, func(t *testing.T) {
+			gotLine, gotPos, gotOk := complete(c.line, c.pos, keywords, names)
+			if gotOk != c.wantOk {
+				t.Fatalf("want ok %t got %t", c.wantOk, gotOk)
+			}
+			if gotLine != c.wantLine || gotPos != c.wantPos {
+				t.Fatalf("want %q at %d got %q at %d", c.wantLine, c.wantPos, gotLine, gotPos)
+			}
+		})
+	}
+}
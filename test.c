@@ -16,9 +16,15 @@ void printSuccess(char* message) {
     printf("\033[0;32m%s\033[0m\n", message);
 }
 
+// conn is the connection the tests prepare statements with.
+int conn = 0;
+
 void openInMemoryDatabase() {
     int errCode = cdb_new_db(":memory:");
     assert(errCode == 0);
+    errCode = cdb_open_conn(&conn, ":memory:");
+    assert(errCode == 0);
+    assert(conn != 0);
 }
 
 void testCreate() {
@@ -27,7 +33,7 @@ void testCreate() {
     char* prepareErr = "";
     int errCode = cdb_prepare(
         &prepareId,
-        ":memory:",
+        conn,
          "CREATE TABLE IF NOT EXISTS foo (id INTEGER PRIMARY KEY, name TEXT);",
         &prepareErr
     );
@@ -54,7 +60,7 @@ void testInsert() {
     char* prepareErr = "";
     int errCode = cdb_prepare(
         &prepareId,
-        ":memory:",
+        conn,
          "INSERT INTO foo (id, name) VALUES (?, ?);",
         &prepareErr
     );
@@ -81,13 +87,60 @@ void testInsert() {
     assert(errCode == 0);
 }
 
+void testInsertMany() {
+    // Prepare
+    int prepareId = 0;
+    char* prepareErr = "";
+    int errCode = cdb_prepare(
+        &prepareId,
+        conn,
+         "INSERT INTO foo (id, name) VALUES (?, ?);",
+        &prepareErr
+    );
+    assert(strcmp(prepareErr, "") == 0);
+    assert(prepareId != 0);
+    assert(errCode == 0);
+
+    // Bind params for two rows
+    errCode = cdb_bind_int(prepareId, 2);
+    assert(errCode == 0);
+    errCode = cdb_bind_string(prepareId, "many1");
+    assert(errCode == 0);
+    errCode = cdb_bind_int(prepareId, 3);
+    assert(errCode == 0);
+    errCode = cdb_bind_string(prepareId, "many2");
+    assert(errCode == 0);
+
+    // Params that cannot be split into rows
+    errCode = cdb_execute_many(prepareId, 3);
+    assert(errCode == 1);
+
+    // Execute
+    errCode = cdb_execute_many(prepareId, 2);
+    assert(errCode == 0);
+
+    // Check result for errors
+    int hasErr = 0;
+    char* errMessage = "";
+    errCode = cdb_result_err(prepareId, &hasErr, &errMessage);
+    assert(hasErr == 0);
+    assert(strcmp(errMessage, "") == 0);
+    assert(errCode == 0);
+
+    // Check rows affected
+    long long int rowsAffected = 0;
+    errCode = cdb_result_rows_affected(prepareId, &rowsAffected);
+    assert(errCode == 0);
+    assert(rowsAffected == 2);
+}
+
 void testSelect() {
     // Prepare
     int prepareId = 0;
     char* prepareErr = "";
     int errCode = cdb_prepare(
         &prepareId,
-        ":memory:",
+        conn,
          "SELECT * FROM foo;",
         &prepareErr
     );
@@ -144,7 +197,7 @@ void testSelect() {
     assert(strcmp(nameColName, "name") == 0);
 
     // Check value of id column
-    int rowId = 0;
+    long long rowId = 0;
     errCode = cdb_result_col_int(prepareId, 0, &rowId);
     assert(errCode == 0);
     assert(rowId == 1);
@@ -170,7 +223,7 @@ void testParameterizedResultColumn() {
     char* prepareErr = "";
     int errCode = cdb_prepare(
         &prepareId,
-        ":memory:",
+        conn,
          "SELECT ? FROM foo;",
         &prepareErr
     );
@@ -193,6 +246,204 @@ void testParameterizedResultColumn() {
     assert(resultType == 1);
 }
 
+// testLargeInt is to test an int that does not fit in 32 bits round trips
+// through bind and result without being truncated.
+void testLargeInt() {
+    // Prepare
+    int prepareId = 0;
+    char* prepareErr = "";
+    int errCode = cdb_prepare(
+        &prepareId,
+        conn,
+         "SELECT ? FROM foo;",
+        &prepareErr
+    );
+    assert(errCode == 0);
+    assert(prepareId != 0);
+    assert(strcmp(prepareErr, "") == 0);
+
+    // Bind int larger than 32 bits
+    long long large = 9007199254740993LL;
+    errCode = cdb_bind_int(prepareId, large);
+    assert(errCode == 0);
+
+    // Execute
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+
+    // Move to the row
+    int hasRow = 0;
+    errCode = cdb_result_row(prepareId, &hasRow);
+    assert(errCode == 0);
+    assert(hasRow == 1);
+
+    // Assert the value is not truncated
+    long long result = 0;
+    errCode = cdb_result_col_int(prepareId, 0, &result);
+    assert(errCode == 0);
+    assert(result == large);
+}
+
+// testTypedColumns is to test a float, blob and NULL column are read with the
+// accessor for their type.
+void testTypedColumns() {
+    // Prepare
+    int prepareId = 0;
+    char* prepareErr = "";
+    int errCode = cdb_prepare(
+        &prepareId,
+        conn,
+         "SELECT CAST('1.5' AS REAL), CAST('ab' AS BLOB), NULL;",
+        &prepareErr
+    );
+    assert(errCode == 0);
+    assert(prepareId != 0);
+    assert(strcmp(prepareErr, "") == 0);
+
+    // Execute
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+
+    // Move to the row
+    int hasRow = 0;
+    errCode = cdb_result_row(prepareId, &hasRow);
+    assert(errCode == 0);
+    assert(hasRow == 1);
+
+    // Check value of float column
+    double f = 0;
+    errCode = cdb_result_col_float(prepareId, 0, &f);
+    assert(errCode == 0);
+    assert(f == 1.5);
+
+    // Check value of blob column
+    void* blob = NULL;
+    int length = 0;
+    errCode = cdb_result_col_blob(prepareId, 1, &blob, &length);
+    assert(errCode == 0);
+    assert(length == 2);
+    assert(memcmp(blob, "ab", 2) == 0);
+
+    // Check only the NULL column is NULL
+    int isNull = 0;
+    errCode = cdb_result_col_is_null(prepareId, 2, &isNull);
+    assert(errCode == 0);
+    assert(isNull == 1);
+    errCode = cdb_result_col_is_null(prepareId, 0, &isNull);
+    assert(errCode == 0);
+    assert(isNull == 0);
+
+    // NULL is not a number
+    errCode = cdb_result_col_float(prepareId, 2, &f);
+    assert(errCode == 1);
+}
+
+// countFooRowsWithId returns the count of rows in foo with the given id read
+// with the connection connId.
+long long countFooRowsWithId(int connId, long long id) {
+    int prepareId = 0;
+    char* prepareErr = "";
+    int errCode = cdb_prepare(
+        &prepareId,
+        connId,
+         "SELECT COUNT(*) FROM foo WHERE id = ?;",
+        &prepareErr
+    );
+    assert(errCode == 0);
+    errCode = cdb_bind_int(prepareId, id);
+    assert(errCode == 0);
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+    int hasRow = 0;
+    errCode = cdb_result_row(prepareId, &hasRow);
+    assert(errCode == 0);
+    assert(hasRow == 1);
+    long long count = 0;
+    errCode = cdb_result_col_int(prepareId, 0, &count);
+    assert(errCode == 0);
+    cdb_close_statement(prepareId);
+    return count;
+}
+
+// testTransaction is to test statements between cdb_begin and cdb_rollback are
+// discarded and statements between cdb_begin and cdb_commit are kept.
+void testTransaction() {
+    // Prepare
+    int prepareId = 0;
+    char* prepareErr = "";
+    int errCode = cdb_prepare(
+        &prepareId,
+        conn,
+         "INSERT INTO foo (id, name) VALUES (10, 'tx');",
+        &prepareErr
+    );
+    assert(errCode == 0);
+    assert(prepareId != 0);
+
+    // Nothing to commit without a transaction
+    errCode = cdb_commit(conn);
+    assert(errCode == 1);
+
+    // Rolled back insert is discarded
+    errCode = cdb_begin(conn, 0);
+    assert(errCode == 0);
+    errCode = cdb_begin(conn, 0);
+    assert(errCode == 1);
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+    errCode = cdb_rollback(conn);
+    assert(errCode == 0);
+    assert(countFooRowsWithId(conn, 10) == 0);
+
+    // Committed insert is kept
+    errCode = cdb_begin(conn, 0);
+    assert(errCode == 0);
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+    errCode = cdb_commit(conn);
+    assert(errCode == 0);
+    assert(countFooRowsWithId(conn, 10) == 1);
+}
+
+// testConnections is to test each connection has its own transaction so a
+// statement of another connection does not see the uncommitted insert.
+void testConnections() {
+    int other = 0;
+    int errCode = cdb_open_conn(&other, ":memory:");
+    assert(errCode == 0);
+    assert(other != 0);
+    assert(other != conn);
+    errCode = cdb_open_conn(&other, "missing");
+    assert(errCode == 1);
+
+    int prepareId = 0;
+    char* prepareErr = "";
+    errCode = cdb_prepare(
+        &prepareId,
+        conn,
+         "INSERT INTO foo (id, name) VALUES (20, 'conn');",
+        &prepareErr
+    );
+    assert(errCode == 0);
+
+    errCode = cdb_begin(conn, 0);
+    assert(errCode == 0);
+    errCode = cdb_execute(prepareId);
+    assert(errCode == 0);
+    // The other connection has no transaction in progress
+    errCode = cdb_commit(other);
+    assert(errCode == 1);
+    assert(countFooRowsWithId(other, 20) == 0);
+    errCode = cdb_commit(conn);
+    assert(errCode == 0);
+    assert(countFooRowsWithId(other, 20) == 1);
+
+    cdb_close_statement(prepareId);
+    cdb_close_conn(other);
+    errCode = cdb_begin(other, 0);
+    assert(errCode == 1);
+}
+
 int main() {
     printInfo("C tests started");
 
@@ -201,6 +452,11 @@ int main() {
     testInsert();
     testSelect();
     testParameterizedResultColumn();
+    testLargeInt();
+    testInsertMany();
+    testTypedColumns();
+    testTransaction();
+    testConnections();
 
     printSuccess("C tests finished successfully");
     return 0;
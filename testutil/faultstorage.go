@@ -0,0 +1,175 @@
+// Package testutil provides helpers for testing programs built on cdb. The
+// helpers are shipped as a regular package so downstream users can run the
+// same durability tests cdb runs against itself.
+package testutil
+
+import (
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/chirst/cdb/pager"
+)
+
+var (
+	// ErrInjected is returned by operations a FaultStorage was told to fail.
+	ErrInjected = errors.New("injected storage fault")
+	// ErrCrashed is returned by every write after a FaultStorage has simulated
+	// a crash with a torn write.
+	ErrCrashed = errors.New("storage crashed")
+)
+
+// FaultKind is the kind of failure a FaultStorage injects into a write.
+type FaultKind int
+
+const (
+	// FaultError fails the write without writing any bytes.
+	FaultError FaultKind = iota
+	// FaultShortWrite writes the first half of the bytes and returns
+	// io.ErrShortWrite.
+	FaultShortWrite
+	// FaultTornWrite writes the first half of the bytes and simulates a crash.
+	// The torn write and every write after it returns ErrCrashed. This mimics
+	// a power loss in the middle of writing a page.
+	FaultTornWrite
+)
+
+// FaultStorage decorates pager.Storage with the ability to inject failures.
+// Faults are scheduled by counting calls to WriteAt. Reads are always passed
+// through so the state left behind by a fault can be inspected.
+type FaultStorage struct {
+	pager.Storage
+	mu sync.Mutex
+	// writes is the number of calls to WriteAt.
+	writes int
+	// faultAt is the write number that will fail. 0 means no fault is
+	// scheduled.
+	faultAt int
+	// faultKind is the kind of failure for the write at faultAt.
+	faultKind FaultKind
+	// crashed is true once a torn write has happened.
+	crashed bool
+	// createJournalErr is returned by CreateJournal when not nil.
+	createJournalErr error
+	// deleteJournalErr is returned by DeleteJournal when not nil.
+	deleteJournalErr error
+}
+
+// NewFaultStorage wraps s. No faults are injected until they are scheduled.
+func NewFaultStorage(s pager.Storage) *FaultStorage {
+	return &FaultStorage{Storage: s}
+}
+
+// FailWrite schedules the nth call to WriteAt from now to fail with kind. n
+// starts at 1 meaning the next write.
+func (f *FaultStorage) FailWrite(n int, kind FaultKind) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faultAt = f.writes + n
+	f.faultKind = kind
+}
+
+// FailCreateJournal makes CreateJournal return err. A nil err stops failing.
+func (f *FaultStorage) FailCreateJournal(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.createJournalErr = err
+}
+
+// FailDeleteJournal makes DeleteJournal return err. A nil err stops failing.
+func (f *FaultStorage) FailDeleteJournal(err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.deleteJournalErr = err
+}
+
+// Reset clears scheduled faults and recovers from a simulated crash.
+func (f *FaultStorage) Reset() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.faultAt = 0
+	f.crashed = false
+	f.createJournalErr = nil
+	f.deleteJournalErr = nil
+}
+
+// Writes returns the number of calls to WriteAt.
+func (f *FaultStorage) Writes() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.writes
+}
+
+// Crashed returns true if a torn write has simulated a crash.
+func (f *FaultStorage) Crashed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.crashed
+}
+
+// WriteAt implements io.WriterAt and injects the scheduled fault.
+func (f *FaultStorage) WriteAt(p []byte, off int64) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.writes += 1
+	if f.crashed {
+		return 0, ErrCrashed
+	}
+	if f.writes != f.faultAt {
+		return f.Storage.WriteAt(p, off)
+	}
+	switch f.faultKind {
+	case FaultShortWrite:
+		n, err := f.Storage.WriteAt(p[:len(p)/2], off)
+		if err != nil {
+			return n, err
+		}
+		return len(p) / 2, io.ErrShortWrite
+	case FaultTornWrite:
+		f.crashed = true
+		n, err := f.Storage.WriteAt(p[:len(p)/2], off)
+		if err != nil {
+			return n, err
+		}
+		return len(p) / 2, ErrCrashed
+	default:
+		return 0, ErrInjected
+	}
+}
+
+// CreateJournal implements pager.Storage and injects the scheduled fault.
+func (f *FaultStorage) CreateJournal(pageNumbers []int) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	if f.createJournalErr != nil {
+		return f.createJournalErr
+	}
+	return f.Storage.CreateJournal(pageNumbers)
+}
+
+// DeleteJournal implements pager.Storage and injects the scheduled fault.
+func (f *FaultStorage) DeleteJournal() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	if f.deleteJournalErr != nil {
+		return f.deleteJournalErr
+	}
+	return f.Storage.DeleteJournal()
+}
+
+// RestoreJournal implements pager.Storage. A crashed storage cannot restore its
+// journal which is left for the next storage opening the database to restore.
+func (f *FaultStorage) RestoreJournal() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.crashed {
+		return ErrCrashed
+	}
+	return f.Storage.RestoreJournal()
+}
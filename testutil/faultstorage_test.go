@@ -0,0 +1,194 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/chirst/cdb/db"
+	"github.com/chirst/cdb/pager"
+)
+
+func mustCreateDB(t *testing.T, s *FaultStorage) *db.DB {
+	d, err := db.New(true, "", db.WithStorage(s))
+	if err != nil {
+		t.Fatalf("err creating db: %s", err)
+	}
+	return d
+}
+
+func execute(d *db.DB, sql string) error {
+	statements := d.Tokenize(sql)
+	return d.Execute(statements[0], []any{}).Err
+}
+
+func mustExecute(t *testing.T, d *db.DB, sql string) {
+	if err := execute(d, sql); err != nil {
+		t.Fatalf("%s executing sql: %s", err, sql)
+	}
+}
+
+func mustCount(t *testing.T, d *db.DB) int {
+	statements := d.Tokenize("SELECT * FROM foo")
+	res := d.Execute(statements[0], []any{})
+	if res.Err != nil {
+		t.Fatal(res.Err)
+	}
+	return len(res.ResultRows)
+}
+
+func TestWriteFaults(t *testing.T) {
+	cases := []struct {
+		name    string
+		kind    FaultKind
+		wantErr error
+	}{
+		{name: "error", kind: FaultError, wantErr: ErrInjected},
+		{name: "short write", kind: FaultShortWrite, wantErr: io.ErrShortWrite},
+		{name: "torn write", kind: FaultTornWrite, wantErr: ErrCrashed},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s := NewFaultStorage(pager.NewMemoryStorage())
+			d := mustCreateDB(t, s)
+			mustExecute(t, d, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+			mustExecute(t, d, "INSERT INTO foo (name) VALUES ('one')")
+
+			s.FailWrite(1, c.kind)
+			err := execute(d, "INSERT INTO foo (name) VALUES ('two')")
+			if !errors.Is(err, c.wantErr) {
+				t.Fatalf("expected %v got %v", c.wantErr, err)
+			}
+			if c.kind == FaultTornWrite && !s.Crashed() {
+				t.Fatal("expected storage to be crashed")
+			}
+
+			// A short or torn write leaves a partially written page behind
+			// which only the journal can repair so only the plain error is
+			// expected to leave the database usable.
+			if c.kind != FaultError {
+				return
+			}
+			s.Reset()
+			mustExecute(t, d, "INSERT INTO foo (name) VALUES ('three')")
+			if got := mustCount(t, d); got != 2 {
+				t.Fatalf("expected failed insert to be discarded leaving 2 rows got %d", got)
+			}
+		})
+	}
+}
+
+func TestJournalFaults(t *testing.T) {
+	s := NewFaultStorage(pager.NewMemoryStorage())
+	d := mustCreateDB(t, s)
+	mustExecute(t, d, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+
+	t.Run("create journal", func(t *testing.T) {
+		s.FailCreateJournal(ErrInjected)
+		writes := s.Writes()
+		err := execute(d, "INSERT INTO foo (name) VALUES ('one')")
+		if !errors.Is(err, ErrInjected) {
+			t.Fatalf("expected %v got %v", ErrInjected, err)
+		}
+		if got := s.Writes(); got != writes {
+			t.Fatalf("expected no writes without a journal got %d", got-writes)
+		}
+		s.Reset()
+		if got := mustCount(t, d); got != 0 {
+			t.Fatalf("expected 0 rows got %d", got)
+		}
+	})
+
+	t.Run("create table", func(t *testing.T) {
+		s.FailCreateJournal(ErrInjected)
+		err := execute(d, "CREATE TABLE bar (id INTEGER PRIMARY KEY)")
+		if !errors.Is(err, ErrInjected) {
+			t.Fatalf("expected %v got %v", ErrInjected, err)
+		}
+		s.Reset()
+		if slices.Contains(d.TableNames(), "bar") {
+			t.Fatal("expected rolled back table to be removed from the catalog")
+		}
+		mustExecute(t, d, "CREATE TABLE bar (id INTEGER PRIMARY KEY)")
+	})
+
+	t.Run("delete journal", func(t *testing.T) {
+		s.FailDeleteJournal(ErrInjected)
+		err := execute(d, "INSERT INTO foo (name) VALUES ('one')")
+		if !errors.Is(err, ErrInjected) {
+			t.Fatalf("expected %v got %v", ErrInjected, err)
+		}
+		s.Reset()
+		mustExecute(t, d, "INSERT INTO foo (name) VALUES ('two')")
+	})
+}
+
+func TestCrashRecovery(t *testing.T) {
+	cases := []struct {
+		name string
+		kind FaultKind
+	}{
+		{name: "error", kind: FaultError},
+		{name: "short write", kind: FaultShortWrite},
+		{name: "torn write", kind: FaultTornWrite},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			name := t.TempDir() + "/crash"
+			fs, err := pager.NewFileStorage(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := NewFaultStorage(fs)
+			d := mustCreateDB(t, s)
+			mustExecute(t, d, "CREATE TABLE foo (id INTEGER PRIMARY KEY, name TEXT)")
+			mustExecute(t, d, "INSERT INTO foo (name) VALUES ('one')")
+
+			s.FailWrite(2, c.kind)
+			if err := execute(d, "INSERT INTO foo (name) VALUES ('two')"); err == nil {
+				t.Fatal("expected insert to fail")
+			}
+
+			// Only a crashed storage leaves the journal for the next open
+			// since otherwise the failed commit restores it.
+			exists, err := fs.JournalExists()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (c.kind == FaultTornWrite) {
+				t.Fatalf("expected journal exists %t got %t", c.kind == FaultTornWrite, exists)
+			}
+			reopened, err := db.New(false, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := mustCount(t, reopened); got != 1 {
+				t.Fatalf("expected failed insert to be undone leaving 1 row got %d", got)
+			}
+			mustExecute(t, reopened, "INSERT INTO foo (name) VALUES ('three')")
+		})
+	}
+}
+
+func TestTornWrite(t *testing.T) {
+	s := NewFaultStorage(pager.NewMemoryStorage())
+	s.FailWrite(2, FaultTornWrite)
+	if _, err := s.WriteAt([]byte{1, 1}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.WriteAt([]byte{2, 2, 2, 2}, 0); !errors.Is(err, ErrCrashed) {
+		t.Fatalf("expected %v got %v", ErrCrashed, err)
+	}
+	if _, err := s.WriteAt([]byte{3}, 0); !errors.Is(err, ErrCrashed) {
+		t.Fatalf("expected writes after a crash to fail got %v", err)
+	}
+	got := make([]byte, 4)
+	if _, err := s.ReadAt(got, 0); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{2, 2, 0, 0}; !bytes.Equal(got, want) {
+		t.Fatalf("expected torn write to leave %v got %v", want, got)
+	}
+}
@@ -0,0 +1,182 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Affinity is the type a column prefers to store values as. A value is
+// converted to the affinity of a column when it is stored if the conversion
+// does not lose information. For example '42' in an INTEGER column is stored
+// as 42, but 'abc' is stored as text.
+type Affinity byte
+
+const (
+	// BlobAffinity stores values as they are.
+	BlobAffinity Affinity = 'B'
+	// TextAffinity stores numbers as text.
+	TextAffinity Affinity = 'T'
+	// NumericAffinity stores text that is a well formed number as a number.
+	// Floats that are a whole number are stored as an integer.
+	NumericAffinity Affinity = 'N'
+	// IntegerAffinity is the same as NumericAffinity except when casting,
+	// where the value is always converted to an integer.
+	IntegerAffinity Affinity = 'I'
+	// RealAffinity stores numbers and text that is a well formed number as a
+	// float.
+	RealAffinity Affinity = 'R'
+)
+
+// AffinityOf returns the affinity of a declared type name using the same rules
+// as SQLite. The name is checked for the following in order:
+//   - INT is IntegerAffinity
+//   - CHAR, CLOB or TEXT is TextAffinity
+//   - BLOB or an empty name is BlobAffinity
+//   - REAL, FLOA or DOUB is RealAffinity
+//   - anything else is NumericAffinity
+func AffinityOf(typeName string) Affinity {
+	t := strings.ToUpper(typeName)
+	contains := func(subs ...string) bool {
+		for _, sub := range subs {
+			if strings.Contains(t, sub) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case contains("INT"):
+		return IntegerAffinity
+	case contains("CHAR", "CLOB", "TEXT"):
+		return TextAffinity
+	case t == "" || contains("BLOB"):
+		return BlobAffinity
+	case contains("REAL", "FLOA", "DOUB"):
+		return RealAffinity
+	}
+	return NumericAffinity
+}
+
+// numericPrefix matches a decimal number at the start of text.
+var numericPrefix = regexp.MustCompile(`^[+-]?(\d+(\.\d*)?|\.\d+)([eE][+-]?\d+)?`)
+
+// parseNumeric returns the number text holds. Leading and trailing spaces are
+// ignored. When prefix is true only the start of text needs to be a number so
+// '12abc' is 12. Otherwise ok is false unless all of text is a number. Text
+// that is an integer too large for 64 bits is a float.
+func parseNumeric(text string, prefix bool) (v Value, ok bool) {
+	text = strings.TrimSpace(text)
+	m := numericPrefix.FindString(text)
+	if m == "" || (!prefix && m != text) {
+		return NullValue(), false
+	}
+	if i, err := strconv.ParseInt(m, 10, 64); err == nil {
+		return IntValue(int(i)), true
+	}
+	// The text is known to be well formed so the only possible error is a
+	// range error where f is infinity.
+	f, _ := strconv.ParseFloat(m, 64)
+	return FloatValue(f), true
+}
+
+// floatToInt returns f as an integer when f is a whole number that fits in 64
+// bits.
+func floatToInt(f float64) (int, bool) {
+	if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+		return 0, false
+	}
+	return int(f), true
+}
+
+// applyAffinity converts v to the type preferred by a when the conversion does
+// not lose information. Otherwise v is returned as is.
+func applyAffinity(v Value, a Affinity) Value {
+	switch a {
+	case TextAffinity:
+		if isNumber(v) {
+			return TextValue(v.Text())
+		}
+	case IntegerAffinity, NumericAffinity:
+		if v.Type() == TextType {
+			n, ok := parseNumeric(v.Text(), false)
+			if !ok {
+				return v
+			}
+			v = n
+		}
+		if v.Type() == FloatType {
+			if i, ok := floatToInt(v.Float()); ok {
+				return IntValue(i)
+			}
+		}
+	case RealAffinity:
+		if v.Type() == TextType {
+			n, ok := parseNumeric(v.Text(), false)
+			if !ok {
+				return v
+			}
+			v = n
+		}
+		if isNumber(v) {
+			return FloatValue(v.Float())
+		}
+	}
+	return v
+}
+
+// castValue converts v to the type of a. Unlike applyAffinity the conversion
+// always happens even if information is lost. Text that does not start with a
+// number is 0 when cast to a number. NULL is always NULL.
+func castValue(v Value, a Affinity) Value {
+	if v.IsNull() {
+		return v
+	}
+	switch a {
+	case TextAffinity:
+		return TextValue(v.Text())
+	case BlobAffinity:
+		return BlobValue(v.Blob())
+	}
+	n := v
+	if !isNumber(n) {
+		n, _ = parseNumeric(v.Text(), true)
+	}
+	switch a {
+	case IntegerAffinity:
+		return IntValue(n.Int())
+	case RealAffinity:
+		return FloatValue(n.Float())
+	}
+	if n.Type() == FloatType {
+		if i, ok := floatToInt(n.Float()); ok {
+			return IntValue(i)
+		}
+		return n
+	}
+	return IntValue(n.Int())
+}
+
+// applyAffinities applies affinity to the registers starting at register
+// start. There is one Affinity in affinities for each register.
+func (r *routine) applyAffinities(start int, affinities string) {
+	for i := range len(affinities) {
+		r.registers[start+i] = applyAffinity(r.registers[start+i], Affinity(affinities[i]))
+	}
+}
+
+// CastCmd stores the value of register P1 converted to the type named by P4 in
+// register P2.
+type CastCmd cmd
+
+func (c *CastCmd) execute(vm *vm, routine *routine) cmdRes {
+	routine.registers[c.P2] = castValue(routine.registers[c.P1], AffinityOf(c.P4))
+	return cmdRes{}
+}
+
+func (c *CastCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Cast register[%d] AS %s into register[%d]", c.P1, c.P4, c.P2)
+	return formatExplain(addr, "Cast", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
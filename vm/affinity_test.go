@@ -0,0 +1,85 @@
+package vm
+
+import "testing"
+
+func TestAffinityOf(t *testing.T) {
+	cases := []struct {
+		typeName string
+		want     Affinity
+	}{
+		{typeName: "INTEGER", want: IntegerAffinity},
+		{typeName: "bigint", want: IntegerAffinity},
+		{typeName: "TEXT", want: TextAffinity},
+		{typeName: "VARCHAR", want: TextAffinity},
+		{typeName: "BLOB", want: BlobAffinity},
+		{typeName: "", want: BlobAffinity},
+		{typeName: "REAL", want: RealAffinity},
+		{typeName: "DOUBLE", want: RealAffinity},
+		{typeName: "NUMERIC", want: NumericAffinity},
+		{typeName: "DECIMAL", want: NumericAffinity},
+	}
+	for _, c := range cases {
+		t.Run(c.typeName, func(t *testing.T) {
+			if got := AffinityOf(c.typeName); got != c.want {
+				t.Fatalf("want %c got %c", c.want, got)
+			}
+		})
+	}
+}
+
+func TestApplyAffinity(t *testing.T) {
+	cases := []struct {
+		name     string
+		v        Value
+		affinity Affinity
+		want     Value
+	}{
+		{name: "integer text", v: TextValue("42"), affinity: IntegerAffinity, want: IntValue(42)},
+		{name: "padded text", v: TextValue(" 42 "), affinity: IntegerAffinity, want: IntValue(42)},
+		{name: "whole float text", v: TextValue("3.0"), affinity: IntegerAffinity, want: IntValue(3)},
+		{name: "float text", v: TextValue("3.5"), affinity: IntegerAffinity, want: FloatValue(3.5)},
+		{name: "word text", v: TextValue("abc"), affinity: IntegerAffinity, want: TextValue("abc")},
+		{name: "number prefix", v: TextValue("12abc"), affinity: IntegerAffinity, want: TextValue("12abc")},
+		{name: "whole float", v: FloatValue(2), affinity: NumericAffinity, want: IntValue(2)},
+		{name: "null", v: NullValue(), affinity: IntegerAffinity, want: NullValue()},
+		{name: "integer as text", v: IntValue(42), affinity: TextAffinity, want: TextValue("42")},
+		{name: "integer as real", v: IntValue(1), affinity: RealAffinity, want: FloatValue(1)},
+		{name: "text as real", v: TextValue("1.5"), affinity: RealAffinity, want: FloatValue(1.5)},
+		{name: "text as blob", v: TextValue("42"), affinity: BlobAffinity, want: TextValue("42")},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := applyAffinity(c.v, c.affinity)
+			if got.Type() != c.want.Type() || compareValues(got, c.want) != 0 {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+}
+
+func TestCastValue(t *testing.T) {
+	cases := []struct {
+		name     string
+		v        Value
+		typeName string
+		want     Value
+	}{
+		{name: "number prefix", v: TextValue("12abc"), typeName: "INTEGER", want: IntValue(12)},
+		{name: "no number", v: TextValue("abc"), typeName: "INTEGER", want: IntValue(0)},
+		{name: "truncate float", v: FloatValue(3.9), typeName: "INTEGER", want: IntValue(3)},
+		{name: "float text", v: TextValue("1.5e1x"), typeName: "REAL", want: FloatValue(15)},
+		{name: "integer", v: IntValue(7), typeName: "TEXT", want: TextValue("7")},
+		{name: "blob", v: IntValue(7), typeName: "BLOB", want: BlobValue([]byte("7"))},
+		{name: "numeric whole", v: TextValue("4.0"), typeName: "NUMERIC", want: IntValue(4)},
+		{name: "numeric float", v: TextValue("4.5"), typeName: "NUMERIC", want: FloatValue(4.5)},
+		{name: "null", v: NullValue(), typeName: "INTEGER", want: NullValue()},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := castValue(c.v, AffinityOf(c.typeName))
+			if got.Type() != c.want.Type() || compareValues(got, c.want) != 0 {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,245 @@
+package vm
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/chirst/cdb/kv"
+)
+
+// accumulator holds the running state of an aggregate function for a group.
+type accumulator struct {
+	// count is the number of non NULL values stepped.
+	count int
+	// value is the running sum, minimum or maximum.
+	value Value
+}
+
+// step accumulates v for the aggregate function fn.
+func (a *accumulator) step(fn string, v Value) error {
+	if v.IsNull() {
+		return nil
+	}
+	a.count += 1
+	switch fn {
+	case "COUNT":
+	case "SUM":
+		n, err := toInt(v)
+		if err != nil {
+			return err
+		}
+		sum, err := Add(a.value.Int(), n)
+		if err != nil {
+			return err
+		}
+		a.value = IntValue(sum)
+	case "MIN":
+		if a.value.IsNull() || compareValues(v, a.value) < 0 {
+			a.value = v
+		}
+	case "MAX":
+		if a.value.IsNull() || compareValues(v, a.value) > 0 {
+			a.value = v
+		}
+	default:
+		return fmt.Errorf("unknown aggregate function %s", fn)
+	}
+	return nil
+}
+
+// final returns the result of the aggregate function fn. Every function except
+// COUNT is NULL when there were no non NULL values.
+func (a *accumulator) final(fn string) Value {
+	if fn == "COUNT" {
+		return IntValue(a.count)
+	}
+	return a.value
+}
+
+// group is a row of a groupTable.
+type group struct {
+	keys         []Value
+	accumulators []accumulator
+}
+
+// groupTable is ephemeral storage for the groups of a GROUP BY. Groups are
+// accumulated in memory then finalized into rows that can be read as a cursor.
+// The value of each row is the group keys followed by the result of each
+// aggregate function.
+type groupTable struct {
+	// fns is the aggregate function of each accumulator in a group.
+	fns []string
+	// groups maps an encoded group key to its group.
+	groups map[string]*group
+	keys   [][]byte
+	values [][]byte
+	// idx is the position of the cursor after finalizing.
+	idx int
+}
+
+func newGroupTable(fns []string) *groupTable {
+	return &groupTable{
+		fns:    fns,
+		groups: map[string]*group{},
+	}
+}
+
+// getGroup returns the group for keyRecord creating it if it does not exist.
+func (g *groupTable) getGroup(keyRecord []byte) (*group, error) {
+	if gr, ok := g.groups[string(keyRecord)]; ok {
+		return gr, nil
+	}
+	keys, err := decodeValues(keyRecord)
+	if err != nil {
+		return nil, err
+	}
+	gr := &group{
+		keys:         keys,
+		accumulators: make([]accumulator, len(g.fns)),
+	}
+	g.groups[string(keyRecord)] = gr
+	return gr, nil
+}
+
+// finalize sorts the groups by their keys and encodes the rows. When
+// alwaysOneGroup is true and there are no groups an empty group is made. This
+// is the case for aggregates without a GROUP BY which always have one row.
+func (g *groupTable) finalize(alwaysOneGroup bool) error {
+	groups := []*group{}
+	for _, gr := range g.groups {
+		groups = append(groups, gr)
+	}
+	if len(groups) == 0 && alwaysOneGroup {
+		groups = append(groups, &group{
+			accumulators: make([]accumulator, len(g.fns)),
+		})
+	}
+	slices.SortFunc(groups, func(a, b *group) int {
+		for i := range a.keys {
+			if c := compareValues(a.keys[i], b.keys[i]); c != 0 {
+				return c
+			}
+		}
+		return 0
+	})
+	for i, gr := range groups {
+		row := slices.Clone(gr.keys)
+		for j := range gr.accumulators {
+			row = append(row, gr.accumulators[j].final(g.fns[j]))
+		}
+		k, err := kv.EncodeKey(i)
+		if err != nil {
+			return err
+		}
+		v, err := encodeValues(row)
+		if err != nil {
+			return err
+		}
+		g.keys = append(g.keys, k)
+		g.values = append(g.values, v)
+	}
+	return nil
+}
+
+func (g *groupTable) GotoFirstRecord() bool {
+	g.idx = 0
+	return g.idx < len(g.keys)
+}
+
+func (g *groupTable) GotoNext() bool {
+	if g.idx < len(g.keys) {
+		g.idx += 1
+	}
+	return g.idx < len(g.keys)
+}
+
+func (g *groupTable) GotoKey(key []byte) bool {
+	return false
+}
+
+func (g *groupTable) GetKey() []byte {
+	return g.keys[g.idx]
+}
+
+func (g *groupTable) GetValue() []byte {
+	return g.values[g.idx]
+}
+
+func (g *groupTable) Count() int {
+	return len(g.keys)
+}
+
+func (g *groupTable) Exists(key []byte) bool {
+	return false
+}
+
+// AggOpenCmd opens an empty group table with cursor id P1 for P2 aggregate
+// functions. P4 is a comma separated list of the aggregate functions.
+type AggOpenCmd cmd
+
+func (c *AggOpenCmd) execute(vm *vm, routine *routine) cmdRes {
+	fns := []string{}
+	if c.P2 != 0 {
+		fns = strings.Split(c.P4, ",")
+	}
+	if len(fns) != c.P2 {
+		return cmdRes{err: fmt.Errorf("expected %d aggregate functions but got %s", c.P2, c.P4)}
+	}
+	routine.cursors[c.P1] = newGroupTable(fns)
+	return cmdRes{}
+}
+
+func (c *AggOpenCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Open group table with id %d for %d aggregate functions", c.P1, c.P2)
+	return formatExplain(addr, "AggOpen", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// AggStepCmd accumulates register P3 into the P5-th aggregate function of the
+// group with the key record in register P2 for group table P1. P4 is the name
+// of the aggregate function.
+type AggStepCmd cmd
+
+func (c *AggStepCmd) execute(vm *vm, routine *routine) cmdRes {
+	g, ok := routine.cursors[c.P1].(*groupTable)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not a group table", c.P1)}
+	}
+	keyRecord, err := routine.blobRegister(c.P2)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	gr, err := g.getGroup(keyRecord)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if err := gr.accumulators[c.P5].step(g.fns[c.P5], routine.registers[c.P3]); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *AggStepCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Accumulate register[%d] into aggregate %d of the group in register[%d] for group table %d", c.P3, c.P5, c.P2, c.P1)
+	return formatExplain(addr, "AggStep", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// AggFinalCmd computes the result of each group in group table P1 so the
+// groups can be read. When P2 is 1 there is always at least one group.
+type AggFinalCmd cmd
+
+func (c *AggFinalCmd) execute(vm *vm, routine *routine) cmdRes {
+	g, ok := routine.cursors[c.P1].(*groupTable)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not a group table", c.P1)}
+	}
+	if err := g.finalize(c.P2 == 1); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *AggFinalCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Compute the result of each group for group table %d", c.P1)
+	return formatExplain(addr, "AggFinal", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
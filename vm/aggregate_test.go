@@ -0,0 +1,77 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestAggregateCommands(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(kv)
+	rows := [][]any{{"b", 1}, {"a", 2}, {"b", 3}, {"a", nil}}
+	ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+		&AggOpenCmd{P1: 3, P2: 3, P4: "COUNT,SUM,MAX"},
+		&RewindCmd{P1: 1, P2: 11},
+		&ColumnCmd{P1: 1, P2: 0, P3: 1},
+		&MakeRecordCmd{P1: 1, P2: 1, P3: 2},
+		&ColumnCmd{P1: 1, P2: 1, P3: 3},
+		&AggStepCmd{P1: 3, P2: 2, P3: 3, P4: "COUNT", P5: 0},
+		&AggStepCmd{P1: 3, P2: 2, P3: 3, P4: "SUM", P5: 1},
+		&AggStepCmd{P1: 3, P2: 2, P3: 3, P4: "MAX", P5: 2},
+		&NextCmd{P1: 1, P2: 4},
+		&AggFinalCmd{P1: 3},
+		&RewindCmd{P1: 3, P2: 19},
+		&ColumnCmd{P1: 3, P2: 0, P3: 4},
+		&ColumnCmd{P1: 3, P2: 1, P3: 5},
+		&ColumnCmd{P1: 3, P2: 2, P3: 6},
+		&ColumnCmd{P1: 3, P2: 3, P3: 7},
+		&ResultRowCmd{P1: 4, P2: 4},
+		&NextCmd{P1: 3, P2: 13},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	got := [][]string{}
+	for _, row := range res.ResultRows {
+		values := []string{}
+		for _, v := range row {
+			values = append(values, v.Text())
+		}
+		got = append(got, values)
+	}
+	want := [][]string{{"a", "1", "2", "2"}, {"b", "2", "4", "3"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+}
+
+func TestAggregateAlwaysOneGroup(t *testing.T) {
+	g := newGroupTable([]string{"COUNT", "MIN"})
+	if err := g.finalize(true); err != nil {
+		t.Fatal(err)
+	}
+	if !g.GotoFirstRecord() {
+		t.Fatal("expected a group")
+	}
+	row, err := kv.Decode(g.GetValue())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []any{0, nil}
+	if !reflect.DeepEqual(row, want) {
+		t.Fatalf("want %v got %v", want, row)
+	}
+	if g.GotoNext() {
+		t.Fatal("expected one group")
+	}
+}
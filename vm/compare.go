@@ -0,0 +1,85 @@
+package vm
+
+import (
+	"bytes"
+	"cmp"
+	"strconv"
+	"strings"
+)
+
+// compareValues defines the total ordering of values. Values of different
+// storage classes are ordered NULL < number < text < blob where integers and
+// floats are both numbers. Values of the same storage class are ordered by
+// value. compareValues is used by the sorter and the comparison commands so
+// ordering is consistent everywhere.
+func compareValues(a, b Value) int {
+	ca, cb := sortClass(a.Type()), sortClass(b.Type())
+	if ca != cb {
+		return cmp.Compare(ca, cb)
+	}
+	switch a.Type() {
+	case IntegerType:
+		if b.Type() == IntegerType {
+			return cmp.Compare(a.Int(), b.Int())
+		}
+		return cmp.Compare(a.Float(), b.Float())
+	case FloatType:
+		return cmp.Compare(a.Float(), b.Float())
+	case TextType:
+		return strings.Compare(a.Text(), b.Text())
+	case BlobType:
+		return bytes.Compare(a.Blob(), b.Blob())
+	}
+	return 0
+}
+
+// sortClass returns the position of t in the ordering of storage classes.
+// Integers and floats share a position since they are compared by value.
+func sortClass(t ValueType) ValueType {
+	if t == FloatType {
+		return IntegerType
+	}
+	return t
+}
+
+// isNumber is true when v is an integer or a float.
+func isNumber(v Value) bool {
+	return v.Type() == IntegerType || v.Type() == FloatType
+}
+
+// applyNumericAffinity converts text to an integer when it is compared to a
+// number and the text is a well formed integer. This way 2 = '2' but 2 < 'a'.
+// Affinity is only applied to comparisons since applying it while sorting
+// would make the ordering inconsistent.
+func applyNumericAffinity(a, b Value) (Value, Value) {
+	if isNumber(a) && b.Type() == TextType {
+		if i, err := strconv.Atoi(b.Text()); err == nil {
+			return a, IntValue(i)
+		}
+	}
+	if a.Type() == TextType && isNumber(b) {
+		if i, err := strconv.Atoi(a.Text()); err == nil {
+			return IntValue(i), b
+		}
+	}
+	return a, b
+}
+
+// rowIdAffinity returns v as a row id. Text that is a well formed integer is a
+// row id the same way it compares equal to an integer. Any other value can
+// never equal a row id so ok is false.
+func rowIdAffinity(v Value) (rowId int, ok bool) {
+	switch v.Type() {
+	case IntegerType:
+		return v.Int(), true
+	case TextType:
+		i, err := strconv.Atoi(v.Text())
+		return i, err == nil
+	}
+	return 0, false
+}
+
+// compareWithAffinity compares a and b after applying numeric affinity.
+func compareWithAffinity(a, b Value) int {
+	return compareValues(applyNumericAffinity(a, b))
+}
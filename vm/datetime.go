@@ -0,0 +1,242 @@
+package vm
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DatetimeLayout is the layout of DATETIME() which is the same as
+// CURRENT_TIMESTAMP.
+const DatetimeLayout = TimestampLayout
+
+// TimeLayout is the layout of TIME().
+const TimeLayout = "15:04:05"
+
+// timeValueLayouts are the layouts a time value given to a date and time
+// function may have. A time without a date is on 2000-01-01.
+var timeValueLayouts = []string{
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02T15:04",
+	DateLayout,
+	"15:04:05.999999999",
+	"15:04",
+}
+
+// unixEpochJulianDay is the julian day number of 1970-01-01 00:00:00.
+const unixEpochJulianDay = 2440587.5
+
+// dateFunction is DATE(time value, modifiers...) formatted as YYYY-MM-DD.
+func dateFunction(now time.Time, args []Value) (Value, error) {
+	return formatTimeValue(now, args, DateLayout), nil
+}
+
+// timeFunction is TIME(time value, modifiers...) formatted as HH:MM:SS.
+func timeFunction(now time.Time, args []Value) (Value, error) {
+	return formatTimeValue(now, args, TimeLayout), nil
+}
+
+// datetimeFunction is DATETIME(time value, modifiers...) formatted as
+// YYYY-MM-DD HH:MM:SS.
+func datetimeFunction(now time.Time, args []Value) (Value, error) {
+	return formatTimeValue(now, args, DatetimeLayout), nil
+}
+
+// formatTimeValue returns the time of args formatted with layout. The result is
+// NULL when the time cannot be computed.
+func formatTimeValue(now time.Time, args []Value, layout string) Value {
+	t, ok := timeOf(now, args)
+	if !ok {
+		return NullValue()
+	}
+	return TextValue(t.Format(layout))
+}
+
+// strftimeFunction is STRFTIME(format, time value, modifiers...) which is the
+// time formatted with format. format may contain the substitutions %d day of
+// month, %f fractional seconds SS.SSS, %H hour, %j day of year, %J julian day
+// number, %m month, %M minute, %s seconds since 1970-01-01, %S seconds, %w day
+// of week where Sunday is 0, %Y year and %% which is %.
+func strftimeFunction(now time.Time, args []Value) (Value, error) {
+	if args[0].IsNull() {
+		return NullValue(), nil
+	}
+	t, ok := timeOf(now, args[1:])
+	if !ok {
+		return NullValue(), nil
+	}
+	format := args[0].Text()
+	sb := strings.Builder{}
+	for i := 0; i < len(format); i += 1 {
+		if format[i] != '%' || i == len(format)-1 {
+			sb.WriteByte(format[i])
+			continue
+		}
+		i += 1
+		switch format[i] {
+		case 'd':
+			sb.WriteString(t.Format("02"))
+		case 'f':
+			sb.WriteString(t.Format("05.000"))
+		case 'H':
+			sb.WriteString(t.Format("15"))
+		case 'j':
+			sb.WriteString(t.Format("002"))
+		case 'J':
+			sb.WriteString(strconv.FormatFloat(julianDay(t), 'f', -1, 64))
+		case 'm':
+			sb.WriteString(t.Format("01"))
+		case 'M':
+			sb.WriteString(t.Format("04"))
+		case 's':
+			sb.WriteString(strconv.FormatInt(t.Unix(), 10))
+		case 'S':
+			sb.WriteString(t.Format("05"))
+		case 'w':
+			sb.WriteString(strconv.Itoa(int(t.Weekday())))
+		case 'Y':
+			sb.WriteString(t.Format("2006"))
+		case '%':
+			sb.WriteByte('%')
+		default:
+			// An unknown substitution makes the whole result NULL.
+			return NullValue(), nil
+		}
+	}
+	return TextValue(sb.String()), nil
+}
+
+// timeOf returns the time of the time value args[0] after applying the
+// modifiers in the rest of args in order. Without args the time is now. ok is
+// false when any argument is NULL or is not a valid time value or modifier.
+func timeOf(now time.Time, args []Value) (t time.Time, ok bool) {
+	if len(args) == 0 {
+		return now, true
+	}
+	if anyNull(args...) {
+		return time.Time{}, false
+	}
+	modifiers := make([]string, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		modifiers = append(modifiers, strings.ToLower(strings.TrimSpace(arg.Text())))
+	}
+	if len(modifiers) > 0 && modifiers[0] == "unixepoch" {
+		n, ok := numericTimeValue(args[0])
+		if !ok {
+			return time.Time{}, false
+		}
+		t = time.Unix(0, 0).UTC().Add(time.Duration(n * float64(time.Second)))
+		modifiers = modifiers[1:]
+	} else if t, ok = parseTimeValue(now, args[0]); !ok {
+		return time.Time{}, false
+	}
+	for _, modifier := range modifiers {
+		if t, ok = applyTimeModifier(t, modifier); !ok {
+			return time.Time{}, false
+		}
+	}
+	return t, true
+}
+
+// parseTimeValue returns the time of v. v is now, text with one of the
+// timeValueLayouts or a julian day number.
+func parseTimeValue(now time.Time, v Value) (time.Time, bool) {
+	if v.Type() == TextType {
+		text := strings.TrimSpace(v.Text())
+		if strings.EqualFold(text, "now") {
+			return now, true
+		}
+		for _, layout := range timeValueLayouts {
+			t, err := time.Parse(layout, text)
+			if err != nil {
+				continue
+			}
+			if t.Year() == 0 && !strings.Contains(layout, "2006") {
+				t = t.AddDate(2000, 0, 0)
+			}
+			return t, true
+		}
+	}
+	n, ok := numericTimeValue(v)
+	if !ok {
+		return time.Time{}, false
+	}
+	seconds := (n - unixEpochJulianDay) * 86400
+	return time.Unix(0, 0).UTC().Add(time.Duration(seconds * float64(time.Second))), true
+}
+
+// numericTimeValue returns v as a number when v is a number or text holding a
+// number.
+func numericTimeValue(v Value) (float64, bool) {
+	if v.Type() == TextType {
+		n, ok := parseNumeric(v.Text(), false)
+		if !ok {
+			return 0, false
+		}
+		v = n
+	}
+	if !isNumber(v) {
+		return 0, false
+	}
+	return v.Float(), true
+}
+
+// julianDay returns the julian day number of t.
+func julianDay(t time.Time) float64 {
+	return float64(t.UnixNano())/float64(time.Second)/86400 + unixEpochJulianDay
+}
+
+// timeUnits are the durations of the units a modifier may add. Months and
+// years are added to the calendar instead.
+var timeUnits = map[string]time.Duration{
+	"second": time.Second,
+	"minute": time.Minute,
+	"hour":   time.Hour,
+	"day":    24 * time.Hour,
+}
+
+// applyTimeModifier returns t changed by modifier. The modifiers are "start of
+// day", "start of month", "start of year", "weekday N" which advances to the
+// next day of week N where Sunday is 0 and "N unit" which adds N seconds,
+// minutes, hours, days, months or years.
+func applyTimeModifier(t time.Time, modifier string) (time.Time, bool) {
+	switch modifier {
+	case "start of day":
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), true
+	case "start of month":
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC), true
+	case "start of year":
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC), true
+	}
+	if day, ok := strings.CutPrefix(modifier, "weekday "); ok {
+		weekday, err := strconv.Atoi(strings.TrimSpace(day))
+		if err != nil || weekday < 0 || weekday > 6 {
+			return time.Time{}, false
+		}
+		days := (weekday - int(t.Weekday()) + 7) % 7
+		return t.AddDate(0, 0, days), true
+	}
+	amount, unit, ok := strings.Cut(modifier, " ")
+	if !ok {
+		return time.Time{}, false
+	}
+	n, err := strconv.ParseFloat(amount, 64)
+	if err != nil || math.IsInf(n, 0) || math.IsNaN(n) {
+		return time.Time{}, false
+	}
+	unit = strings.TrimSuffix(strings.TrimSpace(unit), "s")
+	switch unit {
+	case "month":
+		return t.AddDate(0, int(n), 0), n == math.Trunc(n)
+	case "year":
+		return t.AddDate(int(n), 0, 0), n == math.Trunc(n)
+	}
+	d, ok := timeUnits[unit]
+	if !ok {
+		return time.Time{}, false
+	}
+	return t.Add(time.Duration(n * float64(d))), true
+}
@@ -0,0 +1,48 @@
+package vm
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDateTimeFunctions(t *testing.T) {
+	now := time.Date(2024, 2, 29, 13, 14, 15, 0, time.UTC)
+	type timeCase struct {
+		name string
+		args []Value
+		want Value
+	}
+	cases := []timeCase{
+		{name: "DATE", args: []Value{}, want: TextValue("2024-02-29")},
+		{name: "DATE", args: []Value{TextValue("now"), TextValue("+1 day")}, want: TextValue("2024-03-01")},
+		{name: "DATE", args: []Value{TextValue("2024-01-31"), TextValue("+1 month")}, want: TextValue("2024-03-02")},
+		{name: "DATE", args: []Value{TextValue("2024-05-17 08:00:00"), TextValue("start of month")}, want: TextValue("2024-05-01")},
+		{name: "DATE", args: []Value{TextValue("2024-01-01"), TextValue("weekday 0")}, want: TextValue("2024-01-07")},
+		{name: "DATE", args: []Value{FloatValue(2460000.5)}, want: TextValue("2023-02-25")},
+		{name: "DATE", args: []Value{TextValue("not a date")}, want: NullValue()},
+		{name: "DATE", args: []Value{TextValue("2024-01-01"), TextValue("+1 fortnight")}, want: NullValue()},
+		{name: "DATE", args: []Value{NullValue()}, want: NullValue()},
+		{name: "TIME", args: []Value{TextValue("12:30")}, want: TextValue("12:30:00")},
+		{name: "TIME", args: []Value{TextValue("2024-01-01T10:00:00"), TextValue("-1.5 hours")}, want: TextValue("08:30:00")},
+		{name: "DATETIME", args: []Value{IntValue(1700000000), TextValue("unixepoch")}, want: TextValue("2023-11-14 22:13:20")},
+		{name: "DATETIME", args: []Value{TextValue("2024-06-15 10:11:12"), TextValue("start of year"), TextValue("+36 hours")}, want: TextValue("2024-01-02 12:00:00")},
+		{name: "STRFTIME", args: []Value{TextValue("%Y-%m-%dT%H:%M:%S %j %w %%"), TextValue("2024-03-05 06:07:08")}, want: TextValue("2024-03-05T06:07:08 065 2 %")},
+		{name: "STRFTIME", args: []Value{TextValue("%s"), TextValue("1970-01-02")}, want: TextValue("86400")},
+		{name: "STRFTIME", args: []Value{TextValue("%f"), TextValue("2024-01-01 00:00:01.250")}, want: TextValue("01.250")},
+		{name: "STRFTIME", args: []Value{TextValue("%J"), TextValue("2000-01-01 12:00:00")}, want: TextValue("2451545")},
+		{name: "STRFTIME", args: []Value{TextValue("%q"), TextValue("2000-01-01")}, want: NullValue()},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s%v", c.name, c.args), func(t *testing.T) {
+			got, err := callFunctionAt(now, c.name, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package vm
+
+import (
+	"fmt"
+
+	"github.com/chirst/cdb/kv"
+)
+
+// ephemeralCursor is a cursor over an ephemeral b tree. It can be written to
+// and positioned the same as a cursor over a table, but its rows are discarded
+// once the cursor is closed.
+type ephemeralCursor struct {
+	*kv.Cursor
+	ephemeral *kv.Ephemeral
+}
+
+func (e *ephemeralCursor) Close() error {
+	e.ephemeral.Close()
+	return nil
+}
+
+// OpenEphemeralCmd opens a cursor with id P1 on a new empty ephemeral b tree.
+// The b tree is held in memory rather than the database file so it can hold
+// intermediate results such as rows being sorted, distinct values, the values
+// of an IN list or a materialized subquery. An ephemeral cursor already open
+// with id P1 is closed first.
+type OpenEphemeralCmd cmd
+
+func (c *OpenEphemeralCmd) execute(vm *vm, routine *routine) cmdRes {
+	if ec, ok := routine.cursors[c.P1].(*ephemeralCursor); ok {
+		ec.Close()
+	}
+	e := kv.NewEphemeral()
+	routine.cursors[c.P1] = &ephemeralCursor{Cursor: e.NewCursor(), ephemeral: e}
+	return cmdRes{}
+}
+
+func (c *OpenEphemeralCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Open cursor with id %d on a new ephemeral b tree", c.P1)
+	return formatExplain(addr, "OpenEphemeral", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
@@ -0,0 +1,106 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
+)
+
+func TestOpenEphemeral(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+	ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenEphemeralCmd{P1: 1},
+		&IntegerCmd{P1: 3, P2: 1},
+		// Insert the values 3, 2 and 1 with increasing row ids.
+		&NewRowIdCmd{P1: 1, P2: 2},
+		&MakeRecordCmd{P1: 1, P2: 1, P3: 3},
+		&InsertCmd{P1: 1, P2: 3, P3: 2},
+		&DecrJumpZeroCmd{P1: 1, P2: 8},
+		&GotoCmd{P2: 3},
+		// Read the rows back in descending row id order.
+		&LastCmd{P1: 1, P2: 13},
+		&RowIdCmd{P1: 1, P2: 4},
+		&ColumnCmd{P1: 1, P2: 0, P3: 5},
+		&ResultRowCmd{P1: 4, P2: 2},
+		&PrevCmd{P1: 1, P2: 9},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	got := ""
+	for _, row := range res.ResultRows {
+		got += row[0].Text() + row[1].Text() + " "
+	}
+	if got != "31 22 13 " {
+		t.Fatalf("expected 31 22 13 got %s", got)
+	}
+	if state, _ := k.TransactionState(); state != pager.TransactionNone {
+		t.Fatalf("expected the database to be untouched got %s", state)
+	}
+}
+
+func TestOpenVirtualEphemeral(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+	table := kv.NewEphemeral()
+	defer table.Close()
+	c := table.NewCursor()
+	for i, name := range []string{"a", "b"} {
+		key, err := kv.EncodeKey(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := kv.Encode([]any{name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Table: table},
+		&RewindCmd{P1: 1, P2: 6},
+		&ColumnCmd{P1: 1, P2: 0, P3: 1},
+		&ResultRowCmd{P1: 1, P2: 1},
+		&NextCmd{P1: 1, P2: 3},
+		&HaltCmd{},
+	}
+	// The table is read again since the routine does not close it.
+	for range 2 {
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		if len(res.ResultRows) != 2 || res.ResultRows[1][0].Text() != "b" {
+			t.Fatalf("expected rows a and b got %v", res.ResultRows)
+		}
+	}
+
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Table: table},
+		&RewindCmd{P1: 1, P2: 4},
+		&DeleteCmd{P1: 1},
+		&HaltCmd{},
+	}
+	if res := vm.Execute(ep, []any{}); !errors.Is(res.Err, errReadOnlyCursor) {
+		t.Fatalf("expected %s got %v", errReadOnlyCursor, res.Err)
+	}
+}
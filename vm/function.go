@@ -0,0 +1,330 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// TypeofCmd stores the name of the storage class of the value in register P1
+// in register P2. The name is one of null, integer, real, text or blob.
+type TypeofCmd cmd
+
+func (c *TypeofCmd) execute(vm *vm, routine *routine) cmdRes {
+	routine.registers[c.P2] = TextValue(routine.registers[c.P1].Type().String())
+	return cmdRes{}
+}
+
+func (c *TypeofCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store the type of register[%d] in register[%d]", c.P1, c.P2)
+	return formatExplain(addr, "Typeof", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// TimestampLayout is the layout of CURRENT_TIMESTAMP.
+const TimestampLayout = "2006-01-02 15:04:05"
+
+// DateLayout is the layout of CURRENT_DATE.
+const DateLayout = "2006-01-02"
+
+// CurrentTimeCmd stores the UTC time the routine first evaluated the current
+// time formatted with the layout P4 in register P1. Every row of a statement
+// sees the same time.
+type CurrentTimeCmd cmd
+
+func (c *CurrentTimeCmd) execute(vm *vm, routine *routine) cmdRes {
+	routine.registers[c.P1] = TextValue(routine.currentTime().Format(c.P4))
+	return cmdRes{}
+}
+
+// currentTime returns the UTC time the routine first evaluated the current
+// time.
+func (r *routine) currentTime() time.Time {
+	if r.now.IsZero() {
+		r.now = time.Now().UTC()
+	}
+	return r.now
+}
+
+func (c *CurrentTimeCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store the current time as %s in register[%d]", c.P4, c.P1)
+	return formatExplain(addr, "CurrentTime", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// scalarFunction is a function called by FunctionCmd that computes one value
+// from its arguments. minArgs and maxArgs are the number of arguments the
+// function accepts.
+type scalarFunction struct {
+	minArgs int
+	maxArgs int
+	// nondeterministic is true when the function may return a different
+	// result for the same arguments.
+	nondeterministic bool
+	call             func(args []Value) (Value, error)
+	// callAt is called instead of call for a function that depends on the
+	// time the statement is executed. now is the same for every call within
+	// a statement.
+	callAt func(now time.Time, args []Value) (Value, error)
+}
+
+// scalarFunctions are the functions FunctionCmd can call by name.
+var scalarFunctions = map[string]scalarFunction{
+	"UPPER":  {minArgs: 1, maxArgs: 1, call: upperFunction},
+	"LOWER":  {minArgs: 1, maxArgs: 1, call: lowerFunction},
+	"LENGTH": {minArgs: 1, maxArgs: 1, call: lengthFunction},
+	"SUBSTR": {minArgs: 2, maxArgs: 3, call: substrFunction},
+	"TRIM":   {minArgs: 1, maxArgs: 2, call: trimFunction},
+	"ABS":    {minArgs: 1, maxArgs: 1, call: absFunction},
+	"ROUND":  {minArgs: 1, maxArgs: 2, call: roundFunction},
+	// MIN and MAX with one argument are aggregate functions.
+	"MIN":    {minArgs: 2, maxArgs: math.MaxInt, call: minFunction},
+	"MAX":    {minArgs: 2, maxArgs: math.MaxInt, call: maxFunction},
+	"RANDOM": {minArgs: 0, maxArgs: 0, nondeterministic: true, call: randomFunction},
+	// The planner evaluates the arguments of COALESCE and IFNULL in order and
+	// stops at the first that is not NULL rather than calling FunctionCmd.
+	"COALESCE": {minArgs: 2, maxArgs: math.MaxInt, call: coalesceFunction},
+	"IFNULL":   {minArgs: 2, maxArgs: 2, call: coalesceFunction},
+	// The date and time functions are not deterministic since a time value
+	// may be 'now'.
+	"DATE":     {minArgs: 0, maxArgs: math.MaxInt, nondeterministic: true, callAt: dateFunction},
+	"TIME":     {minArgs: 0, maxArgs: math.MaxInt, nondeterministic: true, callAt: timeFunction},
+	"DATETIME": {minArgs: 0, maxArgs: math.MaxInt, nondeterministic: true, callAt: datetimeFunction},
+	"STRFTIME": {minArgs: 1, maxArgs: math.MaxInt, nondeterministic: true, callAt: strftimeFunction},
+}
+
+// FunctionArgs returns the least and most arguments accepted by the scalar
+// function name. ok is false when there is no such function.
+func FunctionArgs(name string) (minArgs, maxArgs int, ok bool) {
+	f, ok := scalarFunctions[name]
+	return f.minArgs, f.maxArgs, ok
+}
+
+// IsDeterministic returns true when the scalar function name always returns
+// the same result for the same arguments. Only a deterministic function can be
+// computed before a statement is executed or computed once for many uses.
+func IsDeterministic(name string) bool {
+	f, ok := scalarFunctions[name]
+	return ok && !f.nondeterministic
+}
+
+// CallFunction returns the result of the scalar function name for args. This
+// is the same result FunctionCmd stores so a function of constants can be
+// computed before a statement is executed.
+func CallFunction(name string, args []Value) (Value, error) {
+	return callFunctionAt(time.Now().UTC(), name, args)
+}
+
+// callFunctionAt is CallFunction for a statement executed at now.
+func callFunctionAt(now time.Time, name string, args []Value) (Value, error) {
+	f, ok := scalarFunctions[name]
+	if !ok {
+		return Value{}, fmt.Errorf("no such function: %s", name)
+	}
+	if len(args) < f.minArgs || len(args) > f.maxArgs {
+		return Value{}, fmt.Errorf("wrong number of arguments to function %s", name)
+	}
+	if f.callAt != nil {
+		return f.callAt(now, args)
+	}
+	return f.call(args)
+}
+
+// FunctionCmd calls the scalar function named P4 with the P2 arguments in the
+// registers starting at register P1 and stores the result in register P3.
+type FunctionCmd cmd
+
+func (c *FunctionCmd) execute(vm *vm, routine *routine) cmdRes {
+	args := make([]Value, c.P2)
+	for i := range args {
+		args[i] = routine.registers[c.P1+i]
+	}
+	v, err := callFunctionAt(routine.currentTime(), c.P4, args)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P3] = v
+	return cmdRes{}
+}
+
+func (c *FunctionCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store %s of %d registers starting at register[%d] in register[%d]", c.P4, c.P2, c.P1, c.P3)
+	return formatExplain(addr, "Function", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// upperFunction is UPPER(x) which is the text of x in upper case.
+func upperFunction(args []Value) (Value, error) {
+	if anyNull(args...) {
+		return NullValue(), nil
+	}
+	return TextValue(strings.ToUpper(args[0].Text())), nil
+}
+
+// lowerFunction is LOWER(x) which is the text of x in lower case.
+func lowerFunction(args []Value) (Value, error) {
+	if anyNull(args...) {
+		return NullValue(), nil
+	}
+	return TextValue(strings.ToLower(args[0].Text())), nil
+}
+
+// lengthFunction is LENGTH(x) which is the number of characters in the text of
+// x or the number of bytes in a blob.
+func lengthFunction(args []Value) (Value, error) {
+	switch args[0].Type() {
+	case NullType:
+		return NullValue(), nil
+	case BlobType:
+		return IntValue(len(args[0].Blob())), nil
+	}
+	return IntValue(utf8.RuneCountInString(args[0].Text())), nil
+}
+
+// substrFunction is SUBSTR(x, start, length) which is length characters of x
+// beginning at the 1 based position start. A negative start counts from the
+// end of x and a negative length is the characters before start. Without
+// length every character after start is included.
+func substrFunction(args []Value) (Value, error) {
+	if anyNull(args...) {
+		return NullValue(), nil
+	}
+	s := []rune(args[0].Text())
+	start := castValue(args[1], IntegerAffinity).Int()
+	length := math.MaxInt
+	negativeLength := false
+	if len(args) == 3 {
+		length = castValue(args[2], IntegerAffinity).Int()
+		if length < 0 {
+			length = -length
+			negativeLength = true
+		}
+	}
+	if start < 0 {
+		start += len(s)
+		if start < 0 {
+			length = max(length+start, 0)
+			start = 0
+		}
+	} else if start > 0 {
+		start -= 1
+	} else if length > 0 {
+		// Position 0 is before the first character so it takes up one
+		// character of the length.
+		length -= 1
+	}
+	if negativeLength {
+		start -= length
+		if start < 0 {
+			length += start
+			start = 0
+		}
+	}
+	if start > len(s) {
+		return TextValue(""), nil
+	}
+	length = min(length, len(s)-start)
+	return TextValue(string(s[start : start+length])), nil
+}
+
+// trimFunction is TRIM(x, characters) which is x without any of characters at
+// its beginning or end. Without characters spaces are removed.
+func trimFunction(args []Value) (Value, error) {
+	if anyNull(args...) {
+		return NullValue(), nil
+	}
+	cutset := " "
+	if len(args) == 2 {
+		cutset = args[1].Text()
+	}
+	return TextValue(strings.Trim(args[0].Text(), cutset)), nil
+}
+
+// absFunction is ABS(x) which is the absolute value of x. Text is converted to
+// a number first.
+func absFunction(args []Value) (Value, error) {
+	v := args[0]
+	if v.IsNull() {
+		return v, nil
+	}
+	if !isNumber(v) {
+		v = castValue(v, NumericAffinity)
+	}
+	if v.Type() == FloatType {
+		return FloatValue(math.Abs(v.Float())), nil
+	}
+	if v.Int() == math.MinInt64 {
+		return Value{}, fmt.Errorf("%w: ABS(%d)", errIntegerOverflow, v.Int())
+	}
+	if v.Int() < 0 {
+		return IntValue(-v.Int()), nil
+	}
+	return v, nil
+}
+
+// roundFunction is ROUND(x, digits) which is x rounded half away from zero to
+// digits decimal places. digits is 0 when it is not given and is kept between 0
+// and 30. The result is always a float.
+func roundFunction(args []Value) (Value, error) {
+	if anyNull(args...) {
+		return NullValue(), nil
+	}
+	x := castValue(args[0], RealAffinity).Float()
+	digits := 0
+	if len(args) == 2 {
+		digits = min(max(castValue(args[1], IntegerAffinity).Int(), 0), 30)
+	}
+	if digits == 0 {
+		return FloatValue(math.Round(x)), nil
+	}
+	pow := math.Pow(10, float64(digits))
+	rounded := math.Round(x*pow) / pow
+	if math.IsInf(rounded, 0) || math.IsNaN(rounded) {
+		// x has no digits that small so it is already rounded.
+		return FloatValue(x), nil
+	}
+	return FloatValue(rounded), nil
+}
+
+// minFunction is MIN(x, y, ...) which is the least argument. The result is
+// NULL when any argument is NULL.
+func minFunction(args []Value) (Value, error) {
+	return extremeValue(args, -1), nil
+}
+
+// maxFunction is MAX(x, y, ...) which is the greatest argument. The result is
+// NULL when any argument is NULL.
+func maxFunction(args []Value) (Value, error) {
+	return extremeValue(args, 1), nil
+}
+
+// extremeValue returns the value in args that compares to every other value
+// with the sign of direction.
+func extremeValue(args []Value, direction int) Value {
+	if anyNull(args...) {
+		return NullValue()
+	}
+	extreme := args[0]
+	for _, v := range args[1:] {
+		if compareValues(v, extreme)*direction > 0 {
+			extreme = v
+		}
+	}
+	return extreme
+}
+
+// randomFunction is RANDOM() which is a pseudo random 64 bit integer.
+func randomFunction(args []Value) (Value, error) {
+	return IntValue(int(rand.Uint64())), nil
+}
+
+// coalesceFunction is COALESCE(x, y, ...) which is the first argument that is
+// not NULL. IFNULL(x, y) is the same as COALESCE with two arguments.
+func coalesceFunction(args []Value) (Value, error) {
+	for _, v := range args {
+		if !v.IsNull() {
+			return v, nil
+		}
+	}
+	return NullValue(), nil
+}
@@ -0,0 +1,140 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestTypeof(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(kv)
+	ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&IntegerCmd{P1: 1, P2: 1},
+		&StringCmd{P1: 2, P4: "a"},
+		&TypeofCmd{P1: 1, P2: 4},
+		&TypeofCmd{P1: 2, P2: 5},
+		// Register 3 is never set so it is NULL.
+		&TypeofCmd{P1: 3, P2: 6},
+		&ResultRowCmd{P1: 4, P2: 3},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	want := []string{"integer", "text", "null"}
+	for i, w := range want {
+		if got := res.ResultRows[0][i].Text(); got != w {
+			t.Fatalf("want %s got %s", w, got)
+		}
+	}
+}
+
+func TestStringFunctions(t *testing.T) {
+	type functionCase struct {
+		name string
+		args []Value
+		want Value
+	}
+	cases := []functionCase{
+		{name: "UPPER", args: []Value{TextValue("aBc")}, want: TextValue("ABC")},
+		{name: "UPPER", args: []Value{NullValue()}, want: NullValue()},
+		{name: "LOWER", args: []Value{TextValue("aBc")}, want: TextValue("abc")},
+		{name: "LENGTH", args: []Value{TextValue("héllo")}, want: IntValue(5)},
+		{name: "LENGTH", args: []Value{IntValue(-12)}, want: IntValue(3)},
+		{name: "LENGTH", args: []Value{BlobValue([]byte("héllo"))}, want: IntValue(6)},
+		{name: "LENGTH", args: []Value{NullValue()}, want: NullValue()},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(2)}, want: TextValue("ello")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(2), IntValue(3)}, want: TextValue("ell")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(0), IntValue(2)}, want: TextValue("h")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(-3)}, want: TextValue("llo")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(-7), IntValue(4)}, want: TextValue("he")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(4), IntValue(-2)}, want: TextValue("el")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), IntValue(9)}, want: TextValue("")},
+		{name: "SUBSTR", args: []Value{TextValue("hello"), NullValue()}, want: NullValue()},
+		{name: "TRIM", args: []Value{TextValue("  a b  ")}, want: TextValue("a b")},
+		{name: "TRIM", args: []Value{TextValue("xxaxyx"), TextValue("xy")}, want: TextValue("a")},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s%v", c.name, c.args), func(t *testing.T) {
+			got, err := CallFunction(c.name, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+
+	t.Run("wrong number of args", func(t *testing.T) {
+		if _, err := CallFunction("SUBSTR", []Value{TextValue("a")}); err == nil {
+			t.Fatal("expected err")
+		}
+	})
+}
+
+func TestNumericFunctions(t *testing.T) {
+	type functionCase struct {
+		name string
+		args []Value
+		want Value
+	}
+	cases := []functionCase{
+		{name: "ABS", args: []Value{IntValue(-3)}, want: IntValue(3)},
+		{name: "ABS", args: []Value{FloatValue(-1.5)}, want: FloatValue(1.5)},
+		{name: "ABS", args: []Value{TextValue("-2")}, want: IntValue(2)},
+		{name: "ABS", args: []Value{NullValue()}, want: NullValue()},
+		{name: "ROUND", args: []Value{FloatValue(2.5)}, want: FloatValue(3)},
+		{name: "ROUND", args: []Value{FloatValue(-2.5)}, want: FloatValue(-3)},
+		{name: "ROUND", args: []Value{FloatValue(1.2345), IntValue(2)}, want: FloatValue(1.23)},
+		{name: "ROUND", args: []Value{IntValue(7), IntValue(-1)}, want: FloatValue(7)},
+		{name: "ROUND", args: []Value{NullValue()}, want: NullValue()},
+		{name: "MIN", args: []Value{IntValue(3), FloatValue(2.5), IntValue(4)}, want: FloatValue(2.5)},
+		{name: "MIN", args: []Value{TextValue("a"), IntValue(9)}, want: IntValue(9)},
+		{name: "MIN", args: []Value{IntValue(1), NullValue()}, want: NullValue()},
+		{name: "MAX", args: []Value{IntValue(3), TextValue("a"), IntValue(4)}, want: TextValue("a")},
+		{name: "COALESCE", args: []Value{NullValue(), NullValue(), IntValue(2)}, want: IntValue(2)},
+		{name: "IFNULL", args: []Value{NullValue(), NullValue()}, want: NullValue()},
+	}
+	for _, c := range cases {
+		t.Run(fmt.Sprintf("%s%v", c.name, c.args), func(t *testing.T) {
+			got, err := CallFunction(c.name, c.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %s got %s", c.want, got)
+			}
+		})
+	}
+
+	t.Run("ABS overflow", func(t *testing.T) {
+		if _, err := CallFunction("ABS", []Value{IntValue(math.MinInt64)}); !errors.Is(err, errIntegerOverflow) {
+			t.Fatalf("expected overflow err got %v", err)
+		}
+	})
+
+	t.Run("RANDOM", func(t *testing.T) {
+		if IsDeterministic("RANDOM") {
+			t.Fatal("expected RANDOM to not be deterministic")
+		}
+		got, err := CallFunction("RANDOM", []Value{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Type() != IntegerType {
+			t.Fatalf("expected integer got %s", got.Type())
+		}
+	})
+}
@@ -0,0 +1,545 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
+)
+
+// errIndexEntryMissing is returned when deleting an index entry that does not
+// exist which means the index is out of sync with its table.
+var errIndexEntryMissing = errors.New("index entry missing")
+
+// indexKey returns the key of the index entry for the value in register
+// valueRegister and the row id in register rowIdRegister. The value of the
+// entry is the row id encoded the same as a table key unless includeRegisters,
+// a list made by IntList, has the registers of the values included in the
+// entry. Then the value is a record of those values.
+func (r *routine) indexKey(valueRegister, rowIdRegister int, includeRegisters string) (key []byte, value []byte, err error) {
+	rowId, err := toInt(r.registers[rowIdRegister])
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err = kv.EncodeIndexKey(r.registers[valueRegister].Any(), rowId)
+	if err != nil {
+		return nil, nil, err
+	}
+	if includeRegisters == "" {
+		value, err = kv.EncodeKey(rowId)
+		if err != nil {
+			return nil, nil, err
+		}
+		return key, value, nil
+	}
+	registers, err := parseIntList(includeRegisters)
+	if err != nil {
+		return nil, nil, err
+	}
+	included := make([]any, len(registers))
+	for i, register := range registers {
+		included[i] = r.registers[register].Any()
+	}
+	value, err = kv.Encode(included)
+	if err != nil {
+		return nil, nil, err
+	}
+	return key, value, nil
+}
+
+// IntList returns ints as the list P4 of IdxInsertCmd and OpenIndexCmd expect.
+func IntList(ints []int) string {
+	s := make([]string, len(ints))
+	for i, n := range ints {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// parseIntList returns the ints of a list made by IntList.
+func parseIntList(list string) ([]int, error) {
+	if list == "" {
+		return nil, nil
+	}
+	s := strings.Split(list, ",")
+	ints := make([]int, len(s))
+	for i := range s {
+		n, err := strconv.Atoi(s[i])
+		if err != nil {
+			return nil, fmt.Errorf("malformed list %s: %w", list, err)
+		}
+		ints[i] = n
+	}
+	return ints, nil
+}
+
+// IdxInsertCmd inserts into index cursor P1 an entry for the value in register
+// P2 and the row id in register P3. P4 is empty or a list made by IntList of
+// the registers of the values the entry includes. When P5 is 1 the entry is
+// held until IdxBulkLoadCmd which is much faster when building an index for
+// many rows.
+type IdxInsertCmd cmd
+
+func (c *IdxInsertCmd) execute(vm *vm, routine *routine) cmdRes {
+	key, value, err := routine.indexKey(c.P2, c.P3, c.P4)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if c.P5 == 1 {
+		routine.bulkLoads[c.P1] = append(
+			routine.bulkLoads[c.P1],
+			pager.PageTuple{Key: key, Value: value},
+		)
+		return cmdRes{}
+	}
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if err := wc.Set(key, value); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxInsertCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Insert into index cursor %d the value in register[%d] for row id register[%d]", c.P1, c.P2, c.P3)
+	if c.P4 != "" {
+		comment += fmt.Sprintf(" including registers[%s]", c.P4)
+	}
+	return formatExplain(addr, "IdxInsert", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// IdxBulkLoadCmd writes the entries held by IdxInsertCmd to the empty index
+// cursor P1. When P2 is 1 the index is unique and nothing is written if two
+// entries have equal values. Instead the err is the message in P4.
+type IdxBulkLoadCmd cmd
+
+func (c *IdxBulkLoadCmd) execute(vm *vm, routine *routine) cmdRes {
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	tuples := routine.bulkLoads[c.P1]
+	delete(routine.bulkLoads, c.P1)
+	if c.P2 == 1 {
+		if err := errIfDuplicateValues(tuples, c.P4); err != nil {
+			return cmdRes{err: err}
+		}
+	}
+	if err := wc.BulkLoad(tuples); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxBulkLoadCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Write the held entries to index cursor %d", c.P1)
+	return formatExplain(addr, "IdxBulkLoad", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// errIfDuplicateValues sorts tuples and returns an err with message when two
+// of the index entries have equal values. Entries with equal values are
+// neighbors once sorted. NULL values are never equal to each other.
+func errIfDuplicateValues(tuples []pager.PageTuple, message string) error {
+	kv.SortTuples(tuples, runtime.GOMAXPROCS(0))
+	var previous Value
+	for i, t := range tuples {
+		v, _, err := kv.DecodeIndexKey(t.Key)
+		if err != nil {
+			return err
+		}
+		value := mustValueOf(v)
+		if i > 0 && !value.IsNull() && !previous.IsNull() && compareWithAffinity(previous, value) == 0 {
+			return errors.New(message)
+		}
+		previous = value
+	}
+	return nil
+}
+
+// IdxNotExistsCmd jumps to P2 if index cursor P1 has no entry equal to the
+// value in register P3 otherwise it falls through. A NULL value is never equal
+// to an entry so it always jumps. This is how a unique index is enforced. When
+// P5 is not 0 and there is an equal entry the row id of the entry is stored in
+// register P5 so the conflicting row can be found.
+type IdxNotExistsCmd cmd
+
+func (c *IdxNotExistsCmd) execute(vm *vm, routine *routine) cmdRes {
+	value := routine.registers[c.P3]
+	if value.IsNull() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	kc, ok := routine.cursors[c.P1].(*kv.Cursor)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not an index write cursor", c.P1)}
+	}
+	found, err := (&indexCursor{cursor: kc}).seek(value)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	if c.P5 != 0 {
+		_, rowId, err := kv.DecodeIndexKey(kc.GetKey())
+		if err != nil {
+			return cmdRes{err: err}
+		}
+		routine.registers[c.P5] = IntValue(rowId)
+	}
+	return cmdRes{}
+}
+
+func (c *IdxNotExistsCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to %d if index cursor %d has no entry equal to register[%d]", c.P2, c.P1, c.P3)
+	if c.P5 != 0 {
+		comment += fmt.Sprintf(" otherwise store its row id in register[%d]", c.P5)
+	}
+	return formatExplain(addr, "IdxNotExists", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *IdxNotExistsCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// IdxDeleteCmd deletes from index cursor P1 the entry for the value in register
+// P2 and the row id in register P3.
+type IdxDeleteCmd cmd
+
+func (c *IdxDeleteCmd) execute(vm *vm, routine *routine) cmdRes {
+	key, _, err := routine.indexKey(c.P2, c.P3, "")
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !wc.GotoKey(key) {
+		return cmdRes{err: errIndexEntryMissing}
+	}
+	if err := wc.DeleteCurrent(); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxDeleteCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Delete from index cursor %d the value in register[%d] for row id register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "IdxDelete", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// indexCursor is a read only cursor over an index. Each entry of the index is
+// presented as the row of the indexed table it belongs to where the indexed
+// column and the columns the entry includes are the only columns with a value.
+// This way an index can stand in for its table when a query only uses those
+// columns and the row id.
+type indexCursor struct {
+	cursor *kv.Cursor
+	// colIdx is the position of the indexed column in the rows of the table or
+	// -1 when the indexed column is the primary key.
+	colIdx int
+	// include are the positions in the rows of the table of the columns the
+	// entries include. The value of an entry is a record of their values when
+	// there are any and otherwise the row id. An index including values may be
+	// opened without include when only the indexed values are read.
+	include []int
+	// columnCount is the number of values in the rows of the table.
+	columnCount int
+	// value is the value being sought by seek.
+	value Value
+	// prefixes are the key prefixes of the entries that may be equal to value.
+	prefixes [][]byte
+	// prefixIdx is the position in prefixes of the entries being visited.
+	prefixIdx int
+	// prefixOnly is true when every entry starting with one of prefixes is
+	// visited rather than only the entries equal to value. See seekTextPrefix.
+	prefixOnly bool
+}
+
+func (ic *indexCursor) GotoFirstRecord() bool {
+	return ic.cursor.GotoFirstRecord()
+}
+
+func (ic *indexCursor) GotoNext() bool {
+	return ic.cursor.GotoNext()
+}
+
+func (ic *indexCursor) GotoLastRecord() bool {
+	return ic.cursor.GotoLastRecord()
+}
+
+func (ic *indexCursor) GotoPrev() bool {
+	return ic.cursor.GotoPrev()
+}
+
+func (ic *indexCursor) GotoKey(key []byte) bool {
+	return ic.cursor.GotoKey(key)
+}
+
+// GetKey returns the row id of the current entry encoded the same as the key
+// of the table. The row id is taken from the key of the entry since the value
+// of an entry including values is not the row id.
+func (ic *indexCursor) GetKey() []byte {
+	_, rowId, err := kv.DecodeIndexKey(ic.cursor.GetKey())
+	if err != nil {
+		return nil
+	}
+	key, _ := kv.EncodeKey(rowId)
+	return key
+}
+
+// GetValue returns a record holding the indexed value at the position of the
+// indexed column and the included values at the positions of their columns.
+func (ic *indexCursor) GetValue() []byte {
+	row := make([]any, ic.columnCount)
+	if ic.colIdx != -1 {
+		v, _, err := kv.DecodeIndexKey(ic.cursor.GetKey())
+		if err == nil {
+			row[ic.colIdx] = v
+		}
+	}
+	if len(ic.include) != 0 {
+		included, err := kv.Decode(ic.cursor.GetValue())
+		if err == nil && len(included) == len(ic.include) {
+			for i, colIdx := range ic.include {
+				row[colIdx] = included[i]
+			}
+		}
+	}
+	record, _ := kv.Encode(row)
+	return record
+}
+
+func (ic *indexCursor) Count() int {
+	return ic.cursor.Count()
+}
+
+func (ic *indexCursor) Exists(key []byte) bool {
+	return ic.cursor.Exists(key)
+}
+
+// seek moves the cursor to the first entry equal to value and returns false if
+// there is no such entry. Equal means equal as a comparison so an integer is
+// equal to text that is the same integer.
+func (ic *indexCursor) seek(value Value) (bool, error) {
+	prefixes, err := seekPrefixes(value)
+	if err != nil {
+		return false, err
+	}
+	ic.value = value
+	ic.prefixes = prefixes
+	ic.prefixIdx = 0
+	ic.prefixOnly = false
+	return ic.find(ic.cursor.SeekGE(prefixes[0]))
+}
+
+// seekTextPrefix moves the cursor to the first entry that may start with the
+// text prefix and returns false if there is no such entry. A number may start
+// with prefix once it is converted to text so every number entry is visited as
+// well. These are few in an index on a column of text.
+func (ic *indexCursor) seekTextPrefix(prefix string) (bool, error) {
+	numberPrefix, err := kv.EncodeIndexTypePrefix(0)
+	if err != nil {
+		return false, err
+	}
+	ic.value = NullValue()
+	ic.prefixes = [][]byte{numberPrefix, kv.EncodeIndexTextPrefix(prefix)}
+	ic.prefixIdx = 0
+	ic.prefixOnly = true
+	return ic.find(ic.cursor.SeekGE(ic.prefixes[0]))
+}
+
+// nextMatch moves the cursor to the next entry equal to the value of seek and
+// returns false if there is no such entry.
+func (ic *indexCursor) nextMatch() (bool, error) {
+	return ic.find(ic.cursor.GotoNext())
+}
+
+// find moves the cursor forward to the first entry equal to the sought value
+// starting with the current entry. ok is false when there is no current entry.
+func (ic *indexCursor) find(ok bool) (bool, error) {
+	for ic.prefixIdx < len(ic.prefixes) {
+		if ok && bytes.HasPrefix(ic.cursor.GetKey(), ic.prefixes[ic.prefixIdx]) {
+			if ic.prefixOnly {
+				return true, nil
+			}
+			v, _, err := kv.DecodeIndexKey(ic.cursor.GetKey())
+			if err != nil {
+				return false, err
+			}
+			if compareWithAffinity(mustValueOf(v), ic.value) == 0 {
+				return true, nil
+			}
+			ok = ic.cursor.GotoNext()
+			continue
+		}
+		ic.prefixIdx += 1
+		if ic.prefixIdx < len(ic.prefixes) {
+			ok = ic.cursor.SeekGE(ic.prefixes[ic.prefixIdx])
+		}
+	}
+	return false, nil
+}
+
+// seekPrefixes returns the key prefixes of the index entries that may be equal
+// to value. A number is equal to an integer or float of the same value so both
+// are found by a prefix they share. Numeric affinity makes an integer equal to
+// text that is a well formed integer such as '12' or '012'. Text like '012'
+// cannot be found by its prefix so a number is also compared with all text
+// entries which are few in an index on a column of numbers.
+func seekPrefixes(value Value) ([][]byte, error) {
+	switch value.Type() {
+	case IntegerType, FloatType:
+		prefix, err := kv.EncodeIndexNumberPrefix(value.Any())
+		if err != nil {
+			return nil, err
+		}
+		textPrefix, err := kv.EncodeIndexTypePrefix("")
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{prefix, textPrefix}, nil
+	}
+	prefix, err := kv.EncodeIndexPrefix(value.Any())
+	if err != nil {
+		return nil, err
+	}
+	prefixes := [][]byte{prefix}
+	if value.Type() == TextType {
+		if i, err := strconv.Atoi(value.Text()); err == nil {
+			intPrefix, err := kv.EncodeIndexNumberPrefix(i)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, intPrefix)
+		}
+	}
+	return prefixes, nil
+}
+
+// getIndexCursor returns cursor id as an index cursor.
+func (r *routine) getIndexCursor(id int) (*indexCursor, error) {
+	ic, ok := r.cursors[id].(*indexCursor)
+	if !ok {
+		return nil, fmt.Errorf("cursor %d is not an index cursor", id)
+	}
+	return ic, nil
+}
+
+// OpenIndexCmd opens a read cursor named P1 on the index with root page P2. The
+// cursor presents each entry as a row of P5 values where the indexed value is
+// the P3-th value. P3 is -1 when the index is on the primary key. P4 is empty or
+// a list made by IntList of the positions in the row of the values the entries
+// include.
+type OpenIndexCmd cmd
+
+func (c *OpenIndexCmd) execute(vm *vm, routine *routine) cmdRes {
+	include, err := parseIntList(c.P4)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.cursors[c.P1] = &indexCursor{
+		cursor:      routine.newCursor(vm, c.P2),
+		colIdx:      c.P3,
+		include:     include,
+		columnCount: c.P5,
+	}
+	return cmdRes{}
+}
+
+func (c *OpenIndexCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Open index cursor with id %d at root page %d", c.P1, c.P2)
+	return formatExplain(addr, "OpenIndex", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// IdxSeekCmd moves index cursor P1 to the first entry equal to the value in
+// register P3. If there is no such entry it jumps to P2.
+type IdxSeekCmd cmd
+
+func (c *IdxSeekCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	ic, err := routine.getIndexCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := ic.seek(routine.registers[c.P3])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxSeekCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move index cursor %d to the first entry equal to register[%d] or jump to %d", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "IdxSeek", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *IdxSeekCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// IdxPrefixSeekCmd moves index cursor P1 to the first entry that may begin with
+// the text in register P3. If there is no such entry it jumps to P2. Entries
+// are visited by IdxNextCmd which includes every entry with the prefix, but
+// may include entries without it so the rows must still be filtered.
+type IdxPrefixSeekCmd cmd
+
+func (c *IdxPrefixSeekCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	ic, err := routine.getIndexCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := ic.seekTextPrefix(routine.registers[c.P3].Text())
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxPrefixSeekCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move index cursor %d to the first entry that may begin with register[%d] or jump to %d", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "IdxPrefixSeek", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *IdxPrefixSeekCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// IdxNextCmd moves index cursor P1 to the next entry equal to the value of the
+// last IdxSeekCmd or with the prefix of the last IdxPrefixSeekCmd. If there is
+// such an entry it jumps to P2.
+type IdxNextCmd cmd
+
+func (c *IdxNextCmd) execute(vm *vm, routine *routine) cmdRes {
+	ic, err := routine.getIndexCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := ic.nextMatch()
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *IdxNextCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move index cursor %d to the next equal entry and jump to %d if there is one", c.P1, c.P2)
+	return formatExplain(addr, "IdxNext", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
@@ -0,0 +1,217 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestIndexCommands(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	root := k.NewBTree()
+	if err := k.EndWriteTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	t.Run("insert and delete", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&OpenWriteCmd{P1: 1, P2: root},
+			&IntegerCmd{P1: 1, P2: 1},
+			&StringCmd{P1: 2, P4: "b"},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1, P5: 1},
+			&IntegerCmd{P1: 2, P2: 1},
+			&StringCmd{P1: 2, P4: "a"},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1, P5: 1},
+			&IdxBulkLoadCmd{P1: 1},
+			&IntegerCmd{P1: 3, P2: 1},
+			&StringCmd{P1: 2, P4: "c"},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1},
+			&IntegerCmd{P1: 1, P2: 1},
+			&StringCmd{P1: 2, P4: "b"},
+			&IdxDeleteCmd{P1: 1, P2: 2, P3: 1},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		snapshot, err := k.BeginReadTransaction(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer k.EndReadTransaction(snapshot)
+		c := snapshot.NewCursor(root)
+		got := [][]byte{}
+		for ok := c.GotoFirstRecord(); ok; ok = c.GotoNext() {
+			got = append(got, c.GetKey())
+		}
+		want := [][]byte{}
+		for _, entry := range []struct {
+			value string
+			rowId int
+		}{{"a", 2}, {"c", 3}} {
+			key, err := kv.EncodeIndexKey(entry.value, entry.rowId)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want = append(want, key)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("not exists row id", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&OpenWriteCmd{P1: 1, P2: root},
+			&StringCmd{P1: 1, P4: "c"},
+			&IdxNotExistsCmd{P1: 1, P2: 6, P3: 1, P5: 2},
+			&ResultRowCmd{P1: 2, P2: 1},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != "3" {
+			t.Fatalf("expected the row id 3 of entry c got %v", res.ResultRows)
+		}
+	})
+
+	t.Run("seek", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&OpenWriteCmd{P1: 1, P2: root},
+			&IntegerCmd{P1: 4, P2: 1},
+			&IntegerCmd{P1: 12, P2: 2},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1},
+			&IntegerCmd{P1: 5, P2: 1},
+			&StringCmd{P1: 2, P4: "012"},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1},
+			&IntegerCmd{P1: 6, P2: 1},
+			&StringCmd{P1: 2, P4: "12a"},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1},
+			&HaltCmd{},
+		}
+		if res := vm.Execute(ep, []any{}); res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+
+		ep = NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 8},
+			&OpenIndexCmd{P1: 1, P2: root, P3: 0, P5: 1},
+			&IdxSeekCmd{P1: 1, P2: 7, P3: 1},
+			&RowIdCmd{P1: 1, P2: 2},
+			&ColumnCmd{P1: 1, P2: 0, P3: 3},
+			&ResultRowCmd{P1: 2, P2: 2},
+			&IdxNextCmd{P1: 1, P2: 3},
+			&HaltCmd{},
+			&TransactionCmd{P2: 0},
+			&IntegerCmd{P1: 12, P2: 1},
+			&GotoCmd{P2: 1},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			got = append(got, []string{row[0].Text(), row[1].Text()})
+		}
+		want := [][]string{{"4", "12"}, {"5", "012"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("include", func(t *testing.T) {
+		if err := k.BeginWriteTransaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		includeRoot := k.NewBTree()
+		if err := k.EndWriteTransaction(); err != nil {
+			t.Fatal(err)
+		}
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&OpenWriteCmd{P1: 1, P2: includeRoot},
+			&IntegerCmd{P1: 7, P2: 1},
+			&StringCmd{P1: 2, P4: "a"},
+			&IntegerCmd{P1: 30, P2: 3},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1, P4: IntList([]int{3})},
+			&IntegerCmd{P1: 8, P2: 1},
+			&StringCmd{P1: 2, P4: "b"},
+			&IntegerCmd{P1: 40, P2: 3},
+			&IdxInsertCmd{P1: 1, P2: 2, P3: 1, P4: IntList([]int{3})},
+			&HaltCmd{},
+		}
+		if res := vm.Execute(ep, []any{}); res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+
+		ep = NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 9},
+			&OpenIndexCmd{P1: 1, P2: includeRoot, P3: 1, P4: IntList([]int{0}), P5: 2},
+			&RewindCmd{P1: 1, P2: 8},
+			&RowIdCmd{P1: 1, P2: 1},
+			&ColumnCmd{P1: 1, P2: 1, P3: 2},
+			&ColumnCmd{P1: 1, P2: 0, P3: 3},
+			&ResultRowCmd{P1: 1, P2: 3},
+			&NextCmd{P1: 1, P2: 3},
+			&HaltCmd{},
+			&TransactionCmd{P2: 0},
+			&GotoCmd{P2: 1},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		got := [][]string{}
+		for _, row := range res.ResultRows {
+			got = append(got, []string{row[0].Text(), row[1].Text(), row[2].Text()})
+		}
+		want := [][]string{{"7", "a", "30"}, {"8", "b", "40"}}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("want %v got %v", want, got)
+		}
+	})
+
+	t.Run("delete missing entry", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&OpenWriteCmd{P1: 1, P2: root},
+			&IntegerCmd{P1: 9, P2: 1},
+			&StringCmd{P1: 2, P4: "z"},
+			&IdxDeleteCmd{P1: 1, P2: 2, P3: 1},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if !errors.Is(res.Err, errIndexEntryMissing) {
+			t.Fatalf("expected %s got %v", errIndexEntryMissing, res.Err)
+		}
+	})
+}
@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrInterrupted is the err of a statement that was stopped by Interrupt. The
+// transaction of the statement is rolled back.
+var ErrInterrupted = errors.New("interrupted")
+
+// interrupts tracks the routines currently executing so they can be stopped
+// by Interrupt. Each routine runs with a context that is canceled with the
+// cause ErrInterrupted when it is interrupted.
+type interrupts struct {
+	mu      sync.Mutex
+	nextId  int
+	cancels map[int]context.CancelCauseFunc
+}
+
+func newInterrupts() *interrupts {
+	return &interrupts{
+		cancels: map[int]context.CancelCauseFunc{},
+	}
+}
+
+// track returns a context derived from ctx that is canceled by interruptAll
+// and a function that must be called when the routine is done executing.
+func (i *interrupts) track(ctx context.Context) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	id := i.nextId
+	i.nextId += 1
+	i.cancels[id] = cancel
+	return ctx, func() {
+		i.mu.Lock()
+		defer i.mu.Unlock()
+		delete(i.cancels, id)
+		cancel(nil)
+	}
+}
+
+// interruptAll cancels the context of every routine currently executing.
+func (i *interrupts) interruptAll() {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, cancel := range i.cancels {
+		cancel(ErrInterrupted)
+	}
+}
+
+// Interrupt stops every statement currently executing on the vm. The stopped
+// statements return ErrInterrupted and their transactions are rolled back.
+// Statements that begin after Interrupt returns are unaffected. Interrupt is
+// safe to call from any goroutine.
+func (v *vm) Interrupt() {
+	v.interrupts.interruptAll()
+}
+
+// stopErr returns ErrInterrupted when the routine has been interrupted or
+// ErrTimeout when the routine ran past its query timeout. Otherwise stopErr
+// returns nil.
+func (r *routine) stopErr() error {
+	select {
+	case <-r.ctx.Done():
+		cause := context.Cause(r.ctx)
+		if errors.Is(cause, ErrInterrupted) || errors.Is(cause, ErrTimeout) {
+			return cause
+		}
+		return nil
+	default:
+		return nil
+	}
+}
+
+// beginErr is the err of a transaction that failed to begin. A transaction
+// that stopped waiting for its lock because the routine was interrupted or
+// timed out is ErrInterrupted or ErrTimeout rather than busy.
+func (r *routine) beginErr(err error) error {
+	if stopErr := r.stopErr(); stopErr != nil {
+		return stopErr
+	}
+	return err
+}
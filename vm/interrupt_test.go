@@ -0,0 +1,88 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestInterrupt(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	// interruptUntilDone interrupts the vm until res is sent since Interrupt
+	// only stops routines that are executing.
+	interruptUntilDone := func(res chan *ExecuteResult) *ExecuteResult {
+		for {
+			vm.Interrupt()
+			select {
+			case r := <-res:
+				return r
+			case <-time.After(time.Millisecond):
+			}
+		}
+	}
+
+	t.Run("running", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&GotoCmd{P2: 2},
+		}
+		res := make(chan *ExecuteResult)
+		go func() {
+			res <- vm.Execute(ep, []any{})
+		}()
+		if r := interruptUntilDone(res); !errors.Is(r.Err, ErrInterrupted) {
+			t.Fatalf("expected %s got %v", ErrInterrupted, r.Err)
+		}
+		// The write transaction of the interrupted routine is rolled back so
+		// another write can begin.
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := k.BeginWriteTransaction(ctx); err != nil {
+			t.Fatalf("expected write lock to be released got %s", err)
+		}
+		k.RollbackWrite()
+	})
+
+	t.Run("waiting for lock", func(t *testing.T) {
+		if err := k.BeginWriteTransaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer k.RollbackWrite()
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&HaltCmd{},
+		}
+		res := make(chan *ExecuteResult)
+		go func() {
+			res <- vm.Execute(ep, []any{})
+		}()
+		if r := interruptUntilDone(res); !errors.Is(r.Err, ErrInterrupted) {
+			t.Fatalf("expected %s got %v", ErrInterrupted, r.Err)
+		}
+	})
+
+	t.Run("later statements run", func(t *testing.T) {
+		vm.Interrupt()
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 0},
+			&HaltCmd{},
+		}
+		if r := vm.Execute(ep, []any{}); r.Err != nil {
+			t.Fatalf("expected no err got %s", r.Err)
+		}
+	})
+}
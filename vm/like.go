@@ -0,0 +1,66 @@
+package vm
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+// LikeCmd stores in register P3 whether the value in register P1 matches the
+// pattern in register P2. In the pattern % matches any sequence of zero or more
+// characters and _ matches any one character. Other characters match
+// themselves and are case sensitive. The result is NULL if either is NULL.
+type LikeCmd cmd
+
+func (c *LikeCmd) execute(vm *vm, routine *routine) cmdRes {
+	v := routine.registers[c.P1]
+	pattern := routine.registers[c.P2]
+	if anyNull(v, pattern) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	routine.registers[c.P3] = boolValue(like(v.Text(), pattern.Text()))
+	return cmdRes{}
+}
+
+func (c *LikeCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store register[%d] LIKE register[%d] in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "Like", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// like returns true when s matches pattern. When a % is followed by a mismatch
+// the match resumes one character further into s from the most recent %. Only
+// the most recent % needs to be retried since any earlier % can already absorb
+// whatever the later one would.
+func like(s, pattern string) bool {
+	si, pi := 0, 0
+	// starPi and starSi are the positions after the most recent % in pattern
+	// and the position in s it is currently matched up to. starPi is -1 until
+	// a % is seen.
+	starPi, starSi := -1, 0
+	for si < len(s) {
+		if pi < len(pattern) {
+			pc, pw := utf8.DecodeRuneInString(pattern[pi:])
+			sc, sw := utf8.DecodeRuneInString(s[si:])
+			switch {
+			case pc == '%':
+				pi += pw
+				starPi, starSi = pi, si
+				continue
+			case pc == '_' || pc == sc:
+				pi += pw
+				si += sw
+				continue
+			}
+		}
+		if starPi == -1 {
+			return false
+		}
+		_, sw := utf8.DecodeRuneInString(s[starSi:])
+		starSi += sw
+		si, pi = starSi, starPi
+	}
+	for pi < len(pattern) && pattern[pi] == '%' {
+		pi += 1
+	}
+	return pi == len(pattern)
+}
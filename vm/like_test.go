@@ -0,0 +1,34 @@
+package vm
+
+import "testing"
+
+func TestLike(t *testing.T) {
+	cases := []struct {
+		s       string
+		pattern string
+		want    bool
+	}{
+		{s: "abc", pattern: "abc", want: true},
+		{s: "abc", pattern: "ABC", want: false},
+		{s: "abc", pattern: "a%", want: true},
+		{s: "abc", pattern: "%c", want: true},
+		{s: "abc", pattern: "%b%", want: true},
+		{s: "abc", pattern: "a_c", want: true},
+		{s: "abc", pattern: "a_", want: false},
+		{s: "abc", pattern: "%", want: true},
+		{s: "", pattern: "%", want: true},
+		{s: "", pattern: "_", want: false},
+		{s: "abcbd", pattern: "a%b_", want: true},
+		{s: "abcbc", pattern: "a%bd", want: false},
+		{s: "aaa", pattern: "%a%a%a%", want: true},
+		{s: "aa", pattern: "%a%a%a%", want: false},
+		{s: "héllo", pattern: "h_llo", want: true},
+	}
+	for _, c := range cases {
+		t.Run(c.s+" LIKE "+c.pattern, func(t *testing.T) {
+			if got := like(c.s, c.pattern); got != c.want {
+				t.Fatalf("want %t got %t", c.want, got)
+			}
+		})
+	}
+}
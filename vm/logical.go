@@ -0,0 +1,101 @@
+package vm
+
+import "fmt"
+
+// truthValue returns the truth of v for a logical operator. known is false when
+// v is NULL since the truth of NULL is unknown.
+func truthValue(v Value) (truth, known bool, err error) {
+	if v.IsNull() {
+		return false, false, nil
+	}
+	i, err := toInt(v)
+	if err != nil {
+		return false, false, err
+	}
+	return i != 0, true, nil
+}
+
+// AndCmd stores the logical and of P1 and P2 in register P3. The result is 0
+// if either is false. Otherwise the result is NULL if either is NULL and 1 when
+// both are true.
+type AndCmd cmd
+
+func (c *AndCmd) execute(vm *vm, routine *routine) cmdRes {
+	l, lknown, err := truthValue(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	r, rknown, err := truthValue(routine.registers[c.P2])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	switch {
+	case (lknown && !l) || (rknown && !r):
+		routine.registers[c.P3] = IntValue(0)
+	case !lknown || !rknown:
+		routine.registers[c.P3] = NullValue()
+	default:
+		routine.registers[c.P3] = IntValue(1)
+	}
+	return cmdRes{}
+}
+
+func (c *AndCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store register[%d] AND register[%d] in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "And", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// OrCmd stores the logical or of P1 and P2 in register P3. The result is 1 if
+// either is true. Otherwise the result is NULL if either is NULL and 0 when
+// both are false.
+type OrCmd cmd
+
+func (c *OrCmd) execute(vm *vm, routine *routine) cmdRes {
+	l, lknown, err := truthValue(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	r, rknown, err := truthValue(routine.registers[c.P2])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	switch {
+	case (lknown && l) || (rknown && r):
+		routine.registers[c.P3] = IntValue(1)
+	case !lknown || !rknown:
+		routine.registers[c.P3] = NullValue()
+	default:
+		routine.registers[c.P3] = IntValue(0)
+	}
+	return cmdRes{}
+}
+
+func (c *OrCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store register[%d] OR register[%d] in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "Or", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// NotCmd stores the logical not of P1 in register P2. The result is NULL if P1
+// is NULL.
+type NotCmd cmd
+
+func (c *NotCmd) execute(vm *vm, routine *routine) cmdRes {
+	v, known, err := truthValue(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	switch {
+	case !known:
+		routine.registers[c.P2] = NullValue()
+	case v:
+		routine.registers[c.P2] = IntValue(0)
+	default:
+		routine.registers[c.P2] = IntValue(1)
+	}
+	return cmdRes{}
+}
+
+func (c *NotCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store NOT register[%d] in register[%d]", c.P1, c.P2)
+	return formatExplain(addr, "Not", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
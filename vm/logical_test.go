@@ -0,0 +1,67 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestLogicalCommands(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	cases := []struct {
+		name  string
+		cmd   Command
+		left  any
+		right any
+		// want is the result or nil for NULL.
+		want any
+	}{
+		{name: "true AND true", cmd: &AndCmd{P1: 1, P2: 2, P3: 3}, left: 1, right: 2, want: "1"},
+		{name: "true AND false", cmd: &AndCmd{P1: 1, P2: 2, P3: 3}, left: 1, right: 0, want: "0"},
+		{name: "NULL AND false", cmd: &AndCmd{P1: 1, P2: 2, P3: 3}, left: nil, right: 0, want: "0"},
+		{name: "NULL AND true", cmd: &AndCmd{P1: 1, P2: 2, P3: 3}, left: nil, right: 1, want: nil},
+		{name: "false OR false", cmd: &OrCmd{P1: 1, P2: 2, P3: 3}, left: 0, right: 0, want: "0"},
+		{name: "false OR true", cmd: &OrCmd{P1: 1, P2: 2, P3: 3}, left: 0, right: "1", want: "1"},
+		{name: "true OR NULL", cmd: &OrCmd{P1: 1, P2: 2, P3: 3}, left: 1, right: nil, want: "1"},
+		{name: "false OR NULL", cmd: &OrCmd{P1: 1, P2: 2, P3: 3}, left: 0, right: nil, want: nil},
+		{name: "NOT true", cmd: &NotCmd{P1: 1, P2: 3}, left: 2, want: "0"},
+		{name: "NOT false", cmd: &NotCmd{P1: 1, P2: 3}, left: 0, want: "1"},
+		{name: "NOT NULL", cmd: &NotCmd{P1: 1, P2: 3}, left: nil, want: nil},
+		{name: "negate", cmd: &NegCmd{P1: 1, P2: 3}, left: 2, want: "-2"},
+		{name: "negate NULL", cmd: &NegCmd{P1: 1, P2: 3}, left: nil, want: nil},
+		{name: "LIKE", cmd: &LikeCmd{P1: 1, P2: 2, P3: 3}, left: 12, right: "1%", want: "1"},
+		{name: "LIKE NULL", cmd: &LikeCmd{P1: 1, P2: 2, P3: 3}, left: "a", right: nil, want: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+			ep.Commands = []Command{
+				&InitCmd{P2: 1},
+				&VariableCmd{P1: 0, P2: 1},
+				&VariableCmd{P1: 1, P2: 2},
+				c.cmd,
+				&ResultRowCmd{P1: 3, P2: 1},
+				&HaltCmd{},
+			}
+			res := vm.Execute(ep, []any{c.left, c.right})
+			if res.Err != nil {
+				t.Fatalf("expected no err got %s", res.Err)
+			}
+			got := res.ResultRows[0][0]
+			if c.want == nil {
+				if !got.IsNull() {
+					t.Fatalf("expected NULL got %s", got)
+				}
+				return
+			}
+			if got.IsNull() || got.Text() != c.want {
+				t.Fatalf("expected %s got %v", c.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package vm
+
+import "fmt"
+
+// JumpIfNull is set as P5 of a comparison command to make the comparison jump
+// when either register is NULL. Comparing NULL to any value is neither true nor
+// false so by default comparisons fall through when either register is NULL.
+const JumpIfNull = 1
+
+// anyNull returns true if any of the values are NULL.
+func anyNull(values ...Value) bool {
+	for _, v := range values {
+		if v.IsNull() {
+			return true
+		}
+	}
+	return false
+}
+
+// compareJump is the result of a comparison command that jumps to c.P2 when
+// comparison is true. When either register is NULL the comparison is unknown
+// so the command only jumps if P5 is JumpIfNull.
+func compareJump(c *cmd, routine *routine, comparison func(int) bool) cmdRes {
+	a, b := routine.registers[c.P1], routine.registers[c.P3]
+	if anyNull(a, b) {
+		if c.P5 == JumpIfNull {
+			return cmdRes{nextAddress: c.P2}
+		}
+		return cmdRes{}
+	}
+	if comparison(compareWithAffinity(a, b)) {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+// NullCmd stores NULL in register P2.
+type NullCmd cmd
+
+func (c *NullCmd) execute(vm *vm, routine *routine) cmdRes {
+	routine.registers[c.P2] = NullValue()
+	return cmdRes{}
+}
+
+func (c *NullCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store NULL in register[%d]", c.P2)
+	return formatExplain(addr, "Null", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// IsNullCmd jumps to P2 if register P1 is NULL otherwise fall through.
+type IsNullCmd cmd
+
+func (c *IsNullCmd) execute(vm *vm, routine *routine) cmdRes {
+	if routine.registers[c.P1].IsNull() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *IsNullCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to address %d if register[%d] is NULL", c.P2, c.P1)
+	return formatExplain(addr, "IsNull", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *IsNullCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// NotNullCmd jumps to P2 if register P1 is not NULL otherwise fall through.
+type NotNullCmd cmd
+
+func (c *NotNullCmd) execute(vm *vm, routine *routine) cmdRes {
+	if !routine.registers[c.P1].IsNull() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *NotNullCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to address %d if register[%d] is not NULL", c.P2, c.P1)
+	return formatExplain(addr, "NotNull", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *NotNullCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
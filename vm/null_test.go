@@ -0,0 +1,86 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestNullCommands(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	cases := []struct {
+		name  string
+		jump  Command
+		left  any
+		right any
+		want  bool
+	}{
+		{name: "IsNull NULL", jump: &IsNullCmd{P1: 1, P2: 6}, left: nil, want: true},
+		{name: "IsNull int", jump: &IsNullCmd{P1: 1, P2: 6}, left: 0, want: false},
+		{name: "NotNull NULL", jump: &NotNullCmd{P1: 1, P2: 6}, left: nil, want: false},
+		{name: "NotNull text", jump: &NotNullCmd{P1: 1, P2: 6}, left: "", want: true},
+		{name: "NotEqual NULL", jump: &NotEqualCmd{P1: 1, P2: 6, P3: 2}, left: nil, right: 1, want: false},
+		{name: "NotEqual NULL NULL", jump: &NotEqualCmd{P1: 1, P2: 6, P3: 2}, left: nil, right: nil, want: false},
+		{name: "NotEqual jump if NULL", jump: &NotEqualCmd{P1: 1, P2: 6, P3: 2, P5: JumpIfNull}, left: nil, right: nil, want: true},
+		{name: "Gte NULL", jump: &GteCmd{P1: 1, P2: 6, P3: 2}, left: 1, right: nil, want: false},
+		{name: "Gte jump if NULL", jump: &GteCmd{P1: 1, P2: 6, P3: 2, P5: JumpIfNull}, left: nil, right: 1, want: true},
+		{name: "Lte NULL", jump: &LteCmd{P1: 1, P2: 6, P3: 2}, left: nil, right: 1, want: false},
+		{name: "Lte jump if NULL", jump: &LteCmd{P1: 1, P2: 6, P3: 2, P5: JumpIfNull}, left: 1, right: nil, want: true},
+		{name: "Eq NULL NULL", jump: &EqCmd{P1: 1, P2: 6, P3: 2}, left: nil, right: nil, want: false},
+		{name: "Eq jump if NULL", jump: &EqCmd{P1: 1, P2: 6, P3: 2, P5: JumpIfNull}, left: 1, right: nil, want: true},
+		{name: "Eq affinity", jump: &EqCmd{P1: 1, P2: 6, P3: 2}, left: "1", right: 1, want: true},
+		{name: "Gt NULL", jump: &GtCmd{P1: 1, P2: 6, P3: 2}, left: 2, right: nil, want: false},
+		{name: "Gt", jump: &GtCmd{P1: 1, P2: 6, P3: 2}, left: 2, right: 1, want: true},
+		{name: "Lt jump if NULL", jump: &LtCmd{P1: 1, P2: 6, P3: 2, P5: JumpIfNull}, left: nil, right: 1, want: true},
+		{name: "Lt equal", jump: &LtCmd{P1: 1, P2: 6, P3: 2}, left: 1, right: 1, want: false},
+		{name: "IfNot NULL", jump: &IfNotCmd{P1: 1, P2: 6}, left: nil, want: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+			ep.Commands = []Command{
+				&InitCmd{P2: 7},
+				&VariableCmd{P1: 0, P2: 1},
+				&VariableCmd{P1: 1, P2: 2},
+				c.jump,
+				&IntegerCmd{P1: 1, P2: 3},
+				&ResultRowCmd{P1: 3, P2: 1},
+				&HaltCmd{},
+				&GotoCmd{P2: 1},
+			}
+			res := vm.Execute(ep, []any{c.left, c.right})
+			if res.Err != nil {
+				t.Fatalf("expected no err got %s", res.Err)
+			}
+			if jumped := len(res.ResultRows) == 0; jumped != c.want {
+				t.Fatalf("expected jump %t got %t", c.want, jumped)
+			}
+		})
+	}
+
+	t.Run("arithmetic with NULL is NULL", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 6},
+			&NullCmd{P2: 1},
+			&AddCmd{P1: 1, P2: 2, P3: 3},
+			&DivideCmd{P1: 2, P2: 1, P3: 4},
+			&ResultRowCmd{P1: 3, P2: 2},
+			&HaltCmd{},
+			&IntegerCmd{P1: 1, P2: 2},
+			&GotoCmd{P2: 1},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		if len(res.ResultRows) != 1 || !res.ResultRows[0][0].IsNull() || !res.ResultRows[0][1].IsNull() {
+			t.Fatalf("expected NULL results got %v", res.ResultRows)
+		}
+	})
+}
@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/chirst/cdb/kv"
+)
+
+// errUnorderedCursor is returned when a command seeks a bound or moves backward
+// with a cursor that is not ordered by key.
+var errUnorderedCursor = errors.New("cursor is not ordered by key")
+
+// reverseCursor is a cursor that can move through a table in descending key
+// order.
+type reverseCursor interface {
+	cursor
+	GotoLastRecord() bool
+	GotoPrev() bool
+}
+
+// getReverseCursor returns cursor id or errUnorderedCursor if the cursor cannot
+// move in descending key order.
+func (r *routine) getReverseCursor(id int) (reverseCursor, error) {
+	rc, ok := r.cursors[id].(reverseCursor)
+	if !ok {
+		return nil, errUnorderedCursor
+	}
+	return rc, nil
+}
+
+// rangeCursor is a cursor that can be positioned relative to a key.
+type rangeCursor interface {
+	reverseCursor
+	SeekGE(key []byte) bool
+	SeekGT(key []byte) bool
+	SeekLE(key []byte) bool
+	SeekLT(key []byte) bool
+}
+
+// getRangeCursor returns cursor id or errUnorderedCursor if the cursor cannot
+// be positioned by a bound.
+func (r *routine) getRangeCursor(id int) (rangeCursor, error) {
+	rc, ok := r.cursors[id].(rangeCursor)
+	if !ok {
+		return nil, errUnorderedCursor
+	}
+	return rc, nil
+}
+
+// seekLowerBound moves the cursor to the first row that may have a row id
+// after bound. The key encoding orders negative row ids among the positive row
+// ids so a negative bound moves to the first row. A float bound seeks the row
+// id below it. Any other bound that is not a row id is text or NULL which no
+// row id is less than so found is false.
+func seekLowerBound(rc rangeCursor, bound Value, seek func(key []byte) bool) (found bool, err error) {
+	rowId, ok := rowIdAffinity(bound)
+	if bound.Type() == FloatType {
+		rowId, ok = int(math.Floor(bound.Float())), true
+	}
+	if !ok {
+		return false, nil
+	}
+	if rowId < 0 {
+		return rc.GotoFirstRecord(), nil
+	}
+	key, err := kv.EncodeKey(rowId)
+	if err != nil {
+		return false, err
+	}
+	return seek(key), nil
+}
+
+// seekUpperBound moves the cursor to the last row with a key before bound. Rows
+// are positioned in key order which is the order of row ids for non negative
+// row ids. A float bound seeks the row id above it. A text bound that is not a
+// row id is greater than every row id so the cursor moves to the last row. NULL
+// is not comparable so found is false.
+func seekUpperBound(rc rangeCursor, bound Value, seek func(key []byte) bool) (found bool, err error) {
+	if bound.IsNull() {
+		return false, nil
+	}
+	rowId, ok := rowIdAffinity(bound)
+	if bound.Type() == FloatType {
+		rowId, ok = int(math.Ceil(bound.Float())), true
+	}
+	if !ok {
+		if bound.Type() == TextType {
+			return rc.GotoLastRecord(), nil
+		}
+		return false, nil
+	}
+	key, err := kv.EncodeKey(rowId)
+	if err != nil {
+		return false, err
+	}
+	return seek(key), nil
+}
+
+// SeekGECmd moves cursor P1 to the first row with a row id greater than or equal
+// to the row id in register P3. If there is no such row it jumps to P2. Rows
+// before the bound are skipped, but rows after it must still be compared to the
+// bound since negative row ids can follow it.
+type SeekGECmd cmd
+
+func (c *SeekGECmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getRangeCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := seekLowerBound(rc, routine.registers[c.P3], rc.SeekGE)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SeekGECmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to the first row id >= register[%d] or jump to addr[%d]", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "SeekGE", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *SeekGECmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// SeekGTCmd moves cursor P1 to the first row with a row id greater than the row
+// id in register P3. If there is no such row it jumps to P2. Like SeekGECmd the
+// rows after the bound must still be compared to the bound.
+type SeekGTCmd cmd
+
+func (c *SeekGTCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getRangeCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := seekLowerBound(rc, routine.registers[c.P3], rc.SeekGT)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SeekGTCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to the first row id > register[%d] or jump to addr[%d]", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "SeekGT", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *SeekGTCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// SeekLECmd moves cursor P1 to the last row with a row id less than or equal to
+// the row id in register P3. If there is no such row it jumps to P2.
+type SeekLECmd cmd
+
+func (c *SeekLECmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getRangeCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := seekUpperBound(rc, routine.registers[c.P3], rc.SeekLE)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SeekLECmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to the last row id <= register[%d] or jump to addr[%d]", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "SeekLE", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *SeekLECmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// SeekLTCmd moves cursor P1 to the last row with a row id less than the row id
+// in register P3. If there is no such row it jumps to P2.
+type SeekLTCmd cmd
+
+func (c *SeekLTCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getRangeCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	found, err := seekUpperBound(rc, routine.registers[c.P3], rc.SeekLT)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !found {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SeekLTCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to the last row id < register[%d] or jump to addr[%d]", c.P1, c.P3, c.P2)
+	return formatExplain(addr, "SeekLT", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *SeekLTCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
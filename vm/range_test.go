@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestSeekBoundCommands(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	root := k.NewBTree()
+	c := k.NewCursor(root)
+	for _, rowId := range []int{-1, 1, 2, 3} {
+		key, err := kv.EncodeKey(rowId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(key, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.EndWriteTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	cases := []struct {
+		name  string
+		seek  Command
+		bound any
+		// want is the row id the seek moves to or empty when it jumps.
+		want string
+	}{
+		{name: "GE", seek: &SeekGECmd{P1: 1, P2: 6, P3: 1}, bound: 2, want: "2"},
+		{name: "GE text", seek: &SeekGECmd{P1: 1, P2: 6, P3: 1}, bound: "2", want: "2"},
+		{name: "GE past end", seek: &SeekGECmd{P1: 1, P2: 6, P3: 1}, bound: 4},
+		{name: "GT", seek: &SeekGTCmd{P1: 1, P2: 6, P3: 1}, bound: 2, want: "3"},
+		{name: "GT negative", seek: &SeekGTCmd{P1: 1, P2: 6, P3: 1}, bound: -5, want: "-1"},
+		{name: "GT text", seek: &SeekGTCmd{P1: 1, P2: 6, P3: 1}, bound: "a"},
+		{name: "GT float", seek: &SeekGTCmd{P1: 1, P2: 6, P3: 1}, bound: 1.5, want: "2"},
+		{name: "GT null", seek: &SeekGTCmd{P1: 1, P2: 6, P3: 1}, bound: nil},
+		{name: "LE", seek: &SeekLECmd{P1: 1, P2: 6, P3: 1}, bound: 2, want: "2"},
+		{name: "LE text", seek: &SeekLECmd{P1: 1, P2: 6, P3: 1}, bound: "a", want: "3"},
+		{name: "LT", seek: &SeekLTCmd{P1: 1, P2: 6, P3: 1}, bound: 2, want: "1"},
+		{name: "LT float", seek: &SeekLTCmd{P1: 1, P2: 6, P3: 1}, bound: 2.5, want: "2"},
+		{name: "LT null", seek: &SeekLTCmd{P1: 1, P2: 6, P3: 1}, bound: nil},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+			ep.Commands = []Command{
+				&InitCmd{P2: 7},
+				&OpenReadCmd{P1: 1, P2: root},
+				&VariableCmd{P1: 0, P2: 1},
+				c.seek,
+				&RowIdCmd{P1: 1, P2: 2},
+				&ResultRowCmd{P1: 2, P2: 1},
+				&HaltCmd{},
+				&TransactionCmd{P2: 0},
+				&GotoCmd{P2: 1},
+			}
+			res := vm.Execute(ep, []any{c.bound})
+			if res.Err != nil {
+				t.Fatalf("expected no err got %s", res.Err)
+			}
+			if c.want == "" {
+				if len(res.ResultRows) != 0 {
+					t.Fatalf("expected jump got row %s", res.ResultRows[0][0].Text())
+				}
+				return
+			}
+			if len(res.ResultRows) != 1 || res.ResultRows[0][0].Text() != c.want {
+				t.Fatalf("expected row %s got %v", c.want, res.ResultRows)
+			}
+		})
+	}
+}
+
+func TestReverseCommands(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := k.BeginWriteTransaction(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	root := k.NewBTree()
+	c := k.NewCursor(root)
+	for _, rowId := range []int{1, 2, 3} {
+		key, err := kv.EncodeKey(rowId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := c.Set(key, []byte{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := k.EndWriteTransaction(); err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	t.Run("descending", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 7},
+			&OpenReadCmd{P1: 1, P2: root},
+			&LastCmd{P1: 1, P2: 6},
+			&RowIdCmd{P1: 1, P2: 1},
+			&ResultRowCmd{P1: 1, P2: 1},
+			&PrevCmd{P1: 1, P2: 3},
+			&HaltCmd{},
+			&TransactionCmd{P2: 0},
+			&GotoCmd{P2: 1},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		got := ""
+		for _, row := range res.ResultRows {
+			got += row[0].Text()
+		}
+		if got != "321" {
+			t.Fatalf("expected 321 got %s", got)
+		}
+	})
+
+	t.Run("unordered cursor", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&OpenVirtualCmd{P1: 1, P4: "v", Rows: [][]any{{"a"}}},
+			&LastCmd{P1: 1, P2: 3},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if !errors.Is(res.Err, errUnorderedCursor) {
+			t.Fatalf("expected %s got %v", errUnorderedCursor, res.Err)
+		}
+	})
+}
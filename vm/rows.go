@@ -0,0 +1,194 @@
+package vm
+
+import (
+	"context"
+
+	"github.com/chirst/cdb/catalog"
+)
+
+// Rows is the result of a statement read one row at a time so every row does
+// not have to be held in memory. The transaction of the statement stays open
+// until every row is read or Rows is closed. Rows read from a snapshot of the
+// database so a write committed while Rows is open is not seen by Rows and does
+// not wait for it. Rows is not safe for concurrent use.
+//
+//	rows, err := vm.Query(ctx, plan, parameters)
+//	if err != nil {
+//		return err
+//	}
+//	defer rows.Close()
+//	for rows.Next() {
+//		row := rows.Row()
+//	}
+//	return rows.Err()
+type Rows struct {
+	// ResultHeader is the names of columns in the result.
+	ResultHeader []string
+	// ResultTypes are the types for each result column.
+	ResultTypes []catalog.CdbType
+	// ResultOrigins are the origins for each result column.
+	ResultOrigins []ColumnOrigin
+
+	vm      *vm
+	plan    *ExecutionPlan
+	routine *routine
+	// address is the command the routine continues from.
+	address int
+	// done is called once the routine is finished.
+	done func()
+	// prefetched is true when row was made by Query and has not been returned
+	// by Next.
+	prefetched bool
+	row        []Value
+	// buffered are the rows that are not made by a routine such as the rows of
+	// an explain.
+	buffered [][]Value
+	err      error
+}
+
+// NewRows returns Rows reading the rows of result which are already in memory.
+func NewRows(result *ExecuteResult) *Rows {
+	return &Rows{
+		ResultHeader:  result.ResultHeader,
+		ResultTypes:   result.ResultTypes,
+		ResultOrigins: result.ResultOrigins,
+		buffered:      result.ResultRows,
+	}
+}
+
+// Query executes plan until the first result row is made and returns Rows to
+// read the result. The err of the statement up to the first row, such as
+// ErrVersionChanged or pager.ErrBusy, is returned by Query rather than Rows.
+func (v *vm) Query(ctx context.Context, plan *ExecutionPlan, parameters []any) (*Rows, error) {
+	parameters, err := v.normalizeParameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Explain {
+		return NewRows(v.explain(plan)), nil
+	}
+	resultTypes, err := v.resolveVarTypes(plan, parameters)
+	if err != nil {
+		return nil, err
+	}
+	if err := v.errForUnknownType(resultTypes); err != nil {
+		return nil, err
+	}
+	ctx, done := v.interrupts.track(ctx)
+	routine := v.newRoutine(ctx, plan, parameters, nil)
+	routine.stream = true
+	rows := &Rows{
+		ResultHeader:  plan.ResultHeader,
+		ResultTypes:   resultTypes,
+		ResultOrigins: plan.ResultOrigins,
+		vm:            v,
+		plan:          plan,
+		routine:       routine,
+		done:          done,
+	}
+	rows.advance()
+	if rows.err != nil {
+		return nil, rows.err
+	}
+	rows.prefetched = rows.row != nil
+	return rows, nil
+}
+
+// Next moves to the next row of the result. Next returns false when there are
+// no more rows or an err stopped the statement. See Err.
+func (r *Rows) Next() bool {
+	if r.routine == nil {
+		if len(r.buffered) == 0 {
+			r.row = nil
+			return false
+		}
+		r.row, r.buffered = r.buffered[0], r.buffered[1:]
+		return true
+	}
+	if r.prefetched {
+		r.prefetched = false
+	} else {
+		r.advance()
+	}
+	return r.row != nil
+}
+
+// advance runs the routine until it makes the next row or is finished.
+func (r *Rows) advance() {
+	r.row = nil
+	next, halted, err := r.vm.step(r.plan, r.routine, r.address)
+	r.address = next
+	if err != nil || halted {
+		r.err = err
+		r.finish()
+		return
+	}
+	r.row = r.routine.row
+}
+
+// Row returns the row Next moved to.
+func (r *Rows) Row() []Value {
+	return r.row
+}
+
+// ColumnInt returns column i of the current row as an integer. See Value.Int.
+func (r *Rows) ColumnInt(i int) int {
+	return r.column(i).Int()
+}
+
+// ColumnFloat returns column i of the current row as a float. See Value.Float.
+func (r *Rows) ColumnFloat(i int) float64 {
+	return r.column(i).Float()
+}
+
+// ColumnText returns column i of the current row as text. See Value.Text.
+func (r *Rows) ColumnText(i int) string {
+	return r.column(i).Text()
+}
+
+// ColumnBlob returns column i of the current row as a blob. See Value.Blob.
+func (r *Rows) ColumnBlob(i int) []byte {
+	return r.column(i).Blob()
+}
+
+// ColumnIsNull returns true when column i of the current row is NULL.
+func (r *Rows) ColumnIsNull(i int) bool {
+	return r.column(i).IsNull()
+}
+
+// column returns column i of the current row. The column is NULL when there is
+// no current row or i is not a column of the result.
+func (r *Rows) column(i int) Value {
+	if i < 0 || i >= len(r.row) {
+		return NullValue()
+	}
+	return r.row[i]
+}
+
+// Err returns the err that stopped the statement or nil when every row was
+// read.
+func (r *Rows) Err() error {
+	return r.err
+}
+
+// Close ends the statement. When rows are left to read the transaction of the
+// statement is rolled back. Close is safe to call more than once.
+func (r *Rows) Close() error {
+	if r.routine != nil {
+		r.vm.rollback(r.routine)
+		r.finish()
+	}
+	r.row = nil
+	r.buffered = nil
+	return nil
+}
+
+// finish releases the routine once it has halted or been rolled back.
+func (r *Rows) finish() {
+	if r.routine == nil {
+		return
+	}
+	r.routine.closeCursors()
+	r.routine = nil
+	r.done()
+}
@@ -0,0 +1,165 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
+)
+
+func TestRows(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	// countPlan makes a row for each of 1 through 3 then errs when err is true.
+	countPlan := func(err bool) *ExecutionPlan {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		halt := &HaltCmd{}
+		if err {
+			halt = &HaltCmd{P1: 1, P4: "count err"}
+		}
+		ep.Commands = []Command{
+			&InitCmd{P2: 5},
+			&AddCmd{P1: 1, P2: 2, P3: 1},
+			&ResultRowCmd{P1: 1, P2: 1},
+			&LtCmd{P1: 1, P2: 1, P3: 3},
+			halt,
+			&TransactionCmd{P2: 0},
+			&IntegerCmd{P1: 0, P2: 1},
+			&IntegerCmd{P1: 1, P2: 2},
+			&IntegerCmd{P1: 3, P2: 3},
+			&GotoCmd{P2: 1},
+		}
+		return ep
+	}
+
+	t.Run("reads every row", func(t *testing.T) {
+		rows, err := vm.Query(context.Background(), countPlan(false), []any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		got := []int{}
+		for rows.Next() {
+			got = append(got, rows.Row()[0].Int())
+		}
+		if rows.Err() != nil {
+			t.Fatal(rows.Err())
+		}
+		if len(got) != 3 || got[0] != 1 || got[2] != 3 {
+			t.Fatalf("expected [1 2 3] got %v", got)
+		}
+	})
+
+	t.Run("err after rows", func(t *testing.T) {
+		rows, err := vm.Query(context.Background(), countPlan(true), []any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		count := 0
+		for rows.Next() {
+			count += 1
+		}
+		if count != 3 || rows.Err() == nil {
+			t.Fatalf("expected 3 rows and err got %d rows and %v", count, rows.Err())
+		}
+	})
+
+	t.Run("close ends transaction", func(t *testing.T) {
+		rows, err := vm.Query(context.Background(), countPlan(false), []any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !rows.Next() {
+			t.Fatal("expected a row")
+		}
+		if state, _ := k.TransactionState(); state != pager.TransactionRead {
+			t.Fatalf("expected open rows to hold a read transaction got %s", state)
+		}
+		rows.Close()
+		if rows.Next() {
+			t.Fatal("expected no rows after close")
+		}
+		if state, _ := k.TransactionState(); state != pager.TransactionNone {
+			t.Fatalf("expected transaction to end got %s", state)
+		}
+	})
+
+	t.Run("writer does not wait for rows", func(t *testing.T) {
+		rows, err := vm.Query(context.Background(), countPlan(false), []any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		if !rows.Next() {
+			t.Fatal("expected a row")
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if err := k.BeginWriteTransaction(ctx); err != nil {
+			t.Fatalf("expected write transaction to begin got %s", err)
+		}
+		k.RollbackWrite()
+		count := 1
+		for rows.Next() {
+			count += 1
+		}
+		if count != 3 {
+			t.Fatalf("expected 3 rows got %d", count)
+		}
+	})
+
+	t.Run("interrupted", func(t *testing.T) {
+		rows, err := vm.Query(context.Background(), countPlan(false), []any{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer rows.Close()
+		vm.Interrupt()
+		for rows.Next() {
+		}
+		if !errors.Is(rows.Err(), ErrInterrupted) {
+			t.Fatalf("expected %s got %v", ErrInterrupted, rows.Err())
+		}
+	})
+}
+
+func TestRowsColumns(t *testing.T) {
+	rows := NewRows(&ExecuteResult{
+		ResultRows: [][]Value{
+			{IntValue(1), FloatValue(1.5), TextValue("a"), BlobValue([]byte{0xff}), NullValue()},
+		},
+	})
+	defer rows.Close()
+	if !rows.Next() {
+		t.Fatal("expected a row")
+	}
+	if got := rows.ColumnInt(0); got != 1 {
+		t.Fatalf("expected int 1 got %d", got)
+	}
+	if got := rows.ColumnFloat(1); got != 1.5 {
+		t.Fatalf("expected float 1.5 got %v", got)
+	}
+	if got := rows.ColumnText(2); got != "a" {
+		t.Fatalf("expected text a got %s", got)
+	}
+	if got := rows.ColumnBlob(3); len(got) != 1 || got[0] != 0xff {
+		t.Fatalf("expected blob ff got %x", got)
+	}
+	if !rows.ColumnIsNull(4) {
+		t.Fatal("expected column 4 to be NULL")
+	}
+	if !rows.ColumnIsNull(5) {
+		t.Fatal("expected column out of range to be NULL")
+	}
+	if rows.ColumnIsNull(0) {
+		t.Fatal("expected column 0 to not be NULL")
+	}
+}
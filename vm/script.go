@@ -0,0 +1,95 @@
+package vm
+
+import (
+	"context"
+	"errors"
+)
+
+// errScriptEnded is the err of executing a plan with a Script that was already
+// committed or rolled back.
+var errScriptEnded = errors.New("script has ended")
+
+// Script executes plans one after another within a single transaction so
+// either every plan is committed or, when one fails, none of them are. Unlike
+// ExecuteMany the plans may differ and each plan can be compiled after the
+// plan before it executed, for example to insert into a table created earlier
+// in the script. A Script is not safe for concurrent use.
+type Script struct {
+	vm    *vm
+	ctx   context.Context
+	done  func()
+	batch *batch
+	ended bool
+}
+
+// NewScript returns a Script executing plans with ctx. When write is true the
+// transaction of the script is a write transaction even when the first plan
+// only reads. This is needed when a later plan writes since a read transaction
+// cannot become a write transaction. The script must be ended with Commit or
+// Rollback.
+func (v *vm) NewScript(ctx context.Context, write bool) *Script {
+	ctx, done := v.interrupts.track(ctx)
+	return &Script{
+		vm:    v,
+		ctx:   ctx,
+		done:  done,
+		batch: &batch{write: write},
+	}
+}
+
+// Execute executes plan with parameters within the transaction of the script.
+// When the result has an err the transaction has been rolled back and the
+// script has ended.
+func (s *Script) Execute(plan *ExecutionPlan, parameters []any) *ExecuteResult {
+	if s.ended {
+		return &ExecuteResult{Err: errScriptEnded}
+	}
+	if plan.Explain {
+		return s.vm.explain(plan)
+	}
+	parameters, err := s.vm.normalizeParameters(parameters)
+	if err != nil {
+		s.Rollback()
+		return &ExecuteResult{Err: err}
+	}
+	resultTypes, err := s.vm.resolveVarTypes(plan, parameters)
+	if err != nil {
+		s.Rollback()
+		return &ExecuteResult{Err: err}
+	}
+	if err := s.vm.errForUnknownType(resultTypes); err != nil {
+		s.Rollback()
+		return &ExecuteResult{Err: err}
+	}
+	res := s.vm.run(s.ctx, plan, parameters, resultTypes, s.batch)
+	if res.Err != nil && !errors.Is(res.Err, ErrVersionChanged) {
+		// The routine rolled back the transaction of the batch.
+		s.end()
+	}
+	return res
+}
+
+// Commit commits the transaction of the script. If the commit fails the
+// transaction is rolled back. Commit is a no-op once the script has ended.
+func (s *Script) Commit() error {
+	if s.ended {
+		return nil
+	}
+	defer s.end()
+	return s.batch.end(s.vm.kv)
+}
+
+// Rollback rolls back the transaction of the script. Rollback is a no-op once
+// the script has ended.
+func (s *Script) Rollback() {
+	if s.ended {
+		return
+	}
+	defer s.end()
+	s.batch.rollback(s.vm.kv)
+}
+
+func (s *Script) end() {
+	s.ended = true
+	s.done()
+}
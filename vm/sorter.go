@@ -0,0 +1,425 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultSorterSpillBytes is the number of bytes a sorter holds in memory
+// before spilling a sorted run to a temporary file.
+const defaultSorterSpillBytes = 4 * 1024 * 1024
+
+// sorter is a cursor over rows that are returned in the order of their sort
+// keys. Rows are inserted then sorted once before being read. Rows are held in
+// memory until they exceed spillBytes at which point they are sorted and
+// written to a temporary file known as a run. Runs are merged when reading.
+//
+// The key and value of each row are copied from the cursor the row was read
+// from so commands such as ColumnCmd and RowIdCmd work the same on a sorter as
+// they do on the original table.
+type sorter struct {
+	// desc is true for each sort key that is in descending order.
+	desc []bool
+	// spillBytes is the number of bytes held in memory before spilling.
+	spillBytes int
+	// records are the rows held in memory.
+	records []*sorterRecord
+	// memBytes is the approximate size of records.
+	memBytes int
+	// runs are the temporary files holding sorted rows.
+	runs []*os.File
+	// count is the number of rows inserted.
+	count int
+	// readers are the sorted runs being merged after sort.
+	readers []sorterReader
+	// current is the row the sorter is on after sort.
+	current *sorterRecord
+}
+
+type sorterRecord struct {
+	// keys are the decoded sort keys.
+	keys []Value
+	// keyRecord is the encoded sort keys.
+	keyRecord []byte
+	key       []byte
+	value     []byte
+}
+
+func (r *sorterRecord) size() int {
+	return len(r.keyRecord) + len(r.key) + len(r.value)
+}
+
+// newSorter returns a sorter for keys ordered by orders. Each order is either
+// ASC or DESC.
+func newSorter(orders []string, spillBytes int) (*sorter, error) {
+	desc := []bool{}
+	for _, o := range orders {
+		switch o {
+		case "ASC":
+			desc = append(desc, false)
+		case "DESC":
+			desc = append(desc, true)
+		default:
+			return nil, fmt.Errorf("unknown sort order %s", o)
+		}
+	}
+	return &sorter{
+		desc:       desc,
+		spillBytes: spillBytes,
+	}, nil
+}
+
+// insert adds a row with the encoded sort keys in keyRecord.
+func (s *sorter) insert(keyRecord, key, value []byte) error {
+	keys, err := decodeValues(keyRecord)
+	if err != nil {
+		return err
+	}
+	r := &sorterRecord{
+		keys:      keys,
+		keyRecord: keyRecord,
+		key:       key,
+		value:     value,
+	}
+	s.records = append(s.records, r)
+	s.memBytes += r.size()
+	s.count += 1
+	if s.memBytes >= s.spillBytes {
+		return s.spill()
+	}
+	return nil
+}
+
+// spill sorts the rows in memory and writes them to a new run.
+func (s *sorter) spill() error {
+	s.sortRecords()
+	f, err := os.CreateTemp("", "cdb-sort-*")
+	if err != nil {
+		return err
+	}
+	s.runs = append(s.runs, f)
+	w := bufio.NewWriter(f)
+	for _, r := range s.records {
+		for _, b := range [][]byte{r.keyRecord, r.key, r.value} {
+			if _, err := w.Write(binary.AppendUvarint(nil, uint64(len(b)))); err != nil {
+				return err
+			}
+			if _, err := w.Write(b); err != nil {
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	s.records = nil
+	s.memBytes = 0
+	return nil
+}
+
+func (s *sorter) sortRecords() {
+	slices.SortStableFunc(s.records, func(a, b *sorterRecord) int {
+		return s.compare(a, b)
+	})
+}
+
+// compare orders a and b by their keys.
+func (s *sorter) compare(a, b *sorterRecord) int {
+	for i := range a.keys {
+		c := compareValues(a.keys[i], b.keys[i])
+		if i < len(s.desc) && s.desc[i] {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// sort prepares the sorter to be read and moves to the first row. sort returns
+// false when there are no rows.
+func (s *sorter) sort() (bool, error) {
+	if len(s.runs) == 0 {
+		s.sortRecords()
+		s.readers = []sorterReader{&memoryRun{records: s.records}}
+	} else {
+		if len(s.records) != 0 {
+			if err := s.spill(); err != nil {
+				return false, err
+			}
+		}
+		for _, f := range s.runs {
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return false, err
+			}
+			s.readers = append(s.readers, &fileRun{r: bufio.NewReader(f)})
+		}
+	}
+	for _, r := range s.readers {
+		if err := r.advance(); err != nil {
+			return false, err
+		}
+	}
+	return s.next()
+}
+
+// next moves to the next row by taking the smallest row at the head of the
+// runs. When rows are equal the earliest run wins so sorting is stable.
+func (s *sorter) next() (bool, error) {
+	var min sorterReader
+	for _, r := range s.readers {
+		if r.head() == nil {
+			continue
+		}
+		if min == nil || s.compare(r.head(), min.head()) < 0 {
+			min = r
+		}
+	}
+	if min == nil {
+		s.current = nil
+		return false, nil
+	}
+	s.current = min.head()
+	if err := min.advance(); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Close removes the temporary files of the sorter.
+func (s *sorter) Close() error {
+	var err error
+	for _, f := range s.runs {
+		if cerr := f.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		if rerr := os.Remove(f.Name()); rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	s.runs = nil
+	return err
+}
+
+func (s *sorter) GotoFirstRecord() bool {
+	return s.current != nil
+}
+
+// GotoNext is next without the err. SorterNextCmd should be used to advance a
+// sorter so errors reading runs are not lost.
+func (s *sorter) GotoNext() bool {
+	ok, _ := s.next()
+	return ok
+}
+
+func (s *sorter) GotoKey(key []byte) bool {
+	return false
+}
+
+func (s *sorter) GetKey() []byte {
+	return s.current.key
+}
+
+func (s *sorter) GetValue() []byte {
+	return s.current.value
+}
+
+func (s *sorter) Count() int {
+	return s.count
+}
+
+func (s *sorter) Exists(key []byte) bool {
+	return false
+}
+
+// sorterReader reads sorted rows from a run.
+type sorterReader interface {
+	// head is the row the reader is on or nil when there are no more rows.
+	head() *sorterRecord
+	// advance moves head to the next row.
+	advance() error
+}
+
+type memoryRun struct {
+	records []*sorterRecord
+	idx     int
+	current *sorterRecord
+}
+
+func (m *memoryRun) head() *sorterRecord {
+	return m.current
+}
+
+func (m *memoryRun) advance() error {
+	m.current = nil
+	if m.idx < len(m.records) {
+		m.current = m.records[m.idx]
+		m.idx += 1
+	}
+	return nil
+}
+
+type fileRun struct {
+	r       *bufio.Reader
+	current *sorterRecord
+}
+
+func (f *fileRun) head() *sorterRecord {
+	return f.current
+}
+
+func (f *fileRun) advance() error {
+	f.current = nil
+	keyRecord, err := f.readBytes()
+	if err == io.EOF {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	key, err := f.readBytes()
+	if err != nil {
+		return err
+	}
+	value, err := f.readBytes()
+	if err != nil {
+		return err
+	}
+	keys, err := decodeValues(keyRecord)
+	if err != nil {
+		return err
+	}
+	f.current = &sorterRecord{
+		keys:      keys,
+		keyRecord: keyRecord,
+		key:       key,
+		value:     value,
+	}
+	return nil
+}
+
+func (f *fileRun) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(f.r)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f.r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// SorterOpenCmd opens a sorter with cursor id P1. P2 is the number of sort
+// keys and P4 is a comma separated list of ASC or DESC for each key.
+type SorterOpenCmd cmd
+
+func (c *SorterOpenCmd) execute(vm *vm, routine *routine) cmdRes {
+	s, err := newSorter(strings.Split(c.P4, ","), vm.sorterSpillBytes)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.cursors[c.P1] = s
+	return cmdRes{}
+}
+
+func (c *SorterOpenCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Open sorter with id %d ordered by %d keys", c.P1, c.P2)
+	return formatExplain(addr, "SorterOpen", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// SorterInsertCmd inserts into sorter P1 the row cursor P3 is on with the sort
+// keys in the record at register P2. If cursor P3 is not open the row is
+// empty. When P5 is not 0 the row is the record at register P5 instead which
+// is how the rows of a join of several cursors are sorted.
+type SorterInsertCmd cmd
+
+func (c *SorterInsertCmd) execute(vm *vm, routine *routine) cmdRes {
+	s, ok := routine.cursors[c.P1].(*sorter)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not a sorter", c.P1)}
+	}
+	keyRecord, err := routine.blobRegister(c.P2)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	var key, value []byte
+	if c.P5 != 0 {
+		value, err = routine.blobRegister(c.P5)
+		if err != nil {
+			return cmdRes{err: err}
+		}
+	} else if source, ok := routine.cursors[c.P3]; ok {
+		key = source.GetKey()
+		// The value is a view of the page buffer so it is copied to outlive
+		// later writes to the table.
+		value = bytes.Clone(source.GetValue())
+	}
+	if err := s.insert(keyRecord, key, value); err != nil {
+		return cmdRes{err: err}
+	}
+	return cmdRes{}
+}
+
+func (c *SorterInsertCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Insert row of cursor %d into sorter %d with keys in register[%d]", c.P3, c.P1, c.P2)
+	if c.P5 != 0 {
+		comment = fmt.Sprintf("Insert row in register[%d] into sorter %d with keys in register[%d]", c.P5, c.P1, c.P2)
+	}
+	return formatExplain(addr, "SorterInsert", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// SorterSortCmd sorts sorter P1 and moves to the first row. If the sorter is
+// empty it jumps to P2.
+type SorterSortCmd cmd
+
+func (c *SorterSortCmd) execute(vm *vm, routine *routine) cmdRes {
+	s, ok := routine.cursors[c.P1].(*sorter)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not a sorter", c.P1)}
+	}
+	hasValues, err := s.sort()
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !hasValues {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SorterSortCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Sort sorter %d. If the sorter is empty jump to addr[%d]", c.P1, c.P2)
+	return formatExplain(addr, "SorterSort", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// SorterNextCmd advances sorter P1. If there are more rows jump to P2 otherwise
+// fall through.
+type SorterNextCmd cmd
+
+func (c *SorterNextCmd) execute(vm *vm, routine *routine) cmdRes {
+	s, ok := routine.cursors[c.P1].(*sorter)
+	if !ok {
+		return cmdRes{err: fmt.Errorf("cursor %d is not a sorter", c.P1)}
+	}
+	hasMore, err := s.next()
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if hasMore {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *SorterNextCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Advance sorter %d if there are rows jump to addr[%d] else fall through", c.P1, c.P2)
+	return formatExplain(addr, "SorterNext", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
@@ -0,0 +1,155 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/chirst/cdb/kv"
+)
+
+func TestSorter(t *testing.T) {
+	rows := []struct {
+		keys  []any
+		value string
+	}{
+		{keys: []any{2, "b"}, value: "2b"},
+		{keys: []any{1, "z"}, value: "1z"},
+		{keys: []any{"s", "a"}, value: "sa"},
+		{keys: []any{2, "a"}, value: "2a"},
+		{keys: []any{nil, "a"}, value: "na"},
+		{keys: []any{1, "z"}, value: "1z2"},
+		{keys: []any{[]byte("b"), "a"}, value: "ba"},
+	}
+	cases := []struct {
+		name       string
+		orders     []string
+		spillBytes int
+		want       []string
+	}{
+		{
+			name:       "in memory",
+			orders:     []string{"ASC", "DESC"},
+			spillBytes: defaultSorterSpillBytes,
+			want:       []string{"na", "1z", "1z2", "2b", "2a", "sa", "ba"},
+		},
+		{
+			name:       "spilled",
+			orders:     []string{"ASC", "DESC"},
+			spillBytes: 1,
+			want:       []string{"na", "1z", "1z2", "2b", "2a", "sa", "ba"},
+		},
+		{
+			name:       "descending",
+			orders:     []string{"DESC", "ASC"},
+			spillBytes: 1,
+			want:       []string{"ba", "sa", "2a", "2b", "1z", "1z2", "na"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			s, err := newSorter(c.orders, c.spillBytes)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			for _, row := range rows {
+				keyRecord, err := kv.Encode(row.keys)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if err := s.insert(keyRecord, nil, []byte(row.value)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := []string{}
+			ok, err := s.sort()
+			for ok && err == nil {
+				got = append(got, string(s.GetValue()))
+				ok, err = s.next()
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("want %v got %v", c.want, got)
+			}
+		})
+	}
+}
+
+func TestSorterCommands(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(kv)
+	rows := [][]any{{"c", 1}, {"a", 2}, {"b", 3}}
+	ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+		&SorterOpenCmd{P1: 2, P2: 1, P4: "DESC"},
+		&RewindCmd{P1: 1, P2: 8},
+		&ColumnCmd{P1: 1, P2: 0, P3: 1},
+		&MakeRecordCmd{P1: 1, P2: 1, P3: 2},
+		&SorterInsertCmd{P1: 2, P2: 2, P3: 1},
+		&NextCmd{P1: 1, P2: 4},
+		&SorterSortCmd{P1: 2, P2: 13},
+		&RowIdCmd{P1: 2, P2: 3},
+		&ColumnCmd{P1: 2, P2: 0, P3: 4},
+		&ResultRowCmd{P1: 3, P2: 2},
+		&SorterNextCmd{P1: 2, P2: 9},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	got := []string{}
+	for _, row := range res.ResultRows {
+		got = append(got, row[0].Text()+row[1].Text())
+	}
+	want := []string{"0c", "2b", "1a"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+}
+
+func TestSorterInsertRecord(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(kv)
+	rows := [][]any{{"c", 1}, {"a", 2}, {"b", 3}}
+	ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+		&SorterOpenCmd{P1: 2, P2: 1, P4: "ASC"},
+		&RewindCmd{P1: 1, P2: 10},
+		&ColumnCmd{P1: 1, P2: 0, P3: 1},
+		&ColumnCmd{P1: 1, P2: 1, P3: 2},
+		&MakeRecordCmd{P1: 1, P2: 1, P3: 3},
+		&MakeRecordCmd{P1: 2, P2: 1, P3: 4},
+		&SorterInsertCmd{P1: 2, P2: 3, P5: 4},
+		&NextCmd{P1: 1, P2: 4},
+		&SorterSortCmd{P1: 2, P2: 14},
+		&ColumnCmd{P1: 2, P2: 0, P3: 5},
+		&ResultRowCmd{P1: 5, P2: 1},
+		&SorterNextCmd{P1: 2, P2: 11},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	got := []string{}
+	for _, row := range res.ResultRows {
+		got = append(got, row[0].Text())
+	}
+	want := []string{"2", "3", "1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("want %v got %v", want, got)
+	}
+}
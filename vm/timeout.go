@@ -0,0 +1,37 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrTimeout is the err of a statement that ran longer than its query timeout.
+// The transaction of the statement is rolled back.
+var ErrTimeout = errors.New("statement timed out")
+
+// WithQueryTimeout returns a context that stops a statement executed with it
+// once d has passed. The stopped statement returns ErrTimeout. The cancel
+// function must be called once the statement is done.
+func WithQueryTimeout(ctx context.Context, d time.Duration) (context.Context, context.CancelFunc) {
+	return context.WithTimeoutCause(ctx, d, ErrTimeout)
+}
+
+type busyTimeoutKey struct{}
+
+// WithBusyTimeout returns a context where a statement waits at most d for the
+// lock of its transaction before returning pager.ErrBusy. A d of 0 waits until
+// ctx is done.
+func WithBusyTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, busyTimeoutKey{}, d)
+}
+
+// busyContext returns the context bounding how long the routine waits for the
+// lock of its transaction. See WithBusyTimeout.
+func (r *routine) busyContext() (context.Context, context.CancelFunc) {
+	d, _ := r.ctx.Value(busyTimeoutKey{}).(time.Duration)
+	if d <= 0 {
+		return r.ctx, func() {}
+	}
+	return context.WithTimeout(r.ctx, d)
+}
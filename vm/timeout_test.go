@@ -0,0 +1,76 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
+)
+
+func TestTimeout(t *testing.T) {
+	k, err := kv.New(true, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm := New(k)
+
+	t.Run("query timeout", func(t *testing.T) {
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&GotoCmd{P2: 2},
+		}
+		ctx, cancel := WithQueryTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if r := vm.ExecuteContext(ctx, ep, []any{}); !errors.Is(r.Err, ErrTimeout) {
+			t.Fatalf("expected %s got %v", ErrTimeout, r.Err)
+		}
+		// The write transaction of the routine is rolled back so another write
+		// can begin.
+		ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if err := k.BeginWriteTransaction(ctx); err != nil {
+			t.Fatalf("expected write lock to be released got %s", err)
+		}
+		k.RollbackWrite()
+	})
+
+	t.Run("busy timeout", func(t *testing.T) {
+		if err := k.BeginWriteTransaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer k.RollbackWrite()
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&HaltCmd{},
+		}
+		ctx := WithBusyTimeout(context.Background(), 10*time.Millisecond)
+		if r := vm.ExecuteContext(ctx, ep, []any{}); !errors.Is(r.Err, pager.ErrBusy) {
+			t.Fatalf("expected %s got %v", pager.ErrBusy, r.Err)
+		}
+	})
+
+	t.Run("query timeout waiting for lock", func(t *testing.T) {
+		if err := k.BeginWriteTransaction(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		defer k.RollbackWrite()
+		ep := NewExecutionPlan(k.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&TransactionCmd{P2: 1},
+			&HaltCmd{},
+		}
+		ctx, cancel := WithQueryTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		if r := vm.ExecuteContext(ctx, ep, []any{}); !errors.Is(r.Err, ErrTimeout) {
+			t.Fatalf("expected %s got %v", ErrTimeout, r.Err)
+		}
+	})
+}
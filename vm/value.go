@@ -0,0 +1,218 @@
+package vm
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/chirst/cdb/kv"
+)
+
+// ValueType is the storage class of a Value.
+type ValueType int
+
+// Storage classes in the order values of different classes sort. Integers and
+// floats are both numbers so they are compared by value with each other.
+const (
+	NullType ValueType = iota
+	IntegerType
+	FloatType
+	TextType
+	BlobType
+)
+
+// valueTypeNames are the names typeof gives each storage class.
+var valueTypeNames = map[ValueType]string{
+	NullType:    "null",
+	IntegerType: "integer",
+	FloatType:   "real",
+	TextType:    "text",
+	BlobType:    "blob",
+}
+
+func (t ValueType) String() string {
+	return valueTypeNames[t]
+}
+
+// Value is a value held in a register or a column of a result row. The type of
+// the value is kept alongside it so it does not need to be determined with a
+// type switch or by parsing text. The zero Value is NULL.
+type Value struct {
+	typ ValueType
+	i   int
+	f   float64
+	s   string
+	b   []byte
+}
+
+// NullValue returns NULL.
+func NullValue() Value {
+	return Value{}
+}
+
+// IntValue returns the integer i.
+func IntValue(i int) Value {
+	return Value{typ: IntegerType, i: i}
+}
+
+// FloatValue returns the float f.
+func FloatValue(f float64) Value {
+	return Value{typ: FloatType, f: f}
+}
+
+// TextValue returns the text s.
+func TextValue(s string) Value {
+	return Value{typ: TextType, s: s}
+}
+
+// BlobValue returns the blob b.
+func BlobValue(b []byte) Value {
+	return Value{typ: BlobType, b: b}
+}
+
+// boolValue returns 1 for true and 0 for false which is how the result of a
+// logical operator or comparison is stored.
+func boolValue(b bool) Value {
+	if b {
+		return IntValue(1)
+	}
+	return IntValue(0)
+}
+
+// ValueOf returns the Value of a go value. nil is NULL. Integer types of any
+// size are an integer. An err is returned for types that cannot be stored.
+func ValueOf(a any) (Value, error) {
+	switch t := a.(type) {
+	case nil:
+		return NullValue(), nil
+	case int:
+		return IntValue(t), nil
+	case int64:
+		return IntValue(int(t)), nil
+	case float64:
+		return FloatValue(t), nil
+	case string:
+		return TextValue(t), nil
+	case []byte:
+		return BlobValue(t), nil
+	}
+	return Value{}, fmt.Errorf("unsupported value %#v of type %T", a, a)
+}
+
+// mustValueOf is ValueOf for values decoded from a record. Records are only
+// made from Values so decoding a record always produces storable values. A
+// value that is not is treated as NULL.
+func mustValueOf(a any) Value {
+	v, _ := ValueOf(a)
+	return v
+}
+
+// Type returns the storage class of v.
+func (v Value) Type() ValueType {
+	return v.typ
+}
+
+// IsNull returns true when v is NULL.
+func (v Value) IsNull() bool {
+	return v.typ == NullType
+}
+
+// Int returns v as an integer. A float is truncated. Any other type is 0.
+func (v Value) Int() int {
+	switch v.typ {
+	case IntegerType:
+		return v.i
+	case FloatType:
+		return int(v.f)
+	}
+	return 0
+}
+
+// Float returns v as a float. Any type other than a number is 0.
+func (v Value) Float() float64 {
+	switch v.typ {
+	case IntegerType:
+		return float64(v.i)
+	case FloatType:
+		return v.f
+	}
+	return 0
+}
+
+// Text returns v as text. Numbers are formatted in base 10 and NULL is empty.
+func (v Value) Text() string {
+	switch v.typ {
+	case IntegerType:
+		return strconv.Itoa(v.i)
+	case FloatType:
+		return strconv.FormatFloat(v.f, 'g', -1, 64)
+	case TextType:
+		return v.s
+	case BlobType:
+		return string(v.b)
+	}
+	return ""
+}
+
+// Blob returns v as a blob. Values that are not a blob are their text as
+// bytes and NULL is nil.
+func (v Value) Blob() []byte {
+	switch v.typ {
+	case NullType:
+		return nil
+	case BlobType:
+		return v.b
+	}
+	return []byte(v.Text())
+}
+
+// Any returns v as a go value. NULL is nil, an integer is an int, a float is a
+// float64, text is a string and a blob is a []byte. This is how values are
+// encoded in a record.
+func (v Value) Any() any {
+	switch v.typ {
+	case IntegerType:
+		return v.i
+	case FloatType:
+		return v.f
+	case TextType:
+		return v.s
+	case BlobType:
+		return v.b
+	}
+	return nil
+}
+
+// String formats v for messages. NULL is formatted as NULL.
+func (v Value) String() string {
+	switch v.typ {
+	case NullType:
+		return "NULL"
+	case TextType:
+		return strconv.Quote(v.s)
+	case BlobType:
+		return fmt.Sprintf("%x", v.b)
+	}
+	return v.Text()
+}
+
+// decodeValues decodes record into Values.
+func decodeValues(record []byte) ([]Value, error) {
+	values, err := kv.Decode(record)
+	if err != nil {
+		return nil, err
+	}
+	ret := make([]Value, len(values))
+	for i := range values {
+		ret[i] = mustValueOf(values[i])
+	}
+	return ret, nil
+}
+
+// encodeValues encodes values into a record.
+func encodeValues(values []Value) ([]byte, error) {
+	span := make([]any, len(values))
+	for i := range values {
+		span[i] = values[i].Any()
+	}
+	return kv.Encode(span)
+}
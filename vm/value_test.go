@@ -0,0 +1,70 @@
+package vm
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestValueOf(t *testing.T) {
+	cases := []struct {
+		a        any
+		wantType ValueType
+		wantText string
+	}{
+		{a: nil, wantType: NullType, wantText: ""},
+		{a: 1, wantType: IntegerType, wantText: "1"},
+		{a: int64(-2), wantType: IntegerType, wantText: "-2"},
+		{a: 1.5, wantType: FloatType, wantText: "1.5"},
+		{a: "foo", wantType: TextType, wantText: "foo"},
+		{a: []byte("bar"), wantType: BlobType, wantText: "bar"},
+	}
+	for _, c := range cases {
+		t.Run(c.wantType.String(), func(t *testing.T) {
+			v, err := ValueOf(c.a)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := v.Type(); got != c.wantType {
+				t.Fatalf("want type %s got %s", c.wantType, got)
+			}
+			if got := v.Text(); got != c.wantText {
+				t.Fatalf("want text %q got %q", c.wantText, got)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		if _, err := ValueOf(struct{}{}); err == nil {
+			t.Fatal("expected err")
+		}
+	})
+}
+
+func TestEncodeDecodeValues(t *testing.T) {
+	values := []Value{
+		NullValue(),
+		IntValue(1),
+		FloatValue(2.5),
+		TextValue("foo"),
+		BlobValue([]byte{0, 1}),
+	}
+	record, err := encodeValues(values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := decodeValues(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(values) {
+		t.Fatalf("want %d values got %d", len(values), len(got))
+	}
+	for i := range values {
+		if got[i].Type() != values[i].Type() {
+			t.Fatalf("want type %s got %s", values[i].Type(), got[i].Type())
+		}
+		if !slices.Equal(got[i].Blob(), values[i].Blob()) {
+			t.Fatalf("want %s got %s", values[i], got[i])
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"slices"
+
+	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
+)
+
+// errReadOnlyCursor is returned when a command attempts to write with a cursor
+// that cannot be written to such as a virtual cursor.
+var errReadOnlyCursor = errors.New("cursor is read only")
+
+// cursor is the interface commands use to move through a table. It is
+// implemented by kv.Cursor and by virtualCursor.
+type cursor interface {
+	GotoFirstRecord() bool
+	GotoNext() bool
+	GotoKey(key []byte) bool
+	GetKey() []byte
+	GetValue() []byte
+	Count() int
+	Exists(key []byte) bool
+}
+
+// writeCursor is a cursor capable of modifying its table.
+type writeCursor interface {
+	cursor
+	NewRowID() (int, error)
+	Set(key, value []byte) error
+	DeleteCurrent() error
+	BulkLoad(tuples []pager.PageTuple) error
+}
+
+// getWriteCursor returns cursor id or errReadOnlyCursor if the cursor cannot
+// be written to.
+func (r *routine) getWriteCursor(id int) (writeCursor, error) {
+	wc, ok := r.cursors[id].(writeCursor)
+	if !ok {
+		return nil, errReadOnlyCursor
+	}
+	return wc, nil
+}
+
+// virtualCursor is a read only cursor over rows held in memory rather than a b
+// tree. Keys are encoded the same as a b tree so commands cannot tell the
+// difference.
+type virtualCursor struct {
+	keys   [][]byte
+	values [][]byte
+	// idx is the position of the cursor. It is len(keys) when the cursor is
+	// past the last row.
+	idx int
+}
+
+// newVirtualCursor encodes rows into a virtualCursor. The key of each row is
+// its position in rows.
+func newVirtualCursor(rows [][]any) (*virtualCursor, error) {
+	vc := &virtualCursor{}
+	for i, row := range rows {
+		k, err := kv.EncodeKey(i)
+		if err != nil {
+			return nil, err
+		}
+		v, err := kv.Encode(row)
+		if err != nil {
+			return nil, err
+		}
+		vc.keys = append(vc.keys, k)
+		vc.values = append(vc.values, v)
+	}
+	return vc, nil
+}
+
+func (vc *virtualCursor) GotoFirstRecord() bool {
+	vc.idx = 0
+	return vc.idx < len(vc.keys)
+}
+
+func (vc *virtualCursor) GotoNext() bool {
+	if vc.idx < len(vc.keys) {
+		vc.idx += 1
+	}
+	return vc.idx < len(vc.keys)
+}
+
+func (vc *virtualCursor) GotoKey(key []byte) bool {
+	i, found := slices.BinarySearchFunc(vc.keys, key, bytes.Compare)
+	if found {
+		vc.idx = i
+	}
+	return found
+}
+
+func (vc *virtualCursor) GetKey() []byte {
+	return vc.keys[vc.idx]
+}
+
+func (vc *virtualCursor) GetValue() []byte {
+	return vc.values[vc.idx]
+}
+
+func (vc *virtualCursor) Count() int {
+	return len(vc.keys)
+}
+
+func (vc *virtualCursor) Exists(key []byte) bool {
+	_, found := slices.BinarySearchFunc(vc.keys, key, bytes.Compare)
+	return found
+}
+
+// sharedCursor is a read only cursor over an ephemeral b tree that outlives the
+// routine such as the rows of a materialized common table shared by the
+// statements of a transaction. Only the methods of cursor are promoted so it
+// is neither written to nor closed by the routine.
+type sharedCursor struct {
+	cursor
+}
+
+// OpenVirtualCmd opens a read only cursor with identifier P1 over Rows. The key
+// of each row is its position in Rows and the value is the encoded row. When
+// Table is not nil the rows are read from the ephemeral b tree Table instead,
+// which is keyed the same way. Table is owned by whoever created it so it is
+// not closed with the cursor. P4 is the name of the virtual table.
+type OpenVirtualCmd struct {
+	P1    int
+	P2    int
+	P3    int
+	P4    string
+	P5    int
+	Rows  [][]any
+	Table *kv.Ephemeral
+}
+
+func (c *OpenVirtualCmd) execute(vm *vm, routine *routine) cmdRes {
+	if c.Table != nil {
+		routine.cursors[c.P1] = &sharedCursor{cursor: c.Table.NewCursor()}
+		return cmdRes{}
+	}
+	vc, err := newVirtualCursor(c.Rows)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.cursors[c.P1] = vc
+	return cmdRes{}
+}
+
+func (c *OpenVirtualCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Open read only cursor with id %d over %d rows of virtual table %s", c.P1, len(c.Rows), c.P4)
+	if c.Table != nil {
+		comment = fmt.Sprintf("Open read only cursor with id %d over %d rows of ephemeral table %s", c.P1, c.Table.NewCursor().Count(), c.P4)
+	}
+	return formatExplain(addr, "OpenVirtual", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ExplainRows returns the rows EXPLAIN would list for the plan so the rows can
+// be used as a virtual table.
+func (e *ExecutionPlan) ExplainRows() [][]any {
+	rows := [][]any{}
+	for addr, command := range e.Commands {
+		row := []any{}
+		for _, column := range command.explain(addr) {
+			row = append(row, column.Any())
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
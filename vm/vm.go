@@ -4,45 +4,133 @@
 package vm
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"math"
 	"regexp"
+	"slices"
 	"strconv"
 	"time"
 
 	"github.com/chirst/cdb/catalog"
 	"github.com/chirst/cdb/kv"
+	"github.com/chirst/cdb/pager"
 )
 
 // ErrVersionChanged signals the execution plan must be recompiled since the
 // catalog has gone out of date since the statement was compiled.
 var ErrVersionChanged = errors.New("statement was compiled with an out of date catalog")
 
+// errIntegerOverflow is returned when an integer does not fit in 64 bits.
+var errIntegerOverflow = errors.New("integer overflows 64 bits")
+
+// errReadOnlyBatch is the err of a plan that writes within the read
+// transaction of a batch.
+var errReadOnlyBatch = errors.New("cannot write within a read transaction")
+
 type vm struct {
 	kv *kv.KV
+	// sorterSpillBytes is the number of bytes a sorter holds in memory before
+	// spilling to a temporary file.
+	sorterSpillBytes int
+	// interrupts are the routines executing that can be stopped by Interrupt.
+	interrupts *interrupts
 }
 
 func New(kv *kv.KV) *vm {
 	return &vm{
-		kv: kv,
+		kv:               kv,
+		sorterSpillBytes: defaultSorterSpillBytes,
+		interrupts:       newInterrupts(),
 	}
 }
 
 // routine contains values that are destroyed when a plan is finished executing
 type routine struct {
-	registers        map[int]any
-	resultRows       *[][]*string
-	cursors          map[int]*kv.Cursor
+	// ctx bounds how long the routine waits to begin a transaction. ctx is
+	// canceled with the cause ErrInterrupted when the routine is interrupted.
+	ctx        context.Context
+	registers  map[int]Value
+	resultRows *[][]Value
+	cursors    map[int]cursor
+	// nullRows are the cursors on a row where every column is NULL. See
+	// NullRowCmd.
+	nullRows map[int]bool
+	// bulkLoads are the entries held for each cursor by IdxInsertCmd.
+	bulkLoads        map[int][]pager.PageTuple
 	parameters       []any
-	readTransaction  bool
 	writeTransaction bool
 	schemaVersion    string
+	// tableGenerations are the generations of the tables the plan depends on.
+	// See ExecutionPlan.Tables.
+	tableGenerations map[string]int
+	// snapshot is the read transaction of the routine. snapshot is nil when
+	// the routine has no read transaction.
+	snapshot *kv.Snapshot
+	// batch is the transaction shared with the other routines of ExecuteMany
+	// or a Script. batch is nil when the routine has a transaction of its own.
+	batch *batch
+	// now is the time used by CurrentTimeCmd. now is zero until the current
+	// time is first needed.
+	now time.Time
+	// rowsAffected is the number of rows inserted, updated or deleted. See
+	// CountChange.
+	rowsAffected int
+	// lastInsertRowID is the row id of the last row inserted. See
+	// SetLastInsertRowID.
+	lastInsertRowID int
+	// stream is true when each result row is read with Rows rather than
+	// appended to resultRows.
+	stream bool
+	// row is the last result row made by a streaming routine.
+	row []Value
+}
+
+// batch is the transaction shared by the routines executing a plan for each set
+// of parameters given to ExecuteMany or each plan of a Script. The first routine
+// begins the transaction and ExecuteMany or the Script ends it once every
+// routine has run.
+type batch struct {
+	// began is true once the transaction has begun.
+	began bool
+	// write is true when the first routine begins a write transaction even if
+	// its plan only reads. See NewScript.
+	write            bool
+	snapshot         *kv.Snapshot
+	writeTransaction bool
+}
+
+// end ends the transaction of the batch. If the write transaction fails to
+// commit it is rolled back.
+func (b *batch) end(kv *kv.KV) error {
+	if b.snapshot != nil {
+		kv.EndReadTransaction(b.snapshot)
+	}
+	if b.writeTransaction {
+		if err := kv.EndWriteTransaction(); err != nil {
+			kv.RollbackWrite()
+			return err
+		}
+	}
+	return nil
+}
+
+// rollback rolls back the transaction of the batch.
+func (b *batch) rollback(kv *kv.KV) {
+	if b.writeTransaction {
+		kv.RollbackWrite()
+		return
+	}
+	if b.snapshot != nil {
+		kv.EndReadTransaction(b.snapshot)
+	}
 }
 
 type Command interface {
 	execute(vm *vm, routine *routine) cmdRes
-	explain(addr int) []*string
+	explain(addr int) []Value
 }
 
 // JumpCommand is a command capable of jumping
@@ -51,7 +139,10 @@ type JumpCommand interface {
 }
 
 type cmdRes struct {
-	doHalt      bool
+	doHalt bool
+	// doYield pauses a streaming routine after the command so the row it made
+	// can be read. See Rows.
+	doYield     bool
 	nextAddress int
 	err         error
 }
@@ -69,14 +160,30 @@ type ExecuteResult struct {
 	Text string
 	// ResultHeader is the names of columns in the result.
 	ResultHeader []string
-	// ResultRows are the columns and rows in a result. These are pointers to a
-	// string since columns can be a null result. TODO this may be wise to make
-	// an any type.
-	ResultRows [][]*string
+	// ResultRows are the columns and rows in a result.
+	ResultRows [][]Value
 	// ResultTypes are the types for each result column.
 	ResultTypes []catalog.CdbType
+	// ResultOrigins are the origins for each result column.
+	ResultOrigins []ColumnOrigin
 	// Duration is the overall execution time
 	Duration time.Duration
+	// RowsAffected is the number of rows inserted, updated or deleted by the
+	// statement. Changes to the system catalog and indexes are not counted.
+	RowsAffected int
+	// LastInsertRowID is the row id of the last row inserted by the statement
+	// such as a row id generated for an INTEGER PRIMARY KEY. It is 0 when the
+	// statement did not insert a row.
+	LastInsertRowID int
+}
+
+// ColumnOrigin is the table column a result column is taken from. Database,
+// Table and Column are empty when the result column is not directly a table
+// column for example when it is an expression or an aggregate.
+type ColumnOrigin struct {
+	Database string
+	Table    string
+	Column   string
 }
 
 type ExecutionPlan struct {
@@ -86,9 +193,20 @@ type ExecutionPlan struct {
 	ResultHeader []string
 	// ResultTypes are the types for each result column.
 	ResultTypes []catalog.CdbType
+	// ResultOrigins are the origins for each result column.
+	ResultOrigins []ColumnOrigin
 	// Version is the catalog version used to compile this plan. If the version
 	// is not the same during execution the execution plan will be recompiled.
 	Version string
+	// Tables are the generations of the tables the plan depends on. When Tables
+	// is set the plan is only recompiled when the generation of one of these
+	// tables changes instead of whenever Version changes. This means a schema
+	// change to an unrelated table does not invalidate the plan.
+	Tables map[string]int
+	// ChangedTable is the table the plan inserts, updates or deletes rows of.
+	// The RowsAffected of executing the plan are the number of rows changed in
+	// it. ChangedTable is empty for other plans.
+	ChangedTable string
 }
 
 func NewExecutionPlan(version string, explain bool) *ExecutionPlan {
@@ -98,6 +216,26 @@ func NewExecutionPlan(version string, explain bool) *ExecutionPlan {
 	}
 }
 
+// DependOn records the plan depends on generation of tableName. See Tables.
+func (e *ExecutionPlan) DependOn(tableName string, generation int) {
+	if e.Tables == nil {
+		e.Tables = map[string]int{}
+	}
+	e.Tables[tableName] = generation
+}
+
+// ReadOnly returns true when executing the plan does not begin a write
+// transaction. An explain is always read only since the plan is not executed.
+func (e *ExecutionPlan) ReadOnly() bool {
+	if e.Explain {
+		return true
+	}
+	return !slices.ContainsFunc(e.Commands, func(c Command) bool {
+		t, ok := c.(*TransactionCmd)
+		return ok && t.P2 == 1
+	})
+}
+
 func (e *ExecutionPlan) Append(command Command) {
 	e.Commands = append(e.Commands, command)
 }
@@ -107,127 +245,286 @@ func (e *ExecutionPlan) Append(command Command) {
 // the system catalog Execute will return ErrVersionChanged in the ExecuteResult
 // err field so the plan can be recompiled.
 func (v *vm) Execute(plan *ExecutionPlan, parameters []any) *ExecuteResult {
-	parameters = v.normalizeParameters(parameters)
+	return v.ExecuteContext(context.Background(), plan, parameters)
+}
+
+// ExecuteContext is Execute where ctx bounds how long the plan waits to begin a
+// transaction. If ctx is done before the transaction begins the ExecuteResult
+// err field is pager.ErrBusy so the statement can be retried.
+func (v *vm) ExecuteContext(ctx context.Context, plan *ExecutionPlan, parameters []any) *ExecuteResult {
+	parameters, err := v.normalizeParameters(parameters)
+	if err != nil {
+		return &ExecuteResult{Err: err}
+	}
 	if plan.Explain {
 		return v.explain(plan)
 	}
-	if err := v.resolveVarTypes(plan, parameters); err != nil {
+	resultTypes, err := v.resolveVarTypes(plan, parameters)
+	if err != nil {
 		return &ExecuteResult{Err: err}
 	}
-	if err := v.errForUnknownType(plan); err != nil {
+	if err := v.errForUnknownType(resultTypes); err != nil {
 		return &ExecuteResult{Err: err}
 	}
+	ctx, done := v.interrupts.track(ctx)
+	defer done()
+	return v.run(ctx, plan, parameters, resultTypes, nil)
+}
+
+// ExecuteMany executes plan once for each set of parameters in batches. Every
+// execution shares one transaction so either all of them are committed or,
+// when one fails, none of them are. This is much faster than executing the
+// plan for each set of parameters since the transaction is only committed
+// once. The result rows of each execution are appended to the result in the
+// order of batches.
+func (v *vm) ExecuteMany(ctx context.Context, plan *ExecutionPlan, batches [][]any) *ExecuteResult {
+	if plan.Explain {
+		return v.explain(plan)
+	}
+	// The parameters are checked before anything is executed so a batch is
+	// never partially executed because of a bad parameter.
+	normalized := make([][]any, len(batches))
+	resultTypes := make([][]catalog.CdbType, len(batches))
+	for i, parameters := range batches {
+		var err error
+		if normalized[i], err = v.normalizeParameters(parameters); err != nil {
+			return &ExecuteResult{Err: err}
+		}
+		if resultTypes[i], err = v.resolveVarTypes(plan, normalized[i]); err != nil {
+			return &ExecuteResult{Err: err}
+		}
+		if err := v.errForUnknownType(resultTypes[i]); err != nil {
+			return &ExecuteResult{Err: err}
+		}
+	}
+	ctx, done := v.interrupts.track(ctx)
+	defer done()
+	b := &batch{}
+	result := &ExecuteResult{
+		ResultRows:    [][]Value{},
+		ResultHeader:  plan.ResultHeader,
+		ResultOrigins: plan.ResultOrigins,
+	}
+	for i := range normalized {
+		res := v.run(ctx, plan, normalized[i], resultTypes[i], b)
+		if res.Err != nil {
+			return res
+		}
+		result.ResultRows = append(result.ResultRows, res.ResultRows...)
+		result.ResultTypes = res.ResultTypes
+		result.RowsAffected += res.RowsAffected
+		if res.LastInsertRowID != 0 {
+			result.LastInsertRowID = res.LastInsertRowID
+		}
+	}
+	if err := b.end(v.kv); err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	return result
+}
+
+// run executes plan with a new routine. When b is not nil the routine uses the
+// transaction of the batch.
+func (v *vm) run(ctx context.Context, plan *ExecutionPlan, parameters []any, resultTypes []catalog.CdbType, b *batch) *ExecuteResult {
+	routine := v.newRoutine(ctx, plan, parameters, b)
+	defer routine.closeCursors()
+	if _, _, err := v.step(plan, routine, 0); err != nil {
+		return &ExecuteResult{Err: err}
+	}
+	return &ExecuteResult{
+		ResultRows:      *routine.resultRows,
+		ResultHeader:    plan.ResultHeader,
+		ResultTypes:     resultTypes,
+		ResultOrigins:   plan.ResultOrigins,
+		RowsAffected:    routine.rowsAffected,
+		LastInsertRowID: routine.lastInsertRowID,
+	}
+}
+
+// newRoutine returns a routine for executing plan. When b is not nil the
+// routine uses the transaction of the batch.
+func (v *vm) newRoutine(ctx context.Context, plan *ExecutionPlan, parameters []any, b *batch) *routine {
 	routine := &routine{
-		registers:        map[int]any{},
-		resultRows:       &[][]*string{},
-		cursors:          map[int]*kv.Cursor{},
+		ctx:              ctx,
+		registers:        map[int]Value{},
+		resultRows:       &[][]Value{},
+		cursors:          map[int]cursor{},
+		nullRows:         map[int]bool{},
+		bulkLoads:        map[int][]pager.PageTuple{},
 		parameters:       parameters,
-		readTransaction:  false,
-		writeTransaction: false,
 		schemaVersion:    plan.Version,
+		tableGenerations: plan.Tables,
+		batch:            b,
 	}
-	i := 0
-	var currentCommand Command
+	if b != nil {
+		routine.snapshot = b.snapshot
+		routine.writeTransaction = b.writeTransaction
+	}
+	return routine
+}
+
+// step executes the commands of plan with routine starting at address i until
+// the routine halts or, when the routine is streaming, makes a result row. The
+// address to continue from is returned. When err is not nil the transaction of
+// the routine has been rolled back.
+func (v *vm) step(plan *ExecutionPlan, routine *routine, i int) (next int, halted bool, err error) {
 	for i < len(plan.Commands) {
-		currentCommand = plan.Commands[i]
-		res := currentCommand.execute(v, routine)
+		if err := routine.stopErr(); err != nil {
+			v.rollback(routine)
+			return i, true, err
+		}
+		res := plan.Commands[i].execute(v, routine)
+		if res.err == nil && !res.doHalt {
+			res.err = routine.corruptionErr(v)
+		}
 		if res.err != nil {
 			v.rollback(routine)
-			return &ExecuteResult{Err: res.err}
+			return i, true, res.err
 		}
 		if res.doHalt {
-			break
+			return i, true, nil
 		}
 		if res.nextAddress == 0 {
 			i = i + 1
 		} else {
 			i = res.nextAddress
 		}
+		if res.doYield {
+			return i, false, nil
+		}
 	}
-	return &ExecuteResult{
-		ResultRows:   *routine.resultRows,
-		ResultHeader: plan.ResultHeader,
-		ResultTypes:  plan.ResultTypes,
-	}
+	return i, true, nil
 }
 
 // normalizeParameters converts parameters to a simpler type. This is because of
 // things like a int vs int64 producing different byte array values. This can
 // for example cause bugs with comparisons within the key value store.
-func (v *vm) normalizeParameters(parameters []any) []any {
+//
+// Integers are 64 bit so every integer type is stored as an int. Unsigned
+// values that do not fit in an int64 are an error rather than wrapping to a
+// negative number.
+func (v *vm) normalizeParameters(parameters []any) ([]any, error) {
 	for i := range parameters {
 		switch t := parameters[i].(type) {
+		case int8:
+			parameters[i] = int(t)
 		case int16:
 			parameters[i] = int(t)
 		case int32:
 			parameters[i] = int(t)
 		case int64:
 			parameters[i] = int(t)
+		case uint8:
+			parameters[i] = int(t)
+		case uint16:
+			parameters[i] = int(t)
+		case uint32:
+			parameters[i] = int(t)
+		case uint:
+			if uint64(t) > math.MaxInt64 {
+				return nil, fmt.Errorf("%w: parameter %d is %d", errIntegerOverflow, i, t)
+			}
+			parameters[i] = int(t)
+		case uint64:
+			if t > math.MaxInt64 {
+				return nil, fmt.Errorf("%w: parameter %d is %d", errIntegerOverflow, i, t)
+			}
+			parameters[i] = int(t)
 		}
 	}
-	return parameters
-}
-
-// resolveVarTypes takes unresolved var types in the result types and determines
-// their type from the passed in go type.
-func (v *vm) resolveVarTypes(plan *ExecutionPlan, parameters []any) error {
-	for i := range plan.ResultTypes {
-		if plan.ResultTypes[i].ID == catalog.CTVar {
-			switch parameters[plan.ResultTypes[i].VarPosition].(type) {
-			case int:
-				plan.ResultTypes[i].ID = catalog.CTInt
-			case string:
-				plan.ResultTypes[i].ID = catalog.CTStr
-			default:
-				return fmt.Errorf("unsupported var %v", parameters[i])
-			}
+	return parameters, nil
+}
+
+// resolveVarTypes returns the result types with unresolved var types determined
+// from the passed in go type. The plan is left unchanged since the same plan can
+// be executed again with parameters of different types.
+func (v *vm) resolveVarTypes(plan *ExecutionPlan, parameters []any) ([]catalog.CdbType, error) {
+	resultTypes := slices.Clone(plan.ResultTypes)
+	for i := range resultTypes {
+		if resultTypes[i].ID != catalog.CTVar {
+			continue
+		}
+		position := resultTypes[i].VarPosition
+		if position >= len(parameters) {
+			return nil, fmt.Errorf("missing parameter at position %d", position)
+		}
+		switch parameters[position].(type) {
+		case int:
+			resultTypes[i].ID = catalog.CTInt
+		case string:
+			resultTypes[i].ID = catalog.CTStr
+		default:
+			return nil, fmt.Errorf("unsupported var %v", parameters[position])
 		}
 	}
-	return nil
+	return resultTypes, nil
 }
 
 // errForUnknownType guarantees the result types will be known or the query
 // will fail before execution.
-func (v *vm) errForUnknownType(plan *ExecutionPlan) error {
-	for i := range plan.ResultTypes {
-		if plan.ResultTypes[i].ID == catalog.CTUnknown {
+func (v *vm) errForUnknownType(resultTypes []catalog.CdbType) error {
+	for i := range resultTypes {
+		if resultTypes[i].ID == catalog.CTUnknown {
 			return fmt.Errorf("unknown type at position %d", i)
 		}
 	}
 	return nil
 }
 
+// registerSpan returns registers start through start+count-1.
+func (r *routine) registerSpan(start, count int) []Value {
+	span := make([]Value, count)
+	for i := range span {
+		span[i] = r.registers[start+i]
+	}
+	return span
+}
+
+// blobRegister returns the blob in register i such as a record made by
+// MakeRecordCmd.
+func (r *routine) blobRegister(i int) ([]byte, error) {
+	if r.registers[i].Type() != BlobType {
+		return nil, fmt.Errorf("failed to convert %s to byte slice", r.registers[i])
+	}
+	return r.registers[i].Blob(), nil
+}
+
+// closeCursors releases cursors holding resources such as the temporary files
+// of a sorter.
+func (r *routine) closeCursors() {
+	for _, c := range r.cursors {
+		if closer, ok := c.(io.Closer); ok {
+			closer.Close()
+		}
+	}
+}
+
 func (v *vm) rollback(r *routine) {
 	if r.writeTransaction {
 		v.kv.RollbackWrite()
 		return
 	}
-	if r.readTransaction {
-		v.kv.EndReadTransaction()
+	if r.snapshot != nil {
+		v.kv.EndReadTransaction(r.snapshot)
 		return
 	}
 }
 
-func formatExplain(addr int, c string, P1, P2, P3 int, P4 string, P5 int, comment string) []*string {
-	aa := strconv.Itoa(addr)
-	p1a := strconv.Itoa(P1)
-	p2a := strconv.Itoa(P2)
-	p3a := strconv.Itoa(P3)
-	p5a := strconv.Itoa(P5)
-	return []*string{
-		&aa,
-		&c,
-		&p1a,
-		&p2a,
-		&p3a,
-		&P4,
-		&p5a,
-		&comment,
+func formatExplain(addr int, c string, P1, P2, P3 int, P4 string, P5 int, comment string) []Value {
+	return []Value{
+		IntValue(addr),
+		TextValue(c),
+		IntValue(P1),
+		IntValue(P2),
+		IntValue(P3),
+		TextValue(P4),
+		IntValue(P5),
+		TextValue(comment),
 	}
 }
 
 func (v *vm) explain(plan *ExecutionPlan) *ExecuteResult {
-	resultRows := [][]*string{}
+	resultRows := [][]Value{}
 	i := 0
 	var currentCommand Command
 	for i < len(plan.Commands) {
@@ -250,33 +547,24 @@ func (v *vm) explain(plan *ExecutionPlan) *ExecuteResult {
 	}
 }
 
-func anyToInt(a any) (int, error) {
-	switch t := a.(type) {
-	case int:
-		return t, nil
-	case int64:
-		return int(t), nil
-	case string:
-		s := regexp.MustCompile(`\D`).ReplaceAllString(t, "")
+// toInt returns v as an integer for arithmetic. The digits of text are used as
+// the integer.
+func toInt(v Value) (int, error) {
+	switch v.Type() {
+	case IntegerType, FloatType:
+		return v.Int(), nil
+	case TextType:
+		s := nonDigits.ReplaceAllString(v.Text(), "")
 		if s == "" {
 			return 0, nil
 		}
 		return strconv.Atoi(s)
 	}
-	return 0, fmt.Errorf("unsupported any to int for variable %#v of type %T", a, a)
+	return 0, fmt.Errorf("unsupported conversion to int for %s of type %s", v, v.Type())
 }
 
-func anyToStr(a any) string {
-	switch t := a.(type) {
-	case int:
-		return strconv.Itoa(t)
-	case int64:
-		return strconv.Itoa(int(t))
-	case string:
-		return t
-	}
-	return ""
-}
+// nonDigits matches the characters toInt removes from text.
+var nonDigits = regexp.MustCompile(`\D`)
 
 // InitCmd jumps to the instruction at address P2.
 type InitCmd cmd
@@ -287,7 +575,7 @@ func (c *InitCmd) execute(vm *vm, routine *routine) cmdRes {
 	}
 }
 
-func (c *InitCmd) explain(addr int) []*string {
+func (c *InitCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Start at addr[%d]", c.P2)
 	return formatExplain(addr, "Init", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -308,8 +596,19 @@ func (c *HaltCmd) execute(vm *vm, routine *routine) cmdRes {
 			err: errors.New(em),
 		}
 	}
-	if routine.readTransaction {
-		vm.kv.EndReadTransaction()
+	// A routine that read a corrupt page fails rather than commit or report
+	// what it read.
+	if err := routine.corruptionErr(vm); err != nil {
+		return cmdRes{err: err}
+	}
+	if routine.batch != nil {
+		// The transaction is ended by ExecuteMany once the batch is done.
+		return cmdRes{
+			doHalt: true,
+		}
+	}
+	if routine.snapshot != nil {
+		vm.kv.EndReadTransaction(routine.snapshot)
 	}
 	if routine.writeTransaction {
 		err := vm.kv.EndWriteTransaction()
@@ -323,7 +622,7 @@ func (c *HaltCmd) execute(vm *vm, routine *routine) cmdRes {
 	}
 }
 
-func (c *HaltCmd) explain(addr int) []*string {
+func (c *HaltCmd) explain(addr int) []Value {
 	comment := "End transaction and exit"
 	if c.P1 != 0 {
 		comment = "Exit with err"
@@ -331,37 +630,95 @@ func (c *HaltCmd) explain(addr int) []*string {
 	return formatExplain(addr, "Halt", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
+// newCursor returns a cursor on the b tree with rootPageNumber reading the
+// snapshot of the routine or, without one, the write transaction.
+func (r *routine) newCursor(vm *vm, rootPageNumber int) *kv.Cursor {
+	if r.snapshot != nil {
+		return r.snapshot.NewCursor(rootPageNumber)
+	}
+	return vm.kv.NewCursor(rootPageNumber)
+}
+
+// corruptionErr returns the first pager.CorruptionError found by the
+// transaction of the routine. A corrupt page reads as an empty page so the
+// routine must fail rather than report what it read.
+func (r *routine) corruptionErr(vm *vm) error {
+	if r.snapshot != nil {
+		return r.snapshot.Err()
+	}
+	if r.writeTransaction {
+		return vm.kv.WriteErr()
+	}
+	return nil
+}
+
+// isStale is true when the catalog changed in a way that invalidates the plan
+// being run by the routine.
+func (r *routine) isStale(c *catalog.Catalog) bool {
+	if r.tableGenerations == nil {
+		return r.schemaVersion != c.GetVersion()
+	}
+	for tableName, generation := range r.tableGenerations {
+		if c.GetGeneration(tableName) != generation {
+			return true
+		}
+	}
+	return false
+}
+
 // TransactionCmd starts a read transaction if P2 is 0. If P2 is 1
-// TransactionCmd starts a write transaction.
+// TransactionCmd starts a write transaction. If the lock for the transaction
+// is not acquired before the routine context is done or the busy timeout of
+// the context passes the err is pager.ErrBusy. The err is ErrInterrupted or
+// ErrTimeout when the routine was interrupted or timed out while waiting.
 type TransactionCmd cmd
 
 func (c *TransactionCmd) execute(vm *vm, routine *routine) cmdRes {
-	if c.P2 == 0 {
-		routine.readTransaction = true
-		if err := vm.kv.BeginReadTransaction(); err != nil {
-			return cmdRes{err: err}
-		}
-		if routine.schemaVersion != vm.kv.GetCatalog().GetVersion() {
-			return cmdRes{err: ErrVersionChanged}
+	if routine.batch != nil && routine.batch.began {
+		// An earlier routine of the batch began the transaction. The catalog
+		// is only checked when the transaction begins since the batch may
+		// change the schema itself.
+		if c.P2 == 1 && !routine.batch.writeTransaction {
+			return cmdRes{err: errReadOnlyBatch}
 		}
 		return cmdRes{}
 	}
-	if c.P2 == 1 {
-		routine.writeTransaction = true
-		if err := vm.kv.BeginWriteTransaction(); err != nil {
-			return cmdRes{err: err}
+	res := c.begin(vm, routine)
+	if res.err == nil && routine.batch != nil {
+		routine.batch.began = true
+		routine.batch.snapshot = routine.snapshot
+		routine.batch.writeTransaction = routine.writeTransaction
+	}
+	return res
+}
+
+func (c *TransactionCmd) begin(vm *vm, routine *routine) cmdRes {
+	if c.P2 != 0 && c.P2 != 1 {
+		return cmdRes{
+			err: fmt.Errorf("unhandled transactionCmd with P2: %d", c.P2),
 		}
-		if routine.schemaVersion != vm.kv.GetCatalog().GetVersion() {
-			return cmdRes{err: ErrVersionChanged}
+	}
+	ctx, cancel := routine.busyContext()
+	defer cancel()
+	if c.P2 == 1 || routine.batch != nil && routine.batch.write {
+		if err := vm.kv.BeginWriteTransaction(ctx); err != nil {
+			return cmdRes{err: routine.beginErr(err)}
 		}
-		return cmdRes{}
+		routine.writeTransaction = true
+	} else {
+		snapshot, err := vm.kv.BeginReadTransaction(ctx)
+		if err != nil {
+			return cmdRes{err: routine.beginErr(err)}
+		}
+		routine.snapshot = snapshot
 	}
-	return cmdRes{
-		err: fmt.Errorf("unhandled transactionCmd with P2: %d", c.P2),
+	if routine.isStale(vm.kv.GetCatalog()) {
+		return cmdRes{err: ErrVersionChanged}
 	}
+	return cmdRes{}
 }
 
-func (c *TransactionCmd) explain(addr int) []*string {
+func (c *TransactionCmd) explain(addr int) []Value {
 	comment := "Begin a read transaction"
 	if c.P2 == 1 {
 		comment = "Begin a write transaction"
@@ -373,11 +730,11 @@ func (c *TransactionCmd) explain(addr int) []*string {
 type OpenReadCmd cmd
 
 func (c *OpenReadCmd) execute(vm *vm, routine *routine) cmdRes {
-	routine.cursors[c.P1] = vm.kv.NewCursor(c.P2)
+	routine.cursors[c.P1] = routine.newCursor(vm, c.P2)
 	return cmdRes{}
 }
 
-func (c *OpenReadCmd) explain(addr int) []*string {
+func (c *OpenReadCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Open read cursor with id %d at root page %d", c.P1, c.P2)
 	return formatExplain(addr, "OpenRead", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -387,6 +744,7 @@ func (c *OpenReadCmd) explain(addr int) []*string {
 type RewindCmd cmd
 
 func (c *RewindCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
 	hasValues := routine.cursors[c.P1].GotoFirstRecord()
 	if !hasValues {
 		return cmdRes{
@@ -396,16 +754,24 @@ func (c *RewindCmd) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *RewindCmd) explain(addr int) []*string {
+func (c *RewindCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Move cursor %d to the start of the table. If the table is empty jump to addr[%d]", c.P1, c.P2)
 	return formatExplain(addr, "Rewind", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
+func (c *RewindCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
 // rowId store in register P2 an integer which is the key of the entry the
 // cursor P1 is on
 type RowIdCmd cmd
 
 func (c *RowIdCmd) execute(vm *vm, routine *routine) cmdRes {
+	if routine.nullRows[c.P1] {
+		routine.registers[c.P2] = NullValue()
+		return cmdRes{}
+	}
 	ek := routine.cursors[c.P1].GetKey()
 	dk, err := kv.DecodeKey(ek)
 	if err != nil {
@@ -413,11 +779,11 @@ func (c *RowIdCmd) execute(vm *vm, routine *routine) cmdRes {
 			err: err,
 		}
 	}
-	routine.registers[c.P2] = dk
+	routine.registers[c.P2] = mustValueOf(dk)
 	return cmdRes{}
 }
 
-func (c *RowIdCmd) explain(addr int) []*string {
+func (c *RowIdCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Store id cursor %d is currently pointing to in register[%d]", c.P1, c.P2)
 	return formatExplain(addr, "RowId", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -427,46 +793,64 @@ func (c *RowIdCmd) explain(addr int) []*string {
 type ColumnCmd cmd
 
 func (c *ColumnCmd) execute(vm *vm, routine *routine) cmdRes {
+	if routine.nullRows[c.P1] {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
 	v := routine.cursors[c.P1].GetValue()
 	cols, err := kv.Decode(v)
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = cols[c.P2]
+	// A record written before its table had as many columns has no value
+	// for the column which is the same as NULL.
+	if c.P2 >= len(cols) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	routine.registers[c.P3] = mustValueOf(cols[c.P2])
 	return cmdRes{}
 }
 
-func (c *ColumnCmd) explain(addr int) []*string {
+func (c *ColumnCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Store the value for the %d-th column in register[%d] for cursor %d", c.P2, c.P3, c.P1)
 	return formatExplain(addr, "Column", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// ResultRowCmd stores P1 through P1+P2-1 as a single row of results
+// NullRowCmd moves cursor P1 to a row where every column and the id are NULL.
+// The cursor stays on the NULL row until it is moved by RewindCmd, NextCmd or
+// SeekRowId. This is how an outer join produces the columns of a table with no
+// matching row.
+type NullRowCmd cmd
+
+func (c *NullRowCmd) execute(vm *vm, routine *routine) cmdRes {
+	routine.nullRows[c.P1] = true
+	return cmdRes{}
+}
+
+func (c *NullRowCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to a row where every column is NULL", c.P1)
+	return formatExplain(addr, "NullRow", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ResultRowCmd stores P1 through P1+P2-1 as a single row of results. A
+// streaming routine pauses after the row so it can be read with Rows.
 type ResultRowCmd cmd
 
 func (c *ResultRowCmd) execute(vm *vm, routine *routine) cmdRes {
-	row := []*string{}
+	row := make([]Value, 0, c.P2)
 	for i := c.P1; i < c.P1+c.P2; i += 1 {
-		switch v := routine.registers[i].(type) {
-		case int64:
-			vs := strconv.Itoa(int(v))
-			row = append(row, &vs)
-		case int:
-			vs := strconv.Itoa(v)
-			row = append(row, &vs)
-		case string:
-			row = append(row, &v)
-		case nil:
-			row = append(row, nil)
-		default:
-			return cmdRes{err: fmt.Errorf("unhandled result row %#v", v)}
-		}
+		row = append(row, routine.registers[i])
+	}
+	if routine.stream {
+		routine.row = row
+		return cmdRes{doYield: true}
 	}
 	*routine.resultRows = append(*routine.resultRows, row)
 	return cmdRes{}
 }
 
-func (c *ResultRowCmd) explain(addr int) []*string {
+func (c *ResultRowCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Make a row from registers[%d..%d]", c.P1, c.P1+c.P2-1)
 	return formatExplain(addr, "ResultRow", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -476,6 +860,7 @@ func (c *ResultRowCmd) explain(addr int) []*string {
 type NextCmd cmd
 
 func (c *NextCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
 	if routine.cursors[c.P1].GotoNext() {
 		return cmdRes{
 			nextAddress: c.P2,
@@ -484,11 +869,58 @@ func (c *NextCmd) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *NextCmd) explain(addr int) []*string {
+func (c *NextCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Advance cursor %d if there are items jump to addr[%d] else fall through", c.P1, c.P2)
 	return formatExplain(addr, "Next", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
+// LastCmd goes to the last entry in the table for cursor P1. If the table is
+// empty it jumps to P2. Together with PrevCmd it visits the table in descending
+// key order.
+type LastCmd cmd
+
+func (c *LastCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getReverseCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if !rc.GotoLastRecord() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *LastCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d to the end of the table. If the table is empty jump to addr[%d]", c.P1, c.P2)
+	return formatExplain(addr, "Last", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *LastCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// PrevCmd moves the cursor P1 back. If the cursor has reached the start fall
+// through. If there is more for the cursor to process jump to P2.
+type PrevCmd cmd
+
+func (c *PrevCmd) execute(vm *vm, routine *routine) cmdRes {
+	delete(routine.nullRows, c.P1)
+	rc, err := routine.getReverseCursor(c.P1)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if rc.GotoPrev() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	return cmdRes{}
+}
+
+func (c *PrevCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Move cursor %d back if there are items jump to addr[%d] else fall through", c.P1, c.P2)
+	return formatExplain(addr, "Prev", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
 // GotoCmd jumps to address P2
 type GotoCmd cmd
 
@@ -498,29 +930,39 @@ func (c *GotoCmd) execute(vm *vm, routine *routine) cmdRes {
 	}
 }
 
-func (c *GotoCmd) explain(addr int) []*string {
+func (c *GotoCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Goto to addr[%d]", c.P2)
 	return formatExplain(addr, "Goto", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
+func (c *GotoCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
 // MakeRecordCmd makes a byte array record for registers P1 through P1+P2-1 and
-// stores the record in register P3.
+// stores the record in register P3. P4 is either empty or has an Affinity for
+// each register. The affinity is applied to the registers before the record is
+// made so values such as '42' in an INTEGER column are stored as integers.
 type MakeRecordCmd cmd
 
 func (c *MakeRecordCmd) execute(vm *vm, routine *routine) cmdRes {
-	span := []any{}
-	for i := c.P1; i < c.P1+c.P2; i += 1 {
-		span = append(span, routine.registers[i])
+	if c.P4 != "" {
+		if len(c.P4) != c.P2 {
+			return cmdRes{
+				err: fmt.Errorf("affinity %s does not match %d registers", c.P4, c.P2),
+			}
+		}
+		routine.applyAffinities(c.P1, c.P4)
 	}
-	v, err := kv.Encode(span)
+	v, err := encodeValues(routine.registerSpan(c.P1, c.P2))
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = v
+	routine.registers[c.P3] = BlobValue(v)
 	return cmdRes{}
 }
 
-func (c *MakeRecordCmd) explain(addr int) []*string {
+func (c *MakeRecordCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Convert registers[%d..%d] to bytes and store in register[%d]", c.P1, c.P1+c.P2-1, c.P3)
 	return formatExplain(addr, "MakeRecord", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -530,49 +972,95 @@ type CreateBTreeCmd cmd
 
 func (c *CreateBTreeCmd) execute(vm *vm, routine *routine) cmdRes {
 	rootPageNumber := vm.kv.NewBTree()
-	routine.registers[c.P2] = rootPageNumber
+	routine.registers[c.P2] = IntValue(rootPageNumber)
 	return cmdRes{}
 }
 
-func (c *CreateBTreeCmd) explain(addr int) []*string {
+func (c *CreateBTreeCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Create new btree and store root page number in register[%d]", c.P2)
 	return formatExplain(addr, "CreateBTree", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// OpenWriteCmd opens a write cursor named P1 on table with root page P2
+// OpenWriteCmd opens a write cursor named P1 on table with root page P2. When
+// P5 is 1 the root page is the value of register P2 which allows opening a
+// b tree created by the same routine.
 type OpenWriteCmd cmd
 
 func (c *OpenWriteCmd) execute(vm *vm, routine *routine) cmdRes {
-	routine.cursors[c.P1] = vm.kv.NewCursor(c.P2)
+	rootPageNumber := c.P2
+	if c.P5 == 1 {
+		r, err := toInt(routine.registers[c.P2])
+		if err != nil {
+			return cmdRes{err: err}
+		}
+		rootPageNumber = r
+	}
+	routine.cursors[c.P1] = vm.kv.NewCursor(rootPageNumber)
 	return cmdRes{}
 }
 
-func (c *OpenWriteCmd) explain(addr int) []*string {
+func (c *OpenWriteCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Open write cursor named %d on table with root page %d", c.P1, c.P2)
+	if c.P5 == 1 {
+		comment = fmt.Sprintf("Open write cursor named %d on table with root page in register[%d]", c.P1, c.P2)
+	}
 	return formatExplain(addr, "OpenWrite", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
 // NewRowIdCmd generates a new row id for cursor P1 and writes the new id to
-// register P2
+// register P2. When P3 is not 0 the new id is also greater than the integer in
+// register P3 so row ids below it are never reused.
 type NewRowIdCmd cmd
 
 func (c *NewRowIdCmd) execute(vm *vm, routine *routine) cmdRes {
-	rid := routine.cursors[c.P1].NewRowID()
-	routine.registers[c.P2] = rid
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{
+			err: err,
+		}
+	}
+	rid, err := wc.NewRowID()
+	if err != nil {
+		return cmdRes{
+			err: err,
+		}
+	}
+	if c.P3 != 0 {
+		floor := routine.registers[c.P3].Int()
+		if floor == math.MaxInt64 {
+			return cmdRes{
+				err: fmt.Errorf("%w: no row id is greater than %d", errIntegerOverflow, floor),
+			}
+		}
+		rid = max(rid, floor+1)
+	}
+	routine.registers[c.P2] = IntValue(rid)
 	return cmdRes{}
 }
 
-func (c *NewRowIdCmd) explain(addr int) []*string {
+func (c *NewRowIdCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Generate row id for cursor %d and store in register[%d]", c.P1, c.P2)
+	if c.P3 != 0 {
+		comment = fmt.Sprintf("Generate row id for cursor %d greater than register[%d] and store in register[%d]", c.P1, c.P3, c.P2)
+	}
 	return formatExplain(addr, "NewRowID", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
 // SeekRowIdCmd moves cursor P1 to the row id in register P3. If there is no
-// record it jumps to P2.
+// record it jumps to P2. The value in P3 is converted to a row id with the
+// same affinity as comparisons so '1' finds row 1. Values that cannot be a row
+// id such as NULL or 'a' jump to P2.
 type SeekRowId cmd
 
 func (c *SeekRowId) execute(vm *vm, routine *routine) cmdRes {
-	key, err := kv.EncodeKey(routine.registers[c.P3])
+	delete(routine.nullRows, c.P1)
+	rowId, ok := rowIdAffinity(routine.registers[c.P3])
+	if !ok {
+		return cmdRes{
+			nextAddress: c.P2,
+		}
+	}
+	key, err := kv.EncodeKey(rowId)
 	if err != nil {
 		return cmdRes{
 			err: err,
@@ -587,16 +1075,27 @@ func (c *SeekRowId) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *SeekRowId) explain(addr int) []*string {
+func (c *SeekRowId) explain(addr int) []Value {
 	comment := fmt.Sprintf("Move cursor %d to row in register[%d] or jump to addr[%d]", c.P1, c.P3, c.P2)
 	return formatExplain(addr, "SeekRowID", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// InsertCmd write to cursor P1 with data in P2 and key in P3
+// CountChange is set in P5 of InsertCmd or DeleteCmd to count the row as
+// affected by the statement. An update is a delete and an insert so only the
+// insert is counted.
+const CountChange = 1
+
+// SetLastInsertRowID is set in P5 of InsertCmd to make the key of the row the
+// LastInsertRowID of the statement. It is only set for rows inserted by an
+// INSERT statement.
+const SetLastInsertRowID = 2
+
+// InsertCmd write to cursor P1 with data in P2 and key in P3. P5 is a
+// combination of the flags CountChange and SetLastInsertRowID.
 type InsertCmd cmd
 
 func (c *InsertCmd) execute(vm *vm, routine *routine) cmdRes {
-	bp3i, err := anyToInt(routine.registers[c.P3])
+	bp3i, err := toInt(routine.registers[c.P3])
 	if err != nil {
 		return cmdRes{
 			err: err,
@@ -608,17 +1107,33 @@ func (c *InsertCmd) execute(vm *vm, routine *routine) cmdRes {
 			err: err,
 		}
 	}
-	bp2, ok := routine.registers[c.P2].([]byte)
-	if !ok {
+	bp2, err := routine.blobRegister(c.P2)
+	if err != nil {
 		return cmdRes{
-			err: fmt.Errorf("failed to convert %v to byte slice", bp2),
+			err: err,
+		}
+	}
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{
+			err: err,
+		}
+	}
+	if err := wc.Set(bp3, bp2); err != nil {
+		return cmdRes{
+			err: err,
 		}
 	}
-	routine.cursors[c.P1].Set(bp3, bp2)
+	if c.P5&CountChange != 0 {
+		routine.rowsAffected += 1
+	}
+	if c.P5&SetLastInsertRowID != 0 {
+		routine.lastInsertRowID = bp3i
+	}
 	return cmdRes{}
 }
 
-func (c *InsertCmd) explain(addr int) []*string {
+func (c *InsertCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Insert cursor %d with value in register[%d] and key register[%d]", c.P1, c.P2, c.P3)
 	return formatExplain(addr, "Insert", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -627,15 +1142,26 @@ func (c *InsertCmd) explain(addr int) []*string {
 // will be left in the "next" position meaning a call to Next will safely
 // execute. However, not calling next may have consequences since the cursor has
 // advanced to either an undefined position (in the case the cursor has reached
-// the end) or the next tuple.
+// the end) or the next tuple. See CountChange for P5.
 type DeleteCmd cmd
 
 func (c *DeleteCmd) execute(vm *vm, routine *routine) cmdRes {
-	routine.cursors[c.P1].DeleteCurrent()
+	wc, err := routine.getWriteCursor(c.P1)
+	if err != nil {
+		return cmdRes{
+			err: err,
+		}
+	}
+	if err := wc.DeleteCurrent(); err != nil {
+		return cmdRes{err: err}
+	}
+	if c.P5&CountChange != 0 {
+		routine.rowsAffected += 1
+	}
 	return cmdRes{}
 }
 
-func (c *DeleteCmd) explain(addr int) []*string {
+func (c *DeleteCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Delete row cursor %d is pointing to", c.P1)
 	return formatExplain(addr, "Delete", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -650,7 +1176,7 @@ func (c *ParseSchemaCmd) execute(vm *vm, routine *routine) cmdRes {
 	}
 }
 
-func (c *ParseSchemaCmd) explain(addr int) []*string {
+func (c *ParseSchemaCmd) explain(addr int) []Value {
 	comment := "Refresh catalog"
 	return formatExplain(addr, "ParseSchema", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -659,11 +1185,11 @@ func (c *ParseSchemaCmd) explain(addr int) []*string {
 type StringCmd cmd
 
 func (c *StringCmd) execute(vm *vm, routine *routine) cmdRes {
-	routine.registers[c.P1] = c.P4
+	routine.registers[c.P1] = TextValue(c.P4)
 	return cmdRes{}
 }
 
-func (c *StringCmd) explain(addr int) []*string {
+func (c *StringCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Store string \"%s\" in register[%d]", c.P4, c.P1)
 	return formatExplain(addr, "String", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -672,88 +1198,139 @@ func (c *StringCmd) explain(addr int) []*string {
 type IntegerCmd cmd
 
 func (c *IntegerCmd) execute(vm *vm, routine *routine) cmdRes {
-	routine.registers[c.P2] = c.P1
+	routine.registers[c.P2] = IntValue(c.P1)
 	return cmdRes{}
 }
 
-func (c *IntegerCmd) explain(addr int) []*string {
+func (c *IntegerCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Store integer %d in register[%d]", c.P1, c.P2)
 	return formatExplain(addr, "Integer", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// AddCmd adds P1 to P2 and stores in register P3
+// FloatCmd stores the real number P4 into register P1. P4 is the real number
+// formatted by strconv.FormatFloat.
+type FloatCmd cmd
+
+func (c *FloatCmd) execute(vm *vm, routine *routine) cmdRes {
+	f, err := strconv.ParseFloat(c.P4, 64)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P1] = FloatValue(f)
+	return cmdRes{}
+}
+
+func (c *FloatCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store real %s in register[%d]", c.P4, c.P1)
+	return formatExplain(addr, "Float", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// AddCmd adds P1 to P2 and stores in register P3. P3 is NULL if either P1 or
+// P2 is NULL. AddCmd returns an error when the sum overflows 64 bits.
 type AddCmd cmd
 
 func (c *AddCmd) execute(vm *vm, routine *routine) cmdRes {
-	l, err := anyToInt(routine.registers[c.P1])
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	r, err := anyToInt(routine.registers[c.P2])
+	r, err := toInt(routine.registers[c.P2])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = l + r
+	v, err := Add(l, r)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P3] = IntValue(v)
 	return cmdRes{}
 }
 
-func (c *AddCmd) explain(addr int) []*string {
+func (c *AddCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Add register[%d] with register[%d] and store in register[%d]", c.P1, c.P2, c.P3)
 	return formatExplain(addr, "Add", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// SubtractCmd subtracts P2 from P1 and stores in register P3
+// SubtractCmd subtracts P2 from P1 and stores in register P3. P3 is NULL if
+// either P1 or P2 is NULL. SubtractCmd returns an error when the difference
+// overflows 64 bits.
 type SubtractCmd cmd
 
 func (c *SubtractCmd) execute(vm *vm, routine *routine) cmdRes {
-	l, err := anyToInt(routine.registers[c.P1])
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	r, err := anyToInt(routine.registers[c.P2])
+	r, err := toInt(routine.registers[c.P2])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = l - r
+	v, err := Subtract(l, r)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P3] = IntValue(v)
 	return cmdRes{}
 }
 
-func (c *SubtractCmd) explain(addr int) []*string {
+func (c *SubtractCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Subtract register[%d] from register[%d] and store in register[%d]", c.P2, c.P1, c.P3)
 	return formatExplain(addr, "Subtract", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// MultiplyCmd multiplies P1 and P2 and stores in register P3
+// MultiplyCmd multiplies P1 and P2 and stores in register P3. P3 is NULL if
+// either P1 or P2 is NULL. MultiplyCmd returns an error when the product
+// overflows 64 bits.
 type MultiplyCmd cmd
 
 func (c *MultiplyCmd) execute(vm *vm, routine *routine) cmdRes {
-	l, err := anyToInt(routine.registers[c.P1])
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	r, err := anyToInt(routine.registers[c.P2])
+	r, err := toInt(routine.registers[c.P2])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = l * r
+	v, err := Multiply(l, r)
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P3] = IntValue(v)
 	return cmdRes{}
 }
 
-func (c *MultiplyCmd) explain(addr int) []*string {
+func (c *MultiplyCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Multiply register[%d] and register[%d] and store in register[%d]", c.P1, c.P2, c.P3)
 	return formatExplain(addr, "Multiply", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
 // DivideCmd divides P1 by P2 and stores in register P3. If P2 is 0 DivideCmd
-// will return an exception.
+// will return an exception. P3 is NULL if either P1 or P2 is NULL.
 type DivideCmd cmd
 
 func (c *DivideCmd) execute(vm *vm, routine *routine) cmdRes {
-	l, err := anyToInt(routine.registers[c.P1])
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	r, err := anyToInt(routine.registers[c.P2])
+	r, err := toInt(routine.registers[c.P2])
 	if err != nil {
 		return cmdRes{err: err}
 	}
@@ -762,36 +1339,264 @@ func (c *DivideCmd) execute(vm *vm, routine *routine) cmdRes {
 			err: errors.New("cannot divide by 0"),
 		}
 	}
-	routine.registers[c.P3] = l / r
+	routine.registers[c.P3] = IntValue(l / r)
 	return cmdRes{}
 }
 
-func (c *DivideCmd) explain(addr int) []*string {
+func (c *DivideCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Divide register[%d] by register[%d] and store in register[%d]", c.P1, c.P2, c.P3)
 	return formatExplain(addr, "Divide", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// ExponentCmd takes P1 to the P2 power and stores in register P3
+// ExponentCmd takes P1 to the P2 power and stores in register P3. P3 is NULL
+// if either P1 or P2 is NULL.
 type ExponentCmd cmd
 
 func (c *ExponentCmd) execute(vm *vm, routine *routine) cmdRes {
-	l, err := anyToInt(routine.registers[c.P1])
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	r, err := anyToInt(routine.registers[c.P2])
+	r, err := toInt(routine.registers[c.P2])
 	if err != nil {
 		return cmdRes{err: err}
 	}
-	routine.registers[c.P3] = int(math.Pow(float64(l), float64(r)))
+	routine.registers[c.P3] = IntValue(int(math.Pow(float64(l), float64(r))))
 	return cmdRes{}
 }
 
-func (c *ExponentCmd) explain(addr int) []*string {
+func (c *ExponentCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Register[%d] to the register[%d] power and store in register[%d]", c.P1, c.P2, c.P3)
 	return formatExplain(addr, "Exponent", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
+// RemainderCmd stores the remainder of dividing P1 by P2 in register P3. P3 is
+// NULL if either P1 or P2 is NULL.
+type RemainderCmd cmd
+
+func (c *RemainderCmd) execute(vm *vm, routine *routine) cmdRes {
+	if anyNull(routine.registers[c.P1], routine.registers[c.P2]) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	l, err := toInt(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	r, err := toInt(routine.registers[c.P2])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	if r == 0 {
+		return cmdRes{
+			err: errors.New("cannot divide by 0"),
+		}
+	}
+	routine.registers[c.P3] = IntValue(l % r)
+	return cmdRes{}
+}
+
+func (c *RemainderCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Remainder of register[%d] divided by register[%d] stored in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "Remainder", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ConcatCmd stores the text of P1 followed by the text of P2 in register P3.
+// Numbers are converted to text the same as CAST(x AS TEXT). P3 is NULL if
+// either P1 or P2 is NULL.
+type ConcatCmd cmd
+
+func (c *ConcatCmd) execute(vm *vm, routine *routine) cmdRes {
+	l, r := routine.registers[c.P1], routine.registers[c.P2]
+	if anyNull(l, r) {
+		routine.registers[c.P3] = NullValue()
+		return cmdRes{}
+	}
+	routine.registers[c.P3] = TextValue(l.Text() + r.Text())
+	return cmdRes{}
+}
+
+func (c *ConcatCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Concatenate register[%d] and register[%d] into register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "Concat", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// BitAndCmd stores the bitwise AND of P1 and P2 in register P3. P3 is NULL if
+// either P1 or P2 is NULL.
+type BitAndCmd cmd
+
+func (c *BitAndCmd) execute(vm *vm, routine *routine) cmdRes {
+	return bitwise(routine, c.P1, c.P2, c.P3, func(l, r int) int { return l & r })
+}
+
+func (c *BitAndCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Bitwise AND register[%d] with register[%d] and store in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "BitAnd", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// BitOrCmd stores the bitwise OR of P1 and P2 in register P3. P3 is NULL if
+// either P1 or P2 is NULL.
+type BitOrCmd cmd
+
+func (c *BitOrCmd) execute(vm *vm, routine *routine) cmdRes {
+	return bitwise(routine, c.P1, c.P2, c.P3, func(l, r int) int { return l | r })
+}
+
+func (c *BitOrCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Bitwise OR register[%d] with register[%d] and store in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "BitOr", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ShiftLeftCmd stores P1 shifted left by P2 bits in register P3. A negative
+// P2 shifts right instead. P3 is NULL if either P1 or P2 is NULL.
+type ShiftLeftCmd cmd
+
+func (c *ShiftLeftCmd) execute(vm *vm, routine *routine) cmdRes {
+	return bitwise(routine, c.P1, c.P2, c.P3, ShiftLeft)
+}
+
+func (c *ShiftLeftCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Shift register[%d] left by register[%d] bits and store in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "ShiftLeft", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ShiftRightCmd stores P1 shifted right by P2 bits in register P3. The sign
+// bit is kept and a negative P2 shifts left instead. P3 is NULL if either P1
+// or P2 is NULL.
+type ShiftRightCmd cmd
+
+func (c *ShiftRightCmd) execute(vm *vm, routine *routine) cmdRes {
+	return bitwise(routine, c.P1, c.P2, c.P3, ShiftRight)
+}
+
+func (c *ShiftRightCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Shift register[%d] right by register[%d] bits and store in register[%d]", c.P1, c.P2, c.P3)
+	return formatExplain(addr, "ShiftRight", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// ShiftLeft returns v shifted left by n bits or shifted right by -n bits when n
+// is negative. Shifting by 64 or more bits leaves only the sign.
+func ShiftLeft(v, n int) int {
+	switch {
+	case n >= 64:
+		return 0
+	case n >= 0:
+		return v << n
+	case n <= -64:
+		if v < 0 {
+			return -1
+		}
+		return 0
+	}
+	return v >> -n
+}
+
+// ShiftRight returns v shifted right by n bits keeping the sign or shifted left
+// by -n bits when n is negative.
+func ShiftRight(v, n int) int {
+	if n == math.MinInt {
+		// -n overflows, but any shift of 64 or more bits is the same.
+		n = -64
+	}
+	return ShiftLeft(v, -n)
+}
+
+// Add returns l + r or errIntegerOverflow when the sum does not fit in 64 bits.
+func Add(l, r int) (int, error) {
+	if r > 0 && l > math.MaxInt-r || r < 0 && l < math.MinInt-r {
+		return 0, fmt.Errorf("%w: %d + %d", errIntegerOverflow, l, r)
+	}
+	return l + r, nil
+}
+
+// Subtract returns l - r or errIntegerOverflow when the difference does not fit
+// in 64 bits.
+func Subtract(l, r int) (int, error) {
+	if r < 0 && l > math.MaxInt+r || r > 0 && l < math.MinInt+r {
+		return 0, fmt.Errorf("%w: %d - %d", errIntegerOverflow, l, r)
+	}
+	return l - r, nil
+}
+
+// Multiply returns l * r or errIntegerOverflow when the product does not fit in
+// 64 bits.
+func Multiply(l, r int) (int, error) {
+	if l == 0 || r == 0 {
+		return 0, nil
+	}
+	p := l * r
+	if p/r != l || l == -1 && r == math.MinInt || r == -1 && l == math.MinInt {
+		return 0, fmt.Errorf("%w: %d * %d", errIntegerOverflow, l, r)
+	}
+	return p, nil
+}
+
+// bitwise stores op applied to the integers of register ol and register or in
+// register r. r is NULL if either operand is NULL.
+func bitwise(routine *routine, ol, or, r int, op func(l, r int) int) cmdRes {
+	if anyNull(routine.registers[ol], routine.registers[or]) {
+		routine.registers[r] = NullValue()
+		return cmdRes{}
+	}
+	lv, err := toInt(routine.registers[ol])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	rv, err := toInt(routine.registers[or])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[r] = IntValue(op(lv, rv))
+	return cmdRes{}
+}
+
+// BitNotCmd stores the bitwise complement of P1 in register P2. P2 is NULL if
+// P1 is NULL.
+type BitNotCmd cmd
+
+func (c *BitNotCmd) execute(vm *vm, routine *routine) cmdRes {
+	if routine.registers[c.P1].IsNull() {
+		routine.registers[c.P2] = NullValue()
+		return cmdRes{}
+	}
+	v, err := toInt(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P2] = IntValue(^v)
+	return cmdRes{}
+}
+
+func (c *BitNotCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store the bitwise complement of register[%d] in register[%d]", c.P1, c.P2)
+	return formatExplain(addr, "BitNot", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+// NegCmd stores the negation of P1 in register P2. P2 is NULL if P1 is NULL.
+type NegCmd cmd
+
+func (c *NegCmd) execute(vm *vm, routine *routine) cmdRes {
+	if routine.registers[c.P1].IsNull() {
+		routine.registers[c.P2] = NullValue()
+		return cmdRes{}
+	}
+	v, err := toInt(routine.registers[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P2] = IntValue(-v)
+	return cmdRes{}
+}
+
+func (c *NegCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Store the negation of register[%d] in register[%d]", c.P1, c.P2)
+	return formatExplain(addr, "Neg", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
 // CopyCmd copies P1 into P2
 type CopyCmd cmd
 
@@ -800,7 +1605,7 @@ func (c *CopyCmd) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *CopyCmd) explain(addr int) []*string {
+func (c *CopyCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Copy register[%d] into register[%d]", c.P1, c.P2)
 	return formatExplain(addr, "Copy", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -811,31 +1616,30 @@ type CountCmd cmd
 func (c *CountCmd) execute(vm *vm, routine *routine) cmdRes {
 	cr := routine.cursors[c.P1]
 	co := cr.Count()
-	routine.registers[c.P2] = co
+	routine.registers[c.P2] = IntValue(co)
 	return cmdRes{}
 }
 
-func (c *CountCmd) explain(addr int) []*string {
+func (c *CountCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Count entries for cursor %d and store in register[%d]", c.P1, c.P2)
 	return formatExplain(addr, "Count", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
-// If the value in register P1 is not an integer raise an exception.
+// MustBeIntCmd converts the value in register P1 to an integer when it can be
+// without losing information such as '42' or 3.0. If the value is still not an
+// integer raise an exception.
 type MustBeIntCmd cmd
 
 func (c *MustBeIntCmd) execute(vm *vm, routine *routine) cmdRes {
-	v := routine.registers[c.P1]
-	switch vi := v.(type) {
-	case int:
-		return cmdRes{}
-	case int64:
-		return cmdRes{}
-	default:
-		return cmdRes{err: fmt.Errorf("value %v must be int", vi)}
+	v := applyAffinity(routine.registers[c.P1], IntegerAffinity)
+	routine.registers[c.P1] = v
+	if v.Type() != IntegerType {
+		return cmdRes{err: fmt.Errorf("value %s must be int", v)}
 	}
+	return cmdRes{}
 }
 
-func (c *MustBeIntCmd) explain(addr int) []*string {
+func (c *MustBeIntCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Throw error if register[%d] is not an integer", c.P1)
 	return formatExplain(addr, "MustBeInt", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -845,23 +1649,13 @@ func (c *MustBeIntCmd) explain(addr int) []*string {
 type NotExistsCmd cmd
 
 func (c *NotExistsCmd) execute(vm *vm, routine *routine) cmdRes {
-	var ek []byte
 	v := routine.registers[c.P3]
-	switch vi := v.(type) {
-	case int:
-		ekb, err := kv.EncodeKey(routine.registers[c.P3])
-		if err != nil {
-			return cmdRes{err: err}
-		}
-		ek = ekb
-	case int64:
-		ekb, err := kv.EncodeKey(routine.registers[c.P3])
-		if err != nil {
-			return cmdRes{err: err}
-		}
-		ek = ekb
-	default:
-		return cmdRes{err: fmt.Errorf("%v must be int", vi)}
+	if v.Type() != IntegerType {
+		return cmdRes{err: fmt.Errorf("%s must be int", v)}
+	}
+	ek, err := kv.EncodeKey(v.Int())
+	if err != nil {
+		return cmdRes{err: err}
 	}
 	exists := routine.cursors[c.P1].Exists(ek)
 	if !exists {
@@ -870,25 +1664,20 @@ func (c *NotExistsCmd) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *NotExistsCmd) explain(addr int) []*string {
+func (c *NotExistsCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Jump to register[%d] if cursor %d does not contain key in register[%d]", c.P2, c.P1, c.P3)
 	return formatExplain(addr, "NotExists", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
 
 // NotEqualCmd jumps to register P2 if register P1 and P3 are not equal.
-// Otherwise fall through.
+// Otherwise fall through. See JumpIfNull for how NULL is compared.
 type NotEqualCmd cmd
 
 func (c *NotEqualCmd) execute(vm *vm, routine *routine) cmdRes {
-	v1 := anyToStr(routine.registers[c.P1])
-	v2 := anyToStr(routine.registers[c.P3])
-	if v1 != v2 {
-		return cmdRes{nextAddress: c.P2}
-	}
-	return cmdRes{}
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c != 0 })
 }
 
-func (c *NotEqualCmd) explain(addr int) []*string {
+func (c *NotEqualCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Jump to address %d if register[%d] does not equal register[%d]", c.P2, c.P1, c.P3)
 	return formatExplain(addr, "NotEqual", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -897,11 +1686,14 @@ func (c *NotEqualCmd) SetJumpAddress(address int) {
 	c.P2 = address
 }
 
-// IfNotCmd jumps to P2 if P1 is false otherwise fall through.
+// IfNotCmd jumps to P2 if P1 is false or NULL otherwise fall through.
 type IfNotCmd cmd
 
 func (c *IfNotCmd) execute(vm *vm, routine *routine) cmdRes {
-	v, err := anyToInt(routine.registers[c.P1])
+	if routine.registers[c.P1].IsNull() {
+		return cmdRes{nextAddress: c.P2}
+	}
+	v, err := toInt(routine.registers[c.P1])
 	if err != nil {
 		return cmdRes{err: err}
 	}
@@ -911,7 +1703,7 @@ func (c *IfNotCmd) execute(vm *vm, routine *routine) cmdRes {
 	return cmdRes{}
 }
 
-func (c *IfNotCmd) explain(addr int) []*string {
+func (c *IfNotCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Jump to address %d if register[%d] is false", c.P2, c.P1)
 	return formatExplain(addr, "IfNot", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -920,41 +1712,40 @@ func (c *IfNotCmd) SetJumpAddress(address int) {
 	c.P2 = address
 }
 
-// GteCmd if P1 is greater than or equal to P3 jump to P2
-type GteCmd cmd
+// DecrJumpZeroCmd subtracts 1 from the integer in register P1 and jumps to P2
+// if the result is 0. Otherwise fall through.
+type DecrJumpZeroCmd cmd
 
-func (c *GteCmd) execute(vm *vm, routine *routine) cmdRes {
-	l := routine.registers[c.P1]
-	r := routine.registers[c.P3]
-	tl, okl := l.(string)
-	tr, okr := r.(string)
-	if okl || okr {
-		if !okl {
-			tl = anyToStr(tl)
-		}
-		if !okr {
-			tr = anyToStr(tr)
-		}
-		if tl >= tr {
-			return cmdRes{nextAddress: c.P2}
-		}
-		return cmdRes{}
-	}
-	vl, err := anyToInt(l)
-	if err != nil {
-		return cmdRes{err: err}
-	}
-	vr, err := anyToInt(r)
-	if err != nil {
-		return cmdRes{err: err}
+func (c *DecrJumpZeroCmd) execute(vm *vm, routine *routine) cmdRes {
+	v := routine.registers[c.P1]
+	if v.Type() != IntegerType {
+		return cmdRes{err: fmt.Errorf("%s must be int", v)}
 	}
-	if vl >= vr {
+	routine.registers[c.P1] = IntValue(v.Int() - 1)
+	if v.Int()-1 == 0 {
 		return cmdRes{nextAddress: c.P2}
 	}
 	return cmdRes{}
 }
 
-func (c *GteCmd) explain(addr int) []*string {
+func (c *DecrJumpZeroCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Decrement register[%d] and jump to address %d if it is 0", c.P1, c.P2)
+	return formatExplain(addr, "DecrJumpZero", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *DecrJumpZeroCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// GteCmd if P1 is greater than or equal to P3 jump to P2. See JumpIfNull for
+// how NULL is compared.
+type GteCmd cmd
+
+func (c *GteCmd) execute(vm *vm, routine *routine) cmdRes {
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c >= 0 })
+}
+
+func (c *GteCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Jump to address %d if register[%d] <= register[%d]", c.P2, c.P1, c.P3)
 	return formatExplain(addr, "Gte", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -963,41 +1754,15 @@ func (c *GteCmd) SetJumpAddress(address int) {
 	c.P2 = address
 }
 
-// LteCmd if P1 is less than or equal to P3 jump to P2
+// LteCmd if P1 is less than or equal to P3 jump to P2. See JumpIfNull for how
+// NULL is compared.
 type LteCmd cmd
 
 func (c *LteCmd) execute(vm *vm, routine *routine) cmdRes {
-	l := routine.registers[c.P1]
-	r := routine.registers[c.P3]
-	tl, okl := l.(string)
-	tr, okr := r.(string)
-	if okl || okr {
-		if !okl {
-			tl = anyToStr(tl)
-		}
-		if !okr {
-			tr = anyToStr(tr)
-		}
-		if tl <= tr {
-			return cmdRes{nextAddress: c.P2}
-		}
-		return cmdRes{}
-	}
-	vl, err := anyToInt(l)
-	if err != nil {
-		return cmdRes{err: err}
-	}
-	vr, err := anyToInt(r)
-	if err != nil {
-		return cmdRes{err: err}
-	}
-	if vl <= vr {
-		return cmdRes{nextAddress: c.P2}
-	}
-	return cmdRes{}
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c <= 0 })
 }
 
-func (c *LteCmd) explain(addr int) []*string {
+func (c *LteCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Jump to address %d if register[%d] >= register[%d]", c.P2, c.P1, c.P3)
 	return formatExplain(addr, "Lte", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -1006,6 +1771,57 @@ func (c *LteCmd) SetJumpAddress(address int) {
 	c.P2 = address
 }
 
+// EqCmd jumps to register P2 if register P1 and P3 are equal. Otherwise fall
+// through. See JumpIfNull for how NULL is compared.
+type EqCmd cmd
+
+func (c *EqCmd) execute(vm *vm, routine *routine) cmdRes {
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c == 0 })
+}
+
+func (c *EqCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to address %d if register[%d] equals register[%d]", c.P2, c.P1, c.P3)
+	return formatExplain(addr, "Eq", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *EqCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// GtCmd if P1 is greater than P3 jump to P2. See JumpIfNull for how NULL is
+// compared.
+type GtCmd cmd
+
+func (c *GtCmd) execute(vm *vm, routine *routine) cmdRes {
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c > 0 })
+}
+
+func (c *GtCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to address %d if register[%d] > register[%d]", c.P2, c.P1, c.P3)
+	return formatExplain(addr, "Gt", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *GtCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
+// LtCmd if P1 is less than P3 jump to P2. See JumpIfNull for how NULL is
+// compared.
+type LtCmd cmd
+
+func (c *LtCmd) execute(vm *vm, routine *routine) cmdRes {
+	return compareJump((*cmd)(c), routine, func(c int) bool { return c < 0 })
+}
+
+func (c *LtCmd) explain(addr int) []Value {
+	comment := fmt.Sprintf("Jump to address %d if register[%d] < register[%d]", c.P2, c.P1, c.P3)
+	return formatExplain(addr, "Lt", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
+}
+
+func (c *LtCmd) SetJumpAddress(address int) {
+	c.P2 = address
+}
+
 // VariableCmd substitutes variable number P1 into register P2. Where P1 is a
 // zero based index.
 type VariableCmd cmd
@@ -1015,11 +1831,15 @@ func (c *VariableCmd) execute(vm *vm, routine *routine) cmdRes {
 	if len(routine.parameters)-1 < paramIdx {
 		return cmdRes{err: fmt.Errorf("no variable at index %d", paramIdx)}
 	}
-	routine.registers[c.P2] = routine.parameters[c.P1]
+	v, err := ValueOf(routine.parameters[c.P1])
+	if err != nil {
+		return cmdRes{err: err}
+	}
+	routine.registers[c.P2] = v
 	return cmdRes{}
 }
 
-func (c *VariableCmd) explain(addr int) []*string {
+func (c *VariableCmd) explain(addr int) []Value {
 	comment := fmt.Sprintf("Substitute variable %d into register[%d]", c.P1, c.P2)
 	return formatExplain(addr, "Variable", c.P1, c.P2, c.P3, c.P4, c.P5, comment)
 }
@@ -3,6 +3,8 @@ package vm
 import (
 	"errors"
 	"log"
+	"math"
+	"reflect"
 	"testing"
 
 	"github.com/chirst/cdb/kv"
@@ -32,6 +34,114 @@ func TestExec(t *testing.T) {
 	}
 }
 
+func TestOpenVirtual(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	vm := New(kv)
+	rows := [][]any{{"a"}, {"b"}, {"c"}}
+
+	t.Run("read", func(t *testing.T) {
+		ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+			&RewindCmd{P1: 1, P2: 7},
+			&RowIdCmd{P1: 1, P2: 1},
+			&ColumnCmd{P1: 1, P2: 0, P3: 2},
+			&ResultRowCmd{P1: 1, P2: 2},
+			&NextCmd{P1: 1, P2: 3},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if res.Err != nil {
+			t.Fatalf("expected no err got %s", res.Err)
+		}
+		if len(res.ResultRows) != len(rows) {
+			t.Fatalf("expected %d rows got %d", len(rows), len(res.ResultRows))
+		}
+		if got := res.ResultRows[2][0].Text() + res.ResultRows[2][1].Text(); got != "2c" {
+			t.Fatalf("expected 2c got %s", got)
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+		ep.Commands = []Command{
+			&InitCmd{P2: 1},
+			&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+			&RewindCmd{P1: 1, P2: 4},
+			&DeleteCmd{P1: 1},
+			&HaltCmd{},
+		}
+		res := vm.Execute(ep, []any{})
+		if !errors.Is(res.Err, errReadOnlyCursor) {
+			t.Fatalf("expected %s got %v", errReadOnlyCursor, res.Err)
+		}
+	})
+}
+
+func TestNullRow(t *testing.T) {
+	kv, err := kv.New(true, "")
+	if err != nil {
+		log.Fatal(err)
+	}
+	vm := New(kv)
+	rows := [][]any{{"a"}}
+	ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+	ep.Commands = []Command{
+		&InitCmd{P2: 1},
+		&OpenVirtualCmd{P1: 1, P4: "v", Rows: rows},
+		&NullRowCmd{P1: 1},
+		&RowIdCmd{P1: 1, P2: 1},
+		&ColumnCmd{P1: 1, P2: 0, P3: 2},
+		&ResultRowCmd{P1: 1, P2: 2},
+		&RewindCmd{P1: 1, P2: 10},
+		&RowIdCmd{P1: 1, P2: 1},
+		&ColumnCmd{P1: 1, P2: 0, P3: 2},
+		&ResultRowCmd{P1: 1, P2: 2},
+		&HaltCmd{},
+	}
+	res := vm.Execute(ep, []any{})
+	if res.Err != nil {
+		t.Fatalf("expected no err got %s", res.Err)
+	}
+	if len(res.ResultRows) != 2 {
+		t.Fatalf("expected 2 rows got %d", len(res.ResultRows))
+	}
+	if !res.ResultRows[0][0].IsNull() || !res.ResultRows[0][1].IsNull() {
+		t.Fatalf("expected NULL row got %v", res.ResultRows[0])
+	}
+	if got := res.ResultRows[1][0].Text() + res.ResultRows[1][1].Text(); got != "0a" {
+		t.Fatalf("expected rewind to leave the NULL row and get 0a got %s", got)
+	}
+}
+
+func TestNormalizeParameters(t *testing.T) {
+	vm := &vm{}
+	t.Run("converts to int", func(t *testing.T) {
+		params, err := vm.normalizeParameters([]any{
+			int8(1), int16(2), int32(3), int64(math.MaxInt64), uint8(5), uint16(6),
+			uint32(7), uint(8), uint64(9), "ten",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []any{1, 2, 3, math.MaxInt64, 5, 6, 7, 8, 9, "ten"}
+		if !reflect.DeepEqual(params, want) {
+			t.Fatalf("want %v got %v", want, params)
+		}
+	})
+
+	t.Run("overflow", func(t *testing.T) {
+		_, err := vm.normalizeParameters([]any{uint64(math.MaxInt64) + 1})
+		if !errors.Is(err, errIntegerOverflow) {
+			t.Fatalf("want %s got %v", errIntegerOverflow, err)
+		}
+	})
+}
+
 func TestExecReturnsVersionErr(t *testing.T) {
 	kv, err := kv.New(true, "")
 	if err != nil {
@@ -131,7 +241,7 @@ func TestAddAffinity(t *testing.T) {
 			if res.Err != nil {
 				t.Fatalf("expected no err got %s", res.Err)
 			}
-			if got := *res.ResultRows[0][0]; got != c.expect {
+			if got := res.ResultRows[0][0].Text(); got != c.expect {
 				t.Fatalf("expected %s got %s", c.expect, got)
 			}
 		})
@@ -195,7 +305,7 @@ func TestNeAffinity(t *testing.T) {
 			if res.Err != nil {
 				t.Fatalf("expected no err got %s", res.Err)
 			}
-			if got := *res.ResultRows[0][0]; got != c.expect {
+			if got := res.ResultRows[0][0].Text(); got != c.expect {
 				t.Fatalf("expected %s got %s", c.expect, got)
 			}
 		})
@@ -246,6 +356,18 @@ func TestGteAffinity(t *testing.T) {
 			rightRegister: &StringCmd{P1: 2, P4: "a"},
 			expect:        "1",
 		},
+		{
+			description:   "10 >= '9'",
+			leftRegister:  &IntegerCmd{P1: 10, P2: 1},
+			rightRegister: &StringCmd{P1: 2, P4: "9"},
+			expect:        "1",
+		},
+		{
+			description:   "'10' >= '9'",
+			leftRegister:  &StringCmd{P1: 1, P4: "10"},
+			rightRegister: &StringCmd{P1: 2, P4: "9"},
+			expect:        "0",
+		},
 	}
 	for _, c := range cases {
 		t.Run(c.description, func(t *testing.T) {
@@ -271,9 +393,155 @@ func TestGteAffinity(t *testing.T) {
 			if res.Err != nil {
 				t.Fatalf("expected no err got %s", res.Err)
 			}
-			if got := *res.ResultRows[0][0]; got != c.expect {
+			if got := res.ResultRows[0][0].Text(); got != c.expect {
 				t.Fatalf("expected %s got %s", c.expect, got)
 			}
 		})
 	}
 }
+
+func TestCompareValues(t *testing.T) {
+	cases := []struct {
+		a, b any
+		want int
+	}{
+		{a: nil, b: nil, want: 0},
+		{a: nil, b: -1, want: -1},
+		{a: 1, b: int64(1), want: 0},
+		{a: 2, b: 10, want: -1},
+		{a: 10, b: "1", want: -1},
+		{a: "b", b: "a", want: 1},
+		{a: "z", b: []byte("a"), want: -1},
+		{a: []byte("a"), b: []byte("b"), want: -1},
+		{a: 1, b: 1.5, want: -1},
+		{a: 2.0, b: 2, want: 0},
+		{a: 1.5, b: "1", want: -1},
+	}
+	for _, c := range cases {
+		a, b := mustValueOf(c.a), mustValueOf(c.b)
+		if got := compareValues(a, b); got != c.want {
+			t.Errorf("compare %#v %#v want %d got %d", c.a, c.b, c.want, got)
+		}
+		if got := compareValues(b, a); got != -c.want {
+			t.Errorf("compare %#v %#v want %d got %d", c.b, c.a, -c.want, got)
+		}
+	}
+}
+
+func TestRowIdAffinity(t *testing.T) {
+	cases := []struct {
+		v      any
+		want   int
+		wantOk bool
+	}{
+		{v: 1, want: 1, wantOk: true},
+		{v: int64(2), want: 2, wantOk: true},
+		{v: "03", want: 3, wantOk: true},
+		{v: "-4", want: -4, wantOk: true},
+		{v: "a", wantOk: false},
+		{v: "1a", wantOk: false},
+		{v: nil, wantOk: false},
+		{v: []byte("1"), wantOk: false},
+	}
+	for _, c := range cases {
+		got, ok := rowIdAffinity(mustValueOf(c.v))
+		if ok != c.wantOk || (ok && got != c.want) {
+			t.Errorf("row id of %#v want %d %t got %d %t", c.v, c.want, c.wantOk, got, ok)
+		}
+	}
+}
+
+func TestArithmeticOverflow(t *testing.T) {
+	cases := []struct {
+		description string
+		op          func(l, r int) (int, error)
+		cmd         Command
+		l           int
+		r           int
+		want        int
+		overflow    bool
+	}{
+		{description: "max + 0", op: Add, cmd: &AddCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: 0, want: math.MaxInt},
+		{description: "max + 1", op: Add, cmd: &AddCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: 1, overflow: true},
+		{description: "min + -1", op: Add, cmd: &AddCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: -1, overflow: true},
+		{description: "min + max", op: Add, cmd: &AddCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: math.MaxInt, want: -1},
+		{description: "min - 0", op: Subtract, cmd: &SubtractCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: 0, want: math.MinInt},
+		{description: "min - 1", op: Subtract, cmd: &SubtractCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: 1, overflow: true},
+		{description: "max - -1", op: Subtract, cmd: &SubtractCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: -1, overflow: true},
+		{description: "0 - min", op: Subtract, cmd: &SubtractCmd{P1: 1, P2: 2, P3: 3}, l: 0, r: math.MinInt, overflow: true},
+		{description: "-1 - min", op: Subtract, cmd: &SubtractCmd{P1: 1, P2: 2, P3: 3}, l: -1, r: math.MinInt, want: math.MaxInt},
+		{description: "max * 1", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: 1, want: math.MaxInt},
+		{description: "max * 2", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: 2, overflow: true},
+		{description: "max * -1", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: math.MaxInt, r: -1, want: -math.MaxInt},
+		{description: "min * -1", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: -1, overflow: true},
+		{description: "-1 * min", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: -1, r: math.MinInt, overflow: true},
+		{description: "min * 0", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: math.MinInt, r: 0, want: 0},
+		{description: "2^32 * 2^31", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: 1 << 32, r: 1 << 31, overflow: true},
+		{description: "-2^32 * 2^31", op: Multiply, cmd: &MultiplyCmd{P1: 1, P2: 2, P3: 3}, l: -1 << 32, r: 1 << 31, want: math.MinInt},
+	}
+	for _, c := range cases {
+		t.Run(c.description, func(t *testing.T) {
+			got, err := c.op(c.l, c.r)
+			if c.overflow {
+				if !errors.Is(err, errIntegerOverflow) {
+					t.Fatalf("want %s got %v", errIntegerOverflow, err)
+				}
+			} else if err != nil || got != c.want {
+				t.Fatalf("want %d got %d err %v", c.want, got, err)
+			}
+
+			kv, err := kv.New(true, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			vm := New(kv)
+			ep := NewExecutionPlan(kv.GetCatalog().GetVersion(), false)
+			ep.Commands = []Command{
+				&InitCmd{P2: 1},
+				&IntegerCmd{P1: c.l, P2: 1},
+				&IntegerCmd{P1: c.r, P2: 2},
+				c.cmd,
+				&ResultRowCmd{P1: 3, P2: 1},
+				&HaltCmd{},
+			}
+			res := vm.Execute(ep, []any{})
+			if c.overflow {
+				if !errors.Is(res.Err, errIntegerOverflow) {
+					t.Fatalf("want %s got %v", errIntegerOverflow, res.Err)
+				}
+				return
+			}
+			if res.Err != nil {
+				t.Fatalf("expected no err got %s", res.Err)
+			}
+			if got := res.ResultRows[0][0].Int(); got != c.want {
+				t.Fatalf("want %d got %d", c.want, got)
+			}
+		})
+	}
+}
+
+func TestShift(t *testing.T) {
+	cases := []struct {
+		v     int
+		n     int
+		left  int
+		right int
+	}{
+		{v: 3, n: 2, left: 12, right: 0},
+		{v: 12, n: -2, left: 3, right: 48},
+		{v: -8, n: 1, left: -16, right: -4},
+		{v: 1, n: 64, left: 0, right: 0},
+		{v: -1, n: 64, left: 0, right: -1},
+		{v: -1, n: math.MinInt, left: -1, right: 0},
+		{v: 5, n: math.MaxInt, left: 0, right: 0},
+	}
+	for _, c := range cases {
+		if got := ShiftLeft(c.v, c.n); got != c.left {
+			t.Errorf("expected %d << %d to be %d got %d", c.v, c.n, c.left, got)
+		}
+		if got := ShiftRight(c.v, c.n); got != c.right {
+			t.Errorf("expected %d >> %d to be %d got %d", c.v, c.n, c.right, got)
+		}
+	}
+}